@@ -0,0 +1,327 @@
+// Package apierrors defines the catalog of API error codes and writes error
+// responses as RFC 7807 problem details, or as JSON:API error documents for
+// clients that ask for them.
+package apierrors
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// Code is a machine-readable error code. Codes are part of the API contract,
+// so existing ones must never be renamed.
+type Code string
+
+// Error codes
+const (
+	CodeInvalidBody       Code = "INVALID_BODY"
+	CodeInvalidParameter  Code = "INVALID_PARAMETER"
+	CodeInvalidCursor     Code = "INVALID_CURSOR"
+	CodeUnsupportedFormat Code = "UNSUPPORTED_FORMAT"
+
+	CodeUnauthorized       Code = "UNAUTHORIZED"
+	CodeTokenInvalid       Code = "TOKEN_INVALID"
+	CodeTokenExpired       Code = "TOKEN_EXPIRED"
+	CodeInvalidCredentials Code = "INVALID_CREDENTIALS"
+	CodeUserNotConfirmed   Code = "USER_NOT_CONFIRMED"
+	CodeLoginFailed        Code = "LOGIN_FAILED"
+	CodeForbidden          Code = "FORBIDDEN"
+	CodeInsufficientScope  Code = "INSUFFICIENT_SCOPE"
+	CodeQuotaExceeded      Code = "QUOTA_EXCEEDED"
+	CodeRetentionActive    Code = "RETENTION_ACTIVE"
+	CodeLegalHold          Code = "LEGAL_HOLD"
+
+	CodeNotFound           Code = "NOT_FOUND"
+	CodeFileNotFound       Code = "FILE_NOT_FOUND"
+	CodeResultNotFound     Code = "RESULT_NOT_FOUND"
+	CodeCollectionNotFound Code = "COLLECTION_NOT_FOUND"
+	CodeShareNotFound      Code = "SHARE_NOT_FOUND"
+	CodeExportNotFound     Code = "EXPORT_NOT_FOUND"
+	CodeJobNotFound        Code = "JOB_NOT_FOUND"
+	CodeObjectNotFound     Code = "OBJECT_NOT_FOUND"
+	CodeUserNotFound       Code = "USER_NOT_FOUND"
+	CodeSSHKeyNotFound     Code = "SSH_KEY_NOT_FOUND"
+	CodeAPIKeyNotFound     Code = "API_KEY_NOT_FOUND"
+	CodeWebhookNotFound    Code = "WEBHOOK_NOT_FOUND"
+	CodePipelineNotFound   Code = "PIPELINE_NOT_FOUND"
+	CodeScheduleNotFound   Code = "SCHEDULE_NOT_FOUND"
+
+	CodeCustomProcessorNotFound Code = "CUSTOM_PROCESSOR_NOT_FOUND"
+	CodePluginNotFound          Code = "PLUGIN_NOT_FOUND"
+
+	CodeAccountDeletionNotFound Code = "ACCOUNT_DELETION_NOT_FOUND"
+
+	CodeChunkManifestNotFound Code = "CHUNK_MANIFEST_NOT_FOUND"
+	CodeChecksumMismatch      Code = "CHECKSUM_MISMATCH"
+
+	CodeUploadTokenInvalid Code = "UPLOAD_TOKEN_INVALID"
+
+	CodeTenantSettingsNotFound Code = "TENANT_SETTINGS_NOT_FOUND"
+
+	CodeQuarantineNotFound Code = "QUARANTINE_NOT_FOUND"
+	CodeAlreadyReviewed    Code = "ALREADY_REVIEWED"
+
+	CodeIPNotAllowed        Code = "IP_NOT_ALLOWED"
+	CodeIPDenyEntryNotFound Code = "IP_DENY_ENTRY_NOT_FOUND"
+
+	CodeSignatureInvalid    Code = "SIGNATURE_INVALID"
+	CodeIntegrationNotFound Code = "INTEGRATION_NOT_FOUND"
+
+	CodeVersionConflict     Code = "VERSION_CONFLICT"
+	CodeUserExists          Code = "USER_EXISTS"
+	CodeDuplicateCollection Code = "DUPLICATE_COLLECTION"
+	CodeDuplicateFileName   Code = "DUPLICATE_FILE_NAME"
+	CodeCollectionCycle     Code = "COLLECTION_CYCLE"
+	CodeShareExpired        Code = "SHARE_EXPIRED"
+	CodeSharePassword       Code = "SHARE_PASSWORD_INVALID"
+	CodeDuplicateSSHKey     Code = "DUPLICATE_SSH_KEY"
+	CodeFileEncrypted       Code = "FILE_ENCRYPTED"
+	CodeExportInProgress    Code = "EXPORT_IN_PROGRESS"
+	CodeRedactionPending    Code = "REDACTION_PENDING"
+
+	CodeIdempotencyKeyReused  Code = "IDEMPOTENCY_KEY_REUSED"
+	CodeIdempotencyInProgress Code = "IDEMPOTENCY_IN_PROGRESS"
+
+	CodeURLNotAllowed Code = "URL_NOT_ALLOWED"
+	CodeFetchFailed   Code = "FETCH_FAILED"
+
+	CodeRateLimited        Code = "RATE_LIMITED"
+	CodeConcurrencyLimited Code = "CONCURRENCY_LIMITED"
+	CodeMaintenance        Code = "MAINTENANCE"
+
+	CodeInternal Code = "INTERNAL_ERROR"
+)
+
+// Problem describes an error code in the catalog
+type Problem struct {
+	Code   Code   `json:"code"`
+	Status int    `json:"status"`
+	Title  string `json:"title"`
+}
+
+var catalog = map[Code]Problem{
+	CodeInvalidBody:       {Status: http.StatusBadRequest, Title: "Invalid request body"},
+	CodeInvalidParameter:  {Status: http.StatusBadRequest, Title: "Invalid parameter"},
+	CodeInvalidCursor:     {Status: http.StatusBadRequest, Title: "Invalid pagination cursor"},
+	CodeUnsupportedFormat: {Status: http.StatusBadRequest, Title: "Unsupported format"},
+
+	CodeUnauthorized:       {Status: http.StatusUnauthorized, Title: "Authentication required"},
+	CodeTokenInvalid:       {Status: http.StatusUnauthorized, Title: "Invalid token"},
+	CodeTokenExpired:       {Status: http.StatusUnauthorized, Title: "Token expired"},
+	CodeInvalidCredentials: {Status: http.StatusUnauthorized, Title: "Invalid username or password"},
+	CodeUserNotConfirmed:   {Status: http.StatusForbidden, Title: "User not confirmed"},
+	CodeLoginFailed:        {Status: http.StatusUnauthorized, Title: "External login failed"},
+	CodeForbidden:          {Status: http.StatusForbidden, Title: "Forbidden"},
+	CodeInsufficientScope:  {Status: http.StatusForbidden, Title: "Insufficient scope"},
+	CodeQuotaExceeded:      {Status: http.StatusForbidden, Title: "Quota exceeded"},
+	CodeRetentionActive:    {Status: http.StatusForbidden, Title: "File is under retention"},
+	CodeLegalHold:          {Status: http.StatusForbidden, Title: "File is under legal hold"},
+
+	CodeNotFound:           {Status: http.StatusNotFound, Title: "Not found"},
+	CodeFileNotFound:       {Status: http.StatusNotFound, Title: "File not found"},
+	CodeResultNotFound:     {Status: http.StatusNotFound, Title: "Processing result not found"},
+	CodeCollectionNotFound: {Status: http.StatusNotFound, Title: "Collection not found"},
+	CodeShareNotFound:      {Status: http.StatusNotFound, Title: "Share not found"},
+	CodeExportNotFound:     {Status: http.StatusNotFound, Title: "Export not found"},
+	CodeJobNotFound:        {Status: http.StatusNotFound, Title: "Scheduled job not found"},
+	CodeObjectNotFound:     {Status: http.StatusNotFound, Title: "S3 object not found"},
+	CodeUserNotFound:       {Status: http.StatusNotFound, Title: "User not found"},
+	CodeSSHKeyNotFound:     {Status: http.StatusNotFound, Title: "SSH key not found"},
+	CodeAPIKeyNotFound:     {Status: http.StatusNotFound, Title: "API key not found"},
+	CodeWebhookNotFound:    {Status: http.StatusNotFound, Title: "Webhook not found"},
+	CodePipelineNotFound:   {Status: http.StatusNotFound, Title: "Pipeline not found"},
+	CodeScheduleNotFound:   {Status: http.StatusNotFound, Title: "Schedule not found"},
+
+	CodeCustomProcessorNotFound: {Status: http.StatusNotFound, Title: "Custom processor not found"},
+	CodePluginNotFound:          {Status: http.StatusNotFound, Title: "Plugin not found"},
+
+	CodeAccountDeletionNotFound: {Status: http.StatusNotFound, Title: "Account deletion not found"},
+
+	CodeChunkManifestNotFound: {Status: http.StatusNotFound, Title: "Chunk manifest not found"},
+	CodeChecksumMismatch:      {Status: http.StatusUnprocessableEntity, Title: "Checksum mismatch"},
+
+	CodeUploadTokenInvalid: {Status: http.StatusNotFound, Title: "Upload token not found, expired or used"},
+
+	CodeTenantSettingsNotFound: {Status: http.StatusNotFound, Title: "Tenant settings not found"},
+
+	CodeQuarantineNotFound: {Status: http.StatusNotFound, Title: "Quarantined result not found"},
+	CodeAlreadyReviewed:    {Status: http.StatusConflict, Title: "Already reviewed"},
+
+	CodeIPNotAllowed:        {Status: http.StatusForbidden, Title: "IP address not allowed"},
+	CodeIPDenyEntryNotFound: {Status: http.StatusNotFound, Title: "IP deny list entry not found"},
+
+	CodeSignatureInvalid:    {Status: http.StatusUnauthorized, Title: "Signature invalid"},
+	CodeIntegrationNotFound: {Status: http.StatusNotFound, Title: "Integration not found"},
+
+	CodeVersionConflict:     {Status: http.StatusConflict, Title: "Version conflict"},
+	CodeUserExists:          {Status: http.StatusConflict, Title: "User already exists"},
+	CodeDuplicateCollection: {Status: http.StatusConflict, Title: "Duplicate collection"},
+	CodeDuplicateFileName:   {Status: http.StatusConflict, Title: "Duplicate file name"},
+	CodeCollectionCycle:     {Status: http.StatusBadRequest, Title: "Collection cycle"},
+	CodeShareExpired:        {Status: http.StatusGone, Title: "Share link expired"},
+	CodeSharePassword:       {Status: http.StatusUnauthorized, Title: "Invalid share password"},
+	CodeDuplicateSSHKey:     {Status: http.StatusConflict, Title: "Duplicate SSH key"},
+	CodeFileEncrypted:       {Status: http.StatusConflict, Title: "File is encrypted"},
+	CodeExportInProgress:    {Status: http.StatusConflict, Title: "Export in progress"},
+	CodeRedactionPending:    {Status: http.StatusConflict, Title: "Redaction pending"},
+
+	CodeIdempotencyKeyReused:  {Status: http.StatusUnprocessableEntity, Title: "Idempotency key reused"},
+	CodeIdempotencyInProgress: {Status: http.StatusConflict, Title: "Request in progress"},
+
+	CodeURLNotAllowed: {Status: http.StatusBadRequest, Title: "URL not allowed"},
+	CodeFetchFailed:   {Status: http.StatusBadGateway, Title: "Fetching the URL failed"},
+
+	CodeRateLimited:        {Status: http.StatusTooManyRequests, Title: "Too many requests"},
+	CodeConcurrencyLimited: {Status: http.StatusTooManyRequests, Title: "Too many concurrent downloads"},
+	CodeMaintenance:        {Status: http.StatusServiceUnavailable, Title: "Down for maintenance"},
+
+	CodeInternal: {Status: http.StatusInternalServerError, Title: "Internal server error"},
+}
+
+// Lookup returns the catalog entry for code. Unknown codes are reported as
+// internal errors.
+func Lookup(code Code) Problem {
+	p, ok := catalog[code]
+	if !ok {
+		code = CodeInternal
+		p = catalog[code]
+	}
+	p.Code = code
+	return p
+}
+
+// Catalog returns every error code, sorted by code
+func Catalog() []Problem {
+	problems := make([]Problem, 0, len(catalog))
+	for code := range catalog {
+		problems = append(problems, Lookup(code))
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Code < problems[j].Code })
+	return problems
+}
+
+// TypeURI returns the problem type URI for code, which serves its catalog
+// entry
+func TypeURI(code Code) string {
+	return "/api/errors/" + string(code)
+}
+
+// Error is an error with a code from the catalog
+type Error struct {
+	Code   Code
+	Detail string
+}
+
+// New returns an error with the given code and human-readable detail
+func New(code Code, detail string) *Error {
+	return &Error{Code: code, Detail: detail}
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return e.Detail
+	}
+	return Lookup(e.Code).Title
+}
+
+// From converts err to an *Error, mapping known domain errors to their codes.
+// Anything else is an internal error, and its message is not exposed.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	switch {
+	case errors.Is(err, database.ErrConflict):
+		return New(CodeVersionConflict, "The resource was modified by another request, reload and retry")
+	case errors.Is(err, database.ErrDuplicateCollection):
+		return New(CodeDuplicateCollection, err.Error())
+	case errors.Is(err, database.ErrDuplicateFileName):
+		return New(CodeDuplicateFileName, err.Error())
+	case errors.Is(err, database.ErrRetentionActive):
+		return New(CodeRetentionActive, err.Error())
+	case errors.Is(err, database.ErrLegalHold):
+		return New(CodeLegalHold, "The file is under legal hold and can't be deleted")
+	case errors.Is(err, database.ErrCollectionCycle):
+		return New(CodeCollectionCycle, err.Error())
+	case errors.Is(err, database.ErrTokenExpired):
+		return New(CodeTokenExpired, "The access token has expired, sign in again")
+	case errors.Is(err, pagination.ErrInvalidCursor):
+		return New(CodeInvalidCursor, err.Error())
+	}
+	return New(CodeInternal, "")
+}
+
+// problemDetails is an RFC 7807 problem details object
+type problemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     Code   `json:"code"`
+}
+
+// jsonAPIError is an error object in a JSON:API error document
+type jsonAPIError struct {
+	Status string `json:"status"`
+	Code   Code   `json:"code"`
+	Title  string `json:"title"`
+	Detail string `json:"detail,omitempty"`
+	Links  struct {
+		Type string `json:"type"`
+	} `json:"links"`
+}
+
+// JSONAPIMediaType is the media type clients send in Accept to get errors as
+// JSON:API documents instead of problem details
+const JSONAPIMediaType = "application/vnd.api+json"
+
+// Write writes err as an error response, formatted as JSON:API if the request
+// accepts it and as problem+json otherwise
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	e := From(err)
+	p := Lookup(e.Code)
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if strings.Contains(r.Header.Get("Accept"), JSONAPIMediaType) {
+		body := jsonAPIError{
+			Status: strconv.Itoa(p.Status),
+			Code:   p.Code,
+			Title:  p.Title,
+			Detail: e.Detail,
+		}
+		body.Links.Type = TypeURI(p.Code)
+
+		w.Header().Set("Content-Type", JSONAPIMediaType)
+		w.WriteHeader(p.Status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"errors": []jsonAPIError{body},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(problemDetails{
+		Type:     TypeURI(p.Code),
+		Title:    p.Title,
+		Status:   p.Status,
+		Detail:   e.Detail,
+		Instance: r.URL.Path,
+		Code:     p.Code,
+	})
+}
+
+// Respond writes an error response with the given code and detail
+func Respond(w http.ResponseWriter, r *http.Request, code Code, detail string) {
+	Write(w, r, New(code, detail))
+}
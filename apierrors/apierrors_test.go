@@ -0,0 +1,65 @@
+package apierrors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func TestWriteProblemJSON(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/files/123", nil)
+	w := httptest.NewRecorder()
+	Respond(w, r, CodeFileNotFound, "File not found")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "/api/errors/FILE_NOT_FOUND", body["type"])
+	assert.Equal(t, "FILE_NOT_FOUND", body["code"])
+	assert.Equal(t, float64(404), body["status"])
+	assert.Equal(t, "File not found", body["detail"])
+	assert.Equal(t, "/api/files/123", body["instance"])
+}
+
+func TestWriteJSONAPI(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/files", nil)
+	r.Header.Set("Accept", JSONAPIMediaType)
+	w := httptest.NewRecorder()
+	Write(w, r, database.ErrConflict)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, JSONAPIMediaType, w.Header().Get("Content-Type"))
+
+	var body struct {
+		Errors []map[string]interface{} `json:"errors"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	if assert.Len(t, body.Errors, 1) {
+		assert.Equal(t, "409", body.Errors[0]["status"])
+		assert.Equal(t, "VERSION_CONFLICT", body.Errors[0]["code"])
+	}
+}
+
+func TestFromHidesUnknownErrors(t *testing.T) {
+	e := From(fmt.Errorf("pq: connection refused"))
+	assert.Equal(t, CodeInternal, e.Code)
+	assert.Empty(t, e.Detail)
+
+	wrapped := fmt.Errorf("saving: %w", New(CodeQuotaExceeded, "Storage quota exceeded"))
+	assert.Equal(t, CodeQuotaExceeded, From(wrapped).Code)
+}
+
+func TestCatalogComplete(t *testing.T) {
+	for _, p := range Catalog() {
+		assert.NotZero(t, p.Status, p.Code)
+		assert.NotEmpty(t, p.Title, p.Code)
+	}
+	assert.Equal(t, CodeInternal, Lookup("NO_SUCH_CODE").Code)
+}
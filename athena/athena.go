@@ -0,0 +1,253 @@
+// Package athena runs SQL queries with Amazon Athena. It calls the three
+// Athena operations it needs over the JSON API directly, signed with the
+// SDK's credentials.
+package athena
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+)
+
+// Query execution states reported by Athena
+const (
+	StateQueued    = "QUEUED"
+	StateRunning   = "RUNNING"
+	StateSucceeded = "SUCCEEDED"
+	StateFailed    = "FAILED"
+	StateCancelled = "CANCELLED"
+)
+
+// maxResultsPerPage is the most rows GetQueryResults returns at a time
+const maxResultsPerPage = 1000
+
+// Client starts Athena queries and reads their results
+type Client struct {
+	cfg    aws.Config
+	signer *v4.Signer
+	client *http.Client
+
+	// Database is the Glue database queries run in, WorkGroup the workgroup
+	// they run in and OutputLocation the S3 URL Athena writes results to
+	Database       string
+	WorkGroup      string
+	OutputLocation string
+}
+
+// New returns a client using cfg's region, credentials and endpoint
+// resolver. No request is made until a query is started.
+func New(cfg aws.Config, database, workGroup, outputLocation string) *Client {
+	return &Client{
+		cfg:            cfg,
+		signer:         v4.NewSigner(),
+		client:         awsconfig.NewHTTPClient(30 * time.Second),
+		Database:       database,
+		WorkGroup:      workGroup,
+		OutputLocation: outputLocation,
+	}
+}
+
+// Execution is the state of a query execution
+type Execution struct {
+	ID    string
+	State string
+	// Reason explains a failed or cancelled execution
+	Reason           string
+	DataScannedBytes int64
+	EngineTime       time.Duration
+}
+
+// Done reports whether the execution has finished, successfully or not
+func (e *Execution) Done() bool {
+	return e.State == StateSucceeded || e.State == StateFailed || e.State == StateCancelled
+}
+
+// ResultSet is the result of a successful query
+type ResultSet struct {
+	Columns []string
+	// Rows holds each row's values in the order of Columns; nil is NULL
+	Rows [][]*string
+	// Truncated is set when the query returned more rows than were read
+	Truncated bool
+}
+
+// StartQuery starts running query and returns its execution ID
+func (c *Client) StartQuery(ctx context.Context, query string) (string, error) {
+	in := map[string]interface{}{
+		"QueryString":           query,
+		"QueryExecutionContext": map[string]string{"Database": c.Database},
+	}
+	if c.WorkGroup != "" {
+		in["WorkGroup"] = c.WorkGroup
+	}
+	if c.OutputLocation != "" {
+		in["ResultConfiguration"] = map[string]string{"OutputLocation": c.OutputLocation}
+	}
+	var out struct {
+		QueryExecutionId string
+	}
+	if err := c.call(ctx, "StartQueryExecution", in, &out); err != nil {
+		return "", err
+	}
+	return out.QueryExecutionId, nil
+}
+
+// GetExecution returns the state of a query execution
+func (c *Client) GetExecution(ctx context.Context, id string) (*Execution, error) {
+	var out struct {
+		QueryExecution struct {
+			Status struct {
+				State             string
+				StateChangeReason string
+			}
+			Statistics struct {
+				DataScannedInBytes          int64
+				EngineExecutionTimeInMillis int64
+			}
+		}
+	}
+	if err := c.call(ctx, "GetQueryExecution", map[string]string{"QueryExecutionId": id}, &out); err != nil {
+		return nil, err
+	}
+	qe := out.QueryExecution
+	return &Execution{
+		ID:               id,
+		State:            qe.Status.State,
+		Reason:           qe.Status.StateChangeReason,
+		DataScannedBytes: qe.Statistics.DataScannedInBytes,
+		EngineTime:       time.Duration(qe.Statistics.EngineExecutionTimeInMillis) * time.Millisecond,
+	}, nil
+}
+
+// GetResults reads up to maxRows rows of a successful execution's result
+func (c *Client) GetResults(ctx context.Context, id string, maxRows int) (*ResultSet, error) {
+	rs := &ResultSet{Rows: [][]*string{}}
+	token := ""
+	header := true
+	for {
+		in := map[string]interface{}{
+			"QueryExecutionId": id,
+			"MaxResults":       maxResultsPerPage,
+		}
+		if token != "" {
+			in["NextToken"] = token
+		}
+		var out struct {
+			NextToken string
+			ResultSet struct {
+				Rows []struct {
+					Data []struct {
+						VarCharValue *string
+					}
+				}
+				ResultSetMetadata struct {
+					ColumnInfo []struct {
+						Name string
+					}
+				}
+			}
+		}
+		if err := c.call(ctx, "GetQueryResults", in, &out); err != nil {
+			return nil, err
+		}
+		if rs.Columns == nil {
+			rs.Columns = make([]string, 0, len(out.ResultSet.ResultSetMetadata.ColumnInfo))
+			for _, col := range out.ResultSet.ResultSetMetadata.ColumnInfo {
+				rs.Columns = append(rs.Columns, col.Name)
+			}
+		}
+		for _, row := range out.ResultSet.Rows {
+			// The first row of a SELECT's result repeats the column names
+			if header {
+				header = false
+				continue
+			}
+			if len(rs.Rows) == maxRows {
+				rs.Truncated = true
+				return rs, nil
+			}
+			values := make([]*string, len(row.Data))
+			for i, d := range row.Data {
+				values[i] = d.VarCharValue
+			}
+			rs.Rows = append(rs.Rows, values)
+		}
+		if out.NextToken == "" {
+			return rs, nil
+		}
+		token = out.NextToken
+	}
+}
+
+// endpoint resolves the Athena endpoint and signing region, honouring the
+// LocalStack resolver used in local development
+func (c *Client) endpoint() (string, string) {
+	region := c.cfg.Region
+	if c.cfg.EndpointResolverWithOptions != nil {
+		e, err := c.cfg.EndpointResolverWithOptions.ResolveEndpoint("Athena", region)
+		if err == nil {
+			if e.SigningRegion != "" {
+				region = e.SigningRegion
+			}
+			return e.URL, region
+		}
+	}
+	return fmt.Sprintf("https://athena.%s.amazonaws.com", region), region
+}
+
+// call sends one Athena JSON request and decodes the response into out
+func (c *Client) call(ctx context.Context, operation string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	url, region := c.endpoint()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonAthena."+operation)
+
+	if c.cfg.Credentials == nil {
+		return errors.New("no AWS credentials configured")
+	}
+	creds, err := c.cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieving credentials: %v", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "athena", region, time.Now()); err != nil {
+		return fmt.Errorf("signing request: %v", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Athena %s: %v", operation, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return fmt.Errorf("Athena %s: %v", operation, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(respBody, &apiErr)
+		return fmt.Errorf("Athena %s: %s %s: %s", operation, resp.Status, apiErr.Type, apiErr.Message)
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,96 @@
+package athena
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testClient(url string) *Client {
+	return New(aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test", "test", ""),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, _ ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: url}, nil
+		}),
+	}, "analytics", "primary", "s3://bucket/athena-results/")
+}
+
+func TestStartQueryAndGetExecution(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-east-1/athena/aws4_request")
+		var in map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&in)
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonAthena.StartQueryExecution":
+			assert.Equal(t, "SELECT 1", in["QueryString"])
+			assert.Equal(t, "primary", in["WorkGroup"])
+			assert.Equal(t, map[string]interface{}{"Database": "analytics"}, in["QueryExecutionContext"])
+			assert.Equal(t, map[string]interface{}{"OutputLocation": "s3://bucket/athena-results/"}, in["ResultConfiguration"])
+			w.Write([]byte(`{"QueryExecutionId":"q-1"}`))
+		case "AmazonAthena.GetQueryExecution":
+			if in["QueryExecutionId"] != "q-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"InvalidRequestException","Message":"unknown execution"}`))
+				return
+			}
+			w.Write([]byte(`{"QueryExecution":{"Status":{"State":"FAILED","StateChangeReason":"TABLE_NOT_FOUND"},"Statistics":{"DataScannedInBytes":42,"EngineExecutionTimeInMillis":1500}}}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := testClient(srv.URL)
+	ctx := context.Background()
+
+	id, err := c.StartQuery(ctx, "SELECT 1")
+	require.NoError(t, err)
+	assert.Equal(t, "q-1", id)
+
+	e, err := c.GetExecution(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, e.Done())
+	assert.Equal(t, StateFailed, e.State)
+	assert.Equal(t, "TABLE_NOT_FOUND", e.Reason)
+	assert.Equal(t, int64(42), e.DataScannedBytes)
+	assert.Equal(t, 1500, int(e.EngineTime.Milliseconds()))
+
+	_, err = c.GetExecution(ctx, "q-2")
+	assert.ErrorContains(t, err, "unknown execution")
+}
+
+func TestGetResultsSkipsHeaderAndPages(t *testing.T) {
+	pages := map[string]string{
+		"":   `{"NextToken":"p2","ResultSet":{"ResultSetMetadata":{"ColumnInfo":[{"Name":"processor"},{"Name":"failures"}]},"Rows":[{"Data":[{"VarCharValue":"processor"},{"VarCharValue":"failures"}]},{"Data":[{"VarCharValue":"builtin"},{"VarCharValue":"3"}]}]}}`,
+		"p2": `{"ResultSet":{"ResultSetMetadata":{"ColumnInfo":[{"Name":"processor"},{"Name":"failures"}]},"Rows":[{"Data":[{"VarCharValue":"custom"},{}]},{"Data":[{"VarCharValue":"other"},{"VarCharValue":"1"}]}]}}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			NextToken string
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		w.Write([]byte(pages[in.NextToken]))
+	}))
+	defer srv.Close()
+
+	c := testClient(srv.URL)
+	rs, err := c.GetResults(context.Background(), "q-1", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"processor", "failures"}, rs.Columns)
+	require.Len(t, rs.Rows, 3)
+	assert.Equal(t, "builtin", *rs.Rows[0][0])
+	assert.Nil(t, rs.Rows[1][1])
+	assert.False(t, rs.Truncated)
+
+	rs, err = c.GetResults(context.Background(), "q-1", 2)
+	require.NoError(t, err)
+	assert.Len(t, rs.Rows, 2)
+	assert.True(t, rs.Truncated)
+}
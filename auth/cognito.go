@@ -2,26 +2,130 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/settings"
 )
 
 var (
 	cognitoClient *cognitoidentityprovider.Client
 	userPoolID    string
 	clientID      string
+
+	// Token verification: the pool's issuer URL and signing keys, the claim
+	// holding the user's groups and the roles those groups map to
+	cognitoIssuer string
+	cognitoKeys   *keySet
+	roleClaim     string
+	groupRoles    RoleMapping
+
+	// knownUsers holds the subjects already recorded in the users table
+	knownUsers sync.Map
 )
 
-// InitCognito initializes the Cognito client
-func InitCognito(cfg aws.Config) {
+// InitCognito initializes the Cognito client and token verification.
+// COGNITO_GROUP_ROLES maps groups to roles, e.g. "Admins=admin", read from
+// the claim named by COGNITO_ROLE_CLAIM (default cognito:groups).
+// COGNITO_ISSUER overrides the issuer URL for Cognito emulators.
+func InitCognito(cfg aws.Config) error {
 	cognitoClient = cognitoidentityprovider.NewFromConfig(cfg)
 
 	// Get Cognito configuration from environment variables
 	userPoolID = getEnv("COGNITO_USER_POOL_ID", "us-east-1_testpool")
 	clientID = getEnv("COGNITO_CLIENT_ID", "1234567890abcdef")
+
+	region, _, _ := strings.Cut(userPoolID, "_")
+	cognitoIssuer = getEnv("COGNITO_ISSUER", fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", region, userPoolID))
+	cognitoKeys = newKeySet(cognitoIssuer + "/.well-known/jwks.json")
+	roleClaim = getEnv("COGNITO_ROLE_CLAIM", "cognito:groups")
+
+	var err error
+	groupRoles, err = ParseRoleMapping(os.Getenv("COGNITO_GROUP_ROLES"))
+	if err != nil {
+		return fmt.Errorf("COGNITO_GROUP_ROLES: %v", err)
+	}
+	return nil
+}
+
+// VerifyToken checks a Cognito access or ID token locally against the pool's
+// signing keys and returns its user, with roles mapped from the user's groups.
+// Users are recorded in the users table the first time they are seen. Access
+// tokens carry no email address, so users first seen through one get a
+// placeholder address.
+func VerifyToken(ctx context.Context, token string) (*MockUser, error) {
+	claims, err := verifyJWT(ctx, token, cognitoKeys, time.Now())
+	if errors.Is(err, errJWTExpired) {
+		return nil, database.ErrTokenExpired
+	}
+	if err != nil {
+		settings.Debugf("Rejected token: %v", err)
+		return nil, ErrInvalidToken
+	}
+	if err := checkCognitoClaims(claims, cognitoIssuer, clientID); err != nil {
+		settings.Debugf("Rejected token: %v", err)
+		return nil, ErrInvalidToken
+	}
+
+	user := &MockUser{
+		ID:          claims.string("sub"),
+		Username:    claims.string("username"),
+		Email:       claims.string("email"),
+		Confirmed:   true,
+		AccessToken: token,
+		Roles:       groupRoles.Roles(claims.strings(roleClaim)),
+		Scopes:      cognitoScopes(claims.string("scope")),
+	}
+	if user.Username == "" {
+		user.Username = claims.string("cognito:username")
+	}
+	if user.Email == "" {
+		user.Email = user.ID + "@cognito.invalid"
+	}
+	if _, ok := knownUsers.Load(user.ID); !ok {
+		saved, err := database.EnsureExternalUser(user.ID, user.Username, user.Email)
+		if err != nil {
+			return nil, err
+		}
+		user.CreatedAt = saved.CreatedAt
+		knownUsers.Store(user.ID, true)
+	}
+	return user, nil
+}
+
+// checkCognitoClaims checks that a token was issued by the pool for this app
+// client. Access tokens name the client in client_id, ID tokens in aud.
+func checkCognitoClaims(claims jwtClaims, issuer, clientID string) error {
+	if claims.string("iss") != issuer {
+		return fmt.Errorf("unexpected issuer %q", claims.string("iss"))
+	}
+	if claims.string("sub") == "" {
+		return errors.New("token has no subject")
+	}
+	switch claims.string("token_use") {
+	case "access":
+		if claims.string("client_id") != clientID {
+			return errors.New("token was issued to another client")
+		}
+	case "id":
+		for _, aud := range claims.strings("aud") {
+			if aud == clientID {
+				return nil
+			}
+		}
+		return errors.New("token was issued to another client")
+	default:
+		return fmt.Errorf("unexpected token_use %q", claims.string("token_use"))
+	}
+	return nil
 }
 
 // SignUp registers a new user
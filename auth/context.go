@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+type contextKey string
+
+const principalContextKey contextKey = "principal"
+
+// Ways a caller can authenticate
+const (
+	// MethodSession is an access token from signing in, with a password or
+	// an external provider
+	MethodSession = "session"
+	MethodCognito = "cognito"
+	MethodAPIKey  = "api_key"
+	MethodService = "service"
+)
+
+// Principal is the authenticated caller of a request. Every auth middleware
+// stores one in the request context; handlers read it with
+// PrincipalFromContext and use its helpers for ownership checks.
+type Principal struct {
+	UserID   string
+	Username string
+	Email    string
+	// Method is how the caller authenticated, one of the Method constants
+	Method string
+	// Roles are the internal roles the user holds
+	Roles []string
+	// Scopes limit what the credential may do; nil means unrestricted
+	Scopes []string
+	// AllowedCIDRs are the only ranges the credential may be used from;
+	// empty allows any address
+	AllowedCIDRs []string
+}
+
+// NewPrincipal returns the principal for a user authenticated with method
+func NewPrincipal(user *MockUser, method string) *Principal {
+	return &Principal{
+		UserID:       user.ID,
+		Username:     user.Username,
+		Email:        user.Email,
+		Method:       method,
+		Roles:        user.Roles,
+		Scopes:       user.Scopes,
+		AllowedCIDRs: user.AllowedCIDRs,
+	}
+}
+
+// WithPrincipal returns a copy of ctx carrying the authenticated caller
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, p)
+}
+
+// PrincipalFromContext returns the authenticated caller stored in ctx, or nil
+// for anonymous requests
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalContextKey).(*Principal)
+	return p
+}
+
+// UserIDFromContext returns the authenticated caller's user ID, or "" for
+// anonymous requests
+func UserIDFromContext(ctx context.Context) string {
+	if p := PrincipalFromContext(ctx); p != nil {
+		return p.UserID
+	}
+	return ""
+}
+
+// HasRole reports whether the caller holds role
+func (p *Principal) HasRole(role string) bool {
+	return p != nil && containsString(p.Roles, role)
+}
+
+// HasScope reports whether the caller's credential grants scope.
+// Unrestricted credentials grant every scope.
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+	return p.Scopes == nil || containsString(p.Scopes, scope)
+}
+
+// IsAdmin reports whether the caller is an administrator: a user holding
+// RoleAdmin, or one listed by username in the comma-separated ADMIN_USERS
+// variable. Administrators using a scoped credential also need ScopeAdmin.
+func (p *Principal) IsAdmin() bool {
+	if p == nil || !p.HasScope(ScopeAdmin) {
+		return false
+	}
+	if p.HasRole(RoleAdmin) {
+		return true
+	}
+	for _, name := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if strings.EqualFold(strings.TrimSpace(name), p.Username) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanReadPII reports whether the caller may see the personal data in files:
+// administrators and holders of RolePIIReader whose credential allows
+// ScopePIIRead are served a file's original content, everyone else its
+// redacted copy if it has one
+func (p *Principal) CanReadPII() bool {
+	return p.HasScope(ScopePIIRead) && (p.IsAdmin() || p.HasRole(RolePIIReader))
+}
+
+// Owns reports whether the caller is the owner with ownerID
+func (p *Principal) Owns(ownerID string) bool {
+	return p != nil && ownerID != "" && ownerID == p.UserID
+}
+
+// CanAccess reports whether the caller may use a resource owned by ownerID.
+// Files uploaded anonymously before such uploads were given a system owner
+// have no owner and are left to administrators.
+func (p *Principal) CanAccess(ownerID string) bool {
+	if ownerID == "" {
+		return p.IsAdmin()
+	}
+	return p.Owns(ownerID)
+}
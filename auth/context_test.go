@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrincipalOwnership(t *testing.T) {
+	var anonymous *Principal
+	owner := NewPrincipal(&MockUser{ID: "u1", Username: "alice", Roles: []string{RoleUser}}, MethodSession)
+	ctx := WithPrincipal(context.Background(), owner)
+	assert.Same(t, owner, PrincipalFromContext(ctx))
+	assert.Equal(t, "u1", UserIDFromContext(ctx))
+	assert.Empty(t, UserIDFromContext(context.Background()))
+
+	assert.True(t, owner.Owns("u1"))
+	assert.False(t, owner.Owns("u2"))
+	assert.False(t, owner.Owns(""), "nobody owns unowned resources")
+	assert.False(t, owner.CanAccess(""), "unowned resources are for administrators")
+	assert.False(t, owner.CanAccess("u2"))
+	admin := NewPrincipal(&MockUser{ID: "u3", Username: "root", Roles: []string{RoleUser, RoleAdmin}}, MethodSession)
+	assert.True(t, admin.CanAccess(""))
+
+	assert.False(t, anonymous.Owns("u1"))
+	assert.False(t, anonymous.CanAccess(""))
+	assert.False(t, anonymous.CanAccess("u1"))
+	assert.False(t, anonymous.IsAdmin())
+}
+
+func TestCanReadPII(t *testing.T) {
+	var anonymous *Principal
+	assert.False(t, anonymous.CanReadPII())
+	assert.False(t, (&Principal{Username: "bob", Roles: []string{RoleUser}}).CanReadPII())
+	assert.True(t, (&Principal{Username: "eve", Roles: []string{RoleUser, RolePIIReader}}).CanReadPII())
+	assert.True(t, (&Principal{Username: "root", Roles: []string{RoleUser, RoleAdmin}}).CanReadPII())
+	assert.True(t, (&Principal{Username: "eve", Roles: []string{RolePIIReader}, Scopes: []string{ScopeFilesRead, ScopePIIRead}}).CanReadPII())
+	assert.False(t, (&Principal{Username: "eve", Roles: []string{RolePIIReader}, Scopes: []string{ScopeFilesRead}}).CanReadPII())
+	assert.False(t, (&Principal{Username: "bob", Roles: []string{RoleUser}, Scopes: []string{ScopePIIRead}}).CanReadPII())
+}
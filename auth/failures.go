@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"log"
+	"time"
+)
+
+// Internal reasons a sign-up, confirmation or sign-in failed. Clients only
+// ever see a generic response, so these are logged for operators instead.
+const (
+	reasonUnknownUser   = "UNKNOWN_USER"
+	reasonWrongPassword = "WRONG_PASSWORD"
+	reasonNoPassword    = "EXTERNAL_USER"
+	reasonAccountTaken  = "ACCOUNT_TAKEN"
+)
+
+// unknownUserPassword is compared against when a username doesn't exist, so
+// the comparison costs the same as for a real user
+const unknownUserPassword = "unknown-user-placeholder-password"
+
+// logAuthFailure records why an auth request failed without telling the client
+func logAuthFailure(action, username, reason string) {
+	log.Printf("Auth %s failed for %q: %s", action, username, reason)
+}
+
+// authResponseFloor returns the least time sign-up, confirmation and sign-in
+// take, from AUTH_RESPONSE_FLOOR (default 250ms), so that response times
+// don't reveal which check failed
+func authResponseFloor() time.Duration {
+	floor, err := time.ParseDuration(getEnv("AUTH_RESPONSE_FLOOR", "250ms"))
+	if err != nil {
+		return 250 * time.Millisecond
+	}
+	return floor
+}
+
+// padTiming sleeps until authResponseFloor has passed since start. Call it
+// deferred, before taking any locks.
+func padTiming(start time.Time) {
+	if d := authResponseFloor() - time.Since(start); d > 0 {
+		time.Sleep(d)
+	}
+}
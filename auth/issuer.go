@@ -0,0 +1,397 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/envelope"
+)
+
+// The API can issue its sign-ins an ID token as well: an RS256 JWT that other
+// services verify against the keys published at /.well-known/jwks.json,
+// without sharing a secret with the API. JWT_SIGNING chooses where the
+// signing key lives:
+//
+//   - kms: the asymmetric RSA key JWT_KMS_KEY_ID. To rotate, create a new
+//     key, set JWT_KMS_KEY_ID to it and JWT_KMS_PREVIOUS_KEY_ID to the old
+//     one until the tokens it signed have expired.
+//   - local: RSA keys the API generates itself, kept in the database with the
+//     private key sealed by the column keys. A new key takes over every
+//     JWT_KEY_ROTATION, default 720h, or when an administrator rotates it.
+//
+// Keys are published under a kid that the token header names, so verifiers
+// pick the right one across rotations.
+const (
+	JWTSigningKMS   = "kms"
+	JWTSigningLocal = "local"
+)
+
+// defaultJWTKeyRotation is how long a local signing key is used for
+const defaultJWTKeyRotation = 30 * 24 * time.Hour
+
+// jwtKeyRefresh is how long a process trusts the signing keys it loaded
+// before checking for a rotation
+const jwtKeyRefresh = time.Minute
+
+// localKeyBits is the size of generated signing keys
+const localKeyBits = 2048
+
+// ErrJWTSigningOff is returned when issuing or rotating keys without the
+// signing it needs configured
+var ErrJWTSigningOff = errors.New("JWT signing is not configured")
+
+// tokenSigner signs the JWTs the API issues
+type tokenSigner interface {
+	// signingKey returns the kid of the key new tokens are signed with and a
+	// function signing a SHA-256 digest with it
+	signingKey(ctx context.Context) (string, func(ctx context.Context, digest []byte) ([]byte, error), error)
+	// publicKeys returns the keys the API's unexpired tokens verify with, by
+	// kid
+	publicKeys(ctx context.Context) (map[string]*rsa.PublicKey, error)
+}
+
+var (
+	jwtSigner   tokenSigner
+	jwtIssuer   string
+	jwtAudience string
+)
+
+// InitJWTSigning configures the signing of ID tokens from JWT_SIGNING,
+// JWT_ISSUER, default golang-aws-api, and JWT_AUDIENCE, which is left out of
+// tokens if unset. It returns the signing mode, or "" if tokens aren't
+// signed.
+func InitJWTSigning(cfg aws.Config) (string, error) {
+	jwtIssuer = getEnv("JWT_ISSUER", "golang-aws-api")
+	jwtAudience = os.Getenv("JWT_AUDIENCE")
+	mode := os.Getenv("JWT_SIGNING")
+	switch mode {
+	case "":
+		jwtSigner = nil
+	case JWTSigningKMS:
+		keyID := os.Getenv("JWT_KMS_KEY_ID")
+		if keyID == "" {
+			return "", errors.New("JWT_SIGNING=kms needs JWT_KMS_KEY_ID")
+		}
+		jwtSigner = newKMSSigner(envelope.NewKMS(cfg), keyID, os.Getenv("JWT_KMS_PREVIOUS_KEY_ID"))
+	case JWTSigningLocal:
+		rotation := defaultJWTKeyRotation
+		if v := os.Getenv("JWT_KEY_ROTATION"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= tokenTTL() {
+				return "", fmt.Errorf("JWT_KEY_ROTATION must be a duration longer than TOKEN_TTL, got %q", v)
+			}
+			rotation = d
+		}
+		jwtSigner = newLocalSigner(rotation)
+	default:
+		return "", fmt.Errorf("JWT_SIGNING must be %s or %s, got %q", JWTSigningKMS, JWTSigningLocal, mode)
+	}
+	return mode, nil
+}
+
+// IssueJWT returns a signed ID token for a signed-in user, valid as long as
+// the access token issued with it. It names the user and, for a scoped
+// sign-in, the scopes, but never the email.
+func IssueJWT(ctx context.Context, user *MockUser) (string, error) {
+	if jwtSigner == nil {
+		return "", ErrJWTSigningOff
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":       jwtIssuer,
+		"sub":       user.ID,
+		"username":  user.Username,
+		"token_use": "id",
+		"iat":       now.Unix(),
+		"exp":       now.Add(tokenTTL()).Unix(),
+		"jti":       randomToken(),
+	}
+	if jwtAudience != "" {
+		claims["aud"] = jwtAudience
+	}
+	if len(user.Roles) > 0 {
+		claims["roles"] = user.Roles
+	}
+	if user.Scopes != nil {
+		claims["scope"] = strings.Join(user.Scopes, " ")
+	}
+	return signJWTClaims(ctx, jwtSigner, claims)
+}
+
+// signJWTClaims encodes claims as a JWT signed by signer
+func signJWTClaims(ctx context.Context, signer tokenSigner, claims map[string]interface{}) (string, error) {
+	kid, sign, err := signer.signingKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := sign(ctx, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("signing token: %w", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// JWKS returns the keys the API's tokens verify with, as published at
+// /.well-known/jwks.json
+func JWKS(ctx context.Context) (*KeySetDocument, error) {
+	if jwtSigner == nil {
+		return nil, ErrJWTSigningOff
+	}
+	keys, err := jwtSigner.publicKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc := &KeySetDocument{Keys: make([]jwk, 0, len(keys))}
+	for kid, pub := range keys {
+		doc.Keys = append(doc.Keys, newJWK(kid, pub))
+	}
+	return doc, nil
+}
+
+// RotateJWTKey retires the local signing key in favour of a new one and
+// returns its kid. Tokens the old key signed verify until they expire.
+func RotateJWTKey(ctx context.Context) (string, error) {
+	s, ok := jwtSigner.(*localSigner)
+	if !ok {
+		return "", ErrJWTSigningOff
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotate(nil)
+}
+
+// kmsAPI is the part of the KMS client signing needs
+type kmsAPI interface {
+	Sign(ctx context.Context, keyID string, digest []byte) ([]byte, error)
+	GetPublicKey(ctx context.Context, keyID string) ([]byte, error)
+}
+
+// kmsSigner signs with an asymmetric KMS key, publishing it and the previous
+// key. The private keys never leave KMS.
+type kmsSigner struct {
+	kms        kmsAPI
+	keyID      string
+	previousID string
+
+	mu sync.Mutex
+	// public caches each KMS key's kid and public key, which don't change
+	public map[string]kmsPublicKey
+}
+
+type kmsPublicKey struct {
+	kid string
+	key *rsa.PublicKey
+}
+
+func newKMSSigner(kms kmsAPI, keyID, previousID string) *kmsSigner {
+	return &kmsSigner{kms: kms, keyID: keyID, previousID: previousID, public: make(map[string]kmsPublicKey)}
+}
+
+// publicKey returns a KMS key's public key and kid, the thumbprint of the
+// key, so the kid stays the same whatever alias the key is configured by
+func (s *kmsSigner) publicKey(ctx context.Context, keyID string) (kmsPublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.public[keyID]; ok {
+		return k, nil
+	}
+	der, err := s.kms.GetPublicKey(ctx, keyID)
+	if err != nil {
+		return kmsPublicKey{}, fmt.Errorf("fetching public key of %s: %w", keyID, err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return kmsPublicKey{}, fmt.Errorf("parsing public key of %s: %v", keyID, err)
+	}
+	pub, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return kmsPublicKey{}, fmt.Errorf("KMS key %s is not an RSA key", keyID)
+	}
+	sum := sha256.Sum256(der)
+	k := kmsPublicKey{kid: base64.RawURLEncoding.EncodeToString(sum[:]), key: pub}
+	s.public[keyID] = k
+	return k, nil
+}
+
+func (s *kmsSigner) signingKey(ctx context.Context) (string, func(context.Context, []byte) ([]byte, error), error) {
+	k, err := s.publicKey(ctx, s.keyID)
+	if err != nil {
+		return "", nil, err
+	}
+	return k.kid, func(ctx context.Context, digest []byte) ([]byte, error) {
+		return s.kms.Sign(ctx, s.keyID, digest)
+	}, nil
+}
+
+func (s *kmsSigner) publicKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	keys := make(map[string]*rsa.PublicKey)
+	for _, id := range []string{s.keyID, s.previousID} {
+		if id == "" {
+			continue
+		}
+		k, err := s.publicKey(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		keys[k.kid] = k.key
+	}
+	return keys, nil
+}
+
+// localSigner signs with RSA keys kept in the database, generating the
+// first and rotating them once they are older than rotation
+type localSigner struct {
+	rotation time.Duration
+	now      func() time.Time
+
+	mu       sync.Mutex
+	active   *localKey
+	loadedAt time.Time
+	// parsed caches keys by kid so PEM is only decoded once
+	parsed map[string]*rsa.PrivateKey
+}
+
+type localKey struct {
+	kid       string
+	key       *rsa.PrivateKey
+	createdAt time.Time
+}
+
+func newLocalSigner(rotation time.Duration) *localSigner {
+	return &localSigner{rotation: rotation, now: time.Now, parsed: make(map[string]*rsa.PrivateKey)}
+}
+
+func (s *localSigner) signingKey(ctx context.Context) (string, func(context.Context, []byte) ([]byte, error), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.active == nil || now.Sub(s.loadedAt) >= jwtKeyRefresh || now.Sub(s.active.createdAt) >= s.rotation {
+		active, err := s.loadActive(now)
+		if err != nil {
+			return "", nil, err
+		}
+		s.active, s.loadedAt = active, now
+	}
+	key := s.active.key
+	return s.active.kid, func(_ context.Context, digest []byte) ([]byte, error) {
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+	}, nil
+}
+
+// loadActive returns the active key, generating one first if there is none
+// or it is due for rotation. The caller holds the lock.
+func (s *localSigner) loadActive(now time.Time) (*localKey, error) {
+	stored, err := database.ActiveSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || now.Sub(stored.CreatedAt) >= s.rotation {
+		// Only one process rotates a key that is due; the others load
+		// the key it stored
+		cutoff := now.Add(-s.rotation)
+		if _, err := s.rotate(&cutoff); err != nil {
+			return nil, err
+		}
+		if stored, err = database.ActiveSigningKey(); err != nil {
+			return nil, err
+		}
+		if stored == nil {
+			return nil, errors.New("no active signing key after rotation")
+		}
+	}
+	key, err := s.parse(stored)
+	if err != nil {
+		return nil, err
+	}
+	return &localKey{kid: stored.ID, key: key, createdAt: stored.CreatedAt}, nil
+}
+
+// rotate stores a new key, replacing the active one if it was created before
+// olderThan, or in any case if olderThan is nil, and returns the new kid, or
+// "" if it wasn't needed. The caller holds the lock.
+func (s *localSigner) rotate(olderThan *time.Time) (string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, localKeyBits)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	kid := database.NewID()
+	encoded := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	stored, err := database.RotateSigningKey(kid, encoded, olderThan)
+	if err != nil || !stored {
+		return "", err
+	}
+	s.parsed[kid] = key
+	// Sign with the new key from the next token on
+	s.active = nil
+	return kid, nil
+}
+
+// parse decodes a stored key. The caller holds the lock.
+func (s *localSigner) parse(k *database.SigningKey) (*rsa.PrivateKey, error) {
+	if key, ok := s.parsed[k.ID]; ok {
+		return key, nil
+	}
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", k.ID)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing signing key %s: %v", k.ID, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an RSA key", k.ID)
+	}
+	s.parsed[k.ID] = key
+	return key, nil
+}
+
+// publicKeys returns the active key and those retired within the token
+// lifetime, whose tokens may not have expired yet
+func (s *localSigner) publicKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	stored, err := database.ListSigningKeys(s.now().Add(-tokenTTL()))
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make(map[string]*rsa.PublicKey, len(stored))
+	for i := range stored {
+		key, err := s.parse(&stored[i])
+		if err != nil {
+			return nil, err
+		}
+		keys[stored[i].ID] = &key.PublicKey
+	}
+	return keys, nil
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKMS signs with in-memory RSA keys, by key ID
+type fakeKMS struct {
+	keys map[string]*rsa.PrivateKey
+}
+
+func (f *fakeKMS) Sign(_ context.Context, keyID string, digest []byte) ([]byte, error) {
+	key, ok := f.keys[keyID]
+	if !ok {
+		return nil, errors.New("NotFoundException")
+	}
+	return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+}
+
+func (f *fakeKMS) GetPublicKey(_ context.Context, keyID string) ([]byte, error) {
+	key, ok := f.keys[keyID]
+	if !ok {
+		return nil, errors.New("NotFoundException")
+	}
+	return x509.MarshalPKIXPublicKey(&key.PublicKey)
+}
+
+func newFakeKMS(t *testing.T, ids ...string) *fakeKMS {
+	f := &fakeKMS{keys: make(map[string]*rsa.PrivateKey)}
+	for _, id := range ids {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		f.keys[id] = key
+	}
+	return f
+}
+
+// useSigner makes s sign the API's tokens for the rest of the test
+func useSigner(t *testing.T, s tokenSigner) {
+	prevSigner, prevIssuer, prevAudience := jwtSigner, jwtIssuer, jwtAudience
+	jwtSigner, jwtIssuer, jwtAudience = s, "golang-aws-api", "partner"
+	t.Cleanup(func() { jwtSigner, jwtIssuer, jwtAudience = prevSigner, prevIssuer, prevAudience })
+}
+
+func TestIssueJWTVerifiesAgainstJWKS(t *testing.T) {
+	kms := newFakeKMS(t, "alias/new", "alias/old")
+	useSigner(t, newKMSSigner(kms, "alias/new", "alias/old"))
+	ctx := context.Background()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := JWKS(r.Context())
+		assert.NoError(t, err)
+		json.NewEncoder(w).Encode(doc)
+	}))
+	defer srv.Close()
+
+	token, err := IssueJWT(ctx, &MockUser{ID: "u1", Username: "alice", Roles: []string{RoleUser}, Scopes: []string{ScopeFilesRead, ScopeResultsRead}})
+	require.NoError(t, err)
+
+	claims, err := verifyJWT(ctx, token, newKeySet(srv.URL), time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "golang-aws-api", claims.string("iss"))
+	assert.Equal(t, "partner", claims.string("aud"))
+	assert.Equal(t, "u1", claims.string("sub"))
+	assert.Equal(t, "alice", claims.string("username"))
+	assert.Equal(t, "files:read results:read", claims.string("scope"))
+	assert.Equal(t, []string{RoleUser}, claims.strings("roles"))
+	assert.NotContains(t, claims, "email")
+
+	// Tokens outlive the sign-in no longer than its access token
+	_, err = verifyJWT(ctx, token, newKeySet(srv.URL), time.Now().Add(tokenTTL()+time.Minute))
+	assert.ErrorIs(t, err, errJWTExpired)
+
+	doc, err := JWKS(ctx)
+	require.NoError(t, err)
+	assert.Len(t, doc.Keys, 2, "the previous key is published until its tokens expire")
+	for _, k := range doc.Keys {
+		assert.Equal(t, "RS256", k.Alg)
+	}
+}
+
+func TestKMSSignerKeyIDs(t *testing.T) {
+	kms := newFakeKMS(t, "alias/tokens")
+	ctx := context.Background()
+
+	kid, _, err := newKMSSigner(kms, "alias/tokens", "").signingKey(ctx)
+	require.NoError(t, err)
+	again, _, err := newKMSSigner(kms, "alias/tokens", "").signingKey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, kid, again, "the kid is derived from the key, not the process")
+
+	_, _, err = newKMSSigner(kms, "alias/missing", "").signingKey(ctx)
+	assert.Error(t, err)
+}
+
+func TestJWTSigningOff(t *testing.T) {
+	useSigner(t, nil)
+	_, err := IssueJWT(context.Background(), &MockUser{ID: "u1"})
+	assert.ErrorIs(t, err, ErrJWTSigningOff)
+	_, err = JWKS(context.Background())
+	assert.ErrorIs(t, err, ErrJWTSigningOff)
+
+	// Keys in KMS are rotated in KMS, not by the API
+	useSigner(t, newKMSSigner(newFakeKMS(t, "alias/tokens"), "alias/tokens", ""))
+	_, err = RotateJWTKey(context.Background())
+	assert.ErrorIs(t, err, ErrJWTSigningOff)
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/awsconfig"
+)
+
+// How long fetched signing keys are trusted, and how often an unknown key ID
+// may trigger a fetch
+const (
+	jwksTTL        = time.Hour
+	jwksMinRefresh = time.Minute
+)
+
+// errUnknownKey is returned for tokens signed by a key that isn't published
+var errUnknownKey = errors.New("token signed by an unknown key")
+
+// keySet caches the RSA signing keys published at a JWKS URL. Keys are
+// fetched again once they are older than jwksTTL, and as soon as a token
+// names a key ID that isn't cached, so tokens signed after a key rotation
+// verify without waiting for the cache to expire. Unknown key IDs trigger at
+// most one fetch per jwksMinRefresh, so forged tokens can't hammer the URL.
+// Only one fetch runs at a time, without holding the lock: cached keys keep
+// verifying while it runs, and callers that need it wait for its result.
+type keySet struct {
+	url    string
+	client *http.Client
+	now    func() time.Time
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// refreshing is closed when the fetch in flight finishes; nil when none is
+	refreshing chan struct{}
+	fetchErr   error
+}
+
+func newKeySet(url string) *keySet {
+	return &keySet{
+		url:    url,
+		client: awsconfig.NewHTTPClient(10 * time.Second),
+		now:    time.Now,
+	}
+}
+
+// key returns the public key with the given key ID
+func (s *keySet) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	now := s.now()
+	stale := now.Sub(s.fetchedAt) > jwksTTL
+	if k, ok := s.keys[kid]; ok && !stale {
+		s.mu.Unlock()
+		return k, nil
+	}
+	done := s.refreshing
+	if done == nil && (stale || now.Sub(s.attemptedAt) >= jwksMinRefresh) {
+		s.attemptedAt = now
+		done = make(chan struct{})
+		s.refreshing = done
+		// The fetch is shared, so it isn't cut short when this caller gives up
+		go s.refresh(context.WithoutCancel(ctx), done)
+	}
+	s.mu.Unlock()
+
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.keys[kid]; ok {
+		return k, nil
+	}
+	if s.keys == nil && s.fetchErr != nil {
+		return nil, s.fetchErr
+	}
+	return nil, errUnknownKey
+}
+
+// refresh fetches the keys and closes done
+func (s *keySet) refresh(ctx context.Context, done chan struct{}) {
+	keys, err := s.fetch(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetchErr = err
+	if err != nil {
+		// Keep serving the keys we have; the issuer may be briefly down
+		log.Printf("Error fetching signing keys from %s: %v", s.url, err)
+	} else {
+		s.keys, s.fetchedAt = keys, s.now()
+	}
+	s.refreshing = nil
+	close(done)
+}
+
+// KeySetDocument is a JWKS document
+type KeySetDocument struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwk is an entry of a JWKS document; only RSA signing keys are used
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// newJWK publishes an RSA key that verifies RS256 signatures under kid
+func newJWK(kid string, pub *rsa.PublicKey) jwk {
+	return jwk{
+		Kid: kid,
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func (s *keySet) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var doc KeySetDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decoding key set: %v", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		pub, err := k.rsaKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", k.Kid, err)
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %v", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid exponent")
+	}
+	var exp int
+	for _, b := range e {
+		exp = exp<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, nil
+}
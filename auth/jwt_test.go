@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// jwksServer publishes a changeable set of RSA keys and counts fetches
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	s := &jwksServer{keys: make(map[string]*rsa.PrivateKey)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		var doc struct {
+			Keys []jwk `json:"keys"`
+		}
+		for kid, key := range s.keys {
+			doc.Keys = append(doc.Keys, jwk{
+				Kid: kid,
+				Kty: "RSA",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) addKey(t *testing.T, kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	s.mu.Lock()
+	s.keys[kid] = key
+	s.mu.Unlock()
+	return key
+}
+
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	assert.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	srv := newJWKSServer(t)
+	key := srv.addKey(t, "k1")
+	keys := newKeySet(srv.URL)
+	ctx := context.Background()
+	now := time.Now()
+
+	token := signJWT(t, key, "k1", map[string]interface{}{"sub": "u1", "exp": now.Add(time.Hour).Unix()})
+	claims, err := verifyJWT(ctx, token, keys, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "u1", claims.string("sub"))
+
+	_, err = verifyJWT(ctx, token, keys, now.Add(2*time.Hour))
+	assert.ErrorIs(t, err, errJWTExpired)
+
+	// Swapping in another payload breaks the signature
+	other := signJWT(t, key, "k1", map[string]interface{}{"sub": "u2", "exp": now.Add(time.Hour).Unix()})
+	tp, op := strings.Split(token, "."), strings.Split(other, ".")
+	_, err = verifyJWT(ctx, tp[0]+"."+op[1]+"."+tp[2], keys, now)
+	assert.EqualError(t, err, "invalid signature")
+
+	// A key this server didn't publish is unknown
+	stranger, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, err = verifyJWT(ctx, signJWT(t, stranger, "k9", map[string]interface{}{"exp": now.Add(time.Hour).Unix()}), keys, now)
+	assert.ErrorIs(t, err, errUnknownKey)
+}
+
+func TestKeySetRotation(t *testing.T) {
+	srv := newJWKSServer(t)
+	key1 := srv.addKey(t, "k1")
+	keys := newKeySet(srv.URL)
+	clock := time.Now()
+	keys.now = func() time.Time { return clock }
+	ctx := context.Background()
+
+	_, err := keys.key(ctx, "k1")
+	assert.NoError(t, err)
+	_, err = keys.key(ctx, "k1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, srv.fetches, "keys are cached")
+
+	// A rotated-in key is picked up on first sight, but unknown key IDs only
+	// trigger a fetch once per jwksMinRefresh
+	srv.addKey(t, "k2")
+	_, err = keys.key(ctx, "k3")
+	assert.ErrorIs(t, err, errUnknownKey)
+	assert.Equal(t, 1, srv.fetches)
+
+	clock = clock.Add(jwksMinRefresh)
+	k2, err := keys.key(ctx, "k2")
+	assert.NoError(t, err)
+	assert.NotNil(t, k2)
+	assert.Equal(t, 2, srv.fetches)
+
+	// Retired keys disappear once the cache expires
+	srv.mu.Lock()
+	delete(srv.keys, "k1")
+	srv.mu.Unlock()
+	k1, err := keys.key(ctx, "k1")
+	assert.NoError(t, err)
+	assert.Equal(t, &key1.PublicKey, k1)
+
+	clock = clock.Add(jwksTTL + time.Second)
+	_, err = keys.key(ctx, "k1")
+	assert.ErrorIs(t, err, errUnknownKey)
+}
+
+func TestKeySetFetchesOnce(t *testing.T) {
+	srv := newJWKSServer(t)
+	srv.addKey(t, "k1")
+	keys := newKeySet(srv.URL)
+	clock := time.Now()
+	keys.now = func() time.Time { return clock }
+	ctx := context.Background()
+
+	// Lookups made while a fetch is in flight wait for it instead of
+	// fetching again
+	srv.mu.Lock()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := keys.key(ctx, "k1")
+			assert.NoError(t, err)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	srv.mu.Unlock()
+	wg.Wait()
+
+	// Cached keys verify while a fetch is stuck, and callers that give up
+	// don't wait for it
+	srv.addKey(t, "k2")
+	clock = clock.Add(jwksMinRefresh)
+	srv.mu.Lock()
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err := keys.key(short, "k2")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = keys.key(ctx, "k1")
+	assert.NoError(t, err)
+	srv.mu.Unlock()
+
+	_, err = keys.key(ctx, "k2")
+	assert.NoError(t, err)
+	srv.mu.Lock()
+	assert.Equal(t, 2, srv.fetches)
+	srv.mu.Unlock()
+}
+
+func TestCheckCognitoClaims(t *testing.T) {
+	const issuer = "https://cognito-idp.us-east-1.amazonaws.com/us-east-1_pool"
+	access := jwtClaims{"iss": issuer, "sub": "u1", "token_use": "access", "client_id": "app"}
+	assert.NoError(t, checkCognitoClaims(access, issuer, "app"))
+	assert.Error(t, checkCognitoClaims(access, issuer, "other-app"))
+	assert.Error(t, checkCognitoClaims(access, "https://evil.example", "app"))
+
+	id := jwtClaims{"iss": issuer, "sub": "u1", "token_use": "id", "aud": "app"}
+	assert.NoError(t, checkCognitoClaims(id, issuer, "app"))
+
+	refresh := jwtClaims{"iss": issuer, "sub": "u1", "token_use": "refresh", "client_id": "app"}
+	assert.Error(t, checkCognitoClaims(refresh, issuer, "app"))
+}
+
+func TestRoleMapping(t *testing.T) {
+	m, err := ParseRoleMapping("Admins=admin, Staff = user,")
+	assert.NoError(t, err)
+	assert.Equal(t, RoleMapping{"Admins": RoleAdmin, "Staff": RoleUser}, m)
+
+	assert.Equal(t, []string{RoleUser}, m.Roles(nil))
+	assert.Equal(t, []string{RoleUser, RoleAdmin}, m.Roles([]string{"Staff", "Admins", "Unmapped"}))
+
+	claims := jwtClaims{"cognito:groups": []interface{}{"Admins"}}
+	user := &MockUser{Username: "alice", Roles: m.Roles(claims.strings("cognito:groups"))}
+	assert.True(t, NewPrincipal(user, MethodCognito).IsAdmin())
+
+	_, err = ParseRoleMapping("Admins=root")
+	assert.Error(t, err)
+	_, err = ParseRoleMapping("admin")
+	assert.Error(t, err)
+}
@@ -1,38 +1,61 @@
 package auth
 
 import (
+	"context"
 	"net/http"
 	"strings"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
 )
 
-// AuthMiddleware verifies the JWT token from the Authorization header
+// AuthMiddleware verifies the Cognito JWT from the Authorization header
+// against the pool's cached signing keys and attaches its principal, with the
+// roles mapped from the user's groups
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get the Authorization header
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "Authorization header is required", http.StatusUnauthorized)
+			apierrors.Respond(w, r, apierrors.CodeUnauthorized, "Authorization header is required")
 			return
 		}
 
 		// Check if the header has the Bearer prefix
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+			apierrors.Respond(w, r, apierrors.CodeTokenInvalid, "Invalid authorization header format")
 			return
 		}
 
 		// Get the token
 		token := parts[1]
 
-		// Verify the token by getting user information
-		_, err := GetUser(r.Context(), token)
+		// Verify the token locally, without a call to Cognito. API keys are
+		// issued by this API and looked up instead.
+		verify, method := VerifyToken, MethodCognito
+		if IsAPIKey(token) {
+			verify = func(ctx context.Context, key string) (*MockUser, error) { return VerifyAPIKey(key) }
+			method = MethodAPIKey
+		}
+		user, err := verify(r.Context(), token)
 		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			apierrors.Write(w, r, err)
 			return
 		}
 
-		// Token is valid, proceed to the next handler
-		next.ServeHTTP(w, r)
+		// Token is valid, proceed to the next handler with the caller attached
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), NewPrincipal(user, method))))
+	})
+}
+
+// OptionalAuthMiddleware is AuthMiddleware for endpoints that also accept
+// anonymous requests
+func OptionalAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		AuthMiddleware(next).ServeHTTP(w, r)
 	})
 }
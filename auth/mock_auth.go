@@ -3,17 +3,25 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
-	"errors"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/yourusername/golang-aws-api/apierrors"
 	"github.com/yourusername/golang-aws-api/database"
 )
 
+// Errors returned by the mock authentication functions. They carry API error
+// codes so handlers can return them as they are.
+var (
+	ErrInvalidLogin     = apierrors.New(apierrors.CodeInvalidCredentials, "invalid username or password")
+	ErrUserNotConfirmed = apierrors.New(apierrors.CodeUserNotConfirmed, "user not confirmed")
+	ErrInvalidToken     = apierrors.New(apierrors.CodeTokenInvalid, "invalid token")
+)
+
 // MockUser represents a user in our mock authentication system
 type MockUser struct {
 	ID          string
@@ -23,6 +31,14 @@ type MockUser struct {
 	Confirmed   bool
 	AccessToken string
 	CreatedAt   time.Time
+	// Roles are the internal roles the user holds
+	Roles []string
+	// Scopes limit what the credential the user authenticated with may do;
+	// nil means unrestricted
+	Scopes []string
+	// AllowedCIDRs are the only ranges the credential may be used from;
+	// empty allows any address
+	AllowedCIDRs []string
 }
 
 // MockAuthProvider provides mock authentication functionality
@@ -41,34 +57,25 @@ func GenerateToken() string {
 	return base64.StdEncoding.EncodeToString(b)
 }
 
-// MockSignUp registers a new user in the mock system
+// MockSignUp registers a new user in the mock system. To avoid revealing
+// which usernames and emails are registered, a taken username or email isn't
+// an error: nil is returned and the caller must respond exactly as for a new
+// user. The reason is only logged.
 func MockSignUp(ctx context.Context, username, password, email string) (*MockUser, error) {
+	defer padTiming(time.Now())
 	mockProvider.mu.Lock()
 	defer mockProvider.mu.Unlock()
 
-	// Check if user already exists
-	existingUser, err := database.GetUserByUsername(username)
-	if err != nil {
-		return nil, err
-	}
-	if existingUser != nil {
-		return nil, errors.New("user already exists")
-	}
-
-	// Check if email already exists
-	existingEmail, err := database.GetUserByEmail(email)
-	if err != nil {
-		return nil, err
-	}
-	if existingEmail != nil {
-		return nil, errors.New("email already exists")
-	}
-
-	// Create new user in database
+	// Create new user in database, if neither the username nor the email is
+	// taken. A single statement keeps both outcomes equally fast.
 	dbUser, err := database.SaveUser(username, password, email)
 	if err != nil {
 		return nil, err
 	}
+	if dbUser == nil {
+		logAuthFailure("sign-up", username, reasonAccountTaken)
+		return nil, nil
+	}
 
 	// Convert database user to mock user
 	user := &MockUser{
@@ -83,56 +90,95 @@ func MockSignUp(ctx context.Context, username, password, email string) (*MockUse
 	return user, nil
 }
 
-// MockConfirmSignUp confirms a user's registration
+// MockConfirmSignUp confirms a user's registration. Confirming an unknown
+// username succeeds without doing anything, so it can't be used to probe for
+// accounts.
 func MockConfirmSignUp(ctx context.Context, username, code string) error {
+	defer padTiming(time.Now())
 	mockProvider.mu.Lock()
 	defer mockProvider.mu.Unlock()
 
-	// Check if user exists
-	user, err := database.GetUserByUsername(username)
-	if err != nil {
-		return err
-	}
-	if user == nil {
-		return errors.New("user not found")
-	}
-
 	// In a real system, we would verify the code
 	// For mock purposes, we'll just confirm the user
-	err = database.ConfirmUser(username)
+	confirmed, err := database.ConfirmUser(username)
 	if err != nil {
 		return err
 	}
-
+	if !confirmed {
+		logAuthFailure("confirmation", username, reasonUnknownUser)
+	}
 	return nil
 }
 
-// MockSignIn authenticates a user
-func MockSignIn(ctx context.Context, username, password string) (*MockUser, error) {
-	mockProvider.mu.RLock()
-	defer mockProvider.mu.RUnlock()
+// MockAuthenticate checks a username and password against the users table
+// without issuing a token, for gateways that keep their own sessions. Unknown
+// users and wrong passwords fail alike, with ErrInvalidLogin after the same
+// delay.
+func MockAuthenticate(username, password string) (*database.User, error) {
+	defer padTiming(time.Now())
+	return authenticate(username, password)
+}
 
+// authenticate is MockAuthenticate without the timing padding
+func authenticate(username, password string) (*database.User, error) {
 	// Check if user exists
 	user, err := database.GetUserByUsername(username)
 	if err != nil {
 		return nil, err
 	}
-	if user == nil {
-		return nil, errors.New("user not found")
-	}
 
-	// Check if password matches
-	if user.Password != password {
-		return nil, errors.New("invalid password")
+	// Compare against something even for unknown users, so they take as
+	// long as a wrong password
+	stored := unknownUserPassword
+	if user != nil {
+		stored = user.Password
+	}
+	match := subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	switch {
+	case user == nil:
+		logAuthFailure("sign-in", username, reasonUnknownUser)
+		return nil, ErrInvalidLogin
+	case user.Password == "":
+		// Users who sign in through an external provider have no password
+		// and can't use this flow
+		logAuthFailure("sign-in", username, reasonNoPassword)
+		return nil, ErrInvalidLogin
+	case !match:
+		logAuthFailure("sign-in", username, reasonWrongPassword)
+		return nil, ErrInvalidLogin
 	}
 
-	// Check if user is confirmed
+	// Only the account's owner gets this far, so telling them the account
+	// still needs confirming reveals nothing
 	if !user.Confirmed {
-		return nil, errors.New("user not confirmed")
+		return nil, ErrUserNotConfirmed
+	}
+	return user, nil
+}
+
+// MockSignIn authenticates a user. The access token is limited to scopes,
+// or unrestricted if scopes is nil.
+func MockSignIn(ctx context.Context, username, password string, scopes []string) (*MockUser, error) {
+	defer padTiming(time.Now())
+	mockProvider.mu.RLock()
+	defer mockProvider.mu.RUnlock()
+
+	user, err := authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if scopes != nil {
+		if scopes, err = ParseScopes(scopes); err != nil {
+			return nil, apierrors.New(apierrors.CodeInvalidParameter, err.Error())
+		}
 	}
 
 	// Generate access token
 	accessToken := GenerateToken()
+	if err := database.SaveAccessToken(accessToken, user.ID, time.Now().Add(tokenTTL()), scopes); err != nil {
+		return nil, err
+	}
 
 	// Convert database user to mock user
 	mockUser := &MockUser{
@@ -143,6 +189,8 @@ func MockSignIn(ctx context.Context, username, password string) (*MockUser, erro
 		Confirmed:   user.Confirmed,
 		AccessToken: accessToken,
 		CreatedAt:   user.CreatedAt,
+		Roles:       []string{RoleUser},
+		Scopes:      scopes,
 	}
 
 	return mockUser, nil
@@ -153,22 +201,42 @@ func MockGetUser(ctx context.Context, accessToken string) (*MockUser, error) {
 	mockProvider.mu.RLock()
 	defer mockProvider.mu.RUnlock()
 
-	// In a real system, we would verify the token
-	// For mock purposes, we'll just return a mock user
+	if IsAPIKey(accessToken) {
+		return VerifyAPIKey(accessToken)
+	}
+
+	user, scopes, err := database.GetUserByAccessToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidToken
+	}
+
 	return &MockUser{
-		ID:          uuid.New().String(),
-		Username:    "mockuser",
+		ID:          user.ID,
+		Username:    user.Username,
+		Email:       user.Email,
 		AccessToken: accessToken,
-		Confirmed:   true,
-		CreatedAt:   time.Now(),
+		Confirmed:   user.Confirmed,
+		CreatedAt:   user.CreatedAt,
+		Roles:       []string{RoleUser},
+		Scopes:      scopes,
 	}, nil
 }
 
 // MockSignOut signs out a user
 func MockSignOut(ctx context.Context, accessToken string) error {
-	// In a real system, we would invalidate the token
-	// For mock purposes, we'll just return success
-	return nil
+	return database.DeleteAccessToken(accessToken)
+}
+
+// tokenTTL returns how long issued access tokens stay valid
+func tokenTTL() time.Duration {
+	ttl, err := time.ParseDuration(getEnv("TOKEN_TTL", "24h"))
+	if err != nil {
+		return 24 * time.Hour
+	}
+	return ttl
 }
 
 // MockInit initializes the mock authentication system
@@ -182,14 +250,14 @@ func MockAuthMiddleware(next http.Handler) http.Handler {
 		// Get the Authorization header
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "Authorization header is required", http.StatusUnauthorized)
+			apierrors.Respond(w, r, apierrors.CodeUnauthorized, "Authorization header is required")
 			return
 		}
 
 		// Check if the header has the Bearer prefix
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+			apierrors.Respond(w, r, apierrors.CodeTokenInvalid, "Invalid authorization header format")
 			return
 		}
 
@@ -197,13 +265,29 @@ func MockAuthMiddleware(next http.Handler) http.Handler {
 		token := parts[1]
 
 		// Verify the token by getting user information
-		_, err := MockGetUser(r.Context(), token)
+		user, err := MockGetUser(r.Context(), token)
 		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			apierrors.Write(w, r, err)
 			return
 		}
 
-		// Token is valid, proceed to the next handler
-		next.ServeHTTP(w, r)
+		// Token is valid, proceed to the next handler with the caller attached
+		method := MethodSession
+		if IsAPIKey(token) {
+			method = MethodAPIKey
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), NewPrincipal(user, method))))
+	})
+}
+
+// MockOptionalAuthMiddleware attaches the caller to the request when a valid
+// token is supplied but lets anonymous requests through
+func MockOptionalAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		MockAuthMiddleware(next).ServeHTTP(w, r)
 	})
 }
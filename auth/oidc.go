@@ -0,0 +1,443 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// Provider types. OIDC providers return an ID token that is verified against
+// the provider's keys; GitHub only speaks OAuth2, so the user and their
+// verified emails are read from its REST API.
+const (
+	ProviderOIDC   = "oidc"
+	ProviderGitHub = "github"
+)
+
+// OAuthStateTTL is how long a user has to finish logging in at the provider
+const OAuthStateTTL = 10 * time.Minute
+
+// ErrLoginFailed is returned when a login at an external provider can't be
+// completed
+var ErrLoginFailed = apierrors.New(apierrors.CodeLoginFailed, "login with the external provider failed")
+
+// OIDCProvider is an external identity provider users can log in with
+type OIDCProvider struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	AuthURL      string   `json:"auth_url"`
+	TokenURL     string   `json:"token_url"`
+	Scopes       []string `json:"scopes"`
+	// ID tokens must be signed by a key at JWKSURL and issued by one of
+	// Issuers (OIDC providers only)
+	JWKSURL string   `json:"jwks_url"`
+	Issuers []string `json:"issuers"`
+	// APIURL is the REST API base (GitHub only)
+	APIURL string `json:"api_url"`
+	// RedirectURL is this API's callback URL registered with the provider
+	RedirectURL string `json:"redirect_url"`
+
+	keys *keySet
+}
+
+// builtinProviders are the presets enabled by setting OIDC_<NAME>_CLIENT_ID
+// and OIDC_<NAME>_CLIENT_SECRET
+var builtinProviders = []OIDCProvider{
+	{
+		Name:     "google",
+		Type:     ProviderOIDC,
+		AuthURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL: "https://oauth2.googleapis.com/token",
+		Scopes:   []string{"openid", "email", "profile"},
+		JWKSURL:  "https://www.googleapis.com/oauth2/v3/certs",
+		Issuers:  []string{"https://accounts.google.com", "accounts.google.com"},
+	},
+	{
+		Name:     "github",
+		Type:     ProviderGitHub,
+		AuthURL:  "https://github.com/login/oauth/authorize",
+		TokenURL: "https://github.com/login/oauth/access_token",
+		Scopes:   []string{"read:user", "user:email"},
+		APIURL:   "https://api.github.com",
+	},
+}
+
+var (
+	oidcProviders = map[string]*OIDCProvider{}
+	oidcClient    = awsconfig.NewHTTPClient(10 * time.Second)
+)
+
+// InitOIDC configures the external login providers. Built-in providers are
+// enabled through OIDC_GOOGLE_CLIENT_ID/SECRET and OIDC_GITHUB_CLIENT_ID/SECRET;
+// OIDC_CONFIG_FILE may hold a JSON list of further providers or overrides.
+// Callback URLs are built from OIDC_REDIRECT_BASE_URL unless a provider sets
+// its own.
+func InitOIDC() error {
+	providers := map[string]*OIDCProvider{}
+	for _, preset := range builtinProviders {
+		p := preset
+		prefix := "OIDC_" + strings.ToUpper(p.Name) + "_"
+		p.ClientID = os.Getenv(prefix + "CLIENT_ID")
+		p.ClientSecret = os.Getenv(prefix + "CLIENT_SECRET")
+		if p.ClientID != "" {
+			providers[p.Name] = &p
+		}
+	}
+
+	if path := os.Getenv("OIDC_CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %v", path, err)
+		}
+		var configured []OIDCProvider
+		if err := json.Unmarshal(data, &configured); err != nil {
+			return fmt.Errorf("parsing %s: %v", path, err)
+		}
+		for i := range configured {
+			providers[configured[i].Name] = &configured[i]
+		}
+	}
+
+	base := strings.TrimRight(getEnv("OIDC_REDIRECT_BASE_URL", "http://localhost:8080"), "/")
+	for name, p := range providers {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("OIDC provider %s: %v", name, err)
+		}
+		if p.RedirectURL == "" {
+			p.RedirectURL = base + "/api/auth/oidc/" + name + "/callback"
+		}
+		if p.JWKSURL != "" {
+			p.keys = newKeySet(p.JWKSURL)
+		}
+	}
+	oidcProviders = providers
+	return nil
+}
+
+func (p *OIDCProvider) validate() error {
+	if p.Name == "" || p.ClientID == "" || p.AuthURL == "" || p.TokenURL == "" {
+		return errors.New("name, client_id, auth_url and token_url are required")
+	}
+	switch p.Type {
+	case ProviderOIDC:
+		if p.JWKSURL == "" || len(p.Issuers) == 0 {
+			return errors.New("jwks_url and issuers are required for oidc providers")
+		}
+	case ProviderGitHub:
+		if p.APIURL == "" {
+			return errors.New("api_url is required for github providers")
+		}
+	default:
+		return fmt.Errorf("type must be %s or %s, got %q", ProviderOIDC, ProviderGitHub, p.Type)
+	}
+	return nil
+}
+
+// OIDCProviderByName returns a configured provider, or nil
+func OIDCProviderByName(name string) *OIDCProvider {
+	return oidcProviders[name]
+}
+
+// OIDCProviderNames lists the configured providers, sorted
+func OIDCProviderNames() []string {
+	names := make([]string, 0, len(oidcProviders))
+	for name := range oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StartLogin records a pending login and returns the provider URL to send the
+// user to, along with the login's state parameter. The login uses the
+// authorization code flow with a PKCE S256 challenge, and a nonce for
+// providers that return an ID token.
+func (p *OIDCProvider) StartLogin() (string, string, error) {
+	state := database.OAuthState{
+		State:        randomToken(),
+		Provider:     p.Name,
+		CodeVerifier: randomToken(),
+		Nonce:        randomToken(),
+		ExpiresAt:    time.Now().Add(OAuthStateTTL),
+	}
+	if err := database.SaveOAuthState(state); err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(state.CodeVerifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state.State},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if p.Type == ProviderOIDC {
+		q.Set("nonce", state.Nonce)
+	}
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + q.Encode(), state.State, nil
+}
+
+// randomToken returns 32 random bytes, base64url encoded as PKCE verifiers
+// require
+func randomToken() string {
+	return strings.NewReplacer("+", "-", "/", "_", "=", "").Replace(GenerateToken())
+}
+
+// externalIdentity is who the provider says the user is
+type externalIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+// FinishLogin completes a login from the provider's callback: it checks the
+// state, exchanges the code, and signs in the user linked to the identity.
+// Unknown identities are linked to the confirmed local user with the same
+// verified email, or get a new user. The returned user carries a fresh access token.
+func (p *OIDCProvider) FinishLogin(ctx context.Context, code, stateParam string) (*MockUser, error) {
+	state, err := database.ConsumeOAuthState(stateParam)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil || state.Provider != p.Name {
+		return nil, apierrors.New(apierrors.CodeLoginFailed, "login expired or was already used, start again")
+	}
+
+	token, err := p.exchange(ctx, code, state.CodeVerifier)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLoginFailed, err)
+	}
+	var id *externalIdentity
+	if p.Type == ProviderGitHub {
+		id, err = p.githubIdentity(ctx, token.AccessToken)
+	} else {
+		id, err = p.idTokenIdentity(ctx, token.IDToken, state.Nonce)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLoginFailed, err)
+	}
+
+	user, err := p.linkedUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken := GenerateToken()
+	if err := database.SaveAccessToken(accessToken, user.ID, time.Now().Add(tokenTTL()), nil); err != nil {
+		return nil, err
+	}
+	return &MockUser{
+		ID:          user.ID,
+		Username:    user.Username,
+		Email:       user.Email,
+		Confirmed:   user.Confirmed,
+		AccessToken: accessToken,
+		CreatedAt:   user.CreatedAt,
+		Roles:       []string{RoleUser},
+	}, nil
+}
+
+// linkedUser finds or creates the local user for an external identity
+func (p *OIDCProvider) linkedUser(id *externalIdentity) (*database.User, error) {
+	user, err := database.GetUserByIdentity(p.Name, id.Subject)
+	if err != nil || user != nil {
+		return user, err
+	}
+
+	// Only an address the provider has verified may claim a local account
+	if id.Email == "" || !id.EmailVerified {
+		return nil, apierrors.New(apierrors.CodeLoginFailed, "the provider account has no verified email address")
+	}
+	user, err = database.GetUserByEmail(id.Email)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		// An unconfirmed account may have been registered by someone who
+		// doesn't own the address; its owner has to confirm it first
+		if !user.Confirmed {
+			return nil, apierrors.New(apierrors.CodeLoginFailed, "an unconfirmed account uses this email address, confirm it before logging in with the provider")
+		}
+		if err := database.LinkIdentity(user.ID, p.Name, id.Subject, id.Email); err != nil {
+			return nil, err
+		}
+		user.Password = ""
+		return user, nil
+	}
+
+	username := id.Username
+	if username == "" {
+		username, _, _ = strings.Cut(id.Email, "@")
+	}
+	return database.CreateLinkedUser(username, id.Email, p.Name, id.Subject)
+}
+
+// tokenResponse is the provider's answer to the code exchange
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchange trades an authorization code and its PKCE verifier for tokens
+func (p *OIDCProvider) exchange(ctx context.Context, code, verifier string) (*tokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token tokenResponse
+	if err := doJSON(req, &token); err != nil {
+		return nil, fmt.Errorf("exchanging code: %v", err)
+	}
+	// GitHub reports errors with a 200 status
+	if token.Error != "" {
+		return nil, fmt.Errorf("exchanging code: %s: %s", token.Error, token.ErrorDescription)
+	}
+	return &token, nil
+}
+
+// idTokenIdentity verifies an OIDC ID token issued to this client for the
+// login with the given nonce
+func (p *OIDCProvider) idTokenIdentity(ctx context.Context, idToken, nonce string) (*externalIdentity, error) {
+	if idToken == "" {
+		return nil, errors.New("provider returned no ID token")
+	}
+	claims, err := verifyJWT(ctx, idToken, p.keys, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+	if !containsString(p.Issuers, claims.string("iss")) {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.string("iss"))
+	}
+	if !containsString(claims.strings("aud"), p.ClientID) {
+		return nil, errors.New("ID token was issued to another client")
+	}
+	if claims.string("nonce") != nonce {
+		return nil, errors.New("ID token nonce doesn't match the login")
+	}
+	if claims.string("sub") == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+
+	// Some providers send email_verified as a string
+	verified, _ := claims["email_verified"].(bool)
+	if s := claims.string("email_verified"); s != "" {
+		verified, _ = strconv.ParseBool(s)
+	}
+	return &externalIdentity{
+		Subject:       claims.string("sub"),
+		Email:         strings.ToLower(claims.string("email")),
+		EmailVerified: verified,
+		Username:      claims.string("preferred_username"),
+	}, nil
+}
+
+// githubIdentity reads the GitHub user and their primary verified email
+func (p *OIDCProvider) githubIdentity(ctx context.Context, accessToken string) (*externalIdentity, error) {
+	var user struct {
+		ID    json.Number `json:"id"`
+		Login string      `json:"login"`
+	}
+	if err := p.githubGet(ctx, accessToken, "/user", &user); err != nil {
+		return nil, err
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.githubGet(ctx, accessToken, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	id := &externalIdentity{Subject: user.ID.String(), Username: user.Login}
+	for _, e := range emails {
+		if e.Verified && (e.Primary || id.Email == "") {
+			id.Email, id.EmailVerified = strings.ToLower(e.Email), true
+		}
+	}
+	if id.Subject == "" {
+		return nil, errors.New("GitHub returned no user ID")
+	}
+	return id, nil
+}
+
+func (p *OIDCProvider) githubGet(ctx context.Context, accessToken, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(p.APIURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if err := doJSON(req, v); err != nil {
+		return fmt.Errorf("GET %s: %v", path, err)
+	}
+	return nil
+}
+
+// doJSON sends req and decodes a successful JSON response into v
+func doJSON(req *http.Request, v interface{}) error {
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Token endpoints describe errors in the body; keep it short in logs
+		if len(body) > 200 {
+			body = body[:200]
+		}
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return json.Unmarshal(body, v)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
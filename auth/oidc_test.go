@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitOIDC(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "oidc.json")
+	os.WriteFile(config, []byte(`[{
+		"name": "corp", "type": "oidc", "client_id": "corp-app",
+		"auth_url": "https://sso.example.com/authorize", "token_url": "https://sso.example.com/token",
+		"jwks_url": "https://sso.example.com/keys", "issuers": ["https://sso.example.com"]
+	}]`), 0o600)
+	t.Setenv("OIDC_GITHUB_CLIENT_ID", "gh-app")
+	t.Setenv("OIDC_GITHUB_CLIENT_SECRET", "gh-secret")
+	t.Setenv("OIDC_CONFIG_FILE", config)
+	t.Setenv("OIDC_REDIRECT_BASE_URL", "https://files.example.com/")
+
+	assert.NoError(t, InitOIDC())
+	assert.Equal(t, []string{"corp", "github"}, OIDCProviderNames())
+	gh := OIDCProviderByName("github")
+	assert.Equal(t, "gh-secret", gh.ClientSecret)
+	assert.Equal(t, "https://files.example.com/api/auth/oidc/github/callback", gh.RedirectURL)
+	assert.NotNil(t, OIDCProviderByName("corp").keys)
+	assert.Nil(t, OIDCProviderByName("google"))
+
+	os.WriteFile(config, []byte(`[{"name": "corp", "type": "oidc", "client_id": "x", "auth_url": "a", "token_url": "t"}]`), 0o600)
+	assert.Error(t, InitOIDC(), "oidc providers need keys and issuers")
+}
+
+func TestIDTokenIdentity(t *testing.T) {
+	srv := newJWKSServer(t)
+	key := srv.addKey(t, "k1")
+	p := &OIDCProvider{Name: "corp", Type: ProviderOIDC, ClientID: "app", Issuers: []string{"https://sso.example.com"}, keys: newKeySet(srv.URL)}
+	ctx := context.Background()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": "https://sso.example.com", "aud": "app", "sub": "42", "nonce": "n1",
+			"email": "Alice@Example.com", "email_verified": "true", "exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	id, err := p.idTokenIdentity(ctx, signJWT(t, key, "k1", claims(nil)), "n1")
+	assert.NoError(t, err)
+	assert.Equal(t, &externalIdentity{Subject: "42", Email: "alice@example.com", EmailVerified: true}, id)
+
+	_, err = p.idTokenIdentity(ctx, signJWT(t, key, "k1", claims(nil)), "n2")
+	assert.Error(t, err, "nonce from another login")
+	_, err = p.idTokenIdentity(ctx, signJWT(t, key, "k1", claims(map[string]interface{}{"aud": "other-app"})), "n1")
+	assert.Error(t, err)
+	_, err = p.idTokenIdentity(ctx, signJWT(t, key, "k1", claims(map[string]interface{}{"iss": "https://evil.example"})), "n1")
+	assert.Error(t, err)
+
+	id, err = p.idTokenIdentity(ctx, signJWT(t, key, "k1", claims(map[string]interface{}{"email_verified": false})), "n1")
+	assert.NoError(t, err)
+	assert.False(t, id.EmailVerified)
+}
+
+func TestGitHubLogin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login/oauth/access_token":
+			r.ParseForm()
+			if r.PostForm.Get("code") != "good" || r.PostForm.Get("code_verifier") != "verifier" {
+				json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_token"})
+		case "/user":
+			if r.Header.Get("Authorization") != "Bearer gho_token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"id": 1234, "login": "octocat"}`))
+		case "/user/emails":
+			w.Write([]byte(`[
+				{"email": "old@example.com", "primary": false, "verified": true},
+				{"email": "unverified@example.com", "primary": false, "verified": false},
+				{"email": "Octo@Example.com", "primary": true, "verified": true}
+			]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	p := &OIDCProvider{Name: "github", Type: ProviderGitHub, ClientID: "app", TokenURL: srv.URL + "/login/oauth/access_token", APIURL: srv.URL}
+	ctx := context.Background()
+
+	_, err := p.exchange(ctx, "bad", "verifier")
+	assert.ErrorContains(t, err, "bad_verification_code")
+
+	token, err := p.exchange(ctx, "good", "verifier")
+	assert.NoError(t, err)
+	id, err := p.githubIdentity(ctx, token.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, &externalIdentity{Subject: "1234", Email: "octo@example.com", EmailVerified: true, Username: "octocat"}, id)
+
+	_, err = p.githubIdentity(ctx, "stolen")
+	assert.Error(t, err)
+}
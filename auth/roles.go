@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Roles a user can hold. Every signed in user is a RoleUser; RoleAdmin also
+// grants the administrator endpoints, and RolePIIReader the original content
+// of files that have a redacted copy.
+const (
+	RoleAdmin     = "admin"
+	RoleUser      = "user"
+	RolePIIReader = "pii_reader"
+)
+
+// validRole reports whether role is one of the internal roles
+func validRole(role string) bool {
+	return role == RoleAdmin || role == RoleUser || role == RolePIIReader
+}
+
+// RoleMapping maps values of an identity provider's group claim, such as
+// Cognito group names, to internal roles
+type RoleMapping map[string]string
+
+// ParseRoleMapping parses a comma-separated list of group=role pairs, e.g.
+// "Admins=admin,Staff=user"
+func ParseRoleMapping(s string) (RoleMapping, error) {
+	m := make(RoleMapping)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" {
+			return nil, fmt.Errorf("invalid role mapping %q, want group=role", pair)
+		}
+		if !validRole(role) {
+			return nil, fmt.Errorf("unknown role %q for group %s", role, group)
+		}
+		m[group] = role
+	}
+	return m, nil
+}
+
+// Roles returns the roles granted by groups. Groups without a mapping are
+// ignored, and RoleUser is always included.
+func (m RoleMapping) Roles(groups []string) []string {
+	roles := []string{RoleUser}
+	seen := map[string]bool{RoleUser: true}
+	for _, group := range groups {
+		if role, ok := m[group]; ok && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes([]string{"files:read", " results:read", "files:read"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ScopeFilesRead, ScopeResultsRead}, scopes)
+
+	_, err = ParseScopes([]string{"files:delete"})
+	assert.Error(t, err)
+	_, err = ParseScopes(nil)
+	assert.Error(t, err)
+}
+
+func TestRequireScope(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	call := func(p *Principal, h http.HandlerFunc) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/files", nil)
+		if p != nil {
+			r = r.WithContext(WithPrincipal(r.Context(), p))
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+
+	session := &Principal{UserID: "u1", Method: MethodSession, Roles: []string{RoleUser}}
+	reader := &Principal{UserID: "u1", Method: MethodAPIKey, Roles: []string{RoleUser}, Scopes: []string{ScopeFilesRead}}
+
+	assert.Equal(t, http.StatusNoContent, call(session, RequireScope(ScopeFilesWrite, ok)))
+	assert.Equal(t, http.StatusNoContent, call(reader, RequireScope(ScopeFilesRead, ok)))
+	assert.Equal(t, http.StatusForbidden, call(reader, RequireScope(ScopeFilesWrite, ok)))
+	assert.Equal(t, http.StatusNoContent, call(nil, RequireScope(ScopeFilesWrite, ok)), "anonymous requests are left to the auth middleware")
+
+	assert.Equal(t, http.StatusNoContent, call(session, RequireUnscoped(ok)))
+	assert.Equal(t, http.StatusForbidden, call(reader, RequireUnscoped(ok)))
+}
+
+func TestScopedAdmin(t *testing.T) {
+	admin := &Principal{Username: "root", Roles: []string{RoleUser, RoleAdmin}}
+	assert.True(t, admin.IsAdmin())
+	admin.Scopes = []string{ScopeFilesRead}
+	assert.False(t, admin.IsAdmin(), "a scoped credential needs the admin scope")
+	admin.Scopes = append(admin.Scopes, ScopeAdmin)
+	assert.True(t, admin.IsAdmin())
+
+	user := &Principal{Username: "bob", Roles: []string{RoleUser}, Scopes: []string{ScopeAdmin}}
+	assert.False(t, user.IsAdmin(), "the admin scope doesn't make a user an administrator")
+}
+
+func TestCognitoScopes(t *testing.T) {
+	assert.Nil(t, cognitoScopes("aws.cognito.signin.user.admin"))
+	assert.Equal(t, []string{ScopeFilesRead, ScopeResultsRead},
+		cognitoScopes("files-api/files:read files-api/results:read other/files:read"))
+}
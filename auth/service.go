@@ -0,0 +1,376 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+// Internal callers such as Lambda functions and batch jobs can authenticate
+// as a service account instead of a user, in one of two ways:
+//
+//   - A SigV4 service token: a presigned STS GetCallerIdentity URL sent as a
+//     Bearer token. The API replays it against STS, so the caller is whoever
+//     IAM says signed it, and never has to share its credentials.
+//   - A TLS client certificate issued by the CA in TLS_CLIENT_CA_FILE.
+//
+// SERVICE_PRINCIPALS maps callers to service accounts, e.g.
+// "arn:aws:iam::123456789012:role/batch=batch,cn:ingest-job=ingest". Roles
+// match any session of the role; certificates match on "cn:" plus their
+// common name or "uri:" plus a URI SAN.
+const (
+	// serviceTokenPrefix marks a Bearer token as a SigV4 service token
+	serviceTokenPrefix = "aws-sts-v1."
+	// ServiceAudienceHeader is signed into service tokens so a token minted
+	// for this API can't be replayed against STS by anything else, and the
+	// other way round
+	ServiceAudienceHeader = "X-Service-Audience"
+	// serviceTokenExpiry is how long a minted service token stays valid, the
+	// longest STS allows
+	serviceTokenExpiry = 15 * time.Minute
+)
+
+// stsHost matches the global and regional STS endpoints
+var stsHost = regexp.MustCompile(`^sts(\.[a-z0-9-]+)?\.amazonaws\.com(\.cn)?$`)
+
+var (
+	servicePrincipals map[string]string
+	serviceAudience   string
+	// trustedSTSHost is the endpoint this API resolves for STS, which is
+	// accepted on top of the AWS ones so LocalStack works in development
+	trustedSTSHost string
+	stsClient      = awsconfig.NewHTTPClient(10 * time.Second)
+
+	// verifiedServiceTokens caches the principals of recently verified
+	// tokens, keyed by the token's hash, so a job making many calls doesn't
+	// cost an STS round trip each
+	verifiedServiceTokens sync.Map
+)
+
+// verifiedServiceToken is a cached service token verification
+type verifiedServiceToken struct {
+	principal string
+	expires   time.Time
+}
+
+// serviceTokenCacheTTL bounds how long a verified token is trusted without
+// asking STS again, so a deleted role loses access quickly
+const serviceTokenCacheTTL = 5 * time.Minute
+
+// InitServiceAuth configures service account authentication from
+// SERVICE_PRINCIPALS and SERVICE_AUTH_AUDIENCE (default golang-aws-api). It
+// reports whether any service accounts are configured.
+func InitServiceAuth(cfg aws.Config) (bool, error) {
+	principals, err := ParseServicePrincipals(os.Getenv("SERVICE_PRINCIPALS"))
+	if err != nil {
+		return false, fmt.Errorf("SERVICE_PRINCIPALS: %v", err)
+	}
+	servicePrincipals = principals
+	serviceAudience = getEnv("SERVICE_AUTH_AUDIENCE", "golang-aws-api")
+	if endpoint, _ := stsEndpoint(cfg); endpoint != "" {
+		if u, err := url.Parse(endpoint); err == nil {
+			trustedSTSHost = u.Host
+		}
+	}
+	return len(principals) > 0, nil
+}
+
+// ParseServicePrincipals parses a comma-separated list of principal=account
+// pairs. Principals are IAM ARNs or "cn:"/"uri:" certificate names.
+func ParseServicePrincipals(s string) (map[string]string, error) {
+	principals := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		// Certificate URIs may contain '=', account names don't
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid service principal %q, want principal=account", pair)
+		}
+		principal, account := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		if principal == "" || account == "" {
+			return nil, fmt.Errorf("invalid service principal %q, want principal=account", pair)
+		}
+		if !strings.HasPrefix(principal, "arn:") && !strings.HasPrefix(principal, "cn:") && !strings.HasPrefix(principal, "uri:") {
+			return nil, fmt.Errorf("service principal %q must be an IAM ARN, cn:<name> or uri:<uri>", principal)
+		}
+		if strings.HasPrefix(principal, "arn:") {
+			principal = normalizeARN(principal)
+		}
+		principals[principal] = account
+	}
+	return principals, nil
+}
+
+// normalizeARN maps an assumed role session, as STS reports callers running
+// under a role, to the role's ARN. Paths aren't part of session ARNs, so they
+// are dropped from role ARNs too.
+func normalizeARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 {
+		return arn
+	}
+	resource := parts[5]
+	switch {
+	case parts[2] == "sts" && strings.HasPrefix(resource, "assumed-role/"):
+		role := strings.Split(resource, "/")[1]
+		return fmt.Sprintf("arn:%s:iam::%s:role/%s", parts[1], parts[4], role)
+	case parts[2] == "iam" && strings.HasPrefix(resource, "role/"):
+		path := strings.Split(resource, "/")
+		return fmt.Sprintf("arn:%s:iam::%s:role/%s", parts[1], parts[4], path[len(path)-1])
+	}
+	return arn
+}
+
+// ServiceAuth wraps an auth middleware so that service tokens and client
+// certificates of configured service accounts are accepted as well. Anything
+// else is left to the wrapped middleware.
+func ServiceAuth(fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		other := fallback(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := servicePrincipal(r)
+			if err != nil {
+				apierrors.Write(w, r, err)
+				return
+			}
+			if principal == "" {
+				other.ServeHTTP(w, r)
+				return
+			}
+			user, err := serviceUser(principal)
+			if err != nil {
+				apierrors.Write(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), NewPrincipal(user, MethodService))))
+		})
+	}
+}
+
+// servicePrincipal returns the service principal a request authenticates as,
+// or "" for requests that aren't from a service
+func servicePrincipal(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(authHeader, "Bearer "+serviceTokenPrefix); ok {
+		principal, err := verifyServiceToken(r.Context(), token)
+		if err != nil {
+			settings.Debugf("Rejected service token: %v", err)
+			return "", ErrInvalidToken
+		}
+		return principal, nil
+	}
+	// A user token wins over the certificate of the proxy or job carrying it
+	if authHeader != "" {
+		return "", nil
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		for _, u := range cert.URIs {
+			if _, ok := servicePrincipals["uri:"+u.String()]; ok {
+				return "uri:" + u.String(), nil
+			}
+		}
+		return "cn:" + cert.Subject.CommonName, nil
+	}
+	return "", nil
+}
+
+// serviceUser returns the service account for an authenticated principal.
+// Service accounts are recorded as users the first time they call, so their
+// uploads have an owner.
+func serviceUser(principal string) (*MockUser, error) {
+	account, ok := servicePrincipals[principal]
+	if !ok {
+		log.Printf("Rejected unknown service principal %s", principal)
+		return nil, apierrors.New(apierrors.CodeForbidden, "caller is not a configured service account")
+	}
+	user := &MockUser{
+		ID:        "service:" + account,
+		Username:  account,
+		Email:     account + "@service.invalid",
+		Confirmed: true,
+		Roles:     []string{RoleUser},
+	}
+	if _, seen := knownUsers.Load(user.ID); !seen {
+		stored, err := database.EnsureExternalUser(user.ID, user.Username, user.Email)
+		if err != nil {
+			return nil, err
+		}
+		user.CreatedAt = stored.CreatedAt
+		knownUsers.Store(user.ID, true)
+	}
+	return user, nil
+}
+
+// verifyServiceToken replays a service token against STS and returns the
+// normalized ARN of the caller that signed it
+func verifyServiceToken(ctx context.Context, token string) (string, error) {
+	hash := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(hash[:])
+	if v, ok := verifiedServiceTokens.Load(key); ok {
+		cached := v.(verifiedServiceToken)
+		if time.Now().Before(cached.expires) {
+			return cached.principal, nil
+		}
+		verifiedServiceTokens.Delete(key)
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", errors.New("malformed service token")
+	}
+	presigned, expires, err := checkServiceTokenURL(string(raw), time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, presigned, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(ServiceAudienceHeader, serviceAudience)
+	resp, err := stsClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("calling STS: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return "", fmt.Errorf("calling STS: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("STS rejected the token: %s", resp.Status)
+	}
+	var out struct {
+		Arn string `xml:"GetCallerIdentityResult>Arn"`
+	}
+	if err := xml.Unmarshal(body, &out); err != nil || out.Arn == "" {
+		return "", errors.New("unexpected STS response")
+	}
+
+	principal := normalizeARN(out.Arn)
+	if limit := time.Now().Add(serviceTokenCacheTTL); expires.After(limit) {
+		expires = limit
+	}
+	verifiedServiceTokens.Store(key, verifiedServiceToken{principal: principal, expires: expires})
+	return principal, nil
+}
+
+// checkServiceTokenURL makes sure a token is a presigned GetCallerIdentity
+// call to STS that binds this API's audience, so it can't be used to make the
+// API call anything else. It returns the URL and when the token expires.
+func checkServiceTokenURL(raw string, now time.Time) (string, time.Time, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", time.Time{}, errors.New("malformed service token")
+	}
+	trusted := u.Scheme == "https" && stsHost.MatchString(u.Hostname()) && u.Port() == ""
+	if !trusted && (trustedSTSHost == "" || u.Host != trustedSTSHost) {
+		return "", time.Time{}, fmt.Errorf("service token is for untrusted host %q", u.Host)
+	}
+	if u.Path != "/" && u.Path != "" {
+		return "", time.Time{}, errors.New("service token has an unexpected path")
+	}
+
+	q := u.Query()
+	for name := range q {
+		switch name {
+		case "Action", "Version", "X-Amz-Algorithm", "X-Amz-Credential", "X-Amz-Date",
+			"X-Amz-Expires", "X-Amz-SignedHeaders", "X-Amz-Signature", "X-Amz-Security-Token":
+		default:
+			return "", time.Time{}, fmt.Errorf("service token has unexpected parameter %s", name)
+		}
+	}
+	if q.Get("Action") != "GetCallerIdentity" {
+		return "", time.Time{}, errors.New("service token isn't a GetCallerIdentity call")
+	}
+	signed := strings.Split(q.Get("X-Amz-SignedHeaders"), ";")
+	if !containsString(signed, strings.ToLower(ServiceAudienceHeader)) {
+		return "", time.Time{}, errors.New("service token doesn't sign the audience")
+	}
+
+	date, err := time.Parse("20060102T150405Z", q.Get("X-Amz-Date"))
+	if err != nil {
+		return "", time.Time{}, errors.New("service token has no valid date")
+	}
+	seconds, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+	if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > serviceTokenExpiry {
+		return "", time.Time{}, errors.New("service token has no valid expiry")
+	}
+	expires := date.Add(time.Duration(seconds) * time.Second)
+	if !now.Before(expires) {
+		return "", time.Time{}, errors.New("service token expired")
+	}
+	return u.String(), expires, nil
+}
+
+// NewServiceToken mints a service token for calling the API as the IAM
+// identity behind cfg's credentials. Send it as a Bearer token; it is valid
+// for 15 minutes. audience must match the API's SERVICE_AUTH_AUDIENCE.
+func NewServiceToken(ctx context.Context, cfg aws.Config, audience string) (string, error) {
+	endpoint, region := stsEndpoint(cfg)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/?Action=GetCallerIdentity&Version=2011-06-15", nil)
+	if err != nil {
+		return "", err
+	}
+	q := req.URL.Query()
+	q.Set("X-Amz-Expires", strconv.Itoa(int(serviceTokenExpiry.Seconds())))
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set(ServiceAudienceHeader, audience)
+
+	if cfg.Credentials == nil {
+		return "", errors.New("no AWS credentials configured")
+	}
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("retrieving credentials: %v", err)
+	}
+	emptyHash := sha256.Sum256(nil)
+	presigned, _, err := v4.NewSigner().PresignHTTP(ctx, creds, req, hex.EncodeToString(emptyHash[:]), "sts", region, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("signing token: %v", err)
+	}
+	return serviceTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(presigned)), nil
+}
+
+// stsEndpoint resolves the STS endpoint and signing region, honouring the
+// LocalStack resolver used in local development
+func stsEndpoint(cfg aws.Config) (string, string) {
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	if cfg.EndpointResolverWithOptions != nil {
+		e, err := cfg.EndpointResolverWithOptions.ResolveEndpoint("STS", region)
+		if err == nil {
+			if e.SigningRegion != "" {
+				region = e.SigningRegion
+			}
+			return strings.TrimRight(e.URL, "/"), region
+		}
+	}
+	return fmt.Sprintf("https://sts.%s.amazonaws.com", region), region
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseServicePrincipals(t *testing.T) {
+	p, err := ParseServicePrincipals("arn:aws:iam::123456789012:role/jobs/batch=batch, cn:ingest-job=ingest,uri:spiffe://corp/ns/a=b=svc")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"arn:aws:iam::123456789012:role/batch": "batch",
+		"cn:ingest-job":                        "ingest",
+		"uri:spiffe://corp/ns/a=b":             "svc",
+	}, p)
+
+	_, err = ParseServicePrincipals("batch")
+	assert.Error(t, err)
+	_, err = ParseServicePrincipals("ingest-job=ingest")
+	assert.Error(t, err, "principals need a kind")
+
+	assert.Equal(t, "arn:aws:iam::123456789012:role/batch",
+		normalizeARN("arn:aws:sts::123456789012:assumed-role/batch/i-0abc"))
+	assert.Equal(t, "arn:aws:iam::123456789012:user/ci", normalizeARN("arn:aws:iam::123456789012:user/ci"))
+}
+
+func TestServiceToken(t *testing.T) {
+	calls := 0
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		q := r.URL.Query()
+		if q.Get("Action") != "GetCallerIdentity" || r.Header.Get(ServiceAudienceHeader) != "files-api" ||
+			!strings.Contains(q.Get("X-Amz-SignedHeaders"), "x-service-audience") || q.Get("X-Amz-Signature") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+			<GetCallerIdentityResult>
+				<Arn>arn:aws:sts::123456789012:assumed-role/batch/job-1</Arn>
+				<UserId>AROAEXAMPLE:job-1</UserId>
+				<Account>123456789012</Account>
+			</GetCallerIdentityResult>
+		</GetCallerIdentityResponse>`))
+	}))
+	defer sts.Close()
+
+	cfg := aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, _ ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: sts.URL}, nil
+		}),
+	}
+	t.Setenv("SERVICE_PRINCIPALS", "arn:aws:iam::123456789012:role/batch=batch")
+	t.Setenv("SERVICE_AUTH_AUDIENCE", "files-api")
+	enabled, err := InitServiceAuth(cfg)
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+
+	ctx := context.Background()
+	token, err := NewServiceToken(ctx, cfg, "files-api")
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	principal, err := servicePrincipal(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/batch", principal)
+	_, err = servicePrincipal(r)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls, "verified tokens are cached")
+
+	// Tokens STS refuses, such as ones signed for another audience, are invalid
+	sts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	other, err := NewServiceToken(ctx, cfg, "another-api")
+	assert.NoError(t, err)
+	r.Header.Set("Authorization", "Bearer "+other)
+	_, err = servicePrincipal(r)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// User tokens are left to the other middleware
+	r.Header.Set("Authorization", "Bearer user-token")
+	principal, err = servicePrincipal(r)
+	assert.NoError(t, err)
+	assert.Empty(t, principal)
+}
+
+func TestCheckServiceTokenURL(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	valid := url.Values{
+		"Action":              {"GetCallerIdentity"},
+		"Version":             {"2011-06-15"},
+		"X-Amz-Date":          {"20240501T115500Z"},
+		"X-Amz-Expires":       {"900"},
+		"X-Amz-SignedHeaders": {"host;x-service-audience"},
+		"X-Amz-Signature":     {"abc"},
+	}
+	check := func(host string, change func(url.Values)) error {
+		q := url.Values{}
+		for k, v := range valid {
+			q[k] = v
+		}
+		if change != nil {
+			change(q)
+		}
+		_, _, err := checkServiceTokenURL("https://"+host+"/?"+q.Encode(), now)
+		return err
+	}
+
+	assert.NoError(t, check("sts.eu-west-1.amazonaws.com", nil))
+	assert.NoError(t, check("sts.amazonaws.com", nil))
+	assert.Error(t, check("sts.amazonaws.com.evil.example", nil))
+	assert.Error(t, check("sts.amazonaws.com", func(q url.Values) { q.Set("Action", "AssumeRole") }))
+	assert.Error(t, check("sts.amazonaws.com", func(q url.Values) { q.Set("RoleArn", "arn:aws:iam::1:role/x") }))
+	assert.Error(t, check("sts.amazonaws.com", func(q url.Values) { q.Set("X-Amz-SignedHeaders", "host") }))
+	assert.Error(t, check("sts.amazonaws.com", func(q url.Values) { q.Set("X-Amz-Date", "20240501T114000Z") }), "expired")
+	assert.Error(t, check("sts.amazonaws.com", func(q url.Values) { q.Set("X-Amz-Expires", "86400") }))
+
+	_, err := verifyServiceToken(context.Background(), base64.RawURLEncoding.EncodeToString([]byte("https://attacker.example/")))
+	assert.Error(t, err)
+}
+
+func TestCertificatePrincipal(t *testing.T) {
+	servicePrincipals = map[string]string{"uri:spiffe://corp/ingest": "ingest"}
+	defer func() { servicePrincipals = nil }()
+	spiffe, _ := url.Parse("spiffe://corp/ingest")
+
+	r := httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: "ingest-job"}, URIs: []*url.URL{spiffe}},
+	}}}
+	principal, err := servicePrincipal(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "uri:spiffe://corp/ingest", principal)
+
+	r.TLS.VerifiedChains[0][0].URIs = nil
+	principal, _ = servicePrincipal(r)
+	assert.Equal(t, "cn:ingest-job", principal)
+
+	// A user's token takes precedence over the connection's certificate
+	r.Header.Set("Authorization", "Bearer user-token")
+	principal, _ = servicePrincipal(r)
+	assert.Empty(t, principal)
+}
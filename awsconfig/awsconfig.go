@@ -0,0 +1,50 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// Load returns the AWS configuration for the current environment. With
+// ENV=local all services resolve to LocalStack using static test credentials.
+// Every client built from it shares the tuned transport from Transport.
+func Load(ctx context.Context) (aws.Config, error) {
+	customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		if os.Getenv("ENV") == "local" {
+			localstackHost := os.Getenv("LOCALSTACK_HOST")
+			if localstackHost == "" {
+				localstackHost = "localstack"
+			}
+			localstackPort := os.Getenv("LOCALSTACK_PORT")
+			if localstackPort == "" {
+				localstackPort = "4566"
+			}
+			return aws.Endpoint{
+				URL:               fmt.Sprintf("http://%s:%s", localstackHost, localstackPort),
+				SigningRegion:     "us-east-1",
+				HostnameImmutable: true,
+			}, nil
+		}
+		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+	})
+
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion("us-east-1"),
+		config.WithEndpointResolverWithOptions(customResolver),
+		config.WithHTTPClient(NewHTTPClient(0)),
+	)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS configuration: %v", err)
+	}
+
+	if os.Getenv("ENV") == "local" {
+		cfg.Credentials = credentials.NewStaticCredentialsProvider("test", "test", "")
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,35 @@
+package awsconfig
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadHTTP(t *testing.T) {
+	t.Setenv("HTTP_MAX_IDLE_CONNS_PER_HOST", "16")
+	t.Setenv("HTTP_IDLE_CONN_TIMEOUT", "30s")
+	t.Setenv("HTTP_MAX_CONNS_PER_HOST", "not a number")
+
+	h := LoadHTTP()
+	assert.Equal(t, 16, h.MaxIdleConnsPerHost)
+	assert.Equal(t, 30*time.Second, h.IdleConnTimeout)
+	assert.Equal(t, 0, h.MaxConnsPerHost, "invalid values keep the default")
+
+	tr := h.NewTransport()
+	assert.True(t, tr.ForceAttemptHTTP2)
+	assert.NotNil(t, tr.TLSClientConfig.ClientSessionCache)
+	assert.Equal(t, 16, tr.MaxIdleConnsPerHost)
+}
+
+func TestLoadTransfer(t *testing.T) {
+	t.Setenv("S3_PART_SIZE_MB", "4")
+	t.Setenv("S3_TRANSFER_CONCURRENCY", "10")
+	t.Setenv("S3_MULTIPART_THRESHOLD_MB", "1")
+
+	tr := LoadTransfer()
+	assert.Equal(t, int64(8<<20), tr.PartSize, "parts under 5 MB are refused")
+	assert.Equal(t, 10, tr.Concurrency)
+	assert.Equal(t, int64(minPartSize), tr.Threshold)
+}
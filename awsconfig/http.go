@@ -0,0 +1,101 @@
+package awsconfig
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// HTTP tunes the connection pool shared by the AWS SDK clients and the
+// service's other outbound HTTP clients
+type HTTP struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	TLSSessionCacheSize int
+}
+
+// LoadHTTP returns the pool settings from HTTP_MAX_IDLE_CONNS (default 200),
+// HTTP_MAX_IDLE_CONNS_PER_HOST (default 64), HTTP_MAX_CONNS_PER_HOST
+// (default 0, unlimited), HTTP_IDLE_CONN_TIMEOUT (default 90s) and
+// HTTP_TLS_SESSION_CACHE (default 128 sessions). Go keeps only 2 idle
+// connections per host by default, so parallel part transfers to S3 would
+// otherwise open a new connection, and TLS handshake, for most requests.
+func LoadHTTP() HTTP {
+	h := HTTP{
+		MaxIdleConns:        200,
+		MaxIdleConnsPerHost: 64,
+		IdleConnTimeout:     90 * time.Second,
+		TLSSessionCacheSize: 128,
+	}
+	if n, ok := envInt("HTTP_MAX_IDLE_CONNS", 0); ok {
+		h.MaxIdleConns = n
+	}
+	if n, ok := envInt("HTTP_MAX_IDLE_CONNS_PER_HOST", 1); ok {
+		h.MaxIdleConnsPerHost = n
+	}
+	if n, ok := envInt("HTTP_MAX_CONNS_PER_HOST", 0); ok {
+		h.MaxConnsPerHost = n
+	}
+	if v := os.Getenv("HTTP_IDLE_CONN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("Invalid HTTP_IDLE_CONN_TIMEOUT %q, using %s", v, h.IdleConnTimeout)
+		} else {
+			h.IdleConnTimeout = d
+		}
+	}
+	if n, ok := envInt("HTTP_TLS_SESSION_CACHE", 0); ok {
+		h.TLSSessionCacheSize = n
+	}
+	return h
+}
+
+// NewTransport returns a transport tuned with h. HTTP/2 is used with servers
+// that offer it over TLS, and TLS sessions are resumed from a cache so
+// reconnecting to the same host skips the full handshake.
+func (h HTTP) NewTransport() *http.Transport {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if h.TLSSessionCacheSize > 0 {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(h.TLSSessionCacheSize)
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          h.MaxIdleConns,
+		MaxIdleConnsPerHost:   h.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       h.MaxConnsPerHost,
+		IdleConnTimeout:       h.IdleConnTimeout,
+	}
+}
+
+var (
+	sharedTransportOnce sync.Once
+	sharedTransport     *http.Transport
+)
+
+// Transport returns the transport shared by the whole process, created from
+// LoadHTTP on first use
+func Transport() *http.Transport {
+	sharedTransportOnce.Do(func() {
+		sharedTransport = LoadHTTP().NewTransport()
+	})
+	return sharedTransport
+}
+
+// NewHTTPClient returns a client on the shared transport whose requests time
+// out after timeout. A zero timeout leaves requests to their context, which
+// suits the AWS SDK's own retry and timeout handling.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: Transport()}
+}
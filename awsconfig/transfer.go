@@ -0,0 +1,57 @@
+package awsconfig
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// Transfer tunes how large objects move to and from S3. Objects of at least
+// Threshold bytes are split into PartSize parts, Concurrency of which are
+// transferred at once; smaller objects use single requests.
+type Transfer struct {
+	PartSize    int64
+	Concurrency int
+	Threshold   int64
+}
+
+// minPartSize is the smallest part S3 accepts in a multipart upload
+const minPartSize = 5 << 20
+
+// LoadTransfer returns the transfer settings from S3_PART_SIZE_MB (default
+// 8), S3_TRANSFER_CONCURRENCY (default 5) and S3_MULTIPART_THRESHOLD_MB
+// (default 64). A threshold of 0 turns parallel transfers off.
+func LoadTransfer() Transfer {
+	t := Transfer{
+		PartSize:    8 << 20,
+		Concurrency: 5,
+		Threshold:   64 << 20,
+	}
+	if n, ok := envInt("S3_PART_SIZE_MB", 5); ok {
+		t.PartSize = int64(n) << 20
+	}
+	if n, ok := envInt("S3_TRANSFER_CONCURRENCY", 1); ok {
+		t.Concurrency = n
+	}
+	if n, ok := envInt("S3_MULTIPART_THRESHOLD_MB", 0); ok {
+		t.Threshold = int64(n) << 20
+	}
+	if t.Threshold > 0 && t.Threshold < minPartSize {
+		t.Threshold = minPartSize
+	}
+	return t
+}
+
+// envInt reads a non-negative integer of at least min from key
+func envInt(key string, min int) (int, bool) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min {
+		log.Printf("Invalid %s %q, using the default", key, v)
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,269 @@
+// Package client is a typed Go client for the file processing API. It takes
+// care of authentication, retries and error decoding, so services calling the
+// API don't have to hand-roll HTTP requests.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default retry settings
+const (
+	DefaultMaxRetries = 3
+	DefaultRetryDelay = 200 * time.Millisecond
+	maxRetryDelay     = 5 * time.Second
+)
+
+// Client calls the API at a base URL such as http://localhost:8080
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	maxRetries int
+	retryDelay time.Duration
+
+	mu    sync.RWMutex
+	token string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithToken sets the access token sent with every request
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithRetries sets how many times a failed request is retried and the delay
+// before the first retry, which doubles on every further attempt
+func WithRetries(maxRetries int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryDelay = delay
+	}
+}
+
+// New returns a client for the API at baseURL
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		maxRetries: DefaultMaxRetries,
+		retryDelay: DefaultRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SetToken replaces the access token sent with every request
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// Token returns the current access token
+func (c *Client) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// Error is an error response from the API
+type Error struct {
+	StatusCode int
+	// Code is the machine-readable error code, such as FILE_NOT_FOUND
+	Code   string
+	Title  string
+	Detail string
+}
+
+func (e *Error) Error() string {
+	msg := e.Detail
+	if msg == "" {
+		msg = e.Title
+	}
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("%s (%s, HTTP %d)", msg, e.Code, e.StatusCode)
+	}
+	return fmt.Sprintf("%s (HTTP %d)", msg, e.StatusCode)
+}
+
+// request describes an API call
+type request struct {
+	method string
+	path   string
+	body   interface{}
+	header http.Header
+	// retry allows retrying requests that aren't naturally idempotent, such
+	// as uploads carrying an Idempotency-Key
+	retry bool
+}
+
+// do sends req and decodes a successful JSON response into out. Network
+// errors, 429s and 5xx responses are retried for idempotent requests.
+func (c *Client) do(ctx context.Context, req request, out interface{}) error {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %v", err)
+	}
+	return nil
+}
+
+// send sends req, retrying as needed, and returns the successful response.
+// The caller must close its body.
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+	retry := req.retry || req.method == http.MethodGet || req.method == http.MethodDelete
+
+	for attempt := 0; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, c.baseURL+req.path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range req.header {
+			httpReq.Header[k] = v
+		}
+		if body != nil {
+			httpReq.Header.Set("Content-Type", "application/json")
+		}
+		httpReq.Header.Set("Accept", "application/json")
+		if token := c.Token(); token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := c.httpClient.Do(httpReq)
+		var delay time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		case resp.StatusCode < 400:
+			return resp, nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			delay = retryAfter(resp)
+			err = decodeError(resp)
+			resp.Body.Close()
+		default:
+			err = decodeError(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		if !retry || attempt >= c.maxRetries {
+			return nil, err
+		}
+		if delay == 0 {
+			delay = c.backoff(attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff returns the delay before retry number attempt+1, with jitter so
+// clients that failed together don't retry together
+func (c *Client) backoff(attempt int) time.Duration {
+	d := time.Duration(float64(c.retryDelay) * math.Pow(2, float64(attempt)))
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d/2 + time.Duration(mathrand.Int63n(int64(d/2)+1))
+}
+
+// retryAfter returns the delay requested by a Retry-After header in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	d := time.Duration(secs) * time.Second
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d
+}
+
+// decodeError reads an error response, which is problem+json for current
+// servers and plain text for older ones
+func decodeError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var problem struct {
+		Code   string `json:"code"`
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	}
+	if json.Unmarshal(data, &problem) == nil && (problem.Code != "" || problem.Title != "") {
+		e.Code, e.Title, e.Detail = problem.Code, problem.Title, problem.Detail
+	} else {
+		e.Detail = strings.TrimSpace(string(data))
+	}
+	return e
+}
+
+// newIdempotencyKey returns a random key for a retried upload
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SignIn signs in with a username and password and uses the returned access
+// token for later requests
+func (c *Client) SignIn(ctx context.Context, username, password string) (string, error) {
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/auth/signin",
+		body:   map[string]string{"username": username, "password": password},
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	c.SetToken(resp.AccessToken)
+	return resp.AccessToken, nil
+}
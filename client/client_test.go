@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(t *testing.T, h http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return New(srv.URL, WithToken("secret"), WithRetries(3, time.Millisecond))
+}
+
+func TestUploadRetriesWithSameIdempotencyKey(t *testing.T) {
+	var calls int32
+	var keys []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"id": "f1", "name": "a.txt", "status": "uploaded"})
+	})
+
+	out, err := c.Upload(context.Background(), UploadInput{Name: "a.txt", Content: "hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, "f1", out.ID)
+	if assert.Len(t, keys, 2) {
+		assert.NotEmpty(t, keys[0])
+		assert.Equal(t, keys[0], keys[1])
+	}
+}
+
+func TestErrorResponsesAreDecoded(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code": "FILE_NOT_FOUND", "title": "File not found", "status": 404, "detail": "File not found",
+		})
+	})
+
+	_, err := c.GetFile(context.Background(), "missing")
+	var apiErr *Error
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+		assert.Equal(t, "FILE_NOT_FOUND", apiErr.Code)
+	}
+	// Client errors aren't retried
+	assert.Equal(t, int32(1), calls)
+}
+
+func TestGetFileAsksForContent(t *testing.T) {
+	var queries []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		json.NewEncoder(w).Encode(File{ID: "f1"})
+	})
+
+	_, err := c.GetFile(context.Background(), "f1")
+	assert.NoError(t, err)
+	_, err = c.GetFileInfo(context.Background(), "f1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"include=content", ""}, queries)
+}
+
+func TestListFilesIteratesPages(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		page := map[string]interface{}{
+			"files":       []FileSummary{{ID: "1"}, {ID: "2"}},
+			"next_cursor": "next",
+		}
+		if r.URL.Query().Get("cursor") == "next" {
+			page = map[string]interface{}{"files": []FileSummary{{ID: "3"}}, "next_cursor": ""}
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+
+	var ids []string
+	it := c.ListFiles(2)
+	for it.Next(context.Background()) {
+		ids = append(ids, it.File().ID)
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, []string{"1", "2", "3"}, ids)
+}
+
+func TestWaitForResultPolls(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "30s", r.URL.Query().Get("wait"))
+		if atomic.AddInt32(&calls, 1) < 2 {
+			json.NewEncoder(w).Encode(map[string]string{"status": StatusProcessing})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "completed", "result": "done"})
+	})
+
+	r, err := c.WaitForResult(context.Background(), "f1")
+	assert.NoError(t, err)
+	assert.Equal(t, "completed", r.Status)
+	assert.Equal(t, int32(2), calls)
+}
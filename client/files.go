@@ -0,0 +1,276 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// StatusProcessing is reported by GetResult until a file has been processed
+const StatusProcessing = "processing"
+
+// File is an uploaded file with its content
+type File struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"`
+}
+
+// FileSummary is a file as it appears in lists
+type FileSummary struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	SizeBytes    int64     `json:"size_bytes"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Version      int       `json:"version"`
+	NameRevision int       `json:"name_revision"`
+}
+
+// Result is the latest processing result of a file
+type Result struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Result    string    `json:"result"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"`
+}
+
+// Done reports whether processing has finished, successfully or not
+func (r *Result) Done() bool {
+	return r.Status != StatusProcessing
+}
+
+// UploadInput describes a file to upload
+type UploadInput struct {
+	Name    string
+	Content string
+	// CollectionID optionally uploads into a collection
+	CollectionID string
+	// OnDuplicate is reject, rename or version; empty uses the server default
+	OnDuplicate string
+	// ProcessDelay defers processing
+	ProcessDelay time.Duration
+	// IdempotencyKey makes retries safe. A random key is used if it's empty.
+	IdempotencyKey string
+}
+
+// UploadOutput is the response to an upload
+type UploadOutput struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Message   string `json:"message"`
+	ProcessAt string `json:"process_at"`
+}
+
+// Upload uploads a file. Uploads always carry an Idempotency-Key, so they are
+// retried like any other request without risk of creating duplicates.
+func (c *Client) Upload(ctx context.Context, in UploadInput) (*UploadOutput, error) {
+	key := in.IdempotencyKey
+	if key == "" {
+		key = newIdempotencyKey()
+	}
+
+	body := map[string]interface{}{
+		"name":    in.Name,
+		"content": in.Content,
+	}
+	if in.CollectionID != "" {
+		body["collection_id"] = in.CollectionID
+	}
+	if in.OnDuplicate != "" {
+		body["on_duplicate"] = in.OnDuplicate
+	}
+	if in.ProcessDelay > 0 {
+		body["process_delay_seconds"] = int(in.ProcessDelay / time.Second)
+	}
+
+	var out UploadOutput
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/files",
+		body:   body,
+		header: http.Header{"Idempotency-Key": {key}},
+		retry:  true,
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetFile retrieves a file with its content
+func (c *Client) GetFile(ctx context.Context, id string) (*File, error) {
+	var f File
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/files/" + url.PathEscape(id) + "?include=content"}, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// GetFileInfo retrieves a file without its content
+func (c *Client) GetFileInfo(ctx context.Context, id string) (*File, error) {
+	var f File
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/files/" + url.PathEscape(id)}, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Download writes a file's raw content to w
+func (c *Client) Download(ctx context.Context, id string, w io.Writer) (int64, error) {
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: "/api/files/" + url.PathEscape(id) + "/download"})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return io.Copy(w, resp.Body)
+}
+
+// GetResult retrieves a file's latest processing result. Files that haven't
+// been processed yet have the status StatusProcessing.
+func (c *Client) GetResult(ctx context.Context, id string) (*Result, error) {
+	return c.getResult(ctx, "/api/files/"+url.PathEscape(id)+"/result")
+}
+
+func (c *Client) getResult(ctx context.Context, path string) (*Result, error) {
+	var r Result
+	if err := c.do(ctx, request{method: http.MethodGet, path: path}, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListResults retrieves every processing result recorded for a file, newest first
+func (c *Client) ListResults(ctx context.Context, id string) ([]Result, error) {
+	var results []Result
+	cursor := ""
+	for {
+		var page struct {
+			Results    []Result `json:"results"`
+			NextCursor string   `json:"next_cursor"`
+		}
+		path := "/api/files/" + url.PathEscape(id) + "/results?" + pageQuery(0, cursor)
+		if err := c.do(ctx, request{method: http.MethodGet, path: path}, &page); err != nil {
+			return nil, err
+		}
+		results = append(results, page.Results...)
+		if page.NextCursor == "" {
+			return results, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// Polling intervals used by WaitForResult
+const (
+	initialPollInterval = 500 * time.Millisecond
+	maxPollInterval     = 10 * time.Second
+)
+
+// resultWait is how long each WaitForResult request asks the server to hold
+// it, kept under the default HTTP client timeout
+const resultWait = "30s"
+
+// WaitForResult polls a file's result until processing has finished or ctx is
+// done. Each request waits on the server for the result to arrive; between
+// requests the polling interval starts short and backs off while the file is
+// still processing.
+func (c *Client) WaitForResult(ctx context.Context, id string) (*Result, error) {
+	interval := initialPollInterval
+	path := "/api/files/" + url.PathEscape(id) + "/result?wait=" + resultWait
+	for {
+		r, err := c.getResult(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if r.Done() {
+			return r, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return r, ctx.Err()
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
+}
+
+// FileIterator walks the caller's files newest first, fetching pages as needed
+//
+//	it := c.ListFiles(0)
+//	for it.Next(ctx) {
+//		f := it.File()
+//	}
+//	if err := it.Err(); err != nil {
+type FileIterator struct {
+	c        *Client
+	pageSize int
+	page     []FileSummary
+	cursor   string
+	done     bool
+	current  FileSummary
+	err      error
+}
+
+// ListFiles returns an iterator over the caller's files. A pageSize of 0 uses
+// the server default.
+func (c *Client) ListFiles(pageSize int) *FileIterator {
+	return &FileIterator{c: c, pageSize: pageSize}
+}
+
+// Next advances to the next file, returning false when there are no more
+// files or an error occurred
+func (it *FileIterator) Next(ctx context.Context) bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		var page struct {
+			Files      []FileSummary `json:"files"`
+			NextCursor string        `json:"next_cursor"`
+		}
+		it.err = it.c.do(ctx, request{method: http.MethodGet, path: "/api/files?" + pageQuery(it.pageSize, it.cursor)}, &page)
+		if it.err != nil {
+			return false
+		}
+		it.page, it.cursor = page.Files, page.NextCursor
+		it.done = page.NextCursor == ""
+	}
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// File returns the file Next advanced to
+func (it *FileIterator) File() FileSummary {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *FileIterator) Err() error {
+	return it.err
+}
+
+// pageQuery encodes pagination query parameters
+func pageQuery(limit int, cursor string) string {
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	return q.Encode()
+}
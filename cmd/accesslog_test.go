@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger := accessLogger
+	accessLogger = slog.New(slog.NewJSONHandler(&buf, nil))
+	t.Cleanup(func() { accessLogger = logger })
+
+	r := mux.NewRouter()
+	r.Use(accessLogMiddleware)
+	r.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+
+	req := httptest.NewRequest("GET", "/items/42", nil)
+	req.Header.Set(requestIDHeader, "trace-1")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, "trace-1", rr.Header().Get(requestIDHeader))
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "trace-1", entry["request_id"])
+	assert.Equal(t, "/items/{id}", entry["route"])
+	assert.Equal(t, float64(http.StatusCreated), entry["status"])
+	assert.Equal(t, float64(5), entry["bytes"])
+
+	// Malformed IDs are replaced
+	buf.Reset()
+	req = httptest.NewRequest("GET", "/items/42", nil)
+	req.Header.Set(requestIDHeader, "bad id\n")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.NotEqual(t, "bad id\n", rr.Header().Get(requestIDHeader))
+	assert.NotEmpty(t, rr.Header().Get(requestIDHeader))
+
+	// A route sampled at 0 isn't logged
+	s := *settings.Current()
+	s.AccessLogSampling = map[string]float64{"/items/{id}": 0}
+	assert.NoError(t, settings.Set(s))
+	t.Cleanup(func() { settings.Set(settings.Defaults()) })
+	buf.Reset()
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/42", nil))
+	assert.Empty(t, buf.String())
+}
+
+func TestLatencyHistograms(t *testing.T) {
+	h := &latencyHistograms{routes: make(map[routeKey]*histogram)}
+	h.observe("GET", "/files", 3*time.Millisecond)
+	h.observe("GET", "/files", 200*time.Millisecond)
+	h.observe("GET", "/files", 20*time.Second)
+
+	var b strings.Builder
+	h.writeTo(&b)
+	out := b.String()
+	assert.Contains(t, out, `http_request_duration_seconds_bucket{method="GET",route="/files",le="0.005"} 1`)
+	assert.Contains(t, out, `http_request_duration_seconds_bucket{method="GET",route="/files",le="0.25"} 2`)
+	assert.Contains(t, out, `http_request_duration_seconds_bucket{method="GET",route="/files",le="10"} 2`)
+	assert.Contains(t, out, `http_request_duration_seconds_bucket{method="GET",route="/files",le="+Inf"} 3`)
+	assert.Contains(t, out, `http_request_duration_seconds_count{method="GET",route="/files"} 3`)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// defaultAccountDeletionGrace is how long a user has to change their mind
+// before their account is purged
+const defaultAccountDeletionGrace = 30 * 24 * time.Hour
+
+// accountDeletionBatchSize is the maximum number of accounts purged per tick
+const accountDeletionBatchSize = 10
+
+// accountDeletionGrace returns the grace period set by ACCOUNT_DELETION_GRACE
+func accountDeletionGrace() time.Duration {
+	v := os.Getenv("ACCOUNT_DELETION_GRACE")
+	if v == "" {
+		return defaultAccountDeletionGrace
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("Invalid ACCOUNT_DELETION_GRACE %q, using %s", v, defaultAccountDeletionGrace)
+		return defaultAccountDeletionGrace
+	}
+	return d
+}
+
+// accountDeletionResponse is the JSON form of an account deletion
+func accountDeletionResponse(d *database.AccountDeletion) map[string]interface{} {
+	response := map[string]interface{}{
+		"id":            d.ID,
+		"user_id":       d.UserID,
+		"status":        d.Status,
+		"scheduled_for": d.ScheduledFor,
+		"created_at":    d.CreatedAt,
+	}
+	if d.CompletedAt.Valid {
+		response["completed_at"] = d.CompletedAt.Time
+	}
+	if d.Status == database.AccountDeletionCompleted || d.Status == database.AccountDeletionFailed {
+		response["files_deleted"] = d.FilesDeleted
+		response["files_retained"] = d.FilesRetained
+		response["objects_deleted"] = d.ObjectsDeleted
+	}
+	if d.Error != "" {
+		response["error"] = d.Error
+	}
+	return response
+}
+
+// deleteAccountHandler schedules the caller's account to be purged once the
+// grace period has passed. Until then the user can still sign in and cancel.
+func deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+
+	d, err := database.ScheduleAccountDeletion(userID, time.Now().Add(accountDeletionGrace()))
+	if err != nil {
+		log.Printf("Error scheduling deletion of user %s: %v", userID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error scheduling account deletion")
+		return
+	}
+	log.Printf("Account deletion %s scheduled for user %s at %s", d.ID, userID, d.ScheduledFor.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(accountDeletionResponse(d))
+}
+
+// getAccountDeletionHandler returns the caller's pending account deletion
+func getAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+
+	d, err := database.GetOpenAccountDeletion(userID)
+	if err != nil {
+		log.Printf("Error retrieving deletion of user %s: %v", userID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving account deletion")
+		return
+	}
+	if d == nil {
+		apierrors.Respond(w, r, apierrors.CodeAccountDeletionNotFound, "No account deletion is pending")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accountDeletionResponse(d))
+}
+
+// cancelAccountDeletionHandler cancels the caller's pending account deletion
+func cancelAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+
+	cancelled, err := database.CancelAccountDeletion(userID)
+	if err != nil {
+		log.Printf("Error cancelling deletion of user %s: %v", userID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error cancelling account deletion")
+		return
+	}
+	if !cancelled {
+		apierrors.Respond(w, r, apierrors.CodeAccountDeletionNotFound, "No account deletion is pending")
+		return
+	}
+	log.Printf("Account deletion cancelled for user %s", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listAccountDeletionsHandler reports account deletions, newest first,
+// filtered by ?status=. Only administrators can see it.
+func listAccountDeletionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", database.AccountDeletionPending, database.AccountDeletionRunning, database.AccountDeletionCompleted,
+		database.AccountDeletionFailed, database.AccountDeletionCancelled:
+	default:
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "Unknown status "+status)
+		return
+	}
+	params, ok := paginationParams(w, r)
+	if !ok {
+		return
+	}
+
+	deletions, err := database.ListAccountDeletions(status, params.After, params.Limit)
+	if err != nil {
+		log.Printf("Error listing account deletions: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing account deletions")
+		return
+	}
+	deletions, next := pagination.Trim(deletions, params.Limit, func(d database.AccountDeletion) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: d.CreatedAt, ID: d.ID}
+	})
+
+	items := make([]map[string]interface{}, 0, len(deletions))
+	for i := range deletions {
+		items = append(items, accountDeletionResponse(&deletions[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"account_deletions": items,
+		"next_cursor":       next,
+	})
+}
+
+// purgeDueAccounts purges every account whose grace period has ended
+func purgeDueAccounts(ctx context.Context) {
+	deletions, err := database.ClaimDueAccountDeletions(time.Now(), accountDeletionBatchSize)
+	if err != nil {
+		log.Printf("Error claiming account deletions: %v", err)
+		return
+	}
+
+	for _, d := range deletions {
+		counts, err := purgeAccount(ctx, d.UserID)
+		if err != nil {
+			log.Printf("Error purging user %s for deletion %s: %v", d.UserID, d.ID, err)
+			if err := database.FailAccountDeletion(d.ID, counts, err.Error()); err != nil {
+				log.Printf("Error marking account deletion %s failed: %v", d.ID, err)
+			}
+			continue
+		}
+		log.Printf("Purged user %s for deletion %s: %d files deleted, %d retained, %d objects deleted",
+			d.UserID, d.ID, counts.FilesDeleted, counts.FilesRetained, counts.ObjectsDeleted)
+		if err := database.CompleteAccountDeletion(d.ID, counts); err != nil {
+			log.Printf("Error marking account deletion %s completed: %v", d.ID, err)
+		}
+	}
+}
+
+// purgeAccount deletes a user's files along with their results and objects,
+// deletes their export archives and anonymizes the account. Files under
+// retention or legal hold can't be deleted yet; they stay with the anonymized
+// account and are counted as retained.
+func purgeAccount(ctx context.Context, userID string) (database.PurgeCounts, error) {
+	var counts database.PurgeCounts
+	files, err := database.ListAllFilesByUser(userID)
+	if err != nil {
+		return counts, err
+	}
+
+	for _, f := range files {
+		objects, err := removeFile(ctx, &f, "")
+		if errors.Is(err, database.ErrRetentionActive) || errors.Is(err, database.ErrLegalHold) {
+			counts.FilesRetained++
+			continue
+		}
+		if err != nil {
+			return counts, err
+		}
+		counts.FilesDeleted++
+		counts.ObjectsDeleted += objects
+	}
+
+	exportKeys, err := database.DeleteExportsByUser(userID)
+	if err != nil {
+		return counts, err
+	}
+	for _, key := range exportKeys {
+		if fileRemover.DeleteObject(ctx, key) {
+			counts.ObjectsDeleted++
+		}
+	}
+
+	return counts, database.AnonymizeUser(userID)
+}
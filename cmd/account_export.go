@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/export"
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// accountExportPageSize is the page size used to read paginated history
+const accountExportPageSize = 500
+
+// createAccountExportHandler starts an asynchronous export of everything
+// stored about the caller. Its status and download link are read from
+// GET /api/exports/{id} like any other export.
+func createAccountExportHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	job, err := database.SaveExport(userID, export.FormatAccount)
+	if err != nil {
+		log.Printf("Error saving account export job: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating export")
+		return
+	}
+
+	go runExport(job)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":      job.ID,
+		"status":  job.Status,
+		"message": "Account export started",
+	})
+}
+
+// accountDocument is account.json in an account export
+type accountDocument struct {
+	ID         string             `json:"id"`
+	Username   string             `json:"username"`
+	Email      string             `json:"email"`
+	Confirmed  bool               `json:"confirmed"`
+	CreatedAt  time.Time          `json:"created_at"`
+	Identities []identityDocument `json:"identities"`
+	APIKeys    []apiKeyResponse   `json:"api_keys"`
+	SSHKeys    []sshKeyResponse   `json:"ssh_keys"`
+}
+
+type identityDocument struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	Email    string    `json:"email"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+type fileDocument struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	S3Key         string     `json:"s3_key"`
+	SizeBytes     int64      `json:"size_bytes"`
+	CollectionID  string     `json:"collection_id,omitempty"`
+	StorageClass  string     `json:"storage_class"`
+	RetentionMode string     `json:"retention_mode,omitempty"`
+	RetainUntil   *time.Time `json:"retain_until,omitempty"`
+	Encrypted     bool       `json:"encrypted"`
+	LegalHold     bool       `json:"legal_hold"`
+	Version       int        `json:"version"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+type resultDocument struct {
+	ID        string    `json:"id"`
+	FileID    string    `json:"file_id"`
+	Status    string    `json:"status"`
+	Result    string    `json:"result"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type collectionDocument struct {
+	ID        string    `json:"id"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type accessDocument struct {
+	FileID    string    `json:"file_id"`
+	Action    string    `json:"action"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// writeAccountArchive writes the account export for a user: the account and
+// its credentials' metadata, file metadata, the full processing history,
+// collections, shares, and the events and file accesses attributed to the
+// user. Secrets such as password and key hashes are left out.
+func writeAccountArchive(ctx context.Context, w io.Writer, userID string) error {
+	docs, err := accountDocuments(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return export.WriteArchive(w, docs)
+}
+
+func accountDocuments(ctx context.Context, userID string) ([]export.Document, error) {
+	user, err := database.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading user: %v", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user %s not found", userID)
+	}
+	account := accountDocument{
+		ID:         user.ID,
+		Username:   user.Username,
+		Email:      user.Email,
+		Confirmed:  user.Confirmed,
+		CreatedAt:  user.CreatedAt,
+		Identities: []identityDocument{},
+		APIKeys:    []apiKeyResponse{},
+		SSHKeys:    []sshKeyResponse{},
+	}
+
+	identities, err := database.ListIdentitiesByUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading identities: %v", err)
+	}
+	for _, i := range identities {
+		account.Identities = append(account.Identities, identityDocument{Provider: i.Provider, Subject: i.Subject, Email: i.Email, LinkedAt: i.CreatedAt})
+	}
+	apiKeys, err := database.ListAPIKeys(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading API keys: %v", err)
+	}
+	for _, k := range apiKeys {
+		account.APIKeys = append(account.APIKeys, newAPIKeyResponse(k))
+	}
+	sshKeys, err := database.ListSSHKeys(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading SSH keys: %v", err)
+	}
+	for _, k := range sshKeys {
+		account.SSHKeys = append(account.SSHKeys, newSSHKeyResponse(k))
+	}
+
+	files, err := database.ListAllFilesByUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading files: %v", err)
+	}
+	fileDocs := make([]fileDocument, 0, len(files))
+	for _, f := range files {
+		fileDocs = append(fileDocs, fileDocument{
+			ID:            f.ID,
+			Name:          f.Name,
+			S3Key:         f.S3Key,
+			SizeBytes:     f.SizeBytes,
+			CollectionID:  f.CollectionID,
+			StorageClass:  f.StorageClass,
+			RetentionMode: f.RetentionMode,
+			RetainUntil:   f.RetainUntil,
+			Encrypted:     f.Encryption.Algorithm != "",
+			LegalHold:     f.LegalHold,
+			Version:       f.Version,
+			CreatedAt:     f.CreatedAt,
+			UpdatedAt:     f.UpdatedAt,
+		})
+	}
+
+	results, err := database.ListProcessingResultsByUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading results: %v", err)
+	}
+	resultDocs := make([]resultDocument, 0, len(results))
+	for _, pr := range results {
+		if err := loadOffloadedResult(ctx, &pr); err != nil {
+			return nil, err
+		}
+		resultDocs = append(resultDocs, resultDocument{ID: pr.ID, FileID: pr.FileID, Status: pr.Status, Result: pr.Result, Version: pr.Version, CreatedAt: pr.CreatedAt, UpdatedAt: pr.UpdatedAt})
+	}
+
+	collections, err := database.ListAllCollectionsByUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading collections: %v", err)
+	}
+	collectionDocs := make([]collectionDocument, 0, len(collections))
+	for _, c := range collections {
+		collectionDocs = append(collectionDocs, collectionDocument{ID: c.ID, ParentID: c.ParentID, Name: c.Name, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt})
+	}
+
+	shares := []shareResponse{}
+	var after *pagination.Cursor
+	for {
+		page, err := database.ListSharesByUser(userID, after, accountExportPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("error loading shares: %v", err)
+		}
+		page, next := pagination.Trim(page, accountExportPageSize, func(s database.Share) pagination.Cursor {
+			return pagination.Cursor{CreatedAt: s.CreatedAt, ID: s.ID}
+		})
+		for i := range page {
+			shares = append(shares, newShareResponse(&page[i]))
+		}
+		if next == "" {
+			break
+		}
+		last := page[len(page)-1]
+		after = &pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+
+	events := []database.Event{}
+	after = nil
+	for {
+		page, err := database.ListEvents(database.EventFilter{UserID: userID}, after, accountExportPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("error loading events: %v", err)
+		}
+		events = append(events, page...)
+		if len(page) < accountExportPageSize {
+			break
+		}
+		last := page[len(page)-1]
+		after = &pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+
+	accesses, err := database.ListFileAccessByUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading access log: %v", err)
+	}
+	accessDocs := make([]accessDocument, 0, len(accesses))
+	for _, a := range accesses {
+		accessDocs = append(accessDocs, accessDocument{FileID: a.FileID, Action: a.Action, IP: a.IP, UserAgent: a.UserAgent, CreatedAt: a.CreatedAt})
+	}
+
+	return []export.Document{
+		{Name: "account.json", Data: account},
+		{Name: "files.json", Data: fileDocs},
+		{Name: "results.json", Data: resultDocs},
+		{Name: "collections.json", Data: collectionDocs},
+		{Name: "shares.json", Data: shares},
+		{Name: "events.json", Data: events},
+		{Name: "access_log.json", Data: accessDocs},
+	}, nil
+}
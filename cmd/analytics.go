@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/parquet"
+)
+
+const (
+	// analyticsTable is the table the results are exported as, and the
+	// directory under the analytics prefix holding its partitions
+	analyticsTable = "processing_results"
+	// analyticsBatchSize is how many results are read at a time
+	analyticsBatchSize = 5000
+	// analyticsDaysPerTick caps how many days one scheduler tick exports,
+	// so a backfill is spread over several ticks
+	analyticsDaysPerTick = 7
+	// analyticsStaleAfter is how long a day's export may run before another
+	// instance takes it over, and how long a failed day waits to be retried
+	analyticsStaleAfter = time.Hour
+)
+
+// analyticsEnabled reports whether the scheduler exports processing results
+// for analytics, with ANALYTICS_EXPORT=true
+func analyticsEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("ANALYTICS_EXPORT"))
+	return enabled
+}
+
+// analyticsPrefix is where the analytics tables are written in the bucket,
+// from ANALYTICS_PREFIX
+func analyticsPrefix() string {
+	if prefix := os.Getenv("ANALYTICS_PREFIX"); prefix != "" {
+		return prefix
+	}
+	return "analytics/"
+}
+
+// analyticsColumns is the schema of the exported results. An offloaded
+// result is exported as the summary kept in the database, with result_key
+// naming the object holding the whole result.
+var analyticsColumns = []parquet.Column{
+	{Name: "id", Kind: parquet.String},
+	{Name: "file_id", Kind: parquet.String},
+	{Name: "user_id", Kind: parquet.String, Optional: true},
+	{Name: "collection_id", Kind: parquet.String, Optional: true},
+	{Name: "file_name", Kind: parquet.String},
+	{Name: "content_type", Kind: parquet.String, Optional: true},
+	{Name: "size_bytes", Kind: parquet.Int64},
+	{Name: "status", Kind: parquet.String},
+	{Name: "source", Kind: parquet.String},
+	{Name: "result", Kind: parquet.String},
+	{Name: "result_key", Kind: parquet.String, Optional: true},
+	{Name: "result_size", Kind: parquet.Int64},
+	{Name: "reused_from", Kind: parquet.String, Optional: true},
+	{Name: "version", Kind: parquet.Int32},
+	{Name: "file_created_at", Kind: parquet.Timestamp},
+	{Name: "created_at", Kind: parquet.Timestamp},
+	{Name: "updated_at", Kind: parquet.Timestamp},
+}
+
+// analyticsRow returns a result's values in the order of analyticsColumns
+func analyticsRow(r database.AnalyticsResult) []interface{} {
+	size := r.ResultSize
+	if r.ResultKey == "" {
+		size = int64(len(r.Result))
+	}
+	return []interface{}{
+		r.ID,
+		r.FileID,
+		optionalString(r.UserID),
+		optionalString(r.CollectionID),
+		r.FileName,
+		optionalString(r.ContentType),
+		r.SizeBytes,
+		r.Status,
+		r.Source,
+		r.Result,
+		optionalString(r.ResultKey),
+		size,
+		optionalString(r.ReusedFrom),
+		r.Version,
+		r.FileCreatedAt.UTC(),
+		r.CreatedAt.UTC(),
+		r.UpdatedAt.UTC(),
+	}
+}
+
+// optionalString exports an empty string as null
+func optionalString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// analyticsKey is where a day's partition is written. The dt= directory is
+// the Hive partition layout Athena and Glue crawlers recognize.
+func analyticsKey(day time.Time) string {
+	return fmt.Sprintf("%s%s/dt=%s/results.parquet", analyticsPrefix(), analyticsTable, day.Format("2006-01-02"))
+}
+
+// analyticsManifestKey is where the manifest is written. Athena skips files
+// whose names start with an underscore, so it can sit next to the partitions.
+func analyticsManifestKey() string {
+	return analyticsPrefix() + analyticsTable + "/_manifest.json"
+}
+
+// exportAnalytics exports each finished UTC day of processing results not
+// exported yet as a Parquet partition, then rewrites the manifest. Days that
+// fail are retried after analyticsStaleAfter.
+func exportAnalytics(ctx context.Context) {
+	if !analyticsEnabled() {
+		return
+	}
+	now := time.Now().UTC()
+	today := now.Truncate(24 * time.Hour)
+
+	exported := 0
+	for i := 0; i < analyticsDaysPerTick && ctx.Err() == nil; i++ {
+		day, err := database.ClaimNextAnalyticsDay(today, now.Add(-analyticsStaleAfter))
+		if err != nil {
+			log.Printf("Error claiming analytics day: %v", err)
+			break
+		}
+		if day == nil {
+			break
+		}
+		if _, err := exportAnalyticsDay(ctx, *day); err != nil {
+			log.Printf("Error exporting analytics for %s: %v", day.Format("2006-01-02"), err)
+			continue
+		}
+		exported++
+	}
+	if exported > 0 {
+		if err := writeAnalyticsManifest(ctx); err != nil {
+			log.Printf("Error writing analytics manifest: %v", err)
+		}
+	}
+}
+
+// exportAnalyticsDay writes the results created on a claimed day as its
+// partition, replacing any earlier export of the day, and records the
+// outcome. It returns how many results were exported.
+func exportAnalyticsDay(ctx context.Context, day time.Time) (int64, error) {
+	key := analyticsKey(day)
+	rows, err := writeAnalyticsPartition(ctx, day, key)
+	if err != nil {
+		if failErr := database.FailAnalyticsPartition(day, err.Error()); failErr != nil {
+			log.Printf("Error recording failed analytics export for %s: %v", day.Format("2006-01-02"), failErr)
+		}
+		return 0, err
+	}
+	if err := database.CompleteAnalyticsPartition(day, key, rows); err != nil {
+		return 0, err
+	}
+	log.Printf("Exported %d results for %s to %s", rows, day.Format("2006-01-02"), key)
+	return rows, nil
+}
+
+// writeAnalyticsPartition spools a day's results to a temporary Parquet file
+// and uploads it to key
+func writeAnalyticsPartition(ctx context.Context, day time.Time, key string) (int64, error) {
+	tmp, err := os.CreateTemp("", "analytics-*.parquet")
+	if err != nil {
+		return 0, fmt.Errorf("error creating temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	pw := parquet.NewWriter(tmp, analyticsColumns)
+	until := day.AddDate(0, 0, 1)
+	afterTime, afterID := day, ""
+	for {
+		results, err := database.ListAnalyticsResults(day, until, afterTime, afterID, analyticsBatchSize)
+		if err != nil {
+			return 0, fmt.Errorf("error loading results: %v", err)
+		}
+		for _, r := range results {
+			if err := pw.Write(analyticsRow(r)...); err != nil {
+				return 0, err
+			}
+		}
+		if len(results) < analyticsBatchSize {
+			break
+		}
+		last := results[len(results)-1]
+		afterTime, afterID = last.CreatedAt, last.ID
+	}
+	if err := pw.Close(); err != nil {
+		return 0, err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	_, err = s3Client.Upload(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(bucketName),
+		Key:           aws.String(key),
+		Body:          tmp,
+		ContentLength: size,
+		ContentType:   aws.String("application/vnd.apache.parquet"),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error uploading %s: %v", key, err)
+	}
+	return pw.Rows(), nil
+}
+
+// analyticsColumn describes a column in the manifest, typed as in Athena
+type analyticsColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type analyticsPartitionResponse struct {
+	Day        string     `json:"dt"`
+	Status     string     `json:"status,omitempty"`
+	Key        string     `json:"key,omitempty"`
+	Rows       int64      `json:"rows"`
+	Error      string     `json:"error,omitempty"`
+	ExportedAt *time.Time `json:"exported_at,omitempty"`
+}
+
+// analyticsManifest lists the exported partitions and their schema, for
+// data teams setting up or checking a table over them
+type analyticsManifest struct {
+	Table         string                       `json:"table"`
+	Location      string                       `json:"location"`
+	Format        string                       `json:"format"`
+	Compression   string                       `json:"compression"`
+	PartitionKeys []analyticsColumn            `json:"partition_keys"`
+	Columns       []analyticsColumn            `json:"columns"`
+	Partitions    []analyticsPartitionResponse `json:"partitions"`
+	UpdatedAt     time.Time                    `json:"updated_at"`
+}
+
+// athenaType returns the Athena type of a Parquet column
+func athenaType(k parquet.Kind) string {
+	switch k {
+	case parquet.Int32:
+		return "int"
+	case parquet.Int64:
+		return "bigint"
+	case parquet.Bool:
+		return "boolean"
+	case parquet.Timestamp:
+		return "timestamp"
+	default:
+		return "string"
+	}
+}
+
+// writeAnalyticsManifest rewrites the manifest from the exported partitions
+func writeAnalyticsManifest(ctx context.Context) error {
+	partitions, err := database.ListAnalyticsPartitions()
+	if err != nil {
+		return err
+	}
+	manifest := analyticsManifest{
+		Table:         analyticsTable,
+		Location:      fmt.Sprintf("s3://%s/%s%s/", bucketName, analyticsPrefix(), analyticsTable),
+		Format:        "parquet",
+		Compression:   "gzip",
+		PartitionKeys: []analyticsColumn{{Name: "dt", Type: "string"}},
+		Columns:       make([]analyticsColumn, 0, len(analyticsColumns)),
+		Partitions:    []analyticsPartitionResponse{},
+		UpdatedAt:     time.Now().UTC(),
+	}
+	for _, c := range analyticsColumns {
+		manifest.Columns = append(manifest.Columns, analyticsColumn{Name: c.Name, Type: athenaType(c.Kind)})
+	}
+	for _, p := range partitions {
+		if p.Status != database.AnalyticsDone {
+			continue
+		}
+		manifest.Partitions = append(manifest.Partitions, analyticsPartitionResponse{
+			Day:        p.Day.Format("2006-01-02"),
+			Key:        p.S3Key,
+			Rows:       p.Rows,
+			ExportedAt: p.ExportedAt,
+		})
+	}
+
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(analyticsManifestKey()),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// listAnalyticsPartitionsHandler lists every day of results exported for
+// analytics or being exported, with failed days' errors
+func listAnalyticsPartitionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	partitions, err := database.ListAnalyticsPartitions()
+	if err != nil {
+		log.Printf("Error listing analytics partitions: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing analytics partitions")
+		return
+	}
+
+	items := make([]analyticsPartitionResponse, 0, len(partitions))
+	for _, p := range partitions {
+		items = append(items, analyticsPartitionResponse{
+			Day:        p.Day.Format("2006-01-02"),
+			Status:     p.Status,
+			Key:        p.S3Key,
+			Rows:       p.Rows,
+			Error:      p.Error,
+			ExportedAt: p.ExportedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":    analyticsEnabled(),
+		"partitions": items,
+	})
+}
+
+// exportAnalyticsDayHandler exports one finished day of results again, such
+// as after results of the day were edited, replacing its partition
+func exportAnalyticsDayHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	day, err := time.Parse("2006-01-02", mux.Vars(r)["day"])
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "day must look like 2024-05-01")
+		return
+	}
+	if !day.Before(time.Now().UTC().Truncate(24 * time.Hour)) {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "Only days that have ended can be exported")
+		return
+	}
+
+	claimed, err := database.ClaimAnalyticsDay(day)
+	if err != nil {
+		log.Printf("Error claiming analytics day %s: %v", day.Format("2006-01-02"), err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error exporting analytics")
+		return
+	}
+	if !claimed {
+		apierrors.Respond(w, r, apierrors.CodeExportInProgress, "The day is already being exported")
+		return
+	}
+	rows, err := exportAnalyticsDay(r.Context(), day)
+	if err != nil {
+		log.Printf("Error exporting analytics for %s: %v", day.Format("2006-01-02"), err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error exporting analytics")
+		return
+	}
+	if err := writeAnalyticsManifest(r.Context()); err != nil {
+		log.Printf("Error writing analytics manifest: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(analyticsPartitionResponse{
+		Day:    day.Format("2006-01-02"),
+		Status: database.AnalyticsDone,
+		Key:    analyticsKey(day),
+		Rows:   rows,
+	})
+}
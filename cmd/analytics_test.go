@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/parquet"
+)
+
+func TestAnalyticsKey(t *testing.T) {
+	t.Setenv("ANALYTICS_PREFIX", "lake/")
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "lake/processing_results/dt=2024-05-01/results.parquet", analyticsKey(day))
+	assert.Equal(t, "lake/processing_results/_manifest.json", analyticsManifestKey())
+}
+
+func TestAnalyticsRowMatchesSchema(t *testing.T) {
+	now := time.Now()
+	r := database.AnalyticsResult{
+		ProcessingResult: database.ProcessingResult{ID: "r1", FileID: "f1", Status: "completed", Result: `{"lines":3}`, Source: database.ResultBuiltin, CreatedAt: now, UpdatedAt: now, Version: 1},
+		FileName:         "a.txt",
+		FileCreatedAt:    now,
+	}
+	row := analyticsRow(r)
+	require.Len(t, row, len(analyticsColumns))
+	assert.Nil(t, row[2], "user_id")
+	assert.Equal(t, int64(len(r.Result)), row[11], "result_size")
+
+	// The writer rejects values that don't fit their column
+	w := parquet.NewWriter(&bytes.Buffer{}, analyticsColumns)
+	assert.NoError(t, w.Write(row...))
+
+	r.ResultKey, r.ResultSize = "results/f1/r1.json", 1<<20
+	assert.Equal(t, int64(1<<20), analyticsRow(r)[11])
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+// anonymousUploads limits uploads made without credentials per client
+// address, separately from the general rate limit
+var anonymousUploads = newRateLimiter()
+
+// admitAnonymousUpload checks an upload made without credentials against the
+// anonymous upload mode, writing an error response and returning false if it
+// is off or the client has used up its rate
+func admitAnonymousUpload(w http.ResponseWriter, r *http.Request, s *settings.Settings) bool {
+	if !s.AnonymousUploads {
+		apierrors.Respond(w, r, apierrors.CodeUnauthorized, "Authentication required")
+		return false
+	}
+	if ok, wait := anonymousUploads.allow(clientIP(r), s.AnonymousRateLimit, s.AnonymousRateLimit); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		apierrors.Respond(w, r, apierrors.CodeRateLimited, "Too many anonymous uploads, retry later")
+		return false
+	}
+	return true
+}
+
+// anonymousMaxFileBytes is the size limit of anonymous uploads: their own
+// limit, or the general one if that is smaller
+func anonymousMaxFileBytes(s *settings.Settings) int64 {
+	if s.MaxFileBytes > 0 && s.MaxFileBytes < s.AnonymousMaxFileBytes {
+		return s.MaxFileBytes
+	}
+	return s.AnonymousMaxFileBytes
+}
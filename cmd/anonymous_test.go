@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+func TestAdmitAnonymousUpload(t *testing.T) {
+	s := settings.Defaults()
+	upload := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/files", nil)
+		r.RemoteAddr = "203.0.113.7:5000"
+		w := httptest.NewRecorder()
+		if admitAnonymousUpload(w, r, &s) {
+			w.WriteHeader(http.StatusCreated)
+		}
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, upload().Code)
+
+	s.AnonymousUploads = true
+	s.AnonymousRateLimit = 2
+	assert.Equal(t, http.StatusCreated, upload().Code)
+	assert.Equal(t, http.StatusCreated, upload().Code)
+	w := upload()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestAnonymousMaxFileBytes(t *testing.T) {
+	s := settings.Defaults()
+	assert.Equal(t, int64(1<<20), anonymousMaxFileBytes(&s))
+	s.MaxFileBytes = 1000
+	assert.Equal(t, int64(1000), anonymousMaxFileBytes(&s))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// apiKeyResponse is the JSON form of an API key. Key is only set when the key
+// is created.
+type apiKeyResponse struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Key    string   `json:"key,omitempty"`
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	// AllowedCIDRs are the only ranges the key works from; empty allows any
+	AllowedCIDRs []string   `json:"allowed_cidrs"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+func newAPIKeyResponse(k database.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:           k.ID,
+		Name:         k.Name,
+		Prefix:       k.Prefix,
+		Scopes:       k.Scopes,
+		AllowedCIDRs: k.AllowedCIDRs,
+		ExpiresAt:    k.ExpiresAt,
+		LastUsedAt:   k.LastUsedAt,
+		CreatedAt:    k.CreatedAt,
+	}
+}
+
+// createAPIKeyHandler issues an API key limited to the requested scopes. The
+// key is only shown in this response.
+func createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.Name == "" {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "name is required")
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "expires_in_days must not be negative")
+		return
+	}
+
+	p := auth.PrincipalFromContext(r.Context())
+	key, k, err := auth.CreateAPIKey(p, req.Name, req.Scopes, time.Duration(req.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		log.Printf("Error creating API key: %v", err)
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	resp := newAPIKeyResponse(*k)
+	resp.Key = key
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// listAPIKeysHandler lists the caller's API keys, without the keys themselves
+func listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	keys, err := database.ListAPIKeys(userID)
+	if err != nil {
+		log.Printf("Error listing API keys: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing API keys")
+		return
+	}
+
+	items := make([]apiKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, newAPIKeyResponse(k))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"api_keys": items,
+	})
+}
+
+// deleteAPIKeyHandler revokes one of the caller's API keys
+func deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := auth.UserIDFromContext(r.Context())
+
+	deleted, err := database.DeleteAPIKey(vars["id"], userID)
+	if err != nil {
+		log.Printf("Error deleting API key: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error deleting API key")
+		return
+	}
+	if !deleted {
+		apierrors.Respond(w, r, apierrors.CodeAPIKeyNotFound, "API key not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/athena"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+const (
+	// analyticsQueryDefaultDays and analyticsQueryMaxDays bound how many
+	// exported days a query reads
+	analyticsQueryDefaultDays = 7
+	analyticsQueryMaxDays     = 366
+	// analyticsQueryMaxRows caps the rows kept of a query's result
+	analyticsQueryMaxRows = 1000
+	// analyticsQueryRunsListed is how many recent runs are listed
+	analyticsQueryRunsListed = 50
+)
+
+// athenaClient runs the predefined analytics queries over the exported
+// partitions
+var athenaClient *athena.Client
+
+// newAthenaClient configures Athena from ATHENA_DATABASE, the Glue database
+// holding the processing_results table, ATHENA_WORKGROUP and
+// ATHENA_OUTPUT_LOCATION, which defaults to athena-results/ under the
+// analytics prefix
+func newAthenaClient(cfg aws.Config) *athena.Client {
+	glueDatabase := os.Getenv("ATHENA_DATABASE")
+	if glueDatabase == "" {
+		glueDatabase = "default"
+	}
+	output := os.Getenv("ATHENA_OUTPUT_LOCATION")
+	if output == "" {
+		output = fmt.Sprintf("s3://%s/%sathena-results/", bucketName, analyticsPrefix())
+	}
+	return athena.New(cfg, glueDatabase, os.Getenv("ATHENA_WORKGROUP"), output)
+}
+
+// analyticsQueryCacheTTL is how long a query's result is served again for
+// the same parameters, from ATHENA_CACHE_TTL
+func analyticsQueryCacheTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("ATHENA_CACHE_TTL")); err == nil && ttl >= 0 {
+		return ttl
+	}
+	return time.Hour
+}
+
+// analyticsQuery is a predefined query over the exported results. A result's
+// processor is its source, as in the SLA report.
+type analyticsQuery struct {
+	Description string
+	// SQL is the query reading the partitions from dt from up to, not
+	// including, dt until
+	SQL string
+}
+
+// analyticsQueries are the queries administrators can run, by name. Their
+// SQL is formatted with the table and the range of days, so nothing from the
+// request ends up in it.
+var analyticsQueries = map[string]analyticsQuery{
+	"failure_rate_by_processor": {
+		Description: "Results and the share of them that failed, per processor",
+		SQL: `SELECT source AS processor, COUNT(*) AS results, COUNT_IF(status = 'failed') AS failures,
+	ROUND(100.0 * COUNT_IF(status = 'failed') / COUNT(*), 2) AS failure_rate
+FROM %[1]s
+WHERE dt >= '%[2]s' AND dt < '%[3]s'
+GROUP BY source
+ORDER BY failure_rate DESC, processor`,
+	},
+	"failure_rate_by_content_type": {
+		Description: "Results and the share of them that failed, per detected content type",
+		SQL: `SELECT COALESCE(content_type, 'unknown') AS content_type, COUNT(*) AS results, COUNT_IF(status = 'failed') AS failures,
+	ROUND(100.0 * COUNT_IF(status = 'failed') / COUNT(*), 2) AS failure_rate
+FROM %[1]s
+WHERE dt >= '%[2]s' AND dt < '%[3]s'
+GROUP BY 1
+ORDER BY failure_rate DESC, content_type`,
+	},
+	"daily_outcomes": {
+		Description: "Results per day and status",
+		SQL: `SELECT dt, status, COUNT(*) AS results
+FROM %[1]s
+WHERE dt >= '%[2]s' AND dt < '%[3]s'
+GROUP BY dt, status
+ORDER BY dt, status`,
+	},
+	"processing_latency": {
+		Description: "Milliseconds from upload to result, as percentiles per processor",
+		SQL: `SELECT source AS processor, COUNT(*) AS results,
+	approx_percentile(date_diff('millisecond', file_created_at, created_at), 0.5) AS p50_ms,
+	approx_percentile(date_diff('millisecond', file_created_at, created_at), 0.95) AS p95_ms,
+	approx_percentile(date_diff('millisecond', file_created_at, created_at), 0.99) AS p99_ms
+FROM %[1]s
+WHERE dt >= '%[2]s' AND dt < '%[3]s' AND reused_from IS NULL
+GROUP BY source
+ORDER BY processor`,
+	},
+	"top_failing_tenants": {
+		Description: "The 20 users with the most failed results",
+		SQL: `SELECT user_id, COUNT(*) AS results, COUNT_IF(status = 'failed') AS failures
+FROM %[1]s
+WHERE dt >= '%[2]s' AND dt < '%[3]s' AND user_id IS NOT NULL
+GROUP BY user_id
+HAVING COUNT_IF(status = 'failed') > 0
+ORDER BY failures DESC, user_id
+LIMIT 20`,
+	},
+}
+
+// analyticsQueryParams are a query run's parameters. From and Until follow
+// from Days and the day the query is run, so a cached result is only served
+// on the day it was produced.
+type analyticsQueryParams struct {
+	Days  int    `json:"days"`
+	From  string `json:"from"`
+	Until string `json:"until"`
+}
+
+// newAnalyticsQueryParams covers the days finished before now, which are the
+// ones exported
+func newAnalyticsQueryParams(days int, now time.Time) (analyticsQueryParams, error) {
+	if days == 0 {
+		days = analyticsQueryDefaultDays
+	}
+	if days < 1 || days > analyticsQueryMaxDays {
+		return analyticsQueryParams{}, fmt.Errorf("days must be between 1 and %d", analyticsQueryMaxDays)
+	}
+	until := now.UTC().Truncate(24 * time.Hour)
+	return analyticsQueryParams{
+		Days:  days,
+		From:  until.AddDate(0, 0, -days).Format("2006-01-02"),
+		Until: until.Format("2006-01-02"),
+	}, nil
+}
+
+// sql returns the query's SQL for the parameters
+func (q analyticsQuery) sql(p analyticsQueryParams) string {
+	return fmt.Sprintf(q.SQL, analyticsTable, p.From, p.Until)
+}
+
+// analyticsQueryResult is a successful run's result as stored
+type analyticsQueryResult struct {
+	Columns   []string    `json:"columns"`
+	Rows      [][]*string `json:"rows"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+type analyticsQueryRunResponse struct {
+	ID               string          `json:"id"`
+	Query            string          `json:"query"`
+	Params           json.RawMessage `json:"params"`
+	ExecutionID      string          `json:"execution_id"`
+	Status           string          `json:"status"`
+	Error            string          `json:"error,omitempty"`
+	Cached           bool            `json:"cached,omitempty"`
+	DataScannedBytes int64           `json:"data_scanned_bytes"`
+	RequestedBy      string          `json:"requested_by,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+	FinishedAt       *time.Time      `json:"finished_at,omitempty"`
+	// Result is included once the run succeeded, when a single run is
+	// requested
+	Result *json.RawMessage `json:"result,omitempty"`
+}
+
+func toAnalyticsQueryRunResponse(q *database.AnalyticsQuery, withResult bool) analyticsQueryRunResponse {
+	resp := analyticsQueryRunResponse{
+		ID:               q.ID,
+		Query:            q.Name,
+		Params:           json.RawMessage(q.Params),
+		ExecutionID:      q.ExecutionID,
+		Status:           q.Status,
+		Error:            q.Error,
+		DataScannedBytes: q.DataScannedBytes,
+		RequestedBy:      q.RequestedBy,
+		CreatedAt:        q.CreatedAt,
+		FinishedAt:       q.FinishedAt,
+	}
+	if withResult && q.Result != "" {
+		result := json.RawMessage(q.Result)
+		resp.Result = &result
+	}
+	return resp
+}
+
+// refreshAnalyticsQuery checks with Athena whether a running query has
+// finished and if so records its result or error
+func refreshAnalyticsQuery(ctx context.Context, q *database.AnalyticsQuery) (*database.AnalyticsQuery, error) {
+	if q.Status != database.QueryRunning {
+		return q, nil
+	}
+	e, err := athenaClient.GetExecution(ctx, q.ExecutionID)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Done() {
+		return q, nil
+	}
+	if e.State != athena.StateSucceeded {
+		message := e.Reason
+		if message == "" {
+			message = "Query " + e.State
+		}
+		return database.FinishAnalyticsQuery(q.ID, database.QueryFailed, message, "", e.DataScannedBytes)
+	}
+
+	rs, err := athenaClient.GetResults(ctx, q.ExecutionID, analyticsQueryMaxRows)
+	if err != nil {
+		return nil, err
+	}
+	result, err := json.Marshal(analyticsQueryResult{Columns: rs.Columns, Rows: rs.Rows, Truncated: rs.Truncated})
+	if err != nil {
+		return nil, err
+	}
+	return database.FinishAnalyticsQuery(q.ID, database.QuerySucceeded, "", string(result), e.DataScannedBytes)
+}
+
+// listAnalyticsQueriesHandler lists the predefined analytics queries
+func listAnalyticsQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	names := make([]string, 0, len(analyticsQueries))
+	for name := range analyticsQueries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	items := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		items = append(items, map[string]interface{}{
+			"name":         name,
+			"description":  analyticsQueries[name].Description,
+			"default_days": analyticsQueryDefaultDays,
+			"max_days":     analyticsQueryMaxDays,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"database": athenaClient.Database,
+		"table":    analyticsTable,
+		"queries":  items,
+	})
+}
+
+// runAnalyticsQueryHandler starts a predefined query in Athena. A run of the
+// same query with the same parameters within the cache TTL is returned
+// instead, unless refresh is set: with its result if it succeeded, otherwise
+// still running.
+func runAnalyticsQueryHandler(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+	if !principal.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	name := mux.Vars(r)["name"]
+	query, ok := analyticsQueries[name]
+	if !ok {
+		apierrors.Respond(w, r, apierrors.CodeNotFound, "Unknown analytics query")
+		return
+	}
+	var req struct {
+		Days    int  `json:"days"`
+		Refresh bool `json:"refresh"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	params, err := newAnalyticsQueryParams(req.Days, time.Now())
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, err.Error())
+		return
+	}
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error running analytics query")
+		return
+	}
+
+	if ttl := analyticsQueryCacheTTL(); !req.Refresh && ttl > 0 {
+		cached, err := database.FindCachedAnalyticsQuery(name, string(paramsJSON), time.Now().Add(-ttl))
+		if err != nil {
+			log.Printf("Error looking up cached analytics query %s: %v", name, err)
+		} else if cached != nil {
+			if cached, err = refreshAnalyticsQuery(r.Context(), cached); err != nil {
+				log.Printf("Error checking analytics query %s: %v", name, err)
+				apierrors.Respond(w, r, apierrors.CodeInternal, "Error checking analytics query")
+				return
+			}
+			resp := toAnalyticsQueryRunResponse(cached, true)
+			resp.Cached = true
+			w.Header().Set("Content-Type", "application/json")
+			if cached.Status == database.QueryRunning {
+				w.WriteHeader(http.StatusAccepted)
+			}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	executionID, err := athenaClient.StartQuery(r.Context(), query.sql(params))
+	if err != nil {
+		log.Printf("Error starting analytics query %s: %v", name, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error starting analytics query")
+		return
+	}
+	run, err := database.SaveAnalyticsQuery(database.AnalyticsQuery{
+		Name:        name,
+		Params:      string(paramsJSON),
+		ExecutionID: executionID,
+		RequestedBy: principal.UserID,
+	})
+	if err != nil {
+		log.Printf("Error saving analytics query %s: %v", name, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error starting analytics query")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(toAnalyticsQueryRunResponse(run, false))
+}
+
+// listAnalyticsQueryRunsHandler lists the latest query runs, without their
+// results
+func listAnalyticsQueryRunsHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	runs, err := database.ListAnalyticsQueries(analyticsQueryRunsListed)
+	if err != nil {
+		log.Printf("Error listing analytics queries: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing analytics queries")
+		return
+	}
+
+	items := make([]analyticsQueryRunResponse, 0, len(runs))
+	for i := range runs {
+		items = append(items, toAnalyticsQueryRunResponse(&runs[i], false))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"runs": items})
+}
+
+// getAnalyticsQueryRunHandler reports a query run's status, checking with
+// Athena while it runs, and its result once it succeeded
+func getAnalyticsQueryRunHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	id := mux.Vars(r)["id"]
+	run, err := database.GetAnalyticsQuery(id)
+	if err != nil {
+		log.Printf("Error loading analytics query %s: %v", id, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading analytics query")
+		return
+	}
+	if run == nil {
+		apierrors.Respond(w, r, apierrors.CodeNotFound, "Analytics query run not found")
+		return
+	}
+	if run, err = refreshAnalyticsQuery(r.Context(), run); err != nil {
+		log.Printf("Error checking analytics query %s: %v", id, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error checking analytics query")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toAnalyticsQueryRunResponse(run, true))
+}
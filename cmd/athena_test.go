@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func TestNewAnalyticsQueryParams(t *testing.T) {
+	now := time.Date(2024, 5, 8, 15, 30, 0, 0, time.UTC)
+
+	p, err := newAnalyticsQueryParams(0, now)
+	require.NoError(t, err)
+	assert.Equal(t, analyticsQueryParams{Days: 7, From: "2024-05-01", Until: "2024-05-08"}, p)
+
+	p, err = newAnalyticsQueryParams(1, now)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-05-07", p.From)
+
+	_, err = newAnalyticsQueryParams(-1, now)
+	assert.Error(t, err)
+	_, err = newAnalyticsQueryParams(analyticsQueryMaxDays+1, now)
+	assert.Error(t, err)
+}
+
+func TestAnalyticsQueriesRender(t *testing.T) {
+	p := analyticsQueryParams{Days: 7, From: "2024-05-01", Until: "2024-05-08"}
+	for name, q := range analyticsQueries {
+		sql := q.sql(p)
+		assert.NotContains(t, sql, "%!", name)
+		assert.Contains(t, sql, "FROM "+analyticsTable, name)
+		assert.Contains(t, sql, "dt >= '2024-05-01' AND dt < '2024-05-08'", name)
+		assert.NotEmpty(t, q.Description, name)
+	}
+	assert.True(t, strings.HasPrefix(analyticsQueries["failure_rate_by_processor"].sql(p), "SELECT source AS processor"))
+}
+
+func TestAnalyticsQueryRunResponse(t *testing.T) {
+	q := &database.AnalyticsQuery{
+		ID:          "run-1",
+		Name:        "daily_outcomes",
+		Params:      `{"days":7,"from":"2024-05-01","until":"2024-05-08"}`,
+		ExecutionID: "q-1",
+		Status:      database.QuerySucceeded,
+		Result:      `{"columns":["dt","status","results"],"rows":[["2024-05-01","failed","2"]]}`,
+		CreatedAt:   time.Now(),
+	}
+
+	body, err := json.Marshal(toAnalyticsQueryRunResponse(q, true))
+	require.NoError(t, err)
+	var decoded struct {
+		Params map[string]interface{}
+		Result analyticsQueryResult
+	}
+	require.NoError(t, json.Unmarshal(body, &decoded))
+	assert.Equal(t, float64(7), decoded.Params["days"])
+	assert.Equal(t, []string{"dt", "status", "results"}, decoded.Result.Columns)
+	require.Len(t, decoded.Result.Rows, 1)
+	assert.Equal(t, "failed", *decoded.Result.Rows[0][1])
+
+	body, err = json.Marshal(toAnalyticsQueryRunResponse(q, false))
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), `"result"`)
+}
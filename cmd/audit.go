@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+// redacted replaces sensitive values in audit records
+const redacted = "[REDACTED]"
+
+// sensitiveKey matches JSON fields and query parameters whose values are
+// credentials
+var sensitiveKey = regexp.MustCompile(`(?i)(password|token|secret|authorization|api_?key|signature|credential|^sig$|^code$|^state$)`)
+
+// emailPattern matches email addresses anywhere in a body
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// sensitiveJSONField matches a sensitive string field in JSON that could not
+// be parsed, typically because it was cut off at the size cap
+var sensitiveJSONField = regexp.MustCompile(`(?i)("[^"]*(?:password|token|secret|authorization|api_?key)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+
+// auditRecord is one logged request
+type auditRecord struct {
+	Method       string `json:"method"`
+	Route        string `json:"route"`
+	ClientIP     string `json:"client_ip"`
+	Query        string `json:"query,omitempty"`
+	Status       int    `json:"status"`
+	DurationMS   int64  `json:"duration_ms"`
+	RequestBody  string `json:"request_body,omitempty"`
+	ResponseBody string `json:"response_body,omitempty"`
+	Truncated    bool   `json:"truncated,omitempty"`
+}
+
+// auditMiddleware logs every request and response with its body when audit
+// logging is enabled in the settings. Requests are logged by route template
+// rather than path, as paths can hold share and upload tokens. Credentials
+// and email addresses are redacted and each body is capped at AuditMaxBytes.
+func auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := settings.Current()
+		if !s.AuditBodies {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+
+		// Keep the head of the body for the log and hand the handler all of it
+		var reqBody []byte
+		if r.Body != nil {
+			reqBody, _ = io.ReadAll(io.LimitReader(r.Body, int64(s.AuditMaxBytes)+1))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(reqBody), r.Body), r.Body}
+		}
+
+		rec := &auditRecorder{ResponseWriter: w, status: http.StatusOK, limit: s.AuditMaxBytes}
+		next.ServeHTTP(rec, r)
+
+		truncated := len(reqBody) > s.AuditMaxBytes || rec.truncated
+		if len(reqBody) > s.AuditMaxBytes {
+			reqBody = reqBody[:s.AuditMaxBytes]
+		}
+		entry, err := json.Marshal(auditRecord{
+			Method:       r.Method,
+			Route:        routeTemplate(r),
+			ClientIP:     clientIP(r),
+			Query:        redactQuery(r.URL.Query()),
+			Status:       rec.status,
+			DurationMS:   time.Since(start).Milliseconds(),
+			RequestBody:  redactBody(r.Header.Get("Content-Type"), reqBody),
+			ResponseBody: redactBody(rec.Header().Get("Content-Type"), rec.body.Bytes()),
+			Truncated:    truncated,
+		})
+		if err != nil {
+			log.Printf("Error encoding audit record: %v", err)
+			return
+		}
+		log.Printf("audit %s", entry)
+	})
+}
+
+// auditRecorder passes a response through while keeping its status and the
+// first limit bytes of its body
+type auditRecorder struct {
+	http.ResponseWriter
+	status    int
+	limit     int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (ar *auditRecorder) WriteHeader(status int) {
+	ar.status = status
+	ar.ResponseWriter.WriteHeader(status)
+}
+
+func (ar *auditRecorder) Write(b []byte) (int, error) {
+	if room := ar.limit - ar.body.Len(); room < len(b) {
+		ar.body.Write(b[:room])
+		ar.truncated = true
+	} else {
+		ar.body.Write(b)
+	}
+	return ar.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// streamed responses can still be flushed
+func (ar *auditRecorder) Unwrap() http.ResponseWriter {
+	return ar.ResponseWriter
+}
+
+// redactBody returns a body for the audit log. JSON has its sensitive fields
+// replaced; text has email addresses masked; anything else is only described.
+func redactBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	isJSON := mediaType == "" || strings.HasSuffix(mediaType, "json")
+	if !isJSON && !strings.HasPrefix(mediaType, "text/") && mediaType != "application/x-www-form-urlencoded" {
+		return "[" + mediaType + " body omitted]"
+	}
+
+	if isJSON {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err == nil {
+			if out, err := json.Marshal(redactValue(v)); err == nil {
+				return string(out)
+			}
+		}
+	}
+	text := sensitiveJSONField.ReplaceAllString(string(body), `$1"`+redacted+`"`)
+	return emailPattern.ReplaceAllString(text, redacted)
+}
+
+// redactValue replaces sensitive fields and email addresses in decoded JSON
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			if sensitiveKey.MatchString(k) {
+				v[k] = redacted
+			} else {
+				v[k] = redactValue(val)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, val := range v {
+			v[i] = redactValue(val)
+		}
+		return v
+	case string:
+		return emailPattern.ReplaceAllString(v, redacted)
+	}
+	return v
+}
+
+// redactQuery encodes a query string with sensitive parameters masked
+func redactQuery(query url.Values) string {
+	for k, values := range query {
+		for i := range values {
+			if sensitiveKey.MatchString(k) {
+				values[i] = redacted
+			} else {
+				values[i] = emailPattern.ReplaceAllString(values[i], redacted)
+			}
+		}
+	}
+	return query.Encode()
+}
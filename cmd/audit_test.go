@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+func TestRedactBodyJSON(t *testing.T) {
+	body := `{"username":"alice","password":"hunter2","email":"alice@example.com","nested":{"access_token":"abc"}}`
+	got := redactBody("application/json", []byte(body))
+	assert.NotContains(t, got, "hunter2")
+	assert.NotContains(t, got, "alice@example.com")
+	assert.NotContains(t, got, "abc")
+	assert.Contains(t, got, `"username":"alice"`)
+}
+
+func TestRedactBodyTruncatedJSON(t *testing.T) {
+	got := redactBody("application/json", []byte(`{"password":"hunter2","email":"bob@exam`))
+	assert.NotContains(t, got, "hunter2")
+
+	got = redactBody("application/json", []byte(`{"name":"a","token":"secret-val`))
+	assert.NotContains(t, got, "secret-val")
+}
+
+func TestRedactBodyOmitsBinary(t *testing.T) {
+	assert.Equal(t, "[application/zip body omitted]", redactBody("application/zip", []byte("PK\x03\x04")))
+}
+
+func TestRedactQuery(t *testing.T) {
+	got := redactQuery(url.Values{"password": {"hunter2"}, "q": {"carol@example.com"}, "limit": {"10"}})
+	assert.NotContains(t, got, "hunter2")
+	assert.NotContains(t, got, "carol")
+	assert.Contains(t, got, "limit=10")
+
+	// Login callbacks and presigned URLs carry credentials of their own
+	got = redactQuery(url.Values{"code": {"c0de"}, "state": {"st4te"}, "X-Amz-Signature": {"s1g"}, "X-Amz-Credential": {"AKIA"}})
+	for _, secret := range []string{"c0de", "st4te", "s1g", "AKIA"} {
+		assert.NotContains(t, got, secret)
+	}
+}
+
+func TestAuditMiddlewareLogsRouteTemplate(t *testing.T) {
+	s := settings.Defaults()
+	s.AuditBodies = true
+	assert.NoError(t, settings.Set(s))
+	t.Cleanup(func() { settings.Set(settings.Defaults()) })
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	r := mux.NewRouter()
+	r.Use(auditMiddleware)
+	r.HandleFunc("/share/{token}", func(w http.ResponseWriter, r *http.Request) {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/share/s3cret-token?password=hunter2", nil))
+
+	assert.Contains(t, buf.String(), `"route":"/share/{token}"`)
+	assert.NotContains(t, buf.String(), "s3cret-token")
+	assert.NotContains(t, buf.String(), "hunter2")
+}
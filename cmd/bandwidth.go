@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/tenants"
+)
+
+// maxBandwidthChunk bounds how many bytes a throttled stream moves between
+// waits, so a large write doesn't leave the caller idle for a long time and
+// then burst
+const maxBandwidthChunk = 32 << 10
+
+// Directions bandwidth is limited in
+const (
+	bandwidthUpload   = "upload"
+	bandwidthDownload = "download"
+)
+
+// bandwidthBucket is a token bucket of bytes shared by every stream of one
+// caller in one direction. It holds at most a second's worth of bytes.
+type bandwidthBucket struct {
+	rate    float64
+	tokens  float64
+	last    time.Time
+	streams int
+}
+
+// bandwidthLimiter caps the bytes per second each caller moves. Streams take
+// bytes before moving them and wait out any debt, so a caller's concurrent
+// streams share the rate between them instead of each getting all of it.
+// Rates come from the caller's settings when a stream starts, so a change
+// applies from the next stream on.
+type bandwidthLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bandwidthBucket
+	now     func() time.Time
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+func newBandwidthLimiter() *bandwidthLimiter {
+	return &bandwidthLimiter{buckets: make(map[string]*bandwidthBucket), now: time.Now, sleep: sleepContext}
+}
+
+// bandwidth limits the streaming upload and download endpoints
+var bandwidth = newBandwidthLimiter()
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// open starts a stream for key limited to rate bytes per second. The stream
+// must be closed with release once it is done.
+func (l *bandwidthLimiter) open(ctx context.Context, key string, rate int64) *throttle {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bandwidthBucket{tokens: float64(rate), last: l.now()}
+		l.buckets[key] = b
+	}
+	b.rate = float64(rate)
+	b.streams++
+
+	chunk := maxBandwidthChunk
+	if rate < int64(chunk) {
+		chunk = int(rate)
+	}
+	return &throttle{l: l, key: key, b: b, ctx: ctx, chunk: chunk}
+}
+
+// take takes n bytes from b and returns how long to wait before moving them
+func (l *bandwidthLimiter) take(b *bandwidthBucket, n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b.tokens = math.Min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// release ends a stream started by open, dropping the bucket after the last
+func (l *bandwidthLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		return
+	}
+	if b.streams--; b.streams <= 0 {
+		delete(l.buckets, key)
+	}
+}
+
+// throttle is one stream of a caller's bandwidth
+type throttle struct {
+	l     *bandwidthLimiter
+	key   string
+	b     *bandwidthBucket
+	ctx   context.Context
+	chunk int
+}
+
+// wait blocks until n more bytes may be moved
+func (t *throttle) wait(n int) error {
+	if d := t.l.take(t.b, n); d > 0 {
+		return t.l.sleep(t.ctx, d)
+	}
+	return nil
+}
+
+func (t *throttle) release() {
+	t.l.release(t.key)
+}
+
+// throttledBody reads a request body no faster than its throttle allows
+type throttledBody struct {
+	io.ReadCloser
+	t *throttle
+}
+
+func (r *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > r.t.chunk {
+		p = p[:r.t.chunk]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := r.t.wait(n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// throttledResponse writes a response no faster than its throttle allows
+type throttledResponse struct {
+	http.ResponseWriter
+	t *throttle
+}
+
+func (w *throttledResponse) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > w.t.chunk {
+			n = w.t.chunk
+		}
+		if err := w.t.wait(n); err != nil {
+			return written, err
+		}
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *throttledResponse) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bandwidthRate returns the bytes per second userID may move in direction, 0
+// for no limit. Callers without a user get the global rate.
+func bandwidthRate(userID, direction string) int64 {
+	s, err := tenants.For(userID)
+	if err != nil {
+		// Failing open keeps transfers working while the database is down
+		log.Printf("Error loading settings of user %s, not limiting bandwidth: %v", userID, err)
+		return 0
+	}
+	if direction == bandwidthUpload {
+		return s.UploadBytesPerSecond
+	}
+	return s.DownloadBytesPerSecond
+}
+
+// bandwidthKey is the bucket of userID's streams in direction, or of the
+// client's address when there is no user
+func bandwidthKey(r *http.Request, userID, direction string) string {
+	if userID == "" {
+		return direction + "\x00ip:" + clientIP(r)
+	}
+	return direction + "\x00" + userID
+}
+
+// throttleUpload limits how fast r's body is read to userID's upload rate.
+// The returned function ends the stream and must be called once the body has
+// been read.
+func throttleUpload(r *http.Request, userID string) func() {
+	rate := bandwidthRate(userID, bandwidthUpload)
+	if rate == 0 {
+		return func() {}
+	}
+	t := bandwidth.open(r.Context(), bandwidthKey(r, userID, bandwidthUpload), rate)
+	r.Body = &throttledBody{ReadCloser: r.Body, t: t}
+	return t.release
+}
+
+// throttleDownloads wraps a streaming handler so that it writes to each
+// caller no faster than their download rate, shared by all of their
+// downloads at once. Anonymous callers are told apart by address.
+func throttleDownloads(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.UserIDFromContext(r.Context())
+		rate := bandwidthRate(userID, bandwidthDownload)
+		if rate == 0 {
+			next(w, r)
+			return
+		}
+		t := bandwidth.open(r.Context(), bandwidthKey(r, userID, bandwidthDownload), rate)
+		defer t.release()
+		next(&throttledResponse{ResponseWriter: w, t: t}, r)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClockLimiter returns a limiter whose sleeps advance its clock instead
+// of blocking, and the total time slept
+func fakeClockLimiter() (*bandwidthLimiter, *time.Duration) {
+	now := time.Now()
+	var slept time.Duration
+	l := newBandwidthLimiter()
+	l.now = func() time.Time { return now }
+	l.sleep = func(_ context.Context, d time.Duration) error {
+		slept += d
+		now = now.Add(d)
+		return nil
+	}
+	return l, &slept
+}
+
+func TestBandwidthLimiterPacesReads(t *testing.T) {
+	l, slept := fakeClockLimiter()
+	tr := l.open(context.Background(), "u1", 1000)
+	defer tr.release()
+
+	// The first second's worth is free, the rest is paced at the rate
+	body := &throttledBody{ReadCloser: io.NopCloser(bytes.NewReader(make([]byte, 3000))), t: tr}
+	n, err := io.Copy(io.Discard, body)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3000), n)
+	assert.Equal(t, 2*time.Second, *slept)
+}
+
+func TestBandwidthLimiterSharesRateBetweenStreams(t *testing.T) {
+	l, slept := fakeClockLimiter()
+	a := l.open(context.Background(), "u1", 1000)
+	b := l.open(context.Background(), "u1", 1000)
+
+	wa := &throttledResponse{ResponseWriter: httptest.NewRecorder(), t: a}
+	wb := &throttledResponse{ResponseWriter: httptest.NewRecorder(), t: b}
+	for i := 0; i < 2; i++ {
+		_, err := wa.Write(make([]byte, 1000))
+		assert.NoError(t, err)
+		_, err = wb.Write(make([]byte, 1000))
+		assert.NoError(t, err)
+	}
+	// 4000 bytes at 1000/s, less the first second's allowance
+	assert.Equal(t, 3*time.Second, *slept)
+
+	// Another caller has its own bucket
+	c := l.open(context.Background(), "u2", 1000)
+	before := *slept
+	assert.NoError(t, c.wait(1000))
+	assert.Equal(t, before, *slept)
+
+	a.release()
+	b.release()
+	c.release()
+	assert.Empty(t, l.buckets)
+}
+
+func TestThrottledResponseStopsWhenRequestIsCancelled(t *testing.T) {
+	l := newBandwidthLimiter()
+	ctx, cancel := context.WithCancel(context.Background())
+	tr := l.open(ctx, "u1", 10)
+	defer tr.release()
+	cancel()
+
+	rec := httptest.NewRecorder()
+	w := &throttledResponse{ResponseWriter: rec, t: tr}
+	n, err := w.Write(make([]byte, 100))
+	assert.ErrorIs(t, err, context.Canceled)
+	// The first second's worth was written before the wait
+	assert.Equal(t, 10, n)
+	assert.Equal(t, 10, rec.Body.Len())
+}
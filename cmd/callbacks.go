@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/queue"
+	"github.com/yourusername/golang-aws-api/signing"
+)
+
+// Headers of a signed callback. The signature is the HMAC-SHA256 of the
+// timestamp, a dot and the body, keyed with the integration's secret, as
+// "sha256=<hex>"; the timestamp is in Unix seconds.
+const (
+	callbackSignatureHeader = "X-Signature"
+	callbackTimestampHeader = "X-Signature-Timestamp"
+)
+
+// callbackTolerance is how far a callback's timestamp may be from the
+// server's clock. Signatures are remembered for twice as long, so a captured
+// call can't be replayed at all.
+const callbackTolerance = 5 * time.Minute
+
+// maxCallbackBody caps the size of a callback body
+const maxCallbackBody = 1 << 20
+
+type integrationKey struct{}
+
+// integrationFromContext returns the integration a verified callback came
+// from
+func integrationFromContext(ctx context.Context) *database.Integration {
+	in, _ := ctx.Value(integrationKey{}).(*database.Integration)
+	return in
+}
+
+// integrationUploadPrefix is where an integration puts objects it reports.
+// Other keys are refused, so an integration can't claim objects that belong
+// to someone else.
+func integrationUploadPrefix(id string) string {
+	return "integrations/" + id + "/"
+}
+
+// verifyCallback only passes on callbacks signed by the integration named in
+// the path, with one of its current secrets, recently, and not before
+func verifyCallback(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		in, err := database.GetIntegration(mux.Vars(r)["integration"])
+		if err != nil {
+			log.Printf("Error loading integration: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error verifying callback")
+			return
+		}
+		if in == nil {
+			// Answered like a bad signature, so integration IDs can't be probed
+			apierrors.Respond(w, r, apierrors.CodeSignatureInvalid, "Callback signature invalid")
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxCallbackBody+1))
+		if err != nil {
+			apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Error reading request body")
+			return
+		}
+		if len(body) > maxCallbackBody {
+			apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Request body too large")
+			return
+		}
+
+		signature := r.Header.Get(callbackSignatureHeader)
+		_, err = signing.Verify(signature, r.Header.Get(callbackTimestampHeader), body, in.Secrets(time.Now()), time.Now(), callbackTolerance)
+		if err != nil {
+			log.Printf("Rejected callback for integration %s: %v", in.ID, err)
+			apierrors.Respond(w, r, apierrors.CodeSignatureInvalid, "Callback signature invalid")
+			return
+		}
+		fresh, err := database.RecordCallbackSignature(in.ID, signature)
+		if err != nil {
+			log.Printf("Error recording callback signature: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error verifying callback")
+			return
+		}
+		if !fresh {
+			log.Printf("Rejected replayed callback for integration %s", in.ID)
+			apierrors.Respond(w, r, apierrors.CodeSignatureInvalid, "Callback already received")
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r.WithContext(context.WithValue(r.Context(), integrationKey{}, in)))
+	}
+}
+
+// uploadCompleteCallbackHandler is called by an integration once it has put
+// an object under its upload prefix. The object is registered as a file of
+// the integration's user and sent for processing.
+func uploadCompleteCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	in := integrationFromContext(r.Context())
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	prefix := integrationUploadPrefix(in.ID)
+	if !strings.HasPrefix(req.Key, prefix) || strings.HasSuffix(req.Key, "/") {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "key must be an object under "+prefix)
+		return
+	}
+
+	f, err := importObject(r.Context(), in.UserID, req.Key, database.NamePolicy())
+	if errors.Is(err, errObjectNotFound) {
+		apierrors.Respond(w, r, apierrors.CodeObjectNotFound, "Object not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error importing object for integration %s: %v", in.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error importing object")
+		return
+	}
+	if f == nil {
+		// Already registered by an earlier call, or its name was rejected
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"imported": false,
+		})
+		return
+	}
+
+	if err := queue.PublishFileEvent(r.Context(), f.ID, bucketName, f.S3Key, f.ETag); err != nil {
+		log.Printf("Error enqueuing file %s from integration %s: %v", f.ID, in.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported": true,
+		"file":     f,
+	})
+}
+
+// purgeCallbackSignatures forgets signatures old enough that their
+// timestamps are no longer accepted anyway
+func purgeCallbackSignatures() {
+	n, err := database.DeleteCallbackSignatures(time.Now().Add(-2 * callbackTolerance))
+	if err != nil {
+		log.Printf("Error deleting callback signatures: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Deleted %d callback signatures", n)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/ingest"
+	"github.com/yourusername/golang-aws-api/storage"
+)
+
+// chunkResponse is the JSON form of a chunk of a file's checksum manifest
+type chunkResponse struct {
+	Number     int        `json:"number"`
+	Offset     int64      `json:"offset"`
+	SizeBytes  int64      `json:"size_bytes"`
+	SHA256     string     `json:"sha256"`
+	Corrupt    bool       `json:"corrupt"`
+	VerifiedAt *time.Time `json:"verified_at"`
+}
+
+func newChunkResponse(c database.FileChunk) chunkResponse {
+	return chunkResponse{
+		Number:     c.Number,
+		Offset:     c.Offset,
+		SizeBytes:  c.Size,
+		SHA256:     c.SHA256,
+		Corrupt:    c.Corrupt,
+		VerifiedAt: c.VerifiedAt,
+	}
+}
+
+// uploadObject uploads a file's object and, when it went up in parts, stores
+// the checksums of its chunks. It returns the object's ETag.
+func uploadObject(ctx context.Context, fileID string, input *s3.PutObjectInput) (string, error) {
+	chunks, etag, err := s3Client.UploadWithChecksums(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	ingest.SaveChunks(fileID, chunks)
+	return etag, nil
+}
+
+// fileChunks loads the checksum manifest of a file the caller may access,
+// writing an error response and returning false if there is none
+func fileChunks(w http.ResponseWriter, r *http.Request) (*database.File, []database.FileChunk, bool) {
+	f := authorizeFile(w, r, mux.Vars(r)["id"])
+	if f == nil {
+		return nil, nil, false
+	}
+	chunks, err := database.ListFileChunks(f.ID)
+	if err != nil {
+		log.Printf("Error loading chunk checksums: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading chunk checksums")
+		return nil, nil, false
+	}
+	if len(chunks) == 0 {
+		apierrors.Respond(w, r, apierrors.CodeChunkManifestNotFound, "File was not uploaded in parts")
+		return nil, nil, false
+	}
+	return f, chunks, true
+}
+
+// listChunksHandler returns the checksum manifest of a file uploaded in parts
+func listChunksHandler(w http.ResponseWriter, r *http.Request) {
+	_, chunks, ok := fileChunks(w, r)
+	if !ok {
+		return
+	}
+	items := make([]chunkResponse, 0, len(chunks))
+	for _, c := range chunks {
+		items = append(items, newChunkResponse(c))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"chunks": items,
+	})
+}
+
+// verifyChunksHandler reads a file's object back and checks its chunks
+// against their checksums: all of them, or those listed in ?parts=1,3
+func verifyChunksHandler(w http.ResponseWriter, r *http.Request) {
+	f, chunks, ok := fileChunks(w, r)
+	if !ok {
+		return
+	}
+
+	selected := chunks
+	if v := r.URL.Query().Get("parts"); v != "" {
+		byNumber := make(map[int]database.FileChunk, len(chunks))
+		for _, c := range chunks {
+			byNumber[c.Number] = c
+		}
+		selected = nil
+		for _, s := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			c, exists := byNumber[n]
+			if err != nil || !exists {
+				apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("parts must list part numbers from 1 to %d", len(chunks)))
+				return
+			}
+			selected = append(selected, c)
+		}
+	}
+
+	toCheck := make([]storage.Chunk, 0, len(selected))
+	checked := make([]int, 0, len(selected))
+	for _, c := range selected {
+		toCheck = append(toCheck, storage.Chunk(c.Chunk))
+		checked = append(checked, c.Number)
+	}
+	corrupt, err := s3Client.VerifyChunks(r.Context(), f.S3Key, toCheck)
+	if err != nil {
+		log.Printf("Error verifying file %s: %v", f.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error reading file content")
+		return
+	}
+	if err := database.RecordChunkVerification(f.ID, checked, corrupt); err != nil {
+		log.Printf("Error recording verification of file %s: %v", f.ID, err)
+	}
+	if len(corrupt) > 0 {
+		log.Printf("File %s has corrupt chunks %v", f.ID, corrupt)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"checked": checked,
+		"corrupt": corrupt,
+	})
+}
+
+// replaceChunkHandler re-uploads one chunk of a file, sent as the request
+// body. The body must match the chunk's checksum, so a chunk can only be
+// restored to what was originally uploaded; the rest of the object is copied
+// within S3. Encrypted files are checksummed as ciphertext, which clients
+// don't hold, so they can't be repaired this way.
+func replaceChunkHandler(w http.ResponseWriter, r *http.Request) {
+	f, chunks, ok := fileChunks(w, r)
+	if !ok {
+		return
+	}
+	if f.Encryption.Encrypted() {
+		apierrors.Respond(w, r, apierrors.CodeFileEncrypted, "Chunks of encrypted files can't be re-uploaded")
+		return
+	}
+	number, err := strconv.Atoi(mux.Vars(r)["number"])
+	if err != nil || number < 1 || number > len(chunks) {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("Chunk number must be from 1 to %d", len(chunks)))
+		return
+	}
+	chunk := chunks[number-1]
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, chunk.Size))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierrors.Respond(w, r, apierrors.CodeInvalidBody, fmt.Sprintf("Chunk %d is %d bytes", number, chunk.Size))
+			return
+		}
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != chunk.Size || hex.EncodeToString(sum[:]) != chunk.SHA256 {
+		apierrors.Respond(w, r, apierrors.CodeChecksumMismatch, fmt.Sprintf("Body doesn't match the checksum of chunk %d", number))
+		return
+	}
+
+	all := make([]storage.Chunk, 0, len(chunks))
+	for _, c := range chunks {
+		all = append(all, storage.Chunk(c.Chunk))
+	}
+	if err := s3Client.ReplaceChunk(r.Context(), f.S3Key, all, number, data); err != nil {
+		log.Printf("Error replacing chunk %d of file %s: %v", number, f.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error uploading chunk")
+		return
+	}
+	downloadCache.Invalidate(f.ID)
+	if err := database.RecordChunkVerification(f.ID, []int{number}, nil); err != nil {
+		log.Printf("Error recording verification of file %s: %v", f.ID, err)
+	}
+	log.Printf("Chunk %d of file %s re-uploaded", number, f.ID)
+
+	chunk.Corrupt = false
+	now := time.Now().UTC()
+	chunk.VerifiedAt = &now
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newChunkResponse(chunk))
+}
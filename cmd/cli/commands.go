@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/client"
+	"golang.org/x/term"
+)
+
+// commandContext returns a context cancelled by Ctrl-C
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+func loginCommand(args []string) error {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	server := flags.String("server", "", "API URL (default API_URL or "+defaultServer+")")
+	username := flags.String("username", "", "username")
+	flags.Parse(args)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *server != "" {
+		cfg.Server = strings.TrimRight(*server, "/")
+	} else {
+		cfg.Server = serverURL(cfg)
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	if *username == "" {
+		fmt.Fprint(os.Stderr, "Username: ")
+		*username, _ = in.ReadString('\n')
+	}
+	password, err := readPassword(in)
+	if err != nil {
+		return fmt.Errorf("reading password: %v", err)
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	c := client.New(cfg.Server)
+	token, err := c.SignIn(ctx, strings.TrimSpace(*username), password)
+	if err != nil {
+		return err
+	}
+
+	cfg.Token = token
+	if err := saveConfig(cfg); err != nil {
+		return fmt.Errorf("saving token: %v", err)
+	}
+	fmt.Printf("Logged in to %s\n", cfg.Server)
+	return nil
+}
+
+// readPassword returns the password from API_PASSWORD, or else prompts for it
+// without echoing. When stdin isn't a terminal the password is read as its
+// next line, so it can be piped in. It is never taken as a flag, where it
+// would show in the process list and shell history.
+func readPassword(in *bufio.Reader) (string, error) {
+	if password := os.Getenv("API_PASSWORD"); password != "" {
+		return password, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		password, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return strings.TrimRight(password, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(password), err
+}
+
+func logoutCommand(args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Token = ""
+	return saveConfig(cfg)
+}
+
+func uploadCommand(args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	collection := flags.String("collection", "", "collection ID to upload into")
+	onDuplicate := flags.String("on-duplicate", "", "reject, rename or version when a name is taken (default: server setting)")
+	wait := flags.Bool("wait", false, "wait for the uploaded files to be processed")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("upload needs at least one file or directory")
+	}
+
+	files, err := collectFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	bar := newProgressBar(os.Stderr, len(files), total)
+
+	var ids []string
+	var failed int
+	for _, f := range files {
+		content, err := os.ReadFile(f.path)
+		if err == nil {
+			var out *client.UploadOutput
+			out, err = c.Upload(ctx, client.UploadInput{
+				Name:         f.name,
+				Content:      string(content),
+				CollectionID: *collection,
+				OnDuplicate:  *onDuplicate,
+			})
+			if err == nil {
+				ids = append(ids, out.ID)
+				bar.println(fmt.Sprintf("%s -> %s", f.path, out.ID))
+			}
+		}
+		if err != nil {
+			failed++
+			bar.println(fmt.Sprintf("%s: %v", f.path, err))
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		bar.add(f.size)
+	}
+	bar.finish()
+
+	if *wait && len(ids) > 0 {
+		if err := watch(ctx, c, ids); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d uploads failed", failed, len(files))
+	}
+	return nil
+}
+
+// localFile is a file to upload
+type localFile struct {
+	path string
+	// name is the path relative to the directory given on the command line
+	name string
+	size int64
+}
+
+// collectFiles expands the paths given to upload, walking directories
+func collectFiles(paths []string) ([]localFile, error) {
+	var files []localFile
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, localFile{path: p, name: filepath.Base(p), size: info.Size()})
+			continue
+		}
+
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !d.Type().IsRegular() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(p, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, localFile{path: path, name: filepath.ToSlash(rel), size: info.Size()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func getCommand(args []string) error {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("get needs a file ID")
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	f, err := c.GetFile(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("ID:       %s\n", f.ID)
+	fmt.Printf("Name:     %s\n", f.Name)
+	fmt.Printf("Created:  %s\n", f.CreatedAt.Local().Format(time.RFC1123))
+	fmt.Printf("Updated:  %s\n", f.UpdatedAt.Local().Format(time.RFC1123))
+	fmt.Printf("Version:  %d\n\n", f.Version)
+	fmt.Println(f.Content)
+	return nil
+}
+
+func downloadCommand(args []string) error {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+	output := flags.String("o", "", `output path, or "-" for stdout (default: the file's name)`)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("download needs a file ID")
+	}
+	id := flags.Arg(0)
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		path := *output
+		if path == "" {
+			f, err := c.GetFileInfo(ctx, id)
+			if err != nil {
+				return err
+			}
+			path = filepath.Base(f.Name)
+		}
+		out, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		w = out
+	}
+
+	n, err := c.Download(ctx, id, w)
+	if err != nil {
+		return err
+	}
+	if *output != "-" {
+		fmt.Fprintf(os.Stderr, "Downloaded %s\n", formatBytes(n))
+	}
+	return nil
+}
+
+func resultsCommand(args []string) error {
+	flags := flag.NewFlagSet("results", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("results needs a file ID")
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	results, err := c.ListResults(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		fmt.Println("No processing results yet")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CREATED\tSTATUS\tRESULT")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.CreatedAt.Local().Format(time.DateTime), r.Status, truncate(r.Result, 60))
+	}
+	return tw.Flush()
+}
+
+func watchCommand(args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	timeout := flags.Duration("timeout", 10*time.Minute, "give up after this long")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("watch needs at least one file ID")
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, *timeout)
+	defer cancelTimeout()
+
+	return watch(ctx, c, flags.Args())
+}
+
+// watch waits for each file to be processed and prints its result
+func watch(ctx context.Context, c *client.Client, ids []string) error {
+	for _, id := range ids {
+		fmt.Fprintf(os.Stderr, "Waiting for %s...", id)
+		r, err := c.WaitForResult(ctx, id)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+		fmt.Printf("%s: %s %s\n", id, r.Status, truncate(r.Result, 60))
+	}
+	return nil
+}
+
+// truncate shortens s to at most n runes for table output
+func truncate(s string, n int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
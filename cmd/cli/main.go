@@ -0,0 +1,137 @@
+// Command cli is the end user command-line client for the file processing API.
+//
+//	cli login -username alice
+//	cli upload -wait report.txt docs/
+//	cli get <file-id>
+//	cli download -o report.txt <file-id>
+//	cli results <file-id>
+//	cli watch <file-id>...
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourusername/golang-aws-api/client"
+)
+
+// config is what login stores between runs
+type config struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+}
+
+const defaultServer = "http://localhost:8080"
+
+var commands = map[string]func(args []string) error{
+	"login":    loginCommand,
+	"logout":   logoutCommand,
+	"upload":   uploadCommand,
+	"get":      getCommand,
+	"download": downloadCommand,
+	"results":  resultsCommand,
+	"watch":    watchCommand,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: cli <command> [flags] [args]
+
+Commands:
+  login      sign in and store the access token
+  logout     forget the stored access token
+  upload     upload files or directories
+  get        show a file and its content
+  download   save a file's content
+  results    list a file's processing results
+  watch      wait until files have been processed
+
+Run "cli <command> -h" for a command's flags. The server defaults to
+API_URL, then the server used at login, then `+defaultServer+`. login
+prompts for the password, or takes it from API_PASSWORD.`)
+}
+
+// configPath returns where login stores its config
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "golang-aws-api", "config.json"), nil
+}
+
+// loadConfig reads the stored config, which is empty before the first login
+func loadConfig() (config, error) {
+	var cfg config
+	p, err := configPath()
+	if err != nil {
+		return cfg, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, json.Unmarshal(data, &cfg)
+}
+
+// saveConfig stores cfg, readable only by the current user since it holds the token
+func saveConfig(cfg config) error {
+	p, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o600)
+}
+
+// serverURL picks the API URL from API_URL, the stored config or the default
+func serverURL(cfg config) string {
+	if v := os.Getenv("API_URL"); v != "" {
+		return strings.TrimRight(v, "/")
+	}
+	if cfg.Server != "" {
+		return cfg.Server
+	}
+	return defaultServer
+}
+
+// newClient returns a client using the stored token
+func newClient() (*client.Client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %v", err)
+	}
+	if cfg.Token == "" {
+		return nil, errors.New(`not logged in, run "cli login" first`)
+	}
+	return client.New(serverURL(cfg), client.WithToken(cfg.Token)), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// progressWidth is the width of the bar itself, in characters
+const progressWidth = 30
+
+// progressBar draws upload progress on a single terminal line
+type progressBar struct {
+	w          io.Writer
+	files      int
+	done       int
+	totalBytes int64
+	doneBytes  int64
+}
+
+func newProgressBar(w io.Writer, files int, totalBytes int64) *progressBar {
+	b := &progressBar{w: w, files: files, totalBytes: totalBytes}
+	b.draw()
+	return b
+}
+
+// add records one more finished file of the given size
+func (b *progressBar) add(size int64) {
+	b.done++
+	b.doneBytes += size
+	b.draw()
+}
+
+// println prints a line above the bar
+func (b *progressBar) println(line string) {
+	fmt.Fprintf(b.w, "\r\033[K%s\n", line)
+	b.draw()
+}
+
+// finish ends the bar's line
+func (b *progressBar) finish() {
+	fmt.Fprintln(b.w)
+}
+
+func (b *progressBar) draw() {
+	fmt.Fprintf(b.w, "\r\033[K%s", b.render())
+}
+
+// render returns the bar, such as [=========>          ] 3/10 files, 1.2 MB/4.0 MB
+func (b *progressBar) render() string {
+	fraction := 1.0
+	if b.totalBytes > 0 {
+		fraction = float64(b.doneBytes) / float64(b.totalBytes)
+	} else if b.files > 0 {
+		fraction = float64(b.done) / float64(b.files)
+	}
+	filled := int(fraction * progressWidth)
+
+	bar := strings.Repeat("=", filled)
+	if filled < progressWidth {
+		bar += ">" + strings.Repeat(" ", progressWidth-filled-1)
+	}
+	return fmt.Sprintf("[%s] %d/%d files, %s/%s", bar, b.done, b.files, formatBytes(b.doneBytes), formatBytes(b.totalBytes))
+}
+
+// formatBytes formats a byte count for humans
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.0 KB", formatBytes(1024))
+	assert.Equal(t, "1.5 MB", formatBytes(1536*1024))
+}
+
+func TestProgressBarRender(t *testing.T) {
+	var out bytes.Buffer
+	b := newProgressBar(&out, 4, 4096)
+	assert.Equal(t, "[>                             ] 0/4 files, 0 B/4.0 KB", b.render())
+
+	b.add(2048)
+	assert.Equal(t, "[===============>              ] 1/4 files, 2.0 KB/4.0 KB", b.render())
+
+	b.add(2048)
+	assert.Equal(t, "[==============================] 2/4 files, 4.0 KB/4.0 KB", b.render())
+}
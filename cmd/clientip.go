@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/yourusername/golang-aws-api/ipfilter"
+)
+
+// trustedProxies are the load balancers whose X-Forwarded-For and X-Real-IP
+// headers are believed, from TRUSTED_PROXIES. Without any, the client address
+// is the address of the connection.
+var trustedProxies *ipfilter.Set
+
+// loadTrustedProxies reads TRUSTED_PROXIES, a comma separated list of CIDR
+// ranges or addresses
+func loadTrustedProxies() (*ipfilter.Set, error) {
+	v := os.Getenv("TRUSTED_PROXIES")
+	if strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+	return ipfilter.Parse(strings.Split(v, ","))
+}
+
+type clientIPKey struct{}
+
+// clientIPMiddleware resolves the client address once and stores it in the
+// request context, so the access log, rate limits, IP filters and audit
+// records all see the same address
+func clientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := resolveClientIP(r, trustedProxies)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
+
+// clientIP returns the address a request came from, as resolved by
+// clientIPMiddleware
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return resolveClientIP(r, trustedProxies)
+}
+
+// resolveClientIP returns the address a request came from. Forwarding headers
+// are only believed when the connection is from a trusted proxy: then the
+// client is the nearest address in X-Forwarded-For that isn't a trusted proxy
+// itself, or X-Real-IP if the proxy only sets that.
+func resolveClientIP(r *http.Request, trusted *ipfilter.Set) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !trusted.ContainsString(ip) {
+		return ip
+	}
+	if hops := r.Header.Values("X-Forwarded-For"); len(hops) > 0 {
+		return forwardedFor(ip, hops, trusted)
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String()
+	}
+	return ip
+}
+
+// forwardedFor walks X-Forwarded-For from the right, where each proxy appends
+// the address it received the request from, and stops at the first hop not
+// in trusted. Everything left of that hop was supplied by the client and
+// can't be believed. A malformed hop stops the walk at the last good one.
+func forwardedFor(remote string, headers []string, trusted *ipfilter.Set) string {
+	var hops []string
+	for _, h := range headers {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap().String()
+		if !trusted.Contains(addr) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/ipfilter"
+)
+
+func TestResolveClientIPTrustsOnlyKnownProxies(t *testing.T) {
+	proxies, err := ipfilter.Parse([]string{"10.0.0.0/8"})
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/api/files", nil)
+	r.RemoteAddr = "203.0.113.7:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Real-IP", "198.51.100.2")
+	assert.Equal(t, "203.0.113.7", resolveClientIP(r, proxies), "headers from an untrusted peer are ignored")
+
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Set("X-Forwarded-For", "6.6.6.6, 198.51.100.1, 10.0.0.3")
+	assert.Equal(t, "198.51.100.1", resolveClientIP(r, proxies), "addresses left of the first untrusted hop are spoofable")
+
+	r.Header.Set("X-Forwarded-For", "garbage, 10.0.0.3")
+	assert.Equal(t, "10.0.0.3", resolveClientIP(r, proxies))
+
+	r.Header.Del("X-Forwarded-For")
+	assert.Equal(t, "198.51.100.2", resolveClientIP(r, proxies))
+
+	r.Header.Set("X-Real-IP", "not an address")
+	assert.Equal(t, "10.0.0.2", resolveClientIP(r, proxies))
+
+	assert.Equal(t, "10.0.0.2", resolveClientIP(r, nil), "without trusted proxies the connection address is used")
+}
+
+func TestClientIPMiddlewareStoresAddress(t *testing.T) {
+	proxies, err := ipfilter.Parse([]string{"10.0.0.0/8"})
+	assert.NoError(t, err)
+	defer func(old *ipfilter.Set) { trustedProxies = old }(trustedProxies)
+	trustedProxies = proxies
+
+	var got string
+	handler := clientIPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Later changes to the headers don't change the resolved address
+		r.Header.Set("X-Real-IP", "192.0.2.1")
+		got = clientIP(r)
+	}))
+	r := httptest.NewRequest("GET", "/api/files", nil)
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Set("X-Real-IP", "198.51.100.2")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "198.51.100.2", got)
+}
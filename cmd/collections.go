@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+// collectionNode is a collection in API responses. Collections and Files are
+// only filled in when its contents are requested.
+type collectionNode struct {
+	ID          string           `json:"id"`
+	Name        string           `json:"name"`
+	ParentID    string           `json:"parent_id,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	Collections []collectionNode `json:"collections,omitempty"`
+	Files       []fileSummary    `json:"files,omitempty"`
+}
+
+func newCollectionNode(c *database.Collection) collectionNode {
+	return collectionNode{
+		ID:        c.ID,
+		Name:      c.Name,
+		ParentID:  c.ParentID,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
+
+// validCollectionName reports whether name can be used as a collection name.
+// Names become part of S3 keys, so they can't contain slashes.
+func validCollectionName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/") && len(name) <= 255
+}
+
+// loadCollection loads a collection owned by the caller, writing an error
+// response and returning nil otherwise
+func loadCollection(w http.ResponseWriter, r *http.Request, id string) *database.Collection {
+	c, err := database.GetCollectionByID(id)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving collection")
+		return nil
+	}
+	if c == nil || !auth.PrincipalFromContext(r.Context()).Owns(c.UserID) {
+		apierrors.Respond(w, r, apierrors.CodeCollectionNotFound, "Collection not found")
+		return nil
+	}
+	return c
+}
+
+// createCollectionHandler creates a collection, optionally inside another one
+func createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string `json:"name"`
+		ParentID string `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if !validCollectionName(req.Name) {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "name is required and can't contain '/'")
+		return
+	}
+	if req.ParentID != "" && loadCollection(w, r, req.ParentID) == nil {
+		return
+	}
+
+	userID := auth.UserIDFromContext(r.Context())
+	c, err := database.SaveCollection(userID, req.ParentID, req.Name)
+	if err != nil {
+		log.Printf("Error saving collection: %v", err)
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newCollectionNode(c))
+}
+
+// listCollectionsHandler lists the caller's collections inside parent_id, or
+// the top-level ones when it isn't given
+func listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	parentID := r.URL.Query().Get("parent_id")
+	if parentID != "" && loadCollection(w, r, parentID) == nil {
+		return
+	}
+
+	collections, err := database.ListCollections(userID, parentID)
+	if err != nil {
+		log.Printf("Error listing collections: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing collections")
+		return
+	}
+
+	items := make([]collectionNode, 0, len(collections))
+	for i := range collections {
+		items = append(items, newCollectionNode(&collections[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"collections": items,
+	})
+}
+
+// getCollectionHandler returns a collection with its path, subcollections and
+// files. With recursive=true the whole tree below it is included.
+func getCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	c := loadCollection(w, r, vars["id"])
+	if c == nil {
+		return
+	}
+
+	path, err := database.CollectionPath(c.ID)
+	if err != nil {
+		log.Printf("Error resolving collection path: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving collection")
+		return
+	}
+
+	depth := 1
+	if r.URL.Query().Get("recursive") == "true" {
+		depth = -1
+	}
+	node, err := buildCollectionTree(c, depth)
+	if err != nil {
+		log.Printf("Error listing collection contents: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving collection")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		collectionNode
+		Path string `json:"path"`
+	}{node, path})
+}
+
+// buildCollectionTree returns a collection with its files and subcollections,
+// descending depth levels (or all of them if depth is negative)
+func buildCollectionTree(c *database.Collection, depth int) (collectionNode, error) {
+	node := newCollectionNode(c)
+	if depth == 0 {
+		return node, nil
+	}
+
+	files, err := database.ListFilesInCollections([]string{c.ID})
+	if err != nil {
+		return node, err
+	}
+	for _, f := range files {
+		node.Files = append(node.Files, newFileSummary(f))
+	}
+
+	children, err := database.ListCollections(c.UserID, c.ID)
+	if err != nil {
+		return node, err
+	}
+	for i := range children {
+		child, err := buildCollectionTree(&children[i], depth-1)
+		if err != nil {
+			return node, err
+		}
+		node.Collections = append(node.Collections, child)
+	}
+	return node, nil
+}
+
+// updateCollectionHandler renames a collection and/or moves it. A parent_id of
+// "" moves it to the top level; leaving parent_id out keeps it where it is.
+func updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req struct {
+		Name     string  `json:"name"`
+		ParentID *string `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	c := loadCollection(w, r, vars["id"])
+	if c == nil {
+		return
+	}
+
+	name, parentID := c.Name, c.ParentID
+	if req.Name != "" {
+		name = req.Name
+	}
+	if req.ParentID != nil {
+		parentID = *req.ParentID
+	}
+	if !validCollectionName(name) {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "name can't contain '/'")
+		return
+	}
+	if parentID != "" && parentID != c.ParentID && loadCollection(w, r, parentID) == nil {
+		return
+	}
+
+	updated, err := database.UpdateCollection(c.ID, name, parentID)
+	if err != nil {
+		log.Printf("Error saving collection: %v", err)
+		apierrors.Write(w, r, err)
+		return
+	}
+	if updated == nil {
+		apierrors.Respond(w, r, apierrors.CodeCollectionNotFound, "Collection not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newCollectionNode(updated))
+}
+
+// deleteCollectionHandler deletes a collection and its subcollections. The
+// files in them are kept.
+func deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	c := loadCollection(w, r, vars["id"])
+	if c == nil {
+		return
+	}
+
+	if err := database.DeleteCollection(c.ID); err != nil {
+		log.Printf("Error deleting collection %s: %v", c.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error deleting collection")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addCollectionFilesHandler moves some of the caller's files into a collection
+func addCollectionFilesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req struct {
+		FileIDs []string `json:"file_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.FileIDs) == 0 {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "file_ids is required")
+		return
+	}
+
+	c := loadCollection(w, r, vars["id"])
+	if c == nil {
+		return
+	}
+
+	moved, err := database.SetFilesCollection(req.FileIDs, c.ID, c.UserID)
+	if errors.Is(err, database.ErrDuplicateFileName) {
+		apierrors.Write(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error adding files to collection %s: %v", c.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error adding files to collection")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":    c.ID,
+		"moved": moved,
+	})
+}
+
+// collectionFiles returns every file in a collection and its subcollections,
+// along with each file's path relative to the collection
+func collectionFiles(c *database.Collection) ([]database.File, map[string]string, error) {
+	paths := map[string]string{c.ID: ""}
+	pending := []database.Collection{*c}
+	for len(pending) > 0 {
+		parent := pending[0]
+		pending = pending[1:]
+
+		children, err := database.ListCollections(parent.UserID, parent.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, child := range children {
+			paths[child.ID] = paths[parent.ID] + child.Name + "/"
+			pending = append(pending, child)
+		}
+	}
+
+	ids := make([]string, 0, len(paths))
+	for id := range paths {
+		ids = append(ids, id)
+	}
+	files, err := database.ListFilesInCollections(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filePaths := make(map[string]string, len(files))
+	for _, f := range files {
+		filePaths[f.ID] = paths[f.CollectionID] + f.Name
+	}
+	return files, filePaths, nil
+}
+
+// downloadCollectionHandler streams every file in a collection and its
+// subcollections as a zip archive that mirrors the collection tree
+func downloadCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	c := loadCollection(w, r, vars["id"])
+	if c == nil {
+		return
+	}
+
+	files, paths, err := collectionFiles(c)
+	if err != nil {
+		log.Printf("Error listing collection %s: %v", c.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving collection")
+		return
+	}
+
+	streamZip(w, r, c.Name+".zip", files, paths)
+}
+
+// reprocessCollectionHandler queues every file in a collection and its
+// subcollections for processing again
+func reprocessCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	c := loadCollection(w, r, vars["id"])
+	if c == nil {
+		return
+	}
+
+	files, _, err := collectionFiles(c)
+	if err != nil {
+		log.Printf("Error listing collection %s: %v", c.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving collection")
+		return
+	}
+
+	events := make([]queue.FileEvent, 0, len(files))
+	for _, f := range files {
+		events = append(events, queue.FileEvent{FileID: f.ID, Bucket: bucketName, Key: f.S3Key})
+	}
+	queued := 0
+	for i, err := range queue.PublishFileEvents(r.Context(), events) {
+		if err != nil {
+			log.Printf("Error queuing file %s for reprocessing: %v", events[i].FileID, err)
+			continue
+		}
+		queued++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     c.ID,
+		"files":  len(files),
+		"queued": queued,
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+// concurrencyRetryAfter is the Retry-After sent with 429s from the limiter.
+// Streams finish at unpredictable times, so this is only a hint.
+const concurrencyRetryAfter = "5"
+
+// concurrencyLimiter is a counting semaphore per endpoint and per caller on
+// each endpoint. Limits come from the current settings, so a reload applies
+// to the next request while streams already running finish as they are.
+type concurrencyLimiter struct {
+	mu     sync.Mutex
+	routes map[string]int
+	users  map[string]int
+}
+
+func newConcurrencyLimiter() *concurrencyLimiter {
+	return &concurrencyLimiter{routes: make(map[string]int), users: make(map[string]int)}
+}
+
+// streams limits the endpoints that stream large objects
+var streams = newConcurrencyLimiter()
+
+// acquire takes a slot on route for caller. A zero limit is unlimited.
+func (l *concurrencyLimiter) acquire(route, caller string, routeLimit, userLimit int) bool {
+	key := route + "\x00" + caller
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if routeLimit > 0 && l.routes[route] >= routeLimit {
+		return false
+	}
+	if userLimit > 0 && l.users[key] >= userLimit {
+		return false
+	}
+	l.routes[route]++
+	l.users[key]++
+	return true
+}
+
+// release gives back a slot taken by acquire
+func (l *concurrencyLimiter) release(route, caller string) {
+	key := route + "\x00" + caller
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.routes[route]--; l.routes[route] <= 0 {
+		delete(l.routes, route)
+	}
+	if l.users[key]--; l.users[key] <= 0 {
+		delete(l.users, key)
+	}
+}
+
+// limitStreams wraps a streaming handler so that at most StreamConcurrency
+// of its requests run at once, and StreamConcurrencyPerUser per caller.
+// Callers are told apart by user ID, or by address when anonymous. Requests
+// over either limit get 429 Too Many Requests right away instead of queuing.
+func limitStreams(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := settings.Current()
+		caller := auth.UserIDFromContext(r.Context())
+		if caller == "" {
+			caller = "ip:" + clientIP(r)
+		}
+		if !streams.acquire(route, caller, s.StreamConcurrency, s.StreamConcurrencyPerUser) {
+			w.Header().Set("Retry-After", concurrencyRetryAfter)
+			apierrors.Respond(w, r, apierrors.CodeConcurrencyLimited, "Too many downloads in progress, retry later")
+			return
+		}
+		defer streams.release(route, caller)
+		next(w, r)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := newConcurrencyLimiter()
+	assert.True(t, l.acquire("download", "alice", 3, 2))
+	assert.True(t, l.acquire("download", "alice", 3, 2))
+	assert.False(t, l.acquire("download", "alice", 3, 2), "alice is at her own limit")
+	assert.True(t, l.acquire("download", "bob", 3, 2))
+	assert.False(t, l.acquire("download", "carol", 3, 2), "the endpoint is full")
+	assert.True(t, l.acquire("download-zip", "alice", 3, 2), "endpoints are limited separately")
+
+	l.release("download", "alice")
+	assert.True(t, l.acquire("download", "carol", 3, 2))
+
+	// Zero limits are unlimited
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.acquire("share", "ip:10.0.0.1", 0, 0))
+	}
+}
+
+func TestLimitStreams(t *testing.T) {
+	s := settings.Defaults()
+	s.StreamConcurrencyPerUser = 1
+	assert.NoError(t, settings.Set(s))
+	t.Cleanup(func() { settings.Set(settings.Defaults()) })
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	h := limitStreams("test-stream", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+	})
+
+	go h(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	close(finish)
+}
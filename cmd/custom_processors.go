@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/custom"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// customProcessorResponse is the JSON form of a user's custom processor
+type customProcessorResponse struct {
+	FunctionARN    string    `json:"function_arn"`
+	RoleARN        string    `json:"role_arn"`
+	TimeoutSeconds int       `json:"timeout_seconds"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func newCustomProcessorResponse(c database.CustomProcessor) customProcessorResponse {
+	return customProcessorResponse{
+		FunctionARN:    c.FunctionARN,
+		RoleARN:        c.RoleARN,
+		TimeoutSeconds: c.TimeoutSeconds,
+		CreatedAt:      c.CreatedAt,
+		UpdatedAt:      c.UpdatedAt,
+	}
+}
+
+// setCustomProcessorHandler registers the caller's own Lambda function to be
+// invoked on each of their files after the built-in processing. It is called
+// as the role an operator registered for the caller, and must be in that
+// role's account.
+func setCustomProcessorHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FunctionARN    string `json:"function_arn"`
+		RoleARN        string `json:"role_arn"`
+		TimeoutSeconds int    `json:"timeout_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	userID := auth.UserIDFromContext(r.Context())
+	role := custom.TenantRole(userID)
+	if role == "" {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "No role is registered for your custom processor, ask an administrator to register one")
+		return
+	}
+	// role_arn may be left out; if given it must be the registered role
+	if req.RoleARN != "" && req.RoleARN != role {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "role_arn must be the role registered for you, "+role)
+		return
+	}
+	if _, err := custom.CheckFunction(role, req.FunctionARN); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "function_arn "+err.Error())
+		return
+	}
+	if req.TimeoutSeconds == 0 {
+		req.TimeoutSeconds = int(custom.DefaultTimeout.Seconds())
+	}
+	if req.TimeoutSeconds < 1 || req.TimeoutSeconds > int(custom.MaxTimeout.Seconds()) {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "timeout_seconds must be between 1 and 300")
+		return
+	}
+
+	c, err := database.SaveCustomProcessor(userID, req.FunctionARN, role, req.TimeoutSeconds)
+	if err != nil {
+		log.Printf("Error saving custom processor: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error saving custom processor")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newCustomProcessorResponse(*c))
+}
+
+// getCustomProcessorHandler returns the caller's custom processor
+func getCustomProcessorHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	c, err := database.GetCustomProcessor(userID)
+	if err != nil {
+		log.Printf("Error loading custom processor: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading custom processor")
+		return
+	}
+	if c == nil {
+		apierrors.Respond(w, r, apierrors.CodeCustomProcessorNotFound, "Custom processor not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newCustomProcessorResponse(*c))
+}
+
+// deleteCustomProcessorHandler stops invoking the caller's custom processor
+func deleteCustomProcessorHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	deleted, err := database.DeleteCustomProcessor(userID)
+	if err != nil {
+		log.Printf("Error deleting custom processor: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error deleting custom processor")
+		return
+	}
+	if !deleted {
+		apierrors.Respond(w, r, apierrors.CodeCustomProcessorNotFound, "Custom processor not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
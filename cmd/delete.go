@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/storage"
+)
+
+// retentionInfo is a file's Object Lock retention as shown in responses
+type retentionInfo struct {
+	Mode        string    `json:"mode"`
+	RetainUntil time.Time `json:"retain_until"`
+	Active      bool      `json:"active"`
+}
+
+// newRetentionInfo returns the retention to show for a file, or nil if it
+// was stored without one
+func newRetentionInfo(mode string, retainUntil *time.Time) *retentionInfo {
+	if mode == "" || retainUntil == nil {
+		return nil
+	}
+	return &retentionInfo{Mode: mode, RetainUntil: *retainUntil, Active: retainUntil.After(time.Now())}
+}
+
+// deleteFileHandler deletes a file and its object. Files under retention
+// can't be deleted until it ends, except that administrators may lift
+// governance retention with ?bypass_governance=true. Files under legal hold
+// can't be deleted until it is released.
+func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	f := authorizeFile(w, r, vars["id"])
+	if f == nil {
+		return
+	}
+
+	bypass := r.URL.Query().Get("bypass_governance") == "true"
+	if bypass && !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Only administrators can bypass governance retention")
+		return
+	}
+
+	var bypassMode string
+	if bypass {
+		bypassMode = storage.RetentionGovernance
+	}
+	_, err := removeFile(r.Context(), f, bypassMode)
+	if errors.Is(err, database.ErrRetentionActive) || errors.Is(err, database.ErrLegalHold) {
+		apierrors.Write(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting file %s: %v", f.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error deleting file")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeFile deletes a file with fileRemover, then drops its cached
+// download. It returns the number of objects deleted.
+func removeFile(ctx context.Context, f *database.File, bypassMode string) (int, error) {
+	deleted, err := fileRemover.Remove(ctx, f, bypassMode)
+	if err != nil {
+		return 0, err
+	}
+	downloadCache.Invalidate(f.ID)
+	return deleted, nil
+}
+
+// setLegalHoldHandler places a file under legal hold or releases it. Only
+// administrators can change a hold, on any user's file.
+func setLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	var req struct {
+		LegalHold *bool `json:"legal_hold"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.LegalHold == nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "legal_hold is required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	f, err := database.SetLegalHold(vars["id"], *req.LegalHold, p.Username)
+	if err != nil {
+		log.Printf("Error setting legal hold on file %s: %v", vars["id"], err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error setting legal hold")
+		return
+	}
+	if f == nil {
+		apierrors.Respond(w, r, apierrors.CodeFileNotFound, "File not found")
+		return
+	}
+	log.Printf("Legal hold on file %s set to %t by %s", f.ID, f.LegalHold, p.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         f.ID,
+		"legal_hold": f.LegalHold,
+	})
+}
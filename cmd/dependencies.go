@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+// maxFileDependencies caps how many files an upload may depend on
+const maxFileDependencies = 50
+
+// fileDependencies checks the files an upload by userID declares it depends
+// on and returns them without duplicates. Each must exist and belong to
+// userID; otherwise an error response is written and false returned.
+func fileDependencies(w http.ResponseWriter, r *http.Request, userID string, ids []string) ([]string, bool) {
+	if len(ids) == 0 {
+		return nil, true
+	}
+	if userID == "" || userID == database.AnonymousUserID {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "depends_on needs an account")
+		return nil, false
+	}
+	if len(ids) > maxFileDependencies {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("A file can depend on at most %d files", maxFileDependencies))
+		return nil, false
+	}
+
+	deps := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		f, err := database.GetFileByID(id)
+		if err != nil {
+			log.Printf("Error loading dependency %s: %v", id, err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file")
+			return nil, false
+		}
+		if f == nil || f.UserID != userID {
+			apierrors.Respond(w, r, apierrors.CodeFileNotFound, fmt.Sprintf("Dependency %s not found", id))
+			return nil, false
+		}
+		deps = append(deps, id)
+	}
+	return deps, true
+}
+
+// failBlockedJobs gives files depending on a file that failed a
+// dependency_failed result instead of leaving them waiting forever
+func failBlockedJobs(ctx context.Context) {
+	blocked, err := database.FailBlockedScheduledJobs(schedulerBatchSize)
+	if err != nil {
+		log.Printf("Error failing blocked scheduled jobs: %v", err)
+		return
+	}
+	for _, b := range blocked {
+		log.Printf("Not processing file %s: dependency %s ended with the status %s", b.FileID, b.DependsOn, b.Status)
+		event := queue.ResultEvent{FileID: b.FileID, Status: database.StatusDependencyFailed, JobID: b.JobID, ProcessedAt: time.Now().UTC()}
+		if err := queue.PublishResult(ctx, event); err != nil {
+			log.Printf("Error publishing result event for file %s: %v", b.FileID, err)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Command devstack runs the whole stack locally with one command: it starts
+// Postgres and LocalStack containers, creates the bucket, queue and tables,
+// then builds and runs the API and a polling worker against them. Ctrl-C
+// stops everything and removes the containers.
+//
+//	go run ./cmd/devstack
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+const (
+	bucketName = "my-test-bucket"
+	queueName  = "my-queue"
+)
+
+func main() {
+	port := flag.String("port", "8080", "port for the API")
+	fifo := flag.Bool("fifo", false, "use a FIFO queue")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	env, cleanup, err := startContainers(ctx)
+	if err != nil {
+		log.Fatalf("Failed to start containers: %v", err)
+	}
+	defer cleanup()
+
+	env["PORT"] = *port
+	env["S3_BUCKET_NAME"] = bucketName
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+
+	if err := bootstrap(ctx, *fifo); err != nil {
+		log.Printf("Failed to bootstrap resources: %v", err)
+		return
+	}
+
+	binDir, err := os.MkdirTemp("", "devstack")
+	if err != nil {
+		log.Printf("Failed to create build directory: %v", err)
+		return
+	}
+	defer os.RemoveAll(binDir)
+
+	api := filepath.Join(binDir, "api")
+	worker := filepath.Join(binDir, "worker")
+	for bin, pkg := range map[string]string{api: "./cmd", worker: "./lambda"} {
+		log.Printf("Building %s", pkg)
+		build := exec.CommandContext(ctx, "go", "build", "-o", bin, pkg)
+		build.Stdout, build.Stderr = os.Stdout, os.Stderr
+		if err := build.Run(); err != nil {
+			log.Printf("Failed to build %s: %v", pkg, err)
+			return
+		}
+	}
+
+	var wg sync.WaitGroup
+	run := func(name, bin string, extraEnv ...string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := runProcess(ctx, name, bin, extraEnv); err != nil && ctx.Err() == nil {
+				log.Printf("%s exited: %v", name, err)
+				stop()
+			}
+		}()
+	}
+	run("api", api)
+	run("worker", worker, "WORKER_MODE=poll")
+
+	log.Printf("Stack is up: API on http://localhost:%s, Ctrl-C to stop", *port)
+	wg.Wait()
+}
+
+// startContainers starts Postgres and LocalStack and returns the environment
+// pointing the API and worker at them, plus a function removing them
+func startContainers(ctx context.Context) (map[string]string, func(), error) {
+	var containers []testcontainers.Container
+	cleanup := func() {
+		log.Printf("Removing containers")
+		for _, c := range containers {
+			c.Terminate(context.Background())
+		}
+	}
+
+	log.Printf("Starting Postgres")
+	pg, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "postgres:14",
+			ExposedPorts: []string{"5432/tcp"},
+			Env: map[string]string{
+				"POSTGRES_USER":     "postgres",
+				"POSTGRES_PASSWORD": "postgres",
+				"POSTGRES_DB":       "postgres",
+			},
+			WaitingFor: wait.ForListeningPort("5432/tcp"),
+		},
+		Started: true,
+	})
+	if err != nil {
+		return nil, cleanup, err
+	}
+	containers = append(containers, pg)
+
+	log.Printf("Starting LocalStack")
+	ls, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "localstack/localstack:latest",
+			ExposedPorts: []string{"4566/tcp"},
+			Env: map[string]string{
+				"SERVICES":       "s3,sqs",
+				"DEFAULT_REGION": "us-east-1",
+			},
+			WaitingFor: wait.ForListeningPort("4566/tcp").WithStartupTimeout(2 * time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		return nil, cleanup, err
+	}
+	containers = append(containers, ls)
+
+	pgHost, err := pg.Host(ctx)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	pgPort, err := pg.MappedPort(ctx, "5432")
+	if err != nil {
+		return nil, cleanup, err
+	}
+	lsHost, err := ls.Host(ctx)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	lsPort, err := ls.MappedPort(ctx, "4566")
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	return map[string]string{
+		"ENV":             "local",
+		"LOCALSTACK_HOST": lsHost,
+		"LOCALSTACK_PORT": lsPort.Port(),
+		"DB_HOST":         pgHost,
+		"DB_PORT":         pgPort.Port(),
+		"DB_USER":         "postgres",
+		"DB_PASSWORD":     "postgres",
+		"DB_NAME":         "postgres",
+	}, cleanup, nil
+}
+
+// bootstrap creates the bucket, the queue with its S3 notification and the
+// database schema, exporting SQS_QUEUE_URL for the child processes
+func bootstrap(ctx context.Context, fifo bool) error {
+	cfg, err := awsconfig.Load(ctx)
+	if err != nil {
+		return err
+	}
+	s3Client := s3.NewFromConfig(cfg)
+	sqsClient := sqs.NewFromConfig(cfg)
+
+	// LocalStack accepts connections a little before its services are ready
+	log.Printf("Creating bucket %s", bucketName)
+	for attempt := 0; ; attempt++ {
+		_, err = s3Client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucketName)})
+		if err == nil || attempt == 30 || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		return fmt.Errorf("creating bucket: %v", err)
+	}
+
+	name := queueName
+	input := &sqs.CreateQueueInput{QueueName: aws.String(name)}
+	if fifo {
+		name += ".fifo"
+		input.QueueName = aws.String(name)
+		input.Attributes = map[string]string{"FifoQueue": "true", "ContentBasedDeduplication": "true"}
+	}
+	log.Printf("Creating queue %s", name)
+	q, err := sqsClient.CreateQueue(ctx, input)
+	if err != nil {
+		return fmt.Errorf("creating queue: %v", err)
+	}
+	queueURL := aws.ToString(q.QueueUrl)
+
+	// S3 notifications can't target FIFO queues; the API publishes itself then
+	if !fifo {
+		_, err = s3Client.PutBucketNotificationConfiguration(ctx, &s3.PutBucketNotificationConfigurationInput{
+			Bucket: aws.String(bucketName),
+			NotificationConfiguration: &s3types.NotificationConfiguration{
+				QueueConfigurations: []s3types.QueueConfiguration{{
+					QueueArn: aws.String("arn:aws:sqs:us-east-1:000000000000:" + name),
+					Events:   []s3types.Event{"s3:ObjectCreated:*"},
+					Filter: &s3types.NotificationConfigurationFilter{
+						Key: &s3types.S3KeyFilter{
+							FilterRules: []s3types.FilterRule{{Name: s3types.FilterRuleNamePrefix, Value: aws.String("files/")}},
+						},
+					},
+				}},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("configuring bucket notifications: %v", err)
+		}
+	}
+
+	os.Setenv("SQS_QUEUE_URL", queueURL)
+	log.Printf("Creating tables")
+	if err := database.InitDB(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// runProcess runs a built binary with the current environment, prefixing each
+// line of its output with name
+func runProcess(ctx context.Context, name, bin string, extraEnv []string) error {
+	cmd := exec.CommandContext(ctx, bin)
+	cmd.Env = append(os.Environ(), extraEnv...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = 10 * time.Second
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	prefixLines(out, name)
+	return cmd.Wait()
+}
+
+// prefixLines copies r to stdout, prefixing each line with the process name
+func prefixLines(r io.Reader, name string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fmt.Printf("[%-6s] %s\n", name, scanner.Text())
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/contenttype"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// downloadLinkExpiry is how long presigned file download links stay valid
+const downloadLinkExpiry = 15 * time.Minute
+
+// fileAccessEntry is an access log entry in API responses
+type fileAccessEntry struct {
+	UserID    string    `json:"user_id,omitempty"`
+	Action    string    `json:"action"`
+	Variant   string    `json:"variant,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// recordAccess logs an access to a file's content, with the variant served,
+// and meters it as a download for the file's owner. Failing to log never
+// fails the request itself.
+func recordAccess(r *http.Request, fileID, action, variant string) {
+	userID := auth.UserIDFromContext(r.Context())
+	if err := database.LogFileAccess(fileID, userID, action, variant, clientIP(r), r.UserAgent()); err != nil {
+		log.Printf("Error logging %s access to file %s: %v", action, fileID, err)
+	}
+	if err := database.RecordUsage("", database.MeterDownloads, 1, fileID); err != nil {
+		log.Printf("Error metering %s access to file %s: %v", action, fileID, err)
+	}
+}
+
+// fileContentType returns the type to serve a file's content as. Files
+// stored before types were recorded are served as opaque bytes.
+func fileContentType(f *database.File) string {
+	if f.ContentType == "" {
+		return contenttype.Default
+	}
+	return f.ContentType
+}
+
+// servedFile returns the file as it is served to the caller, and the variant
+// of its content that is, for the access log: for callers who may not see
+// personal data, a file with a redacted copy has that copy's object and size
+// in place of its own. Until the pii stage of its pipeline has run, a file
+// isn't served to them at all: an error response is written and nil
+// returned.
+func servedFile(w http.ResponseWriter, r *http.Request, f *database.File) (*database.File, string) {
+	if auth.PrincipalFromContext(r.Context()).CanReadPII() {
+		return f, database.VariantOriginal
+	}
+	if f.RedactionPending {
+		apierrors.Respond(w, r, apierrors.CodeRedactionPending, "File is waiting for its personal data to be redacted, retry once it is processed")
+		return nil, ""
+	}
+	if !f.Redacted() {
+		return f, database.VariantOriginal
+	}
+	redacted := *f
+	redacted.S3Key, redacted.SizeBytes = f.RedactedKey, f.RedactedSize
+	return &redacted, database.VariantRedacted
+}
+
+// getFileObject fetches a file's object, serving small files from the
+// download cache. Objects are cached as stored, so encrypted files still go
+// through objectContent. The caller must close the returned Body.
+func getFileObject(ctx context.Context, f *database.File) (*s3.GetObjectOutput, error) {
+	if result, ok := downloadCache.Get(f.ID, f.S3Key); ok {
+		return result, nil
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(f.S3Key),
+	}
+	result, err := s3Client.Download(ctx, input, f.SizeBytes)
+	if err != nil || !downloadCache.Fits(result.ContentLength) {
+		return result, err
+	}
+	cached, err := downloadCache.Put(f.ID, f.S3Key, result)
+	if err != nil {
+		// The body may have been partly read, so the object is fetched again
+		log.Printf("Error caching file %s: %v", f.ID, err)
+		return s3Client.Download(ctx, input, f.SizeBytes)
+	}
+	return cached, nil
+}
+
+// downloadFileHandler streams a file's content as an attachment
+func downloadFileHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	f := authorizeFile(w, r, fileID)
+	if f == nil {
+		return
+	}
+	f, variant := servedFile(w, r, f)
+	if f == nil {
+		return
+	}
+
+	result, err := getFileObject(r.Context(), f)
+	if err != nil {
+		log.Printf("Error retrieving from S3: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file content")
+		return
+	}
+	defer result.Body.Close()
+
+	content, length, err := objectContent(r.Context(), f.ID, f.Encryption, result)
+	if err != nil {
+		log.Printf("Error decrypting file %s: %v", f.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file content")
+		return
+	}
+
+	recordAccess(r, f.ID, database.AccessDownload, variant)
+
+	w.Header().Set("Content-Type", fileContentType(f))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", f.Name))
+	if length > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	if _, err := io.Copy(w, content); err != nil {
+		log.Printf("Error streaming file %s: %v", f.ID, err)
+	}
+}
+
+// downloadURLHandler returns a short-lived presigned S3 URL for a file. Files
+// encrypted before upload can't be downloaded this way.
+func downloadURLHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	f := authorizeFile(w, r, fileID)
+	if f == nil {
+		return
+	}
+	f, variant := servedFile(w, r, f)
+	if f == nil {
+		return
+	}
+	// S3 would hand out the ciphertext, so encrypted files are only
+	// downloaded through the API
+	if f.Encryption.Encrypted() {
+		apierrors.Respond(w, r, apierrors.CodeFileEncrypted, "File is encrypted, download it from /api/files/"+f.ID+"/download instead")
+		return
+	}
+
+	presigned, err := s3.NewPresignClient(s3Client.Client).PresignGetObject(r.Context(), &s3.GetObjectInput{
+		Bucket:                     aws.String(bucketName),
+		Key:                        aws.String(f.S3Key),
+		ResponseContentDisposition: aws.String(fmt.Sprintf("attachment; filename=%q", f.Name)),
+		ResponseContentType:        aws.String(fileContentType(f)),
+	}, s3.WithPresignExpires(downloadLinkExpiry))
+	if err != nil {
+		log.Printf("Error presigning download for file %s: %v", f.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating download link")
+		return
+	}
+
+	recordAccess(r, f.ID, database.AccessPresign, variant)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"download_url": presigned.URL,
+		"expires_at":   time.Now().Add(downloadLinkExpiry),
+	})
+}
+
+// accessLogHandler lists who accessed a file's content, newest first. Only the
+// file's owner and administrators can see it.
+func accessLogHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	params, ok := paginationParams(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := database.GetFileByID(fileID)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file")
+		return
+	}
+	p := auth.PrincipalFromContext(r.Context())
+	if f == nil || !(p.IsAdmin() || p.Owns(f.UserID)) {
+		apierrors.Respond(w, r, apierrors.CodeFileNotFound, "File not found")
+		return
+	}
+
+	entries, err := database.ListFileAccess(fileID, params.After, params.Limit)
+	if err != nil {
+		log.Printf("Error listing access log: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving access log")
+		return
+	}
+	entries, next := pagination.Trim(entries, params.Limit, func(a database.FileAccess) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: a.CreatedAt, ID: a.ID}
+	})
+
+	items := make([]fileAccessEntry, 0, len(entries))
+	for _, a := range entries {
+		items = append(items, fileAccessEntry{
+			UserID:    a.UserID,
+			Action:    a.Action,
+			Variant:   a.Variant,
+			IP:        a.IP,
+			UserAgent: a.UserAgent,
+			CreatedAt: a.CreatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":     items,
+		"next_cursor": next,
+	})
+}
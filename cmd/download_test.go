@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func TestServedFile(t *testing.T) {
+	f := &database.File{ID: "f1", S3Key: "files/f1/a.txt", SizeBytes: 40, RedactedKey: "redacted/f1/r1", RedactedSize: 30}
+	anonymous := httptest.NewRequest("GET", "/", nil)
+	served, variant := servedFile(httptest.NewRecorder(), anonymous, f)
+	assert.Equal(t, database.VariantRedacted, variant)
+	assert.Equal(t, "redacted/f1/r1", served.S3Key)
+	assert.Equal(t, int64(30), served.SizeBytes)
+	assert.Equal(t, "files/f1/a.txt", f.S3Key, "the file itself is left alone")
+
+	reader := httptest.NewRequest("GET", "/", nil)
+	reader = reader.WithContext(auth.WithPrincipal(reader.Context(), &auth.Principal{UserID: "u1", Roles: []string{auth.RoleUser, auth.RolePIIReader}}))
+	served, variant = servedFile(httptest.NewRecorder(), reader, f)
+	assert.Same(t, f, served)
+	assert.Equal(t, database.VariantOriginal, variant)
+
+	// A reader's key without pii:read is served the copy too
+	scoped := httptest.NewRequest("GET", "/", nil)
+	scoped = scoped.WithContext(auth.WithPrincipal(scoped.Context(), &auth.Principal{UserID: "u1", Roles: []string{auth.RoleUser, auth.RolePIIReader}, Scopes: []string{auth.ScopeFilesRead}}))
+	served, variant = servedFile(httptest.NewRecorder(), scoped, f)
+	assert.Equal(t, "redacted/f1/r1", served.S3Key)
+	assert.Equal(t, database.VariantRedacted, variant)
+
+	plain := &database.File{ID: "f2", S3Key: "files/f2/b.txt"}
+	served, variant = servedFile(httptest.NewRecorder(), anonymous, plain)
+	assert.Same(t, plain, served)
+	assert.Equal(t, database.VariantOriginal, variant)
+
+	// Until the pii stage has run the file is refused, but to PII readers
+	pending := &database.File{ID: "f3", S3Key: "files/f3/c.txt", RedactionPending: true}
+	w := httptest.NewRecorder()
+	served, _ = servedFile(w, anonymous, pending)
+	assert.Nil(t, served)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), string(apierrors.CodeRedactionPending))
+	served, variant = servedFile(httptest.NewRecorder(), reader, pending)
+	assert.Same(t, pending, served)
+	assert.Equal(t, database.VariantOriginal, variant)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yourusername/golang-aws-api/envelope"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+// encryptionInfo describes a file's client-side encryption in API responses.
+// The wrapped data key itself is never returned.
+type encryptionInfo struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+}
+
+// newEncryptionInfo returns the encryption to show for a file, or nil if its
+// content is stored as uploaded
+func newEncryptionInfo(env envelope.Envelope) *encryptionInfo {
+	if !env.Encrypted() {
+		return nil
+	}
+	return &encryptionInfo{Algorithm: env.Algorithm, KeyID: env.KeyID}
+}
+
+// sealContent encrypts body for fileID when the owner's uploads are encrypted
+// before they reach S3, returning the body to upload and its length
+func sealContent(ctx context.Context, userID, fileID string, body io.ReadSeeker, size int64) (io.ReadSeeker, int64, envelope.Envelope, error) {
+	return envelope.SealBody(ctx, keyService, settings.Current().EncryptionKey(userID), fileID, body, size)
+}
+
+// objectContent returns the content of an object of fileID and its length.
+// stored is the envelope recorded for the file. Plain objects are streamed
+// from S3; encrypted ones are read and decrypted first.
+func objectContent(ctx context.Context, fileID string, stored envelope.Envelope, obj *s3.GetObjectOutput) (io.Reader, int64, error) {
+	env, err := envelope.ObjectEnvelope(stored, obj.Metadata)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !env.Encrypted() {
+		return obj.Body, obj.ContentLength, nil
+	}
+	sealed, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	content, err := envelope.Open(ctx, keyService, env, fileID, sealed)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(content), int64(len(content)), nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+)
+
+// errorCatalogHandler lists every API error code
+func errorCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": apierrors.Catalog(),
+	})
+}
+
+// errorCodeHandler describes a single error code. Problem type URIs point
+// here, so clients can dereference them.
+func errorCodeHandler(w http.ResponseWriter, r *http.Request) {
+	code := apierrors.Code(mux.Vars(r)["code"])
+	for _, p := range apierrors.Catalog() {
+		if p.Code == code {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(p)
+			return
+		}
+	}
+	apierrors.Respond(w, r, apierrors.CodeNotFound, "Unknown error code")
+}
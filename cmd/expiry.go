@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/settings"
+	"github.com/yourusername/golang-aws-api/tenants"
+)
+
+// expiryBatchSize is the maximum number of expired files deleted per policy
+// and tick
+const expiryBatchSize = 500
+
+// anonymousFileLifetime is how long uploads made without credentials are
+// kept, whatever the expiry policy
+const anonymousFileLifetime = 24 * time.Hour
+
+// expireFiles deletes the files that have outlived their owner's expiry
+// policy, along with their results and objects. Anonymous uploads and users
+// with their own policy, in the settings or their tenant overrides, are
+// handled one by one; the default policy covers everyone else.
+func expireFiles(ctx context.Context) {
+	s := settings.Current()
+	now := time.Now()
+
+	files, err := database.ListExpiredFilesByUser(database.AnonymousUserID, now.Add(-anonymousFileLifetime), expiryBatchSize)
+	if err != nil {
+		log.Printf("Error listing expired anonymous uploads: %v", err)
+	} else {
+		deleteExpiredFiles(ctx, files)
+	}
+
+	// Without the overrides, the default policy could delete files their
+	// owners are meant to keep longer
+	overrides, err := database.ListTenantSettings()
+	if err != nil {
+		log.Printf("Error listing tenant settings: %v", err)
+		return
+	}
+	userDays := tenants.ExpiryDays(s, overrides)
+
+	exclude := make([]string, 0, len(userDays)+1)
+	exclude = append(exclude, database.AnonymousUserID)
+	for userID, days := range userDays {
+		exclude = append(exclude, userID)
+		if days == 0 {
+			continue
+		}
+		files, err := database.ListExpiredFilesByUser(userID, now.AddDate(0, 0, -days), expiryBatchSize)
+		if err != nil {
+			log.Printf("Error listing expired files of user %s: %v", userID, err)
+			continue
+		}
+		deleteExpiredFiles(ctx, files)
+	}
+
+	if s.FileExpiryDays == 0 {
+		return
+	}
+	files, err = database.ListExpiredFiles(exclude, now.AddDate(0, 0, -s.FileExpiryDays), expiryBatchSize)
+	if err != nil {
+		log.Printf("Error listing expired files: %v", err)
+		return
+	}
+	deleteExpiredFiles(ctx, files)
+}
+
+// deleteExpiredFiles deletes files and their objects. A file whose retention
+// was extended or that was put on legal hold since it was listed is skipped.
+func deleteExpiredFiles(ctx context.Context, files []database.File) {
+	deleted := 0
+	for _, f := range files {
+		_, err := removeFile(ctx, &f, "")
+		if errors.Is(err, database.ErrRetentionActive) || errors.Is(err, database.ErrLegalHold) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error deleting expired file %s: %v", f.ID, err)
+			continue
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d expired files", deleted)
+	}
+}
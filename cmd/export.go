@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/export"
+)
+
+// exportResultHandler streams a file's latest processing result as CSV, JSON or PDF
+func exportResultHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.FormatJSON
+	}
+	if !export.IsValidFormat(format) {
+		apierrors.Respond(w, r, apierrors.CodeUnsupportedFormat, "Unsupported export format, use csv, json or pdf")
+		return
+	}
+
+	file := authorizeFile(w, r, fileID)
+	if file == nil {
+		return
+	}
+
+	result, err := database.GetProcessingResultByFileID(fileID)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving processing result")
+		return
+	}
+	if result == nil {
+		apierrors.Respond(w, r, apierrors.CodeResultNotFound, "Processing result not available yet")
+		return
+	}
+	if err := loadOffloadedResult(r.Context(), result); err != nil {
+		log.Printf("Error loading result of file %s: %v", fileID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving processing result")
+		return
+	}
+
+	record := export.Record{
+		FileID:      file.ID,
+		FileName:    file.Name,
+		UploadedAt:  file.CreatedAt,
+		Status:      result.Status,
+		Result:      result.Result,
+		ProcessedAt: result.CreatedAt,
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.ID+"-result."+format))
+	if err := export.Write(w, format, []export.Record{record}); err != nil {
+		// Headers are already sent, so all we can do is log
+		log.Printf("Error writing %s export for file %s: %v", format, fileID, err)
+	}
+}
+
+// exportLinkExpiry is how long presigned export download links stay valid
+const exportLinkExpiry = 15 * time.Minute
+
+// createExportHandler starts an asynchronous export of all the caller's files
+// and their results
+func createExportHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Format string `json:"format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.Format == "" {
+		req.Format = export.FormatZip
+	}
+	if req.Format != export.FormatZip && req.Format != export.FormatCSV {
+		apierrors.Respond(w, r, apierrors.CodeUnsupportedFormat, "Unsupported export format, use zip or csv")
+		return
+	}
+
+	userID := auth.UserIDFromContext(r.Context())
+	job, err := database.SaveExport(userID, req.Format)
+	if err != nil {
+		log.Printf("Error saving export job: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating export")
+		return
+	}
+
+	go runExport(job)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":      job.ID,
+		"status":  job.Status,
+		"message": "Export started",
+	})
+}
+
+// getExportHandler reports the status of an export job, with a download link once it completes
+func getExportHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	exportID := vars["id"]
+
+	job, err := database.GetExportByID(exportID)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving export")
+		return
+	}
+	if job == nil || !auth.PrincipalFromContext(r.Context()).Owns(job.UserID) {
+		apierrors.Respond(w, r, apierrors.CodeExportNotFound, "Export not found")
+		return
+	}
+
+	response := map[string]interface{}{
+		"id":         job.ID,
+		"format":     job.Format,
+		"status":     job.Status,
+		"created_at": job.CreatedAt,
+	}
+	if job.CompletedAt.Valid {
+		response["completed_at"] = job.CompletedAt.Time
+	}
+	if job.Error != "" {
+		response["error"] = job.Error
+	}
+
+	if job.Status == database.ExportCompleted {
+		presigned, err := s3.NewPresignClient(s3Client.Client).PresignGetObject(r.Context(), &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(job.S3Key),
+		}, s3.WithPresignExpires(exportLinkExpiry))
+		if err != nil {
+			log.Printf("Error presigning export download: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating download link")
+			return
+		}
+		response["download_url"] = presigned.URL
+		response["download_expires_at"] = time.Now().Add(exportLinkExpiry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// runExport builds the export archive for a job and uploads it to S3
+func runExport(job *database.Export) {
+	ctx := context.Background()
+	log.Printf("Running export %s for user %s", job.ID, job.UserID)
+
+	if err := database.UpdateExportStatus(job.ID, database.ExportRunning); err != nil {
+		log.Printf("Error updating export %s: %v", job.ID, err)
+	}
+
+	s3Key, err := buildExport(ctx, job)
+	if err != nil {
+		log.Printf("Export %s failed: %v", job.ID, err)
+		if err := database.FailExport(job.ID, err.Error()); err != nil {
+			log.Printf("Error marking export %s as failed: %v", job.ID, err)
+		}
+		return
+	}
+
+	if err := database.CompleteExport(job.ID, s3Key); err != nil {
+		log.Printf("Error completing export %s: %v", job.ID, err)
+		return
+	}
+	log.Printf("Export %s completed: %s", job.ID, s3Key)
+}
+
+// buildExport writes the job's export to a temporary file, uploads it and
+// returns the S3 key
+func buildExport(ctx context.Context, job *database.Export) (string, error) {
+	// Spool to disk so large exports don't have to be held in memory
+	tmp, err := os.CreateTemp("", "export-*")
+	if err != nil {
+		return "", fmt.Errorf("error creating temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	ext := job.Format
+	if job.Format == export.FormatAccount {
+		ext = export.FormatZip
+		err = writeAccountArchive(ctx, tmp, job.UserID)
+	} else {
+		err = writeFilesExport(ctx, tmp, job)
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	s3Key := fmt.Sprintf("exports/%s/%s.%s", job.UserID, job.ID, ext)
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(s3Key),
+		Body:        tmp,
+		ContentType: aws.String(export.ContentType(job.Format)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error uploading export to S3: %v", err)
+	}
+	return s3Key, nil
+}
+
+// writeFilesExport writes the user's files and results in the job's format
+func writeFilesExport(ctx context.Context, w io.Writer, job *database.Export) error {
+	files, err := database.GetFilesWithResultsByUser(job.UserID)
+	if err != nil {
+		return fmt.Errorf("error loading files: %v", err)
+	}
+
+	records := make([]export.Record, 0, len(files))
+	for _, f := range files {
+		if f.ResultKey != "" {
+			pr := database.ProcessingResult{ResultKey: f.ResultKey}
+			if err := loadOffloadedResult(ctx, &pr); err != nil {
+				return err
+			}
+			f.Result = pr.Result
+		}
+		records = append(records, export.Record{
+			FileID:      f.ID,
+			FileName:    f.Name,
+			S3Key:       f.S3Key,
+			UploadedAt:  f.CreatedAt,
+			Status:      f.Status,
+			Result:      f.Result,
+			ProcessedAt: f.ProcessedAt.Time,
+		})
+	}
+
+	if err := export.Write(w, job.Format, records); err != nil {
+		return fmt.Errorf("error writing export: %v", err)
+	}
+	return nil
+}
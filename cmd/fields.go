@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+)
+
+// fieldNamePattern matches the names a ?fields= list may contain
+var fieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// requestedFields parses ?fields=id,name,created_at. It returns nil when the
+// parameter isn't set, meaning every field.
+func requestedFields(r *http.Request) ([]string, bool) {
+	v, set := r.URL.Query()["fields"]
+	if !set {
+		return nil, true
+	}
+	var fields []string
+	for _, s := range strings.Split(strings.Join(v, ","), ",") {
+		s = strings.TrimSpace(s)
+		if !fieldNamePattern.MatchString(s) {
+			return nil, false
+		}
+		fields = append(fields, s)
+	}
+	return fields, true
+}
+
+// fieldSelected reports whether a response field will be kept, so handlers
+// can skip work, like fetching content, for fields the client left out
+func fieldSelected(r *http.Request, name string) bool {
+	fields, ok := requestedFields(r)
+	if !ok || fields == nil {
+		return true
+	}
+	for _, f := range fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// withFields trims successful JSON responses of a handler to the fields
+// listed in ?fields=. A response holding a single object is trimmed to those
+// of its top-level fields; a list response keeps its envelope, such as
+// next_cursor, and trims each object in its lists instead. Unknown names are
+// ignored, and the fields are written in the order they were asked for.
+func withFields(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fields, ok := requestedFields(r)
+		if !ok {
+			apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "fields must be a comma-separated list of field names")
+			return
+		}
+		if fields == nil {
+			next(w, r)
+			return
+		}
+
+		rec := &bufferedResponse{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		body := rec.body.Bytes()
+		if rec.status >= 200 && rec.status < 300 && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			if trimmed, err := selectFields(body, fields); err == nil {
+				body = trimmed
+			}
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(rec.status)
+		w.Write(body)
+	}
+}
+
+// selectFields trims a JSON response body as described on withFields
+func selectFields(body []byte, fields []string) ([]byte, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(body, &top); err != nil {
+		return nil, err
+	}
+
+	isList := false
+	for key, raw := range top {
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) != nil || !allObjects(items) {
+			continue
+		}
+		isList = true
+		for i, item := range items {
+			picked, err := pickFields(item, fields)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = picked
+		}
+		trimmed, err := json.Marshal(items)
+		if err != nil {
+			return nil, err
+		}
+		top[key] = trimmed
+	}
+	if !isList {
+		out, err := pickFields(body, fields)
+		if err != nil {
+			return nil, err
+		}
+		return append(out, '\n'), nil
+	}
+
+	out, err := json.Marshal(top)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// allObjects reports whether every item is a JSON object. An empty list
+// passes, so an empty page is still treated as a list.
+func allObjects(items []json.RawMessage) bool {
+	for _, item := range items {
+		if !bytes.HasPrefix(bytes.TrimSpace(item), []byte("{")) {
+			return false
+		}
+	}
+	return true
+}
+
+// pickFields writes the named fields of a JSON object, in the given order
+func pickFields(object []byte, fields []string) ([]byte, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(object, &values); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		v, ok := values[f]
+		if !ok || seen[f] {
+			continue
+		}
+		seen[f] = true
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(f)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// bufferedResponse holds back a response's status and body so they can be
+// rewritten before being sent
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (br *bufferedResponse) WriteHeader(status int) {
+	br.status = status
+}
+
+func (br *bufferedResponse) Write(b []byte) (int, error) {
+	return br.body.Write(b)
+}
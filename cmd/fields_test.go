@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serveWithFields(target string, status int, body interface{}) *httptest.ResponseRecorder {
+	handler := withFields(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", target, nil))
+	return w
+}
+
+func TestWithFieldsTrimsObject(t *testing.T) {
+	file := map[string]interface{}{"id": "1", "name": "a.txt", "content": "hello", "version": 2}
+
+	w := serveWithFields("/api/files/1?fields=name,id,missing", http.StatusOK, file)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"name":"a.txt","id":"1"}`+"\n", w.Body.String())
+
+	// Without ?fields the response is untouched
+	w = serveWithFields("/api/files/1", http.StatusOK, file)
+	assert.JSONEq(t, `{"id":"1","name":"a.txt","content":"hello","version":2}`, w.Body.String())
+}
+
+func TestWithFieldsTrimsListItems(t *testing.T) {
+	list := map[string]interface{}{
+		"files":       []map[string]interface{}{{"id": "1", "name": "a.txt", "size_bytes": 5}, {"id": "2", "name": "b.txt"}},
+		"next_cursor": "abc",
+	}
+	w := serveWithFields("/api/files?fields=id", http.StatusOK, list)
+	assert.JSONEq(t, `{"files":[{"id":"1"},{"id":"2"}],"next_cursor":"abc"}`, w.Body.String())
+
+	empty := map[string]interface{}{"files": []string{}, "next_cursor": ""}
+	w = serveWithFields("/api/files?fields=id", http.StatusOK, empty)
+	assert.JSONEq(t, `{"files":[],"next_cursor":""}`, w.Body.String())
+}
+
+func TestWithFieldsLeavesErrorsAlone(t *testing.T) {
+	problem := map[string]interface{}{"code": "FILE_NOT_FOUND", "detail": "File not found"}
+	w := serveWithFields("/api/files/1?fields=id", http.StatusNotFound, problem)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"code":"FILE_NOT_FOUND","detail":"File not found"}`, w.Body.String())
+
+	w = serveWithFields("/api/files/1?fields=id,,name", http.StatusOK, problem)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestFieldSelected(t *testing.T) {
+	assert.True(t, fieldSelected(httptest.NewRequest("GET", "/api/files/1", nil), "content"))
+	assert.True(t, fieldSelected(httptest.NewRequest("GET", "/api/files/1?fields=id,content", nil), "content"))
+	assert.False(t, fieldSelected(httptest.NewRequest("GET", "/api/files/1?fields=id,name", nil), "content"))
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/contenttype"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/queue"
+	"github.com/yourusername/golang-aws-api/quota"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+// Limits for fetching a remote file
+const (
+	maxFetchBytes     = 100 << 20
+	fetchTimeout      = 2 * time.Minute
+	maxFetchRedirects = 5
+)
+
+// errAddressNotAllowed is returned when a fetch would connect to a private,
+// loopback or otherwise internal address
+var errAddressNotAllowed = errors.New("address not allowed")
+
+// blockedNetworks are ranges that aren't reachable on the public internet
+// beyond what net.IP's own predicates cover
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+	"2001:db8::/32",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}
+
+// publicIP reports whether ip is a public unicast address
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// newFetchClient returns an HTTP client for fetching user-supplied URLs. The
+// address is checked after DNS resolution, at connect time, so a name that
+// resolves to an internal address is refused even after a redirect or a DNS
+// change. No proxy is used since it would hide the real destination.
+func newFetchClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s", errAddressNotAllowed, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: fetchTimeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+			}
+			return validateFetchURL(req.URL)
+		},
+	}
+}
+
+var fetchClient = newFetchClient()
+
+// validateFetchURL checks that u is an absolute http or https URL without
+// credentials
+func validateFetchURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("only http and https URLs can be fetched")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL has no host")
+	}
+	if u.User != nil {
+		return fmt.Errorf("URLs with credentials are not allowed")
+	}
+	return nil
+}
+
+// fetchToFile downloads rawURL into a temporary file, which the caller must
+// remove. Bodies over limit bytes are rejected with QUOTA_EXCEEDED, and
+// failures on the remote side with FETCH_FAILED.
+func fetchToFile(ctx context.Context, rawURL string, limit int64) (*os.File, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, 0, apierrors.New(apierrors.CodeInvalidParameter, "Invalid url")
+	}
+	resp, err := fetchClient.Do(req)
+	if errors.Is(err, errAddressNotAllowed) {
+		return nil, 0, apierrors.New(apierrors.CodeURLNotAllowed, "The URL points to an address that can't be fetched")
+	}
+	if err != nil {
+		return nil, 0, apierrors.New(apierrors.CodeFetchFailed, fmt.Sprintf("Error fetching URL: %v", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, apierrors.New(apierrors.CodeFetchFailed, fmt.Sprintf("Remote server returned %s", resp.Status))
+	}
+	tooLarge := apierrors.New(apierrors.CodeQuotaExceeded, fmt.Sprintf("Remote file exceeds the %d byte limit", limit))
+	if resp.ContentLength > limit {
+		return nil, 0, tooLarge
+	}
+
+	tmp, err := os.CreateTemp("", "fetch-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(tmp, io.LimitReader(resp.Body, limit+1))
+	if err == nil && n > limit {
+		err = tooLarge
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		var apiErr *apierrors.Error
+		if !errors.As(err, &apiErr) {
+			err = apierrors.New(apierrors.CodeFetchFailed, fmt.Sprintf("Error reading remote file: %v", err))
+		}
+		return nil, 0, err
+	}
+	return tmp, n, nil
+}
+
+// fetchFileName picks a file name from the last segment of the URL path
+func fetchFileName(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return u.Hostname()
+	}
+	return name
+}
+
+// uploadFromURLHandler fetches a remote file, stores it in S3 and queues it
+// for processing, so clients don't have to download and re-upload it
+func uploadFromURLHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL          string `json:"url"`
+		Name         string `json:"name"`
+		CollectionID string `json:"collection_id"`
+		OnDuplicate  string `json:"on_duplicate"`
+		StorageClass string `json:"storage_class"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err == nil {
+		err = validateFetchURL(u)
+	}
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("Invalid url: %v", err))
+		return
+	}
+	if req.Name == "" {
+		req.Name = fetchFileName(u)
+	}
+
+	policy := req.OnDuplicate
+	if policy == "" {
+		policy = database.NamePolicy()
+	}
+	if !database.ValidNamePolicy(policy) {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "on_duplicate must be reject, rename or version")
+		return
+	}
+
+	userID := auth.UserIDFromContext(r.Context())
+	class, ok := storageClass(w, r, userID, req.StorageClass)
+	if !ok {
+		return
+	}
+	lock := settings.Current().Lock(userID, time.Now())
+	fileID := database.NewID()
+	keyPrefix, ok := fileKeyPrefix(w, r, fileID, req.CollectionID, userID)
+	if !ok {
+		return
+	}
+	pending, ok := redactionPending(w, r, userID, req.Name)
+	if !ok {
+		return
+	}
+
+	// The per-file quota also caps the fetch, and the rest is checked once
+	// the size is known
+	limit := int64(maxFetchBytes)
+	maxFileBytes, ok := tenantMaxFileBytes(w, r, userID)
+	if !ok {
+		return
+	}
+	if maxFileBytes > 0 && maxFileBytes < limit {
+		limit = maxFileBytes
+	}
+	tmp, size, err := fetchToFile(r.Context(), u.String(), limit)
+	if err != nil {
+		log.Printf("Error fetching %s: %v", u.Redacted(), err)
+		apierrors.Write(w, r, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := quota.Check(userID, req.Name, size); err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	contentType, err := contenttype.DetectReader(req.Name, tmp)
+	if err != nil {
+		log.Printf("Error reading fetched file: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error reading fetched file")
+		return
+	}
+	body, length, encryption, err := sealContent(r.Context(), userID, fileID, tmp, size)
+	if err != nil {
+		log.Printf("Error encrypting fetched file: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error encrypting file")
+		return
+	}
+
+	var f *database.File
+	err = database.WithTx(func(tx *sql.Tx) error {
+		if err := quota.CheckTx(tx, userID, req.Name, size); err != nil {
+			return err
+		}
+		var err error
+		f, err = database.CreateFileTx(tx, database.NewFile{
+			ID:               fileID,
+			Name:             req.Name,
+			UserID:           userID,
+			CollectionID:     req.CollectionID,
+			SizeBytes:        size,
+			StorageClass:     class,
+			RetentionMode:    lock.Mode,
+			RetainUntil:      lock.RetainUntil,
+			Encryption:       encryption,
+			S3Key:            func(name string) string { return keyPrefix + "/" + name },
+			ContentType:      contentType,
+			RedactionPending: pending,
+		}, policy)
+		return err
+	})
+	var apiErr *apierrors.Error
+	if errors.As(err, &apiErr) || errors.Is(err, database.ErrDuplicateFileName) {
+		apierrors.Write(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving to database: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error saving file metadata")
+		return
+	}
+
+	log.Printf("Uploading fetched file to S3: bucket=%s, key=%s, size=%d", bucketName, f.S3Key, size)
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(bucketName),
+		Key:           aws.String(f.S3Key),
+		Body:          body,
+		ContentLength: length,
+		ContentType:   aws.String(contentType),
+		StorageClass:  types.StorageClass(class),
+		Metadata:      encryption.Metadata(),
+	}
+	lock.Apply(input)
+	etag, err := uploadObject(r.Context(), f.ID, input)
+	if err != nil {
+		log.Printf("Error uploading to S3: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error uploading file")
+		return
+	}
+
+	// S3 event notifications can't target FIFO queues, so publish the event ourselves
+	if queue.IsFIFO() {
+		if err := queue.PublishFileEvent(r.Context(), f.ID, bucketName, f.S3Key, etag); err != nil {
+			log.Printf("Error publishing processing event: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error queuing file for processing")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":            f.ID,
+		"name":          f.Name,
+		"size_bytes":    size,
+		"storage_class": class,
+		"content_type":  contentType,
+		"source_url":    u.Redacted(),
+		"status":        "uploaded",
+		"message":       "File fetched successfully and processing started",
+	})
+}
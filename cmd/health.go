@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/storage"
+)
+
+// healthReport is the result of the health checks
+type healthReport struct {
+	Status   string                 `json:"status"`
+	Database string                 `json:"database"`
+	S3       []storage.RegionStatus `json:"s3"`
+}
+
+// checkHealth checks whether the database and each S3 region can be reached.
+// The API is degraded, but still serves reads, while one region is down; it
+// is unavailable without the database or any bucket.
+func checkHealth(ctx context.Context) (healthReport, int) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	report := healthReport{Database: "ok"}
+	if err := database.GetDB().PingContext(ctx); err != nil {
+		report.Database = err.Error()
+	}
+	report.S3 = s3Client.Health(ctx)
+
+	var code int
+	report.Status, code = healthStatus(report.Database == "ok", report.S3)
+	return report, code
+}
+
+// healthHandler is the public health check for load balancers and uptime
+// monitors. It reports only the overall status; the reasons, which name
+// buckets, regions and database errors, are logged and shown to
+// administrators by adminHealthHandler.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	report, code := checkHealth(r.Context())
+	if report.Status != "ok" {
+		log.Printf("Health check %s: database=%s, s3=%+v", report.Status, report.Database, report.S3)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"status": report.Status})
+}
+
+// adminHealthHandler reports the result of every health check
+func adminHealthHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	report, code := checkHealth(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
+
+// healthStatus summarizes the checks as ok, degraded or unavailable
+func healthStatus(dbOK bool, regions []storage.RegionStatus) (string, int) {
+	up := 0
+	for _, region := range regions {
+		if region.OK {
+			up++
+		}
+	}
+	switch {
+	case !dbOK || up == 0:
+		return "unavailable", http.StatusServiceUnavailable
+	case up < len(regions):
+		return "degraded", http.StatusOK
+	default:
+		return "ok", http.StatusOK
+	}
+}
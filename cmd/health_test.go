@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/storage"
+)
+
+func TestHealthStatus(t *testing.T) {
+	primary := storage.RegionStatus{Role: "primary", OK: true}
+	replica := storage.RegionStatus{Role: "replica", OK: true}
+	down := storage.RegionStatus{Role: "replica", OK: false}
+
+	status, code := healthStatus(true, []storage.RegionStatus{primary, replica})
+	assert.Equal(t, "ok", status)
+	assert.Equal(t, http.StatusOK, code)
+
+	status, code = healthStatus(true, []storage.RegionStatus{primary, down})
+	assert.Equal(t, "degraded", status)
+	assert.Equal(t, http.StatusOK, code)
+
+	status, code = healthStatus(true, []storage.RegionStatus{{OK: false}, down})
+	assert.Equal(t, "unavailable", status)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	status, code = healthStatus(false, []storage.RegionStatus{primary})
+	assert.Equal(t, "unavailable", status)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
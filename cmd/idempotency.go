@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// idempotencyTTL is how long responses are kept for replay
+const idempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLength caps the length of Idempotency-Key values
+const maxIdempotencyKeyLength = 255
+
+// withIdempotency makes a handler safe to retry. When a request carries an
+// Idempotency-Key header, its response is stored and replayed for later
+// requests with the same key, so the handler runs at most once per key.
+// Keys are scoped to the signed in user. Anonymous requests are never
+// replayed: they would share one scope, so anyone reusing a key would get
+// back another client's response.
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		scope := auth.UserIDFromContext(r.Context())
+		if key == "" || scope == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "Idempotency-Key must be at most "+strconv.Itoa(maxIdempotencyKeyLength)+" characters")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := requestHash(r, body)
+
+		existing, err := database.ClaimIdempotencyKey(scope, key, hash, idempotencyTTL)
+		if err != nil {
+			log.Printf("Error claiming idempotency key: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error checking Idempotency-Key")
+			return
+		}
+		if existing != nil {
+			replayResponse(w, r, existing, hash)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		// Server errors are worth retrying, so they aren't stored
+		if rec.status >= http.StatusInternalServerError {
+			err = database.ReleaseIdempotencyKey(scope, key)
+		} else {
+			err = database.CompleteIdempotencyKey(scope, key, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("Error storing idempotent response: %v", err)
+		}
+	}
+}
+
+// replayResponse writes the stored response for a repeated Idempotency-Key
+func replayResponse(w http.ResponseWriter, r *http.Request, rec *database.IdempotencyRecord, hash string) {
+	if rec.RequestHash != hash {
+		apierrors.Respond(w, r, apierrors.CodeIdempotencyKeyReused, "Idempotency-Key was already used for a different request")
+		return
+	}
+	if rec.StatusCode == 0 {
+		apierrors.Respond(w, r, apierrors.CodeIdempotencyInProgress, "A request with this Idempotency-Key is still in progress")
+		return
+	}
+
+	if rec.ContentType != "" {
+		w.Header().Set("Content-Type", rec.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(rec.StatusCode)
+	w.Write(rec.ResponseBody)
+}
+
+// requestHash identifies a request's method, path and body, so a key reused
+// for a different request can be detected
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy of its
+// status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
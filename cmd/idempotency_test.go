@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func TestReplayResponse(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/files", nil)
+	stored := &database.IdempotencyRecord{
+		RequestHash:  "abc",
+		StatusCode:   http.StatusCreated,
+		ContentType:  "application/json",
+		ResponseBody: []byte(`{"id":"1"}`),
+	}
+
+	w := httptest.NewRecorder()
+	replayResponse(w, r, stored, "abc")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, `{"id":"1"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	replayResponse(w, r, stored, "different")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	stored.StatusCode = 0
+	w = httptest.NewRecorder()
+	replayResponse(w, r, stored, "abc")
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestRequestHash(t *testing.T) {
+	a := httptest.NewRequest("POST", "/api/files", nil)
+	b := httptest.NewRequest("POST", "/api/files/import", nil)
+	assert.Equal(t, requestHash(a, []byte("x")), requestHash(a, []byte("x")))
+	assert.NotEqual(t, requestHash(a, []byte("x")), requestHash(a, []byte("y")))
+	assert.NotEqual(t, requestHash(a, []byte("x")), requestHash(b, []byte("x")))
+}
+
+func TestWithIdempotencyPassesThroughWithoutKey(t *testing.T) {
+	called := false
+	h := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("POST", "/api/files", strings.NewReader("{}")))
+	assert.True(t, called)
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestWithIdempotencyIgnoresKeyOfAnonymousRequests(t *testing.T) {
+	calls := 0
+	h := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	// Without a user there is no scope to keep the key in, so the handler
+	// runs every time instead of replaying someone else's response
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("POST", "/api/files", strings.NewReader("{}"))
+		r.Header.Set("Idempotency-Key", "upload-1")
+		w := httptest.NewRecorder()
+		h(w, r)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	}
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/contenttype"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/queue"
+	"github.com/yourusername/golang-aws-api/storage"
+	"github.com/yourusername/golang-aws-api/tenants"
+)
+
+// maxImportObjects caps how many objects a single prefix import registers
+const maxImportObjects = 1000
+
+// importedFile describes a file registered by an import
+type importedFile struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	S3Key       string `json:"s3_key"`
+	SizeBytes   int64  `json:"size_bytes"`
+	ContentType string `json:"content_type"`
+	// ETag of the object when it was registered, which tells processing
+	// messages for rewrites of the same key apart
+	ETag string `json:"-"`
+}
+
+// importFilesHandler registers existing S3 objects as files, either a single
+// key or every object under a prefix, optionally enqueuing them for processing.
+// Only administrators may import, as the objects may hold anyone's data, and
+// never from the prefixes where the API keeps users' own objects.
+func importFilesHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	var req struct {
+		Key     string `json:"key"`
+		Prefix  string `json:"prefix"`
+		Process bool   `json:"process"`
+		// Owner of the imported files, by default the caller
+		UserID string `json:"user_id"`
+		// Name policy for objects whose name the caller already uses; with
+		// reject they are skipped
+		OnDuplicate string `json:"on_duplicate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if (req.Key == "") == (req.Prefix == "") {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "Exactly one of key or prefix is required")
+		return
+	}
+	if (req.Key != "" && storage.ReservedKey(req.Key)) || (req.Prefix != "" && storage.OverlapsReserved(req.Prefix)) {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "Objects under files/, exports/, results/ and redacted/ can't be imported")
+		return
+	}
+	policy := req.OnDuplicate
+	if policy == "" {
+		policy = database.NamePolicy()
+	}
+	if !database.ValidNamePolicy(policy) {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "on_duplicate must be reject, rename or version")
+		return
+	}
+
+	userID := p.UserID
+	if req.UserID != "" {
+		owner, err := database.GetUserByID(req.UserID)
+		if err != nil {
+			log.Printf("Error looking up user %s: %v", req.UserID, err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error importing objects")
+			return
+		}
+		if owner == nil {
+			apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "user_id names no user")
+			return
+		}
+		userID = owner.ID
+	}
+
+	var imported []importedFile
+	var skipped int
+	var err error
+	if req.Key != "" {
+		var f *importedFile
+		f, err = importObject(r.Context(), userID, req.Key, policy)
+		if f != nil {
+			imported = append(imported, *f)
+		} else if err == nil {
+			skipped++
+		}
+	} else {
+		imported, skipped, err = importPrefix(r.Context(), userID, req.Prefix, policy)
+	}
+	if errors.Is(err, errObjectNotFound) {
+		apierrors.Respond(w, r, apierrors.CodeObjectNotFound, "Object not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error importing objects: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error importing objects")
+		return
+	}
+
+	// Optionally send the imported files for processing
+	enqueued := 0
+	if req.Process {
+		events := make([]queue.FileEvent, 0, len(imported))
+		for _, f := range imported {
+			events = append(events, queue.FileEvent{FileID: f.ID, Bucket: bucketName, Key: f.S3Key, ETag: f.ETag})
+		}
+		for i, err := range queue.PublishFileEvents(r.Context(), events) {
+			if err != nil {
+				log.Printf("Error enqueuing imported file %s: %v", events[i].FileID, err)
+				continue
+			}
+			enqueued++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported": imported,
+		"skipped":  skipped,
+		"enqueued": enqueued,
+	})
+}
+
+var errObjectNotFound = errors.New("object not found")
+
+// importObject registers a single S3 object, returning nil if it is already
+// registered or its name is rejected by the name policy
+func importObject(ctx context.Context, userID, key, policy string) (*importedFile, error) {
+	existing, err := database.GetFileByS3Key(key)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, nil
+	}
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		log.Printf("Error reading object %s: %v", key, err)
+		return nil, errObjectNotFound
+	}
+
+	return registerObject(ctx, userID, key, head.ContentLength, string(head.StorageClass), aws.ToString(head.ETag), policy)
+}
+
+// importPrefix registers every not yet registered object under a prefix
+func importPrefix(ctx context.Context, userID, prefix, policy string) ([]importedFile, int, error) {
+	var imported []importedFile
+	skipped := 0
+
+	paginator := s3.NewListObjectsV2Paginator(s3Client.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() && len(imported) < maxImportObjects {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return imported, skipped, fmt.Errorf("error listing objects: %v", err)
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			// Skip folder placeholders
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+
+			existing, err := database.GetFileByS3Key(key)
+			if err != nil {
+				return imported, skipped, err
+			}
+			if existing != nil {
+				skipped++
+				continue
+			}
+
+			f, err := registerObject(ctx, userID, key, obj.Size, string(obj.StorageClass), aws.ToString(obj.ETag), policy)
+			if err != nil {
+				return imported, skipped, err
+			}
+			if f == nil {
+				skipped++
+				continue
+			}
+			imported = append(imported, *f)
+			if len(imported) >= maxImportObjects {
+				break
+			}
+		}
+	}
+	return imported, skipped, nil
+}
+
+// registerObject creates the files row for an existing S3 object, returning
+// nil if the name policy rejects its name. The object keeps the storage class
+// it was written with, and its content type is sniffed from its first bytes
+// whatever type it was written with.
+func registerObject(ctx context.Context, userID, key string, size int64, class, etag, policy string) (*importedFile, error) {
+	name := path.Base(key)
+	contentType, err := contenttype.DetectObject(ctx, s3Client, bucketName, key, name, size)
+	if err != nil {
+		log.Printf("Error sniffing content type of %s, going by its name: %v", key, err)
+		contentType = contenttype.Detect(name, nil)
+	}
+	pending, err := tenants.RedactsPII(userID, name)
+	if err != nil {
+		return nil, fmt.Errorf("error loading pipeline: %v", err)
+	}
+	f, err := database.CreateFile(database.NewFile{
+		ID:               database.NewID(),
+		Name:             name,
+		UserID:           userID,
+		SizeBytes:        size,
+		StorageClass:     class,
+		ContentType:      contentType,
+		RedactionPending: pending,
+		// Imported objects stay where they are, whatever name they get
+		S3Key: func(string) string { return key },
+	}, policy)
+	if errors.Is(err, database.ErrDuplicateFileName) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error saving file metadata: %v", err)
+	}
+	log.Printf("Imported S3 object: id=%s, s3_key=%s, size=%d", f.ID, f.S3Key, f.SizeBytes)
+	return &importedFile{ID: f.ID, Name: f.Name, S3Key: f.S3Key, SizeBytes: f.SizeBytes, ContentType: f.ContentType, ETag: etag}, nil
+}
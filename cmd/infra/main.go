@@ -0,0 +1,542 @@
+// Command infra prints Terraform definitions for the AWS resources the API and
+// the processing Lambda expect, derived from the same environment variables
+// they read at runtime.
+//
+//	S3_BUCKET_NAME=uploads SQS_FIFO=true go run ./cmd/infra > infra.tf
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/custom"
+	"github.com/yourusername/golang-aws-api/processor"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+// lambdaTimeoutMargin is added to the processor timeout for the Lambda's own
+// work around processing: fetching the object and saving the result
+const lambdaTimeoutMargin = 30 * time.Second
+
+// passthroughEnv are the settings copied from the current environment into
+// the Lambda's environment, if they are set
+var passthroughEnv = []string{
+	"PROCESSING_MAX_ATTEMPTS",
+	"PROCESSING_RETRY_BASE_DELAY",
+	"PROCESSING_RETRY_MAX_DELAY",
+	"SQS_VISIBILITY_EXTENSION",
+	"SQS_SIGNING_KEY",
+	"SQS_SIGNING_PREVIOUS_KEY",
+	"SQS_SIGNING_PREVIOUS_KEY_UNTIL",
+	"SQS_ACCEPT_S3_NOTIFICATIONS",
+	"CUSTOM_PROCESSOR_ROLES",
+	"WASM_MEMORY_LIMIT_MB",
+	"WASM_REFRESH_INTERVAL",
+	"PROCESSOR_TIMEOUT",
+	"PROCESSOR_TIMEOUT_TEXT",
+	"MEDIA_FFPROBE_PATH",
+	"ARCHIVE_MAX_MEMBERS",
+	"ARCHIVE_MAX_EXPANDED_MB",
+	"ARCHIVE_EXPAND_CONCURRENCY",
+	"RESULT_GATES",
+	"RESULT_SCHEMA",
+	"PII_GATE_MIN_SCORE",
+	"PII_GATE_ENTITY_TYPES",
+	"PII_GATE_LANGUAGE",
+	"PII_PROCESSOR_COMPREHEND",
+	"PII_PROCESSOR_MIN_SCORE",
+	"PII_PROCESSOR_LANGUAGE",
+	"SEARCH_BACKEND",
+	"OPENSEARCH_URL",
+	"OPENSEARCH_INDEX",
+	"ID_STRATEGY",
+	"DB_HOST",
+	"DB_PORT",
+	"DB_USER",
+	"DB_NAME",
+}
+
+// stack is the configuration the Terraform template is rendered from
+type stack struct {
+	Name         string
+	Region       string
+	Bucket       string
+	Queue        string
+	FIFO         bool
+	ContentDedup bool
+	// MaxReceiveCount moves a message to the DLQ once the Lambda's own retry
+	// policy has had every attempt, plus one for a final failure being recorded
+	MaxReceiveCount   int
+	LambdaTimeout     int
+	VisibilityTimeout int
+	LambdaZip         string
+	UserPool          string
+	KeyPrefix         string
+	// ObjectLock creates the bucket with Object Lock, which uploads need
+	// when a retention mode is configured
+	ObjectLock bool
+	// EncryptionKey is the KMS key uploads are encrypted under before they
+	// reach S3; the API and the Lambda may use it to wrap and unwrap data keys
+	EncryptionKey string
+	// CustomProcessorRoles are the roles the Lambda may assume to invoke
+	// tenants' own functions
+	CustomProcessorRoles []string
+	// Comprehend lets the Lambda ask Comprehend for personal data, when the
+	// pii result gate or the pii processor is configured to
+	Comprehend bool
+	// MaxConcurrency caps how many Lambda instances process the queue at
+	// once, so an expanded archive's members don't take every instance; 0
+	// leaves it to Lambda
+	MaxConcurrency int
+	// Env is the Lambda's environment, as HCL expressions sorted by name
+	Env []envVar
+}
+
+// envVar is a Lambda environment variable set to an HCL expression
+type envVar struct {
+	Name string
+	Expr string
+}
+
+func main() {
+	name := flag.String("name", "file-processor", "prefix for resource names")
+	region := flag.String("region", getEnv("AWS_REGION", "us-east-1"), "AWS region")
+	lambdaZip := flag.String("lambda-zip", "lambda.zip", "path of the built Lambda package")
+	userPool := flag.String("user-pool", "", "Cognito user pool name (default <name>-users)")
+	output := flag.String("o", "-", `output file, or "-" for stdout`)
+	flag.Parse()
+
+	s := loadStack(*name, *region, *lambdaZip, *userPool)
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := render(w, s); err != nil {
+		log.Fatalf("Failed to render Terraform: %v", err)
+	}
+}
+
+// loadStack reads the configuration from the environment
+func loadStack(name, region, lambdaZip, userPool string) stack {
+	queueName := "my-queue"
+	if u, err := url.Parse(getEnv("SQS_QUEUE_URL", "")); err == nil && path.Base(u.Path) != "." && path.Base(u.Path) != "/" {
+		queueName = path.Base(u.Path)
+	}
+	fifo := strings.HasSuffix(queueName, ".fifo")
+	if v := os.Getenv("SQS_FIFO"); v != "" {
+		fifo = v == "true"
+	}
+	// SQS requires FIFO queue names to end in .fifo
+	queueName = strings.TrimSuffix(queueName, ".fifo")
+
+	if userPool == "" {
+		userPool = name + "-users"
+	}
+
+	lambdaTimeout := processor.Timeout("text") + lambdaTimeoutMargin
+	roles := custom.AllowedRoles()
+	if len(roles) > 0 {
+		// A tenant's function runs after the built-in processing
+		lambdaTimeout += custom.MaxTimeout
+	}
+	if lambdaTimeout > 15*time.Minute {
+		lambdaTimeout = 15 * time.Minute
+	}
+
+	bucket := getEnv("S3_BUCKET_NAME", "my-test-bucket")
+	env := []envVar{
+		{"S3_BUCKET_NAME", "aws_s3_bucket.files.bucket"},
+		{"SQS_QUEUE_URL", "aws_sqs_queue.processing.url"},
+		{"SQS_FIFO", fmt.Sprintf("%q", fmt.Sprint(fifo))},
+		{"DB_PASSWORD", "var.db_password"},
+	}
+	for _, key := range passthroughEnv {
+		if v := os.Getenv(key); v != "" {
+			env = append(env, envVar{key, fmt.Sprintf("%q", v)})
+		}
+	}
+	sort.Slice(env, func(i, j int) bool { return env[i].Name < env[j].Name })
+
+	return stack{
+		Name:                 name,
+		Region:               region,
+		Bucket:               bucket,
+		Queue:                queueName,
+		FIFO:                 fifo,
+		ContentDedup:         os.Getenv("SQS_CONTENT_BASED_DEDUP") == "true",
+		ObjectLock:           os.Getenv("RETENTION_MODE") != "",
+		EncryptionKey:        os.Getenv("ENCRYPTION_KMS_KEY_ID"),
+		CustomProcessorRoles: roles,
+		Comprehend:           hasResultGate(os.Getenv("RESULT_GATES"), "pii") || os.Getenv("PII_PROCESSOR_COMPREHEND") == "true",
+		MaxReceiveCount:      queue.LoadRetryPolicy().MaxAttempts + 1,
+		LambdaTimeout:        int(lambdaTimeout / time.Second),
+		// AWS recommends six times the function timeout for SQS event sources
+		VisibilityTimeout: int(6 * lambdaTimeout / time.Second),
+		LambdaZip:         lambdaZip,
+		UserPool:          userPool,
+		Env:               env,
+		KeyPrefix:         "files/",
+		MaxConcurrency:    maxConcurrency(),
+	}
+}
+
+// maxConcurrency reads PROCESSING_MAX_CONCURRENCY, which SQS event sources
+// accept between 2 and 1000
+func maxConcurrency() int {
+	v := os.Getenv("PROCESSING_MAX_CONCURRENCY")
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 2 || n > 1000 {
+		log.Fatalf("PROCESSING_MAX_CONCURRENCY must be between 2 and 1000, got %q", v)
+	}
+	return n
+}
+
+// hasResultGate reports whether the comma-separated RESULT_GATES list gates
+// names the gate name
+func hasResultGate(gates, name string) bool {
+	for _, g := range strings.Split(gates, ",") {
+		if strings.TrimSpace(g) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// render writes the Terraform definitions for s
+func render(w io.Writer, s stack) error {
+	return tfTemplate.Execute(w, s)
+}
+
+var tfTemplate = template.Must(template.New("terraform").Funcs(template.FuncMap{
+	"quote": func(s string) string { return fmt.Sprintf("%q", s) },
+	// suffix is the queue name suffix SQS requires for FIFO queues
+	"suffix": func(fifo bool) string {
+		if fifo {
+			return ".fifo"
+		}
+		return ""
+	},
+	// pad left-aligns names to the longest one, as terraform fmt does
+	"pad": func(vars []envVar, name string) string {
+		width := 0
+		for _, v := range vars {
+			if len(v.Name) > width {
+				width = len(v.Name)
+			}
+		}
+		return fmt.Sprintf("%-*s", width, name)
+	},
+}).Parse(`# Generated by cmd/infra. Re-run it after changing the configuration
+# instead of editing this file.
+
+terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 5.0"
+    }
+  }
+}
+
+provider "aws" {
+  region = {{quote .Region}}
+}
+
+variable "db_password" {
+  type      = string
+  sensitive = true
+}
+
+resource "aws_s3_bucket" "files" {
+  bucket = {{quote .Bucket}}
+{{- if .ObjectLock}}
+
+  # Uploads set their own retention; Object Lock also turns on versioning
+  object_lock_enabled = true
+{{- end}}
+}
+
+resource "aws_sqs_queue" "dlq" {
+  name                      = {{quote (printf "%s-dlq%s" .Queue (suffix .FIFO))}}
+{{- if .FIFO}}
+  fifo_queue                = true
+{{- end}}
+  message_retention_seconds = 1209600
+}
+
+resource "aws_sqs_queue" "processing" {
+  name                       = {{quote (printf "%s%s" .Queue (suffix .FIFO))}}
+  visibility_timeout_seconds = {{.VisibilityTimeout}}
+  receive_wait_time_seconds  = 20
+{{- if .FIFO}}
+
+  fifo_queue                  = true
+  content_based_deduplication = {{.ContentDedup}}
+{{- end}}
+
+  redrive_policy = jsonencode({
+    deadLetterTargetArn = aws_sqs_queue.dlq.arn
+    maxReceiveCount     = {{.MaxReceiveCount}}
+  })
+}
+{{if not .FIFO}}
+# S3 notifications can't target FIFO queues; with SQS_FIFO=true the API
+# publishes upload events itself instead
+resource "aws_sqs_queue_policy" "s3_notifications" {
+  queue_url = aws_sqs_queue.processing.id
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [{
+      Effect    = "Allow"
+      Principal = { Service = "s3.amazonaws.com" }
+      Action    = "sqs:SendMessage"
+      Resource  = aws_sqs_queue.processing.arn
+      Condition = { ArnEquals = { "aws:SourceArn" = aws_s3_bucket.files.arn } }
+    }]
+  })
+}
+
+resource "aws_s3_bucket_notification" "files" {
+  bucket = aws_s3_bucket.files.id
+
+  queue {
+    queue_arn     = aws_sqs_queue.processing.arn
+    events        = ["s3:ObjectCreated:*"]
+    filter_prefix = {{quote .KeyPrefix}}
+  }
+
+  depends_on = [aws_sqs_queue_policy.s3_notifications]
+}
+{{end}}
+resource "aws_iam_role" "lambda" {
+  name = {{quote (printf "%s-lambda" .Name)}}
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [{
+      Effect    = "Allow"
+      Principal = { Service = "lambda.amazonaws.com" }
+      Action    = "sts:AssumeRole"
+    }]
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "lambda_logs" {
+  role       = aws_iam_role.lambda.name
+  policy_arn = "arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"
+}
+
+{{- if .EncryptionKey}}
+# Key that wraps the per-file data keys of uploads encrypted by the API
+data "aws_kms_key" "uploads" {
+  key_id = {{quote .EncryptionKey}}
+}
+
+{{end -}}
+resource "aws_iam_role_policy" "lambda" {
+  name = {{quote (printf "%s-lambda" .Name)}}
+  role = aws_iam_role.lambda.id
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = ["s3:GetObject", "s3:HeadObject"]
+        Resource = "${aws_s3_bucket.files.arn}/*"
+      },
+      {
+        # Results too large for the database are stored under results/
+        Effect   = "Allow"
+        Action   = ["s3:PutObject", "s3:DeleteObject"]
+        Resource = "${aws_s3_bucket.files.arn}/results/*"
+      },
+      {
+        # Copies of files with their personal data redacted
+        Effect   = "Allow"
+        Action   = ["s3:PutObject", "s3:DeleteObject"]
+        Resource = "${aws_s3_bucket.files.arn}/redacted/*"
+      },
+{{- if .EncryptionKey}}
+      {
+        # Data keys are generated for redacted copies of encrypted files
+        Effect   = "Allow"
+        Action   = ["kms:GenerateDataKey", "kms:Decrypt"]
+        Resource = data.aws_kms_key.uploads.arn
+      },
+{{- end}}
+{{- if .CustomProcessorRoles}}
+      {
+        Effect   = "Allow"
+        Action   = "sts:AssumeRole"
+        Resource = [{{range $i, $r := .CustomProcessorRoles}}{{if $i}}, {{end}}{{quote $r}}{{end}}]
+      },
+{{- end}}
+{{- if .Comprehend}}
+      {
+        Effect   = "Allow"
+        Action   = "comprehend:DetectPiiEntities"
+        Resource = "*"
+      },
+{{- end}}
+      {
+        Effect = "Allow"
+        Action = [
+          "sqs:ReceiveMessage",
+          "sqs:DeleteMessage",
+          "sqs:GetQueueAttributes",
+          "sqs:ChangeMessageVisibility",
+        ]
+        Resource = aws_sqs_queue.processing.arn
+      },
+    ]
+  })
+}
+
+resource "aws_lambda_function" "processor" {
+  function_name    = {{quote .Name}}
+  role             = aws_iam_role.lambda.arn
+  filename         = {{quote .LambdaZip}}
+  source_code_hash = filebase64sha256({{quote .LambdaZip}})
+  # The Lambda binary must be packaged as "bootstrap" for this runtime
+  handler          = "bootstrap"
+  runtime          = "provided.al2023"
+  timeout          = {{.LambdaTimeout}}
+
+  environment {
+    variables = {
+{{- range .Env}}
+      {{pad $.Env .Name}} = {{.Expr}}
+{{- end}}
+    }
+  }
+}
+
+resource "aws_lambda_event_source_mapping" "processing" {
+  event_source_arn        = aws_sqs_queue.processing.arn
+  function_name           = aws_lambda_function.processor.arn
+  batch_size              = 10
+  function_response_types = ["ReportBatchItemFailures"]
+{{- if .MaxConcurrency}}
+
+  scaling_config {
+    maximum_concurrency = {{.MaxConcurrency}}
+  }
+{{- end}}
+}
+
+# Policy for the API's own role: it uploads objects, signs download URLs,
+# publishes scheduled and FIFO processing events, pauses the worker for
+# maintenance and runs analytics queries
+resource "aws_iam_policy" "api" {
+  name = {{quote (printf "%s-api" .Name)}}
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = ["s3:PutObject", "s3:GetObject", "s3:HeadObject", "s3:DeleteObject"{{if .ObjectLock}}, "s3:PutObjectRetention", "s3:BypassGovernanceRetention"{{end}}]
+        Resource = "${aws_s3_bucket.files.arn}/*"
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["s3:ListBucket", "s3:GetBucketLocation"]
+        Resource = aws_s3_bucket.files.arn
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["sqs:SendMessage", "sqs:GetQueueAttributes"]
+        Resource = aws_sqs_queue.processing.arn
+      },
+      {
+        Effect   = "Allow"
+        Action   = "lambda:UpdateEventSourceMapping"
+        Resource = aws_lambda_event_source_mapping.processing.arn
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["athena:StartQueryExecution", "athena:GetQueryExecution", "athena:GetQueryResults", "glue:GetDatabase", "glue:GetTable", "glue:GetPartitions"]
+        Resource = "*"
+      },
+{{- if .EncryptionKey}}
+      {
+        Effect   = "Allow"
+        Action   = ["kms:GenerateDataKey", "kms:Decrypt"]
+        Resource = data.aws_kms_key.uploads.arn
+      },
+{{- end}}
+    ]
+  })
+}
+
+resource "aws_cognito_user_pool" "users" {
+  name                     = {{quote .UserPool}}
+  auto_verified_attributes = ["email"]
+
+  password_policy {
+    minimum_length    = 8
+    require_uppercase = true
+    require_lowercase = true
+    require_numbers   = true
+    require_symbols   = true
+  }
+
+  schema {
+    name                = "email"
+    attribute_data_type = "String"
+    required            = true
+    mutable             = true
+  }
+}
+
+resource "aws_cognito_user_pool_client" "api" {
+  name                = {{quote (printf "%s-api" .Name)}}
+  user_pool_id        = aws_cognito_user_pool.users.id
+  explicit_auth_flows = ["ALLOW_USER_PASSWORD_AUTH", "ALLOW_REFRESH_TOKEN_AUTH"]
+}
+
+output "S3_BUCKET_NAME" {
+  value = aws_s3_bucket.files.bucket
+}
+
+output "SQS_QUEUE_URL" {
+  value = aws_sqs_queue.processing.url
+}
+
+# Maintenance mode pauses the worker by disabling this mapping
+output "WORKER_EVENT_SOURCE_MAPPING" {
+  value = aws_lambda_event_source_mapping.processing.uuid
+}
+
+output "COGNITO_USER_POOL_ID" {
+  value = aws_cognito_user_pool.users.id
+}
+
+output "COGNITO_CLIENT_ID" {
+  value = aws_cognito_user_pool_client.api.id
+}
+`))
+
+func getEnv(key, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultValue
+}
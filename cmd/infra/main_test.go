@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderStandardQueue(t *testing.T) {
+	t.Setenv("S3_BUCKET_NAME", "uploads")
+	t.Setenv("SQS_QUEUE_URL", "https://sqs.eu-west-1.amazonaws.com/123456789012/processing")
+	t.Setenv("SQS_FIFO", "")
+	t.Setenv("PROCESSING_MAX_ATTEMPTS", "3")
+	t.Setenv("PROCESSOR_TIMEOUT", "60s")
+
+	s := loadStack("proc", "eu-west-1", "lambda.zip", "")
+	assert.Equal(t, "processing", s.Queue)
+	assert.False(t, s.FIFO)
+	assert.Equal(t, 4, s.MaxReceiveCount)
+	assert.Equal(t, 90, s.LambdaTimeout)
+	assert.Equal(t, 540, s.VisibilityTimeout)
+
+	var out bytes.Buffer
+	assert.NoError(t, render(&out, s))
+	tf := out.String()
+	assert.Contains(t, tf, `bucket = "uploads"`)
+	assert.Contains(t, tf, `name                       = "processing"`)
+	assert.Contains(t, tf, "receive_wait_time_seconds  = 20")
+	assert.Contains(t, tf, "batch_size              = 10")
+	assert.Contains(t, tf, `resource "aws_s3_bucket_notification" "files"`)
+	assert.Contains(t, tf, `PROCESSOR_TIMEOUT       = "60s"`)
+	assert.Contains(t, tf, `"athena:StartQueryExecution"`)
+	assert.Contains(t, tf, `Resource = aws_lambda_event_source_mapping.processing.arn`)
+	assert.Contains(t, tf, `output "WORKER_EVENT_SOURCE_MAPPING"`)
+	assert.NotContains(t, tf, "fifo_queue")
+	assert.NotContains(t, tf, "scaling_config")
+}
+
+func TestRenderMaxConcurrency(t *testing.T) {
+	t.Setenv("PROCESSING_MAX_CONCURRENCY", "20")
+
+	var out bytes.Buffer
+	assert.NoError(t, render(&out, loadStack("proc", "eu-west-1", "lambda.zip", "")))
+	assert.Contains(t, out.String(), "maximum_concurrency = 20")
+}
+
+func TestRenderFIFOQueueSkipsS3Notifications(t *testing.T) {
+	t.Setenv("SQS_QUEUE_URL", "http://localhost:4566/000000000000/my-queue.fifo")
+	t.Setenv("SQS_FIFO", "")
+
+	s := loadStack("proc", "us-east-1", "lambda.zip", "")
+	assert.True(t, s.FIFO)
+	assert.Equal(t, "my-queue", s.Queue)
+
+	var out bytes.Buffer
+	assert.NoError(t, render(&out, s))
+	tf := out.String()
+	assert.Contains(t, tf, `name                       = "my-queue.fifo"`)
+	assert.Contains(t, tf, `name                      = "my-queue-dlq.fifo"`)
+	assert.Contains(t, tf, "fifo_queue")
+	assert.NotContains(t, tf, "aws_s3_bucket_notification")
+}
+
+func TestRenderObjectLock(t *testing.T) {
+	t.Setenv("RETENTION_MODE", "COMPLIANCE")
+
+	s := loadStack("proc", "us-east-1", "lambda.zip", "")
+	assert.True(t, s.ObjectLock)
+
+	var out bytes.Buffer
+	assert.NoError(t, render(&out, s))
+	tf := out.String()
+	assert.Contains(t, tf, "object_lock_enabled = true")
+	assert.Contains(t, tf, `"s3:PutObjectRetention"`)
+}
+
+func TestRenderEncryptionKey(t *testing.T) {
+	t.Setenv("ENCRYPTION_KMS_KEY_ID", "alias/uploads")
+
+	s := loadStack("proc", "us-east-1", "lambda.zip", "")
+	var out bytes.Buffer
+	assert.NoError(t, render(&out, s))
+	tf := out.String()
+	assert.Contains(t, tf, `key_id = "alias/uploads"`)
+	assert.Contains(t, tf, `["kms:GenerateDataKey", "kms:Decrypt"]`)
+}
+
+func TestRenderCustomProcessorRoles(t *testing.T) {
+	t.Setenv("PROCESSOR_TIMEOUT", "60s")
+	t.Setenv("CUSTOM_PROCESSOR_ROLES", "u1=arn:aws:iam::123456789012:role/a,u2=arn:aws:iam::123456789012:role/b,u3=arn:aws:iam::123456789012:role/a")
+
+	s := loadStack("proc", "us-east-1", "lambda.zip", "")
+	assert.Equal(t, 390, s.LambdaTimeout)
+
+	var out bytes.Buffer
+	assert.NoError(t, render(&out, s))
+	tf := out.String()
+	assert.Contains(t, tf, `Resource = ["arn:aws:iam::123456789012:role/a", "arn:aws:iam::123456789012:role/b"]`)
+}
+
+func TestRenderComprehend(t *testing.T) {
+	s := loadStack("proc", "us-east-1", "lambda.zip", "")
+	var out bytes.Buffer
+	assert.NoError(t, render(&out, s))
+	assert.NotContains(t, out.String(), "comprehend:DetectPiiEntities")
+
+	t.Setenv("RESULT_GATES", "schema, pii")
+	t.Setenv("RESULT_SCHEMA", `{"type": "object"}`)
+	s = loadStack("proc", "us-east-1", "lambda.zip", "")
+	assert.True(t, s.Comprehend)
+
+	out.Reset()
+	assert.NoError(t, render(&out, s))
+	tf := out.String()
+	assert.Contains(t, tf, `Action   = "comprehend:DetectPiiEntities"`)
+	assert.Contains(t, tf, `RESULT_GATES`)
+}
+
+func TestRenderPIIProcessorComprehend(t *testing.T) {
+	t.Setenv("PII_PROCESSOR_COMPREHEND", "true")
+
+	s := loadStack("proc", "us-east-1", "lambda.zip", "")
+	assert.True(t, s.Comprehend)
+	var out bytes.Buffer
+	assert.NoError(t, render(&out, s))
+	assert.Contains(t, out.String(), `"${aws_s3_bucket.files.arn}/redacted/*"`)
+	assert.Contains(t, out.String(), "comprehend:DetectPiiEntities")
+}
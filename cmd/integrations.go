@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// Grace periods for the previous secret after a rotation, when none is given
+// and the longest allowed
+const (
+	defaultSecretGrace = 24 * time.Hour
+	maxSecretGrace     = 30 * 24 * time.Hour
+)
+
+// integrationResponse is the JSON form of an integration. Secret is only set
+// when the integration is created or its secret rotated.
+type integrationResponse struct {
+	ID                string     `json:"id"`
+	Name              string     `json:"name"`
+	UserID            string     `json:"user_id"`
+	Secret            string     `json:"secret,omitempty"`
+	UploadPrefix      string     `json:"upload_prefix"`
+	PreviousExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+	CreatedBy         string     `json:"created_by"`
+	CreatedAt         time.Time  `json:"created_at"`
+	RotatedAt         *time.Time `json:"rotated_at,omitempty"`
+}
+
+func newIntegrationResponse(in database.Integration) integrationResponse {
+	return integrationResponse{
+		ID:                in.ID,
+		Name:              in.Name,
+		UserID:            in.UserID,
+		UploadPrefix:      integrationUploadPrefix(in.ID),
+		PreviousExpiresAt: in.PreviousExpiresAt,
+		CreatedBy:         in.CreatedBy,
+		CreatedAt:         in.CreatedAt,
+		RotatedAt:         in.RotatedAt,
+	}
+}
+
+// newIntegrationSecret returns a random signing secret
+func newIntegrationSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createIntegrationHandler registers a third party that signs its callbacks,
+// with the user its files belong to. The secret is only shown in this
+// response. Only administrators can create one.
+func createIntegrationHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	var req struct {
+		Name   string `json:"name"`
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.Name == "" || req.UserID == "" {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "name and user_id are required")
+		return
+	}
+	user, err := database.GetUserByID(req.UserID)
+	if err != nil {
+		log.Printf("Error loading user %s: %v", req.UserID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating integration")
+		return
+	}
+	if user == nil {
+		apierrors.Respond(w, r, apierrors.CodeUserNotFound, "User not found")
+		return
+	}
+
+	secret, err := newIntegrationSecret()
+	if err != nil {
+		log.Printf("Error generating integration secret: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating integration")
+		return
+	}
+	in, err := database.SaveIntegration(req.Name, user.ID, secret, p.Username)
+	if err != nil {
+		log.Printf("Error saving integration: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating integration")
+		return
+	}
+	log.Printf("Integration %s (%s) created by %s", in.ID, in.Name, p.Username)
+
+	resp := newIntegrationResponse(*in)
+	resp.Secret = secret
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// listIntegrationsHandler lists the integrations, without their secrets. Only
+// administrators can see them.
+func listIntegrationsHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	list, err := database.ListIntegrations()
+	if err != nil {
+		log.Printf("Error listing integrations: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing integrations")
+		return
+	}
+
+	items := make([]integrationResponse, 0, len(list))
+	for _, in := range list {
+		items = append(items, newIntegrationResponse(in))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"integrations": items,
+	})
+}
+
+// rotateIntegrationSecretHandler gives an integration a new secret. Callbacks
+// signed with the old one are still accepted for grace_seconds, one day by
+// default, so the third party can switch over without failed calls. Only
+// administrators can rotate a secret.
+func rotateIntegrationSecretHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	var req struct {
+		GraceSeconds *int `json:"grace_seconds"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+			return
+		}
+	}
+	grace := defaultSecretGrace
+	if req.GraceSeconds != nil {
+		grace = time.Duration(*req.GraceSeconds) * time.Second
+	}
+	if grace < 0 || grace > maxSecretGrace {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("grace_seconds must be between 0 and %d", int(maxSecretGrace/time.Second)))
+		return
+	}
+
+	secret, err := newIntegrationSecret()
+	if err != nil {
+		log.Printf("Error generating integration secret: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error rotating secret")
+		return
+	}
+	id := mux.Vars(r)["id"]
+	in, err := database.RotateIntegrationSecret(id, secret, grace)
+	if err != nil {
+		log.Printf("Error rotating secret of integration %s: %v", id, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error rotating secret")
+		return
+	}
+	if in == nil {
+		apierrors.Respond(w, r, apierrors.CodeIntegrationNotFound, "Integration not found")
+		return
+	}
+	log.Printf("Secret of integration %s rotated by %s", in.ID, p.Username)
+
+	resp := newIntegrationResponse(*in)
+	resp.Secret = secret
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// deleteIntegrationHandler removes an integration, so its callbacks are
+// refused. Only administrators can remove one.
+func deleteIntegrationHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	deleted, err := database.DeleteIntegration(id)
+	if err != nil {
+		log.Printf("Error deleting integration %s: %v", id, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error deleting integration")
+		return
+	}
+	if !deleted {
+		apierrors.Respond(w, r, apierrors.CodeIntegrationNotFound, "Integration not found")
+		return
+	}
+	log.Printf("Integration %s deleted by %s", id, p.Username)
+
+	w.WriteHeader(http.StatusNoContent)
+}
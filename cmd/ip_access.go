@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/ipfilter"
+)
+
+// ipDenyCacheTTL is how long the deny list is cached between database reads.
+// Other API instances pick up a change within this time.
+const ipDenyCacheTTL = 10 * time.Second
+
+// ipDenyCache caches the deny list for ipDenyCacheTTL
+type ipDenyCache struct {
+	mu      sync.Mutex
+	set     *ipfilter.Set
+	fetched time.Time
+}
+
+var ipDenyList ipDenyCache
+
+// get returns the cached deny list, refreshing it once it is stale. If the
+// database can't be reached the last known list is kept.
+func (c *ipDenyCache) get() *ipfilter.Set {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.fetched) < ipDenyCacheTTL {
+		return c.set
+	}
+	entries, err := database.ListIPDenyEntries()
+	if err != nil {
+		log.Printf("Error reading IP deny list: %v", err)
+		return c.set
+	}
+	cidrs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		cidrs = append(cidrs, e.CIDR)
+	}
+	set, err := ipfilter.Parse(cidrs)
+	if err != nil {
+		log.Printf("Error parsing IP deny list: %v", err)
+		return c.set
+	}
+	c.set = set
+	c.fetched = time.Now()
+	return c.set
+}
+
+// invalidate makes the next request read the deny list again
+func (c *ipDenyCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetched = time.Time{}
+}
+
+// ipDenyMiddleware rejects every request from a denied address with 403
+// Forbidden
+func ipDenyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ipDenyList.get().ContainsString(clientIP(r)) {
+			apierrors.Respond(w, r, apierrors.CodeIPNotAllowed, "Requests from your address are not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAllowedIP rejects requests made with a credential, such as an API
+// key, that is limited to address ranges the client isn't in
+func requireAllowedIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := auth.PrincipalFromContext(r.Context())
+		if p == nil || len(p.AllowedCIDRs) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed, err := ipfilter.Parse(p.AllowedCIDRs)
+		if err != nil {
+			log.Printf("Error parsing allowed ranges of user %s: %v", p.UserID, err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error checking client address")
+			return
+		}
+		if !allowed.ContainsString(clientIP(r)) {
+			apierrors.Respond(w, r, apierrors.CodeIPNotAllowed, "This credential can't be used from your address")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// canonicalCIDRs validates ranges and returns them masked to their networks,
+// with single addresses written as ranges of one
+func canonicalCIDRs(ranges []string) ([]string, error) {
+	cidrs := make([]string, 0, len(ranges))
+	for _, s := range ranges {
+		p, err := ipfilter.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, p.String())
+	}
+	return cidrs, nil
+}
+
+// setAPIKeyAllowedIPsHandler limits an API key to address ranges. An empty
+// list lets the key be used from anywhere again. Only administrators can
+// change it.
+func setAPIKeyAllowedIPsHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	var req struct {
+		AllowedCIDRs []string `json:"allowed_cidrs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	cidrs, err := canonicalCIDRs(req.AllowedCIDRs)
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, err.Error())
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	k, err := database.SetAPIKeyAllowedCIDRs(id, cidrs)
+	if err != nil {
+		log.Printf("Error saving allowed ranges of API key %s: %v", id, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error saving allowed ranges")
+		return
+	}
+	if k == nil {
+		apierrors.Respond(w, r, apierrors.CodeAPIKeyNotFound, "API key not found")
+		return
+	}
+	log.Printf("API key %s limited to %v by %s", id, cidrs, p.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newAPIKeyResponse(*k))
+}
+
+// ipDenyEntryResponse is the JSON form of a denied range
+type ipDenyEntryResponse struct {
+	ID        string    `json:"id"`
+	CIDR      string    `json:"cidr"`
+	Reason    string    `json:"reason"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newIPDenyEntryResponse(e database.IPDenyEntry) ipDenyEntryResponse {
+	return ipDenyEntryResponse{
+		ID:        e.ID,
+		CIDR:      e.CIDR,
+		Reason:    e.Reason,
+		CreatedBy: e.CreatedBy,
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+// listIPDenyListHandler lists the denied ranges. Only administrators can see
+// them.
+func listIPDenyListHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	entries, err := database.ListIPDenyEntries()
+	if err != nil {
+		log.Printf("Error listing IP deny list: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing IP deny list")
+		return
+	}
+
+	items := make([]ipDenyEntryResponse, 0, len(entries))
+	for _, e := range entries {
+		items = append(items, newIPDenyEntryResponse(e))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": items,
+	})
+}
+
+// addIPDenyEntryHandler denies a range on every endpoint. Only administrators
+// can add one.
+func addIPDenyEntryHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	var req struct {
+		CIDR   string `json:"cidr"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	prefix, err := ipfilter.ParsePrefix(req.CIDR)
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, err.Error())
+		return
+	}
+
+	e, err := database.AddIPDenyEntry(prefix.String(), req.Reason, p.Username)
+	if err != nil {
+		log.Printf("Error adding IP deny list entry: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error adding IP deny list entry")
+		return
+	}
+	ipDenyList.invalidate()
+	log.Printf("Range %s denied by %s", e.CIDR, p.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newIPDenyEntryResponse(*e))
+}
+
+// deleteIPDenyEntryHandler allows a denied range again. Only administrators
+// can remove one.
+func deleteIPDenyEntryHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	deleted, err := database.DeleteIPDenyEntry(id)
+	if err != nil {
+		log.Printf("Error deleting IP deny list entry: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error deleting IP deny list entry")
+		return
+	}
+	if !deleted {
+		apierrors.Respond(w, r, apierrors.CodeIPDenyEntryNotFound, "IP deny list entry not found")
+		return
+	}
+	ipDenyList.invalidate()
+	log.Printf("IP deny list entry %s removed by %s", id, p.Username)
+
+	w.WriteHeader(http.StatusNoContent)
+}
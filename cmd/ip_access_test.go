@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/auth"
+)
+
+func TestRequireAllowedIP(t *testing.T) {
+	handler := requireAllowedIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func(addr string, cidrs []string) int {
+		r := httptest.NewRequest("GET", "/api/files", nil)
+		r.RemoteAddr = addr + ":5000"
+		r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{UserID: "u1", AllowedCIDRs: cidrs}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, call("203.0.113.7", nil))
+	assert.Equal(t, http.StatusOK, call("203.0.113.7", []string{"203.0.113.0/24"}))
+	assert.Equal(t, http.StatusOK, call("2001:db8::1", []string{"203.0.113.0/24", "2001:db8::/32"}))
+	assert.Equal(t, http.StatusForbidden, call("198.51.100.1", []string{"203.0.113.0/24"}))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+)
+
+// jwtSigning is the JWT_SIGNING mode ID tokens are signed in, empty when
+// they aren't
+var jwtSigning string
+
+// idToken returns the ID token for a sign-in: a signed JWT when JWT signing
+// is configured, the access token otherwise
+func idToken(r *http.Request, user *auth.MockUser) (string, error) {
+	token, err := auth.IssueJWT(r.Context(), user)
+	if errors.Is(err, auth.ErrJWTSigningOff) {
+		return user.AccessToken, nil
+	}
+	return token, err
+}
+
+// jwksHandler publishes the keys the API's ID tokens verify with. Verifiers
+// cache it, so keys stay published until the tokens they signed expire.
+func jwksHandler(w http.ResponseWriter, r *http.Request) {
+	doc, err := auth.JWKS(r.Context())
+	if err != nil {
+		log.Printf("Error loading JWT signing keys: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading signing keys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// rotateJWTKeyHandler has the API sign new tokens with a new local key. The
+// old key stays published until the tokens it signed expire.
+func rotateJWTKeyHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	kid, err := auth.RotateJWTKey(r.Context())
+	if err != nil {
+		log.Printf("Error rotating the JWT signing key: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error rotating signing key")
+		return
+	}
+	log.Printf("JWT signing key rotated to %s by %s", kid, p.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"kid": kid})
+}
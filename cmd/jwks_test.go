@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/auth"
+)
+
+func TestIDTokenWithoutSigning(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/auth/signin", nil)
+	token, err := idToken(r, &auth.MockUser{ID: "u1", AccessToken: "opaque"})
+	assert.NoError(t, err)
+	assert.Equal(t, "opaque", token)
+}
+
+func TestRotateJWTKeyRequiresAdmin(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/admin/jwt-keys/rotate", nil)
+	r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{UserID: "u1", Username: "alice", Roles: []string{auth.RoleUser}}))
+	w := httptest.NewRecorder()
+	rotateJWTKeyHandler(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
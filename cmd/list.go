@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// fileSummary is a file as it appears in list responses, without its content
+type fileSummary struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	SizeBytes int64     `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"`
+	// Revision tells apart files uploaded under the same name
+	Revision     int             `json:"name_revision"`
+	StorageClass string          `json:"storage_class"`
+	Retention    *retentionInfo  `json:"retention,omitempty"`
+	Encryption   *encryptionInfo `json:"encryption,omitempty"`
+	LegalHold    bool            `json:"legal_hold"`
+	// ParentID is the archive the file was expanded from
+	ParentID string `json:"parent_id,omitempty"`
+	// Redacted is set when the file has a redacted copy, RedactionPending
+	// until the pii processor has run on a file whose pipeline has it
+	Redacted         bool `json:"redacted,omitempty"`
+	RedactionPending bool `json:"redaction_pending,omitempty"`
+}
+
+func newFileSummary(f database.File) fileSummary {
+	return fileSummary{
+		ID:               f.ID,
+		Name:             f.Name,
+		SizeBytes:        f.SizeBytes,
+		CreatedAt:        f.CreatedAt,
+		UpdatedAt:        f.UpdatedAt,
+		Version:          f.Version,
+		Revision:         f.NameRevision,
+		StorageClass:     f.StorageClass,
+		Retention:        newRetentionInfo(f.RetentionMode, f.RetainUntil),
+		Encryption:       newEncryptionInfo(f.Encryption),
+		LegalHold:        f.LegalHold,
+		ParentID:         f.ParentID,
+		Redacted:         f.Redacted(),
+		RedactionPending: f.RedactionPending,
+	}
+}
+
+// userSummary is a user as it appears in list responses
+type userSummary struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Confirmed bool      `json:"confirmed"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// paginationParams reads the pagination query parameters, writing a 400
+// response and returning false if they are invalid
+func paginationParams(w http.ResponseWriter, r *http.Request) (pagination.Params, bool) {
+	params, err := pagination.FromRequest(r)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		apierrors.Write(w, r, err)
+		return params, false
+	}
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, err.Error())
+		return params, false
+	}
+	return params, true
+}
+
+// listFilesHandler lists the caller's files, newest first
+func listFilesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	params, ok := paginationParams(w, r)
+	if !ok {
+		return
+	}
+
+	files, err := database.ListFilesByUser(userID, params.After, params.Limit)
+	if err != nil {
+		log.Printf("Error listing files: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing files")
+		return
+	}
+	files, next := pagination.Trim(files, params.Limit, func(f database.File) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: f.CreatedAt, ID: f.ID}
+	})
+
+	items := make([]fileSummary, 0, len(files))
+	for _, f := range files {
+		items = append(items, newFileSummary(f))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files":       items,
+		"next_cursor": next,
+	})
+}
+
+// listChildFilesHandler lists the files expanded from an archive
+func listChildFilesHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["id"]
+	if authorizeFile(w, r, fileID) == nil {
+		return
+	}
+
+	files, err := database.ListChildFiles(fileID)
+	if err != nil {
+		log.Printf("Error listing archive members: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing files")
+		return
+	}
+
+	items := make([]fileSummary, 0, len(files))
+	for _, f := range files {
+		items = append(items, newFileSummary(f))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": items,
+	})
+}
+
+// listResultsHandler lists every processing result recorded for a file, newest first
+func listResultsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	params, ok := paginationParams(w, r)
+	if !ok {
+		return
+	}
+	if authorizeFile(w, r, fileID) == nil {
+		return
+	}
+
+	results, err := database.ListProcessingResultsByFileID(fileID, params.After, params.Limit)
+	if err != nil {
+		log.Printf("Error listing processing results: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing processing results")
+		return
+	}
+	results, next := pagination.Trim(results, params.Limit, func(pr database.ProcessingResult) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: pr.CreatedAt, ID: pr.ID}
+	})
+
+	items := make([]ProcessingResult, 0, len(results))
+	for _, pr := range results {
+		if err := loadOffloadedResult(r.Context(), &pr); err != nil {
+			log.Printf("Error listing processing results: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing processing results")
+			return
+		}
+		items = append(items, ProcessingResult{
+			ID:        pr.ID,
+			Status:    pr.Status,
+			Result:    pr.Result,
+			Source:    pr.Source,
+			CreatedAt: pr.CreatedAt,
+			UpdatedAt: pr.UpdatedAt,
+			Version:   pr.Version,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results":     items,
+		"next_cursor": next,
+	})
+}
+
+// listUsersHandler lists users, newest first, for administrators
+func listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	params, ok := paginationParams(w, r)
+	if !ok {
+		return
+	}
+
+	users, err := database.ListUsers(params.After, params.Limit)
+	if err != nil {
+		log.Printf("Error listing users: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing users")
+		return
+	}
+	users, next := pagination.Trim(users, params.Limit, func(u database.User) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: u.CreatedAt, ID: u.ID}
+	})
+
+	items := make([]userSummary, 0, len(users))
+	for _, u := range users {
+		items = append(items, userSummary{
+			ID:        u.ID,
+			Username:  u.Username,
+			Confirmed: u.Confirmed,
+			CreatedAt: u.CreatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users":       items,
+		"next_cursor": next,
+	})
+}
@@ -4,38 +4,106 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/google/uuid"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
+	"github.com/yourusername/golang-aws-api/apierrors"
 	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/contenttype"
 	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/envelope"
+	"github.com/yourusername/golang-aws-api/ingest"
+	"github.com/yourusername/golang-aws-api/metering"
+	"github.com/yourusername/golang-aws-api/objectcache"
+	"github.com/yourusername/golang-aws-api/processor"
+	"github.com/yourusername/golang-aws-api/queue"
+	"github.com/yourusername/golang-aws-api/quota"
+	"github.com/yourusername/golang-aws-api/removal"
+	"github.com/yourusername/golang-aws-api/search"
+	"github.com/yourusername/golang-aws-api/settings"
+	"github.com/yourusername/golang-aws-api/storage"
+	"github.com/yourusername/golang-aws-api/wasm"
 )
 
 // Global variables
 var (
-	s3Client    *s3.Client
-	sqsQueueURL string
-	bucketName  string
+	s3Client   *storage.Store
+	bucketName string
+	keyService envelope.KeyService
+	// ingestStore stores files uploaded with upload tokens
+	ingestStore *ingest.Store
+	// fileRemover deletes files and everything stored for them
+	fileRemover *removal.Remover
+	// meteringSink receives usage records, if METERING_SINK configures one
+	meteringSink metering.Sink
+	// downloadCache keeps small downloaded files on local disk, if
+	// DOWNLOAD_CACHE_DIR configures it
+	downloadCache *objectcache.Cache
+
+	// serviceAuth is set when service accounts may call the API with SigV4
+	// service tokens or client certificates
+	serviceAuth bool
 )
 
-// FileData represents the data structure for file uploads
+// FileData represents the data structure for file uploads. Uploads stream
+// their content into a spool instead of Content; see readUpload.
 type FileData struct {
 	ID        string    `json:"id"`
 	Name      string    `json:"name"`
-	Content   string    `json:"content"`
+	Content   string    `json:"content,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"`
+
+	// Optional collection to upload into, which must belong to the caller
+	CollectionID string `json:"collection_id,omitempty"`
+	// What to do if the caller already has a file with this name: reject,
+	// rename or version. Defaults to FILE_NAME_POLICY.
+	OnDuplicate string `json:"on_duplicate,omitempty"`
+
+	// Optional deferred processing, either as a delay or an absolute time
+	ProcessDelaySeconds int        `json:"process_delay_seconds,omitempty"`
+	ProcessAt           *time.Time `json:"process_at,omitempty"`
+	// Files of the caller's whose processing must complete before this
+	// file is processed
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	// S3 storage class: STANDARD, STANDARD_IA or INTELLIGENT_TIERING.
+	// Defaults to the owner's or the deployment's default.
+	StorageClass string `json:"storage_class,omitempty"`
+	// Object Lock retention, set by the deployment's retention policy
+	Retention *retentionInfo `json:"retention,omitempty"`
+	// Client-side encryption, set by the deployment's encryption policy
+	Encryption *encryptionInfo `json:"encryption,omitempty"`
+	// Set by an administrator to keep the file from being deleted
+	LegalHold bool `json:"legal_hold"`
+	// Store each member of a zip or tar archive as a file of its own once
+	// the archive is processed
+	ExpandArchive bool `json:"expand_archive,omitempty"`
+	// Size of the content in bytes and the MIME type detected from it; both
+	// are set by the server and ignored on upload
+	SizeBytes   int64  `json:"size_bytes"`
+	ContentType string `json:"content_type,omitempty"`
+	// Set by the server when the pii processor found personal data; callers
+	// who may not see it are served a redacted copy
+	Redacted bool `json:"redacted,omitempty"`
+	// Set by the server until the pii processor has run; meanwhile callers
+	// who may not see personal data aren't served the content
+	RedactionPending bool `json:"redaction_pending,omitempty"`
 }
 
 // ProcessingResult represents the result from Lambda processing
@@ -43,58 +111,63 @@ type ProcessingResult struct {
 	ID        string    `json:"id"`
 	Status    string    `json:"status"`
 	Result    string    `json:"result"`
+	Source    string    `json:"source,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"`
+	// ReusedFrom is the file whose result this copies, as the content was
+	// unchanged
+	ReusedFrom string `json:"reused_from,omitempty"`
 }
 
 func setupAWS() error {
 	// Set up AWS configuration
-	customResolver := aws.EndpointResolverWithOptions(aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-		if os.Getenv("ENV") == "local" {
-			localstackHost := os.Getenv("LOCALSTACK_HOST")
-			if localstackHost == "" {
-				localstackHost = "localstack"
-			}
-			localstackPort := os.Getenv("LOCALSTACK_PORT")
-			if localstackPort == "" {
-				localstackPort = "4566"
-			}
-			return aws.Endpoint{
-				URL:               fmt.Sprintf("http://%s:%s", localstackHost, localstackPort),
-				SigningRegion:     "us-east-1",
-				HostnameImmutable: true,
-			}, nil
-		}
-		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
-	}))
-
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion("us-east-1"),
-		config.WithEndpointResolverWithOptions(customResolver),
-	)
-
-	if os.Getenv("ENV") == "local" {
-		cfg.Credentials = credentials.NewStaticCredentialsProvider("test", "test", "")
-	}
-
+	cfg, err := awsconfig.Load(context.TODO())
 	if err != nil {
-		return fmt.Errorf("failed to load AWS configuration: %v", err)
+		return err
 	}
 
-	s3Client = s3.NewFromConfig(cfg)
+	queue.InitQueue(cfg)
 
-	// Set bucket and queue names
+	// Set bucket name
 	bucketName = os.Getenv("S3_BUCKET_NAME")
 	if bucketName == "" {
 		bucketName = "my-test-bucket"
 	}
-	sqsQueueURL = os.Getenv("SQS_QUEUE_URL")
-	if sqsQueueURL == "" {
-		sqsQueueURL = "http://localhost:4566/000000000000/my-queue"
+	s3Client = storage.New(cfg, bucketName)
+	keyService = envelope.NewKMS(cfg)
+	database.SetColumnEncryption(keyService, os.Getenv("COLUMN_ENCRYPTION_KEY_ID"))
+	ingestStore = &ingest.Store{S3: s3Client, Bucket: bucketName, Keys: keyService}
+	searchBackend = search.New()
+	fileRemover = &removal.Remover{S3: s3Client, Bucket: bucketName, Index: searchBackend}
+	athenaClient = newAthenaClient(cfg)
+	workerMappings = lambda.NewFromConfig(cfg)
+	workerMapping = os.Getenv("WORKER_EVENT_SOURCE_MAPPING")
+	if meteringSink, err = metering.New(cfg); err != nil {
+		return err
+	}
+
+	if cognitoAuth() {
+		if err := auth.InitCognito(cfg); err != nil {
+			return err
+		}
+	}
+	if serviceAuth, err = auth.InitServiceAuth(cfg); err != nil {
+		return err
+	}
+	if jwtSigning, err = auth.InitJWTSigning(cfg); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// cognitoAuth reports whether AUTH_BACKEND selects Cognito tokens instead of
+// the built-in mock users
+func cognitoAuth() bool {
+	return os.Getenv("AUTH_BACKEND") == "cognito"
+}
+
 func main() {
 	// Initialize AWS
 	log.Println("Setting up AWS...")
@@ -110,25 +183,186 @@ func main() {
 	}
 	log.Println("Database initialization completed")
 
-	// Initialize mock authentication
+	// Initialize mock authentication, unless tokens come from Cognito
 	log.Println("Initializing authentication...")
-	auth.MockInit()
+	requireAuth, optionalAuth := auth.MockAuthMiddleware, auth.MockOptionalAuthMiddleware
+	if cognitoAuth() {
+		requireAuth, optionalAuth = auth.AuthMiddleware, auth.OptionalAuthMiddleware
+	} else {
+		auth.MockInit()
+		if err := auth.InitOIDC(); err != nil {
+			log.Fatalf("Failed to configure external login: %v", err)
+		}
+	}
+	if serviceAuth {
+		requireAuth, optionalAuth = auth.ServiceAuth(requireAuth), auth.ServiceAuth(optionalAuth)
+	}
 	log.Println("Authentication initialization completed")
 
-	r := mux.NewRouter()
+	var err error
+	if trustedProxies, err = loadTrustedProxies(); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	if downloadCache, err = objectcache.Load(); err != nil {
+		log.Fatalf("Invalid download cache: %v", err)
+	}
+
+	// Load the plugin processors first, as settings may name them in pipelines
+	wasm.Watch(context.Background(), wasm.RefreshInterval())
+
+	// Load runtime settings, reloaded on SIGHUP
+	settings.Init(context.Background())
 
-	// Public endpoints (no auth required)
-	r.HandleFunc("/api/auth/signup", mockSignUpHandler).Methods("POST")
-	r.HandleFunc("/api/auth/confirm", mockConfirmSignUpHandler).Methods("POST")
-	r.HandleFunc("/api/auth/signin", mockSignInHandler).Methods("POST")
-	r.HandleFunc("/api/files", uploadFileHandler).Methods("POST")
+	// Start the scheduler for deferred processing
+	startScheduler(context.Background())
+	startQueueDepthMonitor(context.Background())
+	startResultConsumer(context.Background())
+	startProgressListener(context.Background())
+
+	r := mux.NewRouter()
+	// Middleware only runs for matched routes, so unmatched requests are
+	// logged by wrapping the not found handler
+	r.NotFoundHandler = clientIPMiddleware(accessLogMiddleware(http.NotFoundHandler()))
+	r.Use(clientIPMiddleware)
+	r.Use(accessLogMiddleware)
+	r.Use(auditMiddleware)
+	r.Use(ipDenyMiddleware)
+	r.Use(maintenanceMiddleware)
+
+	// Public endpoints (no auth required). With Cognito, clients sign up and
+	// sign in against the user pool directly.
+	if !cognitoAuth() {
+		r.Handle("/api/auth/signup", limitRequests(mockSignUpHandler)).Methods("POST")
+		r.Handle("/api/auth/confirm", limitRequests(mockConfirmSignUpHandler)).Methods("POST")
+		r.Handle("/api/auth/signin", limitRequests(mockSignInHandler)).Methods("POST")
+		r.Handle("/api/auth/oidc", limitRequests(oidcProvidersHandler)).Methods("GET")
+		r.Handle("/api/auth/oidc/{provider}/login", limitRequests(oidcLoginHandler)).Methods("GET")
+		r.Handle("/api/auth/oidc/{provider}/callback", limitRequests(oidcCallbackHandler)).Methods("GET")
+	}
+	if jwtSigning != "" {
+		r.Handle("/.well-known/jwks.json", limitRequests(jwksHandler)).Methods("GET")
+	}
+	r.Handle("/api/files", optionalAuth(withRequestUser(requests.middleware(requireAllowedIP(auth.RequireScope(auth.ScopeFilesWrite, withIdempotency(uploadFileHandler))))))).Methods("POST")
+	r.Handle("/share/{token}", limitRequests(limitStreams("share", throttleDownloads(publicShareHandler)))).Methods("GET", "POST")
+	r.Handle("/upload/{token}", limitRequests(uploadWithTokenHandler)).Methods("POST")
+	r.Handle("/upload/{token}", limitRequests(uploadTokenPreflightHandler)).Methods("OPTIONS")
+	r.Handle("/callbacks/{integration}/upload-complete", limitRequests(verifyCallback(uploadCompleteCallbackHandler))).Methods("POST")
+	r.Handle("/health", limitRequests(healthHandler)).Methods("GET")
+	r.Handle("/metrics", limitRequests(metricsHandler)).Methods("GET")
+	r.Handle("/api/errors", limitRequests(errorCatalogHandler)).Methods("GET")
+	r.Handle("/api/errors/{code}", limitRequests(errorCodeHandler)).Methods("GET")
 
 	// Protected endpoints (auth required)
 	api := r.PathPrefix("/api").Subrouter()
-	api.Use(auth.MockAuthMiddleware)
-
-	api.HandleFunc("/files/{id}", getFileHandler).Methods("GET")
-	api.HandleFunc("/files/{id}/result", getResultHandler).Methods("GET")
+	api.Use(requireAuth)
+	api.Use(withRequestUser)
+	api.Use(requireAllowedIP)
+	api.Use(requests.middleware)
+
+	api.HandleFunc("/files", auth.RequireScope(auth.ScopeFilesRead, withFields(listFilesHandler))).Methods("GET")
+	api.HandleFunc("/files/import", auth.RequireScope(auth.ScopeAdmin, importFilesHandler)).Methods("POST")
+	api.HandleFunc("/files/from-url", auth.RequireScope(auth.ScopeFilesWrite, withIdempotency(uploadFromURLHandler))).Methods("POST")
+	api.HandleFunc("/files/search", auth.RequireScope(auth.ScopeFilesRead, withFields(searchFilesHandler))).Methods("GET")
+	api.HandleFunc("/files/status", auth.RequireScope(auth.ScopeResultsRead, withFields(fileStatusHandler))).Methods("POST")
+	api.HandleFunc("/files/download-zip", auth.RequireScope(auth.ScopeFilesRead, limitStreams("download-zip", throttleDownloads(downloadZipHandler)))).Methods("POST")
+	api.HandleFunc("/files/{id}", auth.RequireScope(auth.ScopeFilesRead, withFields(getFileHandler))).Methods("GET")
+	api.HandleFunc("/files/{id}", auth.RequireScope(auth.ScopeFilesRead, headFileHandler)).Methods("HEAD")
+	api.HandleFunc("/files/{id}", auth.RequireScope(auth.ScopeFilesWrite, updateFileHandler)).Methods("PATCH")
+	api.HandleFunc("/files/{id}", auth.RequireScope(auth.ScopeFilesWrite, deleteFileHandler)).Methods("DELETE")
+	api.HandleFunc("/files/{id}/result", auth.RequireScope(auth.ScopeResultsRead, withFields(getResultHandler))).Methods("GET")
+	api.HandleFunc("/files/{id}/result", auth.RequireScope(auth.ScopeAdmin, updateResultHandler)).Methods("PATCH")
+	api.HandleFunc("/files/{id}/results", auth.RequireScope(auth.ScopeResultsRead, withFields(listResultsHandler))).Methods("GET")
+	api.HandleFunc("/files/{id}/events", auth.RequireScope(auth.ScopeResultsRead, limitStreams("events", fileEventsHandler))).Methods("GET")
+	api.HandleFunc("/files/{id}/timeline", auth.RequireScope(auth.ScopeResultsRead, timelineHandler)).Methods("GET")
+	api.HandleFunc("/files/{id}/stats", auth.RequireScope(auth.ScopeResultsRead, fileStatsHandler)).Methods("GET")
+	api.HandleFunc("/files/{id}/children", auth.RequireScope(auth.ScopeFilesRead, withFields(listChildFilesHandler))).Methods("GET")
+	api.HandleFunc("/files/{id}/chunks", auth.RequireScope(auth.ScopeFilesRead, listChunksHandler)).Methods("GET")
+	api.HandleFunc("/files/{id}/chunks/{number}", auth.RequireScope(auth.ScopeFilesWrite, replaceChunkHandler)).Methods("PUT")
+	api.HandleFunc("/files/{id}/verify", auth.RequireScope(auth.ScopeFilesRead, verifyChunksHandler)).Methods("POST")
+	api.HandleFunc("/files/{id}/download", auth.RequireScope(auth.ScopeFilesRead, limitStreams("download", throttleDownloads(downloadFileHandler)))).Methods("GET")
+	api.HandleFunc("/files/{id}/preview", auth.RequireScope(auth.ScopeFilesRead, filePreviewHandler)).Methods("GET")
+	api.HandleFunc("/files/{id}/download-url", auth.RequireScope(auth.ScopeFilesRead, downloadURLHandler)).Methods("GET")
+	api.HandleFunc("/files/{id}/access-log", auth.RequireScope(auth.ScopeFilesRead, accessLogHandler)).Methods("GET")
+	api.HandleFunc("/files/{id}/share", auth.RequireScope(auth.ScopeFilesWrite, createShareHandler)).Methods("POST")
+	api.HandleFunc("/files/{id}/result/export", auth.RequireScope(auth.ScopeResultsRead, exportResultHandler)).Methods("GET")
+	api.HandleFunc("/files/{id}/hold", auth.RequireScope(auth.ScopeAdmin, setLegalHoldHandler)).Methods("PUT")
+	api.HandleFunc("/files/{id}/schedule", auth.RequireScope(auth.ScopeFilesWrite, cancelScheduleHandler)).Methods("DELETE")
+	api.HandleFunc("/exports", auth.RequireScope(auth.ScopeFilesRead, createExportHandler)).Methods("POST")
+	api.HandleFunc("/exports/{id}", auth.RequireScope(auth.ScopeFilesRead, getExportHandler)).Methods("GET")
+	api.HandleFunc("/upload-tokens", auth.RequireScope(auth.ScopeFilesWrite, createUploadTokenHandler)).Methods("POST")
+	api.HandleFunc("/shares", auth.RequireScope(auth.ScopeFilesRead, withFields(listSharesHandler))).Methods("GET")
+	api.HandleFunc("/shares/{id}", auth.RequireScope(auth.ScopeFilesWrite, revokeShareHandler)).Methods("DELETE")
+	api.HandleFunc("/users", auth.RequireScope(auth.ScopeAdmin, withFields(listUsersHandler))).Methods("GET")
+	api.HandleFunc("/users/me", auth.RequireUnscoped(deleteAccountHandler)).Methods("DELETE")
+	api.HandleFunc("/users/me/export", auth.RequireUnscoped(createAccountExportHandler)).Methods("POST")
+	api.HandleFunc("/users/me/deletion", auth.RequireUnscoped(getAccountDeletionHandler)).Methods("GET")
+	api.HandleFunc("/users/me/deletion", auth.RequireUnscoped(cancelAccountDeletionHandler)).Methods("DELETE")
+	api.HandleFunc("/ssh-keys", auth.RequireUnscoped(createSSHKeyHandler)).Methods("POST")
+	api.HandleFunc("/ssh-keys", auth.RequireUnscoped(listSSHKeysHandler)).Methods("GET")
+	api.HandleFunc("/ssh-keys/{id}", auth.RequireUnscoped(deleteSSHKeyHandler)).Methods("DELETE")
+	api.HandleFunc("/api-keys", auth.RequireUnscoped(createAPIKeyHandler)).Methods("POST")
+	api.HandleFunc("/api-keys", auth.RequireUnscoped(listAPIKeysHandler)).Methods("GET")
+	api.HandleFunc("/api-keys/{id}", auth.RequireUnscoped(deleteAPIKeyHandler)).Methods("DELETE")
+	api.HandleFunc("/webhooks", auth.RequireUnscoped(createWebhookHandler)).Methods("POST")
+	api.HandleFunc("/webhooks", auth.RequireUnscoped(listWebhooksHandler)).Methods("GET")
+	api.HandleFunc("/webhooks/{id}", auth.RequireUnscoped(deleteWebhookHandler)).Methods("DELETE")
+	api.HandleFunc("/schedules", auth.RequireUnscoped(createScheduleHandler)).Methods("POST")
+	api.HandleFunc("/schedules", auth.RequireUnscoped(listSchedulesHandler)).Methods("GET")
+	api.HandleFunc("/schedules/{id}", auth.RequireUnscoped(getScheduleHandler)).Methods("GET")
+	api.HandleFunc("/schedules/{id}", auth.RequireUnscoped(deleteScheduleHandler)).Methods("DELETE")
+	api.HandleFunc("/schedules/{id}/runs", auth.RequireUnscoped(listScheduleRunsHandler)).Methods("GET")
+	api.HandleFunc("/pipelines", auth.RequireUnscoped(listPipelinesHandler)).Methods("GET")
+	api.HandleFunc("/pipelines/{type}", auth.RequireUnscoped(setPipelineHandler)).Methods("PUT")
+	api.HandleFunc("/pipelines/{type}", auth.RequireUnscoped(deletePipelineHandler)).Methods("DELETE")
+	api.HandleFunc("/custom-processor", auth.RequireUnscoped(getCustomProcessorHandler)).Methods("GET")
+	api.HandleFunc("/custom-processor", auth.RequireUnscoped(setCustomProcessorHandler)).Methods("PUT")
+	api.HandleFunc("/custom-processor", auth.RequireUnscoped(deleteCustomProcessorHandler)).Methods("DELETE")
+	api.HandleFunc("/events", auth.RequireScope(auth.ScopeResultsRead, limitStreams("events", userEventsHandler))).Methods("GET")
+	api.HandleFunc("/stats", auth.RequireScope(auth.ScopeResultsRead, statsHandler)).Methods("GET")
+	api.HandleFunc("/usage", auth.RequireScope(auth.ScopeFilesRead, usageHandler)).Methods("GET")
+	api.HandleFunc("/collections", auth.RequireScope(auth.ScopeFilesWrite, createCollectionHandler)).Methods("POST")
+	api.HandleFunc("/collections", auth.RequireScope(auth.ScopeFilesRead, withFields(listCollectionsHandler))).Methods("GET")
+	api.HandleFunc("/collections/{id}", auth.RequireScope(auth.ScopeFilesRead, withFields(getCollectionHandler))).Methods("GET")
+	api.HandleFunc("/collections/{id}", auth.RequireScope(auth.ScopeFilesWrite, updateCollectionHandler)).Methods("PATCH")
+	api.HandleFunc("/collections/{id}", auth.RequireScope(auth.ScopeFilesWrite, deleteCollectionHandler)).Methods("DELETE")
+	api.HandleFunc("/collections/{id}/files", auth.RequireScope(auth.ScopeFilesWrite, addCollectionFilesHandler)).Methods("POST")
+	api.HandleFunc("/collections/{id}/download", auth.RequireScope(auth.ScopeFilesRead, limitStreams("collection-download", throttleDownloads(downloadCollectionHandler)))).Methods("GET")
+	api.HandleFunc("/collections/{id}/reprocess", auth.RequireScope(auth.ScopeFilesWrite, reprocessCollectionHandler)).Methods("POST")
+	api.HandleFunc("/admin/health", auth.RequireScope(auth.ScopeAdmin, adminHealthHandler)).Methods("GET")
+	api.HandleFunc("/admin/maintenance", auth.RequireScope(auth.ScopeAdmin, getMaintenanceHandler)).Methods("GET")
+	api.HandleFunc("/admin/maintenance", auth.RequireScope(auth.ScopeAdmin, setMaintenanceHandler)).Methods("PUT")
+	api.HandleFunc("/admin/stuck-files", auth.RequireScope(auth.ScopeAdmin, listStuckFilesHandler)).Methods("GET")
+	api.HandleFunc("/admin/stuck-files/requeue", auth.RequireScope(auth.ScopeAdmin, requeueStuckFilesHandler)).Methods("POST")
+	api.HandleFunc("/admin/schedules/run", auth.RequireScope(auth.ScopeAdmin, runSchedulesHandler)).Methods("POST")
+	api.HandleFunc("/admin/analytics/partitions", auth.RequireScope(auth.ScopeAdmin, listAnalyticsPartitionsHandler)).Methods("GET")
+	api.HandleFunc("/admin/analytics/partitions/{day}/export", auth.RequireScope(auth.ScopeAdmin, exportAnalyticsDayHandler)).Methods("POST")
+	api.HandleFunc("/admin/analytics/queries", auth.RequireScope(auth.ScopeAdmin, listAnalyticsQueriesHandler)).Methods("GET")
+	api.HandleFunc("/admin/analytics/queries/runs", auth.RequireScope(auth.ScopeAdmin, listAnalyticsQueryRunsHandler)).Methods("GET")
+	api.HandleFunc("/admin/analytics/queries/runs/{id}", auth.RequireScope(auth.ScopeAdmin, getAnalyticsQueryRunHandler)).Methods("GET")
+	api.HandleFunc("/admin/analytics/queries/{name}/runs", auth.RequireScope(auth.ScopeAdmin, runAnalyticsQueryHandler)).Methods("POST")
+	api.HandleFunc("/admin/quarantine", auth.RequireScope(auth.ScopeAdmin, listQuarantinesHandler)).Methods("GET")
+	api.HandleFunc("/admin/quarantine/{id}", auth.RequireScope(auth.ScopeAdmin, getQuarantineHandler)).Methods("GET")
+	api.HandleFunc("/admin/quarantine/{id}/approve", auth.RequireScope(auth.ScopeAdmin, approveQuarantineHandler)).Methods("POST")
+	api.HandleFunc("/admin/quarantine/{id}/reject", auth.RequireScope(auth.ScopeAdmin, rejectQuarantineHandler)).Methods("POST")
+	api.HandleFunc("/admin/account-deletions", auth.RequireScope(auth.ScopeAdmin, listAccountDeletionsHandler)).Methods("GET")
+	api.HandleFunc("/admin/plugins", auth.RequireScope(auth.ScopeAdmin, listPluginsHandler)).Methods("GET")
+	api.HandleFunc("/admin/plugins/{name}", auth.RequireScope(auth.ScopeAdmin, uploadPluginHandler)).Methods("PUT")
+	api.HandleFunc("/admin/plugins/{name}", auth.RequireScope(auth.ScopeAdmin, deletePluginHandler)).Methods("DELETE")
+	api.HandleFunc("/admin/tenants", auth.RequireScope(auth.ScopeAdmin, listTenantSettingsHandler)).Methods("GET")
+	api.HandleFunc("/admin/tenants/{user_id}", auth.RequireScope(auth.ScopeAdmin, getTenantSettingsHandler)).Methods("GET")
+	api.HandleFunc("/admin/tenants/{user_id}", auth.RequireScope(auth.ScopeAdmin, setTenantSettingsHandler)).Methods("PUT")
+	api.HandleFunc("/admin/tenants/{user_id}", auth.RequireScope(auth.ScopeAdmin, deleteTenantSettingsHandler)).Methods("DELETE")
+	api.HandleFunc("/admin/api-keys/{id}/allowed-ips", auth.RequireScope(auth.ScopeAdmin, setAPIKeyAllowedIPsHandler)).Methods("PUT")
+	api.HandleFunc("/admin/ip-deny-list", auth.RequireScope(auth.ScopeAdmin, listIPDenyListHandler)).Methods("GET")
+	api.HandleFunc("/admin/ip-deny-list", auth.RequireScope(auth.ScopeAdmin, addIPDenyEntryHandler)).Methods("POST")
+	api.HandleFunc("/admin/ip-deny-list/{id}", auth.RequireScope(auth.ScopeAdmin, deleteIPDenyEntryHandler)).Methods("DELETE")
+	api.HandleFunc("/admin/integrations", auth.RequireScope(auth.ScopeAdmin, createIntegrationHandler)).Methods("POST")
+	api.HandleFunc("/admin/integrations", auth.RequireScope(auth.ScopeAdmin, listIntegrationsHandler)).Methods("GET")
+	api.HandleFunc("/admin/integrations/{id}/rotate", auth.RequireScope(auth.ScopeAdmin, rotateIntegrationSecretHandler)).Methods("POST")
+	if jwtSigning == auth.JWTSigningLocal {
+		api.HandleFunc("/admin/jwt-keys/rotate", auth.RequireScope(auth.ScopeAdmin, rotateJWTKeyHandler)).Methods("POST")
+	}
+	api.HandleFunc("/admin/integrations/{id}", auth.RequireScope(auth.ScopeAdmin, deleteIntegrationHandler)).Methods("DELETE")
 
 	// Start the server
 	port := os.Getenv("PORT")
@@ -136,8 +370,20 @@ func main() {
 		port = "8080"
 	}
 
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
+	}
+	srv := &http.Server{Addr: ":" + port, Handler: r, TLSConfig: tlsConfig}
+
 	log.Printf("Server starting on port %s...", port)
-	if err := http.ListenAndServe(":"+port, r); err != nil {
+	if tlsConfig != nil {
+		// The certificate is already loaded into tlsConfig
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
@@ -151,20 +397,22 @@ func mockSignUpHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
-	user, err := auth.MockSignUp(r.Context(), req.Username, req.Password, req.Email)
-	if err != nil {
-		http.Error(w, "Failed to sign up: "+err.Error(), http.StatusInternalServerError)
+	// A taken username or email gets the same response as a new account, so
+	// sign-up can't be used to find out who is registered
+	if _, err := auth.MockSignUp(r.Context(), req.Username, req.Password, req.Email); err != nil {
+		log.Printf("Error signing up %s: %v", req.Username, err)
+		apierrors.Write(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "User registered successfully. Please check your email for confirmation code.",
-		"user_id": user.Username,
+		"user_id": req.Username,
 	})
 }
 
@@ -176,13 +424,14 @@ func mockConfirmSignUpHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	err := auth.MockConfirmSignUp(r.Context(), req.Username, req.Code)
 	if err != nil {
-		http.Error(w, "Failed to confirm sign up: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error confirming sign up for %s: %v", req.Username, err)
+		apierrors.Write(w, r, err)
 		return
 	}
 
@@ -197,165 +446,504 @@ func mockSignInHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		// Optional scopes limiting the token, e.g. ["files:read"]
+		Scopes []string `json:"scopes,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
-	user, err := auth.MockSignIn(r.Context(), req.Username, req.Password)
+	user, err := auth.MockSignIn(r.Context(), req.Username, req.Password, req.Scopes)
 	if err != nil {
-		http.Error(w, "Failed to sign in: "+err.Error(), http.StatusUnauthorized)
+		log.Printf("Error signing in %s: %v", req.Username, err)
+		apierrors.Write(w, r, err)
+		return
+	}
+	id, err := idToken(r, user)
+	if err != nil {
+		log.Printf("Error signing the ID token for %s: %v", req.Username, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error signing in")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"access_token": user.AccessToken,
-		"id_token":     user.AccessToken, // For simplicity, we're using the same token
+		// A signed JWT when JWT_SIGNING is set, the access token otherwise
+		"id_token": id,
 	})
 }
 
 // uploadFileHandler handles file uploads to S3
 func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
+	// Uploads made with a valid token are owned by that user. Those without
+	// one are only accepted in anonymous upload mode, under stricter limits.
+	s := settings.Current()
+	userID := auth.UserIDFromContext(r.Context())
+	anonymous := userID == ""
+	if anonymous && !admitAnonymousUpload(w, r, s) {
+		return
+	}
+	defer throttleUpload(r, userID)()
+
+	// The content is spooled rather than decoded into a string, and the
+	// per-file limit is enforced while it is read
+	maxFileBytes := anonymousMaxFileBytes(s)
+	if !anonymous {
+		var ok bool
+		if maxFileBytes, ok = tenantMaxFileBytes(w, r, userID); !ok {
+			return
+		}
+	}
+	content := newSpool(maxFileBytes)
+	defer content.Close()
+
 	var fileData FileData
-	if err := json.NewDecoder(r.Body).Decode(&fileData); err != nil {
+	if err := readUpload(r, &fileData, content); err != nil {
 		log.Printf("Error decoding request body: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		switch {
+		case errors.Is(err, errUploadTooLarge):
+			apierrors.Respond(w, r, apierrors.CodeQuotaExceeded, fmt.Sprintf("File exceeds the %d byte limit", maxFileBytes))
+		case content.Failed():
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error reading upload")
+		default:
+			apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		}
 		return
 	}
 
 	// Generate unique ID if not provided
 	if fileData.ID == "" {
-		fileData.ID = uuid.New().String()
+		fileData.ID = database.NewID()
 	}
 
 	fileData.CreatedAt = time.Now()
 
-	// Save file metadata to database
-	s3Key := fmt.Sprintf("files/%s/%s", fileData.ID, fileData.Name)
-	log.Printf("Saving file metadata to database: id=%s, name=%s, s3_key=%s", fileData.ID, fileData.Name, s3Key)
-	_, err := database.GetDB().Exec(
-		"INSERT INTO files (id, name, s3_key, created_at) VALUES ($1, $2, $3, $4)",
-		fileData.ID, fileData.Name, s3Key, fileData.CreatedAt,
-	)
+	// Work out when processing should run if the client deferred it
+	if fileData.ProcessDelaySeconds < 0 {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "process_delay_seconds must not be negative")
+		return
+	}
+	var processAt time.Time
+	if fileData.ProcessAt != nil {
+		processAt = *fileData.ProcessAt
+	} else if fileData.ProcessDelaySeconds > 0 {
+		processAt = fileData.CreatedAt.Add(time.Duration(fileData.ProcessDelaySeconds) * time.Second)
+	}
+	deferred := processAt.After(fileData.CreatedAt)
+	dependsOn, ok := fileDependencies(w, r, userID, fileData.DependsOn)
+	if !ok {
+		return
+	}
+	// A file with dependencies is held as a job until they complete
+	if !deferred && len(dependsOn) > 0 {
+		processAt = fileData.CreatedAt
+	}
+
+	if anonymous {
+		if !s.AnonymousTypeAllowed(fileData.Name) {
+			apierrors.Respond(w, r, apierrors.CodeUnsupportedFormat, "Anonymous uploads must be one of "+strings.Join(s.AnonymousUploadTypes, ", "))
+			return
+		}
+		userID = database.AnonymousUserID
+	}
+
+	if err := quota.Check(userID, fileData.Name, content.Size()); err != nil {
+		log.Printf("Upload of %s rejected: %v", fileData.Name, err)
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	if fileData.ExpandArchive && processor.ArchiveFormat(fileData.Name) == "" {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "expand_archive needs a .zip, .tar, .tar.gz or .tgz file")
+		return
+	}
+
+	policy := fileData.OnDuplicate
+	if policy == "" {
+		policy = database.NamePolicy()
+	}
+	if !database.ValidNamePolicy(policy) {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "on_duplicate must be reject, rename or version")
+		return
+	}
+
+	class, ok := storageClass(w, r, userID, fileData.StorageClass)
+	if !ok {
+		return
+	}
+	fileData.StorageClass = class
+	lock := s.Lock(userID, fileData.CreatedAt)
+
+	keyPrefix, ok := fileKeyPrefix(w, r, fileData.ID, fileData.CollectionID, userID)
+	if !ok {
+		return
+	}
+
+	pending, ok := redactionPending(w, r, userID, fileData.Name)
+	if !ok {
+		return
+	}
+
+	plain, err := content.Reader()
+	if err != nil {
+		log.Printf("Error reading spooled upload: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error reading upload")
+		return
+	}
+	// The type is sniffed from the plain content; the name only refines it
+	contentType, err := contenttype.DetectReader(fileData.Name, plain)
+	if err != nil {
+		log.Printf("Error reading spooled upload: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error reading upload")
+		return
+	}
+	body, size, encryption, err := sealContent(r.Context(), userID, fileData.ID, plain, content.Size())
+	if err != nil {
+		log.Printf("Error encrypting %s: %v", fileData.Name, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error encrypting file")
+		return
+	}
+
+	// Save file metadata to database. The scheduled job is recorded in the same
+	// transaction, before the object lands in S3, so the worker skips the
+	// immediate S3 notification. The name policy may change the file's name,
+	// so the S3 key is only known once the row is saved.
+	log.Printf("Saving file metadata to database: id=%s, name=%s, policy=%s", fileData.ID, fileData.Name, policy)
+	var s3Key string
+	var scheduledJob *database.ScheduledJob
+	err = database.WithTx(func(tx *sql.Tx) error {
+		if err := quota.CheckTx(tx, userID, fileData.Name, content.Size()); err != nil {
+			return err
+		}
+		f, err := database.CreateFileTx(tx, database.NewFile{
+			ID:               fileData.ID,
+			Name:             fileData.Name,
+			UserID:           userID,
+			CollectionID:     fileData.CollectionID,
+			SizeBytes:        content.Size(),
+			StorageClass:     class,
+			RetentionMode:    lock.Mode,
+			RetainUntil:      lock.RetainUntil,
+			Encryption:       encryption,
+			S3Key:            func(name string) string { return keyPrefix + "/" + name },
+			ExpandArchive:    fileData.ExpandArchive,
+			ContentType:      contentType,
+			RedactionPending: pending,
+		}, policy)
+		if err != nil {
+			return fmt.Errorf("error saving file metadata: %w", err)
+		}
+		fileData.Name, s3Key = f.Name, f.S3Key
+		if !deferred && len(dependsOn) == 0 {
+			return nil
+		}
+
+		log.Printf("Scheduling processing: file_id=%s, run_at=%s, depends_on=%v", fileData.ID, processAt, dependsOn)
+		job, err := database.SaveScheduledJobTx(tx, fileData.ID, s3Key, processAt, database.ScheduledJobPending)
+		if err != nil {
+			return fmt.Errorf("error saving scheduled job: %v", err)
+		}
+		scheduledJob = job
+		if len(dependsOn) == 0 {
+			return nil
+		}
+		if err := database.SaveFileDependenciesTx(tx, fileData.ID, dependsOn); err != nil {
+			return fmt.Errorf("error saving dependencies: %v", err)
+		}
+		return nil
+	})
+	var apiErr *apierrors.Error
+	if errors.As(err, &apiErr) || errors.Is(err, database.ErrDuplicateFileName) {
+		apierrors.Write(w, r, err)
+		return
+	}
 	if err != nil {
 		log.Printf("Error saving to database: %v", err)
-		http.Error(w, "Error saving file metadata", http.StatusInternalServerError)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error saving file metadata")
 		return
 	}
 
 	// Upload content to S3
 	log.Printf("Uploading to S3: bucket=%s, key=%s", bucketName, s3Key)
-	_, err = s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(s3Key),
-		Body:   strings.NewReader(fileData.Content),
-	})
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(bucketName),
+		Key:           aws.String(s3Key),
+		Body:          body,
+		ContentLength: size,
+		ContentType:   aws.String(contentType),
+		StorageClass:  types.StorageClass(class),
+		Metadata:      encryption.Metadata(),
+	}
+	lock.Apply(input)
+	etag, err := uploadObject(context.TODO(), fileData.ID, input)
 	if err != nil {
 		log.Printf("Error uploading to S3: %v", err)
-		http.Error(w, "Error uploading file", http.StatusInternalServerError)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error uploading file")
 		return
 	}
 	log.Printf("Successfully uploaded to S3")
 
+	if scheduledJob != nil {
+		// Jobs with dependencies are left to the scheduler, which holds them
+		// until the dependencies complete
+		message := "File uploaded successfully and processing scheduled"
+		if len(dependsOn) > 0 {
+			message = "File uploaded successfully; processing waits for its dependencies"
+		} else if err := scheduleProcessing(r.Context(), scheduledJob); err != nil {
+			log.Printf("Error publishing scheduled job: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error scheduling processing")
+			return
+		}
+
+		resp := map[string]interface{}{
+			"id":            fileData.ID,
+			"name":          fileData.Name,
+			"storage_class": class,
+			"content_type":  contentType,
+			"status":        "uploaded",
+			"process_at":    scheduledJob.RunAt.Format(time.RFC3339),
+			"message":       message,
+		}
+		if len(dependsOn) > 0 {
+			resp["depends_on"] = dependsOn
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// S3 event notifications can't target FIFO queues, so publish the event ourselves
+	if queue.IsFIFO() {
+		log.Printf("Publishing processing event to FIFO queue: file_id=%s", fileData.ID)
+		if err := queue.PublishFileEvent(r.Context(), fileData.ID, bucketName, s3Key, etag); err != nil {
+			log.Printf("Error publishing processing event: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error queuing file for processing")
+			return
+		}
+	}
+
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{
-		"id":      fileData.ID,
-		"status":  "uploaded",
-		"message": "File uploaded successfully and processing started",
+		"id":            fileData.ID,
+		"name":          fileData.Name,
+		"storage_class": class,
+		"content_type":  contentType,
+		"status":        "uploaded",
+		"message":       "File uploaded successfully and processing started",
 	})
 }
 
+// fileKeyPrefix returns the S3 key prefix for a new file. Files in a
+// collection are stored under the collection's path, and the collection must
+// belong to userID; otherwise an error response is written and false returned.
+func fileKeyPrefix(w http.ResponseWriter, r *http.Request, fileID, collectionID, userID string) (string, bool) {
+	keyPrefix := fmt.Sprintf("files/%s", fileID)
+	if collectionID == "" {
+		return keyPrefix, true
+	}
+
+	c, err := database.GetCollectionByID(collectionID)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving collection")
+		return "", false
+	}
+	if c == nil || userID == "" || c.UserID != userID {
+		apierrors.Respond(w, r, apierrors.CodeCollectionNotFound, "Collection not found")
+		return "", false
+	}
+	collectionPath, err := database.CollectionPath(c.ID)
+	if err != nil {
+		log.Printf("Error resolving collection path: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving collection")
+		return "", false
+	}
+	return keyPrefix + "/" + collectionPath, true
+}
+
 // getFileHandler retrieves file information
 func getFileHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	fileID := vars["id"]
 
-	var fileData FileData
-	var s3Key string
+	f := authorizeFile(w, r, fileID)
+	if f == nil {
+		return
+	}
 
-	err := database.GetDB().QueryRow(
-		"SELECT id, name, s3_key, created_at FROM files WHERE id = $1",
-		fileID,
-	).Scan(&fileData.ID, &fileData.Name, &s3Key, &fileData.CreatedAt)
+	// Get file content from S3 only when asked for with ?include=content
+	includeContent, ok := includedParts(w, r)
+	if !ok {
+		return
+	}
+	redacted, pending := f.Redacted(), f.RedactionPending
+	// A file waiting for redaction can still be described without content
+	variant := database.VariantOriginal
+	wantContent := includeContent && fieldSelected(r, "content")
+	if wantContent || !pending {
+		if f, variant = servedFile(w, r, f); f == nil {
+			return
+		}
+	}
+	fileData := FileData{
+		ID:               f.ID,
+		Name:             f.Name,
+		CreatedAt:        f.CreatedAt,
+		UpdatedAt:        f.UpdatedAt,
+		Version:          f.Version,
+		StorageClass:     f.StorageClass,
+		LegalHold:        f.LegalHold,
+		SizeBytes:        f.SizeBytes,
+		ContentType:      f.ContentType,
+		Redacted:         redacted,
+		RedactionPending: pending,
+	}
+	if wantContent {
+		result, err := getFileObject(r.Context(), f)
+		if err != nil {
+			log.Printf("Error retrieving from S3: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file content")
+			return
+		}
+		defer result.Body.Close()
 
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "File not found", http.StatusNotFound)
-		} else {
-			log.Printf("Database query error: %v", err)
-			http.Error(w, "Error retrieving file", http.StatusInternalServerError)
+		// Read content, decrypting it if it was encrypted before upload
+		content, _, err := objectContent(r.Context(), f.ID, f.Encryption, result)
+		if err != nil {
+			log.Printf("Error decrypting file %s: %v", f.ID, err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error reading file content")
+			return
 		}
-		return
+		b, err := io.ReadAll(content)
+		if err != nil {
+			log.Printf("Error reading S3 content: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error reading file content")
+			return
+		}
+		fileData.Content = string(b)
 	}
+	fileData.Retention = newRetentionInfo(f.RetentionMode, f.RetainUntil)
+	fileData.Encryption = newEncryptionInfo(f.Encryption)
+	recordAccess(r, f.ID, database.AccessView, variant)
 
-	// Get file content from S3
-	result, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
+	// Return file data
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fileData)
+}
+
+// includedParts parses ?include=, which lists optional parts of a file to
+// return. Only content can be included so far.
+func includedParts(w http.ResponseWriter, r *http.Request) (content bool, ok bool) {
+	v := r.URL.Query().Get("include")
+	if v == "" {
+		return false, true
+	}
+	for _, part := range strings.Split(v, ",") {
+		if strings.TrimSpace(part) != "content" {
+			apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "include may only list content")
+			return false, false
+		}
+	}
+	return true, true
+}
+
+// headFileHandler answers HEAD /files/{id} with a file's size, ETag and
+// modification time as headers, without reading its content. They describe
+// the content the caller would be served, which may be the redacted copy.
+func headFileHandler(w http.ResponseWriter, r *http.Request) {
+	f := authorizeFile(w, r, mux.Vars(r)["id"])
+	if f == nil {
+		return
+	}
+	f, _ = servedFile(w, r, f)
+	if f == nil {
+		return
+	}
+	head, err := s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
 		Bucket: aws.String(bucketName),
-		Key:    aws.String(s3Key),
+		Key:    aws.String(f.S3Key),
 	})
 	if err != nil {
-		log.Printf("Error retrieving from S3: %v", err)
-		http.Error(w, "Error retrieving file content", http.StatusInternalServerError)
+		log.Printf("Error reading metadata of file %s from S3: %v", f.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file content")
 		return
 	}
-	defer result.Body.Close()
 
-	// Read content
-	content, err := io.ReadAll(result.Body)
-	if err != nil {
-		log.Printf("Error reading S3 content: %v", err)
-		http.Error(w, "Error reading file content", http.StatusInternalServerError)
-		return
+	w.Header().Set("X-File-Size", strconv.FormatInt(f.SizeBytes, 10))
+	if head.ETag != nil {
+		w.Header().Set("ETag", *head.ETag)
 	}
-	fileData.Content = string(content)
-
-	// Return file data
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(fileData)
+	w.Header().Set("Last-Modified", f.UpdatedAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
 }
 
-// getResultHandler retrieves processing results
+// getResultHandler retrieves processing results. With ?wait=30s a file that
+// has no result yet holds the request until one arrives or the wait is over,
+// so clients needn't poll in a tight loop.
 func getResultHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	fileID := vars["id"]
 
-	var result ProcessingResult
-
-	err := database.GetDB().QueryRow(
-		"SELECT id, status, result, created_at FROM processing_results WHERE file_id = $1",
-		fileID,
-	).Scan(&result.ID, &result.Status, &result.Result, &result.CreatedAt)
+	wait, ok := resultWait(w, r)
+	if !ok {
+		return
+	}
+	if authorizeFile(w, r, fileID) == nil {
+		return
+	}
+	// Subscribed before the first lookup so a result saved in between
+	// isn't missed
+	var updates <-chan resultNotice
+	if wait > 0 {
+		var cancel func()
+		updates, cancel = results.subscribe(fileTopic(fileID))
+		defer cancel()
+	}
 
-	if err != nil {
-		if err == sql.ErrNoRows {
-			// Check if file exists first
-			var exists bool
-			err = database.GetDB().QueryRow("SELECT EXISTS(SELECT 1 FROM files WHERE id = $1)", fileID).Scan(&exists)
-			if err != nil || !exists {
-				http.Error(w, "File not found", http.StatusNotFound)
-				return
-			}
-
-			// File exists but processing not complete
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]string{
-				"status":  "processing",
-				"message": "Processing not complete or not started",
-			})
-			return
-		} else {
-			log.Printf("Database query error: %v", err)
-			http.Error(w, "Error retrieving processing result", http.StatusInternalServerError)
-			return
+	pr, err := database.GetProcessingResultByFileID(fileID)
+	// An expanding archive is waited on until its members are processed too
+	if err == nil && wait > 0 && (pr == nil || pr.Status == database.StatusExpanding) {
+		waited, waitErr := waitForResult(r.Context(), updates, wait, func() (*database.ProcessingResult, error) {
+			return database.GetProcessingResultByFileID(fileID)
+		})
+		if waited != nil || waitErr != nil {
+			pr, err = waited, waitErr
 		}
 	}
+	if err == nil && pr != nil {
+		err = loadOffloadedResult(r.Context(), pr)
+	}
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving processing result")
+		return
+	}
+
+	if pr == nil {
+		// File exists but processing not complete
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "processing",
+			"message": "Processing not complete or not started",
+		})
+		return
+	}
 
 	// Return processing result
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(ProcessingResult{
+		ID:         pr.ID,
+		Status:     pr.Status,
+		Result:     pr.Result,
+		CreatedAt:  pr.CreatedAt,
+		UpdatedAt:  pr.UpdatedAt,
+		Version:    pr.Version,
+		ReusedFrom: pr.ReusedFrom,
+	})
 }
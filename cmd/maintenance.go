@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// maintenanceCacheTTL is how long the maintenance state is cached between
+// database reads. Other API instances pick up a toggle within this time.
+const maintenanceCacheTTL = 5 * time.Second
+
+// defaultMaintenanceRetryAfter is sent when maintenance was enabled without a
+// retry hint
+const defaultMaintenanceRetryAfter = 60 * time.Second
+
+// maintenanceExempt lists the write endpoints that stay available so an
+// administrator can sign in and turn maintenance mode off again
+var maintenanceExempt = map[string]bool{
+	"/api/auth/signin":       true,
+	"/api/admin/maintenance": true,
+}
+
+// eventSourceMappings is the part of the Lambda API used to pause the worker
+type eventSourceMappings interface {
+	UpdateEventSourceMapping(ctx context.Context, params *lambda.UpdateEventSourceMappingInput, optFns ...func(*lambda.Options)) (*lambda.UpdateEventSourceMappingOutput, error)
+}
+
+// workerMapping is the worker Lambda's event source mapping, from
+// WORKER_EVENT_SOURCE_MAPPING, and workerMappings the client to update it
+// with. Maintenance mode disables the mapping so the worker stops receiving
+// messages; receiving and failing them would count towards the queue's
+// maxReceiveCount and move them to the dead-letter queue.
+var (
+	workerMappings eventSourceMappings
+	workerMapping  string
+)
+
+// pauseWorker disables or re-enables the worker's event source mapping. It
+// does nothing without WORKER_EVENT_SOURCE_MAPPING, as when the worker polls
+// the queue itself and checks maintenance mode between polls.
+func pauseWorker(ctx context.Context, paused bool) error {
+	if workerMapping == "" {
+		return nil
+	}
+	_, err := workerMappings.UpdateEventSourceMapping(ctx, &lambda.UpdateEventSourceMappingInput{
+		UUID:    aws.String(workerMapping),
+		Enabled: aws.Bool(!paused),
+	})
+	return err
+}
+
+// maintenanceCache caches the maintenance state for maintenanceCacheTTL
+type maintenanceCache struct {
+	mu      sync.Mutex
+	state   database.Maintenance
+	fetched time.Time
+}
+
+var maintenance maintenanceCache
+
+// get returns the cached state, refreshing it once it is stale. If the
+// database can't be reached the last known state is kept.
+func (c *maintenanceCache) get() database.Maintenance {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.fetched) < maintenanceCacheTTL {
+		return c.state
+	}
+	m, err := database.GetMaintenance()
+	if err != nil {
+		log.Printf("Error reading maintenance state: %v", err)
+		return c.state
+	}
+	c.set(*m)
+	return c.state
+}
+
+func (c *maintenanceCache) set(m database.Maintenance) {
+	c.state = m
+	c.fetched = time.Now()
+}
+
+// maintenanceMiddleware rejects writes with 503 Service Unavailable while
+// maintenance mode is on. Reads keep working.
+func maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if maintenanceExempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		m := maintenance.get()
+		if !m.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		retryAfter := m.RetryAfter
+		if retryAfter <= 0 {
+			retryAfter = defaultMaintenanceRetryAfter
+		}
+		detail := m.Message
+		if detail == "" {
+			detail = "The service is down for maintenance, retry later"
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+		apierrors.Respond(w, r, apierrors.CodeMaintenance, detail)
+	})
+}
+
+// maintenanceResponse is the JSON form of the maintenance state
+func maintenanceResponse(m database.Maintenance) map[string]interface{} {
+	return map[string]interface{}{
+		"enabled":             m.Enabled,
+		"message":             m.Message,
+		"retry_after_seconds": int(m.RetryAfter / time.Second),
+		"updated_by":          m.UpdatedBy,
+		"updated_at":          m.UpdatedAt,
+	}
+}
+
+// getMaintenanceHandler returns the maintenance state. Only administrators can
+// see it.
+func getMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	m, err := database.GetMaintenance()
+	if err != nil {
+		log.Printf("Error reading maintenance state: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error reading maintenance state")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(maintenanceResponse(*m))
+}
+
+// setMaintenanceHandler turns maintenance mode on or off. Only administrators
+// can toggle it.
+func setMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	var req struct {
+		Enabled           bool   `json:"enabled"`
+		Message           string `json:"message"`
+		RetryAfterSeconds int    `json:"retry_after_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.RetryAfterSeconds < 0 {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "retry_after_seconds must not be negative")
+		return
+	}
+
+	m, err := database.SetMaintenance(req.Enabled, req.Message, time.Duration(req.RetryAfterSeconds)*time.Second, p.Username)
+	if err != nil {
+		log.Printf("Error updating maintenance state: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error updating maintenance state")
+		return
+	}
+	maintenance.mu.Lock()
+	maintenance.set(*m)
+	maintenance.mu.Unlock()
+	log.Printf("Maintenance mode set to %t by %s", m.Enabled, p.Username)
+
+	// Setting the same state again retries a failed pause
+	if err := pauseWorker(r.Context(), m.Enabled); err != nil {
+		log.Printf("Error updating the worker's event source mapping: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Maintenance mode was updated but the worker couldn't be paused or resumed, retry")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(maintenanceResponse(*m))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func TestMaintenanceMiddlewareBlocksWrites(t *testing.T) {
+	maintenance.set(database.Maintenance{Enabled: true, RetryAfter: 2 * time.Minute})
+	t.Cleanup(func() { maintenance.set(database.Maintenance{}) })
+
+	handler := maintenanceMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := serve("POST", "/api/files")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "120", w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, serve("GET", "/api/files").Code)
+	assert.Equal(t, http.StatusOK, serve("PUT", "/api/admin/maintenance").Code)
+	assert.Equal(t, http.StatusOK, serve("POST", "/api/auth/signin").Code)
+}
+
+// fakeMappings records the event source mapping updates it is asked for
+type fakeMappings struct {
+	updates []lambda.UpdateEventSourceMappingInput
+}
+
+func (f *fakeMappings) UpdateEventSourceMapping(_ context.Context, params *lambda.UpdateEventSourceMappingInput, _ ...func(*lambda.Options)) (*lambda.UpdateEventSourceMappingOutput, error) {
+	f.updates = append(f.updates, *params)
+	return &lambda.UpdateEventSourceMappingOutput{}, nil
+}
+
+func TestPauseWorker(t *testing.T) {
+	fake := &fakeMappings{}
+	prevMappings, prevMapping := workerMappings, workerMapping
+	workerMappings, workerMapping = fake, ""
+	t.Cleanup(func() { workerMappings, workerMapping = prevMappings, prevMapping })
+
+	// A worker that polls the queue itself has no mapping to disable
+	assert.NoError(t, pauseWorker(context.Background(), true))
+	assert.Empty(t, fake.updates)
+
+	workerMapping = "mapping-uuid"
+	assert.NoError(t, pauseWorker(context.Background(), true))
+	assert.NoError(t, pauseWorker(context.Background(), false))
+	if assert.Len(t, fake.updates, 2) {
+		assert.Equal(t, "mapping-uuid", aws.ToString(fake.updates[0].UUID))
+		assert.False(t, aws.ToBool(fake.updates[0].Enabled))
+		assert.True(t, aws.ToBool(fake.updates[1].Enabled))
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// usageExportBatchSize is how many usage records are sent to the metering
+// sink at a time
+const usageExportBatchSize = 500
+
+// usageMetrics are the metrics reported by the usage endpoint, which reports
+// zero for those without records
+var usageMetrics = []string{database.MeterBytesUploaded, database.MeterProcessingMillis, database.MeterDownloads}
+
+// usageDay is one day of usage in API responses
+type usageDay struct {
+	Date  string           `json:"date"`
+	Usage map[string]int64 `json:"usage"`
+}
+
+// usageHandler reports the caller's metered usage in a calendar month, the
+// current one unless ?month=YYYY-MM asks for another, in total and per day
+func usageHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	now := time.Now().UTC()
+	since := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if month := r.URL.Query().Get("month"); month != "" {
+		t, err := time.Parse("2006-01", month)
+		if err != nil {
+			apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "month must look like 2024-05")
+			return
+		}
+		since = t
+	}
+	until := since.AddDate(0, 1, 0)
+
+	days, err := database.GetUsageByDay(userID, since, until)
+	if err != nil {
+		log.Printf("Error loading usage of user %s: %v", userID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading usage")
+		return
+	}
+
+	totals := make(map[string]int64, len(usageMetrics))
+	for _, m := range usageMetrics {
+		totals[m] = 0
+	}
+	items := []usageDay{}
+	for _, d := range days {
+		date := d.Day.Format("2006-01-02")
+		if len(items) == 0 || items[len(items)-1].Date != date {
+			items = append(items, usageDay{Date: date, Usage: map[string]int64{}})
+		}
+		items[len(items)-1].Usage[d.Metric] = d.Quantity
+		totals[d.Metric] += d.Quantity
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"month":  since.Format("2006-01"),
+		"totals": totals,
+		"days":   items,
+	})
+}
+
+// exportUsage sends the usage records not yet exported to the metering sink,
+// if one is configured. Records that fail to send are retried on the next
+// tick.
+func exportUsage(ctx context.Context) {
+	if meteringSink == nil {
+		return
+	}
+	for {
+		records, err := database.ListUnexportedUsage(usageExportBatchSize)
+		if err != nil {
+			log.Printf("Error listing usage records to export: %v", err)
+			return
+		}
+		if len(records) == 0 {
+			return
+		}
+		if err := meteringSink.Send(ctx, records); err != nil {
+			log.Printf("Error exporting %d usage records: %v", len(records), err)
+			return
+		}
+		ids := make([]string, len(records))
+		for i, rec := range records {
+			ids[i] = rec.ID
+		}
+		if err := database.MarkUsageExported(ids); err != nil {
+			log.Printf("Error marking %d usage records exported: %v", len(records), err)
+			return
+		}
+		if len(records) < usageExportBatchSize {
+			return
+		}
+	}
+}
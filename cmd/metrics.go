@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram buckets
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts observations into latencyBuckets; counts are cumulative
+// only when written out
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// routeKey identifies a histogram
+type routeKey struct {
+	method string
+	route  string
+}
+
+// latencyHistograms keeps a latency histogram per method and route template
+type latencyHistograms struct {
+	mu     sync.Mutex
+	routes map[routeKey]*histogram
+}
+
+var requestLatency = &latencyHistograms{routes: make(map[routeKey]*histogram)}
+
+func (l *latencyHistograms) observe(method, route string, d time.Duration) {
+	seconds := d.Seconds()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := routeKey{method: method, route: route}
+	h, ok := l.routes[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		l.routes[key] = h
+	}
+	if i := sort.SearchFloat64s(latencyBuckets, seconds); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// writeTo writes the histograms in the Prometheus text exposition format
+func (l *latencyHistograms) writeTo(w *strings.Builder) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	keys := make([]routeKey, 0, len(l.routes))
+	for key := range l.routes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	w.WriteString("# HELP http_request_duration_seconds Request latency by method and route.\n")
+	w.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	for _, key := range keys {
+		h := l.routes[key]
+		labels := fmt.Sprintf("method=%q,route=%q", key.method, key.route)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=%q} %d\n", labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "http_request_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(w, "http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+}
+
+// metricsHandler serves the request latency histograms and the processing
+// queue's depth for Prometheus
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	requestLatency.writeTo(&b)
+	queueDepth.writeTo(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(b.String()))
+}
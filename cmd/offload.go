@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// loadOffloadedResult replaces the summary of a result that was too large for
+// its row with the whole result from S3, so clients see no difference
+func loadOffloadedResult(ctx context.Context, pr *database.ProcessingResult) error {
+	if pr.ResultKey == "" {
+		return nil
+	}
+	result, err := s3Client.GetResult(ctx, pr.ResultKey)
+	if err != nil {
+		return fmt.Errorf("error reading offloaded result %s: %v", pr.ResultKey, err)
+	}
+	pr.Result = result
+	return nil
+}
+
+// purgeResultObjects deletes offloaded results whose rows are gone
+func purgeResultObjects(ctx context.Context) {
+	keys, err := database.ClaimResultObjectDeletions(schedulerBatchSize)
+	if err != nil {
+		log.Printf("Error claiming offloaded results to delete: %v", err)
+		return
+	}
+	deleted := 0
+	for _, key := range keys {
+		if fileRemover.DeleteObject(ctx, key) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d offloaded results", deleted)
+	}
+}
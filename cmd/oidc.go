@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+)
+
+// oidcStateCookie ties a login callback to the browser that started the
+// login, so a callback URL can't be replayed in someone else's browser
+const oidcStateCookie = "oidc_state"
+
+// oidcProvidersHandler lists the external providers users can log in with
+func oidcProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"providers": auth.OIDCProviderNames(),
+	})
+}
+
+// oidcProvider returns the provider named in the path, writing a 404
+// response and returning nil if it isn't configured
+func oidcProvider(w http.ResponseWriter, r *http.Request) *auth.OIDCProvider {
+	p := auth.OIDCProviderByName(mux.Vars(r)["provider"])
+	if p == nil {
+		apierrors.Respond(w, r, apierrors.CodeNotFound, "Unknown login provider")
+	}
+	return p
+}
+
+// oidcLoginHandler redirects the browser to the provider's login page
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	p := oidcProvider(w, r)
+	if p == nil {
+		return
+	}
+	authURL, state, err := p.StartLogin()
+	if err != nil {
+		log.Printf("Error starting %s login: %v", p.Name, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error starting login")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc/",
+		MaxAge:   int(auth.OAuthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallbackHandler completes a login when the provider redirects back, and
+// returns an access token for the linked local user
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	p := oidcProvider(w, r)
+	if p == nil {
+		return
+	}
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		apierrors.Respond(w, r, apierrors.CodeLoginFailed, "The provider refused the login: "+e)
+		return
+	}
+	state, code := q.Get("state"), q.Get("code")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if state == "" || code == "" || err != nil || cookie.Value != state {
+		apierrors.Respond(w, r, apierrors.CodeLoginFailed, "Login state is missing or doesn't match, start again")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc/", MaxAge: -1})
+
+	user, err := p.FinishLogin(r.Context(), code, state)
+	if err != nil {
+		log.Printf("Error completing %s login: %v", p.Name, err)
+		apierrors.Write(w, r, err)
+		return
+	}
+	id, err := idToken(r, user)
+	if err != nil {
+		log.Printf("Error signing the ID token for %s: %v", user.Username, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error completing login")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": user.AccessToken,
+		"id_token":     id,
+		"user_id":      user.ID,
+		"username":     user.Username,
+	})
+}
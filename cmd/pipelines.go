@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/pagination"
+	"github.com/yourusername/golang-aws-api/processor"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+// pipelineResponse is the JSON form of a user's pipeline
+type pipelineResponse struct {
+	FileType  string    `json:"file_type"`
+	Stages    []string  `json:"stages"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newPipelineResponse(p database.Pipeline) pipelineResponse {
+	return pipelineResponse{FileType: p.FileType, Stages: p.Stages, UpdatedAt: p.UpdatedAt}
+}
+
+// pipelineFileType reads the file type from the URL: an extension such as
+// ".csv", matched without regard to case, or "*" for every other type
+func pipelineFileType(w http.ResponseWriter, r *http.Request) (string, bool) {
+	fileType := strings.ToLower(mux.Vars(r)["type"])
+	if fileType == processor.DefaultFileType {
+		return fileType, true
+	}
+	if len(fileType) < 2 || fileType[0] != '.' || strings.ContainsAny(fileType[1:], "./\\") {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, `file type must be an extension such as ".csv", or "*"`)
+		return "", false
+	}
+	return fileType, true
+}
+
+// setPipelineHandler sets the processors the caller's files of one type run
+// through, in order
+func setPipelineHandler(w http.ResponseWriter, r *http.Request) {
+	fileType, ok := pipelineFileType(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Stages []string `json:"stages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if err := settings.ValidatePipeline(req.Stages); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "stages: "+err.Error())
+		return
+	}
+
+	userID := auth.UserIDFromContext(r.Context())
+	p, err := database.SaveUserPipeline(userID, fileType, req.Stages)
+	if err != nil {
+		log.Printf("Error saving pipeline: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error saving pipeline")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newPipelineResponse(*p))
+}
+
+// listPipelinesHandler lists the caller's pipelines
+func listPipelinesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	pipelines, err := database.ListUserPipelines(userID)
+	if err != nil {
+		log.Printf("Error listing pipelines: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing pipelines")
+		return
+	}
+
+	items := make([]pipelineResponse, 0, len(pipelines))
+	for _, p := range pipelines {
+		items = append(items, newPipelineResponse(p))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pipelines": items,
+	})
+}
+
+// deletePipelineHandler removes one of the caller's pipelines, so files of
+// that type go back to the configured pipeline
+func deletePipelineHandler(w http.ResponseWriter, r *http.Request) {
+	fileType, ok := pipelineFileType(w, r)
+	if !ok {
+		return
+	}
+	userID := auth.UserIDFromContext(r.Context())
+
+	deleted, err := database.DeleteUserPipeline(userID, fileType)
+	if err != nil {
+		log.Printf("Error deleting pipeline: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error deleting pipeline")
+		return
+	}
+	if !deleted {
+		apierrors.Respond(w, r, apierrors.CodePipelineNotFound, "Pipeline not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// timelineHandler lists a file's events oldest first: its upload, each
+// pipeline stage run and its results
+func timelineHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["id"]
+	params, ok := paginationParams(w, r)
+	if !ok {
+		return
+	}
+	if authorizeFile(w, r, fileID) == nil {
+		return
+	}
+
+	events, err := database.ListEvents(database.EventFilter{FileID: fileID}, params.After, params.Limit+1)
+	if err != nil {
+		log.Printf("Error listing events: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing events")
+		return
+	}
+	events, next := pagination.Trim(events, params.Limit, func(e database.Event) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: e.CreatedAt, ID: e.ID}
+	})
+	if events == nil {
+		events = []database.Event{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events":      events,
+		"next_cursor": next,
+	})
+}
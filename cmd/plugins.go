@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/wasm"
+)
+
+// pluginResponse is the JSON form of a plugin
+type pluginResponse struct {
+	Name      string    `json:"name"`
+	Processor string    `json:"processor"`
+	SHA256    string    `json:"sha256"`
+	SizeBytes int64     `json:"size_bytes"`
+	UpdatedBy string    `json:"updated_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newPluginResponse(p database.WasmPlugin) pluginResponse {
+	return pluginResponse{
+		Name:      p.Name,
+		Processor: wasm.Prefix + p.Name,
+		SHA256:    p.SHA256,
+		SizeBytes: p.SizeBytes,
+		UpdatedBy: p.UpdatedBy,
+		CreatedAt: p.CreatedAt,
+		UpdatedAt: p.UpdatedAt,
+	}
+}
+
+// uploadPluginHandler stores a WebAssembly module, sent as the request body,
+// as the plugin processor "wasm:<name>". Workers pick it up within
+// WASM_REFRESH_INTERVAL. Only administrators can upload plugins.
+func uploadPluginHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if !wasm.ValidName(name) {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "name must be lower-case letters, digits, _ and -")
+		return
+	}
+
+	module, err := io.ReadAll(http.MaxBytesReader(w, r.Body, wasm.MaxModuleBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Module is larger than 16 MB")
+			return
+		}
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if err := wasm.Validate(r.Context(), module); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid plugin: "+err.Error())
+		return
+	}
+
+	sum := sha256.Sum256(module)
+	plugin, err := database.SaveWasmPlugin(name, module, hex.EncodeToString(sum[:]), p.Username)
+	if err != nil {
+		log.Printf("Error saving plugin: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error saving plugin")
+		return
+	}
+	log.Printf("Plugin %s uploaded by %s", name, p.Username)
+	// Register it here straight away, so pipelines can name it
+	if err := wasm.Refresh(r.Context()); err != nil {
+		log.Printf("Error refreshing plugins: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newPluginResponse(*plugin))
+}
+
+// listPluginsHandler lists the uploaded plugins. Only administrators can see
+// them.
+func listPluginsHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	plugins, err := database.ListWasmPlugins()
+	if err != nil {
+		log.Printf("Error listing plugins: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing plugins")
+		return
+	}
+
+	items := make([]pluginResponse, 0, len(plugins))
+	for _, p := range plugins {
+		items = append(items, newPluginResponse(p))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"plugins": items,
+	})
+}
+
+// deletePluginHandler removes a plugin. Pipelines naming it fail until they
+// are changed. Only administrators can delete plugins.
+func deletePluginHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	deleted, err := database.DeleteWasmPlugin(name)
+	if err != nil {
+		log.Printf("Error deleting plugin: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error deleting plugin")
+		return
+	}
+	if !deleted {
+		apierrors.Respond(w, r, apierrors.CodePluginNotFound, "Plugin not found")
+		return
+	}
+	log.Printf("Plugin %s deleted by %s", name, p.Username)
+	if err := wasm.Refresh(r.Context()); err != nil {
+		log.Printf("Error refreshing plugins: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/preview"
+)
+
+// Preview size limits: the default and largest text preview in KB, and the
+// default, smallest and largest thumbnail side in pixels
+const (
+	defaultPreviewKB   = 16
+	maxPreviewKB       = 256
+	defaultPreviewSize = 256
+	minPreviewSize     = 16
+	maxPreviewSize     = 1024
+)
+
+// maxPreviewSourceBytes caps the files read in full for a preview, which
+// images, PDFs and encrypted files are
+const maxPreviewSourceBytes = 20 << 20
+
+// previewCacheBytes is how much rendered previews the cache keeps
+const previewCacheBytes = 64 << 20
+
+var errPreviewTooLarge = errors.New("file too large to preview")
+
+// previewCache keeps recently rendered previews, least recently used ones
+// dropped first once it holds more than previewCacheBytes
+type previewCache struct {
+	mu    sync.Mutex
+	bytes int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type previewCacheEntry struct {
+	key     string
+	preview *preview.Preview
+}
+
+var previews = newPreviewCache()
+
+func newPreviewCache() *previewCache {
+	return &previewCache{order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *previewCache) get(key string) *preview.Preview {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*previewCacheEntry).preview
+}
+
+func (c *previewCache) put(key string, p *preview.Preview) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; ok || len(p.Body) > previewCacheBytes {
+		return
+	}
+	c.items[key] = c.order.PushFront(&previewCacheEntry{key: key, preview: p})
+	c.bytes += len(p.Body)
+	for c.bytes > previewCacheBytes {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*previewCacheEntry)
+		c.order.Remove(oldest)
+		delete(c.items, entry.key)
+		c.bytes -= len(entry.preview.Body)
+	}
+}
+
+// previewParam reads an integer query parameter, writing an error response
+// and returning false if it is outside [min, max]
+func previewParam(w http.ResponseWriter, r *http.Request, name string, def, min, max int) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("%s must be between %d and %d", name, min, max))
+		return 0, false
+	}
+	return n, true
+}
+
+// filePreviewHandler returns a preview of a file: its first max_kb KB if it
+// is text, a thumbnail at most size pixels across if it is an image, or the
+// text of the first page if it is a PDF. Only as much of a text file as the
+// preview needs is read. Previews are cached per file version, and clients
+// can revalidate theirs with If-None-Match.
+func filePreviewHandler(w http.ResponseWriter, r *http.Request) {
+	maxKB, ok := previewParam(w, r, "max_kb", defaultPreviewKB, 1, maxPreviewKB)
+	if !ok {
+		return
+	}
+	size, ok := previewParam(w, r, "size", defaultPreviewSize, minPreviewSize, maxPreviewSize)
+	if !ok {
+		return
+	}
+
+	f := authorizeFile(w, r, mux.Vars(r)["id"])
+	if f == nil {
+		return
+	}
+	key := fmt.Sprintf("%s-%d-%d-%d", f.ID, f.Version, maxKB, size)
+	served, variant := servedFile(w, r, f)
+	if served == nil {
+		return
+	}
+	if served != f {
+		// Previews of a redacted copy are kept apart from the original's
+		key += "-" + served.S3Key
+		f = served
+	}
+	etag := `"` + key + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	p := previews.get(key)
+	if p == nil {
+		opts := preview.Options{MaxTextBytes: maxKB << 10, ThumbnailSize: size}
+		content, err := previewSource(r.Context(), f, opts.MaxTextBytes+1)
+		if errors.Is(err, errPreviewTooLarge) {
+			apierrors.Respond(w, r, apierrors.CodeUnsupportedFormat, "File is too large to preview")
+			return
+		}
+		if err != nil {
+			log.Printf("Error reading file %s for preview: %v", f.ID, err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file content")
+			return
+		}
+		p, err = preview.Render(content, opts)
+		if err != nil {
+			if !errors.Is(err, preview.ErrUnsupported) {
+				log.Printf("Error rendering preview of file %s: %v", f.ID, err)
+			}
+			apierrors.Respond(w, r, apierrors.CodeUnsupportedFormat, "No preview is available for this file")
+			return
+		}
+		previews.put(key, p)
+	}
+
+	recordAccess(r, f.ID, database.AccessPreview, variant)
+
+	w.Header().Set("Content-Type", p.ContentType)
+	w.Header().Set("X-Preview-Kind", p.Kind)
+	if p.Truncated {
+		w.Header().Set("X-Preview-Truncated", "true")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(p.Body)))
+	w.Write(p.Body)
+}
+
+// previewSource reads what a preview of f needs. The head of a plain object
+// is read first, and if it is text only textBytes of it are kept; anything
+// else is read in full. Encrypted objects can only be read in full.
+func previewSource(ctx context.Context, f *database.File, textBytes int) ([]byte, error) {
+	if !f.Encryption.Encrypted() && f.SizeBytes > int64(textBytes) {
+		head, err := readObject(ctx, f, fmt.Sprintf("bytes=0-%d", textBytes-1), int64(textBytes))
+		if err != nil {
+			return nil, err
+		}
+		if preview.Sniff(head) == preview.KindText {
+			return head, nil
+		}
+	}
+	if f.SizeBytes > maxPreviewSourceBytes {
+		return nil, errPreviewTooLarge
+	}
+	return readObject(ctx, f, "", maxPreviewSourceBytes)
+}
+
+// readObject reads up to limit bytes of a file's content, optionally only
+// the given byte range of a plain object
+func readObject(ctx context.Context, f *database.File, byteRange string, limit int64) ([]byte, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(f.S3Key),
+	}
+	if byteRange != "" {
+		input.Range = aws.String(byteRange)
+	}
+	obj, err := s3Client.GetObject(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+	content, _, err := objectContent(ctx, f.ID, f.Encryption, obj)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, io.LimitReader(content, limit))
+	return buf.Bytes(), err
+}
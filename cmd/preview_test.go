@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/preview"
+)
+
+func TestPreviewCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newPreviewCache()
+	big := make([]byte, previewCacheBytes/2)
+
+	c.put("a", &preview.Preview{Body: big})
+	c.put("b", &preview.Preview{Body: big})
+	assert.NotNil(t, c.get("a"))
+	c.put("c", &preview.Preview{Body: big})
+
+	assert.NotNil(t, c.get("a"))
+	assert.Nil(t, c.get("b"), "b was used least recently")
+	assert.NotNil(t, c.get("c"))
+	assert.Equal(t, previewCacheBytes, c.bytes)
+}
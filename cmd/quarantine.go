@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+// maxQuarantinesListed caps how many quarantined results are listed at once
+const maxQuarantinesListed = 500
+
+type quarantineResponse struct {
+	ID         string     `json:"id"`
+	FileID     string     `json:"file_id"`
+	FileName   string     `json:"file_name"`
+	UserID     string     `json:"user_id,omitempty"`
+	Gate       string     `json:"gate"`
+	Reason     string     `json:"reason"`
+	Status     string     `json:"status"`
+	ReviewedBy string     `json:"reviewed_by,omitempty"`
+	Note       string     `json:"note,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	// Result is the withheld result, included when a single quarantined
+	// result is requested and it wasn't rejected
+	Result *string `json:"result,omitempty"`
+}
+
+func newQuarantineResponse(q *database.Quarantine, withResult bool) quarantineResponse {
+	resp := quarantineResponse{
+		ID:         q.ID,
+		FileID:     q.FileID,
+		FileName:   q.FileName,
+		UserID:     q.UserID,
+		Gate:       q.Gate,
+		Reason:     q.Reason,
+		Status:     q.Status,
+		ReviewedBy: q.ReviewedBy,
+		Note:       q.Note,
+		CreatedAt:  q.CreatedAt,
+		ReviewedAt: q.ReviewedAt,
+	}
+	if withResult && q.Status != database.QuarantineRejected {
+		result := q.Result
+		resp.Result = &result
+	}
+	return resp
+}
+
+// parseQuarantineStatus parses the ?status= to list: pending by default, or
+// all for every status
+func parseQuarantineStatus(s string) (string, error) {
+	switch s {
+	case "":
+		return database.QuarantinePending, nil
+	case "all":
+		return "", nil
+	case database.QuarantinePending, database.QuarantineApproved, database.QuarantineRejected:
+		return s, nil
+	}
+	return "", fmt.Errorf("status must be one of %s, %s, %s or all", database.QuarantinePending, database.QuarantineApproved, database.QuarantineRejected)
+}
+
+// listQuarantinesHandler lists results withheld by a gate, oldest first,
+// without the results themselves. Only administrators can see them.
+func listQuarantinesHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	status, err := parseQuarantineStatus(r.URL.Query().Get("status"))
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, err.Error())
+		return
+	}
+	limit := maxQuarantinesListed
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxQuarantinesListed {
+			apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("limit must be between 1 and %d", maxQuarantinesListed))
+			return
+		}
+		limit = n
+	}
+
+	quarantines, err := database.ListQuarantines(status, limit)
+	if err != nil {
+		log.Printf("Error listing quarantined results: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing quarantined results")
+		return
+	}
+
+	items := make([]quarantineResponse, 0, len(quarantines))
+	for i := range quarantines {
+		items = append(items, newQuarantineResponse(&quarantines[i], false))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"quarantined": items})
+}
+
+// getQuarantineHandler returns a quarantined result, including the withheld
+// result for the administrator to review
+func getQuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	id := mux.Vars(r)["id"]
+	q, err := database.GetQuarantine(id)
+	if err != nil {
+		log.Printf("Error loading quarantined result %s: %v", id, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading quarantined result")
+		return
+	}
+	if q == nil {
+		apierrors.Respond(w, r, apierrors.CodeQuarantineNotFound, "Quarantined result not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newQuarantineResponse(q, true))
+}
+
+// approveQuarantineHandler publishes a quarantined result as completed
+func approveQuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	reviewQuarantine(w, r, true)
+}
+
+// rejectQuarantineHandler discards a quarantined result, leaving the file's
+// result failed
+func rejectQuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	reviewQuarantine(w, r, false)
+}
+
+// reviewQuarantine approves or rejects a pending quarantined result with an
+// optional note, then sends the file's new status to the results queue
+func reviewQuarantine(w http.ResponseWriter, r *http.Request, approve bool) {
+	principal := auth.PrincipalFromContext(r.Context())
+	if !principal.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	var req struct {
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	q, err := database.ReviewQuarantine(id, principal.UserID, req.Note, approve)
+	if errors.Is(err, database.ErrAlreadyReviewed) {
+		apierrors.Respond(w, r, apierrors.CodeAlreadyReviewed, "The quarantined result was already reviewed")
+		return
+	}
+	if err != nil {
+		log.Printf("Error reviewing quarantined result %s: %v", id, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error reviewing quarantined result")
+		return
+	}
+	if q == nil {
+		apierrors.Respond(w, r, apierrors.CodeQuarantineNotFound, "Quarantined result not found")
+		return
+	}
+
+	// The review is saved either way; clients that miss the event still find
+	// the result with GET /api/files/{id}/result
+	status := "failed"
+	if approve {
+		status = "completed"
+	}
+	event := queue.ResultEvent{FileID: q.FileID, Status: status, ProcessedAt: time.Now().UTC()}
+	if err := queue.PublishResult(r.Context(), event); err != nil {
+		log.Printf("Error publishing result event for file %s: %v", q.FileID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newQuarantineResponse(q, false))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func TestParseQuarantineStatus(t *testing.T) {
+	status, err := parseQuarantineStatus("")
+	assert.NoError(t, err)
+	assert.Equal(t, database.QuarantinePending, status)
+
+	status, err = parseQuarantineStatus("all")
+	assert.NoError(t, err)
+	assert.Equal(t, "", status)
+
+	status, err = parseQuarantineStatus(database.QuarantineRejected)
+	assert.NoError(t, err)
+	assert.Equal(t, database.QuarantineRejected, status)
+
+	_, err = parseQuarantineStatus("withheld")
+	assert.Error(t, err)
+}
+
+func TestNewQuarantineResponse(t *testing.T) {
+	q := &database.Quarantine{
+		ID:        "q1",
+		FileID:    "f1",
+		Gate:      "pii",
+		Reason:    "Personal data detected: EMAIL",
+		Result:    "contact: someone@example.com",
+		Status:    database.QuarantinePending,
+		CreatedAt: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	assert.Nil(t, newQuarantineResponse(q, false).Result)
+	resp := newQuarantineResponse(q, true)
+	require.NotNil(t, resp.Result)
+	assert.Equal(t, q.Result, *resp.Result)
+
+	q.Status = database.QuarantineRejected
+	assert.Nil(t, newQuarantineResponse(q, true).Result)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+// defaultQueueMetricsNamespace is the CloudWatch namespace queue depth is
+// published under unless QUEUE_METRICS_NAMESPACE is set
+const defaultQueueMetricsNamespace = "FileProcessing"
+
+// queueDepthGauge holds the last queue depth read from SQS
+type queueDepthGauge struct {
+	mu    sync.Mutex
+	depth queue.Depth
+	ok    bool
+}
+
+var queueDepth = &queueDepthGauge{}
+
+func (g *queueDepthGauge) set(d queue.Depth) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.depth, g.ok = d, true
+}
+
+// writeTo writes the gauge in the Prometheus text exposition format. Nothing
+// is written until the queue has been read once.
+func (g *queueDepthGauge) writeTo(w *strings.Builder) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.ok {
+		return
+	}
+	w.WriteString("# HELP sqs_queue_messages Approximate number of messages in the processing queue.\n")
+	w.WriteString("# TYPE sqs_queue_messages gauge\n")
+	fmt.Fprintf(w, "sqs_queue_messages{state=\"visible\"} %d\n", g.depth.Visible)
+	fmt.Fprintf(w, "sqs_queue_messages{state=\"in_flight\"} %d\n", g.depth.InFlight)
+	fmt.Fprintf(w, "sqs_queue_messages{state=\"delayed\"} %d\n", g.depth.Delayed)
+}
+
+// metricsOutput receives CloudWatch embedded metric format records. CloudWatch
+// Logs turns them into metrics, so no CloudWatch API access is needed.
+var metricsOutput io.Writer = os.Stdout
+
+// startQueueDepthMonitor reads the processing queue's depth every
+// QUEUE_METRICS_INTERVAL (default 1m, 0 turns it off), keeps it for /metrics
+// and publishes ApproximateNumberOfMessages and the in-flight count as
+// CloudWatch metrics, which worker auto scaling can target. Every API
+// instance publishes the same values, so alarms and scaling policies should
+// use the Maximum statistic.
+func startQueueDepthMonitor(ctx context.Context) {
+	interval := time.Minute
+	if v := os.Getenv("QUEUE_METRICS_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("Invalid QUEUE_METRICS_INTERVAL %q, using %s", v, interval)
+		} else {
+			interval = d
+		}
+	}
+	if interval == 0 {
+		log.Printf("Queue depth metrics are disabled")
+		return
+	}
+	namespace := os.Getenv("QUEUE_METRICS_NAMESPACE")
+	if namespace == "" {
+		namespace = defaultQueueMetricsNamespace
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			recordQueueDepth(ctx, namespace)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// recordQueueDepth reads the queue depth once and publishes it
+func recordQueueDepth(ctx context.Context, namespace string) {
+	d, err := queue.GetDepth(ctx)
+	if err != nil {
+		log.Printf("Error reading queue depth: %v", err)
+		return
+	}
+	queueDepth.set(d)
+	if err := writeQueueDepthEMF(metricsOutput, namespace, path.Base(queue.QueueURL()), d, time.Now()); err != nil {
+		log.Printf("Error publishing queue depth: %v", err)
+	}
+}
+
+// writeQueueDepthEMF writes d as one CloudWatch embedded metric format record
+// with the queue name as its dimension
+func writeQueueDepthEMF(w io.Writer, namespace, queueName string, d queue.Depth, at time.Time) error {
+	record := map[string]interface{}{
+		"_aws": map[string]interface{}{
+			"Timestamp": at.UnixMilli(),
+			"CloudWatchMetrics": []map[string]interface{}{{
+				"Namespace":  namespace,
+				"Dimensions": [][]string{{"QueueName"}},
+				"Metrics": []map[string]string{
+					{"Name": "ApproximateNumberOfMessages", "Unit": "Count"},
+					{"Name": "ApproximateNumberOfMessagesNotVisible", "Unit": "Count"},
+					{"Name": "ApproximateNumberOfMessagesDelayed", "Unit": "Count"},
+				},
+			}},
+		},
+		"QueueName":                             queueName,
+		"ApproximateNumberOfMessages":           d.Visible,
+		"ApproximateNumberOfMessagesNotVisible": d.InFlight,
+		"ApproximateNumberOfMessagesDelayed":    d.Delayed,
+	}
+	return json.NewEncoder(w).Encode(record)
+}
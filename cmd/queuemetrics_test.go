@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+func TestQueueDepthMetrics(t *testing.T) {
+	g := &queueDepthGauge{}
+	var b strings.Builder
+	g.writeTo(&b)
+	assert.Empty(t, b.String(), "nothing is reported before the first read")
+
+	g.set(queue.Depth{Visible: 42, InFlight: 3})
+	g.writeTo(&b)
+	assert.Contains(t, b.String(), `sqs_queue_messages{state="visible"} 42`)
+	assert.Contains(t, b.String(), `sqs_queue_messages{state="in_flight"} 3`)
+
+	var buf bytes.Buffer
+	at := time.UnixMilli(1700000000000)
+	assert.NoError(t, writeQueueDepthEMF(&buf, "FileProcessing", "my-queue", queue.Depth{Visible: 42}, at))
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "my-queue", record["QueueName"])
+	assert.Equal(t, float64(42), record["ApproximateNumberOfMessages"])
+	meta := record["_aws"].(map[string]interface{})
+	assert.Equal(t, float64(1700000000000), meta["Timestamp"])
+	metrics := meta["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "FileProcessing", metrics["Namespace"])
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+// maxIdleBuckets is how many client buckets are kept before full ones are
+// dropped; a full bucket behaves the same as a missing one
+const maxIdleBuckets = 10000
+
+// bucket is a token bucket for one client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits requests per client using the rate in the current
+// settings, so a reload takes effect on the next request
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// allow takes a token for client. When none is left it returns false and how
+// long until the next one is available.
+func (l *rateLimiter) allow(client string, perMinute, burst int) (bool, time.Duration) {
+	if burst < 1 {
+		burst = perMinute
+	}
+	rate := float64(perMinute) / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(client, rate, burst)
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// blocked reports whether client has no token left, without taking one, and
+// how long until the next one is available
+func (l *rateLimiter) blocked(client string, perMinute, burst int) (bool, time.Duration) {
+	if burst < 1 {
+		burst = perMinute
+	}
+	rate := float64(perMinute) / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(client, rate, burst)
+	if b.tokens < 1 {
+		return true, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	return false, 0
+}
+
+// refill returns client's bucket with the tokens earned since it was last
+// used. l.mu must be held.
+func (l *rateLimiter) refill(client string, rate float64, burst int) *bucket {
+	now := l.now()
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.prune(now, rate, burst)
+		}
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	return b
+}
+
+// prune drops buckets that have refilled completely
+func (l *rateLimiter) prune(now time.Time, rate float64, burst int) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// requests is the limiter for API requests
+var requests = newRateLimiter()
+
+// limitRequests is requests.middleware for a single handler, for routes
+// outside the authenticated API
+func limitRequests(next http.HandlerFunc) http.Handler {
+	return requests.middleware(next)
+}
+
+// middleware rejects callers over the configured rate with 429 Too Many
+// Requests. Callers are told apart by user ID, or by address when anonymous,
+// so users behind one proxy or NAT don't share a limit; it must run after
+// authentication. A zero RateLimit disables limiting.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := settings.Current()
+		if s.RateLimit == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		caller := auth.UserIDFromContext(r.Context())
+		if caller == "" {
+			caller = "ip:" + clientIP(r)
+		}
+		if ok, wait := l.allow(caller, s.RateLimit, s.RateBurst); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			apierrors.Respond(w, r, apierrors.CodeRateLimited, "Too many requests, retry later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+func TestRateLimiterRefills(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		ok, _ := l.allow("10.0.0.1", 60, 2)
+		assert.True(t, ok)
+	}
+	ok, wait := l.allow("10.0.0.1", 60, 2)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	// Other clients have their own bucket
+	ok, _ = l.allow("10.0.0.2", 60, 2)
+	assert.True(t, ok)
+
+	now = now.Add(time.Second)
+	ok, _ = l.allow("10.0.0.1", 60, 2)
+	assert.True(t, ok)
+}
+
+func TestRateLimiterBlockedDoesNotTakeTokens(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+
+	blocked, _ := l.blocked("share", 60, 1)
+	assert.False(t, blocked)
+	blocked, _ = l.blocked("share", 60, 1)
+	assert.False(t, blocked, "checking leaves the token in place")
+
+	ok, _ := l.allow("share", 60, 1)
+	assert.True(t, ok)
+	blocked, wait := l.blocked("share", 60, 1)
+	assert.True(t, blocked)
+	assert.Equal(t, time.Second, wait)
+}
+
+func TestRateLimiterMiddlewareLimitsUsersSeparately(t *testing.T) {
+	s := settings.Defaults()
+	s.RateLimit, s.RateBurst = 60, 1
+	assert.NoError(t, settings.Set(s))
+	t.Cleanup(func() { settings.Set(settings.Defaults()) })
+
+	h := newRateLimiter().middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(userID string) int {
+		r := httptest.NewRequest("GET", "/api/files", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		if userID != "" {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{UserID: userID}))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Users behind the same address each have their own limit
+	assert.Equal(t, http.StatusOK, request("alice"))
+	assert.Equal(t, http.StatusOK, request("bob"))
+	assert.Equal(t, http.StatusTooManyRequests, request("alice"))
+
+	// Anonymous requests are limited by address
+	assert.Equal(t, http.StatusOK, request(""))
+	assert.Equal(t, http.StatusTooManyRequests, request(""))
+}
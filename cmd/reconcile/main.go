@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func main() {
+	prefix := flag.String("prefix", "files/", "only objects under this prefix are expected to have file rows")
+	fix := flag.Bool("fix", false, "fix the orphans that are found")
+	dryRun := flag.Bool("dry-run", false, "with -fix, print the fixes without applying them")
+	objectAction := flag.String("orphan-objects", "register", "fix for objects without rows: register or delete")
+	grace := flag.Duration("grace", time.Hour, "skip rows and objects newer than this, which uploads in flight may not have finished writing")
+	flag.Parse()
+
+	if *objectAction != "register" && *objectAction != "delete" {
+		log.Fatalf("Invalid -orphan-objects %q, use register or delete", *objectAction)
+	}
+
+	bucketName := getEnv("S3_BUCKET_NAME", "my-test-bucket")
+
+	ctx := context.Background()
+	cfg, err := awsconfig.Load(ctx)
+	if err != nil {
+		log.Fatalf("Failed to setup AWS: %v", err)
+	}
+	s3Client := s3.NewFromConfig(cfg)
+
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	// Uploads save the row before writing the object, so either may be
+	// missing for a while; only what is older than the grace period counts
+	cutoff := time.Now().Add(-*grace)
+
+	// List every object in the bucket
+	objects := make(map[string]int64)
+	recent := make(map[string]bool)
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Fatalf("Failed to list objects: %v", err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			objects[key] = obj.Size
+			if obj.LastModified != nil && obj.LastModified.After(cutoff) {
+				recent[key] = true
+			}
+		}
+	}
+
+	files, err := database.GetAllFiles()
+	if err != nil {
+		log.Fatalf("Failed to query files: %v", err)
+	}
+
+	// Rows whose object is gone, and rows whose recorded size is missing or
+	// differs from their object's. Encrypted objects are larger than the
+	// content recorded for them, so their sizes aren't compared.
+	registered := make(map[string]bool)
+	var orphanRows, sizeMismatches []database.File
+	for _, f := range files {
+		registered[f.S3Key] = true
+		if f.CreatedAt.After(cutoff) {
+			continue
+		}
+		size, ok := objects[f.S3Key]
+		if !ok {
+			orphanRows = append(orphanRows, f)
+		} else if size != f.SizeBytes && !f.Encryption.Encrypted() {
+			sizeMismatches = append(sizeMismatches, f)
+		}
+	}
+
+	// Objects under the prefix that no row points to
+	var orphanObjects []string
+	for key := range objects {
+		if strings.HasPrefix(key, *prefix) && !strings.HasSuffix(key, "/") && !registered[key] && !recent[key] {
+			orphanObjects = append(orphanObjects, key)
+		}
+	}
+
+	fmt.Printf("Bucket %s: %d objects, %d file rows, skipping those newer than %s\n", bucketName, len(objects), len(files), cutoff.Format(time.RFC3339))
+
+	fmt.Printf("\nFile rows without S3 objects: %d\n", len(orphanRows))
+	fmt.Println("ID\t\tName\t\tS3 Key")
+	fmt.Println("------------------------------------------------------------")
+	for _, f := range orphanRows {
+		fmt.Printf("%s\t%s\t%s\n", f.ID, f.Name, f.S3Key)
+	}
+
+	fmt.Printf("\nFile rows with a wrong size: %d\n", len(sizeMismatches))
+	fmt.Println("ID\t\tRecorded\tActual")
+	fmt.Println("------------------------------------------------------------")
+	for _, f := range sizeMismatches {
+		fmt.Printf("%s\t%d\t%d\n", f.ID, f.SizeBytes, objects[f.S3Key])
+	}
+
+	fmt.Printf("\nS3 objects without file rows (prefix %q): %d\n", *prefix, len(orphanObjects))
+	fmt.Println("S3 Key\t\tSize")
+	fmt.Println("------------------------------------------------------------")
+	for _, key := range orphanObjects {
+		fmt.Printf("%s\t%d\n", key, objects[key])
+	}
+
+	if !*fix {
+		return
+	}
+
+	fmt.Println()
+	failed := 0
+	for _, f := range orphanRows {
+		fmt.Printf("Deleting file row %s (%s)\n", f.ID, f.S3Key)
+		if *dryRun {
+			continue
+		}
+		if err := database.DeleteFile(f.ID); err != nil {
+			log.Printf("Error deleting file row %s: %v", f.ID, err)
+			failed++
+		}
+	}
+
+	for _, f := range sizeMismatches {
+		size := objects[f.S3Key]
+		fmt.Printf("Setting size of file %s to %d\n", f.ID, size)
+		if *dryRun {
+			continue
+		}
+		if err := database.SetFileSize(f.ID, size); err != nil {
+			log.Printf("Error setting size of file %s: %v", f.ID, err)
+			failed++
+		}
+	}
+
+	for _, key := range orphanObjects {
+		if *objectAction == "delete" {
+			fmt.Printf("Deleting object %s\n", key)
+			if *dryRun {
+				continue
+			}
+			_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(bucketName),
+				Key:    aws.String(key),
+			})
+			if err != nil {
+				log.Printf("Error deleting object %s: %v", key, err)
+				failed++
+			}
+			continue
+		}
+
+		fmt.Printf("Registering object %s\n", key)
+		if *dryRun {
+			continue
+		}
+		if _, err := database.SaveFileWithID(database.NewID(), path.Base(key), key, "", objects[key]); err != nil {
+			log.Printf("Error registering object %s: %v", key, err)
+			failed++
+		}
+	}
+
+	if *dryRun {
+		fmt.Println("\nDry run: no changes were made")
+	}
+	if failed > 0 {
+		log.Fatalf("%d fixes failed", failed)
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/envelope"
+)
+
+// rekey encrypts the sensitive database columns under the active column key:
+// values stored before COLUMN_ENCRYPTION_KEY_ID was set, and, after
+// -rotate, those sealed under retired keys.
+func main() {
+	rotate := flag.Bool("rotate", false, "retire the active data key in favour of a new one before re-encrypting")
+	batch := flag.Int("batch", 500, "rows to re-encrypt at a time")
+	dryRun := flag.Bool("dry-run", false, "list the column keys and count the values to re-encrypt without changing anything")
+	flag.Parse()
+
+	if *batch < 1 {
+		log.Fatalf("-batch must be at least 1")
+	}
+	kmsKeyID := os.Getenv("COLUMN_ENCRYPTION_KEY_ID")
+	if kmsKeyID == "" && !*dryRun {
+		log.Fatalf("COLUMN_ENCRYPTION_KEY_ID must name the KMS key column keys are generated under")
+	}
+
+	ctx := context.Background()
+	cfg, err := awsconfig.Load(ctx)
+	if err != nil {
+		log.Fatalf("Failed to setup AWS: %v", err)
+	}
+	database.SetColumnEncryption(envelope.NewKMS(cfg), kmsKeyID)
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	if *dryRun {
+		keys, err := database.ListColumnKeys()
+		if err != nil {
+			log.Fatalf("Failed to list column keys: %v", err)
+		}
+		for _, k := range keys {
+			state := "active"
+			if !k.Active {
+				state = "retired"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\tcreated=%s\n", k.ID, k.Purpose, state, k.KMSKeyID, k.CreatedAt.Format(time.RFC3339))
+		}
+		counts, err := database.CountStaleColumns(ctx)
+		if err != nil {
+			log.Fatalf("Failed to count values to re-encrypt: %v", err)
+		}
+		columns := make([]string, 0, len(counts))
+		for c := range counts {
+			columns = append(columns, c)
+		}
+		sort.Strings(columns)
+		for _, c := range columns {
+			fmt.Printf("%s\t%d\n", c, counts[c])
+		}
+		fmt.Fprintf(os.Stderr, "Dry run: %d column keys\n", len(keys))
+		return
+	}
+
+	if *rotate {
+		id, err := database.RotateColumnKey(ctx)
+		if err != nil {
+			log.Fatalf("Failed to rotate the column key: %v", err)
+		}
+		fmt.Printf("Rotated to column key %s\n", id)
+	}
+
+	// Processes still sealing under the retired key for up to a minute leave
+	// values behind; run again to catch them
+	n, err := database.ReencryptColumns(ctx, *batch)
+	if err != nil {
+		log.Fatalf("Failed after re-encrypting %d values: %v", n, err)
+	}
+	fmt.Printf("Re-encrypted %d values\n", n)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// costMonth is the month the estimates are for
+const costMonth = 30 * 24 * time.Hour
+
+// costPrices are the AWS prices the estimate uses, in USD
+type costPrices struct {
+	StoragePerGBMonth float64
+	PutPer1000        float64
+	GetPer1000        float64
+	LambdaPerMillion  float64
+	LambdaPerGBSecond float64
+	// LambdaMemoryMB is the memory configured for the processing function
+	LambdaMemoryMB int
+}
+
+// costRow is the estimated monthly cost of one tenant. The total row has no
+// tenant.
+type costRow struct {
+	Tenant      string  `json:"tenant,omitempty"`
+	BytesStored int64   `json:"bytes_stored"`
+	Storage     float64 `json:"storage_usd"`
+	Requests    float64 `json:"requests_usd"`
+	Lambda      float64 `json:"lambda_usd"`
+	Total       float64 `json:"total_usd"`
+}
+
+// costCommand estimates each tenant's monthly S3 storage, S3 request and
+// Lambda cost for chargeback. Storage is priced as it is now; requests and
+// processing are counted over a window and scaled to a month.
+//
+//	report cost -window 168h -lambda-memory-mb 512 -format csv
+func costCommand(args []string) {
+	fs := flag.NewFlagSet("cost", flag.ExitOnError)
+	window := fs.Duration("window", costMonth, "count requests and processing over this long before now")
+	format := fs.String("format", "table", "output format: table, json or csv")
+	var prices costPrices
+	fs.Float64Var(&prices.StoragePerGBMonth, "storage-gb-month", 0.023, "S3 storage price per GB-month")
+	fs.Float64Var(&prices.PutPer1000, "put-per-1000", 0.005, "S3 PUT price per 1,000 requests")
+	fs.Float64Var(&prices.GetPer1000, "get-per-1000", 0.0004, "S3 GET price per 1,000 requests")
+	fs.Float64Var(&prices.LambdaPerMillion, "lambda-per-million", 0.20, "Lambda price per million invocations")
+	fs.Float64Var(&prices.LambdaPerGBSecond, "lambda-gb-second", 0.0000166667, "Lambda price per GB-second")
+	fs.IntVar(&prices.LambdaMemoryMB, "lambda-memory-mb", 128, "memory of the processing function")
+	fs.Parse(args)
+
+	if *window <= 0 || prices.LambdaMemoryMB <= 0 {
+		log.Fatalf("-window and -lambda-memory-mb must be positive")
+	}
+	write, ok := costWriters[*format]
+	if !ok {
+		log.Fatalf("Invalid -format %q, use table, json or csv", *format)
+	}
+
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	now := time.Now()
+	usage, err := database.GetTenantUsage(now.Add(-*window), now)
+	if err != nil {
+		log.Fatalf("Failed to query usage: %v", err)
+	}
+
+	if err := write(os.Stdout, costReport(usage, *window, prices)); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
+
+// costReport estimates the monthly cost of each tenant's usage, with the
+// activity counted over window, followed by the total
+func costReport(usage []database.TenantUsage, window time.Duration, p costPrices) []costRow {
+	scale := float64(costMonth) / float64(window)
+	rows := make([]costRow, 0, len(usage)+1)
+	var total costRow
+	for _, u := range usage {
+		gbSeconds := float64(u.ProcessingMillis) / 1000 * float64(p.LambdaMemoryMB) / 1024
+		row := costRow{
+			Tenant:      u.Username,
+			BytesStored: u.BytesStored,
+			Storage:     float64(u.BytesStored) / (1 << 30) * p.StoragePerGBMonth,
+			Requests:    scale * (float64(u.Uploads)*p.PutPer1000 + float64(u.Downloads)*p.GetPer1000) / 1000,
+			Lambda:      scale * (float64(u.Invocations)*p.LambdaPerMillion/1e6 + gbSeconds*p.LambdaPerGBSecond),
+		}
+		row.Total = row.Storage + row.Requests + row.Lambda
+		rows = append(rows, row)
+
+		total.BytesStored += row.BytesStored
+		total.Storage += row.Storage
+		total.Requests += row.Requests
+		total.Lambda += row.Lambda
+		total.Total += row.Total
+	}
+	return append(rows, total)
+}
+
+var costWriters = map[string]func(w io.Writer, rows []costRow) error{
+	"table": writeCostTable,
+	"json":  writeCostJSON,
+	"csv":   writeCostCSV,
+}
+
+func writeCostTable(w io.Writer, rows []costRow) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "TENANT\tBYTES STORED\tSTORAGE\tREQUESTS\tLAMBDA\tTOTAL\t")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%d\t$%.4f\t$%.4f\t$%.4f\t$%.2f\t\n",
+			tableLabel(r.Tenant), r.BytesStored, r.Storage, r.Requests, r.Lambda, r.Total)
+	}
+	return tw.Flush()
+}
+
+func writeCostJSON(w io.Writer, rows []costRow) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
+
+func writeCostCSV(w io.Writer, rows []costRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"tenant", "bytes_stored", "storage_usd", "requests_usd", "lambda_usd", "total_usd"})
+	usd := func(f float64) string { return strconv.FormatFloat(f, 'f', 4, 64) }
+	for _, r := range rows {
+		cw.Write([]string{r.Tenant, strconv.FormatInt(r.BytesStored, 10), usd(r.Storage), usd(r.Requests), usd(r.Lambda), usd(r.Total)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func TestCostReport(t *testing.T) {
+	prices := costPrices{
+		StoragePerGBMonth: 0.02,
+		PutPer1000:        0.005,
+		GetPer1000:        0.0004,
+		LambdaPerMillion:  0.20,
+		LambdaPerGBSecond: 0.00001,
+		LambdaMemoryMB:    2048,
+	}
+	usage := []database.TenantUsage{
+		{Username: "alice", BytesStored: 50 << 30, Uploads: 1000, Downloads: 10000, Invocations: 1000, ProcessingMillis: 500000},
+		{Username: "bob", BytesStored: 1 << 30},
+	}
+
+	// A week of activity is scaled to 30 days
+	rows := costReport(usage, 7*24*time.Hour, prices)
+	assert.Len(t, rows, 3)
+	scale := 30.0 / 7
+
+	alice := rows[0]
+	assert.Equal(t, "alice", alice.Tenant)
+	assert.InDelta(t, 1.0, alice.Storage, 1e-9)
+	assert.InDelta(t, scale*(0.005+0.004), alice.Requests, 1e-9)
+	// 500 seconds at 2 GB is 1000 GB-seconds
+	assert.InDelta(t, scale*(0.0002+0.01), alice.Lambda, 1e-9)
+	assert.InDelta(t, alice.Storage+alice.Requests+alice.Lambda, alice.Total, 1e-9)
+
+	bob := rows[1]
+	assert.InDelta(t, 0.02, bob.Total, 1e-9)
+
+	total := rows[2]
+	assert.Equal(t, "", total.Tenant)
+	assert.Equal(t, int64(51<<30), total.BytesStored)
+	assert.InDelta(t, alice.Total+bob.Total, total.Total, 1e-9)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/removal"
+	"github.com/yourusername/golang-aws-api/search"
+	"github.com/yourusername/golang-aws-api/storage"
+)
+
+// Kinds of integrity findings
+const (
+	// findingMissingObject is a file with results whose object is gone
+	findingMissingObject = "missing_object"
+	// findingEmptyObject is a file recorded with content whose object is
+	// empty
+	findingEmptyObject = "empty_object"
+	// findingStuckResult is a result left in a non-terminal status
+	findingStuckResult = "stuck_result"
+)
+
+const (
+	// integrityBatchSize is how many files are read from the database at a
+	// time
+	integrityBatchSize = 1000
+	// integrityMaxStuckResults caps how many stuck results one run reports
+	integrityMaxStuckResults = 1000
+)
+
+// integrityFinding is one anomaly. Fixed and FixError are only set with -fix.
+type integrityFinding struct {
+	Kind     string `json:"kind"`
+	FileID   string `json:"file_id"`
+	ResultID string `json:"result_id,omitempty"`
+	UserID   string `json:"user_id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	S3Key    string `json:"s3_key,omitempty"`
+	Detail   string `json:"detail"`
+	Fixed    bool   `json:"fixed,omitempty"`
+	FixError string `json:"fix_error,omitempty"`
+
+	// file is the file a missing object's fix deletes
+	file *database.File
+}
+
+// integrityReport is the report's JSON output
+type integrityReport struct {
+	CheckedAt    time.Time          `json:"checked_at"`
+	FilesChecked int                `json:"files_checked"`
+	CheckErrors  int                `json:"check_errors"`
+	Counts       map[string]int     `json:"counts"`
+	Findings     []integrityFinding `json:"findings"`
+}
+
+// objectHeader reads S3 object metadata; storage.Store implements it
+type objectHeader interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// fileRemover deletes a file and everything stored for it;
+// removal.Remover implements it
+type fileRemover interface {
+	Remove(ctx context.Context, f *database.File, bypassMode string) (int, error)
+}
+
+// integrityCommand finds anomalies the database's constraints can't rule
+// out: files with results whose S3 object was deleted, files recorded with
+// content whose object is empty, and archive results that have been
+// expanding for longer than -stuck-after. The report is written as JSON.
+// With -fix, files whose object is gone are deleted like the API deletes
+// them, redacted copy and search document included, and stuck results are
+// marked failed; empty objects need the file uploaded again and are only
+// reported. The command exits with status 1 if anything is left unfixed.
+//
+//	report integrity -stuck-after 24h -workers 16 -fix
+func integrityCommand(args []string) {
+	fs := flag.NewFlagSet("integrity", flag.ExitOnError)
+	stuckAfter := fs.Duration("stuck-after", 24*time.Hour, "a result expanding for longer than this is stuck")
+	workers := fs.Int("workers", 8, "S3 objects to check in parallel")
+	limit := fs.Int("limit", 0, "most files to check against S3 (default all)")
+	fix := fs.Bool("fix", false, "delete files whose object is gone and fail stuck results")
+	fs.Parse(args)
+
+	if *stuckAfter <= 0 || *workers <= 0 || *limit < 0 {
+		log.Fatalf("-stuck-after and -workers must be positive and -limit not negative")
+	}
+
+	ctx := context.Background()
+	cfg, err := awsconfig.Load(ctx)
+	if err != nil {
+		log.Fatalf("Failed to setup AWS: %v", err)
+	}
+	bucket := getEnv("S3_BUCKET_NAME", "my-test-bucket")
+	store := storage.New(cfg, bucket)
+
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	report := integrityReport{CheckedAt: time.Now().UTC(), Findings: []integrityFinding{}}
+	afterID := ""
+	for *limit == 0 || report.FilesChecked < *limit {
+		batch := integrityBatchSize
+		if *limit > 0 && *limit-report.FilesChecked < batch {
+			batch = *limit - report.FilesChecked
+		}
+		files, err := database.ListIntegrityFiles(afterID, batch)
+		if err != nil {
+			log.Fatalf("Failed to list files: %v", err)
+		}
+		findings, checkErrors := checkObjects(ctx, store, bucket, files, *workers)
+		report.Findings = append(report.Findings, findings...)
+		report.CheckErrors += checkErrors
+		report.FilesChecked += len(files)
+		if len(files) < batch {
+			break
+		}
+		afterID = files[len(files)-1].ID
+	}
+
+	cutoff := time.Now().Add(-*stuckAfter)
+	stuck, err := database.ListStuckResults(cutoff, integrityMaxStuckResults)
+	if err != nil {
+		log.Fatalf("Failed to list stuck results: %v", err)
+	}
+	report.Findings = append(report.Findings, stuckResultFindings(stuck, time.Now())...)
+
+	if *fix {
+		remover := &removal.Remover{S3: store, Bucket: bucket, Index: search.New()}
+		fixFindings(ctx, remover, report.Findings, cutoff)
+	}
+	report.Counts = countFindings(report.Findings)
+
+	if err := writeIntegrityJSON(os.Stdout, report); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+	for _, f := range report.Findings {
+		if !f.Fixed {
+			os.Exit(1)
+		}
+	}
+}
+
+// checkObjects checks the objects of files with up to workers requests at a
+// time. It returns the anomalies found, in the order of files, and how many
+// objects couldn't be checked.
+func checkObjects(ctx context.Context, heads objectHeader, bucket string, files []database.IntegrityFile, workers int) ([]integrityFinding, int) {
+	found := make([]*integrityFinding, len(files))
+	failed := make([]bool, len(files))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				finding, err := checkObject(ctx, heads, bucket, files[i])
+				if err != nil {
+					log.Printf("Error checking object of file %s: %v", files[i].ID, err)
+					failed[i] = true
+					continue
+				}
+				found[i] = finding
+			}
+		}()
+	}
+	for i := range files {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var findings []integrityFinding
+	checkErrors := 0
+	for i, f := range found {
+		if failed[i] {
+			checkErrors++
+		}
+		if f != nil {
+			findings = append(findings, *f)
+		}
+	}
+	return findings, checkErrors
+}
+
+// checkObject returns the anomaly of a file's object, or nil if there is
+// none. A missing object only counts once the file has a result: until then
+// it may still be being uploaded.
+func checkObject(ctx context.Context, heads objectHeader, bucket string, f database.IntegrityFile) (*integrityFinding, error) {
+	finding := &integrityFinding{FileID: f.ID, UserID: f.UserID, Name: f.Name, S3Key: f.S3Key, file: &f.File}
+	head, err := heads.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(f.S3Key),
+	})
+	var notFound *types.NotFound
+	switch {
+	case errors.As(err, &notFound):
+		if !f.HasResult {
+			return nil, nil
+		}
+		finding.Kind = findingMissingObject
+		finding.Detail = "The file has results but its object is not in S3"
+		return finding, nil
+	case err != nil:
+		return nil, err
+	}
+	if head.ContentLength == 0 && f.SizeBytes > 0 {
+		finding.Kind = findingEmptyObject
+		finding.Detail = fmt.Sprintf("The object is empty but the file was uploaded with %d bytes", f.SizeBytes)
+		return finding, nil
+	}
+	return nil, nil
+}
+
+// stuckResultFindings reports results that are stuck as of now
+func stuckResultFindings(results []database.ProcessingResult, now time.Time) []integrityFinding {
+	findings := make([]integrityFinding, 0, len(results))
+	for _, r := range results {
+		findings = append(findings, integrityFinding{
+			Kind:     findingStuckResult,
+			FileID:   r.FileID,
+			ResultID: r.ID,
+			Detail:   fmt.Sprintf("The result has been %s since %s, %s ago", r.Status, r.UpdatedAt.UTC().Format(time.RFC3339), now.Sub(r.UpdatedAt).Round(time.Minute)),
+		})
+	}
+	return findings
+}
+
+// fixFindings fixes what can be fixed, recording the outcome on each
+// finding. Files under retention or legal hold aren't deleted.
+func fixFindings(ctx context.Context, remover fileRemover, findings []integrityFinding, stuckBefore time.Time) {
+	for i := range findings {
+		f := &findings[i]
+		var err error
+		switch f.Kind {
+		case findingMissingObject:
+			_, err = remover.Remove(ctx, f.file, "")
+			f.Fixed = err == nil
+		case findingStuckResult:
+			var failed bool
+			failed, err = database.FailStuckResult(f.ResultID, stuckBefore, "Archive expansion did not finish")
+			if err == nil && !failed {
+				err = errors.New("the result was updated since it was checked")
+			}
+			f.Fixed = failed
+		default:
+			continue
+		}
+		if err != nil {
+			f.FixError = err.Error()
+		}
+	}
+}
+
+// countFindings counts the findings by kind
+func countFindings(findings []integrityFinding) map[string]int {
+	counts := map[string]int{findingMissingObject: 0, findingEmptyObject: 0, findingStuckResult: 0}
+	for _, f := range findings {
+		counts[f.Kind]++
+	}
+	return counts
+}
+
+func writeIntegrityJSON(w io.Writer, report integrityReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
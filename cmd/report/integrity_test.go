@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/pagination"
+	"github.com/yourusername/golang-aws-api/removal"
+	"github.com/yourusername/golang-aws-api/search"
+)
+
+// fakeObjects answers HeadObject from object sizes by key; other keys are
+// missing except "broken", which fails
+type fakeObjects map[string]int64
+
+func (o fakeObjects) HeadObject(_ context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	key := aws.ToString(in.Key)
+	if key == "broken" {
+		return nil, errors.New("access denied")
+	}
+	size, ok := o[key]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{ContentLength: size}, nil
+}
+
+func integrityFile(id, key string, size int64, hasResult bool) database.IntegrityFile {
+	return database.IntegrityFile{File: database.File{ID: id, S3Key: key, SizeBytes: size}, HasResult: hasResult}
+}
+
+func TestCheckObjects(t *testing.T) {
+	objects := fakeObjects{"ok": 10, "empty": 0, "really-empty": 0}
+	files := []database.IntegrityFile{
+		integrityFile("f1", "ok", 10, true),
+		integrityFile("f2", "gone", 10, true),
+		integrityFile("f3", "uploading", 10, false),
+		integrityFile("f4", "empty", 10, true),
+		integrityFile("f5", "really-empty", 0, true),
+		integrityFile("f6", "broken", 10, true),
+	}
+
+	findings, checkErrors := checkObjects(context.Background(), objects, "bucket", files, 3)
+	assert.Equal(t, 1, checkErrors)
+	require.Len(t, findings, 2)
+	assert.Equal(t, findingMissingObject, findings[0].Kind)
+	assert.Equal(t, "f2", findings[0].FileID)
+	assert.Equal(t, findingEmptyObject, findings[1].Kind)
+	assert.Equal(t, "f4", findings[1].FileID)
+}
+
+func TestStuckResultFindings(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	findings := stuckResultFindings([]database.ProcessingResult{
+		{ID: "r1", FileID: "a1", Status: database.StatusExpanding, UpdatedAt: now.Add(-26 * time.Hour)},
+	}, now)
+	require.Len(t, findings, 1)
+	assert.Equal(t, findingStuckResult, findings[0].Kind)
+	assert.Equal(t, "r1", findings[0].ResultID)
+	assert.Contains(t, findings[0].Detail, "26h0m0s ago")
+
+	counts := countFindings(findings)
+	assert.Equal(t, 1, counts[findingStuckResult])
+	assert.Equal(t, 0, counts[findingMissingObject])
+}
+
+// fakeStore records the objects deleted from it and the files removed from
+// the search index
+type fakeStore struct {
+	deleted   []string
+	unindexed []string
+}
+
+func (s *fakeStore) DeleteObject(_ context.Context, in *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	s.deleted = append(s.deleted, aws.ToString(in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (s *fakeStore) Index(context.Context, search.Document) error { return nil }
+
+func (s *fakeStore) Rename(context.Context, string, string) error { return nil }
+
+func (s *fakeStore) Delete(_ context.Context, fileID string) error {
+	s.unindexed = append(s.unindexed, fileID)
+	return nil
+}
+
+func (s *fakeStore) Search(context.Context, string, string, *pagination.Cursor, int) ([]search.Hit, error) {
+	return nil, nil
+}
+
+// storedRemover removes what a removal.Remover stores outside the database,
+// which the tests don't have
+type storedRemover struct {
+	*removal.Remover
+}
+
+func (r storedRemover) Remove(ctx context.Context, f *database.File, bypassMode string) (int, error) {
+	return r.DeleteStored(ctx, f, bypassMode, nil), nil
+}
+
+func TestFixFindingsRemovesEverythingStored(t *testing.T) {
+	file := integrityFile("f1", "files/f1/a.txt", 10, true)
+	file.RedactedKey = "redacted/f1/r1"
+	findings, _ := checkObjects(context.Background(), fakeObjects{}, "bucket", []database.IntegrityFile{file}, 1)
+	require.Len(t, findings, 1)
+
+	store := &fakeStore{}
+	remover := storedRemover{&removal.Remover{S3: store, Bucket: "bucket", Index: store}}
+	fixFindings(context.Background(), remover, findings, time.Now())
+	assert.True(t, findings[0].Fixed)
+	assert.Empty(t, findings[0].FixError)
+	assert.ElementsMatch(t, []string{"files/f1/a.txt", "redacted/f1/r1"}, store.deleted)
+	assert.Equal(t, []string{"f1"}, store.unindexed)
+}
@@ -1,3 +1,9 @@
+// Command report prints reports on the database contents.
+//
+//	report                      list the stored files
+//	report sla -deadline 5m     processing SLA compliance, see slaCommand
+//	report cost                 estimated monthly AWS cost per tenant, see costCommand
+//	report integrity -fix       orphaned results and other anomalies, see integrityCommand
 package main
 
 import (
@@ -10,6 +16,20 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "sla":
+			slaCommand(os.Args[2:])
+			return
+		case "cost":
+			costCommand(os.Args[2:])
+			return
+		case "integrity":
+			integrityCommand(os.Args[2:])
+			return
+		}
+	}
+
 	// Get database connection details from environment variables
 	dbHost := getEnv("DB_HOST", "localhost")
 	dbPort := getEnv("DB_PORT", "5432")
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// slaRow is the compliance of one group of processed files. Processor or
+// Tenant is empty when the report isn't broken down by it, and both are for
+// the total.
+type slaRow struct {
+	Processor string `json:"processor,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+	Files     int    `json:"files"`
+	Met       int    `json:"met"`
+	Missed    int    `json:"missed"`
+	// Pending files have no result yet but are still within the deadline;
+	// they don't count towards the compliance
+	Pending    int     `json:"pending"`
+	Compliance float64 `json:"compliance_percent"`
+}
+
+// slaCommand reports the percentage of files processed within a deadline of
+// their upload, over the files uploaded in a time window:
+//
+//	report sla -deadline 2m -window 24h -by processor -format csv
+func slaCommand(args []string) {
+	fs := flag.NewFlagSet("sla", flag.ExitOnError)
+	deadline := fs.Duration("deadline", 5*time.Minute, "files must be processed within this long of their upload")
+	window := fs.Duration("window", 7*24*time.Hour, "report on the files uploaded in this long before -until")
+	until := fs.String("until", "", "end of the window as an RFC 3339 time (default now)")
+	by := fs.String("by", "processor,tenant", "comma-separated breakdown: processor, tenant or both")
+	format := fs.String("format", "table", "output format: table, json or csv")
+	fs.Parse(args)
+
+	if *deadline <= 0 || *window <= 0 {
+		log.Fatalf("-deadline and -window must be positive")
+	}
+	byProcessor, byTenant, err := parseBreakdown(*by)
+	if err != nil {
+		log.Fatalf("Invalid -by: %v", err)
+	}
+	write, ok := slaWriters[*format]
+	if !ok {
+		log.Fatalf("Invalid -format %q, use table, json or csv", *format)
+	}
+	now := time.Now()
+	end := now
+	if *until != "" {
+		if end, err = time.Parse(time.RFC3339, *until); err != nil {
+			log.Fatalf("Invalid -until: %v", err)
+		}
+	}
+
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	times, err := database.GetProcessingTimes(end.Add(-*window), end)
+	if err != nil {
+		log.Fatalf("Failed to query processing times: %v", err)
+	}
+
+	rows := slaReport(times, *deadline, now, byProcessor, byTenant)
+	if err := write(os.Stdout, rows); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
+
+// parseBreakdown parses the -by flag
+func parseBreakdown(by string) (processor, tenant bool, err error) {
+	for _, dim := range strings.Split(by, ",") {
+		switch strings.TrimSpace(dim) {
+		case "processor":
+			processor = true
+		case "tenant":
+			tenant = true
+		case "":
+		default:
+			return false, false, fmt.Errorf("unknown breakdown %q", dim)
+		}
+	}
+	return processor, tenant, nil
+}
+
+// slaReport groups the processing times and computes each group's
+// compliance with the deadline as of now. The groups are sorted, and
+// followed by the total when there is more than one.
+func slaReport(times []database.ProcessingTime, deadline time.Duration, now time.Time, byProcessor, byTenant bool) []slaRow {
+	type key struct{ processor, tenant string }
+	groups := make(map[key]*slaRow)
+	total := &slaRow{}
+	for _, t := range times {
+		var k key
+		if byProcessor {
+			k.processor = t.Processor
+		}
+		if byTenant {
+			k.tenant = t.Tenant
+		}
+		row := groups[k]
+		if row == nil {
+			row = &slaRow{Processor: k.processor, Tenant: k.tenant}
+			groups[k] = row
+		}
+		for _, r := range []*slaRow{row, total} {
+			r.Files++
+			switch {
+			case t.ProcessedAt.Valid && t.ProcessedAt.Time.Sub(t.UploadedAt) <= deadline:
+				r.Met++
+			case t.ProcessedAt.Valid, now.Sub(t.UploadedAt) > deadline:
+				r.Missed++
+			default:
+				r.Pending++
+			}
+		}
+	}
+
+	rows := make([]slaRow, 0, len(groups)+1)
+	for _, row := range groups {
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Processor != rows[j].Processor {
+			return rows[i].Processor < rows[j].Processor
+		}
+		return rows[i].Tenant < rows[j].Tenant
+	})
+	if len(rows) > 1 {
+		rows = append(rows, *total)
+	}
+	for i := range rows {
+		if decided := rows[i].Met + rows[i].Missed; decided > 0 {
+			rows[i].Compliance = 100 * float64(rows[i].Met) / float64(decided)
+		}
+	}
+	return rows
+}
+
+var slaWriters = map[string]func(w io.Writer, rows []slaRow) error{
+	"table": writeSLATable,
+	"json":  writeSLAJSON,
+	"csv":   writeSLACSV,
+}
+
+// tableLabel is how tables show an empty processor or tenant
+func tableLabel(s string) string {
+	if s == "" {
+		return "*"
+	}
+	return s
+}
+
+func writeSLATable(w io.Writer, rows []slaRow) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "PROCESSOR\tTENANT\tFILES\tMET\tMISSED\tPENDING\tCOMPLIANCE\t")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%.2f%%\t\n",
+			tableLabel(r.Processor), tableLabel(r.Tenant), r.Files, r.Met, r.Missed, r.Pending, r.Compliance)
+	}
+	return tw.Flush()
+}
+
+func writeSLAJSON(w io.Writer, rows []slaRow) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
+
+func writeSLACSV(w io.Writer, rows []slaRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"processor", "tenant", "files", "met", "missed", "pending", "compliance_percent"})
+	for _, r := range rows {
+		cw.Write([]string{
+			r.Processor, r.Tenant,
+			strconv.Itoa(r.Files), strconv.Itoa(r.Met), strconv.Itoa(r.Missed), strconv.Itoa(r.Pending),
+			strconv.FormatFloat(r.Compliance, 'f', 2, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
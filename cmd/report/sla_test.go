@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func processedAfter(uploaded time.Time, d time.Duration) sql.NullTime {
+	return sql.NullTime{Time: uploaded.Add(d), Valid: true}
+}
+
+func TestSLAReport(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-time.Hour)
+	times := []database.ProcessingTime{
+		{Tenant: "alice", Processor: "builtin", UploadedAt: old, ProcessedAt: processedAfter(old, time.Minute)},
+		{Tenant: "alice", Processor: "builtin", UploadedAt: old, ProcessedAt: processedAfter(old, 10*time.Minute)},
+		{Tenant: "bob", Processor: "builtin", UploadedAt: old},
+		// Not processed yet, but still within the deadline
+		{Tenant: "bob", Processor: "builtin", UploadedAt: now.Add(-time.Minute)},
+		{Tenant: "alice", Processor: "custom", UploadedAt: old, ProcessedAt: processedAfter(old, 2*time.Minute)},
+	}
+
+	rows := slaReport(times, 5*time.Minute, now, true, true)
+	assert.Equal(t, []slaRow{
+		{Processor: "builtin", Tenant: "alice", Files: 2, Met: 1, Missed: 1, Compliance: 50},
+		{Processor: "builtin", Tenant: "bob", Files: 2, Missed: 1, Pending: 1},
+		{Processor: "custom", Tenant: "alice", Files: 1, Met: 1, Compliance: 100},
+		{Files: 5, Met: 2, Missed: 2, Pending: 1, Compliance: 50},
+	}, rows)
+
+	rows = slaReport(times, 5*time.Minute, now, false, true)
+	assert.Equal(t, []slaRow{
+		{Tenant: "alice", Files: 3, Met: 2, Missed: 1, Compliance: 200.0 / 3},
+		{Tenant: "bob", Files: 2, Missed: 1, Pending: 1},
+		{Files: 5, Met: 2, Missed: 2, Pending: 1, Compliance: 50},
+	}, rows)
+}
+
+func TestParseBreakdown(t *testing.T) {
+	processor, tenant, err := parseBreakdown("tenant")
+	assert.NoError(t, err)
+	assert.False(t, processor)
+	assert.True(t, tenant)
+
+	_, _, err = parseBreakdown("processor,region")
+	assert.Error(t, err)
+}
+
+func TestWriteSLACSV(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeSLACSV(&buf, []slaRow{{Processor: "builtin", Tenant: "alice", Files: 3, Met: 2, Missed: 1, Compliance: 200.0 / 3}})
+	assert.NoError(t, err)
+	assert.Equal(t, "processor,tenant,files,met,missed,pending,compliance_percent\nbuiltin,alice,3,2,1,0,66.67\n", buf.String())
+}
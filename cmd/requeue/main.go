@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+// minOlderThan keeps files that may still be in a Lambda invocation, which
+// runs for at most 15 minutes, from being requeued
+const minOlderThan = 15 * time.Minute
+
+func main() {
+	olderThan := flag.Duration("older-than", time.Hour, "how long a file must have waited for its first result to count as stuck")
+	files := flag.String("file", "", "comma-separated file IDs to requeue (default every stuck file)")
+	limit := flag.Int("limit", 500, "most stuck files to requeue")
+	dryRun := flag.Bool("dry-run", false, "list the stuck files without requeueing them")
+	flag.Parse()
+
+	if *olderThan < minOlderThan {
+		log.Fatalf("-older-than must be at least %s", minOlderThan)
+	}
+	bucketName := getEnv("S3_BUCKET_NAME", "my-test-bucket")
+
+	ctx := context.Background()
+	if !*dryRun {
+		cfg, err := awsconfig.Load(ctx)
+		if err != nil {
+			log.Fatalf("Failed to setup AWS: %v", err)
+		}
+		queue.InitQueue(cfg)
+	}
+
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	since := time.Now().Add(-*olderThan)
+	stuck, err := database.ListStuckFiles(since, *limit)
+	if err != nil {
+		log.Fatalf("Failed to list stuck files: %v", err)
+	}
+	if *files != "" {
+		wanted := make(map[string]bool)
+		for _, id := range strings.Split(*files, ",") {
+			wanted[strings.TrimSpace(id)] = true
+		}
+		var selected []database.StuckFile
+		for _, s := range stuck {
+			if wanted[s.ID] {
+				selected = append(selected, s)
+			}
+		}
+		stuck = selected
+	}
+
+	if *dryRun {
+		for _, s := range stuck {
+			fmt.Printf("%s\t%s\tattempts=%d\tlast_activity=%s\t%s\n", s.ID, s.Name, s.Attempts, s.LastActivity.Format(time.RFC3339), s.LastError)
+		}
+		fmt.Fprintf(os.Stderr, "Dry run: %d stuck files\n", len(stuck))
+		return
+	}
+
+	// Claiming resets the files' attempts and skips any that got a result,
+	// were attempted or were claimed by someone else since they were listed
+	ids := make([]string, 0, len(stuck))
+	for _, s := range stuck {
+		ids = append(ids, s.ID)
+	}
+	claimed, err := database.ClaimStuckFiles(ids, since)
+	if err != nil {
+		log.Fatalf("Failed to claim stuck files: %v", err)
+	}
+
+	// Each message gets a requeue ID, which the worker deduplicates on and
+	// which makes it skip a file processed in the meantime
+	events := make([]queue.FileEvent, 0, len(claimed))
+	for _, s := range claimed {
+		events = append(events, queue.FileEvent{FileID: s.ID, Bucket: bucketName, Key: s.S3Key, JobID: s.JobID, RequeueID: database.NewID()})
+	}
+	var failed int
+	for i, err := range queue.PublishFileEvents(ctx, events) {
+		if err != nil {
+			log.Printf("Error requeueing file %s: %v", events[i].FileID, err)
+			failed++
+		}
+	}
+
+	fmt.Printf("Requeued %d of %d stuck files\n", len(events)-failed, len(stuck))
+	if failed > 0 {
+		fmt.Printf("%d files failed\n", failed)
+		os.Exit(1)
+	}
+}
+
+// Helper function to get environment variables
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
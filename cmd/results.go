@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/processor"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+const (
+	// resultStatusCacheSize caps how many files' latest status is kept
+	resultStatusCacheSize = 10000
+	// resultStreamBuffer is how many updates a slow stream may fall behind
+	// before further ones are dropped for it
+	resultStreamBuffer = 16
+	// resultStreamKeepAlive is how often an idle stream sends a comment, so
+	// proxies don't close it
+	resultStreamKeepAlive = 30 * time.Second
+	// resultMaxWait caps how long GET /files/{id}/result?wait= holds a request
+	resultMaxWait = 60 * time.Second
+	// resultWaitPoll is how often a waiting request rechecks the database, for
+	// results announced while the listener was disconnected or when result
+	// notifications are disabled
+	resultWaitPoll = 2 * time.Second
+)
+
+// resultNotice is a completion event with the owner of the file, as fanned
+// out to every API instance. Progress is set instead on the progress updates
+// of a file still being processed, which aren't results.
+type resultNotice struct {
+	queue.ResultEvent
+	UserID   string              `json:"user_id"`
+	Progress *processor.Progress `json:"progress,omitempty"`
+}
+
+// progressEvent is the data of a "progress" server-sent event
+type progressEvent struct {
+	FileID string `json:"file_id"`
+	processor.Progress
+}
+
+// resultHub keeps the latest status of recently processed files and hands
+// completion events to the streams subscribed to a file or to its owner
+type resultHub struct {
+	mu     sync.Mutex
+	subs   map[string]map[chan resultNotice]bool
+	latest map[string]resultNotice
+}
+
+func newResultHub() *resultHub {
+	return &resultHub{
+		subs:   make(map[string]map[chan resultNotice]bool),
+		latest: make(map[string]resultNotice),
+	}
+}
+
+var results = newResultHub()
+
+// fileTopic and userTopic are the keys streams subscribe under
+func fileTopic(fileID string) string { return "file:" + fileID }
+func userTopic(userID string) string { return "user:" + userID }
+
+// subscribe returns a channel receiving the events published on topic until
+// cancel is called
+func (h *resultHub) subscribe(topic string) (<-chan resultNotice, func()) {
+	ch := make(chan resultNotice, resultStreamBuffer)
+	h.mu.Lock()
+	if h.subs[topic] == nil {
+		h.subs[topic] = make(map[chan resultNotice]bool)
+	}
+	h.subs[topic][ch] = true
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs[topic], ch)
+		if len(h.subs[topic]) == 0 {
+			delete(h.subs, topic)
+		}
+	}
+}
+
+// publish records a result as its file's latest status and passes it, or a
+// progress update, to the file's and the owner's subscribers. A subscriber
+// whose buffer is full misses it.
+func (h *resultHub) publish(n resultNotice) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n.Progress == nil {
+		if _, ok := h.latest[n.FileID]; !ok && len(h.latest) >= resultStatusCacheSize {
+			for fileID := range h.latest {
+				delete(h.latest, fileID)
+				break
+			}
+		}
+		h.latest[n.FileID] = n
+	}
+
+	for _, topic := range []string{fileTopic(n.FileID), userTopic(n.UserID)} {
+		for ch := range h.subs[topic] {
+			select {
+			case ch <- n:
+			default:
+			}
+		}
+	}
+}
+
+// status returns the cached latest status of a file
+func (h *resultHub) status(fileID string) (resultNotice, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n, ok := h.latest[fileID]
+	return n, ok
+}
+
+// startResultConsumer consumes the results queue when RESULTS_QUEUE_URL is
+// set. Each event is delivered to the owner's webhooks once, by whichever
+// instance receives it, and fanned out to every instance with a Postgres
+// NOTIFY so streams on any of them see it.
+func startResultConsumer(ctx context.Context) {
+	if !queue.ResultsEnabled() {
+		log.Printf("Result notifications are disabled")
+		return
+	}
+	webhookQueue = newWebhookSender(ctx, webhookWorkers, webhookBacklog, webhookRetry, deliverWebhook)
+	err := database.ListenFileResults(ctx, func(payload []byte) {
+		var n resultNotice
+		if err := json.Unmarshal(payload, &n); err != nil {
+			log.Printf("Error decoding result notification: %v", err)
+			return
+		}
+		results.publish(n)
+	})
+	if err != nil {
+		log.Printf("Error listening for result notifications: %v", err)
+		return
+	}
+
+	go func() {
+		for ctx.Err() == nil {
+			messages, err := queue.ReceiveResults(ctx, 10, 20*time.Second)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Error receiving result events: %v", err)
+					time.Sleep(5 * time.Second)
+				}
+				continue
+			}
+
+			var handles []string
+			for _, m := range messages {
+				signature, timestamp := queue.MessageSignature(m)
+				if err := queue.VerifyMessage(aws.ToString(m.Body), signature, timestamp); err != nil {
+					// Forged or altered events are dropped
+					log.Printf("Dropping result event %s: %v", aws.ToString(m.MessageId), err)
+					handles = append(handles, aws.ToString(m.ReceiptHandle))
+					continue
+				}
+				if err := handleResultEvent([]byte(aws.ToString(m.Body))); err != nil {
+					// Left on the queue to be redelivered
+					log.Printf("Error handling result event %s: %v", aws.ToString(m.MessageId), err)
+					continue
+				}
+				handles = append(handles, aws.ToString(m.ReceiptHandle))
+			}
+			for _, err := range queue.DeleteResults(ctx, handles) {
+				if err != nil {
+					log.Printf("Error deleting result event: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// startProgressListener passes the progress the worker announces for long
+// pipelines on to the streams of this instance
+func startProgressListener(ctx context.Context) {
+	err := database.ListenFileProgress(ctx, func(payload []byte) {
+		var n resultNotice
+		if err := json.Unmarshal(payload, &n); err != nil || n.Progress == nil {
+			log.Printf("Error decoding progress notification: %v", err)
+			return
+		}
+		results.publish(n)
+	})
+	if err != nil {
+		log.Printf("Error listening for progress notifications: %v", err)
+	}
+}
+
+// handleResultEvent queues one completion event for the file owner's
+// webhooks and announces it to every instance
+func handleResultEvent(body []byte) error {
+	var event queue.ResultEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		log.Printf("Dropping malformed result event: %v", err)
+		return nil
+	}
+	f, err := database.GetFileByID(event.FileID)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		// Deleted since it was processed
+		return nil
+	}
+
+	n := resultNotice{ResultEvent: event, UserID: f.UserID}
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	if err := database.NotifyFileResult(payload); err != nil {
+		return err
+	}
+	deliverWebhooks(n)
+	return nil
+}
+
+// fileEventsHandler streams the processing results of one file, and its
+// progress while it is processed, as server-sent events. The latest known
+// status is sent first, if this instance has seen one, or else the file's
+// saved progress.
+func fileEventsHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["id"]
+	f := authorizeFile(w, r, fileID)
+	if f == nil {
+		return
+	}
+
+	updates, cancel := results.subscribe(fileTopic(f.ID))
+	defer cancel()
+	var initial []resultNotice
+	if n, ok := results.status(f.ID); ok {
+		initial = append(initial, n)
+	} else if progress, err := database.GetProcessingProgress([]string{f.ID}); err != nil {
+		log.Printf("Error loading progress of file %s: %v", f.ID, err)
+	} else if p, ok := progress[f.ID]; ok {
+		initial = append(initial, resultNotice{ResultEvent: queue.ResultEvent{FileID: f.ID}, UserID: f.UserID, Progress: newProgress(p)})
+	}
+	streamResults(w, r, updates, initial)
+}
+
+// userEventsHandler streams the processing results and progress of all the
+// caller's files as server-sent events
+func userEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	updates, cancel := results.subscribe(userTopic(userID))
+	defer cancel()
+	streamResults(w, r, updates, nil)
+}
+
+// streamResults writes initial and then every update as a "result" or
+// "progress" event until the client goes away
+func streamResults(w http.ResponseWriter, r *http.Request, updates <-chan resultNotice, initial []resultNotice) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	write := func(n resultNotice) error {
+		event := "result"
+		var payload interface{} = n.ResultEvent
+		if n.Progress != nil {
+			event, payload = "progress", progressEvent{FileID: n.FileID, Progress: *n.Progress}
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	for _, n := range initial {
+		if write(n) != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(resultStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case n := <-updates:
+			if write(n) != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// resultWait parses ?wait=, given as a duration like 30s or as seconds, and
+// caps it at resultMaxWait. It is zero when the parameter isn't set.
+func resultWait(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	v := r.URL.Query().Get("wait")
+	if v == "" {
+		return 0, true
+	}
+	wait, err := time.ParseDuration(v)
+	if err != nil {
+		var seconds int
+		seconds, err = strconv.Atoi(v)
+		wait = time.Duration(seconds) * time.Second
+	}
+	if err != nil || wait < 0 {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "wait must be a duration like 30s")
+		return 0, false
+	}
+	if wait > resultMaxWait {
+		wait = resultMaxWait
+	}
+	return wait, true
+}
+
+// waitForResult blocks until load finds a final result, wait elapses or the
+// client goes away. load runs whenever updates announces a result, and every
+// resultWaitPoll in case an announcement was missed. An expanding archive's
+// result isn't final, so it is only returned if nothing newer arrived in
+// time. It returns nil if no result arrived in time.
+func waitForResult(ctx context.Context, updates <-chan resultNotice, wait time.Duration, load func() (*database.ProcessingResult, error)) (*database.ProcessingResult, error) {
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	poll := time.NewTicker(resultWaitPoll)
+	defer poll.Stop()
+	var last *database.ProcessingResult
+	for {
+		select {
+		case <-ctx.Done():
+			return last, nil
+		case <-timeout.C:
+			return last, nil
+		case n := <-updates:
+			if n.Progress != nil {
+				continue
+			}
+		case <-poll.C:
+		}
+		pr, err := load()
+		if err != nil {
+			return nil, err
+		}
+		if pr != nil && pr.Status != database.StatusExpanding {
+			return pr, nil
+		}
+		if pr != nil {
+			last = pr
+		}
+	}
+}
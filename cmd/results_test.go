@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/processor"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+func TestResultHubFansOut(t *testing.T) {
+	h := newResultHub()
+	byFile, cancelFile := h.subscribe(fileTopic("f1"))
+	defer cancelFile()
+	byUser, cancelUser := h.subscribe(userTopic("u1"))
+	defer cancelUser()
+	other, cancelOther := h.subscribe(fileTopic("f2"))
+	defer cancelOther()
+
+	n := resultNotice{ResultEvent: queue.ResultEvent{FileID: "f1", Status: "completed"}, UserID: "u1"}
+	h.publish(n)
+	assert.Equal(t, n, <-byFile)
+	assert.Equal(t, n, <-byUser)
+	assert.Empty(t, other)
+
+	cached, ok := h.status("f1")
+	assert.True(t, ok)
+	assert.Equal(t, "completed", cached.Status)
+}
+
+func TestStreamResults(t *testing.T) {
+	updates := make(chan resultNotice, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/api/events", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	initial := []resultNotice{{ResultEvent: queue.ResultEvent{FileID: "f1", Status: "failed"}}}
+	updates <- resultNotice{ResultEvent: queue.ResultEvent{FileID: "f1", Status: "completed", ProcessedAt: time.Unix(0, 0).UTC()}}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	streamResults(w, r, updates, initial)
+
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	assert.Len(t, events, 2)
+	assert.Contains(t, events[0], `"status":"failed"`)
+	assert.True(t, strings.HasPrefix(events[1], "event: result\ndata: {"))
+	assert.Contains(t, events[1], `"status":"completed"`)
+}
+
+func TestStreamResultsProgress(t *testing.T) {
+	h := newResultHub()
+	updates, unsubscribe := h.subscribe(fileTopic("f1"))
+	defer unsubscribe()
+	h.publish(resultNotice{ResultEvent: queue.ResultEvent{FileID: "f1"}, UserID: "u1", Progress: &processor.Progress{Stage: 1, Stages: 2, Processor: "archive", Done: 40, Percent: 25}})
+
+	// Progress isn't a result, so it doesn't become the file's status
+	_, ok := h.status("f1")
+	assert.False(t, ok)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/api/files/f1/events", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	streamResults(w, r, updates, nil)
+
+	assert.Equal(t, `event: progress
+data: {"file_id":"f1","stage":1,"stages":2,"processor":"archive","done":40,"percent":25}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestResultWait(t *testing.T) {
+	for query, want := range map[string]time.Duration{"": 0, "wait=30s": 30 * time.Second, "wait=5": 5 * time.Second, "wait=10m": resultMaxWait} {
+		wait, ok := resultWait(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/files/f1/result?"+query, nil))
+		assert.True(t, ok, query)
+		assert.Equal(t, want, wait, query)
+	}
+
+	w := httptest.NewRecorder()
+	_, ok := resultWait(w, httptest.NewRequest("GET", "/api/files/f1/result?wait=soon", nil))
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestWaitForResult(t *testing.T) {
+	updates := make(chan resultNotice, 1)
+	var loads int
+	load := func() (*database.ProcessingResult, error) {
+		loads++
+		if loads < 2 {
+			return nil, nil
+		}
+		return &database.ProcessingResult{ID: "r1"}, nil
+	}
+
+	// A notice that races the save is followed by the one that finds it
+	updates <- resultNotice{}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		updates <- resultNotice{}
+	}()
+	pr, err := waitForResult(context.Background(), updates, time.Second, load)
+	assert.NoError(t, err)
+	if assert.NotNil(t, pr) {
+		assert.Equal(t, "r1", pr.ID)
+	}
+
+	start := time.Now()
+	pr, err = waitForResult(context.Background(), nil, 20*time.Millisecond, func() (*database.ProcessingResult, error) { return nil, nil })
+	assert.NoError(t, err)
+	assert.Nil(t, pr)
+	assert.Less(t, time.Since(start), resultWaitPoll)
+}
+
+func TestWebhookSignature(t *testing.T) {
+	// echo -n '{"file_id":"f1"}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t, "sha256=d0ed37d01f92b12cd9564889fc83e1211beae9380337838ced2357d033b402bc", webhookSignature("secret", []byte(`{"file_id":"f1"}`)))
+}
+
+func TestWaitForResultWaitsOutExpandingArchives(t *testing.T) {
+	updates := make(chan resultNotice, 2)
+	status := database.StatusExpanding
+	load := func() (*database.ProcessingResult, error) {
+		return &database.ProcessingResult{ID: "r1", Status: status}, nil
+	}
+
+	// Still expanding when the wait ends, so that is what is returned
+	updates <- resultNotice{}
+	pr, err := waitForResult(context.Background(), updates, 20*time.Millisecond, load)
+	assert.NoError(t, err)
+	if assert.NotNil(t, pr) {
+		assert.Equal(t, database.StatusExpanding, pr.Status)
+	}
+
+	status = "completed"
+	updates <- resultNotice{}
+	pr, err = waitForResult(context.Background(), updates, time.Second, load)
+	assert.NoError(t, err)
+	if assert.NotNil(t, pr) {
+		assert.Equal(t, "completed", pr.Status)
+	}
+}
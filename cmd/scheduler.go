@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+// schedulerBatchSize is the maximum number of due jobs claimed per tick
+const schedulerBatchSize = 100
+
+// startScheduler runs a ticker that enqueues scheduled jobs once they are due
+func startScheduler(ctx context.Context) {
+	interval := 30 * time.Second
+	if v := os.Getenv("SCHEDULER_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Printf("Invalid SCHEDULER_INTERVAL %q, using %s: %v", v, interval, err)
+		} else {
+			interval = d
+		}
+	}
+
+	log.Printf("Scheduler started with interval %s", interval)
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				failBlockedJobs(ctx)
+				enqueueDueJobs(ctx)
+				if schedulesDrivenByTicker() {
+					runDueSchedules(ctx)
+				}
+				purgeIdempotencyKeys()
+				purgeUploadTokens()
+				purgeProcessedMessages()
+				purgeCallbackSignatures()
+				purgeDueAccounts(ctx)
+				expireFiles(ctx)
+				purgeResultObjects(ctx)
+				exportAnalytics(ctx)
+				exportUsage(ctx)
+			}
+		}
+	}()
+}
+
+// enqueueDueJobs publishes every pending job whose run time has passed
+func enqueueDueJobs(ctx context.Context) {
+	jobs, err := database.ClaimDueScheduledJobs(time.Now(), schedulerBatchSize)
+	if err != nil {
+		log.Printf("Error claiming scheduled jobs: %v", err)
+		return
+	}
+
+	events := make([]queue.FileEvent, 0, len(jobs))
+	for _, job := range jobs {
+		log.Printf("Enqueuing scheduled job: id=%s, file_id=%s", job.ID, job.FileID)
+		events = append(events, queue.FileEvent{FileID: job.FileID, Bucket: bucketName, Key: job.S3Key, JobID: job.ID})
+	}
+	for i, err := range queue.PublishFileEvents(ctx, events) {
+		if err == nil {
+			continue
+		}
+		job := jobs[i]
+		log.Printf("Error publishing scheduled job %s: %v", job.ID, err)
+		// Put the job back so the next tick retries it
+		if err := database.UpdateScheduledJobStatus(job.ID, database.ScheduledJobPending); err != nil {
+			log.Printf("Error resetting scheduled job %s: %v", job.ID, err)
+		}
+	}
+}
+
+// purgeIdempotencyKeys deletes stored responses whose replay window has passed
+func purgeIdempotencyKeys() {
+	n, err := database.DeleteExpiredIdempotencyKeys()
+	if err != nil {
+		log.Printf("Error deleting expired idempotency keys: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Deleted %d expired idempotency keys", n)
+	}
+}
+
+// purgeProcessedMessages forgets processed deliveries SQS can no longer
+// redeliver
+func purgeProcessedMessages() {
+	n, err := database.DeleteOldProcessedMessages()
+	if err != nil {
+		log.Printf("Error deleting processed messages: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Deleted %d processed messages", n)
+	}
+}
+
+// scheduleProcessing records a deferred processing job for a file. Jobs due
+// within the SQS delay limit are published right away with a delivery delay;
+// later ones are left pending for the scheduler.
+func scheduleProcessing(ctx context.Context, job *database.ScheduledJob) error {
+	delay := time.Until(job.RunAt)
+	if delay > queue.MaxDelay || queue.IsFIFO() {
+		return nil
+	}
+
+	if err := queue.PublishScheduledFileEvent(ctx, job.FileID, bucketName, job.S3Key, job.ID, delay); err != nil {
+		return err
+	}
+	return database.UpdateScheduledJobStatus(job.ID, database.ScheduledJobEnqueued)
+}
+
+// cancelScheduleHandler cancels pending scheduled processing for a file. Only
+// the file's owner or an administrator may cancel it.
+func cancelScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	f, err := database.GetFileByID(fileID)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file")
+		return
+	}
+	p := auth.PrincipalFromContext(r.Context())
+	if f == nil || !(p.Owns(f.UserID) || p.IsAdmin()) {
+		apierrors.Respond(w, r, apierrors.CodeFileNotFound, "File not found")
+		return
+	}
+
+	cancelled, err := database.CancelScheduledJobs(fileID)
+	if err != nil {
+		log.Printf("Error cancelling scheduled jobs: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error cancelling scheduled processing")
+		return
+	}
+	if cancelled == 0 {
+		apierrors.Respond(w, r, apierrors.CodeJobNotFound, "No scheduled processing found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":      fileID,
+		"status":  "cancelled",
+		"message": "Scheduled processing cancelled",
+	})
+}
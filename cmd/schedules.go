@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/cron"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/processor"
+	"github.com/yourusername/golang-aws-api/queue"
+	"github.com/yourusername/golang-aws-api/tenants"
+)
+
+const (
+	// maxSchedulesPerUser caps how many schedules a user may have
+	maxSchedulesPerUser = 20
+	// maxScheduleFiles caps how many files one run of a schedule processes
+	maxScheduleFiles = 1000
+	// minScheduleInterval is the shortest recurrence a schedule may have
+	minScheduleInterval = 15 * time.Minute
+	// scheduleRunsLimit is how many runs the history lists
+	scheduleRunsLimit = 50
+)
+
+// schedulesDrivenByTicker reports whether the scheduler ticker starts due
+// schedule runs. With SCHEDULE_TRIGGER=eventbridge they are only started by
+// POST /api/admin/schedules/run, which an EventBridge rule calls instead.
+func schedulesDrivenByTicker() bool {
+	return !strings.EqualFold(os.Getenv("SCHEDULE_TRIGGER"), "eventbridge")
+}
+
+// scheduleRequest is the body of POST /api/schedules
+type scheduleRequest struct {
+	Name      string `json:"name"`
+	Cron      string `json:"cron"`
+	FileID    string `json:"file_id"`
+	Prefix    string `json:"prefix"`
+	Processor string `json:"processor"`
+}
+
+// validate checks the request and returns its parsed expression
+func (req *scheduleRequest) validate(now time.Time) (*cron.Schedule, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		return nil, fmt.Errorf("name is required and must be at most 100 characters")
+	}
+	expr, err := cron.Parse(req.Cron)
+	if err != nil {
+		return nil, err
+	}
+	if expr.Next(now).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never fires", req.Cron)
+	}
+	if expr.Interval(now) < minScheduleInterval {
+		return nil, fmt.Errorf("schedules may run at most every %s", minScheduleInterval)
+	}
+	if (req.FileID == "") == (req.Prefix == "") {
+		return nil, fmt.Errorf("exactly one of file_id and prefix is required")
+	}
+	if req.Processor != "" && processor.Get(req.Processor) == nil {
+		return nil, fmt.Errorf("unknown processor %q", req.Processor)
+	}
+	return expr, nil
+}
+
+type scheduleResponse struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Cron      string     `json:"cron"`
+	FileID    string     `json:"file_id,omitempty"`
+	Prefix    string     `json:"prefix,omitempty"`
+	Processor string     `json:"processor,omitempty"`
+	Enabled   bool       `json:"enabled"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func newScheduleResponse(s database.Schedule) scheduleResponse {
+	resp := scheduleResponse{
+		ID:        s.ID,
+		Name:      s.Name,
+		Cron:      s.Cron,
+		FileID:    s.FileID,
+		Prefix:    s.Prefix,
+		Processor: s.Processor,
+		Enabled:   s.Enabled,
+		LastRunAt: s.LastRunAt,
+		CreatedAt: s.CreatedAt,
+	}
+	if s.Enabled {
+		resp.NextRunAt = &s.NextRunAt
+	}
+	return resp
+}
+
+type scheduleRunResponse struct {
+	ID        string         `json:"id"`
+	StartedAt time.Time      `json:"started_at"`
+	Files     int            `json:"files"`
+	Failed    int            `json:"failed"`
+	Error     string         `json:"error,omitempty"`
+	Statuses  map[string]int `json:"statuses"`
+}
+
+// createScheduleHandler registers a recurring re-run of processing for one
+// of the caller's files, or for every file whose name starts with a prefix
+func createScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	var req scheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	now := time.Now().UTC()
+	expr, err := req.validate(now)
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, err.Error())
+		return
+	}
+
+	userID := auth.UserIDFromContext(r.Context())
+	if req.FileID != "" {
+		f, err := database.GetFileByID(req.FileID)
+		if err != nil {
+			log.Printf("Error loading file %s: %v", req.FileID, err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file")
+			return
+		}
+		if f == nil || f.UserID != userID {
+			apierrors.Respond(w, r, apierrors.CodeFileNotFound, "File not found")
+			return
+		}
+	}
+	if req.Processor != "" {
+		owner, err := tenants.For(userID)
+		if err != nil {
+			log.Printf("Error loading settings of user %s: %v", userID, err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading settings")
+			return
+		}
+		if !owner.ProcessorEnabled(req.Processor) {
+			apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("Processor %s is disabled", req.Processor))
+			return
+		}
+	}
+
+	n, err := database.CountSchedules(userID)
+	if err != nil {
+		log.Printf("Error counting schedules: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating schedule")
+		return
+	}
+	if n >= maxSchedulesPerUser {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("At most %d schedules are allowed", maxSchedulesPerUser))
+		return
+	}
+
+	s, err := database.SaveSchedule(database.Schedule{
+		UserID:    userID,
+		Name:      req.Name,
+		Cron:      req.Cron,
+		FileID:    req.FileID,
+		Prefix:    req.Prefix,
+		Processor: req.Processor,
+		NextRunAt: expr.Next(now),
+	})
+	if err != nil {
+		log.Printf("Error saving schedule: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating schedule")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newScheduleResponse(*s))
+}
+
+// listSchedulesHandler lists the caller's schedules
+func listSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	schedules, err := database.ListSchedules(userID)
+	if err != nil {
+		log.Printf("Error listing schedules: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing schedules")
+		return
+	}
+
+	items := make([]scheduleResponse, 0, len(schedules))
+	for _, s := range schedules {
+		items = append(items, newScheduleResponse(s))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"schedules": items,
+	})
+}
+
+// getScheduleHandler returns one of the caller's schedules
+func getScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := ownedSchedule(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newScheduleResponse(*s))
+}
+
+// listScheduleRunsHandler lists a schedule's most recent runs, each with its
+// files counted by the status of their new result
+func listScheduleRunsHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := ownedSchedule(w, r)
+	if !ok {
+		return
+	}
+	runs, err := database.ListScheduleRuns(s.ID, scheduleRunsLimit)
+	if err != nil {
+		log.Printf("Error listing runs of schedule %s: %v", s.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing schedule runs")
+		return
+	}
+
+	items := make([]scheduleRunResponse, 0, len(runs))
+	for _, run := range runs {
+		items = append(items, scheduleRunResponse{
+			ID:        run.ID,
+			StartedAt: run.StartedAt,
+			Files:     run.Files,
+			Failed:    run.Failed,
+			Error:     run.Error,
+			Statuses:  run.Statuses,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"runs": items,
+	})
+}
+
+// deleteScheduleHandler removes one of the caller's schedules with its run
+// history. Files already queued by a run are still processed.
+func deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := auth.UserIDFromContext(r.Context())
+
+	deleted, err := database.DeleteSchedule(vars["id"], userID)
+	if err != nil {
+		log.Printf("Error deleting schedule: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error deleting schedule")
+		return
+	}
+	if !deleted {
+		apierrors.Respond(w, r, apierrors.CodeScheduleNotFound, "Schedule not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownedSchedule loads the schedule named in the path if it belongs to the
+// caller, writing an error response and returning false otherwise
+func ownedSchedule(w http.ResponseWriter, r *http.Request) (*database.Schedule, bool) {
+	vars := mux.Vars(r)
+	userID := auth.UserIDFromContext(r.Context())
+
+	s, err := database.GetSchedule(vars["id"], userID)
+	if err != nil {
+		log.Printf("Error loading schedule: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving schedule")
+		return nil, false
+	}
+	if s == nil {
+		apierrors.Respond(w, r, apierrors.CodeScheduleNotFound, "Schedule not found")
+		return nil, false
+	}
+	return s, true
+}
+
+// runSchedulesHandler starts the runs of every schedule that is due, for an
+// EventBridge rule driving schedules with SCHEDULE_TRIGGER=eventbridge
+func runSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	started := runDueSchedules(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"runs": started,
+	})
+}
+
+// runDueSchedules starts a run of each schedule that is due, queueing its
+// files for processing, and returns how many runs it started
+func runDueSchedules(ctx context.Context) int {
+	runs, err := database.StartDueScheduleRuns(time.Now().UTC(), schedulerBatchSize, maxScheduleFiles)
+	if err != nil {
+		log.Printf("Error starting schedule runs: %v", err)
+		return 0
+	}
+
+	for _, run := range runs {
+		log.Printf("Running schedule: id=%s, run_id=%s, files=%d", run.Schedule.ID, run.Run.ID, len(run.Files))
+		events := make([]queue.FileEvent, 0, len(run.Files))
+		for _, f := range run.Files {
+			events = append(events, queue.FileEvent{FileID: f.ID, Bucket: bucketName, Key: f.S3Key, ScheduleRunID: run.Run.ID, Processor: run.Schedule.Processor})
+		}
+		var failed int
+		var lastErr error
+		for i, err := range queue.PublishFileEvents(ctx, events) {
+			if err != nil {
+				log.Printf("Error queueing file %s for schedule run %s: %v", events[i].FileID, run.Run.ID, err)
+				failed++
+				lastErr = err
+			}
+		}
+		if failed == 0 {
+			continue
+		}
+		if err := database.FinishScheduleRun(run.Run.ID, failed, lastErr.Error()); err != nil {
+			log.Printf("Error recording schedule run %s: %v", run.Run.ID, err)
+		}
+	}
+	return len(runs)
+}
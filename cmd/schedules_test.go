@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleRequestValidate(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	req := scheduleRequest{Name: " nightly ", Cron: "0 2 * * *", Prefix: "reports/"}
+	expr, err := req.validate(now)
+	require.NoError(t, err)
+	assert.Equal(t, "nightly", req.Name)
+	assert.Equal(t, time.Date(2024, 5, 2, 2, 0, 0, 0, time.UTC), expr.Next(now))
+
+	for name, req := range map[string]scheduleRequest{
+		"no name":           {Cron: "@daily", Prefix: "a"},
+		"bad cron":          {Name: "x", Cron: "0 2 * *", Prefix: "a"},
+		"never fires":       {Name: "x", Cron: "0 0 31 2 *", Prefix: "a"},
+		"too frequent":      {Name: "x", Cron: "*/5 * * * *", Prefix: "a"},
+		"no target":         {Name: "x", Cron: "@daily"},
+		"both targets":      {Name: "x", Cron: "@daily", Prefix: "a", FileID: "f"},
+		"unknown processor": {Name: "x", Cron: "@daily", Prefix: "a", Processor: "nope"},
+	} {
+		_, err := req.validate(now)
+		assert.Error(t, err, name)
+	}
+}
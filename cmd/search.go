@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/pagination"
+	"github.com/yourusername/golang-aws-api/search"
+)
+
+// searchBackend serves file search, Postgres unless SEARCH_BACKEND says otherwise
+var searchBackend search.Backend
+
+// searchResult is a file in search responses, with its relevance and
+// highlighted matches
+type searchResult struct {
+	fileSummary
+	Rank      float64 `json:"rank"`
+	Highlight struct {
+		Name    string `json:"name"`
+		Content string `json:"content,omitempty"`
+	} `json:"highlight"`
+}
+
+// newSearchResult describes a hit on f. Callers who may not see personal
+// data get no content highlight for a file that has or waits for a redacted
+// copy, as its index entry may hold the original text.
+func newSearchResult(r *http.Request, f database.File, h search.Hit) searchResult {
+	item := searchResult{
+		fileSummary: newFileSummary(f),
+		Rank:        h.Rank,
+	}
+	item.Highlight.Name = h.NameHighlight
+	if !(f.Redacted() || f.RedactionPending) || auth.PrincipalFromContext(r.Context()).CanReadPII() {
+		item.Highlight.Content = h.ContentHighlight
+	}
+	return item
+}
+
+// searchFilesHandler runs a full-text search over the caller's file names and
+// extracted content, most relevant first
+func searchFilesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "q is required")
+		return
+	}
+	params, ok := paginationParams(w, r)
+	if !ok {
+		return
+	}
+
+	hits, err := searchBackend.Search(r.Context(), userID, query, params.After, params.Limit)
+	if err != nil {
+		log.Printf("Error searching files: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error searching files")
+		return
+	}
+	hits, next := pagination.Trim(hits, params.Limit, search.Hit.Cursor)
+
+	ids := make([]string, 0, len(hits))
+	for _, h := range hits {
+		ids = append(ids, h.FileID)
+	}
+	files, err := database.GetFilesByIDs(ids)
+	if err != nil {
+		log.Printf("Error loading search results: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error searching files")
+		return
+	}
+
+	items := make([]searchResult, 0, len(hits))
+	for _, h := range hits {
+		// An external index can briefly lag behind deleted files
+		f, ok := files[h.FileID]
+		if !ok {
+			continue
+		}
+		items = append(items, newSearchResult(r, f, h))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files":       items,
+		"next_cursor": next,
+	})
+}
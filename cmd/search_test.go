@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/search"
+)
+
+func TestNewSearchResultHidesPersonalData(t *testing.T) {
+	hit := search.Hit{FileID: "f1", NameHighlight: "<b>contacts</b>.txt", ContentHighlight: "mail <b>jane@example.com</b>"}
+	redacted := database.File{ID: "f1", Name: "contacts.txt", RedactedKey: "redacted/f1/r1"}
+	pending := database.File{ID: "f1", Name: "contacts.txt", RedactionPending: true}
+	plain := database.File{ID: "f1", Name: "contacts.txt"}
+
+	user := httptest.NewRequest("GET", "/", nil)
+	user = user.WithContext(auth.WithPrincipal(user.Context(), &auth.Principal{UserID: "u1", Username: "jane", Roles: []string{auth.RoleUser}}))
+	for _, f := range []database.File{redacted, pending} {
+		item := newSearchResult(user, f, hit)
+		assert.Equal(t, "<b>contacts</b>.txt", item.Highlight.Name)
+		assert.Empty(t, item.Highlight.Content)
+	}
+	assert.Equal(t, hit.ContentHighlight, newSearchResult(user, plain, hit).Highlight.Content)
+
+	reader := httptest.NewRequest("GET", "/", nil)
+	reader = reader.WithContext(auth.WithPrincipal(reader.Context(), &auth.Principal{UserID: "u1", Username: "jane", Roles: []string{auth.RoleUser, auth.RolePIIReader}}))
+	assert.Equal(t, hit.ContentHighlight, newSearchResult(reader, redacted, hit).Highlight.Content)
+}
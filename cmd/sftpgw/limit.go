@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Failed sign-ins allowed per username and per client address: a burst of
+// signInBurst, then one every signInEvery
+const (
+	signInBurst = 5
+	signInEvery = time.Minute
+	// maxSignInBuckets is how many buckets are kept before full ones are
+	// dropped; a full bucket behaves the same as a missing one
+	maxSignInBuckets = 10000
+)
+
+// bucket is a token bucket of failures left for one username or address
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// signInLimiter limits failed sign-ins, so passwords can't be guessed by
+// opening more connections or by spreading guesses over many usernames
+type signInLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func newSignInLimiter() *signInLimiter {
+	return &signInLimiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// signIns is the limiter for the SSH auth callbacks
+var signIns = newSignInLimiter()
+
+// signInKeys returns the buckets a connection's sign-ins count against
+func signInKeys(meta ssh.ConnMetadata) []string {
+	host := meta.RemoteAddr().String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return []string{"user:" + strings.ToLower(meta.User()), "ip:" + host}
+}
+
+// blocked reports whether any of keys has no failures left
+func (l *signInLimiter) blocked(keys []string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if l.refill(key).tokens < 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// fail records a failed sign-in against each of keys
+func (l *signInLimiter) fail(keys []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		b := l.refill(key)
+		b.tokens = math.Max(0, b.tokens-1)
+	}
+}
+
+// refill returns key's bucket with the failures earned back since it was
+// last used. l.mu must be held.
+func (l *signInLimiter) refill(key string) *bucket {
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxSignInBuckets {
+			l.prune(now)
+		}
+		b = &bucket{tokens: signInBurst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(signInBurst, b.tokens+float64(now.Sub(b.last))/float64(signInEvery))
+	b.last = now
+	return b
+}
+
+// prune drops buckets that have refilled completely
+func (l *signInLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+float64(now.Sub(b.last))/float64(signInEvery) >= signInBurst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignInLimiter(t *testing.T) {
+	now := time.Now()
+	l := newSignInLimiter()
+	l.now = func() time.Time { return now }
+
+	alice := []string{"user:alice", "ip:192.0.2.1"}
+	for i := 0; i < signInBurst; i++ {
+		assert.False(t, l.blocked(alice))
+		l.fail(alice)
+	}
+	assert.True(t, l.blocked(alice))
+
+	// Other usernames from the same address are blocked too, and so is the
+	// username from other addresses
+	assert.True(t, l.blocked([]string{"user:bob", "ip:192.0.2.1"}))
+	assert.True(t, l.blocked([]string{"user:alice", "ip:198.51.100.7"}))
+	assert.False(t, l.blocked([]string{"user:bob", "ip:198.51.100.7"}))
+
+	now = now.Add(signInEvery)
+	assert.False(t, l.blocked(alice))
+	l.fail(alice)
+	assert.True(t, l.blocked(alice))
+}
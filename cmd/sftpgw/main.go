@@ -0,0 +1,234 @@
+// Command sftpgw is an SFTP drop box for partners that can't use the HTTP
+// API. Users sign in with their API username and password or with an SSH key
+// registered through /api/ssh-keys; every file they upload is stored and
+// processed exactly like an HTTP upload.
+//
+//	go run ./cmd/sftpgw -listen :2022 -host-key sftpgw_host_key
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/envelope"
+	"github.com/yourusername/golang-aws-api/ingest"
+	"github.com/yourusername/golang-aws-api/queue"
+	"github.com/yourusername/golang-aws-api/settings"
+	"github.com/yourusername/golang-aws-api/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+// userIDExtension carries the authenticated user's ID from the auth callbacks
+// to the connection
+const userIDExtension = "user-id"
+
+func main() {
+	listen := flag.String("listen", getEnv("SFTP_LISTEN", ":2022"), "address to listen on")
+	hostKeyPath := flag.String("host-key", getEnv("SFTP_HOST_KEY", "sftpgw_host_key"), "host key file, created if missing")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := awsconfig.Load(ctx)
+	if err != nil {
+		log.Fatalf("Failed to setup AWS: %v", err)
+	}
+	queue.InitQueue(cfg)
+	keys := envelope.NewKMS(cfg)
+	database.SetColumnEncryption(keys, os.Getenv("COLUMN_ENCRYPTION_KEY_ID"))
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	settings.Init(ctx)
+
+	hostKey, err := loadHostKey(*hostKeyPath)
+	if err != nil {
+		log.Fatalf("Failed to load host key: %v", err)
+	}
+	config := &ssh.ServerConfig{
+		MaxAuthTries:      3,
+		PasswordCallback:  passwordCallback,
+		PublicKeyCallback: publicKeyCallback,
+	}
+	config.AddHostKey(hostKey)
+
+	bucketName := getEnv("S3_BUCKET_NAME", "my-test-bucket")
+	gw := &gateway{store: &ingest.Store{
+		S3:     storage.New(cfg, bucketName),
+		Bucket: bucketName,
+		Keys:   keys,
+	}}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *listen, err)
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	log.Printf("SFTP gateway listening on %s", *listen)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Error accepting connection: %v", err)
+			continue
+		}
+		go gw.serveConn(ctx, conn, config)
+	}
+}
+
+// errTooManySignIns rejects sign-ins for a username or address over the
+// failed sign-in limit
+var errTooManySignIns = errors.New("too many failed sign-ins, try again later")
+
+// passwordCallback signs users in with their API credentials. Failures count
+// against both the username and the client's address.
+func passwordCallback(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	keys := signInKeys(meta)
+	if signIns.blocked(keys) {
+		log.Printf("Password sign in refused for %s from %s: too many failures", meta.User(), meta.RemoteAddr())
+		return nil, errTooManySignIns
+	}
+	user, err := auth.MockAuthenticate(meta.User(), string(password))
+	if err != nil {
+		signIns.fail(keys)
+		log.Printf("Password sign in failed for %s from %s: %v", meta.User(), meta.RemoteAddr(), err)
+		return nil, errors.New("invalid credentials")
+	}
+	return &ssh.Permissions{Extensions: map[string]string{userIDExtension: user.ID}}, nil
+}
+
+// publicKeyCallback signs users in with a registered key. The SSH username
+// must match the key's owner, ignoring case. Clients offer every key they
+// have, so unknown keys aren't counted as failures, but usernames and
+// addresses over the limit can't sign in with a key either.
+func publicKeyCallback(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if signIns.blocked(signInKeys(meta)) {
+		return nil, errTooManySignIns
+	}
+	user, err := database.GetUserBySSHKey(ssh.FingerprintSHA256(key))
+	if err != nil {
+		log.Printf("Error looking up SSH key: %v", err)
+		return nil, errors.New("invalid credentials")
+	}
+	if user == nil || !strings.EqualFold(user.Username, meta.User()) || !user.Confirmed {
+		return nil, errors.New("invalid credentials")
+	}
+	return &ssh.Permissions{Extensions: map[string]string{userIDExtension: user.ID}}, nil
+}
+
+// serveConn runs the SSH handshake and serves the sftp subsystem on every
+// session channel of the connection
+func (gw *gateway) serveConn(ctx context.Context, conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		log.Printf("SSH handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	userID := sshConn.Permissions.Extensions[userIDExtension]
+	log.Printf("SFTP session for %s from %s", sshConn.User(), sshConn.RemoteAddr())
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			log.Printf("Error accepting channel: %v", err)
+			continue
+		}
+		go gw.serveSession(ctx, channel, requests, userID)
+	}
+}
+
+// serveSession waits for the client to ask for the sftp subsystem and serves
+// it; shells and commands are refused
+func (gw *gateway) serveSession(ctx context.Context, channel ssh.Channel, requests <-chan *ssh.Request, userID string) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "subsystem" || subsystemName(req.Payload) != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		store := func(name string, f *os.File, size int64) error {
+			return gw.save(ctx, userID, name, f, size)
+		}
+		if err := newSFTPServer(channel, store, settings.Current().MaxFileBytes).Serve(); err != nil && err != io.EOF {
+			log.Printf("SFTP session ended with error: %v", err)
+		}
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		return
+	}
+}
+
+// subsystemName decodes the name from a subsystem request payload
+func subsystemName(payload []byte) string {
+	var msg struct{ Name string }
+	if err := ssh.Unmarshal(payload, &msg); err != nil {
+		return ""
+	}
+	return msg.Name
+}
+
+// loadHostKey reads the server's host key, generating and saving an Ed25519
+// key on first start
+func loadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(data)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	log.Printf("Generating host key %s", path)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "sftpgw")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, fmt.Errorf("saving host key: %v", err)
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// Helper function to get environment variables
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
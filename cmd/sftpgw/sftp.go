@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// errUploadsOnly is sent for every request a drop box doesn't support
+var errUploadsOnly = errors.New("this server only accepts uploads")
+
+// storeFunc saves a finished upload. f is positioned at the start of the
+// content.
+type storeFunc func(name string, f *os.File, size int64) error
+
+// newSFTPServer serves a flat, write-only root directory on rwc: uploads
+// are spooled to temporary files and handed to store when the client closes
+// them. Reading, listing existing files, renames and subdirectories are not
+// supported.
+func newSFTPServer(rwc io.ReadWriteCloser, store storeFunc, maxBytes int64) *sftp.RequestServer {
+	h := &dropBox{store: store, maxBytes: maxBytes}
+	return sftp.NewRequestServer(rwc, sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h})
+}
+
+// dropBox implements the sftp request handlers for newSFTPServer
+type dropBox struct {
+	store    storeFunc
+	maxBytes int64
+}
+
+func (d *dropBox) Fileread(*sftp.Request) (io.ReaderAt, error) {
+	return nil, sftp.ErrSSHFxPermissionDenied
+}
+
+func (d *dropBox) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	p := path.Clean("/" + r.Filepath)
+	if path.Dir(p) != "/" || p == "/" {
+		return nil, errors.New("files can only be uploaded to the root directory")
+	}
+
+	f, err := os.CreateTemp("", "sftp-*")
+	if err != nil {
+		log.Printf("Error creating upload file: %v", err)
+		return nil, errors.New("error creating file")
+	}
+	return &upload{name: path.Base(p), f: f, store: d.store, maxBytes: d.maxBytes}, nil
+}
+
+// Filecmd accepts the setstat clients send after uploading; times and
+// permissions don't apply here. Everything else is refused.
+func (d *dropBox) Filecmd(r *sftp.Request) error {
+	if r.Method == "Setstat" {
+		return nil
+	}
+	return errUploadsOnly
+}
+
+// Filelist reports the root as an empty directory; earlier uploads are not
+// listed
+func (d *dropBox) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	if path.Clean("/"+r.Filepath) != "/" {
+		return nil, os.ErrNotExist
+	}
+	switch r.Method {
+	case "List":
+		return listerAt(nil), nil
+	case "Stat", "Lstat":
+		return listerAt{rootInfo{}}, nil
+	default:
+		return nil, errUploadsOnly
+	}
+}
+
+// upload is a file being written by the client
+type upload struct {
+	name     string
+	f        *os.File
+	store    storeFunc
+	maxBytes int64
+
+	mu     sync.Mutex
+	size   int64
+	failed bool
+}
+
+func (u *upload) WriteAt(data []byte, offset int64) (int, error) {
+	end := offset + int64(len(data))
+	if u.maxBytes > 0 && end > u.maxBytes {
+		return 0, fmt.Errorf("file exceeds the %d byte limit", u.maxBytes)
+	}
+	n, err := u.f.WriteAt(data, offset)
+	if err != nil {
+		log.Printf("Error writing upload %s: %v", u.name, err)
+		return n, errors.New("error writing file")
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if end > u.size {
+		u.size = end
+	}
+	return n, nil
+}
+
+// TransferError is called when the session ends with the file still open;
+// the upload is discarded rather than stored incomplete
+func (u *upload) TransferError(error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.failed = true
+}
+
+// Close finishes the upload by handing it to store. Its error is reported
+// to the client.
+func (u *upload) Close() error {
+	defer os.Remove(u.f.Name())
+	defer u.f.Close()
+
+	u.mu.Lock()
+	failed, size := u.failed, u.size
+	u.mu.Unlock()
+	if failed {
+		return nil
+	}
+
+	if _, err := u.f.Seek(0, io.SeekStart); err != nil {
+		return errors.New("error reading file")
+	}
+	return u.store(u.name, u.f, size)
+}
+
+// listerAt lists a fixed set of entries
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(dst []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(dst, l[offset:])
+	if n < len(dst) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// rootInfo describes the root directory
+type rootInfo struct{}
+
+func (rootInfo) Name() string       { return "/" }
+func (rootInfo) Size() int64        { return 0 }
+func (rootInfo) Mode() os.FileMode  { return os.ModeDir | 0o755 }
+func (rootInfo) ModTime() time.Time { return time.Time{} }
+func (rootInfo) IsDir() bool        { return true }
+func (rootInfo) Sys() any           { return nil }
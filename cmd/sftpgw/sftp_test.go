@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSFTPUpload(t *testing.T) {
+	server, conn := net.Pipe()
+
+	stored := make(map[string]string)
+	store := func(name string, f *os.File, size int64) error {
+		b, err := io.ReadAll(f)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(b)), size)
+		stored[name] = string(b)
+		return nil
+	}
+	go newSFTPServer(server, store, 16).Serve()
+
+	client, err := sftp.NewClientPipe(conn, conn)
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Create("/inbox/report.csv")
+	assert.Error(t, err)
+
+	f, err := client.Create("report.csv")
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("world"), 6)
+	assert.NoError(t, err)
+	_, err = f.WriteAt([]byte("hello "), 0)
+	assert.NoError(t, err)
+	_, err = f.WriteAt([]byte("over the limit"), 11)
+	assert.ErrorContains(t, err, "byte limit")
+	assert.NoError(t, f.Close())
+	assert.Equal(t, map[string]string{"report.csv": "hello world"}, stored)
+
+	_, err = client.Open("report.csv")
+	assert.Error(t, err, "uploads can't be read back")
+
+	wd, err := client.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, "/", wd)
+	info, err := client.Stat("/")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	entries, err := client.ReadDir("/")
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	assert.Error(t, client.Rename("report.csv", "other.csv"))
+	assert.Error(t, client.Symlink("report.csv", "link"))
+}
+
+func TestSFTPStoreError(t *testing.T) {
+	server, conn := net.Pipe()
+	store := func(string, *os.File, int64) error {
+		return os.ErrExist
+	}
+	go newSFTPServer(server, store, 0).Serve()
+
+	client, err := sftp.NewClientPipe(conn, conn)
+	require.NoError(t, err)
+	defer client.Close()
+
+	f, err := client.Create("report.csv")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.ErrorContains(t, f.Close(), os.ErrExist.Error(), "store errors are shown to the client")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/ingest"
+)
+
+// gateway stores uploaded files the same way the HTTP upload does
+type gateway struct {
+	store *ingest.Store
+}
+
+// save stores an uploaded file for userID. Errors are returned in a form
+// that can be shown to the SFTP client.
+func (gw *gateway) save(ctx context.Context, userID, name string, f *os.File, size int64) error {
+	file, err := gw.store.Save(ctx, userID, name, f, size)
+	var apiErr *apierrors.Error
+	switch {
+	case err == nil:
+		log.Printf("Stored SFTP upload: id=%s, name=%s, size=%d", file.ID, file.Name, size)
+		return nil
+	case errors.As(err, &apiErr):
+		return errors.New(apiErr.Detail)
+	case errors.Is(err, ingest.ErrMaintenance), errors.Is(err, database.ErrDuplicateFileName):
+		return err
+	default:
+		log.Printf("Error storing SFTP upload %s: %v", name, err)
+		return errors.New("error saving file")
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// Wrong passwords allowed per share link: a burst of sharePasswordBurst,
+// then sharePasswordAttemptsPerMinute. The limit is per link rather than per
+// client so guessing can't be spread over many addresses.
+const (
+	sharePasswordAttemptsPerMinute = 1
+	sharePasswordBurst             = 5
+)
+
+// sharePasswordFailures counts wrong passwords per share
+var sharePasswordFailures = newRateLimiter()
+
+// Share link lifetimes when the client doesn't ask for one, and the longest allowed
+const (
+	defaultShareTTL = 7 * 24 * time.Hour
+	maxShareTTL     = 30 * 24 * time.Hour
+)
+
+// shareResponse is a share link in API responses
+type shareResponse struct {
+	ID                string     `json:"id"`
+	FileID            string     `json:"file_id"`
+	URL               string     `json:"url,omitempty"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	MaxDownloads      int64      `json:"max_downloads,omitempty"`
+	Downloads         int        `json:"downloads"`
+	PasswordProtected bool       `json:"password_protected"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	Active            bool       `json:"active"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+func newShareResponse(s *database.Share) shareResponse {
+	resp := shareResponse{
+		ID:                s.ID,
+		FileID:            s.FileID,
+		ExpiresAt:         s.ExpiresAt,
+		MaxDownloads:      s.MaxDownloads.Int64,
+		Downloads:         s.Downloads,
+		PasswordProtected: s.PasswordHash != "",
+		Active:            s.Active(time.Now()),
+		CreatedAt:         s.CreatedAt,
+	}
+	if s.RevokedAt.Valid {
+		resp.RevokedAt = &s.RevokedAt.Time
+	}
+	return resp
+}
+
+// newShareToken returns a random URL-safe share token
+func newShareToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// shareURL returns the public link for a share token
+func shareURL(r *http.Request, token string) string {
+	return publicURL(r, "/share/"+token)
+}
+
+// publicURL returns the public address of path on this API. PUBLIC_BASE_URL
+// is used when the API sits behind a proxy or a different public host name.
+func publicURL(r *http.Request, path string) string {
+	base := os.Getenv("PUBLIC_BASE_URL")
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return strings.TrimRight(base, "/") + path
+}
+
+// createShareHandler creates a public link to a file with an expiry, an
+// optional download limit and an optional password
+func createShareHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	var req struct {
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
+		MaxDownloads     int    `json:"max_downloads"`
+		Password         string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.ExpiresInSeconds < 0 || req.MaxDownloads < 0 {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "expires_in_seconds and max_downloads must not be negative")
+		return
+	}
+	ttl := defaultShareTTL
+	if req.ExpiresInSeconds > 0 {
+		ttl = time.Duration(req.ExpiresInSeconds) * time.Second
+	}
+	if ttl > maxShareTTL {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("Share links can't last longer than %s", maxShareTTL))
+		return
+	}
+
+	f := authorizeFile(w, r, fileID)
+	if f == nil {
+		return
+	}
+
+	var passwordHash string
+	if req.Password != "" {
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			log.Printf("Error hashing share password: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating share")
+			return
+		}
+		passwordHash = hash
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		log.Printf("Error generating share token: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating share")
+		return
+	}
+
+	userID := auth.UserIDFromContext(r.Context())
+	share, err := database.SaveShare(token, f.ID, userID, time.Now().Add(ttl), req.MaxDownloads, passwordHash)
+	if err != nil {
+		log.Printf("Error saving share: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating share")
+		return
+	}
+
+	// The token is only ever returned here
+	resp := newShareResponse(share)
+	resp.URL = shareURL(r, share.Token)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// listSharesHandler lists the share links the caller created, newest first
+func listSharesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	params, ok := paginationParams(w, r)
+	if !ok {
+		return
+	}
+
+	shares, err := database.ListSharesByUser(userID, params.After, params.Limit)
+	if err != nil {
+		log.Printf("Error listing shares: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing shares")
+		return
+	}
+	shares, next := pagination.Trim(shares, params.Limit, func(s database.Share) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: s.CreatedAt, ID: s.ID}
+	})
+
+	items := make([]shareResponse, 0, len(shares))
+	for i := range shares {
+		items = append(items, newShareResponse(&shares[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"shares":      items,
+		"next_cursor": next,
+	})
+}
+
+// revokeShareHandler revokes one of the caller's share links
+func revokeShareHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shareID := vars["id"]
+	userID := auth.UserIDFromContext(r.Context())
+
+	revoked, err := database.RevokeShare(shareID, userID)
+	if err != nil {
+		log.Printf("Error revoking share: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error revoking share")
+		return
+	}
+	if !revoked {
+		apierrors.Respond(w, r, apierrors.CodeShareNotFound, "Share not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publicShareHandler streams the file behind a share link. Password protected
+// links take the password in the X-Share-Password header or, for forms, the
+// password field of a POST body. It is never read from the URL, which ends
+// up in logs and browser history.
+func publicShareHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	token := vars["token"]
+
+	share, err := database.GetShareByToken(token)
+	if err != nil {
+		log.Printf("Error loading share: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving share")
+		return
+	}
+	if share == nil {
+		apierrors.Respond(w, r, apierrors.CodeShareNotFound, "Share not found")
+		return
+	}
+	if !share.Active(time.Now()) {
+		apierrors.Respond(w, r, apierrors.CodeShareExpired, "Share link has expired")
+		return
+	}
+
+	if share.PasswordHash != "" && !checkSharePassword(w, r, share) {
+		return
+	}
+
+	f, err := database.GetFileByID(share.FileID)
+	if err != nil || f == nil {
+		log.Printf("Error loading shared file %s: %v", share.FileID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file")
+		return
+	}
+
+	// A download refused while the file waits for redaction isn't counted
+	f, variant := servedFile(w, r, f)
+	if f == nil {
+		return
+	}
+
+	// Count the download before streaming so the limit holds under concurrency
+	ok, err := database.ConsumeShareDownload(share.ID)
+	if err != nil {
+		log.Printf("Error counting share download: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving share")
+		return
+	}
+	if !ok {
+		apierrors.Respond(w, r, apierrors.CodeShareExpired, "Share link has expired")
+		return
+	}
+
+	result, err := getFileObject(r.Context(), f)
+	if err != nil {
+		log.Printf("Error retrieving from S3: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file content")
+		return
+	}
+	defer result.Body.Close()
+
+	content, _, err := objectContent(r.Context(), f.ID, f.Encryption, result)
+	if err != nil {
+		log.Printf("Error decrypting shared file %s: %v", f.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file content")
+		return
+	}
+
+	recordAccess(r, f.ID, database.AccessShare, variant)
+
+	w.Header().Set("Content-Type", fileContentType(f))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", f.Name))
+	if _, err := io.Copy(w, content); err != nil {
+		log.Printf("Error streaming shared file %s: %v", f.ID, err)
+	}
+}
+
+// checkSharePassword verifies the password given for a protected share,
+// responding and returning false when it is wrong or the share has seen too
+// many wrong ones lately
+func checkSharePassword(w http.ResponseWriter, r *http.Request, share *database.Share) bool {
+	if blocked, wait := sharePasswordFailures.blocked(share.ID, sharePasswordAttemptsPerMinute, sharePasswordBurst); blocked {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		apierrors.Respond(w, r, apierrors.CodeRateLimited, "Too many wrong passwords, retry later")
+		return false
+	}
+
+	password := r.Header.Get("X-Share-Password")
+	if password == "" && r.Method == http.MethodPost {
+		password = r.PostFormValue("password")
+	}
+	if !auth.CheckPassword(share.PasswordHash, password) {
+		sharePasswordFailures.allow(share.ID, sharePasswordAttemptsPerMinute, sharePasswordBurst)
+		apierrors.Respond(w, r, apierrors.CodeSharePassword, "Invalid share password")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func TestCheckSharePassword(t *testing.T) {
+	hash, err := auth.HashPassword("hunter2")
+	require.NoError(t, err)
+	share := &database.Share{ID: "share-password-test", PasswordHash: hash}
+
+	check := func(r *http.Request) int {
+		w := httptest.NewRecorder()
+		if checkSharePassword(w, r, share) {
+			return http.StatusOK
+		}
+		return w.Code
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/share/token", nil)
+	r.Header.Set("X-Share-Password", "hunter2")
+	assert.Equal(t, http.StatusOK, check(r))
+
+	form := url.Values{"password": {"hunter2"}}
+	r = httptest.NewRequest(http.MethodPost, "/share/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.Equal(t, http.StatusOK, check(r))
+
+	// Passwords in the URL end up in logs, so they aren't accepted
+	r = httptest.NewRequest(http.MethodGet, "/share/token?password=hunter2", nil)
+	assert.Equal(t, http.StatusUnauthorized, check(r))
+
+	for i := 1; i < sharePasswordBurst; i++ {
+		r = httptest.NewRequest(http.MethodGet, "/share/token", nil)
+		r.Header.Set("X-Share-Password", "guess")
+		assert.Equal(t, http.StatusUnauthorized, check(r))
+	}
+
+	// Once the link has seen too many wrong passwords even the right one
+	// has to wait
+	r = httptest.NewRequest(http.MethodGet, "/share/token", nil)
+	r.Header.Set("X-Share-Password", "hunter2")
+	assert.Equal(t, http.StatusTooManyRequests, check(r))
+}
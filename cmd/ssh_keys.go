@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshKeyResponse is the JSON form of a registered SSH key
+type sshKeyResponse struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Fingerprint string    `json:"fingerprint"`
+	PublicKey   string    `json:"public_key"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func newSSHKeyResponse(k database.SSHKey) sshKeyResponse {
+	return sshKeyResponse{
+		ID:          k.ID,
+		Name:        k.Name,
+		Fingerprint: k.Fingerprint,
+		PublicKey:   k.PublicKey,
+		CreatedAt:   k.CreatedAt,
+	}
+}
+
+// createSSHKeyHandler registers a public key, in authorized_keys format, that
+// the caller can use to sign in to the SFTP gateway
+func createSSHKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string `json:"name"`
+		PublicKey string `json:"public_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "public_key must be an SSH public key in authorized_keys format")
+		return
+	}
+	if req.Name == "" {
+		req.Name = comment
+	}
+
+	userID := auth.UserIDFromContext(r.Context())
+	publicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	k, err := database.SaveSSHKey(userID, req.Name, ssh.FingerprintSHA256(key), publicKey)
+	if errors.Is(err, database.ErrDuplicateSSHKey) {
+		apierrors.Respond(w, r, apierrors.CodeDuplicateSSHKey, "This SSH key is already registered")
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving SSH key: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error saving SSH key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newSSHKeyResponse(*k))
+}
+
+// listSSHKeysHandler lists the caller's SSH keys
+func listSSHKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	keys, err := database.ListSSHKeys(userID)
+	if err != nil {
+		log.Printf("Error listing SSH keys: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing SSH keys")
+		return
+	}
+
+	items := make([]sshKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, newSSHKeyResponse(k))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ssh_keys": items,
+	})
+}
+
+// deleteSSHKeyHandler removes one of the caller's SSH keys
+func deleteSSHKeyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := auth.UserIDFromContext(r.Context())
+
+	deleted, err := database.DeleteSSHKey(vars["id"], userID)
+	if err != nil {
+		log.Printf("Error deleting SSH key: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error deleting SSH key")
+		return
+	}
+	if !deleted {
+		apierrors.Respond(w, r, apierrors.CodeSSHKeyNotFound, "SSH key not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/processor"
+	"github.com/yourusername/golang-aws-api/settings"
+	"github.com/yourusername/golang-aws-api/tenants"
+)
+
+// textStatsResponse is the JSON form of a file's text statistics
+type textStatsResponse struct {
+	FileID      string                 `json:"file_id"`
+	Language    string                 `json:"language"`
+	Words       int                    `json:"words"`
+	Sentences   int                    `json:"sentences"`
+	Characters  int64                  `json:"characters"`
+	Readability *processor.Readability `json:"readability,omitempty"`
+	TopTerms    json.RawMessage        `json:"top_terms"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+}
+
+func newTextStatsResponse(s database.TextStats) textStatsResponse {
+	resp := textStatsResponse{
+		FileID:     s.FileID,
+		Language:   s.Language,
+		Words:      s.Words,
+		Sentences:  s.Sentences,
+		Characters: s.Characters,
+		TopTerms:   s.TopTerms,
+		UpdatedAt:  s.UpdatedAt,
+	}
+	if s.FleschReadingEase != nil && s.FleschKincaidGrade != nil {
+		resp.Readability = &processor.Readability{
+			FleschReadingEase:  *s.FleschReadingEase,
+			FleschKincaidGrade: *s.FleschKincaidGrade,
+		}
+	}
+	return resp
+}
+
+// fileStatsHandler returns the text statistics of one file
+func fileStatsHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["id"]
+	if authorizeFile(w, r, fileID) == nil {
+		return
+	}
+
+	stats, err := database.GetTextStats(fileID)
+	if err != nil {
+		log.Printf("Error loading text statistics: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading text statistics")
+		return
+	}
+	if stats == nil {
+		apierrors.Respond(w, r, apierrors.CodeResultNotFound, "No text statistics for this file")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newTextStatsResponse(*stats))
+}
+
+// storageStats is what a user stores against their quotas. Zero limits mean
+// no limit.
+type storageStats struct {
+	UserID   string `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+	MaxFiles int    `json:"max_files"`
+	MaxBytes int64  `json:"max_bytes"`
+}
+
+func newStorageStats(files int, bytes int64, limits tenants.Settings) storageStats {
+	return storageStats{Files: files, Bytes: bytes, MaxFiles: limits.MaxFiles, MaxBytes: limits.MaxBytes}
+}
+
+// statsHandler aggregates the text statistics of the caller's files, or of
+// those in one language with ?language=, and reports what the caller stores.
+// Administrators can add ?tenants=true for every user's storage and the
+// service's total.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	language := strings.ToLower(r.URL.Query().Get("language"))
+	userID := auth.UserIDFromContext(r.Context())
+	withTenants := r.URL.Query().Get("tenants") == "true"
+	if withTenants && !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Only administrators can see every tenant's storage")
+		return
+	}
+
+	summary, err := database.GetTextStatsSummary(userID, language, processor.TopTerms)
+	if err != nil {
+		log.Printf("Error loading text statistics: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading text statistics")
+		return
+	}
+
+	files, bytes, err := database.UserUsage(userID)
+	if err != nil {
+		log.Printf("Error loading storage of user %s: %v", userID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading storage statistics")
+		return
+	}
+	limits, err := tenants.For(userID)
+	if err != nil {
+		log.Printf("Error loading settings of user %s: %v", userID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading storage statistics")
+		return
+	}
+
+	resp := map[string]interface{}{
+		"text": map[string]interface{}{
+			"files":                   summary.Files,
+			"words":                   summary.Words,
+			"sentences":               summary.Sentences,
+			"avg_flesch_reading_ease": summary.AvgReadingEase,
+			"languages":               summary.Languages,
+			"top_terms":               summary.TopTerms,
+		},
+		"storage": newStorageStats(files, bytes, limits),
+	}
+	if withTenants {
+		perTenant, total, err := tenantStorageStats()
+		if err != nil {
+			log.Printf("Error loading tenant storage: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading storage statistics")
+			return
+		}
+		resp["tenants"] = perTenant
+		resp["total"] = total
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// tenantStorageStats returns what every user with files stores against their
+// quotas, largest first, and the total over all files including those
+// without an owner
+func tenantStorageStats() ([]storageStats, map[string]interface{}, error) {
+	totals, err := database.GetStorageTotals()
+	if err != nil {
+		return nil, nil, err
+	}
+	overrides, err := database.ListTenantSettings()
+	if err != nil {
+		return nil, nil, err
+	}
+	byUser := make(map[string]*database.TenantSettings, len(overrides))
+	for i := range overrides {
+		byUser[overrides[i].UserID] = &overrides[i]
+	}
+
+	s := settings.Current()
+	items := make([]storageStats, 0, len(totals))
+	for _, t := range totals {
+		item := newStorageStats(t.Files, t.Bytes, tenants.Apply(s, t.UserID, byUser[t.UserID]))
+		item.UserID, item.Username = t.UserID, t.Username
+		items = append(items, item)
+	}
+
+	files, bytes, err := database.TotalUsage()
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, map[string]interface{}{"files": files, "bytes": bytes}, nil
+}
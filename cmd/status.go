@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/processor"
+)
+
+// maxStatusFiles caps how many files a single status request can ask about
+const maxStatusFiles = 500
+
+// fileStatus is the JSON form of one file in a bulk status response. Files
+// that don't exist, or that the caller can't access, have the status
+// not_found; files without a result yet have the status waiting while a
+// dependency hasn't completed, and processing otherwise, with the progress
+// of a long-running pipeline once it has reported some.
+type fileStatus struct {
+	ID        string     `json:"id"`
+	Status    string     `json:"status"`
+	ResultID  string     `json:"result_id,omitempty"`
+	Summary   string     `json:"summary,omitempty"`
+	Truncated bool       `json:"truncated,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// DependsOn are the files this one waits for, with the status of their
+	// latest result, so the dependency graph can be followed
+	DependsOn []dependencyStatus  `json:"depends_on,omitempty"`
+	Progress  *processor.Progress `json:"progress,omitempty"`
+}
+
+// dependencyStatus is a file another file depends on. Status is empty while
+// it has no result.
+type dependencyStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// fileStatusHandler reports the current processing status of many files at
+// once, so clients that uploaded a batch can poll it with one request. Each
+// result is summarised by its first database.ResultSummaryLength characters.
+func fileStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FileIDs []string `json:"file_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if len(req.FileIDs) == 0 {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "file_ids is required")
+		return
+	}
+	if len(req.FileIDs) > maxStatusFiles {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("At most %d files can be checked at once", maxStatusFiles))
+		return
+	}
+
+	found, err := database.GetFileStatuses(req.FileIDs)
+	if err != nil {
+		log.Printf("Error loading file statuses: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file statuses")
+		return
+	}
+
+	deps, err := database.ListFileDependencies(req.FileIDs)
+	if err != nil {
+		log.Printf("Error loading file dependencies: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file statuses")
+		return
+	}
+
+	progress, err := database.GetProcessingProgress(req.FileIDs)
+	if err != nil {
+		log.Printf("Error loading processing progress: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file statuses")
+		return
+	}
+
+	p := auth.PrincipalFromContext(r.Context())
+	items := make([]fileStatus, 0, len(req.FileIDs))
+	seen := make(map[string]bool, len(req.FileIDs))
+	for _, id := range req.FileIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		status := newFileStatus(id, found[id], deps[id], p)
+		if pr, ok := progress[id]; ok && status.Status == "processing" {
+			status.Progress = newProgress(pr)
+		}
+		items = append(items, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": items,
+	})
+}
+
+// newProgress is the API form of a file's saved progress
+func newProgress(p database.ProcessingProgress) *processor.Progress {
+	return &processor.Progress{
+		Stage:     p.Stage,
+		Stages:    p.Stages,
+		Processor: p.Processor,
+		Done:      p.Done,
+		Total:     p.Total,
+		Percent:   p.Percent,
+	}
+}
+
+func newFileStatus(id string, fs database.FileStatus, deps []database.FileDependency, p *auth.Principal) fileStatus {
+	if fs.FileID == "" || !p.CanAccess(fs.UserID) {
+		return fileStatus{ID: id, Status: "not_found"}
+	}
+	var dependsOn []dependencyStatus
+	waiting := false
+	for _, d := range deps {
+		dependsOn = append(dependsOn, dependencyStatus{ID: d.DependsOn, Status: d.Status})
+		waiting = waiting || d.Status != "completed"
+	}
+	if fs.Result == nil {
+		status := "processing"
+		if waiting {
+			status = "waiting"
+		}
+		return fileStatus{ID: id, Status: status, DependsOn: dependsOn}
+	}
+	return fileStatus{
+		ID:        id,
+		Status:    fs.Result.Status,
+		ResultID:  fs.Result.ID,
+		Summary:   fs.Result.Result,
+		Truncated: fs.Truncated,
+		UpdatedAt: &fs.Result.UpdatedAt,
+		DependsOn: dependsOn,
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func TestNewFileStatus(t *testing.T) {
+	owner := &auth.Principal{UserID: "u1"}
+	other := &auth.Principal{UserID: "u2"}
+	updated := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	done := database.FileStatus{
+		FileID:    "f1",
+		UserID:    "u1",
+		Result:    &database.ProcessingResult{ID: "r1", Status: "completed", Result: "Word count: 2", UpdatedAt: updated},
+		Truncated: true,
+	}
+
+	assert.Equal(t, fileStatus{ID: "f1", Status: "completed", ResultID: "r1", Summary: "Word count: 2", Truncated: true, UpdatedAt: &updated},
+		newFileStatus("f1", done, nil, owner))
+	assert.Equal(t, fileStatus{ID: "f1", Status: "not_found"}, newFileStatus("f1", done, nil, other))
+	assert.Equal(t, fileStatus{ID: "f2", Status: "not_found"}, newFileStatus("f2", database.FileStatus{}, nil, owner))
+	assert.Equal(t, fileStatus{ID: "f3", Status: "processing"}, newFileStatus("f3", database.FileStatus{FileID: "f3", UserID: "u1"}, nil, owner))
+}
+
+func TestNewFileStatusWithDependencies(t *testing.T) {
+	owner := &auth.Principal{UserID: "u1"}
+	pending := database.FileStatus{FileID: "f2", UserID: "u1"}
+	deps := []database.FileDependency{
+		{FileID: "f2", DependsOn: "f1", Status: "completed"},
+		{FileID: "f2", DependsOn: "f0"},
+	}
+
+	assert.Equal(t, fileStatus{ID: "f2", Status: "waiting", DependsOn: []dependencyStatus{{ID: "f1", Status: "completed"}, {ID: "f0"}}},
+		newFileStatus("f2", pending, deps, owner))
+
+	// Once every dependency completed the file is being processed
+	deps[1].Status = "completed"
+	assert.Equal(t, "processing", newFileStatus("f2", pending, deps, owner).Status)
+}
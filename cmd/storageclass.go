@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/settings"
+	"github.com/yourusername/golang-aws-api/storage"
+)
+
+// classPolicy picks the storage class of uploads. It defaults to the
+// runtime settings and can be swapped for another policy.
+var classPolicy storage.ClassPolicy = storage.ClassPolicyFunc(func(userID, requested string) (string, error) {
+	return settings.Current().StorageClass(userID, requested)
+})
+
+// storageClass resolves the storage class of a new upload, writing a 400
+// response and returning false if the requested class isn't allowed
+func storageClass(w http.ResponseWriter, r *http.Request, userID, requested string) (string, bool) {
+	class, err := classPolicy.StorageClass(userID, storage.NormalizeClass(requested))
+	if errors.Is(err, storage.ErrClassNotAllowed) {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, err.Error())
+		return "", false
+	}
+	if err != nil {
+		log.Printf("Error choosing storage class: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error choosing storage class")
+		return "", false
+	}
+	return class, true
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+const (
+	// defaultStuckAfter is how long a file has to wait for its first result
+	// before it counts as stuck, unless ?older_than= says otherwise
+	defaultStuckAfter = time.Hour
+	// minStuckAfter keeps files that may still be in a Lambda invocation,
+	// which runs for at most 15 minutes, from being requeued
+	minStuckAfter = 15 * time.Minute
+	// maxStuckFiles caps how many stuck files are listed or requeued at once
+	maxStuckFiles = 500
+)
+
+type stuckFileResponse struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	UserID       string    `json:"user_id,omitempty"`
+	Attempts     int       `json:"attempts"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastActivity time.Time `json:"last_activity"`
+	JobID        string    `json:"job_id,omitempty"`
+}
+
+func newStuckFileResponse(s database.StuckFile) stuckFileResponse {
+	return stuckFileResponse{
+		ID:           s.ID,
+		Name:         s.Name,
+		UserID:       s.UserID,
+		Attempts:     s.Attempts,
+		LastError:    s.LastError,
+		LastActivity: s.LastActivity,
+		JobID:        s.JobID,
+	}
+}
+
+// parseStuckAfter parses how long a file must have been waiting to count as
+// stuck
+func parseStuckAfter(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultStuckAfter, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("older_than must be a duration such as 2h")
+	}
+	if d < minStuckAfter {
+		return 0, fmt.Errorf("older_than must be at least %s", minStuckAfter)
+	}
+	return d, nil
+}
+
+// listStuckFilesHandler lists files that have waited longer than
+// ?older_than=, default an hour, for their first result without being
+// attempted. Only administrators can see them.
+func listStuckFilesHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	after, err := parseStuckAfter(r.URL.Query().Get("older_than"))
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, err.Error())
+		return
+	}
+	limit := maxStuckFiles
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStuckFiles {
+			apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("limit must be between 1 and %d", maxStuckFiles))
+			return
+		}
+		limit = n
+	}
+
+	stuck, err := database.ListStuckFiles(time.Now().Add(-after), limit)
+	if err != nil {
+		log.Printf("Error listing stuck files: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing stuck files")
+		return
+	}
+
+	items := make([]stuckFileResponse, 0, len(stuck))
+	for _, s := range stuck {
+		items = append(items, newStuckFileResponse(s))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": items,
+	})
+}
+
+// requeueStuckFilesHandler resets the attempts of stuck files and sends their
+// processing messages again: the listed file_ids, or every stuck file up to
+// the listing limit without them. Files that got a result or were attempted
+// in the meantime are left alone.
+func requeueStuckFilesHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	var req struct {
+		FileIDs   []string `json:"file_ids"`
+		OlderThan string   `json:"older_than"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	after, err := parseStuckAfter(req.OlderThan)
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, err.Error())
+		return
+	}
+	if len(req.FileIDs) > maxStuckFiles {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("At most %d files can be requeued at once", maxStuckFiles))
+		return
+	}
+
+	olderThan := time.Now().Add(-after)
+	ids := req.FileIDs
+	if len(ids) == 0 {
+		stuck, err := database.ListStuckFiles(olderThan, maxStuckFiles)
+		if err != nil {
+			log.Printf("Error listing stuck files: %v", err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error requeueing stuck files")
+			return
+		}
+		for _, s := range stuck {
+			ids = append(ids, s.ID)
+		}
+	}
+
+	requeued, failed, err := requeueStuckFiles(r.Context(), ids, olderThan)
+	if err != nil {
+		log.Printf("Error requeueing stuck files: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error requeueing stuck files")
+		return
+	}
+	if requeued == nil {
+		requeued = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"requeued": requeued,
+		"failed":   failed,
+	})
+}
+
+// requeueStuckFiles claims those of ids that are still stuck since before
+// olderThan and sends their processing messages again. It returns the files
+// requeued and, by file ID, why the others that were claimed couldn't be
+// sent. Each message carries a requeue ID, which the worker deduplicates on
+// and which makes it skip a file processed since.
+func requeueStuckFiles(ctx context.Context, ids []string, olderThan time.Time) ([]string, map[string]string, error) {
+	claimed, err := database.ClaimStuckFiles(ids, olderThan)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events := make([]queue.FileEvent, 0, len(claimed))
+	for _, s := range claimed {
+		events = append(events, queue.FileEvent{FileID: s.ID, Bucket: bucketName, Key: s.S3Key, JobID: s.JobID, RequeueID: database.NewID()})
+	}
+	var requeued []string
+	failed := map[string]string{}
+	for i, err := range queue.PublishFileEvents(ctx, events) {
+		if err != nil {
+			log.Printf("Error requeueing file %s: %v", events[i].FileID, err)
+			failed[events[i].FileID] = err.Error()
+			continue
+		}
+		log.Printf("Requeued stuck file %s", events[i].FileID)
+		requeued = append(requeued, events[i].FileID)
+	}
+	return requeued, failed, nil
+}
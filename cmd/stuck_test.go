@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStuckAfter(t *testing.T) {
+	d, err := parseStuckAfter("")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultStuckAfter, d)
+
+	d, err = parseStuckAfter("2h")
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, d)
+
+	_, err = parseStuckAfter("5m")
+	assert.Error(t, err)
+	_, err = parseStuckAfter("soon")
+	assert.Error(t, err)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/processor"
+	"github.com/yourusername/golang-aws-api/tenants"
+)
+
+// tenantSettingsBody is a user's settings overrides in API requests and
+// responses. Fields that are null or missing keep the global setting.
+type tenantSettingsBody struct {
+	UserID            string   `json:"user_id,omitempty"`
+	MaxFileBytes      *int64   `json:"max_file_bytes"`
+	MaxFiles          *int     `json:"max_files"`
+	MaxBytes          *int64   `json:"max_bytes"`
+	AllowedFileTypes  []string `json:"allowed_file_types"`
+	FileExpiryDays    *int     `json:"file_expiry_days"`
+	EnabledProcessors []string `json:"enabled_processors"`
+	// Bytes per second across all of the user's uploads and downloads
+	UploadBytesPerSecond   *int64     `json:"upload_bytes_per_second"`
+	DownloadBytesPerSecond *int64     `json:"download_bytes_per_second"`
+	UpdatedBy              string     `json:"updated_by,omitempty"`
+	UpdatedAt              *time.Time `json:"updated_at,omitempty"`
+}
+
+func newTenantSettingsBody(t database.TenantSettings) tenantSettingsBody {
+	return tenantSettingsBody{
+		UserID:                 t.UserID,
+		MaxFileBytes:           t.MaxFileBytes,
+		MaxFiles:               t.MaxFiles,
+		MaxBytes:               t.MaxBytes,
+		AllowedFileTypes:       t.AllowedFileTypes,
+		FileExpiryDays:         t.FileExpiryDays,
+		EnabledProcessors:      t.EnabledProcessors,
+		UploadBytesPerSecond:   t.UploadBytesPerSecond,
+		DownloadBytesPerSecond: t.DownloadBytesPerSecond,
+		UpdatedBy:              t.UpdatedBy,
+		UpdatedAt:              &t.UpdatedAt,
+	}
+}
+
+// validate checks the overrides and normalises the file types
+func (b *tenantSettingsBody) validate() error {
+	if (b.MaxFileBytes != nil && *b.MaxFileBytes < 0) || (b.MaxFiles != nil && *b.MaxFiles < 0) || (b.MaxBytes != nil && *b.MaxBytes < 0) {
+		return fmt.Errorf("quotas must not be negative")
+	}
+	if b.FileExpiryDays != nil && *b.FileExpiryDays < 0 {
+		return fmt.Errorf("file_expiry_days must not be negative")
+	}
+	if (b.UploadBytesPerSecond != nil && *b.UploadBytesPerSecond < 0) || (b.DownloadBytesPerSecond != nil && *b.DownloadBytesPerSecond < 0) {
+		return fmt.Errorf("upload_bytes_per_second and download_bytes_per_second must not be negative")
+	}
+	for i, ext := range b.AllowedFileTypes {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if len(ext) < 2 || !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("allowed_file_types must be extensions like .csv, got %q", b.AllowedFileTypes[i])
+		}
+		b.AllowedFileTypes[i] = ext
+	}
+	for _, name := range b.EnabledProcessors {
+		if processor.Get(name) == nil {
+			return fmt.Errorf("enabled_processors: unknown processor %q", name)
+		}
+	}
+	return nil
+}
+
+// tenantMaxFileBytes returns the per-file limit of a user's uploads, writing
+// an error response and returning false if their settings can't be loaded
+func tenantMaxFileBytes(w http.ResponseWriter, r *http.Request, userID string) (int64, bool) {
+	s, err := tenants.For(userID)
+	if err != nil {
+		log.Printf("Error loading settings of user %s: %v", userID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading settings")
+		return 0, false
+	}
+	return s.MaxFileBytes, true
+}
+
+// redactionPending reports whether a file called name uploaded by userID
+// waits for the pii stage before its content is served, writing an error
+// response and returning false if the user's pipeline can't be loaded
+func redactionPending(w http.ResponseWriter, r *http.Request, userID, name string) (bool, bool) {
+	pending, err := tenants.RedactsPII(userID, name)
+	if err != nil {
+		log.Printf("Error loading pipeline of user %s: %v", userID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading pipeline")
+		return false, false
+	}
+	return pending, true
+}
+
+// listTenantSettingsHandler lists every user's settings overrides. Only
+// administrators can see them.
+func listTenantSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	list, err := database.ListTenantSettings()
+	if err != nil {
+		log.Printf("Error listing tenant settings: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing tenant settings")
+		return
+	}
+
+	items := make([]tenantSettingsBody, 0, len(list))
+	for _, t := range list {
+		items = append(items, newTenantSettingsBody(t))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tenants": items,
+	})
+}
+
+// getTenantSettingsHandler returns a user's settings overrides. Only
+// administrators can see them.
+func getTenantSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	t, err := database.GetTenantSettings(mux.Vars(r)["user_id"])
+	if err != nil {
+		log.Printf("Error loading tenant settings: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error loading tenant settings")
+		return
+	}
+	if t == nil {
+		apierrors.Respond(w, r, apierrors.CodeTenantSettingsNotFound, "The user has no settings overrides")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newTenantSettingsBody(*t))
+}
+
+// setTenantSettingsHandler replaces a user's settings overrides. They apply
+// to uploads, downloads, processing and file expiry; other API instances and the worker
+// pick them up within a short cache time. Only administrators can set them.
+func setTenantSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	userID := mux.Vars(r)["user_id"]
+
+	var req tenantSettingsBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if err := req.validate(); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, err.Error())
+		return
+	}
+	user, err := database.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error loading user %s: %v", userID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error saving tenant settings")
+		return
+	}
+	if user == nil {
+		apierrors.Respond(w, r, apierrors.CodeUserNotFound, "User not found")
+		return
+	}
+
+	t, err := database.SaveTenantSettings(database.TenantSettings{
+		UserID:                 userID,
+		MaxFileBytes:           req.MaxFileBytes,
+		MaxFiles:               req.MaxFiles,
+		MaxBytes:               req.MaxBytes,
+		AllowedFileTypes:       req.AllowedFileTypes,
+		FileExpiryDays:         req.FileExpiryDays,
+		EnabledProcessors:      req.EnabledProcessors,
+		UploadBytesPerSecond:   req.UploadBytesPerSecond,
+		DownloadBytesPerSecond: req.DownloadBytesPerSecond,
+		UpdatedBy:              p.Username,
+	})
+	if err != nil {
+		log.Printf("Error saving tenant settings: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error saving tenant settings")
+		return
+	}
+	tenants.Invalidate(userID)
+	log.Printf("Settings overrides of user %s set by %s", userID, p.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newTenantSettingsBody(*t))
+}
+
+// deleteTenantSettingsHandler removes a user's settings overrides, so the
+// global settings apply to them again. Only administrators can remove them.
+func deleteTenantSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	p := auth.PrincipalFromContext(r.Context())
+	if !p.IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+	userID := mux.Vars(r)["user_id"]
+
+	deleted, err := database.DeleteTenantSettings(userID)
+	if err != nil {
+		log.Printf("Error deleting tenant settings: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error deleting tenant settings")
+		return
+	}
+	if !deleted {
+		apierrors.Respond(w, r, apierrors.CodeTenantSettingsNotFound, "The user has no settings overrides")
+		return
+	}
+	tenants.Invalidate(userID)
+	log.Printf("Settings overrides of user %s removed by %s", userID, p.Username)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// updateFileHandler renames a file. The request must carry the version the
+// client last read; a stale version is rejected with 409 Conflict.
+func updateFileHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	var req struct {
+		Name    string `json:"name"`
+		Version int    `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.Name == "" || req.Version < 1 {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "name and version are required")
+		return
+	}
+
+	if authorizeFile(w, r, fileID) == nil {
+		return
+	}
+
+	f, err := database.UpdateFileName(fileID, req.Name, req.Version)
+	if errors.Is(err, database.ErrConflict) {
+		apierrors.Respond(w, r, apierrors.CodeVersionConflict, "File was modified by another request, reload and retry")
+		return
+	}
+	if errors.Is(err, database.ErrDuplicateFileName) {
+		apierrors.Write(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating file %s: %v", fileID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error updating file")
+		return
+	}
+	if f == nil {
+		apierrors.Respond(w, r, apierrors.CodeFileNotFound, "File not found")
+		return
+	}
+	if err := searchBackend.Rename(r.Context(), f.ID, f.Name); err != nil {
+		log.Printf("Error updating search index for file %s: %v", f.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FileData{
+		ID:        f.ID,
+		Name:      f.Name,
+		CreatedAt: f.CreatedAt,
+		UpdatedAt: f.UpdatedAt,
+		Version:   f.Version,
+
+		StorageClass: f.StorageClass,
+		Retention:    newRetentionInfo(f.RetentionMode, f.RetainUntil),
+		Encryption:   newEncryptionInfo(f.Encryption),
+	})
+}
+
+// updateResultHandler overwrites the status and result text of a file's latest
+// processing result, using the same version check as updateFileHandler.
+// Results are written by processing, so owners may only change their files'
+// own metadata and correcting a result is left to administrators.
+func updateResultHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.PrincipalFromContext(r.Context()).IsAdmin() {
+		apierrors.Respond(w, r, apierrors.CodeForbidden, "Administrator access required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	var req struct {
+		Status  string `json:"status"`
+		Result  string `json:"result"`
+		Version int    `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.Status == "" || req.Version < 1 {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "status and version are required")
+		return
+	}
+
+	current, err := database.GetProcessingResultByFileID(fileID)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving processing result")
+		return
+	}
+	if current == nil {
+		apierrors.Respond(w, r, apierrors.CodeResultNotFound, "Processing result not found")
+		return
+	}
+
+	pr, err := database.UpdateProcessingResult(current.ID, req.Version, req.Status, req.Result)
+	if errors.Is(err, database.ErrConflict) {
+		apierrors.Respond(w, r, apierrors.CodeVersionConflict, "Processing result was modified by another request, reload and retry")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating processing result for file %s: %v", fileID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error updating processing result")
+		return
+	}
+	if pr == nil {
+		apierrors.Respond(w, r, apierrors.CodeResultNotFound, "Processing result not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ProcessingResult{
+		ID:        pr.ID,
+		Status:    pr.Status,
+		Result:    pr.Result,
+		CreatedAt: pr.CreatedAt,
+		UpdatedAt: pr.UpdatedAt,
+		Version:   pr.Version,
+	})
+}
+
+// authorizeFile loads a file and checks that the caller may access it, writing
+// an error response and returning nil otherwise. Files uploaded without a
+// token have no owner and can only be accessed by administrators.
+func authorizeFile(w http.ResponseWriter, r *http.Request, fileID string) *database.File {
+	f, err := database.GetFileByID(fileID)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving file")
+		return nil
+	}
+
+	if f == nil || !auth.PrincipalFromContext(r.Context()).CanAccess(f.UserID) {
+		apierrors.Respond(w, r, apierrors.CodeFileNotFound, "File not found")
+		return nil
+	}
+	return f
+}
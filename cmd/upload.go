@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// uploadChunkSize is the size of the pooled buffers uploads are read and
+// copied through
+const uploadChunkSize = 32 << 10
+
+// spoolMemoryBytes is how much of an upload is kept in memory before it
+// spills to a temporary file
+const spoolMemoryBytes = 1 << 20
+
+// maxUploadMetadataBytes caps the fields of an upload other than its content
+const maxUploadMetadataBytes = 64 << 10
+
+// errUploadTooLarge is returned once an upload passes the per-file limit
+var errUploadTooLarge = errors.New("upload exceeds the file size limit")
+
+// uploadChunks holds *[]byte buffers of uploadChunkSize, and spoolBuffers
+// the in-memory part of spools, so a busy server reuses the same few
+// buffers instead of allocating per request
+var (
+	uploadChunks = sync.Pool{New: func() interface{} {
+		b := make([]byte, uploadChunkSize)
+		return &b
+	}}
+	spoolBuffers  = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	uploadReaders = sync.Pool{New: func() interface{} { return bufio.NewReaderSize(nil, uploadChunkSize) }}
+)
+
+// spool collects an upload whose size isn't known up front. Small uploads
+// stay in a pooled buffer and larger ones spill to a temporary file, so the
+// content is held once and can be re-read for encryption and S3 retries.
+type spool struct {
+	limit int64
+	size  int64
+	buf   *bytes.Buffer
+	file  *os.File
+	err   error
+}
+
+// newSpool returns an empty spool that rejects writes past limit bytes. A
+// zero limit is unlimited.
+func newSpool(limit int64) *spool {
+	buf := spoolBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return &spool{limit: limit, buf: buf}
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.limit > 0 && s.size+int64(len(p)) > s.limit {
+		return 0, errUploadTooLarge
+	}
+	if s.file == nil && s.buf.Len()+len(p) > spoolMemoryBytes {
+		if err := s.spill(); err != nil {
+			s.err = err
+			return 0, err
+		}
+	}
+	var n int
+	var err error
+	if s.file != nil {
+		n, err = s.file.Write(p)
+		if err != nil {
+			s.err = fmt.Errorf("error writing upload to disk: %v", err)
+			err = s.err
+		}
+	} else {
+		n, _ = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// spill moves the buffered content to a temporary file
+func (s *spool) spill() error {
+	f, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return fmt.Errorf("error creating upload file: %v", err)
+	}
+	if _, err := f.Write(s.buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("error writing upload to disk: %v", err)
+	}
+	s.file = f
+	s.releaseBuffer()
+	return nil
+}
+
+// Failed reports whether a write failed on the server's side rather than
+// because of the upload itself
+func (s *spool) Failed() bool {
+	return s.err != nil
+}
+
+// Size returns the number of bytes written
+func (s *spool) Size() int64 {
+	return s.size
+}
+
+// Reader returns the content from the start
+func (s *spool) Reader() (io.ReadSeeker, error) {
+	if s.file != nil {
+		if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return s.file, nil
+	}
+	return bytes.NewReader(s.buf.Bytes()), nil
+}
+
+// Close removes the temporary file or returns the buffer to the pool. Readers
+// from Reader must not be used afterwards.
+func (s *spool) Close() error {
+	s.releaseBuffer()
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+func (s *spool) releaseBuffer() {
+	if s.buf == nil {
+		return
+	}
+	// Buffers that grew well past the spill point aren't worth keeping
+	if s.buf.Cap() <= 2*spoolMemoryBytes {
+		spoolBuffers.Put(s.buf)
+	}
+	s.buf = nil
+}
+
+// copyUpload copies src to dst through a pooled buffer
+func copyUpload(dst io.Writer, src io.Reader) (int64, error) {
+	buf := uploadChunks.Get().(*[]byte)
+	defer uploadChunks.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// readUpload reads an upload request into meta and content. JSON bodies carry
+// the content as the "content" string, and multipart/form-data bodies as a
+// "file" part next to an optional "metadata" part holding the other fields as
+// JSON. Either way the content goes straight into content without being held
+// as a string.
+func readUpload(r *http.Request, meta *FileData, content *spool) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return readMultipartUpload(r, meta, content)
+	}
+	return decodeUpload(r.Body, meta, content)
+}
+
+// readMultipartUpload reads a multipart/form-data upload part by part. The
+// file's name defaults to the file part's file name.
+func readMultipartUpload(r *http.Request, meta *FileData, content *spool) error {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	var fileName string
+	var sawFile bool
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch part.FormName() {
+		case "metadata":
+			if err := json.NewDecoder(io.LimitReader(part, maxUploadMetadataBytes)).Decode(meta); err != nil {
+				return fmt.Errorf("invalid metadata part: %v", err)
+			}
+			if meta.Content != "" {
+				return errors.New("content must be sent as the file part")
+			}
+		case "file":
+			if sawFile {
+				return errors.New("only one file part is allowed")
+			}
+			sawFile = true
+			fileName = part.FileName()
+			if _, err := copyUpload(content, part); err != nil {
+				return err
+			}
+		}
+	}
+	if !sawFile {
+		return errors.New("missing file part")
+	}
+	if meta.Name == "" {
+		meta.Name = fileName
+	}
+	return nil
+}
+
+// decodeUpload decodes a JSON upload from body. The fields other than content
+// are collected and decoded into meta as usual, while the content string is
+// unescaped into content as it is read. Keys match the way encoding/json
+// matches them, and a content key is accepted only once.
+func decodeUpload(body io.Reader, meta *FileData, content io.Writer) error {
+	br := uploadReaders.Get().(*bufio.Reader)
+	br.Reset(body)
+	defer func() {
+		br.Reset(nil)
+		uploadReaders.Put(br)
+	}()
+	d := &uploadDecoder{r: br}
+
+	fields := spoolBuffers.Get().(*bytes.Buffer)
+	fields.Reset()
+	defer spoolBuffers.Put(fields)
+
+	if err := d.expect('{'); err != nil {
+		return err
+	}
+	fields.WriteByte('{')
+	c, err := d.next()
+	if err != nil {
+		return err
+	}
+	var key bytes.Buffer
+	var sawContent, sawField bool
+	for c != '}' {
+		if c != '"' {
+			return d.syntaxError(c, "object key")
+		}
+		key.Reset()
+		if err := d.readString(&key); err != nil {
+			return err
+		}
+		if err := d.expect(':'); err != nil {
+			return err
+		}
+		if c, err = d.next(); err != nil {
+			return err
+		}
+
+		if strings.EqualFold(key.String(), "content") {
+			if sawContent {
+				return errors.New("content is given more than once")
+			}
+			sawContent = true
+			switch c {
+			case '"':
+				if err := d.readString(content); err != nil {
+					return err
+				}
+			case 'n':
+				if err := d.readLiteral("null"); err != nil {
+					return err
+				}
+			default:
+				return errors.New("content must be a string")
+			}
+		} else {
+			if sawField {
+				fields.WriteByte(',')
+			}
+			sawField = true
+			k, _ := json.Marshal(key.String())
+			fields.Write(k)
+			fields.WriteByte(':')
+			if err := d.copyValue(fields, c); err != nil {
+				return err
+			}
+			if fields.Len() > maxUploadMetadataBytes {
+				return fmt.Errorf("upload fields exceed %d bytes", maxUploadMetadataBytes)
+			}
+		}
+
+		if c, err = d.next(); err != nil {
+			return err
+		}
+		switch c {
+		case ',':
+			if c, err = d.next(); err != nil {
+				return err
+			}
+		case '}':
+		default:
+			return d.syntaxError(c, "object")
+		}
+	}
+	fields.WriteByte('}')
+	return json.Unmarshal(fields.Bytes(), meta)
+}
+
+// uploadDecoder is the small JSON scanner behind decodeUpload
+type uploadDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *uploadDecoder) syntaxError(c byte, context string) error {
+	return fmt.Errorf("invalid character %q in %s", c, context)
+}
+
+// next returns the next byte that isn't whitespace
+func (d *uploadDecoder) next() (byte, error) {
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return c, nil
+		}
+	}
+}
+
+func (d *uploadDecoder) expect(want byte) error {
+	c, err := d.next()
+	if err != nil {
+		return err
+	}
+	if c != want {
+		return d.syntaxError(c, fmt.Sprintf("place of %q", want))
+	}
+	return nil
+}
+
+// readLiteral reads the rest of lit, whose first byte was already read
+func (d *uploadDecoder) readLiteral(lit string) error {
+	for i := 1; i < len(lit); i++ {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if c != lit[i] {
+			return d.syntaxError(c, "literal "+lit)
+		}
+	}
+	return nil
+}
+
+// readString unescapes a JSON string, whose opening quote was already read,
+// into w. Invalid UTF-8 and unpaired surrogates become U+FFFD, as with
+// encoding/json.
+func (d *uploadDecoder) readString(w io.Writer) error {
+	bufp := uploadChunks.Get().(*[]byte)
+	defer uploadChunks.Put(bufp)
+	out := (*bufp)[:0]
+
+	for {
+		if len(out) > uploadChunkSize-utf8.UTFMax {
+			if _, err := w.Write(out); err != nil {
+				return err
+			}
+			out = out[:0]
+		}
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		switch {
+		case c == '"':
+			_, err := w.Write(out)
+			return err
+		case c == '\\':
+			if out, err = d.readEscape(out); err != nil {
+				return err
+			}
+		case c < 0x20:
+			return d.syntaxError(c, "string literal")
+		case c < utf8.RuneSelf:
+			out = append(out, c)
+		default:
+			d.r.UnreadByte()
+			r, size, err := d.r.ReadRune()
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			if r == utf8.RuneError && size == 1 {
+				out = utf8.AppendRune(out, utf8.RuneError)
+			} else {
+				out = utf8.AppendRune(out, r)
+			}
+		}
+	}
+}
+
+// readEscape appends the character escaped after a backslash to out
+func (d *uploadDecoder) readEscape(out []byte) ([]byte, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return out, unexpectedEOF(err)
+	}
+	switch c {
+	case '"', '\\', '/':
+		return append(out, c), nil
+	case 'b':
+		return append(out, '\b'), nil
+	case 'f':
+		return append(out, '\f'), nil
+	case 'n':
+		return append(out, '\n'), nil
+	case 'r':
+		return append(out, '\r'), nil
+	case 't':
+		return append(out, '\t'), nil
+	case 'u':
+		r, err := d.readHex()
+		if err != nil {
+			return out, err
+		}
+		if utf16.IsSurrogate(r) {
+			// A surrogate only counts when the other half follows right away
+			r2 := utf8.RuneError
+			if next, err := d.r.Peek(6); err == nil && next[0] == '\\' && next[1] == 'u' {
+				if low, ok := parseHex(next[2:]); ok {
+					if dec := utf16.DecodeRune(r, low); dec != utf8.RuneError {
+						d.r.Discard(6)
+						r2 = dec
+					}
+				}
+			}
+			r = r2
+		}
+		return utf8.AppendRune(out, r), nil
+	}
+	return out, d.syntaxError(c, "string escape code")
+}
+
+func (d *uploadDecoder) readHex() (rune, error) {
+	hex, err := d.r.Peek(4)
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	r, ok := parseHex(hex)
+	if !ok {
+		return 0, errors.New("invalid \\u escape in string literal")
+	}
+	d.r.Discard(4)
+	return r, nil
+}
+
+func parseHex(b []byte) (rune, bool) {
+	var r rune
+	for _, c := range b[:4] {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		r = r*16 + rune(c)
+	}
+	return r, true
+}
+
+// copyValue copies the JSON value starting with c to w as it is, leaving
+// validation to json.Unmarshal
+func (d *uploadDecoder) copyValue(w *bytes.Buffer, c byte) error {
+	switch c {
+	case '"':
+		w.WriteByte(c)
+		return d.copyRawString(w)
+	case '{', '[':
+		w.WriteByte(c)
+		depth := 1
+		for depth > 0 {
+			if w.Len() > maxUploadMetadataBytes {
+				return fmt.Errorf("upload fields exceed %d bytes", maxUploadMetadataBytes)
+			}
+			c, err := d.r.ReadByte()
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			w.WriteByte(c)
+			switch c {
+			case '"':
+				if err := d.copyRawString(w); err != nil {
+					return err
+				}
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+		return nil
+	}
+	// A number or literal runs until the next delimiter
+	w.WriteByte(c)
+	for {
+		next, err := d.r.Peek(1)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		switch next[0] {
+		case ',', '}', ']', ' ', '\t', '\n', '\r':
+			return nil
+		}
+		if w.Len() > maxUploadMetadataBytes {
+			return fmt.Errorf("upload fields exceed %d bytes", maxUploadMetadataBytes)
+		}
+		w.WriteByte(next[0])
+		d.r.Discard(1)
+	}
+}
+
+// copyRawString copies a string, whose opening quote was already copied, up
+// to and including its closing quote
+func (d *uploadDecoder) copyRawString(w *bytes.Buffer) error {
+	for {
+		if w.Len() > maxUploadMetadataBytes {
+			return fmt.Errorf("upload fields exceed %d bytes", maxUploadMetadataBytes)
+		}
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		w.WriteByte(c)
+		switch c {
+		case '"':
+			return nil
+		case '\\':
+			c, err := d.r.ReadByte()
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			w.WriteByte(c)
+		}
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
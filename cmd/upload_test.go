@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeUploadMatchesEncodingJSON(t *testing.T) {
+	bodies := []string{
+		`{"name":"a.txt","content":"Hello, World!"}`,
+		`{ "content" : "line\nbreak \"quoted\" \\ \/ \té😀" , "name" : "b.txt" }`,
+		`{"content":"lone \ud800 surrogate \udc00 and \ud800A"}`,
+		"{\"content\":\"bad \xff utf-8 \xe2\x82 end\"}",
+		`{"Content":"case folded","collection_id":"c1","process_delay_seconds":30,"process_at":"2024-01-02T03:04:05Z"}`,
+		`{"name":"nested","id":"x","extra":{"a":[1,"}",{"b":null}],"c":"\"]"},"content":null}`,
+		`{}`,
+	}
+	for _, body := range bodies {
+		var want FileData
+		assert.NoError(t, json.Unmarshal([]byte(body), &want), body)
+
+		var got FileData
+		var content bytes.Buffer
+		assert.NoError(t, decodeUpload(strings.NewReader(body), &got, &content), body)
+		assert.Equal(t, want.Content, content.String(), body)
+		want.Content = ""
+		assert.Equal(t, want, got, body)
+	}
+}
+
+func TestDecodeUploadRejectsInvalidBodies(t *testing.T) {
+	for _, body := range []string{
+		``,
+		`[]`,
+		`{"content":"unterminated`,
+		`{"content":"a","content":"b"}`,
+		`{"content":42}`,
+		`{"content":"bad \x escape"}`,
+		`{"name":"a" "content":"b"}`,
+		`{"name":}`,
+	} {
+		var meta FileData
+		assert.Error(t, decodeUpload(strings.NewReader(body), &meta, io.Discard), body)
+	}
+}
+
+func TestReadMultipartUpload(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("metadata", `{"collection_id":"c1","on_duplicate":"rename"}`)
+	fw, _ := mw.CreateFormFile("file", "report.csv")
+	fw.Write([]byte("a,b\n1,2\n"))
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/api/files", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	var meta FileData
+	content := newSpool(0)
+	defer content.Close()
+	assert.NoError(t, readUpload(req, &meta, content))
+	assert.Equal(t, "report.csv", meta.Name)
+	assert.Equal(t, "c1", meta.CollectionID)
+	assert.Equal(t, "rename", meta.OnDuplicate)
+
+	r, err := content.Reader()
+	assert.NoError(t, err)
+	got, _ := io.ReadAll(r)
+	assert.Equal(t, "a,b\n1,2\n", string(got))
+}
+
+func TestSpool(t *testing.T) {
+	s := newSpool(0)
+	defer s.Close()
+	chunk := bytes.Repeat([]byte("x"), 64<<10)
+	for i := 0; i < 20; i++ {
+		_, err := s.Write(chunk)
+		assert.NoError(t, err)
+	}
+	assert.NotNil(t, s.file, "large uploads spill to disk")
+	assert.Equal(t, int64(20*len(chunk)), s.Size())
+
+	// Readers can be taken again, as S3 retries do
+	for i := 0; i < 2; i++ {
+		r, err := s.Reader()
+		assert.NoError(t, err)
+		n, _ := io.Copy(io.Discard, r)
+		assert.Equal(t, s.Size(), n)
+	}
+
+	limited := newSpool(10)
+	defer limited.Close()
+	_, err := limited.Write([]byte("0123456789a"))
+	assert.ErrorIs(t, err, errUploadTooLarge)
+	assert.False(t, limited.Failed())
+}
+
+// uploadBenchBody is a JSON upload with 1 MiB of escaped text content
+func uploadBenchBody() []byte {
+	content := strings.Repeat("lorem ipsum \"dolor\"\n", 1<<20/20)
+	body, _ := json.Marshal(map[string]string{"name": "bench.txt", "content": content})
+	return body
+}
+
+// BenchmarkUploadDecodeString is the old upload path: the content is decoded
+// into a string and then read again for S3.
+func BenchmarkUploadDecodeString(b *testing.B) {
+	body := uploadBenchBody()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	for i := 0; i < b.N; i++ {
+		var fileData FileData
+		if err := json.NewDecoder(bytes.NewReader(body)).Decode(&fileData); err != nil {
+			b.Fatal(err)
+		}
+		io.Copy(io.Discard, strings.NewReader(fileData.Content))
+	}
+}
+
+// BenchmarkUploadDecodeStream is the current path: the content is unescaped
+// into a pooled spool.
+func BenchmarkUploadDecodeStream(b *testing.B) {
+	body := uploadBenchBody()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	for i := 0; i < b.N; i++ {
+		var fileData FileData
+		content := newSpool(0)
+		if err := decodeUpload(bytes.NewReader(body), &fileData, content); err != nil {
+			b.Fatal(err)
+		}
+		r, _ := content.Reader()
+		io.Copy(io.Discard, r)
+		content.Close()
+	}
+}
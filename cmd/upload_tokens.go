@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/quota"
+)
+
+// Upload token lifetimes when the client doesn't ask for one, and the longest
+// allowed
+const (
+	defaultUploadTokenTTL = 15 * time.Minute
+	maxUploadTokenTTL     = time.Hour
+)
+
+// uploadTokenRetention is how long expired upload tokens are kept, so the
+// file a token was used for can still be looked up
+const uploadTokenRetention = 7 * 24 * time.Hour
+
+// uploadTokenResponse is a newly created upload token
+type uploadTokenResponse struct {
+	ID          string    `json:"id"`
+	Token       string    `json:"token"`
+	UploadURL   string    `json:"upload_url"`
+	Name        string    `json:"name"`
+	SizeBytes   int64     `json:"size_bytes"`
+	ContentType string    `json:"content_type"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// createUploadTokenHandler creates a short-lived token that uploads one file
+// on the caller's behalf. An integrator's backend calls it with an API key
+// and hands the token to a browser, which can then upload exactly the file
+// described, and nothing else, without holding the key.
+func createUploadTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name             string `json:"name"`
+		SizeBytes        int64  `json:"size_bytes"`
+		ContentType      string `json:"content_type"`
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.Name == "" || path.Base(req.Name) != req.Name {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "name must be a file name without a path")
+		return
+	}
+	if req.SizeBytes < 1 {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "size_bytes must be positive")
+		return
+	}
+	contentType, _, err := mime.ParseMediaType(req.ContentType)
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "content_type must be a media type like text/csv")
+		return
+	}
+	if req.ExpiresInSeconds < 0 {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "expires_in_seconds must not be negative")
+		return
+	}
+	ttl := defaultUploadTokenTTL
+	if req.ExpiresInSeconds > 0 {
+		ttl = time.Duration(req.ExpiresInSeconds) * time.Second
+	}
+	if ttl > maxUploadTokenTTL {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("Upload tokens can't last longer than %s", maxUploadTokenTTL))
+		return
+	}
+
+	// Checked now so the integrator hears about it rather than the browser;
+	// the upload itself is checked again
+	userID := auth.UserIDFromContext(r.Context())
+	if err := quota.Check(userID, req.Name, req.SizeBytes); err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		log.Printf("Error generating upload token: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating upload token")
+		return
+	}
+	t, err := database.SaveUploadToken(token, userID, req.Name, req.SizeBytes, contentType, time.Now().Add(ttl))
+	if err != nil {
+		log.Printf("Error saving upload token: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating upload token")
+		return
+	}
+
+	// The token is only ever returned here
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(uploadTokenResponse{
+		ID:          t.ID,
+		Token:       t.Token,
+		UploadURL:   publicURL(r, "/upload/"+t.Token),
+		Name:        t.Name,
+		SizeBytes:   t.SizeBytes,
+		ContentType: t.ContentType,
+		ExpiresAt:   t.ExpiresAt,
+	})
+}
+
+// uploadWithTokenHandler stores the request body as the file an upload token
+// was created for. The body must have the token's content type and exactly
+// its size. The token is used up by a successful upload; one that fails can
+// be retried until the token expires.
+func uploadWithTokenHandler(w http.ResponseWriter, r *http.Request) {
+	allowUploadOrigins(w)
+	t, err := database.ClaimUploadToken(mux.Vars(r)["token"])
+	if err != nil {
+		log.Printf("Error claiming upload token: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error checking upload token")
+		return
+	}
+	if t == nil {
+		apierrors.Respond(w, r, apierrors.CodeUploadTokenInvalid, "Upload token not found, expired or already used")
+		return
+	}
+
+	f := saveTokenUpload(w, r, t)
+	if f == nil {
+		if err := database.ReleaseUploadToken(t.ID); err != nil {
+			log.Printf("Error releasing upload token %s: %v", t.ID, err)
+		}
+		return
+	}
+	if err := database.CompleteUploadToken(t.ID, f.ID); err != nil {
+		log.Printf("Error recording upload of token %s: %v", t.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":      f.ID,
+		"name":    f.Name,
+		"status":  "uploaded",
+		"message": "File uploaded successfully and processing started",
+	})
+}
+
+// saveTokenUpload checks the request body against an upload token and stores
+// it, writing an error response and returning nil if it can't
+func saveTokenUpload(w http.ResponseWriter, r *http.Request, t *database.UploadToken) *database.File {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != t.ContentType {
+		apierrors.Respond(w, r, apierrors.CodeUnsupportedFormat, "Content-Type must be "+t.ContentType)
+		return nil
+	}
+	if r.ContentLength >= 0 && r.ContentLength != t.SizeBytes {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, fmt.Sprintf("Body must be %d bytes", t.SizeBytes))
+		return nil
+	}
+
+	content := newSpool(t.SizeBytes)
+	defer content.Close()
+	defer throttleUpload(r, t.UserID)()
+	_, err := io.Copy(content, r.Body)
+	if err == nil && content.Size() != t.SizeBytes {
+		err = errUploadTooLarge
+	}
+	switch {
+	case errors.Is(err, errUploadTooLarge):
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, fmt.Sprintf("Body must be %d bytes", t.SizeBytes))
+		return nil
+	case content.Failed():
+		log.Printf("Error spooling upload for token %s: %v", t.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error reading upload")
+		return nil
+	case err != nil:
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Error reading request body")
+		return nil
+	}
+	body, err := content.Reader()
+	if err != nil {
+		log.Printf("Error reading spooled upload: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error reading upload")
+		return nil
+	}
+
+	f, err := ingestStore.Save(r.Context(), t.UserID, t.Name, body, t.SizeBytes)
+	if err != nil {
+		var apiErr *apierrors.Error
+		if errors.As(err, &apiErr) || errors.Is(err, database.ErrDuplicateFileName) {
+			apierrors.Write(w, r, err)
+			return nil
+		}
+		log.Printf("Error saving upload for token %s: %v", t.ID, err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error uploading file")
+		return nil
+	}
+	return f
+}
+
+// uploadTokenPreflightHandler answers CORS preflight requests, so browsers
+// on any site can upload with a token. The token is the only credential, so
+// no cookies are involved.
+func uploadTokenPreflightHandler(w http.ResponseWriter, r *http.Request) {
+	allowUploadOrigins(w)
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(maxUploadTokenTTL/time.Second)))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func allowUploadOrigins(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+}
+
+// purgeUploadTokens deletes upload tokens once they have been expired for
+// uploadTokenRetention
+func purgeUploadTokens() {
+	n, err := database.DeleteExpiredUploadTokens(time.Now().Add(-uploadTokenRetention))
+	if err != nil {
+		log.Printf("Error deleting expired upload tokens: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Deleted %d expired upload tokens", n)
+	}
+}
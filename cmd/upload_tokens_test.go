@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func TestSaveTokenUploadChecksBody(t *testing.T) {
+	token := &database.UploadToken{ID: "t1", Name: "a.csv", SizeBytes: 5, ContentType: "text/csv"}
+	upload := func(contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/upload/secret", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		assert.Nil(t, saveTokenUpload(w, r, token))
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, upload("text/plain", "a,b,c").Code)
+	assert.Contains(t, upload("text/csv", "a,b,c,d").Body.String(), "Body must be 5 bytes")
+	assert.Contains(t, upload("text/csv", "a,b").Body.String(), "Body must be 5 bytes")
+}
+
+func TestUploadTokenPreflight(t *testing.T) {
+	w := httptest.NewRecorder()
+	uploadTokenPreflightHandler(w, httptest.NewRequest("OPTIONS", "/upload/secret", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "POST", w.Header().Get("Access-Control-Allow-Methods"))
+}
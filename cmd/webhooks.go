@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+// webhookTimeout bounds a single webhook delivery
+const webhookTimeout = 10 * time.Second
+
+const (
+	// webhookWorkers is how many deliveries are made at once
+	webhookWorkers = 8
+	// webhookBacklog caps the deliveries queued or waiting to be retried
+	webhookBacklog = 1000
+)
+
+// webhookRetry spaces out the attempts at a failing delivery, the last one
+// four and a half minutes after the first
+var webhookRetry = queue.RetryPolicy{MaxAttempts: 6, BaseDelay: 10 * time.Second, MaxDelay: 2 * time.Minute}
+
+// webhookSignatureHeader carries the HMAC-SHA256 of the delivery body, keyed
+// with the webhook's secret, as "sha256=<hex>"
+const webhookSignatureHeader = "X-Webhook-Signature"
+
+// webhookResponse is the JSON form of a webhook. Secret is only set when the
+// webhook is created.
+type webhookResponse struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newWebhookResponse(h database.Webhook) webhookResponse {
+	return webhookResponse{
+		ID:        h.ID,
+		URL:       h.URL,
+		CreatedAt: h.CreatedAt,
+	}
+}
+
+// createWebhookHandler registers a URL that is POSTed each processing result
+// for the caller's files. The signing secret is only shown in this response.
+func createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err == nil {
+		err = validateFetchURL(u)
+	}
+	if err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "url must be an http or https URL")
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error generating webhook secret: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating webhook")
+		return
+	}
+	secret := hex.EncodeToString(b)
+
+	userID := auth.UserIDFromContext(r.Context())
+	h, err := database.SaveWebhook(userID, u.String(), secret)
+	if err != nil {
+		log.Printf("Error saving webhook: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error creating webhook")
+		return
+	}
+
+	resp := newWebhookResponse(*h)
+	resp.Secret = secret
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// listWebhooksHandler lists the caller's webhooks, without their secrets
+func listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	hooks, err := database.ListWebhooks(userID)
+	if err != nil {
+		log.Printf("Error listing webhooks: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error listing webhooks")
+		return
+	}
+
+	items := make([]webhookResponse, 0, len(hooks))
+	for _, h := range hooks {
+		items = append(items, newWebhookResponse(h))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"webhooks": items,
+	})
+}
+
+// deleteWebhookHandler removes one of the caller's webhooks
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := auth.UserIDFromContext(r.Context())
+
+	deleted, err := database.DeleteWebhook(vars["id"], userID)
+	if err != nil {
+		log.Printf("Error deleting webhook: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error deleting webhook")
+		return
+	}
+	if !deleted {
+		apierrors.Respond(w, r, apierrors.CodeWebhookNotFound, "Webhook not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deliverWebhooks queues a completion event for each of the file owner's
+// webhooks. The deliveries are made by webhookQueue's workers, so a slow
+// endpoint doesn't hold up the results queue.
+func deliverWebhooks(n resultNotice) {
+	hooks, err := database.ListWebhooks(n.UserID)
+	if err != nil {
+		log.Printf("Error listing webhooks for user %s: %v", n.UserID, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(n.ResultEvent)
+	if err != nil {
+		log.Printf("Error encoding webhook body: %v", err)
+		return
+	}
+	for _, h := range hooks {
+		webhookQueue.enqueue(webhookDelivery{hook: h, fileID: n.FileID, body: body})
+	}
+}
+
+// webhookDelivery is one event for one webhook, with the attempts made so far
+type webhookDelivery struct {
+	hook     database.Webhook
+	fileID   string
+	body     []byte
+	attempts int
+}
+
+// webhookSender delivers webhooks with a fixed number of workers. At most
+// size deliveries are outstanding, queued or waiting to be retried; more are
+// dropped. Failed deliveries are retried with backoff until retry is
+// exhausted.
+type webhookSender struct {
+	deliveries  chan webhookDelivery
+	outstanding chan struct{}
+	retry       queue.RetryPolicy
+	send        func(ctx context.Context, h database.Webhook, body []byte) error
+}
+
+// webhookQueue delivers the webhooks of this instance, once the results
+// queue is consumed
+var webhookQueue *webhookSender
+
+// newWebhookSender starts workers that deliver with send until ctx is done
+func newWebhookSender(ctx context.Context, workers, size int, retry queue.RetryPolicy, send func(context.Context, database.Webhook, []byte) error) *webhookSender {
+	s := &webhookSender{
+		deliveries:  make(chan webhookDelivery, size),
+		outstanding: make(chan struct{}, size),
+		retry:       retry,
+		send:        send,
+	}
+	for i := 0; i < workers; i++ {
+		go s.work(ctx)
+	}
+	return s
+}
+
+// enqueue queues a new delivery, reporting false if it was dropped because
+// too many are outstanding
+func (s *webhookSender) enqueue(d webhookDelivery) bool {
+	select {
+	case s.outstanding <- struct{}{}:
+	default:
+		log.Printf("Dropping webhook %s for file %s: too many deliveries outstanding", d.hook.ID, d.fileID)
+		return false
+	}
+	s.deliveries <- d
+	return true
+}
+
+// work makes deliveries until ctx is done. A failed delivery is put back on
+// the queue once its backoff has passed; the queue always has room for it,
+// since it still counts as outstanding.
+func (s *webhookSender) work(ctx context.Context) {
+	for {
+		var d webhookDelivery
+		select {
+		case <-ctx.Done():
+			return
+		case d = <-s.deliveries:
+		}
+
+		err := s.send(ctx, d.hook, d.body)
+		if err == nil {
+			<-s.outstanding
+			continue
+		}
+		d.attempts++
+		if s.retry.Exhausted(d.attempts) {
+			log.Printf("Giving up on webhook %s for file %s after %d attempts: %v", d.hook.ID, d.fileID, d.attempts, err)
+			<-s.outstanding
+			continue
+		}
+		log.Printf("Error delivering webhook %s for file %s, retrying: %v", d.hook.ID, d.fileID, err)
+		time.AfterFunc(s.retry.Delay(d.attempts), func() { s.deliveries <- d })
+	}
+}
+
+// deliverWebhook sends one signed delivery. Webhook URLs are user-supplied,
+// so they are called through the fetch client, which refuses internal
+// addresses.
+func deliverWebhook(ctx context.Context, h database.Webhook, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookSignatureHeader, webhookSignature(h.Secret, body))
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookSignature returns the signature header value for body
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
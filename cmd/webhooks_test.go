@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+func TestWebhookSenderRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	done := make(chan string, 2)
+	send := func(_ context.Context, h database.Webhook, _ []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[h.ID]++
+		if h.ID == "flaky" && attempts[h.ID] < 3 {
+			return errors.New("webhook responded 503 Service Unavailable")
+		}
+		if h.ID == "broken" {
+			if attempts[h.ID] == 4 {
+				done <- h.ID
+			}
+			return errors.New("webhook responded 500 Internal Server Error")
+		}
+		done <- h.ID
+		return nil
+	}
+	s := newWebhookSender(ctx, 2, 10, queue.RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}, send)
+
+	assert.True(t, s.enqueue(webhookDelivery{hook: database.Webhook{ID: "flaky"}, fileID: "f1"}))
+	assert.True(t, s.enqueue(webhookDelivery{hook: database.Webhook{ID: "broken"}, fileID: "f1"}))
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("deliveries didn't finish")
+		}
+	}
+	// The last failure is released once it is given up on
+	assert.Eventually(t, func() bool { return len(s.outstanding) == 0 }, time.Second, time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]int{"flaky": 3, "broken": 4}, attempts)
+}
+
+func TestWebhookSenderIsBounded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	defer cancel()
+	defer close(release)
+
+	send := func(context.Context, database.Webhook, []byte) error {
+		<-release
+		return nil
+	}
+	s := newWebhookSender(ctx, 1, 2, webhookRetry, send)
+	assert.True(t, s.enqueue(webhookDelivery{hook: database.Webhook{ID: "w1"}}))
+	assert.True(t, s.enqueue(webhookDelivery{hook: database.Webhook{ID: "w2"}}))
+	assert.False(t, s.enqueue(webhookDelivery{hook: database.Webhook{ID: "w3"}}), "deliveries beyond the backlog are dropped")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/envelope"
+)
+
+// maxZipFiles caps how many files a single zip download can include
+const maxZipFiles = 1000
+
+// zipEntry is a file's S3 object to add to a zip archive under Name
+type zipEntry struct {
+	Name       string
+	FileID     string
+	S3Key      string
+	Encryption envelope.Envelope
+}
+
+// writeZip streams S3 objects into a zip archive written to w. Objects are
+// copied one at a time straight from the S3 response, so memory use doesn't
+// grow with the size or number of files; only encrypted objects are held in
+// memory while they are decrypted.
+func writeZip(ctx context.Context, w io.Writer, entries []zipEntry) error {
+	zw := zip.NewWriter(w)
+	seen := make(map[string]bool)
+
+	for _, entry := range entries {
+		obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(entry.S3Key),
+		})
+		if err != nil {
+			return fmt.Errorf("getting %s: %v", entry.S3Key, err)
+		}
+
+		header := &zip.FileHeader{
+			Name:   uniqueZipName(seen, entry.Name),
+			Method: zip.Deflate,
+		}
+		if obj.LastModified != nil {
+			header.Modified = *obj.LastModified
+		}
+		content, _, err := objectContent(ctx, entry.FileID, entry.Encryption, obj)
+		var fw io.Writer
+		if err == nil {
+			fw, err = zw.CreateHeader(header)
+		}
+		if err == nil {
+			_, err = io.Copy(fw, content)
+		}
+		obj.Body.Close()
+		if err != nil {
+			return fmt.Errorf("adding %s: %v", entry.S3Key, err)
+		}
+	}
+	return zw.Close()
+}
+
+// uniqueZipName returns name, or name with a " (n)" suffix if an entry with
+// that name was already added
+func uniqueZipName(seen map[string]bool, name string) string {
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	unique := name
+	ext := path.Ext(name)
+	for i := 2; seen[unique]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	seen[unique] = true
+	return unique
+}
+
+// downloadZipHandler streams a zip archive of the requested files, given
+// either as a list of file IDs or as a collection to download recursively
+func downloadZipHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FileIDs      []string `json:"file_ids"`
+		CollectionID string   `json:"collection_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierrors.Respond(w, r, apierrors.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if (len(req.FileIDs) == 0) == (req.CollectionID == "") {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, "Exactly one of file_ids or collection_id is required")
+		return
+	}
+
+	if req.CollectionID != "" {
+		c := loadCollection(w, r, req.CollectionID)
+		if c == nil {
+			return
+		}
+		files, paths, err := collectionFiles(c)
+		if err != nil {
+			log.Printf("Error listing collection %s: %v", c.ID, err)
+			apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving collection")
+			return
+		}
+		streamZip(w, r, c.Name+".zip", files, paths)
+		return
+	}
+
+	if len(req.FileIDs) > maxZipFiles {
+		apierrors.Respond(w, r, apierrors.CodeInvalidParameter, fmt.Sprintf("At most %d files can be downloaded at once", maxZipFiles))
+		return
+	}
+	found, err := database.GetFilesByIDs(req.FileIDs)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		apierrors.Respond(w, r, apierrors.CodeInternal, "Error retrieving files")
+		return
+	}
+
+	// Everything is checked up front, since once the archive starts streaming
+	// there's no way to report an error to the client
+	p := auth.PrincipalFromContext(r.Context())
+	files := make([]database.File, 0, len(req.FileIDs))
+	for _, id := range req.FileIDs {
+		f, ok := found[id]
+		if !ok || !p.CanAccess(f.UserID) {
+			apierrors.Respond(w, r, apierrors.CodeFileNotFound, "File not found: "+id)
+			return
+		}
+		files = append(files, f)
+	}
+	streamZip(w, r, "files.zip", files, nil)
+}
+
+// streamZip writes files to the response as a zip archive named filename.
+// Each file is stored under its path from paths, or under its name if it has
+// none. A file servedFile refuses refuses the whole archive.
+func streamZip(w http.ResponseWriter, r *http.Request, filename string, files []database.File, paths map[string]string) {
+	entries := make([]zipEntry, 0, len(files))
+	variants := make([]string, 0, len(files))
+	for _, f := range files {
+		name := f.Name
+		if p, ok := paths[f.ID]; ok {
+			name = p
+		}
+		served, variant := servedFile(w, r, &f)
+		if served == nil {
+			return
+		}
+		entries = append(entries, zipEntry{Name: name, FileID: f.ID, S3Key: served.S3Key, Encryption: f.Encryption})
+		variants = append(variants, variant)
+	}
+	for i, entry := range entries {
+		recordAccess(r, entry.FileID, database.AccessDownload, variants[i])
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if err := writeZip(r.Context(), w, entries); err != nil {
+		// Headers are already sent, so all we can do is log
+		log.Printf("Error streaming %s: %v", filename, err)
+	}
+}
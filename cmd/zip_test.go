@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUniqueZipName(t *testing.T) {
+	seen := make(map[string]bool)
+	assert.Equal(t, "report.txt", uniqueZipName(seen, "report.txt"))
+	assert.Equal(t, "report (2).txt", uniqueZipName(seen, "report.txt"))
+	assert.Equal(t, "report (3).txt", uniqueZipName(seen, "report.txt"))
+	assert.Equal(t, "docs/report.txt", uniqueZipName(seen, "docs/report.txt"))
+
+	// Entries can't escape the archive root
+	assert.Equal(t, "etc/passwd", uniqueZipName(seen, "../../etc/passwd"))
+}
@@ -0,0 +1,195 @@
+// Package comprehend detects personal data in text with Amazon Comprehend.
+// Only DetectPiiEntities is needed, so it is called directly through
+// Comprehend's JSON protocol rather than through an SDK client.
+package comprehend
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+)
+
+// MaxTextBytes is the most text Comprehend checks in one request
+const MaxTextBytes = 100 * 1000
+
+// Entity is a piece of personal data Comprehend found. The offsets count
+// characters, as Comprehend reports them.
+type Entity struct {
+	Type        string
+	Score       float64
+	BeginOffset int
+	EndOffset   int
+}
+
+// Span is an entity's type and position in a text, in bytes
+type Span struct {
+	Type  string
+	Start int
+	End   int
+}
+
+// Client calls Amazon Comprehend
+type Client struct {
+	cfg    aws.Config
+	signer *v4.Signer
+	client *http.Client
+}
+
+// New returns a Comprehend client for cfg
+func New(cfg aws.Config) *Client {
+	return &Client{
+		cfg:    cfg,
+		signer: v4.NewSigner(),
+		client: awsconfig.NewHTTPClient(30 * time.Second),
+	}
+}
+
+// DetectPIIEntities returns the personal data in text, which must be at most
+// MaxTextBytes, in the language with the given code
+func (c *Client) DetectPIIEntities(ctx context.Context, text, language string) ([]Entity, error) {
+	var out struct {
+		Entities []Entity
+	}
+	err := c.call(ctx, "DetectPiiEntities", map[string]string{"Text": text, "LanguageCode": language}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Entities, nil
+}
+
+// Spans returns where text of any length holds personal data found with at
+// least minScore, checking it MaxTextBytes at a time. An entity crossing
+// the boundary between two requests may be missed.
+func (c *Client) Spans(ctx context.Context, text, language string, minScore float64) ([]Span, error) {
+	var spans []Span
+	offset := 0
+	for _, chunk := range SplitText(text, MaxTextBytes) {
+		entities, err := c.DetectPIIEntities(ctx, chunk, language)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entities {
+			if e.Score < minScore {
+				continue
+			}
+			start, end := byteOffsets(chunk, e.BeginOffset, e.EndOffset)
+			spans = append(spans, Span{Type: e.Type, Start: offset + start, End: offset + end})
+		}
+		offset += len(chunk)
+	}
+	return spans, nil
+}
+
+// byteOffsets converts character offsets in s to byte offsets, clamped to
+// the length of s
+func byteOffsets(s string, begin, end int) (int, int) {
+	start, stop := len(s), len(s)
+	chars := 0
+	for i := range s {
+		if chars == begin {
+			start = i
+		}
+		if chars == end {
+			stop = i
+			break
+		}
+		chars++
+	}
+	if stop < start {
+		stop = start
+	}
+	return start, stop
+}
+
+// SplitText splits s into chunks of at most size bytes without splitting a
+// UTF-8 character
+func SplitText(s string, size int) []string {
+	var chunks []string
+	for len(s) > 0 {
+		n := size
+		if n >= len(s) {
+			n = len(s)
+		} else {
+			for n > 0 && !utf8.RuneStart(s[n]) {
+				n--
+			}
+		}
+		chunks = append(chunks, s[:n])
+		s = s[n:]
+	}
+	return chunks
+}
+
+// endpoint resolves the Comprehend endpoint and signing region, honouring
+// the LocalStack resolver used in local development
+func (c *Client) endpoint() (string, string) {
+	region := c.cfg.Region
+	if c.cfg.EndpointResolverWithOptions != nil {
+		e, err := c.cfg.EndpointResolverWithOptions.ResolveEndpoint("Comprehend", region)
+		if err == nil {
+			if e.SigningRegion != "" {
+				region = e.SigningRegion
+			}
+			return e.URL, region
+		}
+	}
+	return fmt.Sprintf("https://comprehend.%s.amazonaws.com", region), region
+}
+
+// call sends one Comprehend JSON request and decodes the response into out
+func (c *Client) call(ctx context.Context, operation string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	url, region := c.endpoint()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Comprehend_20171127."+operation)
+
+	if c.cfg.Credentials == nil {
+		return errors.New("no AWS credentials configured")
+	}
+	creds, err := c.cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieving credentials: %v", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "comprehend", region, time.Now()); err != nil {
+		return fmt.Errorf("signing request: %v", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Comprehend %s: %v", operation, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("Comprehend %s: %v", operation, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(respBody, &apiErr)
+		return fmt.Errorf("Comprehend %s: %s %s: %s", operation, resp.Status, apiErr.Type, apiErr.Message)
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,83 @@
+package comprehend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testClient(url string) *Client {
+	return New(aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test", "test", ""),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, _ ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: url}, nil
+		}),
+	})
+}
+
+func TestSpans(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Comprehend_20171127.DetectPiiEntities", r.Header.Get("X-Amz-Target"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-east-1/comprehend/aws4_request")
+		var in struct {
+			Text         string
+			LanguageCode string
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		assert.Equal(t, "en", in.LanguageCode)
+
+		// Offsets count characters, so "é" before the name is one
+		var entities []Entity
+		if i := strings.Index(in.Text, "Jane"); i >= 0 {
+			chars := len([]rune(in.Text[:i]))
+			entities = append(entities, Entity{Type: "NAME", Score: 0.9, BeginOffset: chars, EndOffset: chars + 4})
+		}
+		entities = append(entities, Entity{Type: "DATE_TIME", Score: 0.2})
+		json.NewEncoder(w).Encode(map[string]interface{}{"Entities": entities})
+	}))
+	defer srv.Close()
+
+	text := "Café Jane"
+	spans, err := testClient(srv.URL).Spans(context.Background(), text, "en", 0.5)
+	require.NoError(t, err)
+	require.Len(t, spans, 1)
+	assert.Equal(t, "NAME", spans[0].Type)
+	assert.Equal(t, "Jane", text[spans[0].Start:spans[0].End])
+}
+
+func TestDetectPIIEntitiesError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"TextSizeLimitExceededException","message":"too long"}`))
+	}))
+	defer srv.Close()
+
+	_, err := testClient(srv.URL).DetectPIIEntities(context.Background(), "text", "en")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "TextSizeLimitExceededException: too long")
+}
+
+func TestByteOffsets(t *testing.T) {
+	start, end := byteOffsets("aéb", 1, 2)
+	assert.Equal(t, 1, start)
+	assert.Equal(t, 3, end)
+
+	start, end = byteOffsets("ab", 1, 9)
+	assert.Equal(t, 1, start)
+	assert.Equal(t, 2, end)
+}
+
+func TestSplitText(t *testing.T) {
+	chunks := SplitText("aéb", 2)
+	assert.Equal(t, []string{"a", "é", "b"}, chunks)
+	assert.Empty(t, SplitText("", 10))
+}
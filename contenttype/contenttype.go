@@ -0,0 +1,159 @@
+// Package contenttype works out the MIME type of a file. The content is
+// sniffed first and the file name's extension only refines what sniffing
+// can't tell apart, such as CSV from plain text or an Office document from
+// any other zip, so a misnamed file gets the type of what it really holds.
+package contenttype
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// SniffLen is how many leading bytes Detect looks at
+const SniffLen = 512
+
+// Default is the type of content nothing more is known about
+const Default = "application/octet-stream"
+
+// extensionTypes covers extensions whose type shouldn't depend on the host's
+// mime.types; mime.TypeByExtension is asked about the rest
+var extensionTypes = map[string]string{
+	".csv":  "text/csv; charset=utf-8",
+	".md":   "text/markdown; charset=utf-8",
+	".tsv":  "text/tab-separated-values; charset=utf-8",
+	".yaml": "application/yaml",
+	".yml":  "application/yaml",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".epub": "application/epub+zip",
+	".jar":  "application/java-archive",
+}
+
+// ByExtension returns the type registered for name's extension, or "" if
+// there is none
+func ByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if t, ok := extensionTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// Detect returns the MIME type of a file named name whose content starts
+// with head. Only the first SniffLen bytes of head are looked at.
+func Detect(name string, head []byte) string {
+	sniffed := Default
+	if len(head) > 0 {
+		sniffed = http.DetectContentType(head)
+	}
+	byName := ByExtension(name)
+	if byName == "" {
+		return sniffed
+	}
+
+	switch mediaType(sniffed) {
+	case Default:
+		// Sniffing knows a few dozen signatures; anything else is taken at
+		// its name unless the name claims text that isn't there
+		if len(head) == 0 || !isText(byName) {
+			return byName
+		}
+	case "text/plain":
+		if isText(byName) {
+			return byName
+		}
+	case "text/xml":
+		if strings.HasSuffix(mediaType(byName), "+xml") {
+			return byName
+		}
+	case "application/zip":
+		if strings.HasSuffix(mediaType(byName), "+zip") || isZipBased(byName) {
+			return byName
+		}
+	}
+	return sniffed
+}
+
+// DetectReader is Detect for content read from r, which is left at its start
+func DetectReader(name string, r io.ReadSeeker) (string, error) {
+	head := make([]byte, SniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return Detect(name, head[:n]), nil
+}
+
+// ObjectGetter is the part of the S3 API DetectObject reads with
+type ObjectGetter interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// DetectObject is Detect for an existing S3 object of size bytes, of which
+// only the first SniffLen bytes are fetched
+func DetectObject(ctx context.Context, client ObjectGetter, bucket, key, name string, size int64) (string, error) {
+	// S3 rejects a range on an empty object
+	if size == 0 {
+		return Detect(name, nil), nil
+	}
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", SniffLen-1)),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer out.Body.Close()
+	head, err := io.ReadAll(io.LimitReader(out.Body, SniffLen))
+	if err != nil {
+		return "", err
+	}
+	return Detect(name, head), nil
+}
+
+func mediaType(t string) string {
+	if i := strings.IndexByte(t, ';'); i >= 0 {
+		t = t[:i]
+	}
+	return strings.TrimSpace(t)
+}
+
+// isText reports whether t is a textual type that sniffing would see as
+// plain text
+func isText(t string) bool {
+	mt := mediaType(t)
+	switch {
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	case mt == "application/json", mt == "application/yaml", mt == "application/javascript", mt == "application/xml":
+		return true
+	}
+	return strings.HasSuffix(mt, "+json") || strings.HasSuffix(mt, "+xml")
+}
+
+// isZipBased reports whether t is a format stored as a zip archive
+func isZipBased(t string) bool {
+	mt := mediaType(t)
+	return strings.HasPrefix(mt, "application/vnd.openxmlformats-officedocument.") ||
+		strings.HasPrefix(mt, "application/vnd.oasis.opendocument.") ||
+		mt == "application/java-archive"
+}
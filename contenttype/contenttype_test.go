@@ -0,0 +1,54 @@
+package contenttype
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var pngHead = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestDetectSniffsContent(t *testing.T) {
+	assert.Equal(t, "image/png", Detect("photo.png", pngHead))
+	assert.Equal(t, "application/pdf", Detect("report.pdf", []byte("%PDF-1.7\n")))
+	assert.Equal(t, "text/plain; charset=utf-8", Detect("notes", []byte("hello")))
+}
+
+func TestDetectCorrectsMisnamedFiles(t *testing.T) {
+	// The content wins over the extension
+	assert.Equal(t, "image/png", Detect("photo.jpg", pngHead))
+	assert.Equal(t, "application/pdf", Detect("report.txt", []byte("%PDF-1.7\n")))
+	// Binary content named as text isn't served as text
+	assert.Equal(t, Default, Detect("data.csv", []byte{0x00, 0x01, 0xfe, 0xff}))
+}
+
+func TestDetectRefinesGenericTypesByName(t *testing.T) {
+	assert.Equal(t, "text/csv; charset=utf-8", Detect("data.csv", []byte("a,b\n1,2\n")))
+	assert.Equal(t, "application/json", Detect("data.json", []byte(`{"a": 1}`)))
+	assert.Equal(t, "image/svg+xml", Detect("logo.svg", []byte(`<?xml version="1.0"?><svg/>`)))
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		Detect("letter.DOCX", []byte("PK\x03\x04rest")))
+	// A zip named as something that isn't one stays a zip
+	assert.Equal(t, "application/zip", Detect("archive.png", []byte("PK\x03\x04rest")))
+	// Empty content goes by name alone
+	assert.Equal(t, "text/csv; charset=utf-8", Detect("empty.csv", nil))
+	assert.Equal(t, Default, Detect("empty", nil))
+}
+
+func TestDetectReaderRewinds(t *testing.T) {
+	content := append(append([]byte{}, pngHead...), bytes.Repeat([]byte{0}, 2*SniffLen)...)
+	r := bytes.NewReader(content)
+	ct, err := DetectReader("photo", r)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", ct)
+
+	rest, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, content, rest)
+
+	ct, err = DetectReader("short.txt", bytes.NewReader([]byte("hi")))
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", ct)
+}
@@ -0,0 +1,226 @@
+// Package cron parses standard five-field cron expressions and works out when
+// they next fire. Fields are minute, hour, day of month, month and day of week,
+// each a *, a value, a range or a list of those, optionally with a /step.
+// Months and days of the week may be given by their three-letter names. The
+// descriptors @hourly, @daily (or @midnight), @weekly, @monthly and @yearly (or
+// @annually) are accepted too. Times are evaluated in UTC.
+package cron
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearchYears bounds how far Next looks for an expression that matches
+// rarely, such as 0 0 29 2 *, or never, such as 0 0 31 4 *
+const maxSearchYears = 5
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a * day field: when only one of the two day
+	// fields is restricted it alone decides, otherwise either may match
+	domAny, dowAny bool
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// field describes the values one field of an expression can take
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{"minute", 0, 59, nil}
+	hourField   = field{"hour", 0, 23, nil}
+	domField    = field{"day of month", 1, 31, nil}
+	monthField  = field{"month", 1, 12, monthNames}
+	// Sunday is both 0 and 7
+	dowField = field{"day of week", 0, 7, dayNames}
+)
+
+// Parse parses a cron expression
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := descriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, got %d", expr, len(parts))
+	}
+
+	var s Schedule
+	var err error
+	if s.minute, err = minuteField.parse(parts[0]); err != nil {
+		return nil, err
+	}
+	if s.hour, err = hourField.parse(parts[1]); err != nil {
+		return nil, err
+	}
+	if s.dom, err = domField.parse(parts[2]); err != nil {
+		return nil, err
+	}
+	if s.month, err = monthField.parse(parts[3]); err != nil {
+		return nil, err
+	}
+	if s.dow, err = dowField.parse(parts[4]); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domAny = parts[2] == "*" || parts[2] == "?"
+	s.dowAny = parts[4] == "*" || parts[4] == "?"
+	return &s, nil
+}
+
+// parse returns the values a field matches as a bit set
+func (f field) parse(expr string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(expr, ",") {
+		lo, hi, step := f.min, f.max, 1
+		rangeExpr := part
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %s field %q", f.name, part)
+			}
+			step, rangeExpr = n, part[:i]
+		}
+		switch {
+		case rangeExpr == "*" || rangeExpr == "?":
+		case strings.Contains(rangeExpr, "-"):
+			bounds := strings.SplitN(rangeExpr, "-", 2)
+			var err error
+			if lo, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range in %s field %q", f.name, part)
+			}
+		default:
+			v, err := f.value(rangeExpr)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			// A single value with a step runs to the end of the field
+			if step == 1 {
+				hi = v
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// value parses a single value of the field, by number or name
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q, must be %d-%d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t, in UTC and to the minute, that the
+// schedule fires, or the zero time if it doesn't within the next few years
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if !has(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !has(s.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !has(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the day of month and day of week fields to t's date
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+func has(set uint64, v int) bool {
+	return set&(1<<uint(v)) != 0
+}
+
+// Interval returns the shortest gap between two firings within the next
+// day, so callers can refuse schedules that would run too often. Schedules
+// firing at most once a day return 24 hours.
+func (s *Schedule) Interval(from time.Time) time.Duration {
+	// Minutes and hours repeat daily, so a day's firings show the shortest gap
+	if bits.OnesCount64(s.minute) == 1 && bits.OnesCount64(s.hour) == 1 {
+		return 24 * time.Hour
+	}
+	shortest := 24 * time.Hour
+	prev := s.Next(from)
+	end := prev.Add(24 * time.Hour)
+	for !prev.IsZero() && prev.Before(end) {
+		next := s.Next(prev)
+		if next.IsZero() {
+			break
+		}
+		if gap := next.Sub(prev); gap < shortest {
+			shortest = gap
+		}
+		prev = next
+	}
+	return shortest
+}
@@ -0,0 +1,69 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func at(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestNext(t *testing.T) {
+	cases := []struct {
+		expr, from, want string
+	}{
+		{"*/15 * * * *", "2024-05-01 10:07", "2024-05-01 10:15"},
+		{"0 2 * * *", "2024-05-01 10:07", "2024-05-02 02:00"},
+		{"@daily", "2024-05-01 00:00", "2024-05-02 00:00"},
+		{"@hourly", "2024-05-01 23:30", "2024-05-02 00:00"},
+		{"30 9 * * mon-fri", "2024-05-03 10:00", "2024-05-06 09:30"},
+		{"0 0 1 */3 *", "2024-05-01 10:00", "2024-07-01 00:00"},
+		{"0 0 29 feb *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		// Either day field matches when both are restricted
+		{"0 0 13 * fri", "2024-05-01 00:00", "2024-05-03 00:00"},
+		// Sunday may be 7
+		{"0 12 * * 7", "2024-05-01 00:00", "2024-05-05 12:00"},
+		{"5,10-12 1 * * *", "2024-05-01 01:10", "2024-05-01 01:11"},
+	}
+	for _, c := range cases {
+		s, err := Parse(c.expr)
+		require.NoError(t, err, c.expr)
+		assert.Equal(t, at(c.want), s.Next(at(c.from)), c.expr)
+	}
+}
+
+func TestNextNeverFires(t *testing.T) {
+	s, err := Parse("0 0 31 4 *")
+	require.NoError(t, err)
+	assert.True(t, s.Next(at("2024-01-01 00:00")).IsZero())
+}
+
+func TestParseRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "@sometimes", "x * * * *"} {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	from := at("2024-05-01 00:00")
+	for expr, want := range map[string]time.Duration{
+		"* * * * *":    time.Minute,
+		"*/10 * * * *": 10 * time.Minute,
+		"0 */6 * * *":  6 * time.Hour,
+		"0 2 * * *":    24 * time.Hour,
+		"0 2 * * 1":    24 * time.Hour,
+	} {
+		s, err := Parse(expr)
+		require.NoError(t, err, expr)
+		assert.Equal(t, want, s.Interval(from), expr)
+	}
+}
@@ -0,0 +1,245 @@
+// Package custom invokes tenants' own Lambda functions on their files after
+// the built-in processing.
+//
+// A tenant's function runs in their own account, so it is never called with
+// the service's credentials: each call is made as the IAM role an operator
+// registered for the tenant in CUSTOM_PROCESSOR_ROLES, which the tenant has
+// set up to trust the service. The role is assumed with the tenant's user ID
+// as the external ID, so a role's trust policy can't be used on behalf of
+// another tenant, and only functions in the role's account are invoked. The
+// function gets a presigned URL to the file rather than the service's access
+// to the bucket.
+package custom
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	// DefaultTimeout is how long a function may run when none is set
+	DefaultTimeout = 30 * time.Second
+	// MaxTimeout is the longest timeout a tenant may set
+	MaxTimeout = 5 * time.Minute
+	// maxOutputBytes caps the output kept from a function
+	maxOutputBytes = 256 << 10
+	// roleSessionName identifies the service's calls in the tenant's CloudTrail
+	roleSessionName = "golang-aws-api-custom-processor"
+)
+
+var (
+	functionARNPattern = regexp.MustCompile(`^arn:aws[a-z-]*:lambda:([a-z0-9-]+):(\d{12}):function:[A-Za-z0-9_-]{1,64}(:[A-Za-z0-9_$-]{1,128})?$`)
+	roleARNPattern     = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(\d{12}):role/[\w+=,.@/-]{1,512}$`)
+)
+
+// tenantRole is a role an operator registered for a tenant
+type tenantRole struct {
+	userID  string
+	roleARN string
+}
+
+// tenantRoles parses CUSTOM_PROCESSOR_ROLES: comma-separated user-id=role-arn
+// pairs. Entries that aren't a user ID and a role ARN are ignored.
+func tenantRoles() []tenantRole {
+	var roles []tenantRole
+	for _, entry := range strings.Split(os.Getenv("CUSTOM_PROCESSOR_ROLES"), ",") {
+		userID, roleARN, ok := strings.Cut(entry, "=")
+		userID, roleARN = strings.TrimSpace(userID), strings.TrimSpace(roleARN)
+		if ok && userID != "" && roleARNPattern.MatchString(roleARN) {
+			roles = append(roles, tenantRole{userID, roleARN})
+		}
+	}
+	return roles
+}
+
+// AllowedRoles returns the roles registered in CUSTOM_PROCESSOR_ROLES, each
+// once, in the order they are listed
+func AllowedRoles() []string {
+	var roles []string
+	seen := make(map[string]bool)
+	for _, r := range tenantRoles() {
+		if !seen[r.roleARN] {
+			seen[r.roleARN] = true
+			roles = append(roles, r.roleARN)
+		}
+	}
+	return roles
+}
+
+// Enabled reports whether any role is registered, without which custom
+// processors can't be registered or run
+func Enabled() bool {
+	return len(tenantRoles()) > 0
+}
+
+// TenantRole returns the role registered for a tenant, or "" if they may not
+// use custom processors
+func TenantRole(userID string) string {
+	for _, r := range tenantRoles() {
+		if r.userID == userID {
+			return r.roleARN
+		}
+	}
+	return ""
+}
+
+// RoleAllowed reports whether roleARN is the role registered for the tenant
+func RoleAllowed(userID, roleARN string) bool {
+	return roleARN != "" && TenantRole(userID) == roleARN
+}
+
+// ValidateFunctionARN checks that arn is a Lambda function ARN, optionally
+// with a version or alias, and returns the function's region and account
+func ValidateFunctionARN(arn string) (string, string, error) {
+	m := functionARNPattern.FindStringSubmatch(arn)
+	if m == nil {
+		return "", "", errors.New("must be a Lambda function ARN")
+	}
+	return m[1], m[2], nil
+}
+
+// CheckFunction checks that functionARN is a Lambda function in the account
+// of roleARN, the tenant's registered account, and returns its region
+func CheckFunction(roleARN, functionARN string) (string, error) {
+	region, account, err := ValidateFunctionARN(functionARN)
+	if err != nil {
+		return "", err
+	}
+	m := roleARNPattern.FindStringSubmatch(roleARN)
+	if m == nil {
+		return "", errors.New("role is not an IAM role ARN")
+	}
+	if account != m[1] {
+		return "", fmt.Errorf("must be a function in account %s, the one registered for you", m[1])
+	}
+	return region, nil
+}
+
+// Request is the event a custom function is invoked with
+type Request struct {
+	FileID    string `json:"file_id"`
+	Name      string `json:"name"`
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	SizeBytes int64  `json:"size_bytes"`
+	// URL is a presigned GET for the file, valid a little longer than the
+	// function's timeout
+	URL string `json:"url"`
+	// Result is the built-in processing result
+	Result string `json:"result"`
+}
+
+// Invoker calls custom functions through the Lambda Invoke API, with
+// credentials for the role registered for each function's tenant
+type Invoker struct {
+	cfg aws.Config
+	sts *sts.Client
+
+	mu    sync.Mutex
+	roles map[tenantRole]*aws.CredentialsCache
+}
+
+// NewInvoker returns an Invoker that assumes roles with cfg's credentials.
+// No request is made until a function is invoked.
+func NewInvoker(cfg aws.Config) *Invoker {
+	return &Invoker{
+		cfg:   cfg,
+		sts:   sts.NewFromConfig(cfg),
+		roles: make(map[tenantRole]*aws.CredentialsCache),
+	}
+}
+
+// credentials returns the cached credentials provider for a tenant's role,
+// assumed with the tenant's user ID as the external ID and refreshed before
+// the credentials expire
+func (i *Invoker) credentials(userID, roleARN string) *aws.CredentialsCache {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	key := tenantRole{userID, roleARN}
+	c, ok := i.roles[key]
+	if !ok {
+		c = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(i.sts, roleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = roleSessionName
+			o.ExternalID = aws.String(userID)
+		}))
+		i.roles[key] = c
+	}
+	return c
+}
+
+// Invoke runs the tenant userID's functionARN as roleARN with req and waits
+// up to timeout for its output. A function that returns a JSON string has
+// the string returned; any other output is returned as it is. The role and
+// the function's account are checked again here, as the roles registered
+// may have changed since the function was.
+func (i *Invoker) Invoke(ctx context.Context, userID, roleARN, functionARN string, req Request, timeout time.Duration) (string, error) {
+	if !RoleAllowed(userID, roleARN) {
+		return "", fmt.Errorf("role %s is not registered for user %s", roleARN, userID)
+	}
+	region, err := CheckFunction(roleARN, functionARN)
+	if err != nil {
+		return "", fmt.Errorf("function %s: %v", functionARN, err)
+	}
+	if timeout <= 0 || timeout > MaxTimeout {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	creds := i.credentials(userID, roleARN)
+	if _, err := creds.Retrieve(ctx); err != nil {
+		return "", fmt.Errorf("assuming role %s: %v", roleARN, err)
+	}
+	client := lambda.NewFromConfig(i.cfg, func(o *lambda.Options) {
+		o.Region = region
+		o.Credentials = creds
+	})
+	out, err := client.Invoke(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(functionARN),
+		InvocationType: types.InvocationTypeRequestResponse,
+		Payload:        payload,
+	})
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("function did not finish within %s", timeout)
+		}
+		return "", err
+	}
+	if kind := aws.ToString(out.FunctionError); kind != "" {
+		var fnErr struct {
+			ErrorMessage string `json:"errorMessage"`
+		}
+		json.Unmarshal(out.Payload, &fnErr)
+		return "", fmt.Errorf("function error (%s): %s", kind, fnErr.ErrorMessage)
+	}
+	if len(out.Payload) > maxOutputBytes {
+		return "", fmt.Errorf("function output is larger than %d bytes", maxOutputBytes)
+	}
+	return decodeOutput(out.Payload), nil
+}
+
+// decodeOutput unquotes a JSON string output, leaving anything else as it is
+func decodeOutput(out []byte) string {
+	var s string
+	if json.Unmarshal(out, &s) == nil {
+		return s
+	}
+	return string(out)
+}
@@ -0,0 +1,59 @@
+package custom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFunctionARN(t *testing.T) {
+	region, account, err := ValidateFunctionARN("arn:aws:lambda:eu-west-1:123456789012:function:my-processor")
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", region)
+	assert.Equal(t, "123456789012", account)
+
+	region, _, err = ValidateFunctionARN("arn:aws:lambda:us-east-1:123456789012:function:my-processor:live")
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", region)
+
+	for _, arn := range []string{
+		"",
+		"my-processor",
+		"arn:aws:lambda:us-east-1:1234:function:my-processor",
+		"arn:aws:s3:::my-bucket",
+		"arn:aws:lambda:us-east-1:123456789012:function:my/processor",
+	} {
+		_, _, err := ValidateFunctionARN(arn)
+		assert.Error(t, err, arn)
+	}
+}
+
+func TestCheckFunction(t *testing.T) {
+	role := "arn:aws:iam::123456789012:role/processor"
+	region, err := CheckFunction(role, "arn:aws:lambda:eu-west-1:123456789012:function:my-processor")
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", region)
+
+	_, err = CheckFunction(role, "arn:aws:lambda:eu-west-1:210987654321:function:my-processor")
+	assert.ErrorContains(t, err, "account 123456789012", "functions in other accounts are refused")
+	_, err = CheckFunction(role, "my-processor")
+	assert.Error(t, err)
+}
+
+func TestTenantRoles(t *testing.T) {
+	t.Setenv("CUSTOM_PROCESSOR_ROLES", " u1=arn:aws:iam::123456789012:role/processor , u2=arn:aws:iam::210987654321:role/team/processor,u3=arn:aws:iam::123456789012:role/processor,u4=not-a-role,arn:aws:iam::123456789012:role/admin")
+
+	assert.True(t, Enabled())
+	assert.Equal(t, []string{"arn:aws:iam::123456789012:role/processor", "arn:aws:iam::210987654321:role/team/processor"}, AllowedRoles())
+	assert.Equal(t, "arn:aws:iam::210987654321:role/team/processor", TenantRole("u2"))
+	assert.Empty(t, TenantRole("u4"))
+
+	assert.True(t, RoleAllowed("u1", "arn:aws:iam::123456789012:role/processor"))
+	assert.False(t, RoleAllowed("u2", "arn:aws:iam::123456789012:role/processor"), "roles are tied to their tenant")
+	assert.False(t, RoleAllowed("u5", "arn:aws:iam::123456789012:role/admin"))
+	assert.False(t, RoleAllowed("u5", ""))
+
+	t.Setenv("CUSTOM_PROCESSOR_ROLES", "")
+	assert.False(t, Enabled())
+	assert.False(t, RoleAllowed("u1", "arn:aws:iam::123456789012:role/processor"))
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"time"
+
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// File access actions
+const (
+	AccessView     = "view"
+	AccessDownload = "download"
+	AccessPresign  = "presign"
+	AccessShare    = "share"
+	AccessPreview  = "preview"
+)
+
+// Content variants an access can be served
+const (
+	VariantOriginal = "original"
+	VariantRedacted = "redacted"
+)
+
+type FileAccess struct {
+	ID     string
+	FileID string
+	// UserID is empty for anonymous access
+	UserID string
+	Action string
+	// Variant is the content served, VariantOriginal or VariantRedacted
+	Variant   string
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+}
+
+// LogFileAccess records that a file's content was accessed, and which
+// variant of it was served
+func LogFileAccess(fileID, userID, action, variant, ip, userAgent string) error {
+	_, err := GetDB().Exec(`
+		INSERT INTO file_access_log (id, file_id, user_id, action, variant, ip, user_agent)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7)
+	`, NewID(), fileID, userID, action, variant, ip, userAgent)
+	return err
+}
+
+// ListFileAccess retrieves a page of a file's access log, newest first,
+// starting after the given cursor. Up to limit+1 rows are returned so the
+// caller can tell whether another page follows.
+func ListFileAccess(fileID string, after *pagination.Cursor, limit int) ([]FileAccess, error) {
+	createdAt, id := keysetBounds(after)
+	rows, err := GetDB().Query(`
+		SELECT id, file_id, COALESCE(user_id, ''), action, variant, ip, user_agent, created_at
+		FROM file_access_log
+		WHERE file_id = $1 AND (created_at, id) < ($2::timestamp, $3::text)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4
+	`, fileID, createdAt, id, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []FileAccess
+	for rows.Next() {
+		var a FileAccess
+		if err := rows.Scan(&a.ID, &a.FileID, &a.UserID, &a.Action, &a.Variant, &a.IP, &a.UserAgent, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, a)
+	}
+	return entries, rows.Err()
+}
+
+// ListFileAccessByUser retrieves every access a user made to any file, oldest
+// first
+func ListFileAccessByUser(userID string) ([]FileAccess, error) {
+	rows, err := GetDB().Query(`
+		SELECT id, file_id, COALESCE(user_id, ''), action, variant, ip, user_agent, created_at
+		FROM file_access_log
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []FileAccess
+	for rows.Next() {
+		var a FileAccess
+		if err := rows.Scan(&a.ID, &a.FileID, &a.UserID, &a.Action, &a.Variant, &a.IP, &a.UserAgent, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, a)
+	}
+	return entries, rows.Err()
+}
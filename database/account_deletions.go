@@ -0,0 +1,267 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// Account deletion statuses
+const (
+	AccountDeletionPending   = "pending"
+	AccountDeletionRunning   = "running"
+	AccountDeletionCompleted = "completed"
+	AccountDeletionFailed    = "failed"
+	AccountDeletionCancelled = "cancelled"
+)
+
+// AccountDeletion is a user's request to delete their account. The account is
+// purged once ScheduledFor has passed unless the user cancels first.
+type AccountDeletion struct {
+	ID             string
+	UserID         string
+	Status         string
+	ScheduledFor   time.Time
+	FilesDeleted   int
+	FilesRetained  int
+	ObjectsDeleted int
+	Error          string
+	CreatedAt      time.Time
+	CompletedAt    sql.NullTime
+}
+
+// accountDeletionColumns is the column list read by scanAccountDeletion
+const accountDeletionColumns = `id, user_id, status, scheduled_for, files_deleted, files_retained, objects_deleted, error, created_at, completed_at`
+
+func scanAccountDeletion(row rowScanner, d *AccountDeletion) error {
+	return row.Scan(&d.ID, &d.UserID, &d.Status, &d.ScheduledFor, &d.FilesDeleted, &d.FilesRetained, &d.ObjectsDeleted, &d.Error, &d.CreatedAt, &d.CompletedAt)
+}
+
+// PurgeCounts is what purging an account removed
+type PurgeCounts struct {
+	FilesDeleted   int
+	FilesRetained  int
+	ObjectsDeleted int
+}
+
+// ScheduleAccountDeletion schedules the user's account to be purged at
+// scheduledFor. If a deletion is already waiting or running, that one is
+// returned unchanged.
+func ScheduleAccountDeletion(userID string, scheduledFor time.Time) (*AccountDeletion, error) {
+	var d AccountDeletion
+	err := scanAccountDeletion(GetDB().QueryRow(`
+		WITH inserted AS (
+			INSERT INTO account_deletions (id, user_id, status, scheduled_for)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING
+			RETURNING `+accountDeletionColumns+`
+		)
+		SELECT `+accountDeletionColumns+` FROM inserted
+		UNION ALL
+		SELECT `+accountDeletionColumns+` FROM account_deletions
+		WHERE user_id = $2 AND status IN ($3, $5)
+		LIMIT 1
+	`, NewID(), userID, AccountDeletionPending, scheduledFor, AccountDeletionRunning), &d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// GetOpenAccountDeletion returns the user's deletion that is waiting or
+// running, or nil if there is none
+func GetOpenAccountDeletion(userID string) (*AccountDeletion, error) {
+	var d AccountDeletion
+	err := scanAccountDeletion(GetDB().QueryRow(`
+		SELECT `+accountDeletionColumns+`
+		FROM account_deletions
+		WHERE user_id = $1 AND status IN ($2, $3)
+	`, userID, AccountDeletionPending, AccountDeletionRunning), &d)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// CancelAccountDeletion cancels the user's pending deletion. It returns false
+// if there was none; a deletion that has started can't be cancelled.
+func CancelAccountDeletion(userID string) (bool, error) {
+	res, err := GetDB().Exec(`
+		UPDATE account_deletions
+		SET status = $1, completed_at = NOW()
+		WHERE user_id = $2 AND status = $3
+	`, AccountDeletionCancelled, userID, AccountDeletionPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ClaimDueAccountDeletions marks up to limit pending deletions whose grace
+// period has ended as running and returns them. Rows locked by another
+// instance are skipped.
+func ClaimDueAccountDeletions(now time.Time, limit int) ([]AccountDeletion, error) {
+	rows, err := GetDB().Query(`
+		UPDATE account_deletions
+		SET status = $1
+		WHERE id IN (
+			SELECT id FROM account_deletions
+			WHERE status = $2 AND scheduled_for <= $3
+			ORDER BY scheduled_for
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+accountDeletionColumns+`
+	`, AccountDeletionRunning, AccountDeletionPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deletions []AccountDeletion
+	for rows.Next() {
+		var d AccountDeletion
+		if err := scanAccountDeletion(rows, &d); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
+
+// CompleteAccountDeletion records that an account was purged
+func CompleteAccountDeletion(id string, counts PurgeCounts) error {
+	_, err := GetDB().Exec(`
+		UPDATE account_deletions
+		SET status = $1, files_deleted = $2, files_retained = $3, objects_deleted = $4, completed_at = NOW()
+		WHERE id = $5
+	`, AccountDeletionCompleted, counts.FilesDeleted, counts.FilesRetained, counts.ObjectsDeleted, id)
+	return err
+}
+
+// FailAccountDeletion records that purging an account stopped with an error,
+// along with what had been removed by then
+func FailAccountDeletion(id string, counts PurgeCounts, reason string) error {
+	_, err := GetDB().Exec(`
+		UPDATE account_deletions
+		SET status = $1, files_deleted = $2, files_retained = $3, objects_deleted = $4, error = $5, completed_at = NOW()
+		WHERE id = $6
+	`, AccountDeletionFailed, counts.FilesDeleted, counts.FilesRetained, counts.ObjectsDeleted, reason, id)
+	return err
+}
+
+// ListAccountDeletions retrieves a page of account deletions, newest first,
+// optionally only those with the given status. Up to limit+1 rows are
+// returned so the caller can tell whether another page follows.
+func ListAccountDeletions(status string, after *pagination.Cursor, limit int) ([]AccountDeletion, error) {
+	createdAt, id := keysetBounds(after)
+	rows, err := GetDB().Query(`
+		SELECT `+accountDeletionColumns+`
+		FROM account_deletions
+		WHERE ($1 = '' OR status = $1) AND (created_at, id) < ($2::timestamp, $3::text)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4
+	`, status, createdAt, id, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deletions []AccountDeletion
+	for rows.Next() {
+		var d AccountDeletion
+		if err := scanAccountDeletion(rows, &d); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
+
+// ListAllFilesByUser returns every file the user owns
+func ListAllFilesByUser(userID string) ([]File, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// DeleteExportsByUser deletes the user's export jobs and returns the S3 keys
+// of their archives
+func DeleteExportsByUser(userID string) ([]string, error) {
+	rows, err := GetDB().Query(`
+		DELETE FROM exports WHERE user_id = $1
+		RETURNING s3_key
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, rows.Err()
+}
+
+// AnonymizeUser removes the user's credentials, shares, collections, webhooks,
+// pipelines, custom processor and linked identities and replaces their
+// username and email with placeholders.
+// The row itself stays so that events and any files kept under retention
+// still point at an account, but nothing about it identifies the person.
+func AnonymizeUser(userID string) error {
+	return WithTx(func(tx *sql.Tx) error {
+		for _, query := range []string{
+			`DELETE FROM access_tokens WHERE user_id = $1`,
+			`DELETE FROM api_keys WHERE user_id = $1`,
+			`DELETE FROM ssh_keys WHERE user_id = $1`,
+			`DELETE FROM webhooks WHERE user_id = $1`,
+			`DELETE FROM processing_pipelines WHERE user_id = $1`,
+			`DELETE FROM custom_processors WHERE user_id = $1`,
+			`DELETE FROM user_identities WHERE user_id = $1`,
+			`DELETE FROM shares WHERE user_id = $1`,
+			`DELETE FROM collections WHERE user_id = $1`,
+			`DELETE FROM idempotency_keys WHERE scope = $1`,
+			`UPDATE file_access_log SET user_id = NULL WHERE user_id = $1`,
+		} {
+			if _, err := tx.Exec(query, userID); err != nil {
+				return err
+			}
+		}
+		placeholder := userID + "@deleted.invalid"
+		_, err := tx.Exec(`
+			UPDATE users
+			SET username = $2, email = $3, email_key = $3, password = '', confirmed = false
+			WHERE id = $1
+		`, userID, "deleted-"+userID, placeholder)
+		return err
+	})
+}
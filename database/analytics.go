@@ -0,0 +1,302 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Analytics partition statuses
+const (
+	AnalyticsRunning = "running"
+	AnalyticsDone    = "done"
+	AnalyticsFailed  = "failed"
+)
+
+// AnalyticsPartition is one UTC day of processing results exported to S3 as
+// a Parquet file
+type AnalyticsPartition struct {
+	Day        time.Time
+	Status     string
+	S3Key      string
+	Rows       int64
+	Error      string
+	StartedAt  time.Time
+	ExportedAt *time.Time
+}
+
+// AnalyticsResult is a processing result with the file it belongs to,
+// flattened for the analytics export
+type AnalyticsResult struct {
+	ProcessingResult
+	UserID        string
+	FileName      string
+	ContentType   string
+	SizeBytes     int64
+	CollectionID  string
+	FileCreatedAt time.Time
+}
+
+// ClaimNextAnalyticsDay claims the next day to export and returns it, or nil
+// when there is none. A failed day, or one whose export started before
+// staleBefore and never finished, is claimed again first; otherwise it is the
+// first day after the last one claimed that has results before until, which
+// should be the start of a day so that only finished days are exported.
+// Instances racing for a day get it only once.
+func ClaimNextAnalyticsDay(until, staleBefore time.Time) (*time.Time, error) {
+	var day time.Time
+	err := GetDB().QueryRow(`
+		UPDATE analytics_partitions
+		SET status = $1, error = '', started_at = NOW()
+		WHERE day = (
+			SELECT day FROM analytics_partitions
+			WHERE status IN ($2, $3) AND started_at < $4
+			ORDER BY day
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING day
+	`, AnalyticsRunning, AnalyticsRunning, AnalyticsFailed, staleBefore).Scan(&day)
+	if err == nil {
+		return &day, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var next sql.NullTime
+	err = GetDB().QueryRow(`
+		SELECT date_trunc('day', MIN(created_at))
+		FROM processing_results
+		WHERE created_at >= COALESCE((SELECT MAX(day) + 1 FROM analytics_partitions), '-infinity'::timestamp)
+			AND created_at < $1
+	`, until).Scan(&next)
+	if err != nil || !next.Valid {
+		return nil, err
+	}
+	err = GetDB().QueryRow(`
+		INSERT INTO analytics_partitions (day, status) VALUES ($1, $2)
+		ON CONFLICT (day) DO NOTHING
+		RETURNING day
+	`, next.Time, AnalyticsRunning).Scan(&day)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &day, nil
+}
+
+// ClaimAnalyticsDay claims a day to export again, such as after results of
+// the day were edited. It reports false if the day is being exported.
+func ClaimAnalyticsDay(day time.Time) (bool, error) {
+	res, err := GetDB().Exec(`
+		INSERT INTO analytics_partitions (day, status) VALUES ($1, $2)
+		ON CONFLICT (day) DO UPDATE
+		SET status = EXCLUDED.status, error = '', started_at = NOW()
+		WHERE analytics_partitions.status <> $2
+	`, day, AnalyticsRunning)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CompleteAnalyticsPartition records that a day was exported to key
+func CompleteAnalyticsPartition(day time.Time, key string, rows int64) error {
+	_, err := GetDB().Exec(`
+		UPDATE analytics_partitions
+		SET status = $1, s3_key = $2, rows = $3, error = '', exported_at = NOW()
+		WHERE day = $4
+	`, AnalyticsDone, key, rows, day)
+	return err
+}
+
+// FailAnalyticsPartition records that exporting a day failed; it is retried
+// once the claim goes stale
+func FailAnalyticsPartition(day time.Time, message string) error {
+	_, err := GetDB().Exec(`
+		UPDATE analytics_partitions SET status = $1, error = $2 WHERE day = $3
+	`, AnalyticsFailed, message, day)
+	return err
+}
+
+// ListAnalyticsPartitions returns every analytics partition, oldest day first
+func ListAnalyticsPartitions() ([]AnalyticsPartition, error) {
+	rows, err := GetDB().Query(`
+		SELECT day, status, s3_key, rows, error, started_at, exported_at
+		FROM analytics_partitions
+		ORDER BY day
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partitions []AnalyticsPartition
+	for rows.Next() {
+		var p AnalyticsPartition
+		if err := rows.Scan(&p.Day, &p.Status, &p.S3Key, &p.Rows, &p.Error, &p.StartedAt, &p.ExportedAt); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, rows.Err()
+}
+
+// ListAnalyticsResults returns up to limit results created from since until
+// until, oldest first, after the result created at afterTime with afterID.
+// Pass since and an empty afterID for the first page.
+func ListAnalyticsResults(since, until, afterTime time.Time, afterID string, limit int) ([]AnalyticsResult, error) {
+	rows, err := GetDB().Query(`
+		SELECT pr.id, pr.file_id, pr.status, pr.result, pr.source, pr.created_at, pr.updated_at, pr.version,
+			COALESCE(pr.reused_from, ''), pr.result_key, pr.result_size,
+			COALESCE(f.user_id, ''), f.name, f.content_type, COALESCE(f.size_bytes, 0), COALESCE(f.collection_id, ''), f.created_at
+		FROM processing_results pr
+		JOIN files f ON f.id = pr.file_id
+		WHERE pr.created_at >= $1 AND pr.created_at < $2 AND (pr.created_at, pr.id) > ($3, $4)
+		ORDER BY pr.created_at, pr.id
+		LIMIT $5
+	`, since, until, afterTime, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []AnalyticsResult
+	for rows.Next() {
+		var r AnalyticsResult
+		err := rows.Scan(&r.ID, &r.FileID, &r.Status, &r.Result, &r.Source, &r.CreatedAt, &r.UpdatedAt, &r.Version,
+			&r.ReusedFrom, &r.ResultKey, &r.ResultSize,
+			&r.UserID, &r.FileName, &r.ContentType, &r.SizeBytes, &r.CollectionID, &r.FileCreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// Analytics query statuses
+const (
+	QueryRunning   = "running"
+	QuerySucceeded = "succeeded"
+	QueryFailed    = "failed"
+)
+
+// AnalyticsQuery is one run of a predefined Athena query
+type AnalyticsQuery struct {
+	ID   string
+	Name string
+	// Params is the query's parameters as JSON; runs of a query with the
+	// same parameters share a cached result
+	Params      string
+	ExecutionID string
+	Status      string
+	Error       string
+	// Result is the result as JSON once the query succeeded
+	Result           string
+	DataScannedBytes int64
+	RequestedBy      string
+	CreatedAt        time.Time
+	FinishedAt       *time.Time
+}
+
+const analyticsQueryColumns = `id, name, params, execution_id, status, error, COALESCE(result::text, ''), data_scanned_bytes, requested_by, created_at, finished_at`
+
+func scanAnalyticsQuery(row rowScanner, q *AnalyticsQuery) error {
+	return row.Scan(&q.ID, &q.Name, &q.Params, &q.ExecutionID, &q.Status, &q.Error, &q.Result, &q.DataScannedBytes, &q.RequestedBy, &q.CreatedAt, &q.FinishedAt)
+}
+
+// SaveAnalyticsQuery records a query that was just started
+func SaveAnalyticsQuery(q AnalyticsQuery) (*AnalyticsQuery, error) {
+	var saved AnalyticsQuery
+	err := scanAnalyticsQuery(GetDB().QueryRow(`
+		INSERT INTO analytics_queries (id, name, params, execution_id, status, requested_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+analyticsQueryColumns+`
+	`, NewID(), q.Name, q.Params, q.ExecutionID, QueryRunning, q.RequestedBy), &saved)
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// GetAnalyticsQuery retrieves a query run, or nil if there is none with
+// that ID
+func GetAnalyticsQuery(id string) (*AnalyticsQuery, error) {
+	var q AnalyticsQuery
+	err := scanAnalyticsQuery(GetDB().QueryRow(`
+		SELECT `+analyticsQueryColumns+`
+		FROM analytics_queries
+		WHERE id = $1
+	`, id), &q)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// FindCachedAnalyticsQuery returns the latest run of a query with the same
+// parameters started after since that is still running or succeeded, or nil
+// if there is none
+func FindCachedAnalyticsQuery(name, params string, since time.Time) (*AnalyticsQuery, error) {
+	var q AnalyticsQuery
+	err := scanAnalyticsQuery(GetDB().QueryRow(`
+		SELECT `+analyticsQueryColumns+`
+		FROM analytics_queries
+		WHERE name = $1 AND params = $2 AND created_at > $3 AND status IN ($4, $5)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, name, params, since, QueryRunning, QuerySucceeded), &q)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// ListAnalyticsQueries returns the latest query runs, newest first
+func ListAnalyticsQueries(limit int) ([]AnalyticsQuery, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+analyticsQueryColumns+`
+		FROM analytics_queries
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queries []AnalyticsQuery
+	for rows.Next() {
+		var q AnalyticsQuery
+		if err := scanAnalyticsQuery(rows, &q); err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	return queries, rows.Err()
+}
+
+// FinishAnalyticsQuery records how a running query ended: with its result as
+// JSON if it succeeded, or an error. It returns the query run as saved,
+// which another request may have finished first.
+func FinishAnalyticsQuery(id, status, message, result string, dataScannedBytes int64) (*AnalyticsQuery, error) {
+	_, err := GetDB().Exec(`
+		UPDATE analytics_queries
+		SET status = $1, error = $2, result = NULLIF($3, '')::jsonb, data_scanned_bytes = $4, finished_at = NOW()
+		WHERE id = $5 AND status = $6
+	`, status, message, result, dataScannedBytes, id, QueryRunning)
+	if err != nil {
+		return nil, err
+	}
+	return GetAnalyticsQuery(id)
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey is a long-lived credential an integration uses to call the API on a
+// user's behalf, limited to its scopes. Only a hash of the key is stored.
+type APIKey struct {
+	ID     string
+	UserID string
+	Name   string
+	// Prefix is the start of the key, shown so users can tell keys apart
+	Prefix     string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+	// AllowedCIDRs are the only ranges the key may be used from; empty
+	// allows any address
+	AllowedCIDRs []string
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, scopes, allowed_cidrs, expires_at, last_used_at, created_at`
+
+func scanAPIKey(row rowScanner, k *APIKey) error {
+	var scopes, cidrs pq.StringArray
+	var expiresAt, lastUsedAt sql.NullTime
+	if err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &scopes, &cidrs, &expiresAt, &lastUsedAt, &k.CreatedAt); err != nil {
+		return err
+	}
+	k.Scopes = scopes
+	k.AllowedCIDRs = cidrs
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	return nil
+}
+
+// SaveAPIKey stores a new key for a user by its hash. A nil expiresAt never
+// expires.
+func SaveAPIKey(userID, name, prefix, keyHash string, scopes []string, expiresAt *time.Time) (*APIKey, error) {
+	var k APIKey
+	err := scanAPIKey(GetDB().QueryRow(`
+		INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+apiKeyColumns+`
+	`, NewID(), userID, name, prefix, keyHash, pq.Array(scopes), expiresAt), &k)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// ListAPIKeys returns a user's keys, oldest first
+func ListAPIKeys(userID string) ([]APIKey, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var k APIKey
+		if err := scanAPIKey(rows, &k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey revokes one of a user's keys, reporting whether it existed
+func DeleteAPIKey(id, userID string) (bool, error) {
+	res, err := GetDB().Exec(`DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetAPIKeyAllowedCIDRs replaces the ranges a key may be used from and
+// returns the key, or nil if it doesn't exist
+func SetAPIKeyAllowedCIDRs(id string, cidrs []string) (*APIKey, error) {
+	if cidrs == nil {
+		cidrs = []string{}
+	}
+	var k APIKey
+	err := scanAPIKey(GetDB().QueryRow(`
+		UPDATE api_keys SET allowed_cidrs = $2
+		WHERE id = $1
+		RETURNING `+apiKeyColumns+`
+	`, id, pq.Array(cidrs)), &k)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// GetUserByAPIKey retrieves the user a key hash belongs to and the key, and
+// records that the key was used. It returns ErrTokenExpired for expired keys.
+func GetUserByAPIKey(keyHash string) (*User, *APIKey, error) {
+	var user User
+	var k APIKey
+	var scopes, cidrs pq.StringArray
+	var expiresAt sql.NullTime
+	var expired bool
+	err := GetDB().QueryRow(`
+		UPDATE api_keys k SET last_used_at = NOW()
+		FROM users u
+		WHERE k.key_hash = $1 AND u.id = k.user_id
+		RETURNING u.id, u.username, u.password, u.email, u.confirmed, u.created_at,
+			k.id, k.name, k.prefix, k.scopes, k.allowed_cidrs, k.expires_at, k.created_at,
+			COALESCE(k.expires_at <= NOW(), false)
+	`, keyHash).Scan(&user.ID, &user.Username, &user.Password, openColumn(columnUserEmail, &user.Email), &user.Confirmed, &user.CreatedAt,
+		&k.ID, &k.Name, &k.Prefix, &scopes, &cidrs, &expiresAt, &k.CreatedAt, &expired)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if expired {
+		return nil, nil, ErrTokenExpired
+	}
+	k.UserID = user.ID
+	k.Scopes = scopes
+	k.AllowedCIDRs = cidrs
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	return &user, &k, nil
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"database/sql"
+)
+
+// ArchiveProgress is the outcome of an expanded archive whose members have all
+// been processed
+type ArchiveProgress struct {
+	ArchiveID string
+	// Result is the archive's own result, saved while it was expanding
+	Result string
+	// Members is how many members the archive stored
+	Members int
+	// Statuses counts the members by the status of their latest result
+	Statuses map[string]int
+}
+
+// SetMemberCountTx records how many members an archive stored once its
+// expansion is done
+func SetMemberCountTx(tx *sql.Tx, archiveID string, members int) error {
+	_, err := tx.Exec(`UPDATE files SET member_count = $1 WHERE id = $2`, members, archiveID)
+	return err
+}
+
+// FinishArchiveTx returns the progress of an expanding archive once its
+// expansion is done and every member has a result, or nil while it is still
+// going or when the archive has already finished. The archive's row stays
+// locked until tx ends, so of the members finishing at the same time only one
+// sees the archive finish.
+func FinishArchiveTx(tx *sql.Tx, archiveID string) (*ArchiveProgress, error) {
+	var members sql.NullInt64
+	err := tx.QueryRow(`SELECT member_count FROM files WHERE id = $1 FOR UPDATE`, archiveID).Scan(&members)
+	if err == sql.ErrNoRows || (err == nil && !members.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var status, result string
+	err = tx.QueryRow(`
+		SELECT status, result
+		FROM processing_results
+		WHERE file_id = $1 AND source = 'builtin'
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, archiveID).Scan(&status, &result)
+	if err == sql.ErrNoRows || (err == nil && status != StatusExpanding) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT pr.status, COUNT(*)
+		FROM files f
+		JOIN LATERAL (
+			SELECT status
+			FROM processing_results
+			WHERE file_id = f.id AND source = 'builtin'
+			ORDER BY created_at DESC
+			LIMIT 1
+		) pr ON TRUE
+		WHERE f.parent_id = $1
+		GROUP BY pr.status
+	`, archiveID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	p := &ArchiveProgress{ArchiveID: archiveID, Result: result, Members: int(members.Int64), Statuses: make(map[string]int)}
+	done := 0
+	for rows.Next() {
+		var s string
+		var n int
+		if err := rows.Scan(&s, &n); err != nil {
+			return nil, err
+		}
+		p.Statuses[s] = n
+		done += n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if done < p.Members {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// FinishParentArchiveTx is FinishArchiveTx for the archive fileID was
+// expanded from, nil for files that weren't
+func FinishParentArchiveTx(tx *sql.Tx, fileID string) (*ArchiveProgress, error) {
+	var parentID string
+	err := tx.QueryRow(`SELECT COALESCE(parent_id, '') FROM files WHERE id = $1`, fileID).Scan(&parentID)
+	if err == sql.ErrNoRows || (err == nil && parentID == "") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return FinishArchiveTx(tx, parentID)
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Processing attempt statuses
+const (
+	AttemptRetrying  = "retrying"
+	AttemptFailed    = "failed"
+	AttemptCompleted = "completed"
+)
+
+type ProcessingAttempt struct {
+	FileID    string
+	Attempts  int
+	Status    string
+	LastError string
+	UpdatedAt time.Time
+}
+
+// RecordFailedAttempt increments the attempt count for a file, stores the
+// error and returns the new attempt count
+func RecordFailedAttempt(fileID, lastError string) (int, error) {
+	var attempts int
+	err := GetDB().QueryRow(`
+		INSERT INTO processing_attempts (file_id, attempts, status, last_error)
+		VALUES ($1, 1, $2, $3)
+		ON CONFLICT (file_id) DO UPDATE
+		SET attempts = processing_attempts.attempts + 1,
+			status = EXCLUDED.status,
+			last_error = EXCLUDED.last_error,
+			updated_at = NOW()
+		RETURNING attempts
+	`, fileID, AttemptRetrying, lastError).Scan(&attempts)
+	return attempts, err
+}
+
+// SetAttemptStatus sets the status of a file's processing attempts, if any
+// were recorded. A completed or failed run ends the file's retries, so its
+// attempt count is reset and reprocessing it later gets every attempt again;
+// a failure's count is kept in the result it records.
+func SetAttemptStatus(fileID, status string) error {
+	return setAttemptStatus(GetDB(), fileID, status)
+}
+
+// SetAttemptStatusTx is SetAttemptStatus run inside a transaction
+func SetAttemptStatusTx(tx *sql.Tx, fileID, status string) error {
+	return setAttemptStatus(tx, fileID, status)
+}
+
+func setAttemptStatus(q querier, fileID, status string) error {
+	_, err := q.Exec(`
+		UPDATE processing_attempts
+		SET status = $1,
+			attempts = CASE WHEN $1 = $3 THEN attempts ELSE 0 END,
+			updated_at = NOW()
+		WHERE file_id = $2
+	`, status, fileID, AttemptRetrying)
+	return err
+}
+
+// GetProcessingAttempt retrieves the attempt tracking row for a file
+func GetProcessingAttempt(fileID string) (*ProcessingAttempt, error) {
+	var pa ProcessingAttempt
+	err := GetDB().QueryRow(`
+		SELECT file_id, attempts, status, last_error, updated_at
+		FROM processing_attempts
+		WHERE file_id = $1
+	`, fileID).Scan(&pa.FileID, &pa.Attempts, &pa.Status, &pa.LastError, &pa.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pa, nil
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"os"
+	"strings"
+)
+
+// NormalizeUsername trims a username. Usernames keep the case they were
+// registered with, but are unique and looked up regardless of case.
+func NormalizeUsername(username string) string {
+	return strings.TrimSpace(username)
+}
+
+// NormalizeEmail trims and lowercases an email address, the form it is stored
+// in
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// gmailDomains are the domains whose local parts ignore dots and +tags
+var gmailDomains = map[string]bool{"gmail.com": true, "googlemail.com": true}
+
+// EmailKey returns the canonical form of an address that users' emails are
+// unique and looked up by. With EMAIL_FOLD_GMAIL=true, dots and +tags are
+// also dropped from Gmail addresses, which Gmail delivers to the same inbox.
+// The setting only applies to keys computed after it is turned on, so it
+// should be chosen before users sign up.
+func EmailKey(email string) string {
+	email = NormalizeEmail(email)
+	if os.Getenv("EMAIL_FOLD_GMAIL") != "true" {
+		return email
+	}
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || !gmailDomains[domain] {
+		return email
+	}
+	local, _, _ = strings.Cut(local, "+")
+	return strings.ReplaceAll(local, ".", "") + "@gmail.com"
+}
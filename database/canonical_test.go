@@ -0,0 +1,20 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailKey(t *testing.T) {
+	assert.Equal(t, "alice@example.com", NormalizeEmail("  Alice@Example.COM "))
+	assert.Equal(t, "Alice", NormalizeUsername(" Alice\t"))
+
+	assert.Equal(t, "first.last+news@gmail.com", EmailKey("First.Last+news@Gmail.com"))
+
+	t.Setenv("EMAIL_FOLD_GMAIL", "true")
+	assert.Equal(t, "firstlast@gmail.com", EmailKey("First.Last+news@Gmail.com"))
+	assert.Equal(t, "firstlast@gmail.com", EmailKey("firstlast@googlemail.com"))
+	assert.Equal(t, "first.last+news@example.com", EmailKey("first.last+news@example.com"), "only Gmail ignores dots and tags")
+	assert.Equal(t, "not-an-address", EmailKey("Not-An-Address"))
+}
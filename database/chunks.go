@@ -0,0 +1,89 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Chunk is a part of a file's object and the checksum of its content
+type Chunk struct {
+	Number int
+	Offset int64
+	Size   int64
+	SHA256 string
+}
+
+// FileChunk is a chunk of a file's checksum manifest with the outcome of its
+// last verification
+type FileChunk struct {
+	Chunk
+	Corrupt bool
+	// VerifiedAt is nil until the chunk is first verified
+	VerifiedAt *time.Time
+}
+
+// SaveFileChunks stores the checksum manifest of a file, replacing any earlier
+// one
+func SaveFileChunks(fileID string, chunks []Chunk) error {
+	return WithTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM file_chunks WHERE file_id = $1`, fileID); err != nil {
+			return err
+		}
+		for _, c := range chunks {
+			_, err := tx.Exec(`
+				INSERT INTO file_chunks (file_id, part_number, offset_bytes, size_bytes, sha256)
+				VALUES ($1, $2, $3, $4, $5)
+			`, fileID, c.Number, c.Offset, c.Size, c.SHA256)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListFileChunks returns a file's checksum manifest in part order, empty for
+// files that weren't uploaded in parts
+func ListFileChunks(fileID string) ([]FileChunk, error) {
+	rows, err := GetDB().Query(`
+		SELECT part_number, offset_bytes, size_bytes, sha256, corrupt, verified_at
+		FROM file_chunks
+		WHERE file_id = $1
+		ORDER BY part_number
+	`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []FileChunk
+	for rows.Next() {
+		var c FileChunk
+		if err := rows.Scan(&c.Number, &c.Offset, &c.Size, &c.SHA256, &c.Corrupt, &c.VerifiedAt); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// RecordChunkVerification marks the checked chunks of a file as verified now,
+// flagging those in corrupt and clearing the flag on the others
+func RecordChunkVerification(fileID string, checked, corrupt []int) error {
+	_, err := GetDB().Exec(`
+		UPDATE file_chunks
+		SET corrupt = (part_number = ANY($3)), verified_at = NOW()
+		WHERE file_id = $1 AND part_number = ANY($2)
+	`, fileID, pq.Array(int64s(checked)), pq.Array(int64s(corrupt)))
+	return err
+}
+
+func int64s(ns []int) []int64 {
+	out := make([]int64, len(ns))
+	for i, n := range ns {
+		out[i] = int64(n)
+	}
+	return out
+}
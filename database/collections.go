@@ -0,0 +1,266 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrCollectionCycle is returned when moving a collection would nest it inside itself
+var ErrCollectionCycle = errors.New("a collection can't be moved inside itself")
+
+// ErrDuplicateCollection is returned when a sibling collection already has the name
+var ErrDuplicateCollection = errors.New("a collection with this name already exists here")
+
+type Collection struct {
+	ID     string
+	UserID string
+	// ParentID is empty for top-level collections
+	ParentID  string
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// collectionColumns is the column list read by scanCollection
+const collectionColumns = `id, user_id, COALESCE(parent_id, ''), name, created_at, updated_at`
+
+func scanCollection(row rowScanner, c *Collection) error {
+	return row.Scan(&c.ID, &c.UserID, &c.ParentID, &c.Name, &c.CreatedAt, &c.UpdatedAt)
+}
+
+// collectionError maps unique violations on sibling names to ErrDuplicateCollection
+func collectionError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrDuplicateCollection
+	}
+	return err
+}
+
+// SaveCollection creates a collection. An empty parentID creates a top-level collection.
+func SaveCollection(userID, parentID, name string) (*Collection, error) {
+	var c Collection
+	err := scanCollection(GetDB().QueryRow(`
+		INSERT INTO collections (id, user_id, parent_id, name)
+		VALUES ($1, $2, NULLIF($3, ''), $4)
+		RETURNING `+collectionColumns+`
+	`, NewID(), userID, parentID, name), &c)
+	if err != nil {
+		return nil, collectionError(err)
+	}
+	return &c, nil
+}
+
+// GetCollectionByID retrieves a collection by its ID
+func GetCollectionByID(id string) (*Collection, error) {
+	var c Collection
+	err := scanCollection(GetDB().QueryRow(`
+		SELECT `+collectionColumns+`
+		FROM collections
+		WHERE id = $1
+	`, id), &c)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ListCollections retrieves a user's collections directly inside parentID, or
+// the top-level ones if parentID is empty, sorted by name
+func ListCollections(userID, parentID string) ([]Collection, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+collectionColumns+`
+		FROM collections
+		WHERE user_id = $1 AND COALESCE(parent_id, '') = $2
+		ORDER BY name
+	`, userID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []Collection
+	for rows.Next() {
+		var c Collection
+		if err := scanCollection(rows, &c); err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+// ListAllCollectionsByUser retrieves every collection a user owns, oldest first
+func ListAllCollectionsByUser(userID string) ([]Collection, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+collectionColumns+`
+		FROM collections
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []Collection
+	for rows.Next() {
+		var c Collection
+		if err := scanCollection(rows, &c); err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+// UpdateCollection renames a collection and moves it under parentID (empty for
+// the top level). It returns ErrCollectionCycle if parentID is the collection
+// itself or one of its descendants.
+func UpdateCollection(id, name, parentID string) (*Collection, error) {
+	if parentID != "" {
+		descendants, err := DescendantCollectionIDs(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range descendants {
+			if d == parentID {
+				return nil, ErrCollectionCycle
+			}
+		}
+	}
+
+	var c Collection
+	err := scanCollection(GetDB().QueryRow(`
+		UPDATE collections
+		SET name = $1, parent_id = NULLIF($2, ''), updated_at = NOW()
+		WHERE id = $3
+		RETURNING `+collectionColumns+`
+	`, name, parentID, id), &c)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, collectionError(err)
+	}
+	return &c, nil
+}
+
+// DeleteCollection deletes a collection and every collection nested in it.
+// Their files are kept and moved out of any collection.
+func DeleteCollection(id string) error {
+	_, err := GetDB().Exec(`DELETE FROM collections WHERE id = $1`, id)
+	return err
+}
+
+// DescendantCollectionIDs returns the ID of a collection followed by the IDs
+// of every collection nested in it, at any depth
+func DescendantCollectionIDs(id string) ([]string, error) {
+	rows, err := GetDB().Query(`
+		WITH RECURSIVE tree AS (
+			SELECT id FROM collections WHERE id = $1
+			UNION ALL
+			SELECT c.id FROM collections c JOIN tree ON c.parent_id = tree.id
+		)
+		SELECT id FROM tree
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var cid string
+		if err := rows.Scan(&cid); err != nil {
+			return nil, err
+		}
+		ids = append(ids, cid)
+	}
+	return ids, rows.Err()
+}
+
+// CollectionPath returns the names from the top-level collection down to the
+// given one joined with "/", e.g. "projects/2024/reports"
+func CollectionPath(id string) (string, error) {
+	rows, err := GetDB().Query(`
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id, name, 0 AS depth FROM collections WHERE id = $1
+			UNION ALL
+			SELECT c.id, c.parent_id, c.name, a.depth + 1
+			FROM collections c JOIN ancestors a ON c.id = a.parent_id
+		)
+		SELECT name FROM ancestors ORDER BY depth DESC
+	`, id)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", err
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, "/"), rows.Err()
+}
+
+// ListFilesInCollections retrieves the files directly inside any of the given
+// collections, sorted by name
+func ListFilesInCollections(collectionIDs []string) ([]File, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE collection_id = ANY($1)
+		ORDER BY name, id
+	`, pq.Array(collectionIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// SetFilesCollection moves a user's files into a collection, or out of any
+// collection if collectionID is empty. Files the user doesn't own are skipped;
+// the number of files moved is returned. Nothing is moved if a file's name and
+// revision are already taken in the target collection.
+func SetFilesCollection(fileIDs []string, collectionID, userID string) (int64, error) {
+	return setFilesCollection(GetDB(), fileIDs, collectionID, userID)
+}
+
+// SetFilesCollectionTx is SetFilesCollection run inside a transaction
+func SetFilesCollectionTx(tx *sql.Tx, fileIDs []string, collectionID, userID string) (int64, error) {
+	return setFilesCollection(tx, fileIDs, collectionID, userID)
+}
+
+func setFilesCollection(q querier, fileIDs []string, collectionID, userID string) (int64, error) {
+	res, err := q.Exec(`
+		UPDATE files
+		SET collection_id = NULLIF($1, ''), version = version + 1, updated_at = NOW()
+		WHERE id = ANY($2) AND user_id = $3
+	`, collectionID, pq.Array(fileIDs), userID)
+	if err != nil {
+		return 0, fileError(err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/yourusername/golang-aws-api/envelope"
+)
+
+// Names sealed values are bound to, so a value copied into another column
+// doesn't open
+const (
+	columnUserEmail         = "users.email"
+	columnIdentityEmail     = "user_identities.email"
+	columnWebhookSecret     = "webhooks.secret"
+	columnIntegrationSecret = "integrations.secret"
+	columnSigningKey        = "jwt_signing_keys.private_key"
+)
+
+// sealedPrefix starts a sealed value, followed by the data key's ID, a colon
+// and the base64 nonce and ciphertext. Values without it were stored before
+// encryption was turned on and are read as is.
+const sealedPrefix = "enc:v1:"
+
+// hashedPrefix starts an email key hashed with the index key
+const hashedPrefix = "hmac:"
+
+// encodeColumn seals value for the column name under the data key with id
+func encodeColumn(id string, key []byte, name, value string) (string, error) {
+	sealed, err := envelope.SealValue(key, []byte(value), []byte(name))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// parseSealed splits a sealed value into its data key's ID and ciphertext,
+// reporting whether stored is sealed at all
+func parseSealed(stored string) (string, []byte, bool, error) {
+	rest, ok := strings.CutPrefix(stored, sealedPrefix)
+	if !ok {
+		return "", nil, false, nil
+	}
+	id, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", nil, true, fmt.Errorf("malformed sealed value")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, true, fmt.Errorf("malformed sealed value: %v", err)
+	}
+	return id, sealed, true, nil
+}
+
+// encryptColumn returns value as it is stored in the column name: sealed
+// under the active data key when encryption is on, as is otherwise
+func encryptColumn(ctx context.Context, name, value string) (string, error) {
+	if value == "" || !columnEncryptionEnabled() {
+		return value, nil
+	}
+	id, key, err := activeColumnKey(ctx, columnKeyData, true)
+	if err != nil {
+		return "", err
+	}
+	return encodeColumn(id, key, name, value)
+}
+
+// decryptColumn returns the value stored in the column name, opening it if
+// it was sealed
+func decryptColumn(ctx context.Context, name, stored string) (string, error) {
+	id, sealed, ok, err := parseSealed(stored)
+	if !ok || err != nil {
+		return stored, err
+	}
+	key, err := columnKey(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := envelope.OpenValue(key, sealed, []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %w", name, err)
+	}
+	return string(plaintext), nil
+}
+
+// sealedValue is a query argument for a sensitive column, encrypted as it is
+// sent
+type sealedValue struct {
+	name, value string
+}
+
+func (v sealedValue) Value() (driver.Value, error) {
+	return encryptColumn(context.Background(), v.name, v.value)
+}
+
+// sealColumn returns value as a query argument for the column name
+func sealColumn(name, value string) driver.Valuer {
+	return sealedValue{name: name, value: value}
+}
+
+// openedValue scans a sensitive column into dest, decrypting it
+type openedValue struct {
+	name string
+	dest *string
+}
+
+func (v openedValue) Scan(src interface{}) error {
+	var stored sql.NullString
+	if err := stored.Scan(src); err != nil {
+		return err
+	}
+	value, err := decryptColumn(context.Background(), v.name, stored.String)
+	if err != nil {
+		return err
+	}
+	*v.dest = value
+	return nil
+}
+
+// openColumn returns a scan destination for the column name that stores its
+// plain value in dest
+func openColumn(name string, dest *string) sql.Scanner {
+	return openedValue{name: name, dest: dest}
+}
+
+// hashEmailKey hashes an EmailKey with the index key
+func hashEmailKey(indexKey []byte, emailKey string) string {
+	mac := hmac.New(sha256.New, indexKey)
+	mac.Write([]byte(emailKey))
+	return hashedPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// emailKeyValue is the email_key argument for a new user: EmailKey(email),
+// hashed with the index key when encryption is on so the address can't be
+// read from it
+type emailKeyValue string
+
+func (v emailKeyValue) Value() (driver.Value, error) {
+	key := EmailKey(string(v))
+	if !columnEncryptionEnabled() {
+		return key, nil
+	}
+	_, indexKey, err := activeColumnKey(context.Background(), columnKeyIndex, true)
+	if err != nil {
+		return nil, err
+	}
+	return hashEmailKey(indexKey, key), nil
+}
+
+// emailKeysValue is the argument matching every email_key a user with the
+// email may be stored under: EmailKey(email) and, once there is an index
+// key, its hash
+type emailKeysValue string
+
+func (v emailKeysValue) Value() (driver.Value, error) {
+	key := EmailKey(string(v))
+	keys := pq.StringArray{key}
+	id, indexKey, err := activeColumnKey(context.Background(), columnKeyIndex, false)
+	if err != nil {
+		return nil, err
+	}
+	if id != "" {
+		keys = append(keys, hashEmailKey(indexKey, key))
+	}
+	return keys.Value()
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSealedColumnRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{9}, 32)
+	columnKeys.Lock()
+	columnKeys.unwrapped["k1"] = key
+	columnKeys.Unlock()
+	defer func() {
+		columnKeys.Lock()
+		delete(columnKeys.unwrapped, "k1")
+		columnKeys.Unlock()
+	}()
+
+	stored, err := encodeColumn("k1", key, columnUserEmail, "alice@example.com")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored, sealedPrefix+"k1:"))
+	assert.NotContains(t, stored, "alice")
+
+	var email string
+	assert.NoError(t, openColumn(columnUserEmail, &email).Scan(stored))
+	assert.Equal(t, "alice@example.com", email)
+
+	// A value is bound to its column
+	_, err = decryptColumn(context.Background(), columnWebhookSecret, stored)
+	assert.Error(t, err)
+
+	// Values stored before encryption was turned on are read as is
+	assert.NoError(t, openColumn(columnUserEmail, &email).Scan([]byte("bob@example.com")))
+	assert.Equal(t, "bob@example.com", email)
+	assert.NoError(t, openColumn(columnUserEmail, &email).Scan(nil))
+	assert.Equal(t, "", email)
+
+	_, err = decryptColumn(context.Background(), columnUserEmail, sealedPrefix+"k1")
+	assert.Error(t, err)
+}
+
+func TestSealColumnWithoutEncryption(t *testing.T) {
+	v, err := sealColumn(columnWebhookSecret, "s3cret").Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", v)
+
+	v, err = emailKeyValue(" Alice@Example.com ").Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", v)
+}
+
+func TestHashEmailKey(t *testing.T) {
+	key := bytes.Repeat([]byte{5}, 32)
+	hashed := hashEmailKey(key, "alice@example.com")
+	assert.True(t, strings.HasPrefix(hashed, hashedPrefix))
+	assert.NotContains(t, hashed, "alice")
+	assert.Equal(t, hashed, hashEmailKey(key, "alice@example.com"), "lookups need the same hash every time")
+	assert.NotEqual(t, hashed, hashEmailKey(key, "bob@example.com"))
+	assert.NotEqual(t, hashed, hashEmailKey(bytes.Repeat([]byte{6}, 32), "alice@example.com"))
+}
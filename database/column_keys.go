@@ -0,0 +1,368 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/envelope"
+)
+
+// Column key purposes: data keys seal sensitive columns and are rotated; the
+// index key hashes email keys so users can still be looked up by email, and
+// is never rotated since the hashes couldn't be recomputed without it
+const (
+	columnKeyData  = "data"
+	columnKeyIndex = "index"
+)
+
+// columnKeyRefresh is how long a process keeps using the active key it
+// loaded before checking whether it was rotated
+const columnKeyRefresh = time.Minute
+
+// ErrColumnEncryptionOff is returned when rotating or re-encrypting column
+// keys without a KMS key configured to generate them under
+var ErrColumnEncryptionOff = errors.New("column encryption is not configured")
+
+// ColumnKey is a data key sensitive columns are encrypted with, stored
+// wrapped by KMS
+type ColumnKey struct {
+	ID       string
+	Purpose  string
+	KMSKeyID string
+	// Active keys seal new values; retired ones only open old values
+	Active    bool
+	CreatedAt time.Time
+	RetiredAt *time.Time
+}
+
+type cachedColumnKey struct {
+	id       string
+	loadedAt time.Time
+}
+
+// columnKeys holds the key service and the keys unwrapped so far, so KMS is
+// only asked once per key and process
+var columnKeys = struct {
+	sync.Mutex
+	service   envelope.KeyService
+	kmsKeyID  string
+	unwrapped map[string][]byte
+	current   map[string]cachedColumnKey
+}{
+	unwrapped: make(map[string][]byte),
+	current:   make(map[string]cachedColumnKey),
+}
+
+// SetColumnEncryption configures the encryption of sensitive columns. keys
+// unwraps the data keys values were sealed with. New values are sealed too
+// when kmsKeyID, the KMS key data keys are generated under, is set;
+// otherwise they are stored as is.
+func SetColumnEncryption(keys envelope.KeyService, kmsKeyID string) {
+	columnKeys.Lock()
+	defer columnKeys.Unlock()
+	columnKeys.service = keys
+	columnKeys.kmsKeyID = kmsKeyID
+	columnKeys.current = make(map[string]cachedColumnKey)
+}
+
+// columnEncryptionEnabled reports whether new values are sealed
+func columnEncryptionEnabled() bool {
+	columnKeys.Lock()
+	defer columnKeys.Unlock()
+	return columnKeys.service != nil && columnKeys.kmsKeyID != ""
+}
+
+// columnKeyContext ties a wrapped data key to its row
+func columnKeyContext(id string) map[string]string {
+	return map[string]string{"column_key_id": id}
+}
+
+// unwrapColumnKey returns the data key with id. The caller holds the lock.
+func unwrapColumnKey(ctx context.Context, id string) ([]byte, error) {
+	if key, ok := columnKeys.unwrapped[id]; ok {
+		return key, nil
+	}
+	if columnKeys.service == nil {
+		return nil, envelope.ErrNoKeyService
+	}
+	var kmsKeyID string
+	var wrapped []byte
+	err := GetDB().QueryRow(`SELECT kms_key_id, wrapped_key FROM column_keys WHERE id = $1`, id).Scan(&kmsKeyID, &wrapped)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("unknown column key %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := columnKeys.service.Decrypt(ctx, kmsKeyID, wrapped, columnKeyContext(id))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping column key %s: %w", id, err)
+	}
+	columnKeys.unwrapped[id] = key
+	return key, nil
+}
+
+// columnKey returns the data key with id
+func columnKey(ctx context.Context, id string) ([]byte, error) {
+	columnKeys.Lock()
+	defer columnKeys.Unlock()
+	return unwrapColumnKey(ctx, id)
+}
+
+// activeColumnKey returns the active key for purpose, generating the first
+// one if there is none and create is set. It returns an empty ID if there is
+// no key and none was created.
+func activeColumnKey(ctx context.Context, purpose string, create bool) (string, []byte, error) {
+	columnKeys.Lock()
+	defer columnKeys.Unlock()
+	if c, ok := columnKeys.current[purpose]; ok && time.Since(c.loadedAt) < columnKeyRefresh && (c.id != "" || !create) {
+		if c.id == "" {
+			return "", nil, nil
+		}
+		key, err := unwrapColumnKey(ctx, c.id)
+		return c.id, key, err
+	}
+
+	id, err := loadActiveColumnKey(purpose)
+	if err != nil {
+		return "", nil, err
+	}
+	if id == "" && create {
+		if err := insertColumnKey(ctx, GetDB(), purpose); err != nil {
+			return "", nil, err
+		}
+		// Another process may have created it first
+		if id, err = loadActiveColumnKey(purpose); err != nil {
+			return "", nil, err
+		}
+	}
+	columnKeys.current[purpose] = cachedColumnKey{id: id, loadedAt: time.Now()}
+	if id == "" {
+		return "", nil, nil
+	}
+	key, err := unwrapColumnKey(ctx, id)
+	return id, key, err
+}
+
+func loadActiveColumnKey(purpose string) (string, error) {
+	var id string
+	err := GetDB().QueryRow(`SELECT id FROM column_keys WHERE purpose = $1 AND active`, purpose).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// insertColumnKey generates a key for purpose under the configured KMS key
+// and stores it as the active one, unless there already is one. The caller
+// holds the lock.
+func insertColumnKey(ctx context.Context, q querier, purpose string) error {
+	if columnKeys.service == nil || columnKeys.kmsKeyID == "" {
+		return ErrColumnEncryptionOff
+	}
+	id := NewID()
+	key, wrapped, err := columnKeys.service.GenerateDataKey(ctx, columnKeys.kmsKeyID, columnKeyContext(id))
+	if err != nil {
+		return fmt.Errorf("generating column key: %w", err)
+	}
+	res, err := q.Exec(`
+		INSERT INTO column_keys (id, purpose, kms_key_id, wrapped_key)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`, id, purpose, columnKeys.kmsKeyID, wrapped)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		columnKeys.unwrapped[id] = key
+	}
+	return nil
+}
+
+// ListColumnKeys returns every column key, oldest first
+func ListColumnKeys() ([]ColumnKey, error) {
+	rows, err := GetDB().Query(`
+		SELECT id, purpose, kms_key_id, active, created_at, retired_at
+		FROM column_keys
+		ORDER BY created_at, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []ColumnKey
+	for rows.Next() {
+		var k ColumnKey
+		if err := rows.Scan(&k.ID, &k.Purpose, &k.KMSKeyID, &k.Active, &k.CreatedAt, &k.RetiredAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RotateColumnKey retires the active data key in favour of a new one
+// generated under the configured KMS key, and returns the new key's ID.
+// Values sealed under retired keys still open; ReencryptColumns moves them
+// to the new key. Other processes pick it up within columnKeyRefresh.
+func RotateColumnKey(ctx context.Context) (string, error) {
+	columnKeys.Lock()
+	defer columnKeys.Unlock()
+	err := WithTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE column_keys SET active = false, retired_at = NOW()
+			WHERE purpose = $1 AND active
+		`, columnKeyData)
+		if err != nil {
+			return err
+		}
+		return insertColumnKey(ctx, tx, columnKeyData)
+	})
+	if err != nil {
+		return "", err
+	}
+	delete(columnKeys.current, columnKeyData)
+	return loadActiveColumnKey(columnKeyData)
+}
+
+// sealedColumns are the sensitive columns stored encrypted, by the name their
+// values are bound to. Both of an integration's secrets share a name, since
+// rotating its secret copies one column into the other.
+var sealedColumns = []struct {
+	table, column, name string
+}{
+	{"users", "email", columnUserEmail},
+	{"user_identities", "email", columnIdentityEmail},
+	{"webhooks", "secret", columnWebhookSecret},
+	{"integrations", "secret", columnIntegrationSecret},
+	{"integrations", "previous_secret", columnIntegrationSecret},
+	{"jwt_signing_keys", "private_key", columnSigningKey},
+}
+
+// staleColumnValues is the condition for values of column not sealed under
+// the active data key, given the key's prefix as $1
+func staleColumnValues(column string) string {
+	return column + ` <> '' AND ` + column + ` NOT LIKE $1`
+}
+
+// CountStaleColumns returns, by table and column, how many values aren't
+// sealed under the active data key, and how many email keys aren't hashed
+func CountStaleColumns(ctx context.Context) (map[string]int, error) {
+	id, _, err := activeColumnKey(ctx, columnKeyData, false)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, c := range sealedColumns {
+		var n int
+		err := GetDB().QueryRow(`SELECT COUNT(*) FROM `+c.table+` WHERE `+staleColumnValues(c.column), sealedPrefix+id+":%").Scan(&n)
+		if err != nil {
+			return nil, err
+		}
+		counts[c.table+"."+c.column] = n
+	}
+	var n int
+	err = GetDB().QueryRow(`SELECT COUNT(*) FROM users WHERE `+staleColumnValues("email_key"), hashedPrefix+"%").Scan(&n)
+	if err != nil {
+		return nil, err
+	}
+	counts["users.email_key"] = n
+	return counts, nil
+}
+
+// ReencryptColumns seals every sensitive value that isn't sealed under the
+// active data key with it, including values stored before encryption was
+// turned on, and hashes email keys stored in plain text. It works batchSize
+// rows at a time and returns how many values it rewrote. Values changed
+// while it runs are left for the next run.
+func ReencryptColumns(ctx context.Context, batchSize int) (int, error) {
+	if !columnEncryptionEnabled() {
+		return 0, ErrColumnEncryptionOff
+	}
+	id, key, err := activeColumnKey(ctx, columnKeyData, true)
+	if err != nil {
+		return 0, err
+	}
+	_, indexKey, err := activeColumnKey(ctx, columnKeyIndex, true)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, c := range sealedColumns {
+		n, err := rewriteColumn(c.table, c.column, sealedPrefix+id+":%", batchSize, func(value string) (string, error) {
+			plaintext, err := decryptColumn(ctx, c.name, value)
+			if err != nil {
+				return "", err
+			}
+			return encodeColumn(id, key, c.name, plaintext)
+		})
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("re-encrypting %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	n, err := rewriteColumn("users", "email_key", hashedPrefix+"%", batchSize, func(value string) (string, error) {
+		return hashEmailKey(indexKey, value), nil
+	})
+	total += n
+	if err != nil {
+		return total, fmt.Errorf("hashing email keys: %w", err)
+	}
+	return total, nil
+}
+
+// rewriteColumn replaces the values of column not matching the LIKE pattern
+// done with what rewrite makes of them, batchSize rows at a time. Rows are
+// addressed by ctid, since not every table has a single key column, and
+// only updated if the value is still the one read.
+func rewriteColumn(table, column, done string, batchSize int, rewrite func(string) (string, error)) (int, error) {
+	total := 0
+	for {
+		rows, err := GetDB().Query(`SELECT ctid::text, `+column+` FROM `+table+` WHERE `+staleColumnValues(column)+` LIMIT $2`, done, batchSize)
+		if err != nil {
+			return total, err
+		}
+		type row struct{ ctid, value string }
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.ctid, &r.value); err != nil {
+				rows.Close()
+				return total, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+
+		updated := 0
+		for _, r := range batch {
+			value, err := rewrite(r.value)
+			if err != nil {
+				return total, err
+			}
+			res, err := GetDB().Exec(`UPDATE `+table+` SET `+column+` = $1 WHERE ctid = $2::tid AND `+column+` = $3`, value, r.ctid, r.value)
+			if err != nil {
+				return total, err
+			}
+			n, _ := res.RowsAffected()
+			updated += int(n)
+		}
+		total += updated
+		// Only rows changed underneath are left; don't spin on them
+		if updated == 0 {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CustomProcessor is a user's own Lambda function, invoked on each of their
+// files after the built-in processing completes
+type CustomProcessor struct {
+	UserID         string
+	FunctionARN    string
+	RoleARN        string
+	TimeoutSeconds int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+const customProcessorColumns = `user_id, function_arn, role_arn, timeout_seconds, created_at, updated_at`
+
+func scanCustomProcessor(row rowScanner, c *CustomProcessor) error {
+	return row.Scan(&c.UserID, &c.FunctionARN, &c.RoleARN, &c.TimeoutSeconds, &c.CreatedAt, &c.UpdatedAt)
+}
+
+// GetCustomProcessor returns a user's custom processor, or nil if they haven't
+// registered one
+func GetCustomProcessor(userID string) (*CustomProcessor, error) {
+	var c CustomProcessor
+	err := scanCustomProcessor(GetDB().QueryRow(`
+		SELECT `+customProcessorColumns+`
+		FROM custom_processors
+		WHERE user_id = $1
+	`, userID), &c)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// SaveCustomProcessor registers a user's custom processor, replacing the one
+// they had
+func SaveCustomProcessor(userID, functionARN, roleARN string, timeoutSeconds int) (*CustomProcessor, error) {
+	var c CustomProcessor
+	err := scanCustomProcessor(GetDB().QueryRow(`
+		INSERT INTO custom_processors (user_id, function_arn, role_arn, timeout_seconds)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET function_arn = EXCLUDED.function_arn, role_arn = EXCLUDED.role_arn,
+			timeout_seconds = EXCLUDED.timeout_seconds, updated_at = NOW()
+		RETURNING `+customProcessorColumns+`
+	`, userID, functionARN, roleARN, timeoutSeconds), &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// DeleteCustomProcessor removes a user's custom processor, reporting whether
+// they had one
+func DeleteCustomProcessor(userID string) (bool, error) {
+	res, err := GetDB().Exec(`DELETE FROM custom_processors WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -2,16 +2,26 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/yourusername/golang-aws-api/pagination"
 )
 
 var db *sql.DB
 
+// connInfo is the connection string db was opened with, kept for the
+// dedicated connections LISTEN needs
+var connInfo string
+
+// ErrConflict is returned when an update loses a race with a concurrent update,
+// i.e. the row's version no longer matches the one the caller read
+var ErrConflict = errors.New("row was modified by another request")
+
 // InitDB initializes the database connection and creates necessary tables
 func InitDB() error {
 	// Set up PostgreSQL connection
@@ -38,6 +48,10 @@ func InitDB() error {
 
 	dbInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		dbHost, dbPort, dbUser, dbPassword, dbName)
+	connInfo = dbInfo
+
+	loadIDStrategy()
+	loadNamePolicy()
 
 	log.Printf("Attempting to connect to database at %s:%s...", dbHost, dbPort)
 
@@ -75,39 +89,7 @@ func InitDB() error {
 	db.SetMaxIdleConns(25)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	log.Printf("Creating database tables...")
-	// Create tables if not exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			id TEXT PRIMARY KEY,
-			username TEXT UNIQUE NOT NULL,
-			password TEXT NOT NULL,
-			email TEXT UNIQUE NOT NULL,
-			confirmed BOOLEAN NOT NULL DEFAULT FALSE,
-			created_at TIMESTAMP NOT NULL DEFAULT NOW()
-		);
-
-		CREATE TABLE IF NOT EXISTS files (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			s3_key TEXT NOT NULL,
-			created_at TIMESTAMP NOT NULL DEFAULT NOW()
-		);
-		
-		CREATE TABLE IF NOT EXISTS processing_results (
-			id TEXT PRIMARY KEY,
-			file_id TEXT NOT NULL REFERENCES files(id),
-			status TEXT NOT NULL,
-			result TEXT NOT NULL,
-			created_at TIMESTAMP NOT NULL DEFAULT NOW()
-		);
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to create tables: %v", err)
-	}
-	log.Printf("Database tables created successfully")
-
-	return nil
+	return migrate()
 }
 
 // GetDB returns the database connection
@@ -117,3 +99,27 @@ func GetDB() *sql.DB {
 	}
 	return db
 }
+
+// versionConflict is called when a compare-and-swap update matched no row. It
+// tells a stale version (ErrConflict) apart from a missing row (nil).
+func versionConflict(table, id string) error {
+	var exists bool
+	err := GetDB().QueryRow(`SELECT EXISTS(SELECT 1 FROM `+table+` WHERE id = $1)`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrConflict
+	}
+	return nil
+}
+
+// keysetBounds returns the created_at and id arguments for a keyset query that
+// lists rows newest first with `(created_at, id) < ($n::timestamp, $m::text)`.
+// The first page starts from 'infinity' so every row qualifies.
+func keysetBounds(after *pagination.Cursor) (interface{}, string) {
+	if after == nil {
+		return "infinity", ""
+	}
+	return after.CreatedAt, after.ID
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// FileDependency is an edge of the dependency graph: FileID is processed once
+// DependsOn has completed
+type FileDependency struct {
+	FileID    string
+	DependsOn string
+	// Status is the status of DependsOn's latest result, empty while it has
+	// none
+	Status string
+}
+
+// BlockedJob is a scheduled job cancelled because a file it depends on ended
+// without completing
+type BlockedJob struct {
+	JobID     string
+	FileID    string
+	DependsOn string
+	Status    string
+}
+
+// latestStatus is a subquery for the status of the latest built-in result of
+// the file whose ID is the column expression fileID
+func latestStatus(fileID string) string {
+	return `(SELECT status FROM processing_results WHERE file_id = ` + fileID + ` AND source = 'builtin' ORDER BY created_at DESC LIMIT 1)`
+}
+
+// SaveFileDependenciesTx records that fileID depends on each of dependsOn
+func SaveFileDependenciesTx(tx *sql.Tx, fileID string, dependsOn []string) error {
+	_, err := tx.Exec(`
+		INSERT INTO file_dependencies (file_id, depends_on)
+		SELECT $1, unnest($2::text[])
+		ON CONFLICT DO NOTHING
+	`, fileID, pq.Array(dependsOn))
+	return err
+}
+
+// ListFileDependencies returns the dependencies of each of the files, keyed by
+// file ID. Files without dependencies are left out.
+func ListFileDependencies(ids []string) (map[string][]FileDependency, error) {
+	rows, err := GetDB().Query(`
+		SELECT d.file_id, d.depends_on, COALESCE(`+latestStatus("d.depends_on")+`, '')
+		FROM file_dependencies d
+		WHERE d.file_id = ANY($1)
+		ORDER BY d.file_id, d.depends_on
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deps := make(map[string][]FileDependency)
+	for rows.Next() {
+		var d FileDependency
+		if err := rows.Scan(&d.FileID, &d.DependsOn, &d.Status); err != nil {
+			return nil, err
+		}
+		deps[d.FileID] = append(deps[d.FileID], d)
+	}
+	return deps, rows.Err()
+}
+
+// FailBlockedScheduledJobs cancels the pending jobs of files that depend on a
+// file whose latest result is final but not completed, and gives each of
+// those files a dependency_failed result, so their own dependents fail in
+// turn. A quarantined result isn't final until it is reviewed. Rows locked by
+// another instance are skipped.
+func FailBlockedScheduledJobs(limit int) ([]BlockedJob, error) {
+	var blocked []BlockedJob
+	err := WithTx(func(tx *sql.Tx) error {
+		// FOR UPDATE can't be combined with DISTINCT, so a job blocked by
+		// more than one dependency is only kept once below
+		rows, err := tx.Query(`
+			SELECT j.id, j.file_id, d.depends_on, `+latestStatus("d.depends_on")+`
+			FROM scheduled_jobs j
+			JOIN file_dependencies d ON d.file_id = j.file_id
+			WHERE j.status = $1 AND `+latestStatus("d.depends_on")+` NOT IN ('completed', $2, $3)
+			ORDER BY j.id, d.depends_on
+			LIMIT $4
+			FOR UPDATE OF j SKIP LOCKED
+		`, ScheduledJobPending, StatusExpanding, StatusQuarantined, limit)
+		if err != nil {
+			return err
+		}
+		seen := make(map[string]bool)
+		for rows.Next() {
+			var b BlockedJob
+			if err := rows.Scan(&b.JobID, &b.FileID, &b.DependsOn, &b.Status); err != nil {
+				rows.Close()
+				return err
+			}
+			if !seen[b.JobID] {
+				seen[b.JobID] = true
+				blocked = append(blocked, b)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, b := range blocked {
+			if err := updateScheduledJobStatus(tx, b.JobID, ScheduledJobCancelled); err != nil {
+				return err
+			}
+			reason := fmt.Sprintf("Dependency %s ended with the status %s", b.DependsOn, b.Status)
+			if err := saveProcessingResult(tx, b.FileID, StatusDependencyFailed, reason, ResultBuiltin, "", 0); err != nil {
+				return err
+			}
+			payload := map[string]string{"status": StatusDependencyFailed, "depends_on": b.DependsOn}
+			if err := RecordEventTx(tx, EventFileProcessed, b.FileID, "", payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return blocked, err
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// Domain event types
+const (
+	EventFileUploaded  = "file.uploaded"
+	EventFileProcessed = "file.processed"
+	EventFileStage     = "file.stage"
+	EventFileDeleted   = "file.deleted"
+	EventFileShared    = "file.shared"
+	EventFileLegalHold = "file.legal_hold"
+	EventFileRequeued  = "file.requeued"
+)
+
+// Event is a recorded domain event. Events are written in the same
+// transaction as the change they describe, so the table is a complete history
+// that downstream projections can be rebuilt from.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	FileID    string          `json:"file_id"`
+	UserID    string          `json:"user_id,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// EventFilter selects events to list. Zero fields match everything.
+type EventFilter struct {
+	Types  []string
+	FileID string
+	UserID string
+	Since  time.Time
+	Until  time.Time
+}
+
+// RecordEvent stores a domain event with payload encoded as JSON. Without a
+// userID the event is attributed to the file's owner.
+func RecordEvent(eventType, fileID, userID string, payload interface{}) error {
+	return recordEvent(GetDB(), eventType, fileID, userID, payload)
+}
+
+// RecordEventTx is RecordEvent run inside a transaction
+func RecordEventTx(tx *sql.Tx, eventType, fileID, userID string, payload interface{}) error {
+	return recordEvent(tx, eventType, fileID, userID, payload)
+}
+
+func recordEvent(q querier, eventType, fileID, userID string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(`
+		INSERT INTO events (id, type, file_id, user_id, payload)
+		VALUES ($1, $2, $3, COALESCE(NULLIF($4, ''), (SELECT user_id FROM files WHERE id = $3)), $5)
+	`, NewID(), eventType, fileID, userID, data)
+	return err
+}
+
+// recordFileUploaded records EventFileUploaded for a newly saved file, and
+// meters the upload
+func recordFileUploaded(q querier, f *File) error {
+	err := recordEvent(q, EventFileUploaded, f.ID, f.UserID, map[string]interface{}{
+		"name":          f.Name,
+		"name_revision": f.NameRevision,
+		"s3_key":        f.S3Key,
+		"size_bytes":    f.SizeBytes,
+		"collection_id": f.CollectionID,
+	})
+	if err != nil {
+		return err
+	}
+	return recordUsage(q, f.UserID, MeterBytesUploaded, f.SizeBytes, f.ID)
+}
+
+// ListEvents retrieves up to limit events matching filter, oldest first,
+// starting after the given cursor
+func ListEvents(filter EventFilter, after *pagination.Cursor, limit int) ([]Event, error) {
+	var createdAt interface{} = "-infinity"
+	var id string
+	if after != nil {
+		createdAt, id = after.CreatedAt, after.ID
+	}
+	var since, until interface{} = "-infinity", "infinity"
+	if !filter.Since.IsZero() {
+		since = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		until = filter.Until
+	}
+
+	rows, err := GetDB().Query(`
+		SELECT id, type, file_id, COALESCE(user_id, ''), payload, created_at
+		FROM events
+		WHERE (created_at, id) > ($1::timestamp, $2::text)
+			AND created_at >= $3::timestamp AND created_at < $4::timestamp
+			AND (cardinality($5::text[]) = 0 OR type = ANY($5))
+			AND ($6 = '' OR file_id = $6)
+			AND ($7 = '' OR user_id = $7)
+		ORDER BY created_at, id
+		LIMIT $8
+	`, createdAt, id, since, until, pq.Array(filter.Types), filter.FileID, filter.UserID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var payload []byte
+		if err := rows.Scan(&e.ID, &e.Type, &e.FileID, &e.UserID, &payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Export job statuses
+const (
+	ExportPending   = "pending"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+)
+
+type Export struct {
+	ID          string
+	UserID      string
+	Format      string
+	Status      string
+	S3Key       string
+	Error       string
+	CreatedAt   time.Time
+	CompletedAt sql.NullTime
+}
+
+// SaveExport creates a new pending export job
+func SaveExport(userID, format string) (*Export, error) {
+	var e Export
+	err := GetDB().QueryRow(`
+		INSERT INTO exports (id, user_id, format, status)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, user_id, format, status, s3_key, error, created_at, completed_at
+	`, NewID(), userID, format, ExportPending).Scan(&e.ID, &e.UserID, &e.Format, &e.Status, &e.S3Key, &e.Error, &e.CreatedAt, &e.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// GetExportByID retrieves an export job by its ID
+func GetExportByID(id string) (*Export, error) {
+	var e Export
+	err := GetDB().QueryRow(`
+		SELECT id, user_id, format, status, s3_key, error, created_at, completed_at
+		FROM exports
+		WHERE id = $1
+	`, id).Scan(&e.ID, &e.UserID, &e.Format, &e.Status, &e.S3Key, &e.Error, &e.CreatedAt, &e.CompletedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// UpdateExportStatus sets the status of an export job
+func UpdateExportStatus(id, status string) error {
+	_, err := GetDB().Exec(`
+		UPDATE exports
+		SET status = $1
+		WHERE id = $2
+	`, status, id)
+	return err
+}
+
+// CompleteExport marks an export job as completed with the S3 key of its archive
+func CompleteExport(id, s3Key string) error {
+	_, err := GetDB().Exec(`
+		UPDATE exports
+		SET status = $1, s3_key = $2, completed_at = NOW()
+		WHERE id = $3
+	`, ExportCompleted, s3Key, id)
+	return err
+}
+
+// FailExport marks an export job as failed with the reason
+func FailExport(id, reason string) error {
+	_, err := GetDB().Exec(`
+		UPDATE exports
+		SET status = $1, error = $2, completed_at = NOW()
+		WHERE id = $3
+	`, ExportFailed, reason, id)
+	return err
+}
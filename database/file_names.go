@@ -0,0 +1,226 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/yourusername/golang-aws-api/envelope"
+)
+
+// Policies for uploading a file whose name its owner already uses in the same
+// collection
+const (
+	// NamePolicyReject refuses the upload with ErrDuplicateFileName
+	NamePolicyReject = "reject"
+	// NamePolicyRename saves the file under the first free name-N variant
+	NamePolicyRename = "rename"
+	// NamePolicyVersion keeps the name and saves the file as its next revision
+	NamePolicyVersion = "version"
+)
+
+// ErrDuplicateFileName is returned when the owner already has a file with the name
+var ErrDuplicateFileName = errors.New("a file with this name already exists")
+
+// fileNameIndex is the unique index backing the name policies
+const fileNameIndex = "idx_files_user_collection_name"
+
+// maxNameAttempts bounds how often CreateFile retries after losing a race for
+// a name to a concurrent upload
+const maxNameAttempts = 5
+
+// namePolicy is the policy used when a request doesn't pick one. Versioning
+// keeps the old behaviour of accepting any name.
+var namePolicy = NamePolicyVersion
+
+// ValidNamePolicy reports whether policy is a known duplicate name policy
+func ValidNamePolicy(policy string) bool {
+	switch policy {
+	case NamePolicyReject, NamePolicyRename, NamePolicyVersion:
+		return true
+	}
+	return false
+}
+
+// SetNamePolicy sets the default duplicate name policy. Unknown policies are
+// rejected and the current one is kept.
+func SetNamePolicy(policy string) bool {
+	if !ValidNamePolicy(policy) {
+		return false
+	}
+	namePolicy = policy
+	return true
+}
+
+// NamePolicy returns the default duplicate name policy
+func NamePolicy() string {
+	return namePolicy
+}
+
+// loadNamePolicy reads the default duplicate name policy from FILE_NAME_POLICY
+func loadNamePolicy() {
+	if v := os.Getenv("FILE_NAME_POLICY"); v != "" && !SetNamePolicy(v) {
+		log.Printf("Invalid FILE_NAME_POLICY %q, using %s", v, namePolicy)
+	}
+}
+
+// fileError maps unique violations on file names to ErrDuplicateFileName
+func fileError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == fileNameIndex {
+		return ErrDuplicateFileName
+	}
+	return err
+}
+
+// NewFile describes a file to create with CreateFile
+type NewFile struct {
+	ID           string
+	Name         string
+	UserID       string
+	CollectionID string
+	SizeBytes    int64
+	// StorageClass defaults to STANDARD when empty
+	StorageClass string
+	// RetentionMode and RetainUntil are the Object Lock retention the object
+	// is written with; an empty mode means none
+	RetentionMode string
+	RetainUntil   time.Time
+	// Encryption is the envelope the content was encrypted with, if any
+	Encryption envelope.Envelope
+	// S3Key builds the object key from the name the file is finally saved under
+	S3Key func(name string) string
+	// ParentID links a member of an expanded archive to the archive
+	ParentID string
+	// ExpandArchive marks an archive to be expanded once processed
+	ExpandArchive bool
+	// ContentType is the MIME type detected from the content
+	ContentType string
+	// RedactionPending marks a file whose pipeline redacts personal data,
+	// see File.RedactionPending
+	RedactionPending bool
+}
+
+// CreateFile saves a new file, applying policy if its owner already has a file
+// with the same name in the same collection. Files without an owner are never
+// checked.
+func CreateFile(nf NewFile, policy string) (*File, error) {
+	var f *File
+	err := WithTx(func(tx *sql.Tx) error {
+		var err error
+		f, err = createFile(tx, nf, policy)
+		return err
+	})
+	return f, err
+}
+
+// CreateFileTx is CreateFile run inside a transaction
+func CreateFileTx(tx *sql.Tx, nf NewFile, policy string) (*File, error) {
+	return createFile(tx, nf, policy)
+}
+
+func createFile(q querier, nf NewFile, policy string) (*File, error) {
+	if !ValidNamePolicy(policy) {
+		return nil, fmt.Errorf("unknown name policy %q", policy)
+	}
+
+	for attempt := 0; attempt < maxNameAttempts; attempt++ {
+		name, revision := nf.Name, 1
+		if nf.UserID != "" {
+			taken, err := namesInUse(q, nf.UserID, nf.CollectionID, nf.Name)
+			if err != nil {
+				return nil, err
+			}
+			if latest, ok := taken[nf.Name]; ok {
+				switch policy {
+				case NamePolicyReject:
+					return nil, ErrDuplicateFileName
+				case NamePolicyRename:
+					name = nextFreeName(nf.Name, taken)
+				case NamePolicyVersion:
+					revision = latest + 1
+				}
+			}
+		}
+
+		// A concurrent upload that takes the name first makes the insert a
+		// no-op, and the name is worked out again
+		var f File
+		err := scanFile(q.QueryRow(`
+			INSERT INTO files (id, name, s3_key, user_id, size_bytes, collection_id, name_revision, storage_class, retention_mode, retain_until, encryption_algorithm, encryption_key_id, encrypted_data_key, parent_id, expand_archive, content_type, redaction_pending)
+			VALUES ($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, ''), $7, COALESCE(NULLIF($8, ''), 'STANDARD'), $9, $10, $11, $12, $13, NULLIF($14, ''), $15, $16, $17)
+			ON CONFLICT (user_id, (COALESCE(collection_id, '')), name, name_revision)
+				WHERE user_id IS NOT NULL
+				DO NOTHING
+			RETURNING `+fileColumns+`
+		`, nf.ID, name, nf.S3Key(name), nf.UserID, nf.SizeBytes, nf.CollectionID, revision, nf.StorageClass, nf.RetentionMode, retainUntil(nf),
+			nf.Encryption.Algorithm, nf.Encryption.KeyID, nf.Encryption.WrappedKey, nf.ParentID, nf.ExpandArchive, nf.ContentType, nf.RedactionPending), &f)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := recordFileUploaded(q, &f); err != nil {
+			return nil, err
+		}
+		return &f, nil
+	}
+	return nil, ErrDuplicateFileName
+}
+
+// retainUntil returns the retain_until value of a new file, NULL without
+// retention
+func retainUntil(nf NewFile) interface{} {
+	if nf.RetentionMode == "" {
+		return nil
+	}
+	return nf.RetainUntil.UTC()
+}
+
+// namesInUse returns the names in a user's collection that start like name,
+// mapped to their latest revision. That covers name itself and all of its
+// name-N variants.
+func namesInUse(q querier, userID, collectionID, name string) (map[string]int, error) {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	rows, err := q.Query(`
+		SELECT name, MAX(name_revision)
+		FROM files
+		WHERE user_id = $1 AND COALESCE(collection_id, '') = $2 AND starts_with(name, $3)
+		GROUP BY name
+	`, userID, collectionID, base)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	taken := make(map[string]int)
+	for rows.Next() {
+		var n string
+		var revision int
+		if err := rows.Scan(&n, &revision); err != nil {
+			return nil, err
+		}
+		taken[n] = revision
+	}
+	return taken, rows.Err()
+}
+
+// nextFreeName returns the first of name-2, name-3, ... that isn't taken,
+// keeping the extension at the end
+func nextFreeName(name string, taken map[string]int) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+		if _, ok := taken[candidate]; !ok {
+			return candidate
+		}
+	}
+}
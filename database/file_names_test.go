@@ -0,0 +1,29 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextFreeName(t *testing.T) {
+	taken := map[string]int{"report.txt": 1}
+	assert.Equal(t, "report-2.txt", nextFreeName("report.txt", taken))
+
+	taken["report-2.txt"] = 1
+	taken["report-3.txt"] = 1
+	assert.Equal(t, "report-4.txt", nextFreeName("report.txt", taken))
+
+	assert.Equal(t, "README-2", nextFreeName("README", map[string]int{"README": 1}))
+	assert.Equal(t, "archive.tar-2.gz", nextFreeName("archive.tar.gz", nil))
+}
+
+func TestSetNamePolicy(t *testing.T) {
+	previous := namePolicy
+	t.Cleanup(func() { namePolicy = previous })
+
+	assert.True(t, SetNamePolicy(NamePolicyReject))
+	assert.Equal(t, NamePolicyReject, NamePolicy())
+	assert.False(t, SetNamePolicy("overwrite"))
+	assert.Equal(t, NamePolicyReject, NamePolicy())
+}
@@ -2,20 +2,107 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
+	"github.com/yourusername/golang-aws-api/envelope"
+	"github.com/yourusername/golang-aws-api/pagination"
 )
 
 type File struct {
 	ID        string
 	Name      string
 	S3Key     string
+	UserID    string
+	SizeBytes int64
 	CreatedAt time.Time
+	UpdatedAt time.Time
+	Version   int
+	// CollectionID is empty for files outside any collection
+	CollectionID string
+	// NameRevision numbers files sharing an owner, collection and name
+	NameRevision int
+	// StorageClass is the S3 storage class the object was written with
+	StorageClass string
+	// Object Lock retention of the object; RetainUntil is nil without one
+	RetentionMode string
+	RetainUntil   *time.Time
+	// Encryption describes the envelope the content was encrypted with
+	// before upload; it is the zero value for plain objects
+	Encryption envelope.Envelope
+	// LegalHold is set by an administrator to keep the file from being
+	// deleted or expired, whatever its retention
+	LegalHold bool
+	// ParentID is the archive a file was expanded from, empty for uploads
+	ParentID string
+	// ExpandArchive asks for an archive's members to be stored as files of
+	// their own once it is processed
+	ExpandArchive bool
+	// ContentType is the MIME type sniffed from the content when it was
+	// stored, empty for files stored before it was recorded
+	ContentType string
+	// RedactedKey is the object holding a copy of the content with its
+	// personal data redacted, of RedactedSize bytes, or empty if the pii
+	// processor found none
+	RedactedKey  string
+	RedactedSize int64
+	// RedactionPending is set on files whose pipeline has the pii stage
+	// until the stage has run, so that their content isn't served to callers
+	// who may not see personal data before it could be redacted
+	RedactionPending bool
+}
+
+// ErrRetentionActive is returned when deleting a file whose retention period
+// hasn't ended
+var ErrRetentionActive = errors.New("file is under retention")
+
+// ErrLegalHold is returned when deleting a file under legal hold
+var ErrLegalHold = errors.New("file is under legal hold")
+
+// Redacted reports whether the file has a redacted copy to serve instead of
+// its content to callers who may not see personal data
+func (f *File) Redacted() bool {
+	return f.RedactedKey != ""
+}
+
+// Retained reports whether the file's retention period is still running
+func (f *File) Retained(now time.Time) bool {
+	return f.RetainUntil != nil && f.RetainUntil.After(now)
+}
+
+// fileColumns is the column list read by scanFile
+const fileColumns = `id, name, s3_key, COALESCE(user_id, ''), COALESCE(size_bytes, 0), created_at, updated_at, version, COALESCE(collection_id, ''), name_revision, storage_class, retention_mode, retain_until, encryption_algorithm, encryption_key_id, encrypted_data_key, legal_hold, COALESCE(parent_id, ''), expand_archive, content_type, redacted_key, redacted_size, redaction_pending`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanFile scans a row selected with fileColumns into f, followed by any
+// extra columns selected after them
+func scanFile(row rowScanner, f *File, extra ...interface{}) error {
+	dest := []interface{}{&f.ID, &f.Name, &f.S3Key, &f.UserID, &f.SizeBytes, &f.CreatedAt, &f.UpdatedAt, &f.Version, &f.CollectionID, &f.NameRevision, &f.StorageClass, &f.RetentionMode, &f.RetainUntil,
+		&f.Encryption.Algorithm, &f.Encryption.KeyID, &f.Encryption.WrappedKey, &f.LegalHold, &f.ParentID, &f.ExpandArchive, &f.ContentType, &f.RedactedKey, &f.RedactedSize, &f.RedactionPending}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// FileWithResult is a file joined with its latest processing result, if any
+type FileWithResult struct {
+	File
+	Status      string
+	Result      string
+	ProcessedAt sql.NullTime
+	// ResultKey is set when Result is the summary of an offloaded result
+	ResultKey string
 }
 
 // GetAllFiles retrieves all files from the database
 func GetAllFiles() ([]File, error) {
 	rows, err := GetDB().Query(`
-		SELECT id, name, s3_key, created_at 
+		SELECT ` + fileColumns + ` 
 		FROM files 
 		ORDER BY created_at DESC
 	`)
@@ -27,7 +114,7 @@ func GetAllFiles() ([]File, error) {
 	var files []File
 	for rows.Next() {
 		var f File
-		if err := rows.Scan(&f.ID, &f.Name, &f.S3Key, &f.CreatedAt); err != nil {
+		if err := scanFile(rows, &f); err != nil {
 			return nil, err
 		}
 		files = append(files, f)
@@ -38,25 +125,105 @@ func GetAllFiles() ([]File, error) {
 // SaveFile saves a new file to the database
 func SaveFile(name, s3Key string) (*File, error) {
 	var f File
-	err := GetDB().QueryRow(`
-		INSERT INTO files (name, s3_key)
-		VALUES ($1, $2)
-		RETURNING id, name, s3_key, created_at
-	`, name, s3Key).Scan(&f.ID, &f.Name, &f.S3Key, &f.CreatedAt)
+	err := WithTx(func(tx *sql.Tx) error {
+		err := scanFile(tx.QueryRow(`
+			INSERT INTO files (id, name, s3_key)
+			VALUES ($1, $2, $3)
+			RETURNING `+fileColumns+`
+		`, NewID(), name, s3Key), &f)
+		if err != nil {
+			return err
+		}
+		return recordFileUploaded(tx, &f)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &f, nil
 }
 
+// SaveFileWithID saves a file with a caller-chosen ID, such as one imported
+// from an existing S3 object
+func SaveFileWithID(id, name, s3Key, userID string, sizeBytes int64) (*File, error) {
+	var f *File
+	err := WithTx(func(tx *sql.Tx) error {
+		var err error
+		f, err = saveFileWithID(tx, id, name, s3Key, userID, sizeBytes)
+		return err
+	})
+	return f, err
+}
+
+// SaveFileWithIDTx is SaveFileWithID run inside a transaction
+func SaveFileWithIDTx(tx *sql.Tx, id, name, s3Key, userID string, sizeBytes int64) (*File, error) {
+	return saveFileWithID(tx, id, name, s3Key, userID, sizeBytes)
+}
+
+func saveFileWithID(q querier, id, name, s3Key, userID string, sizeBytes int64) (*File, error) {
+	var f File
+	err := scanFile(q.QueryRow(`
+		INSERT INTO files (id, name, s3_key, user_id, size_bytes)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		RETURNING `+fileColumns+`
+	`, id, name, s3Key, userID, sizeBytes), &f)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	if err := recordFileUploaded(q, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
 // GetFileByID retrieves a file by its ID
 func GetFileByID(id string) (*File, error) {
 	var f File
-	err := GetDB().QueryRow(`
-		SELECT id, name, s3_key, created_at 
+	err := scanFile(GetDB().QueryRow(`
+		SELECT `+fileColumns+` 
 		FROM files 
 		WHERE id = $1
-	`, id).Scan(&f.ID, &f.Name, &f.S3Key, &f.CreatedAt)
+	`, id), &f)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// GetFilesByIDs retrieves the files with the given IDs, keyed by ID. IDs that
+// don't exist are left out.
+func GetFilesByIDs(ids []string) (map[string]File, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make(map[string]File, len(ids))
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return nil, err
+		}
+		files[f.ID] = f
+	}
+	return files, rows.Err()
+}
+
+// GetFileByS3Key retrieves a file by the key of its S3 object
+func GetFileByS3Key(s3Key string) (*File, error) {
+	var f File
+	err := scanFile(GetDB().QueryRow(`
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE s3_key = $1
+	`, s3Key), &f)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -65,3 +232,319 @@ func GetFileByID(id string) (*File, error) {
 	}
 	return &f, nil
 }
+
+// ListChildFiles returns the files expanded from an archive, by name
+func ListChildFiles(parentID string) ([]File, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE parent_id = $1
+		ORDER BY name, id
+	`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// ListFilesByUser retrieves a page of a user's files, newest first, starting
+// after the given cursor. Up to limit+1 rows are returned so the caller can
+// tell whether another page follows.
+func ListFilesByUser(userID string, after *pagination.Cursor, limit int) ([]File, error) {
+	createdAt, id := keysetBounds(after)
+	rows, err := GetDB().Query(`
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE user_id = $1 AND (created_at, id) < ($2::timestamp, $3::text)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4
+	`, userID, createdAt, id, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// UserUsage returns how many files a user owns and their total size in bytes
+func UserUsage(userID string) (int, int64, error) {
+	return userUsage(GetDB(), userID)
+}
+
+// UserUsageTx is UserUsage run inside a transaction. It first locks the
+// user's usage until the transaction ends, so uploads saved concurrently are
+// counted one after the other instead of all against the same total.
+func UserUsageTx(tx *sql.Tx, userID string) (int, int64, error) {
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('usage:' || $1))`, userID); err != nil {
+		return 0, 0, err
+	}
+	return userUsage(tx, userID)
+}
+
+func userUsage(q querier, userID string) (int, int64, error) {
+	var files int
+	var bytes int64
+	err := q.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(size_bytes), 0)
+		FROM files
+		WHERE user_id = $1
+	`, userID).Scan(&files, &bytes)
+	return files, bytes, err
+}
+
+// SetFileSize records the size of a file's content, for rows saved without
+// one or with one that no longer matches the object
+func SetFileSize(id string, sizeBytes int64) error {
+	_, err := GetDB().Exec(`UPDATE files SET size_bytes = $1 WHERE id = $2`, sizeBytes, id)
+	return err
+}
+
+// SetContentSHA256 records the hex SHA-256 of a file's plain content
+func SetContentSHA256(id, sum string) error {
+	_, err := GetDB().Exec(`UPDATE files SET content_sha256 = $1 WHERE id = $2`, sum, id)
+	return err
+}
+
+// SetRedactedCopy records the redacted copy of a file, or that it has none
+// when key is empty, which ends any pending redaction, and returns the key of
+// the copy it replaces, if any
+func SetRedactedCopy(id, key string, size int64) (string, error) {
+	var old string
+	err := GetDB().QueryRow(`
+		UPDATE files f SET redacted_key = $1, redacted_size = $2, redaction_pending = false
+		FROM files prev
+		WHERE f.id = $3 AND prev.id = f.id
+		RETURNING prev.redacted_key
+	`, key, size, id).Scan(&old)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return old, err
+}
+
+// SetLegalHold places a file under legal hold or releases it and returns the
+// updated file, or nil if it doesn't exist
+func SetLegalHold(id string, hold bool, by string) (*File, error) {
+	var f *File
+	err := WithTx(func(tx *sql.Tx) error {
+		var updated File
+		err := scanFile(tx.QueryRow(`
+			UPDATE files SET legal_hold = $1
+			WHERE id = $2
+			RETURNING `+fileColumns+`
+		`, hold, id), &updated)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		f = &updated
+		return RecordEventTx(tx, EventFileLegalHold, id, "", map[string]interface{}{"legal_hold": hold, "by": by})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// UpdateFileName renames a file if it is still at the given version and returns
+// the updated row. It returns ErrConflict if the file was changed in the
+// meantime, ErrDuplicateFileName if the name is taken and nil if it doesn't
+// exist.
+func UpdateFileName(id, name string, version int) (*File, error) {
+	var f File
+	err := scanFile(GetDB().QueryRow(`
+		UPDATE files
+		SET name = $1, version = version + 1, updated_at = NOW()
+		WHERE id = $2 AND version = $3
+		RETURNING `+fileColumns+`
+	`, name, id, version), &f)
+	if err == sql.ErrNoRows {
+		return nil, versionConflict("files", id)
+	}
+	if err != nil {
+		return nil, fileError(err)
+	}
+	return &f, nil
+}
+
+// GetFilesWithResultsByUser retrieves all files owned by a user together with
+// their latest processing result
+func GetFilesWithResultsByUser(userID string) ([]FileWithResult, error) {
+	rows, err := GetDB().Query(`
+		SELECT f.id, f.name, f.s3_key, COALESCE(f.user_id, ''), COALESCE(f.size_bytes, 0), f.created_at, f.updated_at, f.version, COALESCE(f.collection_id, ''),
+			COALESCE(pr.status, ''), COALESCE(pr.result, ''), pr.created_at, COALESCE(pr.result_key, '')
+		FROM files f
+		LEFT JOIN LATERAL (
+			SELECT status, result, created_at, result_key
+			FROM processing_results
+			WHERE file_id = f.id AND source = 'builtin'
+			ORDER BY created_at DESC
+			LIMIT 1
+		) pr ON TRUE
+		WHERE f.user_id = $1
+		ORDER BY f.created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileWithResult
+	for rows.Next() {
+		var f FileWithResult
+		if err := scanFile(rows, &f.File, &f.Status, &f.Result, &f.ProcessedAt, &f.ResultKey); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// DeleteFile deletes a file and every row that references it in a single
+// transaction, so a failure never leaves results behind without their file
+func DeleteFile(id string) error {
+	return WithTx(func(tx *sql.Tx) error {
+		return DeleteFileTx(tx, id)
+	})
+}
+
+// DeleteFileTx is DeleteFile run inside a caller's transaction
+func DeleteFileTx(tx *sql.Tx, id string) error {
+	return deleteFile(tx, id, "")
+}
+
+// DeleteFileBypassingRetention is DeleteFile for administrators: files under
+// retention in mode are deleted too. Retention in other modes still applies.
+func DeleteFileBypassingRetention(id, mode string) error {
+	return WithTx(func(tx *sql.Tx) error {
+		return deleteFile(tx, id, mode)
+	})
+}
+
+// deleteFile deletes a file unless its retention forbids it, in which case
+// an error wrapping ErrRetentionActive says until when, or it is under legal
+// hold, which nothing bypasses. Retention in bypassMode doesn't count.
+func deleteFile(tx *sql.Tx, id string, bypassMode string) error {
+	var mode string
+	var until *time.Time
+	var hold bool
+	err := tx.QueryRow(`
+		SELECT retention_mode, retain_until, legal_hold FROM files WHERE id = $1 FOR UPDATE
+	`, id).Scan(&mode, &until, &hold)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if hold {
+		return ErrLegalHold
+	}
+	f := File{RetentionMode: mode, RetainUntil: until}
+	if f.Retained(time.Now()) && !(bypassMode != "" && mode == bypassMode) {
+		return fmt.Errorf("%w: %s retention until %s", ErrRetentionActive, strings.ToLower(mode), until.Format(time.RFC3339))
+	}
+
+	if err := queueFileResultObjectDeletions(tx, id); err != nil {
+		return err
+	}
+	for _, query := range []string{
+		`DELETE FROM processing_results WHERE file_id = $1`,
+		`DELETE FROM scheduled_jobs WHERE file_id = $1`,
+		`DELETE FROM processing_attempts WHERE file_id = $1`,
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+
+	var name, s3Key, userID string
+	err = tx.QueryRow(`
+		DELETE FROM files WHERE id = $1
+		RETURNING name, s3_key, COALESCE(user_id, '')
+	`, id).Scan(&name, &s3Key, &userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return RecordEventTx(tx, EventFileDeleted, id, userID, map[string]string{"name": name, "s3_key": s3Key})
+}
+
+// ListExpiredFilesByUser returns up to limit of a user's files created before
+// cutoff, oldest first. Files still under retention or on legal hold are left
+// out.
+func ListExpiredFilesByUser(userID string, cutoff time.Time, limit int) ([]File, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE user_id = $1 AND created_at < $2 AND NOT legal_hold
+			AND (retain_until IS NULL OR retain_until <= NOW())
+		ORDER BY created_at, id
+		LIMIT $3
+	`, userID, cutoff, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// ListExpiredFiles is ListExpiredFilesByUser for every file not owned by one
+// of the excluded users, including files without an owner
+func ListExpiredFiles(exclude []string, cutoff time.Time, limit int) ([]File, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE (user_id IS NULL OR NOT (user_id = ANY($1)))
+			AND created_at < $2 AND NOT legal_hold
+			AND (retain_until IS NULL OR retain_until <= NOW())
+		ORDER BY created_at, id
+		LIMIT $3
+	`, pq.Array(exclude), cutoff, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
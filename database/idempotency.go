@@ -0,0 +1,98 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// idempotencyLockTimeout is how long an unfinished request holds its key. A
+// request that hasn't finished by then is assumed to have died, and a retry
+// may take the key over.
+const idempotencyLockTimeout = 5 * time.Minute
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// Idempotency-Key header
+type IdempotencyRecord struct {
+	Scope       string
+	Key         string
+	RequestHash string
+	// StatusCode is 0 while the original request is still in progress
+	StatusCode   int
+	ContentType  string
+	ResponseBody []byte
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+// ClaimIdempotencyKey reserves key for a new request. It returns nil if the
+// caller now holds the key and should process the request, or the record left
+// by an earlier request with the same key otherwise. Expired keys and keys
+// abandoned by requests that never finished can be claimed again.
+func ClaimIdempotencyKey(scope, key, requestHash string, ttl time.Duration) (*IdempotencyRecord, error) {
+	var claimed bool
+	err := GetDB().QueryRow(`
+		INSERT INTO idempotency_keys (scope, key, request_hash, expires_at)
+		VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second')
+		ON CONFLICT (scope, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash,
+			status_code = NULL,
+			content_type = '',
+			response_body = NULL,
+			created_at = NOW(),
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= NOW()
+			OR (idempotency_keys.status_code IS NULL
+				AND idempotency_keys.created_at <= NOW() - $5 * INTERVAL '1 second')
+		RETURNING true
+	`, scope, key, requestHash, int64(ttl/time.Second), int64(idempotencyLockTimeout/time.Second)).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var rec IdempotencyRecord
+	var status sql.NullInt64
+	err = GetDB().QueryRow(`
+		SELECT scope, key, request_hash, status_code, content_type, response_body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE scope = $1 AND key = $2
+	`, scope, key).Scan(&rec.Scope, &rec.Key, &rec.RequestHash, &status, &rec.ContentType, &rec.ResponseBody, &rec.CreatedAt, &rec.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	rec.StatusCode = int(status.Int64)
+	return &rec, nil
+}
+
+// CompleteIdempotencyKey stores the response to the request holding key, so
+// retries get the same response
+func CompleteIdempotencyKey(scope, key string, statusCode int, contentType string, body []byte) error {
+	_, err := GetDB().Exec(`
+		UPDATE idempotency_keys
+		SET status_code = $3, content_type = $4, response_body = $5
+		WHERE scope = $1 AND key = $2
+	`, scope, key, statusCode, contentType, body)
+	return err
+}
+
+// ReleaseIdempotencyKey frees key without storing a response, so a retry is
+// processed as a new request
+func ReleaseIdempotencyKey(scope, key string) error {
+	_, err := GetDB().Exec(`
+		DELETE FROM idempotency_keys
+		WHERE scope = $1 AND key = $2
+	`, scope, key)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys removes expired keys and returns how many were
+// deleted
+func DeleteExpiredIdempotencyKeys() (int64, error) {
+	res, err := GetDB().Exec(`DELETE FROM idempotency_keys WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
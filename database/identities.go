@@ -0,0 +1,160 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// OAuthState is a pending external login: the PKCE verifier and ID token
+// nonce that belong to the state parameter sent to the provider
+type OAuthState struct {
+	State        string
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	ExpiresAt    time.Time
+}
+
+// SaveOAuthState stores a pending login, clearing out logins that were never
+// completed
+func SaveOAuthState(s OAuthState) error {
+	if _, err := GetDB().Exec(`DELETE FROM oauth_states WHERE expires_at <= NOW()`); err != nil {
+		return err
+	}
+	_, err := GetDB().Exec(`
+		INSERT INTO oauth_states (state, provider, code_verifier, nonce, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, s.State, s.Provider, s.CodeVerifier, s.Nonce, s.ExpiresAt)
+	return err
+}
+
+// ConsumeOAuthState deletes and returns the pending login for state, or nil
+// if there is none or it has expired. Each state can be used once.
+func ConsumeOAuthState(state string) (*OAuthState, error) {
+	var s OAuthState
+	err := GetDB().QueryRow(`
+		DELETE FROM oauth_states WHERE state = $1
+		RETURNING state, provider, code_verifier, nonce, expires_at
+	`, state).Scan(&s.State, &s.Provider, &s.CodeVerifier, &s.Nonce, &s.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !s.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// Identity is an external provider's subject linked to a user
+type Identity struct {
+	Provider  string
+	Subject   string
+	UserID    string
+	Email     string
+	CreatedAt time.Time
+}
+
+// ListIdentitiesByUser returns the identities linked to a user, oldest first
+func ListIdentitiesByUser(userID string) ([]Identity, error) {
+	rows, err := GetDB().Query(`
+		SELECT provider, subject, user_id, email, created_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []Identity
+	for rows.Next() {
+		var i Identity
+		if err := rows.Scan(&i.Provider, &i.Subject, &i.UserID, openColumn(columnIdentityEmail, &i.Email), &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+// GetUserByIdentity returns the user linked to a provider's subject, or nil
+func GetUserByIdentity(provider, subject string) (*User, error) {
+	var user User
+	err := GetDB().QueryRow(`
+		SELECT u.id, u.username, u.password, u.email, u.confirmed, u.created_at
+		FROM user_identities i
+		JOIN users u ON u.id = i.user_id
+		WHERE i.provider = $1 AND i.subject = $2
+	`, provider, subject).Scan(&user.ID, &user.Username, &user.Password, openColumn(columnUserEmail, &user.Email), &user.Confirmed, &user.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// LinkIdentity links a provider's subject to an existing user and clears
+// the user's password, so a password set before the provider proved who owns
+// the email can no longer sign in
+func LinkIdentity(userID, provider, subject, email string) error {
+	return WithTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE users SET password = '' WHERE id = $1`, userID); err != nil {
+			return err
+		}
+		return linkIdentity(tx, userID, provider, subject, email)
+	})
+}
+
+func linkIdentity(q querier, userID, provider, subject, email string) error {
+	_, err := q.Exec(`
+		INSERT INTO user_identities (provider, subject, user_id, email)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, subject) DO NOTHING
+	`, provider, subject, userID, sealColumn(columnIdentityEmail, email))
+	return err
+}
+
+// maxUsernameAttempts bounds the suffixes tried for a new external user
+const maxUsernameAttempts = 20
+
+// CreateLinkedUser creates a confirmed user without a password for a
+// provider's subject and links the two. The username is the first of
+// username, username-2, username-3 and so on that isn't taken.
+func CreateLinkedUser(username, email, provider, subject string) (*User, error) {
+	var user *User
+	err := WithTx(func(tx *sql.Tx) error {
+		for attempt := 1; attempt <= maxUsernameAttempts; attempt++ {
+			name := username
+			if attempt > 1 {
+				name = fmt.Sprintf("%s-%d", username, attempt)
+			}
+			var u User
+			err := tx.QueryRow(`
+				INSERT INTO users (id, username, password, email, email_key, confirmed)
+				VALUES ($1, $2, '', $3, $4, true)
+				ON CONFLICT DO NOTHING
+				RETURNING id, username, password, email, confirmed, created_at
+			`, NewID(), name, sealColumn(columnUserEmail, NormalizeEmail(email)), emailKeyValue(email)).Scan(&u.ID, &u.Username, &u.Password, openColumn(columnUserEmail, &u.Email), &u.Confirmed, &u.CreatedAt)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			user = &u
+			return linkIdentity(tx, u.ID, provider, subject, email)
+		}
+		return fmt.Errorf("no free username like %q", username)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"log"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+)
+
+// ID generation strategies
+const (
+	IDStrategyUUIDv4 = "uuidv4"
+	IDStrategyUUIDv7 = "uuidv7"
+	IDStrategyULID   = "ulid"
+)
+
+// idStrategy is the strategy used by NewID. UUIDv7 keeps IDs sortable by
+// creation time while staying compatible with existing UUID columns.
+var idStrategy = IDStrategyUUIDv7
+
+// SetIDStrategy selects the ID generation strategy. Unknown strategies are
+// rejected and the current one is kept.
+func SetIDStrategy(strategy string) bool {
+	switch strategy {
+	case IDStrategyUUIDv4, IDStrategyUUIDv7, IDStrategyULID:
+		idStrategy = strategy
+		return true
+	}
+	return false
+}
+
+// loadIDStrategy reads the ID generation strategy from ID_STRATEGY
+func loadIDStrategy() {
+	if v := os.Getenv("ID_STRATEGY"); v != "" && !SetIDStrategy(v) {
+		log.Printf("Invalid ID_STRATEGY %q, using %s", v, idStrategy)
+	}
+}
+
+// NewID returns a new row ID using the configured strategy
+func NewID() string {
+	switch idStrategy {
+	case IDStrategyUUIDv4:
+		return uuid.New().String()
+	case IDStrategyULID:
+		return ulid.Make().String()
+	default:
+		id, err := uuid.NewV7()
+		if err != nil {
+			// NewV7 only fails if the random source does
+			return uuid.New().String()
+		}
+		return id.String()
+	}
+}
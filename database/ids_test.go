@@ -0,0 +1,68 @@
+package database
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func withIDStrategy(t *testing.T, strategy string) {
+	t.Helper()
+	previous := idStrategy
+	assert.True(t, SetIDStrategy(strategy))
+	t.Cleanup(func() { idStrategy = previous })
+}
+
+func TestNewIDFormats(t *testing.T) {
+	withIDStrategy(t, IDStrategyUUIDv4)
+	id, err := uuid.Parse(NewID())
+	assert.NoError(t, err)
+	assert.Equal(t, uuid.Version(4), id.Version())
+
+	withIDStrategy(t, IDStrategyUUIDv7)
+	id, err = uuid.Parse(NewID())
+	assert.NoError(t, err)
+	assert.Equal(t, uuid.Version(7), id.Version())
+
+	withIDStrategy(t, IDStrategyULID)
+	_, err = ulid.ParseStrict(NewID())
+	assert.NoError(t, err)
+}
+
+func TestNewIDSortableByTime(t *testing.T) {
+	for _, strategy := range []string{IDStrategyUUIDv7, IDStrategyULID} {
+		withIDStrategy(t, strategy)
+
+		var ids []string
+		for i := 0; i < 5; i++ {
+			ids = append(ids, NewID())
+			// Both formats have millisecond precision
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		assert.True(t, sort.StringsAreSorted(ids), "%s IDs should sort by creation time", strategy)
+	}
+}
+
+func TestNewIDUnique(t *testing.T) {
+	for _, strategy := range []string{IDStrategyUUIDv4, IDStrategyUUIDv7, IDStrategyULID} {
+		withIDStrategy(t, strategy)
+
+		seen := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			id := NewID()
+			assert.False(t, seen[id], "%s generated duplicate ID %s", strategy, id)
+			seen[id] = true
+		}
+	}
+}
+
+func TestSetIDStrategyRejectsUnknown(t *testing.T) {
+	withIDStrategy(t, IDStrategyULID)
+	assert.False(t, SetIDStrategy("snowflake"))
+	assert.Equal(t, IDStrategyULID, idStrategy)
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Integration is a third party allowed to call back into the API. Its calls
+// are signed with Secret, or with PreviousSecret until PreviousExpiresAt
+// after a rotation.
+type Integration struct {
+	ID                string
+	Name              string
+	UserID            string
+	Secret            string
+	PreviousSecret    string
+	PreviousExpiresAt *time.Time
+	CreatedBy         string
+	CreatedAt         time.Time
+	RotatedAt         *time.Time
+}
+
+const integrationColumns = `id, name, user_id, secret, previous_secret, previous_expires_at, created_by, created_at, rotated_at`
+
+func scanIntegration(row rowScanner, in *Integration) error {
+	var previousExpiresAt, rotatedAt sql.NullTime
+	err := row.Scan(&in.ID, &in.Name, &in.UserID, openColumn(columnIntegrationSecret, &in.Secret), openColumn(columnIntegrationSecret, &in.PreviousSecret), &previousExpiresAt, &in.CreatedBy, &in.CreatedAt, &rotatedAt)
+	if err != nil {
+		return err
+	}
+	if previousExpiresAt.Valid {
+		in.PreviousExpiresAt = &previousExpiresAt.Time
+	}
+	if rotatedAt.Valid {
+		in.RotatedAt = &rotatedAt.Time
+	}
+	return nil
+}
+
+// Secrets returns the secrets a call may be signed with at now, current first
+func (in *Integration) Secrets(now time.Time) []string {
+	if in.PreviousSecret == "" || in.PreviousExpiresAt == nil || !now.Before(*in.PreviousExpiresAt) {
+		return []string{in.Secret}
+	}
+	return []string{in.Secret, in.PreviousSecret}
+}
+
+// SaveIntegration registers an integration whose files are owned by userID
+func SaveIntegration(name, userID, secret, createdBy string) (*Integration, error) {
+	var in Integration
+	err := scanIntegration(GetDB().QueryRow(`
+		INSERT INTO integrations (id, name, user_id, secret, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+integrationColumns+`
+	`, NewID(), name, userID, sealColumn(columnIntegrationSecret, secret), createdBy), &in)
+	if err != nil {
+		return nil, err
+	}
+	return &in, nil
+}
+
+// GetIntegration retrieves an integration by ID
+func GetIntegration(id string) (*Integration, error) {
+	var in Integration
+	err := scanIntegration(GetDB().QueryRow(`
+		SELECT `+integrationColumns+`
+		FROM integrations
+		WHERE id = $1
+	`, id), &in)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &in, nil
+}
+
+// ListIntegrations returns every integration, oldest first
+func ListIntegrations() ([]Integration, error) {
+	rows, err := GetDB().Query(`
+		SELECT ` + integrationColumns + `
+		FROM integrations
+		ORDER BY created_at, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Integration
+	for rows.Next() {
+		var in Integration
+		if err := scanIntegration(rows, &in); err != nil {
+			return nil, err
+		}
+		list = append(list, in)
+	}
+	return list, rows.Err()
+}
+
+// RotateIntegrationSecret replaces an integration's secret, keeping the old
+// one valid for grace, and returns the integration, or nil if it doesn't exist
+func RotateIntegrationSecret(id, secret string, grace time.Duration) (*Integration, error) {
+	var in Integration
+	err := scanIntegration(GetDB().QueryRow(`
+		UPDATE integrations
+		SET previous_secret = secret,
+			previous_expires_at = NOW() + $3 * INTERVAL '1 second',
+			secret = $2,
+			rotated_at = NOW()
+		WHERE id = $1
+		RETURNING `+integrationColumns+`
+	`, id, sealColumn(columnIntegrationSecret, secret), int64(grace/time.Second)), &in)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &in, nil
+}
+
+// DeleteIntegration removes an integration, reporting whether it existed
+func DeleteIntegration(id string) (bool, error) {
+	res, err := GetDB().Exec(`DELETE FROM integrations WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RecordCallbackSignature remembers a signature accepted for an integration
+// and reports whether it is new. A signature seen before is a replay.
+func RecordCallbackSignature(integrationID, signature string) (bool, error) {
+	res, err := GetDB().Exec(`
+		INSERT INTO callback_signatures (integration_id, signature)
+		VALUES ($1, $2)
+		ON CONFLICT (integration_id, signature) DO NOTHING
+	`, integrationID, signature)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteCallbackSignatures forgets signatures received before cutoff and
+// returns how many were deleted
+func DeleteCallbackSignatures(cutoff time.Time) (int64, error) {
+	res, err := GetDB().Exec(`DELETE FROM callback_signatures WHERE received_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
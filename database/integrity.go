@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// IntegrityFile is a file to check against its S3 object
+type IntegrityFile struct {
+	File
+	// HasResult is set when the file has any processing result
+	HasResult bool
+}
+
+// ListIntegrityFiles returns up to limit files with IDs after afterID, in ID
+// order. Pass an empty afterID for the first page.
+func ListIntegrityFiles(afterID string, limit int) ([]IntegrityFile, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+fileColumns+`, EXISTS(SELECT 1 FROM processing_results p WHERE p.file_id = files.id)
+		FROM files
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+	`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []IntegrityFile
+	for rows.Next() {
+		var f IntegrityFile
+		if err := scanFile(rows, &f.File, &f.HasResult); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// ListStuckResults returns up to limit results still expanding that were
+// last updated before olderThan, oldest first. An archive's result stays
+// expanding until every member has one, so these are archives whose
+// expansion never finished.
+func ListStuckResults(olderThan time.Time, limit int) ([]ProcessingResult, error) {
+	rows, err := GetDB().Query(`
+		SELECT id, file_id, status, result, source, created_at, updated_at, version, COALESCE(reused_from, ''), result_key, result_size
+		FROM processing_results
+		WHERE status = $1 AND updated_at < $2
+		ORDER BY updated_at, id
+		LIMIT $3
+	`, StatusExpanding, olderThan, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []ProcessingResult
+	for rows.Next() {
+		var pr ProcessingResult
+		err := rows.Scan(&pr.ID, &pr.FileID, &pr.Status, &pr.Result, &pr.Source, &pr.CreatedAt, &pr.UpdatedAt, &pr.Version,
+			&pr.ReusedFrom, &pr.ResultKey, &pr.ResultSize)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, pr)
+	}
+	return results, rows.Err()
+}
+
+// FailStuckResult marks a result listed by ListStuckResults as failed with
+// message, unless it has been updated since olderThan. It reports whether
+// the result was failed.
+func FailStuckResult(id string, olderThan time.Time, message string) (bool, error) {
+	failed := false
+	err := WithTx(func(tx *sql.Tx) error {
+		var fileID string
+		err := tx.QueryRow(`
+			UPDATE processing_results
+			SET status = 'failed', result = $1, updated_at = NOW(), version = version + 1
+			WHERE id = $2 AND status = $3 AND updated_at < $4
+			RETURNING file_id
+		`, message, id, StatusExpanding, olderThan).Scan(&fileID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		failed = true
+		return recordEvent(tx, EventFileProcessed, fileID, "", map[string]string{"status": "failed"})
+	})
+	return failed, err
+}
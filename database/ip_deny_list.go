@@ -0,0 +1,69 @@
+package database
+
+import "time"
+
+// IPDenyEntry is a range of client addresses the API refuses every request
+// from
+type IPDenyEntry struct {
+	ID        string
+	CIDR      string
+	Reason    string
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+const ipDenyColumns = `id, cidr, reason, created_by, created_at`
+
+func scanIPDenyEntry(row rowScanner, e *IPDenyEntry) error {
+	return row.Scan(&e.ID, &e.CIDR, &e.Reason, &e.CreatedBy, &e.CreatedAt)
+}
+
+// AddIPDenyEntry denies a range, or updates the reason of a range that is
+// already denied, and returns the entry
+func AddIPDenyEntry(cidr, reason, createdBy string) (*IPDenyEntry, error) {
+	var e IPDenyEntry
+	err := scanIPDenyEntry(GetDB().QueryRow(`
+		INSERT INTO ip_deny_list (id, cidr, reason, created_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (cidr) DO UPDATE SET reason = EXCLUDED.reason
+		RETURNING `+ipDenyColumns+`
+	`, NewID(), cidr, reason, createdBy), &e)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ListIPDenyEntries returns every denied range, oldest first
+func ListIPDenyEntries() ([]IPDenyEntry, error) {
+	rows, err := GetDB().Query(`
+		SELECT ` + ipDenyColumns + `
+		FROM ip_deny_list
+		ORDER BY created_at, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []IPDenyEntry
+	for rows.Next() {
+		var e IPDenyEntry
+		if err := scanIPDenyEntry(rows, &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// DeleteIPDenyEntry allows a denied range again, reporting whether it was
+// denied
+func DeleteIPDenyEntry(id string) (bool, error) {
+	res, err := GetDB().Exec(`DELETE FROM ip_deny_list WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
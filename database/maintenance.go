@@ -0,0 +1,44 @@
+package database
+
+import "time"
+
+// Maintenance is the maintenance mode state. While it is enabled the API
+// rejects writes and the worker stops consuming the queue.
+type Maintenance struct {
+	Enabled    bool
+	Message    string
+	RetryAfter time.Duration
+	UpdatedBy  string
+	UpdatedAt  time.Time
+}
+
+// GetMaintenance returns the current maintenance mode state
+func GetMaintenance() (*Maintenance, error) {
+	var m Maintenance
+	var retryAfter int
+	err := GetDB().QueryRow(`
+		SELECT enabled, message, retry_after_seconds, updated_by, updated_at
+		FROM maintenance
+	`).Scan(&m.Enabled, &m.Message, &retryAfter, &m.UpdatedBy, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	m.RetryAfter = time.Duration(retryAfter) * time.Second
+	return &m, nil
+}
+
+// SetMaintenance turns maintenance mode on or off and returns the new state
+func SetMaintenance(enabled bool, message string, retryAfter time.Duration, updatedBy string) (*Maintenance, error) {
+	var m Maintenance
+	var seconds int
+	err := GetDB().QueryRow(`
+		UPDATE maintenance
+		SET enabled = $1, message = $2, retry_after_seconds = $3, updated_by = $4, updated_at = NOW()
+		RETURNING enabled, message, retry_after_seconds, updated_by, updated_at
+	`, enabled, message, int(retryAfter/time.Second), updatedBy).Scan(&m.Enabled, &m.Message, &seconds, &m.UpdatedBy, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	m.RetryAfter = time.Duration(seconds) * time.Second
+	return &m, nil
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Metered usage, recorded per file for billing
+const (
+	// MeterBytesUploaded is the size of each uploaded file
+	MeterBytesUploaded = "bytes_uploaded"
+	// MeterProcessingMillis is how long a file's processing pipeline ran
+	MeterProcessingMillis = "processing_ms"
+	// MeterDownloads counts the reads of a file's content
+	MeterDownloads = "downloads"
+)
+
+// UsageRecord is one metered use of the service, billed to the owner of the
+// file it concerns
+type UsageRecord struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Metric    string    `json:"metric"`
+	Quantity  int64     `json:"quantity"`
+	FileID    string    `json:"file_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UsageDay is the total of one metric over a day
+type UsageDay struct {
+	Day      time.Time
+	Metric   string
+	Quantity int64
+}
+
+// RecordUsage stores a usage record. Without a userID the usage is billed to
+// the file's owner; usage that belongs to nobody isn't recorded.
+func RecordUsage(userID, metric string, quantity int64, fileID string) error {
+	return recordUsage(GetDB(), userID, metric, quantity, fileID)
+}
+
+func recordUsage(q querier, userID, metric string, quantity int64, fileID string) error {
+	_, err := q.Exec(`
+		INSERT INTO usage_records (id, user_id, metric, quantity, file_id)
+		SELECT $1::TEXT, owner, $3::TEXT, $4::BIGINT, $5::TEXT
+		FROM (SELECT COALESCE(NULLIF($2::TEXT, ''), (SELECT user_id FROM files WHERE id = $5)) AS owner) o
+		WHERE owner IS NOT NULL
+	`, NewID(), userID, metric, quantity, fileID)
+	return err
+}
+
+// ListUnexportedUsage returns up to limit usage records that haven't been
+// sent to the metering sink, oldest first
+func ListUnexportedUsage(limit int) ([]UsageRecord, error) {
+	rows, err := GetDB().Query(`
+		SELECT id, user_id, metric, quantity, file_id, created_at
+		FROM usage_records
+		WHERE exported_at IS NULL
+		ORDER BY created_at, id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []UsageRecord
+	for rows.Next() {
+		var u UsageRecord
+		if err := rows.Scan(&u.ID, &u.UserID, &u.Metric, &u.Quantity, &u.FileID, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, u)
+	}
+	return records, rows.Err()
+}
+
+// MarkUsageExported records that usage records were sent to the metering sink
+func MarkUsageExported(ids []string) error {
+	_, err := GetDB().Exec(`UPDATE usage_records SET exported_at = NOW() WHERE id = ANY($1)`, pq.Array(ids))
+	return err
+}
+
+// GetUsageByDay totals a user's usage in [since, until) per day and metric,
+// oldest day first
+func GetUsageByDay(userID string, since, until time.Time) ([]UsageDay, error) {
+	rows, err := GetDB().Query(`
+		SELECT date_trunc('day', created_at), metric, SUM(quantity)
+		FROM usage_records
+		WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+		GROUP BY 1, 2
+		ORDER BY 1, 2
+	`, userID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []UsageDay
+	for rows.Next() {
+		var d UsageDay
+		if err := rows.Scan(&d.Day, &d.Metric, &d.Quantity); err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
@@ -0,0 +1,1008 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migration is a versioned schema change. Migrations are applied in order and
+// each one runs at most once per database.
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrations lists every schema change in the order it is applied. Append new
+// migrations to the end and never edit one that has shipped.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		// Tables use IF NOT EXISTS so databases created before migrations
+		// were tracked are adopted as they are
+		SQL: `
+			CREATE TABLE IF NOT EXISTS users (
+				id TEXT PRIMARY KEY,
+				username TEXT UNIQUE NOT NULL,
+				password TEXT NOT NULL,
+				email TEXT UNIQUE NOT NULL,
+				confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+
+			CREATE TABLE IF NOT EXISTS access_tokens (
+				token TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id),
+				expires_at TIMESTAMP NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+
+			CREATE TABLE IF NOT EXISTS files (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				s3_key TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS user_id TEXT REFERENCES users(id);
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS size_bytes BIGINT;
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT NOW();
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+			CREATE TABLE IF NOT EXISTS processing_results (
+				id TEXT PRIMARY KEY,
+				file_id TEXT NOT NULL REFERENCES files(id),
+				status TEXT NOT NULL,
+				result TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+
+			ALTER TABLE processing_results ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT NOW();
+			ALTER TABLE processing_results ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+			CREATE TABLE IF NOT EXISTS scheduled_jobs (
+				id TEXT PRIMARY KEY,
+				file_id TEXT NOT NULL REFERENCES files(id),
+				s3_key TEXT NOT NULL,
+				run_at TIMESTAMP NOT NULL,
+				status TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+
+			CREATE TABLE IF NOT EXISTS processing_attempts (
+				file_id TEXT PRIMARY KEY REFERENCES files(id),
+				attempts INTEGER NOT NULL DEFAULT 0,
+				status TEXT NOT NULL,
+				last_error TEXT NOT NULL DEFAULT '',
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+
+			CREATE TABLE IF NOT EXISTS exports (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id),
+				format TEXT NOT NULL,
+				status TEXT NOT NULL,
+				s3_key TEXT NOT NULL DEFAULT '',
+				error TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				completed_at TIMESTAMP
+			);
+		`,
+	},
+	{
+		Version: 2,
+		Name:    "result and listing indexes",
+		SQL: `
+			-- Latest result per file: WHERE file_id = $1 ORDER BY created_at DESC LIMIT 1
+			CREATE INDEX IF NOT EXISTS idx_processing_results_file_id_created_at
+				ON processing_results (file_id, created_at DESC);
+
+			-- Listing all files newest first
+			CREATE INDEX IF NOT EXISTS idx_files_created_at
+				ON files (created_at DESC);
+
+			-- Per-user listing sorted by upload time. The included columns let the
+			-- list be served from the index without visiting the table.
+			CREATE INDEX IF NOT EXISTS idx_files_user_id_created_at
+				ON files (user_id, created_at)
+				INCLUDE (id, name, s3_key, size_bytes, updated_at, version);
+
+			-- Scheduler claims and per-file job lookups
+			CREATE INDEX IF NOT EXISTS idx_scheduled_jobs_status_run_at
+				ON scheduled_jobs (status, run_at);
+			CREATE INDEX IF NOT EXISTS idx_scheduled_jobs_file_id
+				ON scheduled_jobs (file_id);
+		`,
+	},
+	{
+		Version: 3,
+		Name:    "keyset pagination indexes",
+		SQL: `
+			-- Users are listed newest first with (created_at, id) cursors
+			CREATE INDEX IF NOT EXISTS idx_users_created_at_id
+				ON users (created_at DESC, id DESC);
+		`,
+	},
+	{
+		Version: 4,
+		Name:    "file full-text search",
+		SQL: `
+			-- Text extracted by the processing Lambda
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS search_text TEXT NOT NULL DEFAULT '';
+
+			-- Names rank above content matches
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS search_vector tsvector
+				GENERATED ALWAYS AS (
+					setweight(to_tsvector('english', name), 'A') ||
+					setweight(to_tsvector('english', search_text), 'B')
+				) STORED;
+
+			CREATE INDEX IF NOT EXISTS idx_files_search_vector
+				ON files USING GIN (search_vector);
+		`,
+	},
+	{
+		Version: 5,
+		Name:    "file access log",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS file_access_log (
+				id TEXT PRIMARY KEY,
+				file_id TEXT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+				user_id TEXT,
+				action TEXT NOT NULL,
+				ip TEXT NOT NULL DEFAULT '',
+				user_agent TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_file_access_log_file_id_created_at
+				ON file_access_log (file_id, created_at DESC, id DESC);
+		`,
+	},
+	{
+		Version: 6,
+		Name:    "share links",
+		SQL: `
+			-- Only a hash of the share token is stored
+			CREATE TABLE IF NOT EXISTS shares (
+				id TEXT PRIMARY KEY,
+				token_hash TEXT UNIQUE NOT NULL,
+				file_id TEXT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+				user_id TEXT NOT NULL REFERENCES users(id),
+				expires_at TIMESTAMP NOT NULL,
+				max_downloads INTEGER,
+				downloads INTEGER NOT NULL DEFAULT 0,
+				password_hash TEXT NOT NULL DEFAULT '',
+				revoked_at TIMESTAMP,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_shares_user_id_created_at
+				ON shares (user_id, created_at DESC, id DESC);
+		`,
+	},
+	{
+		Version: 7,
+		Name:    "collections",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS collections (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id),
+				-- Deleting a collection deletes everything nested in it
+				parent_id TEXT REFERENCES collections(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+
+			-- Sibling names are unique, including at the top level
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_collections_user_parent_name
+				ON collections (user_id, COALESCE(parent_id, ''), name);
+			CREATE INDEX IF NOT EXISTS idx_collections_parent_id
+				ON collections (parent_id);
+
+			-- Files in a deleted collection are kept, outside any collection
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS collection_id TEXT
+				REFERENCES collections(id) ON DELETE SET NULL;
+			CREATE INDEX IF NOT EXISTS idx_files_collection_id
+				ON files (collection_id);
+		`,
+	},
+	{
+		Version: 8,
+		Name:    "idempotency_keys",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS idempotency_keys (
+				-- The user the key belongs to, or '' for anonymous requests
+				scope TEXT NOT NULL,
+				key TEXT NOT NULL,
+				request_hash TEXT NOT NULL,
+				-- NULL until the original request has finished
+				status_code INTEGER,
+				content_type TEXT NOT NULL DEFAULT '',
+				response_body BYTEA,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				expires_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (scope, key)
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at
+				ON idempotency_keys (expires_at);
+		`,
+	},
+	{
+		Version: 9,
+		Name:    "unique_file_names",
+		SQL: `
+			-- Files sharing an owner, collection and name are told apart by revision
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS name_revision INTEGER NOT NULL DEFAULT 1;
+
+			-- Number existing duplicates in upload order so the index can be built
+			UPDATE files f
+			SET name_revision = d.revision
+			FROM (
+				SELECT id, ROW_NUMBER() OVER (
+					PARTITION BY user_id, COALESCE(collection_id, ''), name
+					ORDER BY created_at, id
+				) AS revision
+				FROM files
+				WHERE user_id IS NOT NULL
+			) d
+			WHERE f.id = d.id AND d.revision > 1;
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_files_user_collection_name
+				ON files (user_id, (COALESCE(collection_id, '')), name, name_revision)
+				WHERE user_id IS NOT NULL;
+		`,
+	},
+	{
+		Version: 10,
+		Name:    "maintenance mode",
+		// A single row shared by the API and the worker
+		SQL: `
+			CREATE TABLE IF NOT EXISTS maintenance (
+				id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+				enabled BOOLEAN NOT NULL DEFAULT FALSE,
+				message TEXT NOT NULL DEFAULT '',
+				retry_after_seconds INTEGER NOT NULL DEFAULT 0,
+				updated_by TEXT NOT NULL DEFAULT '',
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+
+			INSERT INTO maintenance (id) VALUES (TRUE) ON CONFLICT DO NOTHING;
+		`,
+	},
+	{
+		Version: 11,
+		Name:    "domain events",
+		// file_id has no foreign key so events outlive deleted files
+		SQL: `
+			CREATE TABLE IF NOT EXISTS events (
+				id TEXT PRIMARY KEY,
+				type TEXT NOT NULL,
+				file_id TEXT NOT NULL,
+				user_id TEXT,
+				payload JSONB NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_events_created_at ON events (created_at, id);
+			CREATE INDEX IF NOT EXISTS idx_events_file_id ON events (file_id, created_at);
+		`,
+	},
+	{
+		Version: 12,
+		Name:    "ssh keys",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS ssh_keys (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				fingerprint TEXT UNIQUE NOT NULL,
+				public_key TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_ssh_keys_user_id ON ssh_keys (user_id);
+		`,
+	},
+	{
+		Version: 13,
+		Name:    "watch checkpoints",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS watch_checkpoints (
+				prefix TEXT PRIMARY KEY,
+				last_key TEXT NOT NULL,
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+		`,
+	},
+	{
+		Version: 14,
+		Name:    "file storage class",
+		SQL: `
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS storage_class TEXT NOT NULL DEFAULT 'STANDARD';
+		`,
+	},
+	{
+		Version: 15,
+		Name:    "file retention",
+		SQL: `
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS retention_mode TEXT NOT NULL DEFAULT '';
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS retain_until TIMESTAMP;
+		`,
+	},
+	{
+		Version: 16,
+		Name:    "file encryption envelope",
+		SQL: `
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS encryption_algorithm TEXT NOT NULL DEFAULT '';
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS encryption_key_id TEXT NOT NULL DEFAULT '';
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS encrypted_data_key BYTEA;
+		`,
+	},
+	{
+		Version: 17,
+		Name:    "external identities",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS user_identities (
+				provider TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				user_id TEXT NOT NULL REFERENCES users(id),
+				email TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (provider, subject)
+			);
+			CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);
+
+			CREATE TABLE IF NOT EXISTS oauth_states (
+				state TEXT PRIMARY KEY,
+				provider TEXT NOT NULL,
+				code_verifier TEXT NOT NULL,
+				nonce TEXT NOT NULL,
+				expires_at TIMESTAMP NOT NULL
+			);
+		`,
+	},
+	{
+		Version: 18,
+		Name:    "token scopes and api keys",
+		SQL: `
+			ALTER TABLE access_tokens ADD COLUMN IF NOT EXISTS scopes TEXT[];
+
+			CREATE TABLE IF NOT EXISTS api_keys (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id),
+				name TEXT NOT NULL,
+				prefix TEXT NOT NULL,
+				key_hash TEXT UNIQUE NOT NULL,
+				scopes TEXT[] NOT NULL,
+				expires_at TIMESTAMP,
+				last_used_at TIMESTAMP,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+			CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+		`,
+	},
+	{
+		// Accounts differing only in case can no longer be created. If this
+		// fails on a duplicate key, merge or rename those accounts first.
+		Version: 19,
+		Name:    "case-insensitive usernames and emails",
+		SQL: `
+			UPDATE users SET username = TRIM(username), email = LOWER(TRIM(email));
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS email_key TEXT;
+			UPDATE users SET email_key = email WHERE email_key IS NULL;
+			ALTER TABLE users ALTER COLUMN email_key SET NOT NULL;
+			CREATE UNIQUE INDEX IF NOT EXISTS users_email_key_idx ON users (email_key);
+			CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_idx ON users (LOWER(username));
+		`,
+	},
+	{
+		Version: 20,
+		Name:    "account deletions",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS account_deletions (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id),
+				status TEXT NOT NULL,
+				scheduled_for TIMESTAMP NOT NULL,
+				files_deleted INTEGER NOT NULL DEFAULT 0,
+				-- Files kept because their retention hadn't ended
+				files_retained INTEGER NOT NULL DEFAULT 0,
+				objects_deleted INTEGER NOT NULL DEFAULT 0,
+				error TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				completed_at TIMESTAMP
+			);
+			-- A user has at most one deletion waiting or in progress
+			CREATE UNIQUE INDEX IF NOT EXISTS account_deletions_open_idx
+				ON account_deletions (user_id) WHERE status IN ('pending', 'running');
+			CREATE INDEX IF NOT EXISTS account_deletions_due_idx
+				ON account_deletions (scheduled_for) WHERE status = 'pending';
+			CREATE INDEX IF NOT EXISTS account_deletions_created_at_idx
+				ON account_deletions (created_at DESC, id DESC);
+		`,
+	},
+	{
+		// Data exports list a user's events and file accesses
+		Version: 21,
+		Name:    "user history indexes",
+		SQL: `
+			CREATE INDEX IF NOT EXISTS idx_events_user_id ON events (user_id, created_at);
+			CREATE INDEX IF NOT EXISTS idx_file_access_log_user_id ON file_access_log (user_id, created_at);
+		`,
+	},
+	{
+		Version: 22,
+		Name:    "file legal hold",
+		SQL: `
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS legal_hold BOOLEAN NOT NULL DEFAULT FALSE;
+		`,
+	},
+	{
+		// Deliveries whose result was saved, so a redelivered or duplicated
+		// message doesn't add a second result
+		Version: 23,
+		Name:    "processed messages",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS processed_messages (
+				-- The FIFO deduplication ID, or the SQS message ID
+				message_key TEXT NOT NULL,
+				file_id TEXT NOT NULL,
+				processed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (message_key, file_id)
+			);
+			CREATE INDEX IF NOT EXISTS processed_messages_processed_at_idx
+				ON processed_messages (processed_at);
+		`,
+	},
+	{
+		Version: 24,
+		Name:    "webhooks",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS webhooks (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				url TEXT NOT NULL,
+				-- Key for the HMAC-SHA256 signature sent with each delivery
+				secret TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+			CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+		`,
+	},
+	{
+		Version: 25,
+		Name:    "processing pipelines",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS processing_pipelines (
+				user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				-- A file extension such as '.csv', or '*' for every other type
+				file_type TEXT NOT NULL,
+				stages TEXT[] NOT NULL,
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (user_id, file_type)
+			);
+		`,
+	},
+	{
+		// Results from tenants' own Lambda functions are kept next to the
+		// built-in ones without replacing them as a file's latest result
+		Version: 26,
+		Name:    "custom processors",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS custom_processors (
+				user_id TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+				function_arn TEXT NOT NULL,
+				-- An allow-listed role the function is invoked as
+				role_arn TEXT NOT NULL,
+				timeout_seconds INTEGER NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+			ALTER TABLE processing_results ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'builtin';
+		`,
+	},
+	{
+		Version: 27,
+		Name:    "wasm plugins",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS wasm_plugins (
+				name TEXT PRIMARY KEY,
+				module BYTEA NOT NULL,
+				sha256 TEXT NOT NULL,
+				size_bytes BIGINT NOT NULL,
+				updated_by TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+		`,
+	},
+	{
+		Version: 28,
+		Name:    "text stats",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS text_stats (
+				file_id TEXT PRIMARY KEY REFERENCES files(id) ON DELETE CASCADE,
+				language TEXT NOT NULL,
+				words INTEGER NOT NULL,
+				sentences INTEGER NOT NULL,
+				characters BIGINT NOT NULL,
+				-- NULL for texts without words
+				flesch_reading_ease DOUBLE PRECISION,
+				flesch_kincaid_grade DOUBLE PRECISION,
+				top_terms JSONB NOT NULL DEFAULT '[]',
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+			CREATE INDEX IF NOT EXISTS idx_text_stats_language ON text_stats(language);
+		`,
+	},
+	{
+		// Members of an expanded archive outlive it as files of their own
+		Version: 29,
+		Name:    "archive members",
+		SQL: `
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS parent_id TEXT
+				REFERENCES files(id) ON DELETE SET NULL;
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS expand_archive BOOLEAN NOT NULL DEFAULT FALSE;
+			CREATE INDEX IF NOT EXISTS idx_files_parent_id ON files(parent_id) WHERE parent_id IS NOT NULL;
+		`,
+	},
+	{
+		// Checksums of the parts of files uploaded in parts, so a damaged
+		// object can be checked and repaired a part at a time
+		Version: 30,
+		Name:    "file chunks",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS file_chunks (
+				file_id TEXT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+				part_number INTEGER NOT NULL,
+				offset_bytes BIGINT NOT NULL,
+				size_bytes BIGINT NOT NULL,
+				-- Hex SHA-256 of the part as stored, ciphertext for encrypted files
+				sha256 TEXT NOT NULL,
+				corrupt BOOLEAN NOT NULL DEFAULT FALSE,
+				verified_at TIMESTAMP,
+				PRIMARY KEY (file_id, part_number)
+			);
+		`,
+	},
+	{
+		Version: 31,
+		Name:    "anonymous upload user",
+		SQL: `
+			-- Owns uploads made without credentials. It has no password, so
+			-- nobody can sign in as it.
+			INSERT INTO users (id, username, password, email, email_key, confirmed)
+			VALUES ('anonymous', '~anonymous', '', 'anonymous@uploads.invalid', 'anonymous@uploads.invalid', true)
+			ON CONFLICT DO NOTHING;
+		`,
+	},
+	{
+		Version: 32,
+		Name:    "upload tokens",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS upload_tokens (
+				id TEXT PRIMARY KEY,
+				-- SHA-256 of the token; the token itself is only shown once
+				token_hash TEXT UNIQUE NOT NULL,
+				user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				size_bytes BIGINT NOT NULL,
+				content_type TEXT NOT NULL,
+				expires_at TIMESTAMP NOT NULL,
+				-- Set when the token is redeemed, which it can be only once
+				used_at TIMESTAMP,
+				file_id TEXT REFERENCES files(id) ON DELETE SET NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+		`,
+	},
+	{
+		Version: 33,
+		Name:    "usage records",
+		// Neither key references its table, so billing records outlive
+		// deleted files and accounts
+		SQL: `
+			CREATE TABLE IF NOT EXISTS usage_records (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				metric TEXT NOT NULL,
+				quantity BIGINT NOT NULL,
+				file_id TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				-- Set once the record was sent to the external metering sink
+				exported_at TIMESTAMP
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_usage_records_user_id_created_at
+				ON usage_records (user_id, created_at);
+			CREATE INDEX IF NOT EXISTS idx_usage_records_unexported
+				ON usage_records (created_at, id) WHERE exported_at IS NULL;
+		`,
+	},
+	{
+		Version: 34,
+		Name:    "tenant settings",
+		SQL: `
+			-- Overrides of the runtime settings for one user; NULL keeps the
+			-- global setting
+			CREATE TABLE IF NOT EXISTS tenant_settings (
+				user_id TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+				max_file_bytes BIGINT,
+				max_files INTEGER,
+				max_bytes BIGINT,
+				allowed_file_types TEXT[],
+				file_expiry_days INTEGER,
+				enabled_processors TEXT[],
+				updated_by TEXT NOT NULL DEFAULT '',
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+		`,
+	},
+	{
+		Version: 35,
+		Name:    "ip allow and deny lists",
+		SQL: `
+			-- An API key with allowed ranges only works from those
+			ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_cidrs TEXT[] NOT NULL DEFAULT '{}';
+
+			-- Ranges no request is accepted from
+			CREATE TABLE IF NOT EXISTS ip_deny_list (
+				id TEXT PRIMARY KEY,
+				cidr TEXT UNIQUE NOT NULL,
+				reason TEXT NOT NULL DEFAULT '',
+				created_by TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+		`,
+	},
+	{
+		Version: 36,
+		Name:    "signed integration callbacks",
+		SQL: `
+			-- A third party that calls back into the API, signing each call
+			-- with its secret. Files it reports are owned by user_id.
+			CREATE TABLE IF NOT EXISTS integrations (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				secret TEXT NOT NULL,
+				-- The secret before the last rotation, accepted until
+				-- previous_expires_at
+				previous_secret TEXT NOT NULL DEFAULT '',
+				previous_expires_at TIMESTAMP,
+				created_by TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				rotated_at TIMESTAMP
+			);
+
+			-- Signatures already accepted, so a captured call can't be
+			-- replayed while its timestamp is still within tolerance
+			CREATE TABLE IF NOT EXISTS callback_signatures (
+				integration_id TEXT NOT NULL REFERENCES integrations(id) ON DELETE CASCADE,
+				signature TEXT NOT NULL,
+				received_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (integration_id, signature)
+			);
+		`,
+	},
+	{
+		Version: 37,
+		Name:    "file content types",
+		SQL: `
+			-- The MIME type sniffed from the content when the file was
+			-- stored; empty for files stored before it was recorded
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		Version: 38,
+		Name:    "tenant bandwidth limits",
+		SQL: `
+			-- Bytes per second a tenant may upload and download; NULL keeps
+			-- the global setting and 0 means no limit
+			ALTER TABLE tenant_settings ADD COLUMN IF NOT EXISTS upload_bytes_per_second BIGINT;
+			ALTER TABLE tenant_settings ADD COLUMN IF NOT EXISTS download_bytes_per_second BIGINT;
+		`,
+	},
+	{
+		Version: 39,
+		Name:    "reused processing results",
+		SQL: `
+			-- SHA-256 of the plain content, recorded when the file is
+			-- processed; empty until then
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS content_sha256 TEXT NOT NULL DEFAULT '';
+			-- The file whose result was copied because the content was
+			-- unchanged, instead of processing it again
+			ALTER TABLE processing_results ADD COLUMN IF NOT EXISTS reused_from TEXT REFERENCES files(id) ON DELETE SET NULL;
+		`,
+	},
+	{
+		Version: 40,
+		Name:    "archive member counts",
+		SQL: `
+			-- How many members an expanded archive stored; NULL until the
+			-- expansion is done, so the archive isn't reported complete
+			-- while members are still being stored
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS member_count INTEGER;
+		`,
+	},
+	{
+		Version: 41,
+		Name:    "file dependencies",
+		SQL: `
+			-- file_id isn't processed until depends_on has a completed
+			-- result. Edges are only added for new files, pointing at
+			-- existing ones, so they can't form a cycle.
+			CREATE TABLE IF NOT EXISTS file_dependencies (
+				file_id TEXT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+				depends_on TEXT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+				PRIMARY KEY (file_id, depends_on)
+			);
+			CREATE INDEX IF NOT EXISTS idx_file_dependencies_depends_on ON file_dependencies(depends_on);
+		`,
+	},
+	{
+		Version: 42,
+		Name:    "recurring schedules",
+		SQL: `
+			-- A schedule re-runs processing of one file, or of every file
+			-- whose name starts with prefix, whenever its cron expression
+			-- fires. An empty processor runs each file's own pipeline.
+			CREATE TABLE IF NOT EXISTS schedules (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				cron TEXT NOT NULL,
+				file_id TEXT REFERENCES files(id) ON DELETE CASCADE,
+				prefix TEXT NOT NULL DEFAULT '',
+				processor TEXT NOT NULL DEFAULT '',
+				enabled BOOLEAN NOT NULL DEFAULT TRUE,
+				next_run_at TIMESTAMP NOT NULL,
+				last_run_at TIMESTAMP,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+			CREATE INDEX IF NOT EXISTS idx_schedules_user_id ON schedules(user_id);
+			CREATE INDEX IF NOT EXISTS idx_schedules_next_run_at ON schedules(next_run_at) WHERE enabled;
+
+			-- One row per firing of a schedule, with the files it queued
+			CREATE TABLE IF NOT EXISTS schedule_runs (
+				id TEXT PRIMARY KEY,
+				schedule_id TEXT NOT NULL REFERENCES schedules(id) ON DELETE CASCADE,
+				started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				files INTEGER NOT NULL DEFAULT 0,
+				-- Files whose processing message couldn't be sent
+				failed INTEGER NOT NULL DEFAULT 0,
+				error TEXT NOT NULL DEFAULT ''
+			);
+			CREATE INDEX IF NOT EXISTS idx_schedule_runs_schedule_id ON schedule_runs(schedule_id, started_at DESC);
+			CREATE TABLE IF NOT EXISTS schedule_run_files (
+				run_id TEXT NOT NULL REFERENCES schedule_runs(id) ON DELETE CASCADE,
+				file_id TEXT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+				PRIMARY KEY (run_id, file_id)
+			);
+		`,
+	},
+	{
+		Version: 43,
+		Name:    "processing progress",
+		SQL: `
+			-- How far a long-running pipeline has got with a file; removed
+			-- once the file's result is saved
+			CREATE TABLE IF NOT EXISTS processing_progress (
+				file_id TEXT PRIMARY KEY REFERENCES files(id) ON DELETE CASCADE,
+				stage INTEGER NOT NULL,
+				stages INTEGER NOT NULL,
+				processor TEXT NOT NULL,
+				done BIGINT NOT NULL DEFAULT 0,
+				total BIGINT NOT NULL DEFAULT 0,
+				percent REAL NOT NULL DEFAULT 0,
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+		`,
+	},
+	{
+		Version: 44,
+		Name:    "offloaded results",
+		SQL: `
+			-- A result too large for its row is stored in S3 under
+			-- result_key; result then keeps only its beginning, and
+			-- result_size is the length of the whole result
+			ALTER TABLE processing_results ADD COLUMN IF NOT EXISTS result_key TEXT NOT NULL DEFAULT '';
+			ALTER TABLE processing_results ADD COLUMN IF NOT EXISTS result_size BIGINT NOT NULL DEFAULT 0;
+			CREATE INDEX IF NOT EXISTS idx_processing_results_result_key ON processing_results(result_key) WHERE result_key <> '';
+
+			-- Offloaded results whose rows were deleted or replaced, for
+			-- the scheduler to delete once no other row points to them
+			CREATE TABLE IF NOT EXISTS result_object_deletions (
+				key TEXT PRIMARY KEY,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+		`,
+	},
+	{
+		Version: 45,
+		Name:    "analytics partitions",
+		SQL: `
+			-- Each UTC day of processing results exported to S3 as a
+			-- Parquet partition for analytics
+			CREATE TABLE IF NOT EXISTS analytics_partitions (
+				day DATE PRIMARY KEY,
+				status TEXT NOT NULL,
+				s3_key TEXT NOT NULL DEFAULT '',
+				rows BIGINT NOT NULL DEFAULT 0,
+				error TEXT NOT NULL DEFAULT '',
+				started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				exported_at TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_processing_results_created_at ON processing_results(created_at, id);
+		`,
+	},
+	{
+		Version: 46,
+		Name:    "analytics queries",
+		SQL: `
+			-- Predefined Athena queries run by administrators, kept to track
+			-- their executions and to serve repeated requests from cache
+			CREATE TABLE IF NOT EXISTS analytics_queries (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				params TEXT NOT NULL,
+				execution_id TEXT NOT NULL,
+				status TEXT NOT NULL,
+				error TEXT NOT NULL DEFAULT '',
+				result JSONB,
+				data_scanned_bytes BIGINT NOT NULL DEFAULT 0,
+				requested_by TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				finished_at TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_analytics_queries_cache ON analytics_queries(name, params, created_at);
+			CREATE INDEX IF NOT EXISTS idx_analytics_queries_created_at ON analytics_queries(created_at);
+		`,
+	},
+	{
+		Version: 47,
+		Name:    "result quarantines",
+		SQL: `
+			-- Results a gate withheld from publication, with the withheld
+			-- result, until an administrator approves or rejects them
+			CREATE TABLE IF NOT EXISTS result_quarantines (
+				id TEXT PRIMARY KEY,
+				result_id TEXT NOT NULL UNIQUE REFERENCES processing_results(id) ON DELETE CASCADE,
+				file_id TEXT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+				gate TEXT NOT NULL,
+				reason TEXT NOT NULL,
+				result TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'pending',
+				reviewed_by TEXT NOT NULL DEFAULT '',
+				note TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				reviewed_at TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_result_quarantines_status ON result_quarantines(status, created_at);
+		`,
+	},
+	{
+		Version: 48,
+		Name:    "redacted copies",
+		SQL: `
+			-- The copy of a file with its personal data redacted, served to
+			-- callers who may not see the original
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS redacted_key TEXT NOT NULL DEFAULT '';
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS redacted_size BIGINT NOT NULL DEFAULT 0;
+		`,
+	},
+	{
+		Version: 49,
+		Name:    "access log variants",
+		SQL: `
+			-- Whether an access was served the original content or the
+			-- redacted copy; empty for accesses logged before copies existed
+			ALTER TABLE file_access_log ADD COLUMN IF NOT EXISTS variant TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		Version: 50,
+		Name:    "column keys",
+		SQL: `
+			-- Data keys sensitive columns are encrypted with, wrapped by KMS.
+			-- Each purpose has at most one active key.
+			CREATE TABLE IF NOT EXISTS column_keys (
+				id TEXT PRIMARY KEY,
+				purpose TEXT NOT NULL,
+				kms_key_id TEXT NOT NULL,
+				wrapped_key BYTEA NOT NULL,
+				active BOOLEAN NOT NULL DEFAULT true,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				retired_at TIMESTAMP
+			);
+			CREATE UNIQUE INDEX IF NOT EXISTS column_keys_active_idx ON column_keys (purpose) WHERE active;
+		`,
+	},
+	{
+		Version: 51,
+		Name:    "jwt signing keys",
+		SQL: `
+			-- RSA keys the API signs tokens with when it keeps its own, the
+			-- private key sealed with the column keys. One key is active.
+			CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+				id TEXT PRIMARY KEY,
+				private_key TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				retired_at TIMESTAMP
+			);
+			CREATE UNIQUE INDEX IF NOT EXISTS jwt_signing_keys_active_idx ON jwt_signing_keys ((true)) WHERE retired_at IS NULL;
+		`,
+	},
+	{
+		Version: 52,
+		Name:    "pending redactions",
+		SQL: `
+			-- Set on files uploaded with the pii stage in their pipeline until
+			-- the stage has run, so they aren't served unredacted meanwhile
+			ALTER TABLE files ADD COLUMN IF NOT EXISTS redaction_pending BOOLEAN NOT NULL DEFAULT false;
+		`,
+	},
+	{
+		Version: 53,
+		Name:    "hashed access tokens",
+		SQL: `
+			-- Access tokens are kept by their SHA-256 like share and upload
+			-- tokens, so that reading the table doesn't hand out sessions.
+			-- Tokens already issued keep working.
+			ALTER TABLE access_tokens RENAME COLUMN token TO token_hash;
+			UPDATE access_tokens SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex');
+		`,
+	},
+}
+
+// migrationLockID is the advisory lock key held while applying migrations
+const migrationLockID = 72140931
+
+// migrate applies every migration that hasn't been recorded in schema_migrations
+func migrate() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	for _, m := range migrations {
+		err := WithTx(func(tx *sql.Tx) error {
+			// The API and the Lambda may start together; the lock makes the
+			// second one wait and then see the migration as applied
+			if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+				return err
+			}
+
+			var applied bool
+			err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version = $1)`, m.Version).Scan(&applied)
+			if err != nil || applied {
+				return err
+			}
+
+			log.Printf("Applying migration %d: %s", m.Version, m.Name)
+			if _, err := tx.Exec(m.SQL); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %v", m.Version, m.Name, err)
+		}
+	}
+
+	log.Printf("Database schema is up to date")
+	return nil
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// fileResultsChannel is the NOTIFY channel processing results are fanned out
+// to API instances on, and fileProgressChannel the one progress is
+const (
+	fileResultsChannel  = "file_results"
+	fileProgressChannel = "file_progress"
+)
+
+// NotifyFileResult sends payload to every API instance listening with
+// ListenFileResults. Payloads must stay under Postgres's 8000 byte limit.
+func NotifyFileResult(payload []byte) error {
+	return notify(fileResultsChannel, payload)
+}
+
+// NotifyFileProgress sends payload to every API instance listening with
+// ListenFileProgress, under the same limit as NotifyFileResult
+func NotifyFileProgress(payload []byte) error {
+	return notify(fileProgressChannel, payload)
+}
+
+func notify(channel string, payload []byte) error {
+	_, err := GetDB().Exec(`SELECT pg_notify($1, $2)`, channel, string(payload))
+	return err
+}
+
+// ListenFileResults calls fn with each payload sent by NotifyFileResult until
+// ctx is done. The listener holds its own connection and reconnects after
+// losing it; notifications sent while it is disconnected are missed.
+func ListenFileResults(ctx context.Context, fn func(payload []byte)) error {
+	return listen(ctx, fileResultsChannel, fn)
+}
+
+// ListenFileProgress is ListenFileResults for the payloads sent by
+// NotifyFileProgress
+func ListenFileProgress(ctx context.Context, fn func(payload []byte)) error {
+	return listen(ctx, fileProgressChannel, fn)
+}
+
+func listen(ctx context.Context, channel string, fn func(payload []byte)) error {
+	listener := pq.NewListener(connInfo, time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Listener on %s: %v", channel, err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				// A nil notification follows a reconnect
+				if n != nil {
+					fn([]byte(n.Extra))
+				}
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,77 @@
+package database
+
+// queueResultObjectDeletion records that the offloaded result under key may
+// no longer be needed
+func queueResultObjectDeletion(q querier, key string) error {
+	_, err := q.Exec(`
+		INSERT INTO result_object_deletions (key) VALUES ($1)
+		ON CONFLICT (key) DO NOTHING
+	`, key)
+	return err
+}
+
+// queueFileResultObjectDeletions records the offloaded results of a file
+// about to be deleted. Objects shared with reused results of other files are
+// kept when the deletions are claimed.
+func queueFileResultObjectDeletions(q querier, fileID string) error {
+	_, err := q.Exec(`
+		INSERT INTO result_object_deletions (key)
+		SELECT DISTINCT result_key FROM processing_results
+		WHERE file_id = $1 AND result_key <> ''
+		ON CONFLICT (key) DO NOTHING
+	`, fileID)
+	return err
+}
+
+// ClaimResultObjectDeletions removes up to limit queued deletions and returns
+// the keys of those no result points to anymore; the caller deletes their
+// objects. Keys still in use, such as by a reused result, are dropped from
+// the queue and deleted once the last row pointing to them goes.
+func ClaimResultObjectDeletions(limit int) ([]string, error) {
+	return claimResultObjectDeletions(`
+		DELETE FROM result_object_deletions d
+		WHERE d.key IN (
+			SELECT key FROM result_object_deletions
+			ORDER BY created_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.key, EXISTS (SELECT 1 FROM processing_results p WHERE p.result_key = d.key)
+	`, limit)
+}
+
+// ClaimResultObjectDeletionsUnder is ClaimResultObjectDeletions for the
+// queued keys starting with prefix, so a deleted file's own results can be
+// deleted without waiting for the rest of the queue
+func ClaimResultObjectDeletionsUnder(prefix string) ([]string, error) {
+	return claimResultObjectDeletions(`
+		DELETE FROM result_object_deletions d
+		WHERE d.key IN (
+			SELECT key FROM result_object_deletions
+			WHERE starts_with(key, $1)
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.key, EXISTS (SELECT 1 FROM processing_results p WHERE p.result_key = d.key)
+	`, prefix)
+}
+
+func claimResultObjectDeletions(query string, arg interface{}) ([]string, error) {
+	rows, err := GetDB().Query(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		var inUse bool
+		if err := rows.Scan(&key, &inUse); err != nil {
+			return nil, err
+		}
+		if !inUse {
+			keys = append(keys, key)
+		}
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Pipeline is the sequence of processors a user has chosen for one file type
+type Pipeline struct {
+	UserID    string
+	FileType  string
+	Stages    []string
+	UpdatedAt time.Time
+}
+
+// GetUserPipeline returns the stages a user set for fileType, or for "*" when
+// they didn't set one for the type. It returns nil if neither is set.
+func GetUserPipeline(userID, fileType string) ([]string, error) {
+	var stages []string
+	err := GetDB().QueryRow(`
+		SELECT stages
+		FROM processing_pipelines
+		WHERE user_id = $1 AND file_type IN ($2, '*')
+		ORDER BY file_type = '*'
+		LIMIT 1
+	`, userID, fileType).Scan(pq.Array(&stages))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stages, nil
+}
+
+// SaveUserPipeline sets a user's pipeline for a file type
+func SaveUserPipeline(userID, fileType string, stages []string) (*Pipeline, error) {
+	p := Pipeline{UserID: userID, FileType: fileType}
+	err := GetDB().QueryRow(`
+		INSERT INTO processing_pipelines (user_id, file_type, stages)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, file_type) DO UPDATE
+		SET stages = EXCLUDED.stages, updated_at = NOW()
+		RETURNING stages, updated_at
+	`, userID, fileType, pq.Array(stages)).Scan(pq.Array(&p.Stages), &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListUserPipelines returns a user's pipelines ordered by file type
+func ListUserPipelines(userID string) ([]Pipeline, error) {
+	rows, err := GetDB().Query(`
+		SELECT user_id, file_type, stages, updated_at
+		FROM processing_pipelines
+		WHERE user_id = $1
+		ORDER BY file_type
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pipelines []Pipeline
+	for rows.Next() {
+		var p Pipeline
+		if err := rows.Scan(&p.UserID, &p.FileType, pq.Array(&p.Stages), &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		pipelines = append(pipelines, p)
+	}
+	return pipelines, rows.Err()
+}
+
+// DeleteUserPipeline removes a user's pipeline for a file type, reporting
+// whether it existed
+func DeleteUserPipeline(userID, fileType string) (bool, error) {
+	res, err := GetDB().Exec(`DELETE FROM processing_pipelines WHERE user_id = $1 AND file_type = $2`, userID, fileType)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// processedMessageRetention is how long deliveries are remembered. SQS keeps
+// a message for at most 14 days, so an older one can't be delivered again.
+const processedMessageRetention = 14 * 24 * time.Hour
+
+// ErrAlreadyProcessed is returned when a delivery's result has already been
+// saved, by an earlier delivery of the same message or a concurrent one
+var ErrAlreadyProcessed = errors.New("message was already processed")
+
+// IsMessageProcessed reports whether the result for a file from the message
+// with the given key has been saved
+func IsMessageProcessed(messageKey, fileID string) (bool, error) {
+	var processed bool
+	err := GetDB().QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM processed_messages WHERE message_key = $1 AND file_id = $2)
+	`, messageKey, fileID).Scan(&processed)
+	return processed, err
+}
+
+// MarkMessageProcessedTx records that the result for a file from a message is
+// being saved in tx. The insert only succeeds once per message and file, so
+// saving the result in the same transaction and rolling back on
+// ErrAlreadyProcessed leaves a single result row however often the message is
+// delivered. A concurrent delivery waits on the row until tx finishes.
+func MarkMessageProcessedTx(tx *sql.Tx, messageKey, fileID string) error {
+	res, err := tx.Exec(`
+		INSERT INTO processed_messages (message_key, file_id)
+		VALUES ($1, $2)
+		ON CONFLICT (message_key, file_id) DO NOTHING
+	`, messageKey, fileID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlreadyProcessed
+	}
+	return nil
+}
+
+// DeleteOldProcessedMessages forgets deliveries older than SQS retention and
+// returns how many were deleted
+func DeleteOldProcessedMessages() (int64, error) {
+	res, err := GetDB().Exec(`
+		DELETE FROM processed_messages WHERE processed_at < NOW() - $1 * INTERVAL '1 second'
+	`, int64(processedMessageRetention/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -3,35 +3,141 @@ package database
 import (
 	"database/sql"
 	"time"
+
+	"github.com/lib/pq"
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// Processing result sources
+const (
+	// ResultBuiltin is produced by the service's own processors
+	ResultBuiltin = "builtin"
+	// ResultCustom is the output of a tenant's own Lambda function
+	ResultCustom = "custom"
 )
 
+// StatusExpanding is the status of an archive whose members are still being
+// stored or processed. It is replaced by the archive's final result once
+// every member has one.
+const StatusExpanding = "expanding"
+
+// StatusDependencyFailed is the status of a file that wasn't processed
+// because a file it depends on didn't complete
+const StatusDependencyFailed = "dependency_failed"
+
 type ProcessingResult struct {
 	ID        string
 	FileID    string
 	Status    string
 	Result    string
+	Source    string
 	CreatedAt time.Time
+	UpdatedAt time.Time
+	Version   int
+	// ReusedFrom is the file whose result was copied because the content
+	// hadn't changed, empty for results of a processing run
+	ReusedFrom string
+	// ResultKey is the object under storage.ResultPrefix holding a result
+	// too large to keep in the row, in which case Result is only its
+	// beginning and ResultSize the length of the whole result
+	ResultKey  string
+	ResultSize int64
 }
 
 // SaveProcessingResult saves a new processing result to the database
 func SaveProcessingResult(fileID, status, result string) error {
-	_, err := GetDB().Exec(`
-		INSERT INTO processing_results (file_id, status, result)
-		VALUES ($1, $2, $3)
-	`, fileID, status, result)
+	return saveProcessingResult(GetDB(), fileID, status, result, ResultBuiltin, "", 0)
+}
+
+// SaveProcessingResultTx is SaveProcessingResult run inside a transaction
+func SaveProcessingResultTx(tx *sql.Tx, fileID, status, result string) error {
+	return saveProcessingResult(tx, fileID, status, result, ResultBuiltin, "", 0)
+}
+
+// SaveOffloadedResultTx saves a result whose whole size bytes were stored in
+// S3 under key, keeping only its summary in the row
+func SaveOffloadedResultTx(tx *sql.Tx, fileID, status, summary, key string, size int64) error {
+	return saveProcessingResult(tx, fileID, status, summary, ResultBuiltin, key, size)
+}
+
+// SaveCustomResultTx saves the output of a tenant's own function as an
+// additional result, which doesn't become the file's latest result
+func SaveCustomResultTx(tx *sql.Tx, fileID, status, result string) error {
+	return saveProcessingResult(tx, fileID, status, result, ResultCustom, "", 0)
+}
+
+// SaveReusedResultTx saves a copy of from as fileID's result, linked to the
+// file it was produced for. An offloaded result's object is shared, not
+// copied.
+func SaveReusedResultTx(tx *sql.Tx, fileID string, from *ProcessingResult) error {
+	_, err := tx.Exec(`
+		INSERT INTO processing_results (id, file_id, status, result, source, reused_from, result_key, result_size)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, NewID(), fileID, from.Status, from.Result, ResultBuiltin, from.FileID, from.ResultKey, from.ResultSize)
+	return err
+}
+
+// ReusableResult returns the completed result of the revision before f when
+// that revision's content had the SHA-256 sum, or nil if there is none.
+// Results that were themselves reused are followed back to the run that
+// produced them.
+func ReusableResult(f *File, sum string) (*ProcessingResult, error) {
+	if f.UserID == "" || f.NameRevision < 2 || sum == "" {
+		return nil, nil
+	}
+	var pr ProcessingResult
+	err := GetDB().QueryRow(`
+		SELECT pr.id, COALESCE(pr.reused_from, pr.file_id), pr.status, pr.result, pr.source, pr.created_at, pr.updated_at, pr.version, pr.result_key, pr.result_size
+		FROM (
+			SELECT id, content_sha256
+			FROM files
+			WHERE user_id = $1 AND COALESCE(collection_id, '') = $2 AND name = $3 AND name_revision < $4
+			ORDER BY name_revision DESC
+			LIMIT 1
+		) prev
+		JOIN LATERAL (
+			SELECT id, file_id, reused_from, status, result, source, created_at, updated_at, version, result_key, result_size
+			FROM processing_results
+			WHERE file_id = prev.id AND source = 'builtin'
+			ORDER BY created_at DESC
+			LIMIT 1
+		) pr ON TRUE
+		WHERE prev.content_sha256 = $5 AND pr.status = 'completed'
+	`, f.UserID, f.CollectionID, f.Name, f.NameRevision, sum).Scan(&pr.ID, &pr.FileID, &pr.Status, &pr.Result, &pr.Source, &pr.CreatedAt, &pr.UpdatedAt, &pr.Version, &pr.ResultKey, &pr.ResultSize)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+func saveProcessingResult(q querier, fileID, status, result, source, key string, size int64) error {
+	return insertProcessingResult(q, NewID(), fileID, status, result, source, key, size)
+}
+
+// insertProcessingResult is saveProcessingResult for a result whose ID the
+// caller chose
+func insertProcessingResult(q querier, id, fileID, status, result, source, key string, size int64) error {
+	_, err := q.Exec(`
+		INSERT INTO processing_results (id, file_id, status, result, source, result_key, result_size)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, id, fileID, status, result, source, key, size)
 	return err
 }
 
-// GetProcessingResultByFileID retrieves the processing result for a specific file
+// GetProcessingResultByFileID retrieves the latest built-in processing result
+// for a specific file
 func GetProcessingResultByFileID(fileID string) (*ProcessingResult, error) {
 	var pr ProcessingResult
 	err := GetDB().QueryRow(`
-		SELECT id, file_id, status, result, created_at 
+		SELECT id, file_id, status, result, source, COALESCE(reused_from, ''), created_at, updated_at, version, result_key, result_size
 		FROM processing_results 
-		WHERE file_id = $1
+		WHERE file_id = $1 AND source = 'builtin'
 		ORDER BY created_at DESC 
 		LIMIT 1
-	`, fileID).Scan(&pr.ID, &pr.FileID, &pr.Status, &pr.Result, &pr.CreatedAt)
+	`, fileID).Scan(&pr.ID, &pr.FileID, &pr.Status, &pr.Result, &pr.Source, &pr.ReusedFrom, &pr.CreatedAt, &pr.UpdatedAt, &pr.Version, &pr.ResultKey, &pr.ResultSize)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -41,12 +147,198 @@ func GetProcessingResultByFileID(fileID string) (*ProcessingResult, error) {
 	return &pr, nil
 }
 
+// ResultSummaryLength is how many characters of a result FileStatus keeps
+const ResultSummaryLength = 200
+
+// FileStatus is a file with the outline of its latest built-in processing
+// result. Result is nil for files that haven't been processed yet.
+type FileStatus struct {
+	FileID string
+	UserID string
+	Result *ProcessingResult
+	// Truncated is set when Result.Result was cut to ResultSummaryLength or
+	// is the summary of an offloaded result
+	Truncated bool
+}
+
+// GetFileStatuses retrieves the files with the given IDs and their latest
+// built-in results in one query, keyed by file ID. Only the start of each
+// result is read. IDs that don't exist are left out.
+func GetFileStatuses(ids []string) (map[string]FileStatus, error) {
+	rows, err := GetDB().Query(`
+		SELECT f.id, COALESCE(f.user_id, ''),
+			pr.id, pr.status, LEFT(pr.result, $2), LENGTH(pr.result) > $2 OR pr.result_key <> '', pr.created_at, pr.updated_at, pr.version
+		FROM files f
+		LEFT JOIN LATERAL (
+			SELECT id, status, result, result_key, created_at, updated_at, version
+			FROM processing_results
+			WHERE file_id = f.id AND source = 'builtin'
+			ORDER BY created_at DESC
+			LIMIT 1
+		) pr ON TRUE
+		WHERE f.id = ANY($1)
+	`, pq.Array(ids), ResultSummaryLength)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]FileStatus, len(ids))
+	for rows.Next() {
+		var fs FileStatus
+		var id, status, result sql.NullString
+		var truncated sql.NullBool
+		var createdAt, updatedAt sql.NullTime
+		var version sql.NullInt64
+		if err := rows.Scan(&fs.FileID, &fs.UserID, &id, &status, &result, &truncated, &createdAt, &updatedAt, &version); err != nil {
+			return nil, err
+		}
+		if id.Valid {
+			fs.Result = &ProcessingResult{
+				ID:        id.String,
+				FileID:    fs.FileID,
+				Status:    status.String,
+				Result:    result.String,
+				Source:    ResultBuiltin,
+				CreatedAt: createdAt.Time,
+				UpdatedAt: updatedAt.Time,
+				Version:   int(version.Int64),
+			}
+			fs.Truncated = truncated.Bool
+		}
+		statuses[fs.FileID] = fs
+	}
+	return statuses, rows.Err()
+}
+
+// ListProcessingResultsByFileID retrieves a page of a file's processing history,
+// newest first, starting after the given cursor. Up to limit+1 rows are
+// returned so the caller can tell whether another page follows.
+func ListProcessingResultsByFileID(fileID string, after *pagination.Cursor, limit int) ([]ProcessingResult, error) {
+	createdAt, id := keysetBounds(after)
+	rows, err := GetDB().Query(`
+		SELECT id, file_id, status, result, source, created_at, updated_at, version, result_key, result_size
+		FROM processing_results
+		WHERE file_id = $1 AND (created_at, id) < ($2::timestamp, $3::text)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4
+	`, fileID, createdAt, id, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []ProcessingResult
+	for rows.Next() {
+		var pr ProcessingResult
+		if err := rows.Scan(&pr.ID, &pr.FileID, &pr.Status, &pr.Result, &pr.Source, &pr.CreatedAt, &pr.UpdatedAt, &pr.Version, &pr.ResultKey, &pr.ResultSize); err != nil {
+			return nil, err
+		}
+		results = append(results, pr)
+	}
+	return results, rows.Err()
+}
+
+// ListProcessingResultsByUser retrieves the full processing history of every
+// file a user owns, oldest first
+func ListProcessingResultsByUser(userID string) ([]ProcessingResult, error) {
+	rows, err := GetDB().Query(`
+		SELECT pr.id, pr.file_id, pr.status, pr.result, pr.source, pr.created_at, pr.updated_at, pr.version, pr.result_key, pr.result_size
+		FROM processing_results pr
+		JOIN files f ON f.id = pr.file_id
+		WHERE f.user_id = $1
+		ORDER BY pr.created_at, pr.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []ProcessingResult
+	for rows.Next() {
+		var pr ProcessingResult
+		if err := rows.Scan(&pr.ID, &pr.FileID, &pr.Status, &pr.Result, &pr.Source, &pr.CreatedAt, &pr.UpdatedAt, &pr.Version, &pr.ResultKey, &pr.ResultSize); err != nil {
+			return nil, err
+		}
+		results = append(results, pr)
+	}
+	return results, rows.Err()
+}
+
 // UpdateProcessingResult updates the status and result of a processing result
-func UpdateProcessingResult(fileID, status, result string) error {
-	_, err := GetDB().Exec(`
-		UPDATE processing_results 
-		SET status = $1, result = $2 
-		WHERE file_id = $3
-	`, status, result, fileID)
-	return err
+// if it is still at the given version and returns the updated row. It returns
+// ErrConflict if the result was changed in the meantime and nil if it doesn't exist.
+// The new result is kept in the row, so an offloaded one's object is queued
+// for deletion.
+func UpdateProcessingResult(id string, version int, status, result string) (*ProcessingResult, error) {
+	var pr ProcessingResult
+	err := WithTx(func(tx *sql.Tx) error {
+		var oldKey string
+		err := tx.QueryRow(`
+			SELECT result_key FROM processing_results WHERE id = $1 AND version = $2 FOR UPDATE
+		`, id, version).Scan(&oldKey)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRow(`
+			UPDATE processing_results 
+			SET status = $1, result = $2, result_key = '', result_size = 0, version = version + 1, updated_at = NOW() 
+			WHERE id = $3
+			RETURNING id, file_id, status, result, source, created_at, updated_at, version
+		`, status, result, id).Scan(&pr.ID, &pr.FileID, &pr.Status, &pr.Result, &pr.Source, &pr.CreatedAt, &pr.UpdatedAt, &pr.Version)
+		if err != nil || oldKey == "" {
+			return err
+		}
+		return queueResultObjectDeletion(tx, oldKey)
+	})
+	if err == sql.ErrNoRows {
+		return nil, versionConflict("processing_results", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+// ProcessingTime is when a file was uploaded and when one processor first
+// produced a result for it
+type ProcessingTime struct {
+	FileID string
+	// Tenant is the username of the file's owner, or its user ID if the
+	// user is gone
+	Tenant     string
+	Processor  string
+	UploadedAt time.Time
+	// ProcessedAt is invalid if the processor hasn't produced a result yet
+	ProcessedAt sql.NullTime
+}
+
+// GetProcessingTimes returns the processing times of the files uploaded in
+// [since, until), one per file and result source. Files without any result
+// are reported for the builtin processor.
+func GetProcessingTimes(since, until time.Time) ([]ProcessingTime, error) {
+	rows, err := GetDB().Query(`
+		SELECT f.id, COALESCE(u.username, f.user_id, ''), COALESCE(pr.source, 'builtin'),
+			f.created_at, MIN(pr.created_at)
+		FROM files f
+		LEFT JOIN users u ON u.id = f.user_id
+		LEFT JOIN processing_results pr ON pr.file_id = f.id
+		WHERE f.created_at >= $1 AND f.created_at < $2
+		GROUP BY f.id, u.username, f.user_id, pr.source, f.created_at
+		ORDER BY f.created_at, f.id
+	`, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var times []ProcessingTime
+	for rows.Next() {
+		var t ProcessingTime
+		if err := rows.Scan(&t.FileID, &t.Tenant, &t.Processor, &t.UploadedAt, &t.ProcessedAt); err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, rows.Err()
 }
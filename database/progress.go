@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ProcessingProgress is how far the pipeline processing a file has got
+type ProcessingProgress struct {
+	FileID    string
+	Stage     int
+	Stages    int
+	Processor string
+	Done      int64
+	Total     int64
+	Percent   float64
+	UpdatedAt time.Time
+}
+
+// SaveProcessingProgress records a file's latest progress
+func SaveProcessingProgress(p ProcessingProgress) error {
+	_, err := GetDB().Exec(`
+		INSERT INTO processing_progress (file_id, stage, stages, processor, done, total, percent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (file_id) DO UPDATE
+		SET stage = EXCLUDED.stage, stages = EXCLUDED.stages, processor = EXCLUDED.processor,
+			done = EXCLUDED.done, total = EXCLUDED.total, percent = EXCLUDED.percent, updated_at = NOW()
+	`, p.FileID, p.Stage, p.Stages, p.Processor, p.Done, p.Total, p.Percent)
+	return err
+}
+
+// DeleteProcessingProgressTx forgets a file's progress once its result is
+// saved
+func DeleteProcessingProgressTx(tx *sql.Tx, fileID string) error {
+	_, err := tx.Exec(`DELETE FROM processing_progress WHERE file_id = $1`, fileID)
+	return err
+}
+
+// GetProcessingProgress returns the progress of those of the given files
+// being processed, keyed by file ID
+func GetProcessingProgress(ids []string) (map[string]ProcessingProgress, error) {
+	rows, err := GetDB().Query(`
+		SELECT file_id, stage, stages, processor, done, total, percent, updated_at
+		FROM processing_progress
+		WHERE file_id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	progress := make(map[string]ProcessingProgress)
+	for rows.Next() {
+		var p ProcessingProgress
+		if err := rows.Scan(&p.FileID, &p.Stage, &p.Stages, &p.Processor, &p.Done, &p.Total, &p.Percent, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		progress[p.FileID] = p
+	}
+	return progress, rows.Err()
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// StatusQuarantined is the status of a result a gate withheld from
+// publication until an administrator reviews it. The row holds a notice
+// instead of the result, which is kept in the quarantine.
+const StatusQuarantined = "quarantined"
+
+// Quarantine review statuses
+const (
+	QuarantinePending  = "pending"
+	QuarantineApproved = "approved"
+	QuarantineRejected = "rejected"
+)
+
+// ErrAlreadyReviewed is returned when reviewing a quarantined result that
+// was already approved or rejected
+var ErrAlreadyReviewed = errors.New("quarantined result was already reviewed")
+
+// Quarantine is a result withheld by a gate
+type Quarantine struct {
+	ID       string
+	ResultID string
+	FileID   string
+	FileName string
+	UserID   string
+	// Gate is the gate that withheld the result and Reason why
+	Gate   string
+	Reason string
+	// Result is the withheld result
+	Result     string
+	Status     string
+	ReviewedBy string
+	Note       string
+	CreatedAt  time.Time
+	ReviewedAt *time.Time
+}
+
+const quarantineColumns = `q.id, q.result_id, q.file_id, f.name, COALESCE(f.user_id, ''), q.gate, q.reason, q.result, q.status, q.reviewed_by, q.note, q.created_at, q.reviewed_at`
+
+func scanQuarantine(row rowScanner, q *Quarantine) error {
+	return row.Scan(&q.ID, &q.ResultID, &q.FileID, &q.FileName, &q.UserID, &q.Gate, &q.Reason, &q.Result, &q.Status, &q.ReviewedBy, &q.Note, &q.CreatedAt, &q.ReviewedAt)
+}
+
+// QuarantineNotice is what a quarantined result's row holds in place of the
+// result
+func QuarantineNotice(gate, reason string) string {
+	return fmt.Sprintf("Result withheld for review by the %s gate: %s", gate, reason)
+}
+
+// QuarantineResultTx saves a file's result as quarantined: the result row
+// holds a notice and the result itself waits for review
+func QuarantineResultTx(tx *sql.Tx, fileID, result, gate, reason string) error {
+	resultID := NewID()
+	if err := insertProcessingResult(tx, resultID, fileID, StatusQuarantined, QuarantineNotice(gate, reason), ResultBuiltin, "", 0); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO result_quarantines (id, result_id, file_id, gate, reason, result)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, NewID(), resultID, fileID, gate, reason, result)
+	return err
+}
+
+// GetQuarantine retrieves a quarantined result, or nil if there is none
+// with that ID
+func GetQuarantine(id string) (*Quarantine, error) {
+	var q Quarantine
+	err := scanQuarantine(GetDB().QueryRow(`
+		SELECT `+quarantineColumns+`
+		FROM result_quarantines q
+		JOIN files f ON f.id = q.file_id
+		WHERE q.id = $1
+	`, id), &q)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// ListQuarantines returns up to limit quarantined results with the review
+// status, or of any status if it is empty, oldest first
+func ListQuarantines(status string, limit int) ([]Quarantine, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+quarantineColumns+`
+		FROM result_quarantines q
+		JOIN files f ON f.id = q.file_id
+		WHERE $1 = '' OR q.status = $1
+		ORDER BY q.created_at, q.id
+		LIMIT $2
+	`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quarantines []Quarantine
+	for rows.Next() {
+		var q Quarantine
+		if err := scanQuarantine(rows, &q); err != nil {
+			return nil, err
+		}
+		quarantines = append(quarantines, q)
+	}
+	return quarantines, rows.Err()
+}
+
+// ReviewQuarantine approves or rejects a pending quarantined result. An
+// approved result replaces the notice in its row and becomes completed; a
+// rejected one is discarded and its row marked failed. It returns the
+// reviewed quarantine, nil if there is none with that ID, or
+// ErrAlreadyReviewed.
+func ReviewQuarantine(id, reviewerID, note string, approve bool) (*Quarantine, error) {
+	err := WithTx(func(tx *sql.Tx) error {
+		var resultID, fileID, gate, reason, status string
+		err := tx.QueryRow(`
+			SELECT result_id, file_id, gate, reason, status FROM result_quarantines WHERE id = $1 FOR UPDATE
+		`, id).Scan(&resultID, &fileID, &gate, &reason, &status)
+		if err != nil {
+			return err
+		}
+		if status != QuarantinePending {
+			return ErrAlreadyReviewed
+		}
+
+		review, resultStatus := QuarantineRejected, "failed"
+		result := `'Result rejected in review: ' || q.reason`
+		if approve {
+			review, resultStatus, result = QuarantineApproved, "completed", "q.result"
+		}
+		_, err = tx.Exec(`
+			UPDATE processing_results pr
+			SET status = $1, result = `+result+`, updated_at = NOW(), version = pr.version + 1
+			FROM result_quarantines q
+			WHERE q.id = $2 AND pr.id = q.result_id
+		`, resultStatus, id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE result_quarantines
+			SET status = $1, reviewed_by = $2, note = $3, reviewed_at = NOW(),
+				result = CASE WHEN $1 = $4 THEN '' ELSE result END
+			WHERE id = $5
+		`, review, reviewerID, note, QuarantineRejected, id)
+		if err != nil {
+			return err
+		}
+		return recordEvent(tx, EventFileProcessed, fileID, reviewerID, map[string]string{"status": resultStatus, "review": review, "gate": gate})
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return GetQuarantine(id)
+}
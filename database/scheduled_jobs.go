@@ -0,0 +1,160 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Scheduled job statuses
+const (
+	ScheduledJobPending   = "pending"
+	ScheduledJobEnqueued  = "enqueued"
+	ScheduledJobCompleted = "completed"
+	ScheduledJobCancelled = "cancelled"
+)
+
+type ScheduledJob struct {
+	ID        string
+	FileID    string
+	S3Key     string
+	RunAt     time.Time
+	Status    string
+	CreatedAt time.Time
+}
+
+// SaveScheduledJob saves a new scheduled processing job for a file
+func SaveScheduledJob(fileID, s3Key string, runAt time.Time, status string) (*ScheduledJob, error) {
+	return saveScheduledJob(GetDB(), fileID, s3Key, runAt, status)
+}
+
+// SaveScheduledJobTx is SaveScheduledJob run inside a transaction
+func SaveScheduledJobTx(tx *sql.Tx, fileID, s3Key string, runAt time.Time, status string) (*ScheduledJob, error) {
+	return saveScheduledJob(tx, fileID, s3Key, runAt, status)
+}
+
+func saveScheduledJob(q querier, fileID, s3Key string, runAt time.Time, status string) (*ScheduledJob, error) {
+	var job ScheduledJob
+	err := q.QueryRow(`
+		INSERT INTO scheduled_jobs (id, file_id, s3_key, run_at, status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, file_id, s3_key, run_at, status, created_at
+	`, NewID(), fileID, s3Key, runAt, status).Scan(&job.ID, &job.FileID, &job.S3Key, &job.RunAt, &job.Status, &job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetScheduledJobByID retrieves a scheduled job by its ID
+func GetScheduledJobByID(id string) (*ScheduledJob, error) {
+	var job ScheduledJob
+	err := GetDB().QueryRow(`
+		SELECT id, file_id, s3_key, run_at, status, created_at
+		FROM scheduled_jobs
+		WHERE id = $1
+	`, id).Scan(&job.ID, &job.FileID, &job.S3Key, &job.RunAt, &job.Status, &job.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetActiveScheduledJobByFileID retrieves the pending or enqueued job for a file, if any
+func GetActiveScheduledJobByFileID(fileID string) (*ScheduledJob, error) {
+	var job ScheduledJob
+	err := GetDB().QueryRow(`
+		SELECT id, file_id, s3_key, run_at, status, created_at
+		FROM scheduled_jobs
+		WHERE file_id = $1 AND status IN ($2, $3)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, fileID, ScheduledJobPending, ScheduledJobEnqueued).Scan(&job.ID, &job.FileID, &job.S3Key, &job.RunAt, &job.Status, &job.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ClaimDueScheduledJobs marks up to limit pending jobs that are due as enqueued
+// and returns them. Jobs of files whose dependencies haven't all completed
+// are held back, and rows locked by another instance are skipped.
+func ClaimDueScheduledJobs(now time.Time, limit int) ([]ScheduledJob, error) {
+	rows, err := GetDB().Query(`
+		UPDATE scheduled_jobs
+		SET status = $1
+		WHERE id IN (
+			SELECT j.id FROM scheduled_jobs j
+			WHERE j.status = $2 AND j.run_at <= $3
+				AND NOT EXISTS (
+					SELECT 1 FROM file_dependencies d
+					WHERE d.file_id = j.file_id AND `+latestStatus("d.depends_on")+` IS DISTINCT FROM 'completed'
+				)
+			ORDER BY j.run_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, file_id, s3_key, run_at, status, created_at
+	`, ScheduledJobEnqueued, ScheduledJobPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []ScheduledJob
+	for rows.Next() {
+		var job ScheduledJob
+		if err := rows.Scan(&job.ID, &job.FileID, &job.S3Key, &job.RunAt, &job.Status, &job.CreatedAt); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// UpdateScheduledJobStatus sets the status of a scheduled job
+func UpdateScheduledJobStatus(id, status string) error {
+	return updateScheduledJobStatus(GetDB(), id, status)
+}
+
+// UpdateScheduledJobStatusTx is UpdateScheduledJobStatus run inside a transaction
+func UpdateScheduledJobStatusTx(tx *sql.Tx, id, status string) error {
+	return updateScheduledJobStatus(tx, id, status)
+}
+
+func updateScheduledJobStatus(q querier, id, status string) error {
+	_, err := q.Exec(`
+		UPDATE scheduled_jobs
+		SET status = $1
+		WHERE id = $2
+	`, status, id)
+	return err
+}
+
+// CancelScheduledJobs cancels all pending or enqueued jobs for a file and
+// returns the number of jobs cancelled
+func CancelScheduledJobs(fileID string) (int64, error) {
+	return cancelScheduledJobs(GetDB(), fileID)
+}
+
+// CancelScheduledJobsTx is CancelScheduledJobs run inside a transaction
+func CancelScheduledJobsTx(tx *sql.Tx, fileID string) (int64, error) {
+	return cancelScheduledJobs(tx, fileID)
+}
+
+func cancelScheduledJobs(q querier, fileID string) (int64, error) {
+	res, err := q.Exec(`
+		UPDATE scheduled_jobs
+		SET status = $1
+		WHERE file_id = $2 AND status IN ($3, $4)
+	`, ScheduledJobCancelled, fileID, ScheduledJobPending, ScheduledJobEnqueued)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,297 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/yourusername/golang-aws-api/cron"
+)
+
+// Schedule re-runs processing of a user's files on a cron recurrence. It
+// targets one file when FileID is set, otherwise every file of the user whose
+// name starts with Prefix.
+type Schedule struct {
+	ID     string
+	UserID string
+	Name   string
+	Cron   string
+	FileID string
+	Prefix string
+	// Processor is run instead of each file's pipeline when set
+	Processor string
+	Enabled   bool
+	NextRunAt time.Time
+	LastRunAt *time.Time
+	CreatedAt time.Time
+}
+
+// ScheduleRun is one firing of a schedule
+type ScheduleRun struct {
+	ID         string
+	ScheduleID string
+	StartedAt  time.Time
+	// Files counts the files queued and Failed those whose message couldn't
+	// be sent
+	Files  int
+	Failed int
+	Error  string
+	// Statuses counts the run's files by the status of the first result
+	// saved for them since the run started; "pending" counts files without
+	// one yet
+	Statuses map[string]int
+}
+
+// StartedScheduleRun is a run that has just been started, with the files it
+// is to process
+type StartedScheduleRun struct {
+	Run      ScheduleRun
+	Schedule Schedule
+	Files    []File
+}
+
+const scheduleColumns = `id, user_id, name, cron, COALESCE(file_id, ''), prefix, processor, enabled, next_run_at, last_run_at, created_at`
+
+func scanSchedule(row rowScanner, s *Schedule) error {
+	return row.Scan(&s.ID, &s.UserID, &s.Name, &s.Cron, &s.FileID, &s.Prefix, &s.Processor, &s.Enabled, &s.NextRunAt, &s.LastRunAt, &s.CreatedAt)
+}
+
+// SaveSchedule saves a new schedule, first running at s.NextRunAt
+func SaveSchedule(s Schedule) (*Schedule, error) {
+	var saved Schedule
+	err := scanSchedule(GetDB().QueryRow(`
+		INSERT INTO schedules (id, user_id, name, cron, file_id, prefix, processor, next_run_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8)
+		RETURNING `+scheduleColumns+`
+	`, NewID(), s.UserID, s.Name, s.Cron, s.FileID, s.Prefix, s.Processor, s.NextRunAt), &saved)
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// GetSchedule retrieves one of a user's schedules, or nil if they have no
+// schedule with that ID
+func GetSchedule(id, userID string) (*Schedule, error) {
+	var s Schedule
+	err := scanSchedule(GetDB().QueryRow(`
+		SELECT `+scheduleColumns+`
+		FROM schedules
+		WHERE id = $1 AND user_id = $2
+	`, id, userID), &s)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ListSchedules returns a user's schedules, oldest first
+func ListSchedules(userID string) ([]Schedule, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+scheduleColumns+`
+		FROM schedules
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []Schedule
+	for rows.Next() {
+		var s Schedule
+		if err := scanSchedule(rows, &s); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+// CountSchedules returns how many schedules a user has
+func CountSchedules(userID string) (int, error) {
+	var n int
+	err := GetDB().QueryRow(`SELECT COUNT(*) FROM schedules WHERE user_id = $1`, userID).Scan(&n)
+	return n, err
+}
+
+// DeleteSchedule removes one of a user's schedules and its run history,
+// reporting whether it existed
+func DeleteSchedule(id, userID string) (bool, error) {
+	res, err := GetDB().Exec(`DELETE FROM schedules WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// StartDueScheduleRuns starts a run of up to limit enabled schedules that are
+// due, moving each on to its next firing, and returns the runs with up to
+// maxFiles files each to process. Schedules locked by another instance are
+// skipped, and one whose expression no longer fires is disabled.
+func StartDueScheduleRuns(now time.Time, limit, maxFiles int) ([]StartedScheduleRun, error) {
+	var started []StartedScheduleRun
+	err := WithTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`
+			SELECT `+scheduleColumns+`
+			FROM schedules
+			WHERE enabled AND next_run_at <= $1
+			ORDER BY next_run_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		`, now, limit)
+		if err != nil {
+			return err
+		}
+		var due []Schedule
+		for rows.Next() {
+			var s Schedule
+			if err := scanSchedule(rows, &s); err != nil {
+				rows.Close()
+				return err
+			}
+			due = append(due, s)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, s := range due {
+			run, err := startScheduleRun(tx, s, now, maxFiles)
+			if err != nil {
+				return err
+			}
+			started = append(started, *run)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return started, nil
+}
+
+func startScheduleRun(tx *sql.Tx, s Schedule, now time.Time, maxFiles int) (*StartedScheduleRun, error) {
+	next := now
+	enabled := false
+	if expr, err := cron.Parse(s.Cron); err == nil {
+		next = expr.Next(now)
+		enabled = !next.IsZero()
+	}
+	if !enabled {
+		next = now
+	}
+	_, err := tx.Exec(`
+		UPDATE schedules SET next_run_at = $1, last_run_at = $2, enabled = $3
+		WHERE id = $4
+	`, next, now, enabled, s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	run := StartedScheduleRun{Schedule: s}
+	err = tx.QueryRow(`
+		INSERT INTO schedule_runs (id, schedule_id, started_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, schedule_id, started_at
+	`, NewID(), s.ID, now).Scan(&run.Run.ID, &run.Run.ScheduleID, &run.Run.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE user_id = $1 AND ($2 = '' OR id = $2) AND left(name, length($3)) = $3
+		ORDER BY created_at, id
+		LIMIT $4
+	`, s.UserID, s.FileID, s.Prefix, maxFiles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return nil, err
+		}
+		run.Files = append(run.Files, f)
+		ids = append(ids, f.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(`
+		INSERT INTO schedule_run_files (run_id, file_id)
+		SELECT $1, unnest($2::text[])
+	`, run.Run.ID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE schedule_runs SET files = $1 WHERE id = $2`, len(ids), run.Run.ID); err != nil {
+		return nil, err
+	}
+	run.Run.Files = len(ids)
+	return &run, nil
+}
+
+// FinishScheduleRun records how many of a run's files couldn't be queued,
+// and why
+func FinishScheduleRun(runID string, failed int, errMsg string) error {
+	_, err := GetDB().Exec(`UPDATE schedule_runs SET failed = $1, error = $2 WHERE id = $3`, failed, errMsg, runID)
+	return err
+}
+
+// ListScheduleRuns returns up to limit of a schedule's most recent runs,
+// newest first, with their files counted by result status
+func ListScheduleRuns(scheduleID string, limit int) ([]ScheduleRun, error) {
+	rows, err := GetDB().Query(`
+		SELECT r.id, r.schedule_id, r.started_at, r.files, r.failed, r.error, COALESCE(p.status, 'pending'), COUNT(rf.file_id)
+		FROM (
+			SELECT * FROM schedule_runs
+			WHERE schedule_id = $1
+			ORDER BY started_at DESC, id
+			LIMIT $2
+		) r
+		LEFT JOIN schedule_run_files rf ON rf.run_id = r.id
+		LEFT JOIN LATERAL (
+			SELECT status FROM processing_results
+			WHERE file_id = rf.file_id AND source = 'builtin' AND created_at >= r.started_at
+			ORDER BY created_at
+			LIMIT 1
+		) p ON TRUE
+		GROUP BY r.id, r.schedule_id, r.started_at, r.files, r.failed, r.error, p.status
+		ORDER BY r.started_at DESC, r.id
+	`, scheduleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []ScheduleRun
+	for rows.Next() {
+		var run ScheduleRun
+		var status string
+		var n int
+		if err := rows.Scan(&run.ID, &run.ScheduleID, &run.StartedAt, &run.Files, &run.Failed, &run.Error, &status, &n); err != nil {
+			return nil, err
+		}
+		if len(runs) == 0 || runs[len(runs)-1].ID != run.ID {
+			run.Statuses = map[string]int{}
+			runs = append(runs, run)
+		}
+		if n > 0 {
+			runs[len(runs)-1].Statuses[status] = n
+		}
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// FileSearchResult is a file matching a search query
+type FileSearchResult struct {
+	File
+	Rank float64
+	// NameHighlight and ContentHighlight are fragments of plain text with
+	// matches between HighlightStart and HighlightStop
+	NameHighlight    string
+	ContentHighlight string
+}
+
+// Markers around the matches in highlights. They are control characters
+// rather than HTML so that whoever renders a fragment escapes it first.
+const (
+	HighlightStart = "\x02"
+	HighlightStop  = "\x03"
+)
+
+// headlineOptions are the ts_headline options marking matches
+const headlineOptions = "StartSel=" + HighlightStart + ", StopSel=" + HighlightStop
+
+// SetFileSearchText stores the text extracted from a file so its content can be searched
+func SetFileSearchText(fileID, text string) error {
+	_, err := GetDB().Exec(`UPDATE files SET search_text = $1 WHERE id = $2`, text, fileID)
+	return err
+}
+
+// SearchFiles runs a full-text search over a user's file names and extracted
+// text. The query uses web search syntax ("quoted phrases", -exclusions, or).
+// Results are ordered by rank, then newest first, starting after the given
+// cursor; up to limit+1 rows are returned so the caller can tell whether
+// another page follows.
+func SearchFiles(userID, query string, after *pagination.Cursor, limit int) ([]FileSearchResult, error) {
+	createdAt, id := keysetBounds(after)
+	var rank interface{} = "infinity"
+	if after != nil {
+		rank = after.Rank
+	}
+
+	// Highlights are only computed for the rows on the page
+	rows, err := GetDB().Query(`
+		WITH q AS (
+			SELECT websearch_to_tsquery('english', $2) AS query
+		), page AS (
+			SELECT * FROM (
+				SELECT f.id AS match_id, f.created_at AS match_created_at,
+					ts_rank(f.search_vector, q.query) AS rank
+				FROM files f, q
+				WHERE f.user_id = $1 AND f.search_vector @@ q.query
+			) m
+			WHERE (rank, match_created_at, match_id) < ($3::real, $4::timestamp, $5::text)
+			ORDER BY rank DESC, match_created_at DESC, match_id DESC
+			LIMIT $6
+		)
+		SELECT `+fileColumns+`, page.rank,
+			ts_headline('english', name, q.query, $7::text),
+			ts_headline('english', search_text, q.query, $7::text || ', MaxFragments=2')
+		FROM page
+		JOIN files ON files.id = page.match_id, q
+		ORDER BY page.rank DESC, page.match_created_at DESC, page.match_id DESC
+	`, userID, query, rank, createdAt, id, limit+1, headlineOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []FileSearchResult
+	for rows.Next() {
+		var r FileSearchResult
+		if err := scanFile(rows, &r.File, &r.Rank, &r.NameHighlight, &r.ContentHighlight); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+type Share struct {
+	ID     string
+	FileID string
+	UserID string
+	// Token is only set on a newly created share; the database keeps its hash
+	Token        string
+	ExpiresAt    time.Time
+	MaxDownloads sql.NullInt64
+	Downloads    int
+	PasswordHash string
+	RevokedAt    sql.NullTime
+	CreatedAt    time.Time
+}
+
+// shareColumns is the column list read by scanShare
+const shareColumns = `id, file_id, user_id, expires_at, max_downloads, downloads, password_hash, revoked_at, created_at`
+
+func scanShare(row rowScanner, s *Share) error {
+	return row.Scan(&s.ID, &s.FileID, &s.UserID, &s.ExpiresAt, &s.MaxDownloads, &s.Downloads, &s.PasswordHash, &s.RevokedAt, &s.CreatedAt)
+}
+
+// hashToken returns the stored form of a share, upload or access token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Active reports whether the share can still be used to download its file
+func (s *Share) Active(now time.Time) bool {
+	if s.RevokedAt.Valid || !now.Before(s.ExpiresAt) {
+		return false
+	}
+	return !s.MaxDownloads.Valid || int64(s.Downloads) < s.MaxDownloads.Int64
+}
+
+// SaveShare creates a share link for a file. maxDownloads of 0 means unlimited
+// and an empty passwordHash means no password is required.
+func SaveShare(token, fileID, userID string, expiresAt time.Time, maxDownloads int, passwordHash string) (*Share, error) {
+	var s Share
+	err := WithTx(func(tx *sql.Tx) error {
+		err := scanShare(tx.QueryRow(`
+			INSERT INTO shares (id, token_hash, file_id, user_id, expires_at, max_downloads, password_hash)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7)
+			RETURNING `+shareColumns+`
+		`, NewID(), hashToken(token), fileID, userID, expiresAt, maxDownloads, passwordHash), &s)
+		if err != nil {
+			return err
+		}
+		return RecordEventTx(tx, EventFileShared, fileID, userID, map[string]interface{}{
+			"share_id":      s.ID,
+			"expires_at":    s.ExpiresAt,
+			"max_downloads": maxDownloads,
+			"password":      passwordHash != "",
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.Token = token
+	return &s, nil
+}
+
+// GetShareByToken retrieves a share by its token, whether or not it is still active
+func GetShareByToken(token string) (*Share, error) {
+	var s Share
+	err := scanShare(GetDB().QueryRow(`
+		SELECT `+shareColumns+`
+		FROM shares
+		WHERE token_hash = $1
+	`, hashToken(token)), &s)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ConsumeShareDownload counts a download against a share. It returns false
+// without counting if the share has expired, been revoked or used up, which
+// also guards against concurrent downloads racing past max_downloads.
+func ConsumeShareDownload(id string) (bool, error) {
+	res, err := GetDB().Exec(`
+		UPDATE shares
+		SET downloads = downloads + 1
+		WHERE id = $1
+			AND revoked_at IS NULL
+			AND expires_at > NOW()
+			AND (max_downloads IS NULL OR downloads < max_downloads)
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ListSharesByUser retrieves a page of the shares a user created, newest
+// first, starting after the given cursor. Up to limit+1 rows are returned so
+// the caller can tell whether another page follows.
+func ListSharesByUser(userID string, after *pagination.Cursor, limit int) ([]Share, error) {
+	createdAt, id := keysetBounds(after)
+	rows, err := GetDB().Query(`
+		SELECT `+shareColumns+`
+		FROM shares
+		WHERE user_id = $1 AND (created_at, id) < ($2::timestamp, $3::text)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4
+	`, userID, createdAt, id, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []Share
+	for rows.Next() {
+		var s Share
+		if err := scanShare(rows, &s); err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
+}
+
+// RevokeShare revokes one of a user's shares. It returns false if the user has
+// no such share or it was already revoked.
+func RevokeShare(id, userID string) (bool, error) {
+	res, err := GetDB().Exec(`
+		UPDATE shares
+		SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
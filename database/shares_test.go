@@ -0,0 +1,30 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShareActive(t *testing.T) {
+	now := time.Now()
+	share := Share{ExpiresAt: now.Add(time.Hour)}
+	assert.True(t, share.Active(now))
+
+	expired := share
+	expired.ExpiresAt = now
+	assert.False(t, expired.Active(now))
+
+	revoked := share
+	revoked.RevokedAt = sql.NullTime{Time: now, Valid: true}
+	assert.False(t, revoked.Active(now))
+
+	limited := share
+	limited.MaxDownloads = sql.NullInt64{Int64: 2, Valid: true}
+	limited.Downloads = 1
+	assert.True(t, limited.Active(now))
+	limited.Downloads = 2
+	assert.False(t, limited.Active(now))
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SigningKey is an RSA key the API signs tokens with when it keeps its own
+// keys rather than using KMS
+type SigningKey struct {
+	// ID is the key's kid
+	ID string
+	// PrivateKey is the PKCS #8 PEM encoded key, sealed at rest with the
+	// column keys
+	PrivateKey string
+	CreatedAt  time.Time
+	// RetiredAt is set once a newer key took over. Tokens the key signed
+	// verify until they expire.
+	RetiredAt *time.Time
+}
+
+const signingKeyColumns = `id, private_key, created_at, retired_at`
+
+func scanSigningKey(row rowScanner, k *SigningKey) error {
+	return row.Scan(&k.ID, openColumn(columnSigningKey, &k.PrivateKey), &k.CreatedAt, &k.RetiredAt)
+}
+
+// ActiveSigningKey returns the key new tokens are signed with, or nil if
+// there is none yet
+func ActiveSigningKey() (*SigningKey, error) {
+	var k SigningKey
+	err := scanSigningKey(GetDB().QueryRow(`
+		SELECT `+signingKeyColumns+`
+		FROM jwt_signing_keys
+		WHERE retired_at IS NULL
+	`), &k)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// ListSigningKeys returns the active key and those retired after since,
+// newest first
+func ListSigningKeys(since time.Time) ([]SigningKey, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+signingKeyColumns+`
+		FROM jwt_signing_keys
+		WHERE retired_at IS NULL OR retired_at > $1
+		ORDER BY created_at DESC, id
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []SigningKey
+	for rows.Next() {
+		var k SigningKey
+		if err := scanSigningKey(rows, &k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RotateSigningKey makes privateKey, with the given ID, the active signing
+// key, retiring the one before it. With olderThan set, the active key is
+// only replaced if it was created before then, and nothing happens
+// otherwise, so processes rotating on a schedule don't each rotate. It
+// reports whether the key was stored.
+func RotateSigningKey(id, privateKey string, olderThan *time.Time) (bool, error) {
+	var stored bool
+	err := WithTx(func(tx *sql.Tx) error {
+		var activeID string
+		var createdAt time.Time
+		err := tx.QueryRow(`
+			SELECT id, created_at FROM jwt_signing_keys WHERE retired_at IS NULL FOR UPDATE
+		`).Scan(&activeID, &createdAt)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil {
+			if olderThan != nil && !createdAt.Before(*olderThan) {
+				return nil
+			}
+			if _, err := tx.Exec(`UPDATE jwt_signing_keys SET retired_at = NOW() WHERE id = $1`, activeID); err != nil {
+				return err
+			}
+		}
+		res, err := tx.Exec(`
+			INSERT INTO jwt_signing_keys (id, private_key)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, id, sealColumn(columnSigningKey, privateKey))
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		stored = n > 0
+		return nil
+	})
+	return stored, err
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrDuplicateSSHKey is returned when a public key is already registered
+var ErrDuplicateSSHKey = errors.New("this SSH key is already registered")
+
+// SSHKey is a public key a user can sign in to the SFTP gateway with
+type SSHKey struct {
+	ID          string
+	UserID      string
+	Name        string
+	Fingerprint string
+	PublicKey   string
+	CreatedAt   time.Time
+}
+
+const sshKeyColumns = `id, user_id, name, fingerprint, public_key, created_at`
+
+func scanSSHKey(row rowScanner, k *SSHKey) error {
+	return row.Scan(&k.ID, &k.UserID, &k.Name, &k.Fingerprint, &k.PublicKey, &k.CreatedAt)
+}
+
+// SaveSSHKey registers a public key for a user
+func SaveSSHKey(userID, name, fingerprint, publicKey string) (*SSHKey, error) {
+	var k SSHKey
+	err := scanSSHKey(GetDB().QueryRow(`
+		INSERT INTO ssh_keys (id, user_id, name, fingerprint, public_key)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+sshKeyColumns+`
+	`, NewID(), userID, name, fingerprint, publicKey), &k)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrDuplicateSSHKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// ListSSHKeys returns a user's keys, oldest first
+func ListSSHKeys(userID string) ([]SSHKey, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+sshKeyColumns+`
+		FROM ssh_keys
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []SSHKey
+	for rows.Next() {
+		var k SSHKey
+		if err := scanSSHKey(rows, &k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteSSHKey removes one of a user's keys, reporting whether it existed
+func DeleteSSHKey(id, userID string) (bool, error) {
+	res, err := GetDB().Exec(`DELETE FROM ssh_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetUserBySSHKey retrieves the user a key fingerprint is registered to
+func GetUserBySSHKey(fingerprint string) (*User, error) {
+	var user User
+	err := GetDB().QueryRow(`
+		SELECT u.id, u.username, u.password, u.email, u.confirmed, u.created_at
+		FROM ssh_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.fingerprint = $1
+	`, fingerprint).Scan(&user.ID, &user.Username, &user.Password, openColumn(columnUserEmail, &user.Email), &user.Confirmed, &user.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
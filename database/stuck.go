@@ -0,0 +1,127 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AttemptRequeued marks a stuck file whose processing message was sent again
+const AttemptRequeued = "requeued"
+
+// StuckFile is a file still waiting for its first result long after it
+// was uploaded or last attempted
+type StuckFile struct {
+	File
+	Attempts  int
+	LastError string
+	// LastActivity is when the file was uploaded, last attempted or due to
+	// run, whichever is latest
+	LastActivity time.Time
+	// JobID is the enqueued scheduled job the file was waiting on, if any
+	JobID string
+}
+
+// stuckFilesQuery selects files without a built-in result and with no
+// activity since $1 that also match cond, with lock applied to the files
+// table. Files held back by a pending scheduled job, including ones waiting
+// on dependencies, or whose processing was cancelled aren't stuck.
+func stuckFilesQuery(cond, lock string) string {
+	return `
+		SELECT ` + fileColumns + `, attempts, last_error, last_activity, job_id
+		FROM (
+			SELECT f.*, COALESCE(a.attempts, 0) AS attempts, COALESCE(a.last_error, '') AS last_error,
+				GREATEST(f.created_at, a.updated_at, j.run_at) AS last_activity, COALESCE(j.id, '') AS job_id
+			FROM files f
+			LEFT JOIN processing_attempts a ON a.file_id = f.id
+			LEFT JOIN LATERAL (
+				SELECT id, status, run_at FROM scheduled_jobs
+				WHERE file_id = f.id
+				ORDER BY created_at DESC
+				LIMIT 1
+			) j ON TRUE
+			WHERE NOT EXISTS (SELECT 1 FROM processing_results p WHERE p.file_id = f.id AND p.source = 'builtin')
+				AND (j.id IS NULL OR j.status = '` + ScheduledJobEnqueued + `')
+				AND GREATEST(f.created_at, a.updated_at, j.run_at) < $1
+				AND ` + cond + `
+			` + lock + `
+		) stuck
+	`
+}
+
+func scanStuckFile(row rowScanner, s *StuckFile) error {
+	return scanFile(row, &s.File, &s.Attempts, &s.LastError, &s.LastActivity, &s.JobID)
+}
+
+// ListStuckFiles returns up to limit files that have been stuck since before
+// olderThan, longest stuck first
+func ListStuckFiles(olderThan time.Time, limit int) ([]StuckFile, error) {
+	rows, err := GetDB().Query(stuckFilesQuery("TRUE", "")+`
+		ORDER BY last_activity, id
+		LIMIT $2
+	`, olderThan, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stuck []StuckFile
+	for rows.Next() {
+		var s StuckFile
+		if err := scanStuckFile(rows, &s); err != nil {
+			return nil, err
+		}
+		stuck = append(stuck, s)
+	}
+	return stuck, rows.Err()
+}
+
+// ClaimStuckFiles resets the attempts of those of ids that are still stuck
+// since before olderThan and marks them requeued, so they aren't claimed
+// again until they have been stuck that long once more. It returns the files
+// claimed; the caller sends their processing messages. Files locked by
+// another claim are skipped.
+func ClaimStuckFiles(ids []string, olderThan time.Time) ([]StuckFile, error) {
+	var claimed []StuckFile
+	err := WithTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(stuckFilesQuery("f.id = ANY($2)", "FOR UPDATE OF f SKIP LOCKED")+`
+			ORDER BY created_at, id
+		`, olderThan, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var s StuckFile
+			if err := scanStuckFile(rows, &s); err != nil {
+				rows.Close()
+				return err
+			}
+			claimed = append(claimed, s)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, s := range claimed {
+			_, err := tx.Exec(`
+				INSERT INTO processing_attempts (file_id, attempts, status, last_error)
+				VALUES ($1, 0, $2, '')
+				ON CONFLICT (file_id) DO UPDATE
+				SET attempts = 0, status = EXCLUDED.status, last_error = '', updated_at = NOW()
+			`, s.ID, AttemptRequeued)
+			if err != nil {
+				return err
+			}
+			if err := recordEvent(tx, EventFileRequeued, s.ID, "", map[string]interface{}{"attempts": s.Attempts, "last_activity": s.LastActivity}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TenantSettings override the runtime settings for one user. A nil field
+// keeps the global setting; a zero limit means no limit, as it does there.
+type TenantSettings struct {
+	UserID       string
+	MaxFileBytes *int64
+	MaxFiles     *int
+	MaxBytes     *int64
+	// AllowedFileTypes are the extensions, like ".csv", the user may upload
+	AllowedFileTypes []string
+	// FileExpiryDays is how long the user's files are kept; 0 keeps them
+	// forever
+	FileExpiryDays *int
+	// EnabledProcessors are the only processors the user's files may run
+	// through. Processors disabled globally stay disabled.
+	EnabledProcessors []string
+	// UploadBytesPerSecond and DownloadBytesPerSecond cap the user's
+	// bandwidth across all of their requests
+	UploadBytesPerSecond   *int64
+	DownloadBytesPerSecond *int64
+	UpdatedBy              string
+	UpdatedAt              time.Time
+}
+
+// tenantSettingsColumns is the column list read by scanTenantSettings
+const tenantSettingsColumns = `user_id, max_file_bytes, max_files, max_bytes, allowed_file_types, file_expiry_days, enabled_processors, upload_bytes_per_second, download_bytes_per_second, updated_by, updated_at`
+
+func scanTenantSettings(row rowScanner, t *TenantSettings) error {
+	var maxFileBytes, maxFiles, maxBytes, expiryDays, uploadRate, downloadRate sql.NullInt64
+	var types, processors pq.StringArray
+	err := row.Scan(&t.UserID, &maxFileBytes, &maxFiles, &maxBytes, &types, &expiryDays, &processors, &uploadRate, &downloadRate, &t.UpdatedBy, &t.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	t.MaxFileBytes = nullInt64(maxFileBytes)
+	t.MaxBytes = nullInt64(maxBytes)
+	t.UploadBytesPerSecond = nullInt64(uploadRate)
+	t.DownloadBytesPerSecond = nullInt64(downloadRate)
+	if maxFiles.Valid {
+		n := int(maxFiles.Int64)
+		t.MaxFiles = &n
+	}
+	if expiryDays.Valid {
+		n := int(expiryDays.Int64)
+		t.FileExpiryDays = &n
+	}
+	t.AllowedFileTypes = types
+	t.EnabledProcessors = processors
+	return nil
+}
+
+func nullInt64(n sql.NullInt64) *int64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int64
+}
+
+// GetTenantSettings returns a user's overrides, or nil if there are none
+func GetTenantSettings(userID string) (*TenantSettings, error) {
+	var t TenantSettings
+	err := scanTenantSettings(GetDB().QueryRow(`
+		SELECT `+tenantSettingsColumns+`
+		FROM tenant_settings
+		WHERE user_id = $1
+	`, userID), &t)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListTenantSettings returns every user's overrides
+func ListTenantSettings() ([]TenantSettings, error) {
+	rows, err := GetDB().Query(`
+		SELECT ` + tenantSettingsColumns + `
+		FROM tenant_settings
+		ORDER BY user_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []TenantSettings
+	for rows.Next() {
+		var t TenantSettings
+		if err := scanTenantSettings(rows, &t); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+// SaveTenantSettings replaces a user's overrides and returns them as stored
+func SaveTenantSettings(t TenantSettings) (*TenantSettings, error) {
+	var types, processors interface{}
+	if t.AllowedFileTypes != nil {
+		types = pq.Array(t.AllowedFileTypes)
+	}
+	if t.EnabledProcessors != nil {
+		processors = pq.Array(t.EnabledProcessors)
+	}
+	var saved TenantSettings
+	err := scanTenantSettings(GetDB().QueryRow(`
+		INSERT INTO tenant_settings (user_id, max_file_bytes, max_files, max_bytes, allowed_file_types, file_expiry_days, enabled_processors, upload_bytes_per_second, download_bytes_per_second, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (user_id) DO UPDATE SET
+			max_file_bytes = EXCLUDED.max_file_bytes,
+			max_files = EXCLUDED.max_files,
+			max_bytes = EXCLUDED.max_bytes,
+			allowed_file_types = EXCLUDED.allowed_file_types,
+			file_expiry_days = EXCLUDED.file_expiry_days,
+			enabled_processors = EXCLUDED.enabled_processors,
+			upload_bytes_per_second = EXCLUDED.upload_bytes_per_second,
+			download_bytes_per_second = EXCLUDED.download_bytes_per_second,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = NOW()
+		RETURNING `+tenantSettingsColumns+`
+	`, t.UserID, t.MaxFileBytes, t.MaxFiles, t.MaxBytes, types, t.FileExpiryDays, processors, t.UploadBytesPerSecond, t.DownloadBytesPerSecond, t.UpdatedBy), &saved)
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// DeleteTenantSettings removes a user's overrides, reporting whether there
+// were any
+func DeleteTenantSettings(userID string) (bool, error) {
+	res, err := GetDB().Exec(`DELETE FROM tenant_settings WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
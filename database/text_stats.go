@@ -0,0 +1,159 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// TextStats are the statistics the text processor computed for a file
+type TextStats struct {
+	FileID     string
+	Language   string
+	Words      int
+	Sentences  int
+	Characters int64
+	// The readability scores are nil for texts without words
+	FleschReadingEase  *float64
+	FleschKincaidGrade *float64
+	// TopTerms is the JSON array of {"term", "count"} objects
+	TopTerms  json.RawMessage
+	UpdatedAt time.Time
+}
+
+// TextStatsSummary aggregates the text statistics of a user's files
+type TextStatsSummary struct {
+	Files          int
+	Words          int64
+	Sentences      int64
+	AvgReadingEase *float64
+	// Languages counts the files in each language
+	Languages map[string]int
+	// TopTerms are the terms most frequent across the files' own top terms
+	TopTerms []TermCount
+}
+
+// TermCount is how often a term occurs
+type TermCount struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// SaveTextStats stores a file's text statistics, replacing earlier ones
+func SaveTextStats(s TextStats) error {
+	topTerms := s.TopTerms
+	if topTerms == nil {
+		topTerms = json.RawMessage("[]")
+	}
+	_, err := GetDB().Exec(`
+		INSERT INTO text_stats (file_id, language, words, sentences, characters, flesch_reading_ease, flesch_kincaid_grade, top_terms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (file_id) DO UPDATE
+		SET language = EXCLUDED.language, words = EXCLUDED.words, sentences = EXCLUDED.sentences,
+			characters = EXCLUDED.characters, flesch_reading_ease = EXCLUDED.flesch_reading_ease,
+			flesch_kincaid_grade = EXCLUDED.flesch_kincaid_grade, top_terms = EXCLUDED.top_terms,
+			updated_at = NOW()
+	`, s.FileID, s.Language, s.Words, s.Sentences, s.Characters, s.FleschReadingEase, s.FleschKincaidGrade, []byte(topTerms))
+	return err
+}
+
+// CopyTextStats gives toID the text statistics of fromID, if it has any
+func CopyTextStats(fromID, toID string) error {
+	_, err := GetDB().Exec(`
+		INSERT INTO text_stats (file_id, language, words, sentences, characters, flesch_reading_ease, flesch_kincaid_grade, top_terms)
+		SELECT $2, language, words, sentences, characters, flesch_reading_ease, flesch_kincaid_grade, top_terms
+		FROM text_stats
+		WHERE file_id = $1
+		ON CONFLICT (file_id) DO UPDATE
+		SET language = EXCLUDED.language, words = EXCLUDED.words, sentences = EXCLUDED.sentences,
+			characters = EXCLUDED.characters, flesch_reading_ease = EXCLUDED.flesch_reading_ease,
+			flesch_kincaid_grade = EXCLUDED.flesch_kincaid_grade, top_terms = EXCLUDED.top_terms,
+			updated_at = NOW()
+	`, fromID, toID)
+	return err
+}
+
+// GetTextStats returns a file's text statistics, or nil if the text processor
+// hasn't run on it
+func GetTextStats(fileID string) (*TextStats, error) {
+	var s TextStats
+	var topTerms []byte
+	err := GetDB().QueryRow(`
+		SELECT file_id, language, words, sentences, characters, flesch_reading_ease, flesch_kincaid_grade, top_terms, updated_at
+		FROM text_stats
+		WHERE file_id = $1
+	`, fileID).Scan(&s.FileID, &s.Language, &s.Words, &s.Sentences, &s.Characters, &s.FleschReadingEase, &s.FleschKincaidGrade, &topTerms, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.TopTerms = topTerms
+	return &s, nil
+}
+
+// GetTextStatsSummary aggregates the text statistics of a user's files, only
+// those in language unless it is empty. limit caps the top terms.
+func GetTextStatsSummary(userID, language string, limit int) (*TextStatsSummary, error) {
+	summary := TextStatsSummary{Languages: make(map[string]int), TopTerms: []TermCount{}}
+	rows, err := GetDB().Query(`
+		SELECT s.language, COUNT(*), SUM(s.words), SUM(s.sentences), SUM(s.flesch_reading_ease), COUNT(s.flesch_reading_ease)
+		FROM text_stats s
+		JOIN files f ON f.id = s.file_id
+		WHERE f.user_id = $1 AND ($2 = '' OR s.language = $2)
+		GROUP BY s.language
+	`, userID, language)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var easeSum float64
+	var easeCount int
+	for rows.Next() {
+		var lang string
+		var files, scored int
+		var words, sentences int64
+		var ease sql.NullFloat64
+		if err := rows.Scan(&lang, &files, &words, &sentences, &ease, &scored); err != nil {
+			return nil, err
+		}
+		summary.Languages[lang] = files
+		summary.Files += files
+		summary.Words += words
+		summary.Sentences += sentences
+		easeSum += ease.Float64
+		easeCount += scored
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if easeCount > 0 {
+		avg := easeSum / float64(easeCount)
+		summary.AvgReadingEase = &avg
+	}
+
+	termRows, err := GetDB().Query(`
+		SELECT t->>'term', SUM((t->>'count')::int) AS total
+		FROM text_stats s
+		JOIN files f ON f.id = s.file_id
+		CROSS JOIN jsonb_array_elements(s.top_terms) t
+		WHERE f.user_id = $1 AND ($2 = '' OR s.language = $2)
+		GROUP BY 1
+		ORDER BY total DESC, 1
+		LIMIT $3
+	`, userID, language, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer termRows.Close()
+	for termRows.Next() {
+		var tc TermCount
+		if err := termRows.Scan(&tc.Term, &tc.Count); err != nil {
+			return nil, err
+		}
+		summary.TopTerms = append(summary.TopTerms, tc)
+	}
+	return &summary, termRows.Err()
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrTokenExpired is returned when an access token exists but has expired
+var ErrTokenExpired = errors.New("access token has expired")
+
+// SaveAccessToken stores an access token issued to a user by its hash. A nil
+// scopes leaves the token unrestricted.
+func SaveAccessToken(token, userID string, expiresAt time.Time, scopes []string) error {
+	_, err := GetDB().Exec(`
+		INSERT INTO access_tokens (token_hash, user_id, expires_at, scopes)
+		VALUES ($1, $2, $3, $4)
+	`, hashToken(token), userID, expiresAt, pq.Array(scopes))
+	return err
+}
+
+// GetUserByAccessToken retrieves the user an access token was issued to and
+// the token's scopes, nil if it is unrestricted. It returns ErrTokenExpired
+// if the token is no longer valid.
+func GetUserByAccessToken(token string) (*User, []string, error) {
+	var user User
+	var expired bool
+	var scopes pq.StringArray
+	err := GetDB().QueryRow(`
+		SELECT u.id, u.username, u.password, u.email, u.confirmed, u.created_at, t.expires_at <= NOW(), t.scopes
+		FROM access_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1
+	`, hashToken(token)).Scan(&user.ID, &user.Username, &user.Password, openColumn(columnUserEmail, &user.Email), &user.Confirmed, &user.CreatedAt, &expired, &scopes)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if expired {
+		return nil, nil, ErrTokenExpired
+	}
+	return &user, scopes, nil
+}
+
+// DeleteAccessToken revokes an access token
+func DeleteAccessToken(token string) error {
+	_, err := GetDB().Exec(`
+		DELETE FROM access_tokens
+		WHERE token_hash = $1
+	`, hashToken(token))
+	return err
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx, so repository functions
+// can share one implementation for their plain and transactional variants
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// WithTx runs fn inside a transaction. The transaction is committed if fn
+// returns nil and rolled back otherwise, including when fn panics.
+func WithTx(fn func(tx *sql.Tx) error) (err error) {
+	tx, err := GetDB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UploadToken lets whoever holds it upload one file on behalf of the user who
+// created it, with the name, size and content type fixed in advance
+type UploadToken struct {
+	ID     string
+	UserID string
+	// Token is only set on a newly created upload token; the database keeps
+	// its hash
+	Token       string
+	Name        string
+	SizeBytes   int64
+	ContentType string
+	ExpiresAt   time.Time
+	UsedAt      sql.NullTime
+	// FileID is the file uploaded with the token, once it has been
+	FileID    string
+	CreatedAt time.Time
+}
+
+// uploadTokenColumns is the column list read by scanUploadToken
+const uploadTokenColumns = `id, user_id, name, size_bytes, content_type, expires_at, used_at, COALESCE(file_id, ''), created_at`
+
+func scanUploadToken(row rowScanner, t *UploadToken) error {
+	return row.Scan(&t.ID, &t.UserID, &t.Name, &t.SizeBytes, &t.ContentType, &t.ExpiresAt, &t.UsedAt, &t.FileID, &t.CreatedAt)
+}
+
+// SaveUploadToken creates an upload token for a file of exactly size bytes
+// called name, sent with the given content type
+func SaveUploadToken(token, userID, name string, size int64, contentType string, expiresAt time.Time) (*UploadToken, error) {
+	var t UploadToken
+	err := scanUploadToken(GetDB().QueryRow(`
+		INSERT INTO upload_tokens (id, token_hash, user_id, name, size_bytes, content_type, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+uploadTokenColumns+`
+	`, NewID(), hashToken(token), userID, name, size, contentType, expiresAt), &t)
+	if err != nil {
+		return nil, err
+	}
+	t.Token = token
+	return &t, nil
+}
+
+// ClaimUploadToken marks an upload token as used and returns it. It returns
+// nil if the token doesn't exist, has expired or was already used, which also
+// keeps two concurrent uploads from both redeeming it.
+func ClaimUploadToken(token string) (*UploadToken, error) {
+	var t UploadToken
+	err := scanUploadToken(GetDB().QueryRow(`
+		UPDATE upload_tokens
+		SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING `+uploadTokenColumns+`
+	`, hashToken(token)), &t)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ReleaseUploadToken makes a claimed token usable again after its upload
+// failed before a file was stored
+func ReleaseUploadToken(id string) error {
+	_, err := GetDB().Exec(`
+		UPDATE upload_tokens
+		SET used_at = NULL
+		WHERE id = $1 AND file_id IS NULL
+	`, id)
+	return err
+}
+
+// CompleteUploadToken records the file uploaded with a claimed token
+func CompleteUploadToken(id, fileID string) error {
+	_, err := GetDB().Exec(`
+		UPDATE upload_tokens
+		SET file_id = $2
+		WHERE id = $1
+	`, id, fileID)
+	return err
+}
+
+// DeleteExpiredUploadTokens removes tokens that expired before cutoff and
+// returns how many were deleted
+func DeleteExpiredUploadTokens(cutoff time.Time) (int64, error) {
+	res, err := GetDB().Exec(`DELETE FROM upload_tokens WHERE expires_at <= $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,116 @@
+package database
+
+import "time"
+
+// TenantUsage is how much of the service a user used
+type TenantUsage struct {
+	UserID   string
+	Username string
+	// BytesStored and Files are what the user stores now
+	BytesStored int64
+	Files       int
+	// The rest are counted over the requested period
+	Uploads int
+	// Downloads counts every read of a file's content, see LogFileAccess
+	Downloads int
+	// Invocations is how many times the user's files were processed, and
+	// ProcessingMillis how long their pipeline stages ran in total
+	Invocations      int
+	ProcessingMillis int64
+}
+
+// GetTenantUsage returns the usage of every user that stores files or had
+// activity in [since, until), ordered by username
+func GetTenantUsage(since, until time.Time) ([]TenantUsage, error) {
+	rows, err := GetDB().Query(`
+		WITH stored AS (
+			SELECT user_id, COALESCE(SUM(size_bytes), 0) AS bytes, COUNT(*) AS files
+			FROM files
+			WHERE user_id IS NOT NULL
+			GROUP BY user_id
+		), activity AS (
+			SELECT user_id,
+				COUNT(*) FILTER (WHERE type = $3) AS uploads,
+				COUNT(*) FILTER (WHERE type = $4) AS invocations,
+				COALESCE(SUM((payload->>'duration_ms')::BIGINT) FILTER (WHERE type = $5), 0) AS processing_ms
+			FROM events
+			WHERE created_at >= $1 AND created_at < $2 AND user_id IS NOT NULL
+			GROUP BY user_id
+		), downloads AS (
+			SELECT f.user_id, COUNT(*) AS downloads
+			FROM file_access_log l
+			JOIN files f ON f.id = l.file_id
+			WHERE l.created_at >= $1 AND l.created_at < $2 AND f.user_id IS NOT NULL
+			GROUP BY f.user_id
+		)
+		SELECT u.id, u.username,
+			COALESCE(s.bytes, 0), COALESCE(s.files, 0),
+			COALESCE(a.uploads, 0), COALESCE(d.downloads, 0),
+			COALESCE(a.invocations, 0), COALESCE(a.processing_ms, 0)
+		FROM users u
+		LEFT JOIN stored s ON s.user_id = u.id
+		LEFT JOIN activity a ON a.user_id = u.id
+		LEFT JOIN downloads d ON d.user_id = u.id
+		WHERE s.user_id IS NOT NULL OR a.user_id IS NOT NULL OR d.user_id IS NOT NULL
+		ORDER BY u.username
+	`, since, until, EventFileUploaded, EventFileProcessed, EventFileStage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []TenantUsage
+	for rows.Next() {
+		var u TenantUsage
+		if err := rows.Scan(&u.UserID, &u.Username, &u.BytesStored, &u.Files, &u.Uploads, &u.Downloads, &u.Invocations, &u.ProcessingMillis); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// StorageTotal is how much one user stores
+type StorageTotal struct {
+	UserID   string
+	Username string
+	Files    int
+	Bytes    int64
+}
+
+// GetStorageTotals returns what every user with files stores, largest first
+func GetStorageTotals() ([]StorageTotal, error) {
+	rows, err := GetDB().Query(`
+		SELECT u.id, u.username, COUNT(*), COALESCE(SUM(f.size_bytes), 0) AS bytes
+		FROM files f
+		JOIN users u ON u.id = f.user_id
+		GROUP BY u.id, u.username
+		ORDER BY bytes DESC, u.username
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []StorageTotal
+	for rows.Next() {
+		var t StorageTotal
+		if err := rows.Scan(&t.UserID, &t.Username, &t.Files, &t.Bytes); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+// TotalUsage returns how many files are stored and their total size in
+// bytes, whoever owns them
+func TotalUsage() (int, int64, error) {
+	var files int
+	var bytes int64
+	err := GetDB().QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(size_bytes), 0)
+		FROM files
+	`).Scan(&files, &bytes)
+	return files, bytes, err
+}
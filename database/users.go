@@ -4,9 +4,13 @@ import (
 	"database/sql"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/yourusername/golang-aws-api/pagination"
 )
 
+// AnonymousUserID is the system user that owns uploads made without
+// credentials, created by a migration
+const AnonymousUserID = "anonymous"
+
 type User struct {
 	ID        string
 	Username  string
@@ -16,29 +20,77 @@ type User struct {
 	CreatedAt time.Time
 }
 
-// SaveUser saves a new user to the database
+// SaveUser saves a new user to the database. It returns nil if the username
+// or email is already taken, ignoring case, doing the same work either way.
+// Users whose email key isn't hashed yet are matched by its plain form too.
 func SaveUser(username, password, email string) (*User, error) {
 	var user User
-	userID := uuid.New().String()
+	userID := NewID()
 	err := GetDB().QueryRow(`
-		INSERT INTO users (id, username, password, email)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO users (id, username, password, email, email_key)
+		SELECT $1, $2, $3, $4, $5
+		WHERE NOT EXISTS (SELECT 1 FROM users WHERE email_key = ANY($6))
+		ON CONFLICT DO NOTHING
 		RETURNING id, username, password, email, confirmed, created_at
-	`, userID, username, password, email).Scan(&user.ID, &user.Username, &user.Password, &user.Email, &user.Confirmed, &user.CreatedAt)
+	`, userID, NormalizeUsername(username), password, sealColumn(columnUserEmail, NormalizeEmail(email)), emailKeyValue(email), emailKeysValue(email)).Scan(&user.ID, &user.Username, &user.Password, openColumn(columnUserEmail, &user.Email), &user.Confirmed, &user.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// EnsureExternalUser returns the user with the given ID, creating it as a
+// confirmed user without a password if it doesn't exist yet. It records users
+// signed in by an external identity provider, so their files and exports can
+// reference them.
+func EnsureExternalUser(id, username, email string) (*User, error) {
+	var user User
+	err := GetDB().QueryRow(`
+		WITH inserted AS (
+			INSERT INTO users (id, username, password, email, email_key, confirmed)
+			VALUES ($1, $2, '', $3, $4, true)
+			ON CONFLICT (id) DO NOTHING
+			RETURNING id, username, password, email, confirmed, created_at
+		)
+		SELECT id, username, password, email, confirmed, created_at FROM inserted
+		UNION ALL
+		SELECT id, username, password, email, confirmed, created_at FROM users WHERE id = $1
+		LIMIT 1
+	`, id, NormalizeUsername(username), sealColumn(columnUserEmail, NormalizeEmail(email)), emailKeyValue(email)).Scan(&user.ID, &user.Username, &user.Password, openColumn(columnUserEmail, &user.Email), &user.Confirmed, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-// GetUserByUsername retrieves a user by username
+// GetUserByUsername retrieves a user by username, ignoring case
 func GetUserByUsername(username string) (*User, error) {
 	var user User
 	err := GetDB().QueryRow(`
 		SELECT id, username, password, email, confirmed, created_at 
 		FROM users 
-		WHERE username = $1
-	`, username).Scan(&user.ID, &user.Username, &user.Password, &user.Email, &user.Confirmed, &user.CreatedAt)
+		WHERE LOWER(username) = LOWER($1)
+	`, NormalizeUsername(username)).Scan(&user.ID, &user.Username, &user.Password, openColumn(columnUserEmail, &user.Email), &user.Confirmed, &user.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserByID retrieves a user by ID
+func GetUserByID(id string) (*User, error) {
+	var user User
+	err := GetDB().QueryRow(`
+		SELECT id, username, password, email, confirmed, created_at
+		FROM users
+		WHERE id = $1
+	`, id).Scan(&user.ID, &user.Username, &user.Password, openColumn(columnUserEmail, &user.Email), &user.Confirmed, &user.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -48,14 +100,15 @@ func GetUserByUsername(username string) (*User, error) {
 	return &user, nil
 }
 
-// GetUserByEmail retrieves a user by email
+// GetUserByEmail retrieves a user by email, compared by EmailKey, plain or
+// hashed
 func GetUserByEmail(email string) (*User, error) {
 	var user User
 	err := GetDB().QueryRow(`
 		SELECT id, username, password, email, confirmed, created_at 
 		FROM users 
-		WHERE email = $1
-	`, email).Scan(&user.ID, &user.Username, &user.Password, &user.Email, &user.Confirmed, &user.CreatedAt)
+		WHERE email_key = ANY($1)
+	`, emailKeysValue(email)).Scan(&user.ID, &user.Username, &user.Password, openColumn(columnUserEmail, &user.Email), &user.Confirmed, &user.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -65,12 +118,44 @@ func GetUserByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// ConfirmUser confirms a user's email
-func ConfirmUser(username string) error {
-	_, err := GetDB().Exec(`
+// ConfirmUser confirms a user's email, reporting whether the user exists
+func ConfirmUser(username string) (bool, error) {
+	res, err := GetDB().Exec(`
 		UPDATE users 
 		SET confirmed = true 
-		WHERE username = $1
-	`, username)
-	return err
+		WHERE LOWER(username) = LOWER($1)
+	`, NormalizeUsername(username))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListUsers retrieves a page of users, newest first, starting after the given
+// cursor. Up to limit+1 rows are returned so the caller can tell whether
+// another page follows.
+func ListUsers(after *pagination.Cursor, limit int) ([]User, error) {
+	createdAt, id := keysetBounds(after)
+	rows, err := GetDB().Query(`
+		SELECT id, username, password, email, confirmed, created_at
+		FROM users
+		WHERE (created_at, id) < ($1::timestamp, $2::text)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, createdAt, id, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Password, openColumn(columnUserEmail, &user.Email), &user.Confirmed, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
 }
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// WasmPlugin is a WebAssembly module uploaded by an administrator to run as a
+// processor. The module itself is only loaded by GetWasmPluginModule.
+type WasmPlugin struct {
+	Name      string
+	SHA256    string
+	SizeBytes int64
+	UpdatedBy string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+const wasmPluginColumns = `name, sha256, size_bytes, updated_by, created_at, updated_at`
+
+func scanWasmPlugin(row rowScanner, p *WasmPlugin) error {
+	return row.Scan(&p.Name, &p.SHA256, &p.SizeBytes, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt)
+}
+
+// SaveWasmPlugin stores a plugin's module, replacing the one uploaded under
+// the same name
+func SaveWasmPlugin(name string, module []byte, sha256, updatedBy string) (*WasmPlugin, error) {
+	var p WasmPlugin
+	err := scanWasmPlugin(GetDB().QueryRow(`
+		INSERT INTO wasm_plugins (name, module, sha256, size_bytes, updated_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name) DO UPDATE
+		SET module = EXCLUDED.module, sha256 = EXCLUDED.sha256, size_bytes = EXCLUDED.size_bytes,
+			updated_by = EXCLUDED.updated_by, updated_at = NOW()
+		RETURNING `+wasmPluginColumns+`
+	`, name, module, sha256, len(module), updatedBy), &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListWasmPlugins returns every plugin ordered by name, without the modules
+func ListWasmPlugins() ([]WasmPlugin, error) {
+	rows, err := GetDB().Query(`
+		SELECT ` + wasmPluginColumns + `
+		FROM wasm_plugins
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plugins []WasmPlugin
+	for rows.Next() {
+		var p WasmPlugin
+		if err := scanWasmPlugin(rows, &p); err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, rows.Err()
+}
+
+// GetWasmPluginModule returns a plugin's module and its checksum, or nil if
+// there is no plugin by that name
+func GetWasmPluginModule(name string) ([]byte, string, error) {
+	var module []byte
+	var sum string
+	err := GetDB().QueryRow(`SELECT module, sha256 FROM wasm_plugins WHERE name = $1`, name).Scan(&module, &sum)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return module, sum, nil
+}
+
+// DeleteWasmPlugin removes a plugin, reporting whether it existed
+func DeleteWasmPlugin(name string) (bool, error) {
+	res, err := GetDB().Exec(`DELETE FROM wasm_plugins WHERE name = $1`, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
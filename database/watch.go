@@ -0,0 +1,26 @@
+package database
+
+import "database/sql"
+
+// GetWatchCheckpoint returns the last key the prefix watcher registered
+// under prefix, or "" if it has never run
+func GetWatchCheckpoint(prefix string) (string, error) {
+	var lastKey string
+	err := GetDB().QueryRow(`
+		SELECT last_key FROM watch_checkpoints WHERE prefix = $1
+	`, prefix).Scan(&lastKey)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return lastKey, err
+}
+
+// SaveWatchCheckpoint records the last key handled under prefix
+func SaveWatchCheckpoint(prefix, lastKey string) error {
+	_, err := GetDB().Exec(`
+		INSERT INTO watch_checkpoints (prefix, last_key)
+		VALUES ($1, $2)
+		ON CONFLICT (prefix) DO UPDATE SET last_key = EXCLUDED.last_key, updated_at = NOW()
+	`, prefix, lastKey)
+	return err
+}
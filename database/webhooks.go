@@ -0,0 +1,67 @@
+package database
+
+import "time"
+
+// Webhook is a URL a user has registered to be told when processing of one
+// of their files finishes
+type Webhook struct {
+	ID        string
+	UserID    string
+	URL       string
+	Secret    string
+	CreatedAt time.Time
+}
+
+const webhookColumns = `id, user_id, url, secret, created_at`
+
+func scanWebhook(row rowScanner, h *Webhook) error {
+	return row.Scan(&h.ID, &h.UserID, &h.URL, openColumn(columnWebhookSecret, &h.Secret), &h.CreatedAt)
+}
+
+// SaveWebhook registers a webhook for a user
+func SaveWebhook(userID, url, secret string) (*Webhook, error) {
+	var h Webhook
+	err := scanWebhook(GetDB().QueryRow(`
+		INSERT INTO webhooks (id, user_id, url, secret)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+webhookColumns+`
+	`, NewID(), userID, url, sealColumn(columnWebhookSecret, secret)), &h)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// ListWebhooks returns a user's webhooks, oldest first
+func ListWebhooks(userID string) ([]Webhook, error) {
+	rows, err := GetDB().Query(`
+		SELECT `+webhookColumns+`
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		var h Webhook
+		if err := scanWebhook(rows, &h); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// DeleteWebhook removes one of a user's webhooks, reporting whether it existed
+func DeleteWebhook(id, userID string) (bool, error) {
+	res, err := GetDB().Exec(`DELETE FROM webhooks WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
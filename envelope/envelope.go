@@ -0,0 +1,206 @@
+// Package envelope encrypts file content before it is written to S3, for
+// tenants who don't want to rely on server-side encryption alone. Every file
+// gets its own AES-256 data key, generated and wrapped by KMS; only the
+// wrapped key is stored, next to the ciphertext in the object's metadata and
+// in the file's row. The row decides how an object is opened: the metadata
+// is written by whoever can write to the bucket.
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// AlgorithmAESGCM is AES-256-GCM with a random 12 byte nonce prepended to
+// the ciphertext
+const AlgorithmAESGCM = "AES256-GCM"
+
+// Object metadata keys holding the envelope
+const (
+	metaAlgorithm  = "envelope-algorithm"
+	metaKeyID      = "envelope-key-id"
+	metaWrappedKey = "envelope-wrapped-key"
+)
+
+// ErrNoKeyService is returned when encrypted content is read by a process
+// that has no key service configured
+var ErrNoKeyService = errors.New("no key service configured for encrypted content")
+
+// KeyService generates data keys and unwraps them again. The encryption
+// context is bound to the wrapped key, so a key can only be unwrapped for the
+// file it was generated for, and only under the key ID it was generated
+// under.
+type KeyService interface {
+	GenerateDataKey(ctx context.Context, keyID string, encryptionContext map[string]string) (plaintext, wrapped []byte, err error)
+	Decrypt(ctx context.Context, keyID string, wrapped []byte, encryptionContext map[string]string) ([]byte, error)
+}
+
+// Envelope describes how a file's content was encrypted. The zero value means
+// the content is stored as is.
+type Envelope struct {
+	Algorithm  string
+	KeyID      string
+	WrappedKey []byte
+}
+
+// Encrypted reports whether content was encrypted
+func (e Envelope) Encrypted() bool {
+	return e.Algorithm != ""
+}
+
+// Metadata returns the envelope as S3 user metadata, which carries the
+// wrapped keys of objects derived from a file, such as its redacted copy
+func (e Envelope) Metadata() map[string]string {
+	if !e.Encrypted() {
+		return nil
+	}
+	return map[string]string{
+		metaAlgorithm:  e.Algorithm,
+		metaKeyID:      e.KeyID,
+		metaWrappedKey: base64.StdEncoding.EncodeToString(e.WrappedKey),
+	}
+}
+
+// FromMetadata reads the envelope from S3 user metadata, returning the zero
+// Envelope for objects that weren't encrypted
+func FromMetadata(metadata map[string]string) (Envelope, error) {
+	algorithm := metadata[metaAlgorithm]
+	if algorithm == "" {
+		return Envelope{}, nil
+	}
+	if algorithm != AlgorithmAESGCM {
+		return Envelope{}, fmt.Errorf("unsupported encryption algorithm %q", algorithm)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(metadata[metaWrappedKey])
+	if err != nil || len(wrapped) == 0 {
+		return Envelope{}, errors.New("invalid wrapped data key in object metadata")
+	}
+	return Envelope{Algorithm: algorithm, KeyID: metadata[metaKeyID], WrappedKey: wrapped}, nil
+}
+
+// ObjectEnvelope returns the envelope to open an object of a file with.
+// stored is the envelope recorded in the file's row, which alone decides
+// whether the content is encrypted and under which KMS key, so whoever can
+// write to the bucket can neither strip the encryption nor swap in content
+// sealed under a key of their own. Only the wrapped data key is read from
+// the metadata, since copies derived from a file are sealed with data keys
+// of their own; each is bound to the file ID by its encryption context.
+func ObjectEnvelope(stored Envelope, metadata map[string]string) (Envelope, error) {
+	env, err := FromMetadata(metadata)
+	if err != nil {
+		return Envelope{}, err
+	}
+	switch {
+	case !stored.Encrypted() && env.Encrypted():
+		return Envelope{}, errors.New("object is encrypted but its file isn't")
+	case !stored.Encrypted():
+		return Envelope{}, nil
+	case !env.Encrypted():
+		return Envelope{}, errors.New("object of an encrypted file isn't encrypted")
+	case env.Algorithm != stored.Algorithm || env.KeyID != stored.KeyID:
+		return Envelope{}, fmt.Errorf("object is encrypted under %s, not the file's key %s", env.KeyID, stored.KeyID)
+	}
+	return Envelope{Algorithm: stored.Algorithm, KeyID: stored.KeyID, WrappedKey: env.WrappedKey}, nil
+}
+
+// encryptionContext ties a data key to the file it protects
+func encryptionContext(fileID string) map[string]string {
+	return map[string]string{"file_id": fileID}
+}
+
+// Seal encrypts plaintext for fileID under a new data key wrapped by keyID
+func Seal(ctx context.Context, keys KeyService, keyID, fileID string, plaintext []byte) ([]byte, Envelope, error) {
+	if keys == nil {
+		return nil, Envelope{}, ErrNoKeyService
+	}
+	dataKey, wrapped, err := keys.GenerateDataKey(ctx, keyID, encryptionContext(fileID))
+	if err != nil {
+		return nil, Envelope{}, fmt.Errorf("generating data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, Envelope{}, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, Envelope{}, err
+	}
+	ciphertext := aead.Seal(nonce, nonce, plaintext, []byte(fileID))
+	return ciphertext, Envelope{Algorithm: AlgorithmAESGCM, KeyID: keyID, WrappedKey: wrapped}, nil
+}
+
+// SealBody is Seal for content read from body, which holds size bytes. Body
+// is returned untouched when keyID is empty, so plain uploads keep streaming.
+func SealBody(ctx context.Context, keys KeyService, keyID, fileID string, body io.ReadSeeker, size int64) (io.ReadSeeker, int64, Envelope, error) {
+	if keyID == "" {
+		return body, size, Envelope{}, nil
+	}
+	plaintext, err := io.ReadAll(io.LimitReader(body, size))
+	if err != nil {
+		return nil, 0, Envelope{}, err
+	}
+	ciphertext, env, err := Seal(ctx, keys, keyID, fileID, plaintext)
+	if err != nil {
+		return nil, 0, Envelope{}, err
+	}
+	return bytes.NewReader(ciphertext), int64(len(ciphertext)), env, nil
+}
+
+// Open unwraps the data key in env and decrypts ciphertext sealed for fileID
+func Open(ctx context.Context, keys KeyService, env Envelope, fileID string, ciphertext []byte) ([]byte, error) {
+	if keys == nil {
+		return nil, ErrNoKeyService
+	}
+	dataKey, err := keys.Decrypt(ctx, env.KeyID, env.WrappedKey, encryptionContext(fileID))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("encrypted content is truncated")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, []byte(fileID))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting content: %v", err)
+	}
+	return plaintext, nil
+}
+
+// ReadObject reads the body of an S3 object belonging to fileID, decrypting
+// it when the file's stored envelope says it is encrypted. The body is not
+// closed.
+func ReadObject(ctx context.Context, keys KeyService, fileID string, stored Envelope, obj *s3.GetObjectOutput) ([]byte, error) {
+	env, err := ObjectEnvelope(stored, obj.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(obj.Body)
+	if err != nil || !env.Encrypted() {
+		return content, err
+	}
+	return Open(ctx, keys, env, fileID, content)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("data key is %d bytes, want 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
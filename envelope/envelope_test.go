@@ -0,0 +1,231 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKeys wraps data keys by prefixing them with the key ID and context
+type fakeKeys struct{}
+
+func (fakeKeys) GenerateDataKey(_ context.Context, keyID string, ec map[string]string) ([]byte, []byte, error) {
+	key := bytes.Repeat([]byte{7}, 32)
+	return key, append([]byte(keyID+"|"+ec["file_id"]+"|"), key...), nil
+}
+
+func (fakeKeys) Decrypt(_ context.Context, keyID string, wrapped []byte, ec map[string]string) ([]byte, error) {
+	parts := bytes.SplitN(wrapped, []byte("|"), 3)
+	if len(parts) != 3 || string(parts[0]) != keyID || string(parts[1]) != ec["file_id"] {
+		return nil, errors.New("InvalidCiphertextException")
+	}
+	return parts[2], nil
+}
+
+func TestSealOpen(t *testing.T) {
+	ctx := context.Background()
+	ciphertext, env, err := Seal(ctx, fakeKeys{}, "alias/uploads", "file-1", []byte("hello"))
+	assert.NoError(t, err)
+	assert.True(t, env.Encrypted())
+	assert.Equal(t, "alias/uploads", env.KeyID)
+	assert.NotContains(t, string(ciphertext), "hello")
+
+	plaintext, err := Open(ctx, fakeKeys{}, env, "file-1", ciphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(plaintext))
+
+	// The content is bound to its file
+	_, err = Open(ctx, fakeKeys{}, env, "file-2", ciphertext)
+	assert.Error(t, err)
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	_, err = Open(ctx, fakeKeys{}, env, "file-1", ciphertext)
+	assert.Error(t, err)
+
+	_, _, err = Seal(ctx, nil, "alias/uploads", "file-1", []byte("hello"))
+	assert.ErrorIs(t, err, ErrNoKeyService)
+}
+
+func TestSealBody(t *testing.T) {
+	ctx := context.Background()
+	plain := strings.NewReader("plain")
+	body, size, env, err := SealBody(ctx, fakeKeys{}, "", "file-1", plain, 5)
+	assert.NoError(t, err)
+	assert.Same(t, plain, body)
+	assert.EqualValues(t, 5, size)
+	assert.False(t, env.Encrypted())
+
+	body, size, env, err = SealBody(ctx, fakeKeys{}, "alias/uploads", "file-1", strings.NewReader("secret"), 6)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 6+12+16, size)
+
+	// Reading it back as an S3 object takes the wrapped key from the metadata
+	obj := &s3.GetObjectOutput{Body: io.NopCloser(body), Metadata: env.Metadata()}
+	content, err := ReadObject(ctx, fakeKeys{}, "file-1", Envelope{Algorithm: AlgorithmAESGCM, KeyID: "alias/uploads"}, obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(content))
+
+	plainObj := &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader("plain"))}
+	content, err = ReadObject(ctx, nil, "file-1", Envelope{}, plainObj)
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", string(content))
+}
+
+func TestSealValue(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 32)
+	sealed, err := SealValue(key, []byte("alice@example.com"), []byte("users.email"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(sealed), "alice")
+
+	again, err := SealValue(key, []byte("alice@example.com"), []byte("users.email"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, sealed, again, "every value gets its own nonce")
+
+	plaintext, err := OpenValue(key, sealed, []byte("users.email"))
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", string(plaintext))
+
+	// The value is bound to its use
+	_, err = OpenValue(key, sealed, []byte("webhooks.secret"))
+	assert.Error(t, err)
+	_, err = OpenValue(bytes.Repeat([]byte{4}, 32), sealed, []byte("users.email"))
+	assert.Error(t, err)
+	_, err = OpenValue(key, sealed[:5], []byte("users.email"))
+	assert.Error(t, err)
+	_, err = SealValue(key[:16], []byte("x"), nil)
+	assert.Error(t, err)
+}
+
+func TestFromMetadata(t *testing.T) {
+	env, err := FromMetadata(nil)
+	assert.NoError(t, err)
+	assert.False(t, env.Encrypted())
+
+	_, err = FromMetadata(map[string]string{metaAlgorithm: "ROT13"})
+	assert.Error(t, err)
+	_, err = FromMetadata(map[string]string{metaAlgorithm: AlgorithmAESGCM, metaWrappedKey: "!"})
+	assert.Error(t, err)
+
+	want := Envelope{Algorithm: AlgorithmAESGCM, KeyID: "k", WrappedKey: []byte{1, 2, 3}}
+	got, err := FromMetadata(want.Metadata())
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestObjectEnvelope(t *testing.T) {
+	stored := Envelope{Algorithm: AlgorithmAESGCM, KeyID: "alias/uploads", WrappedKey: []byte("row")}
+	copied := Envelope{Algorithm: AlgorithmAESGCM, KeyID: "alias/uploads", WrappedKey: []byte("copy")}
+
+	env, err := ObjectEnvelope(stored, copied.Metadata())
+	assert.NoError(t, err)
+	assert.Equal(t, copied, env)
+
+	env, err = ObjectEnvelope(Envelope{}, nil)
+	assert.NoError(t, err)
+	assert.False(t, env.Encrypted())
+
+	// The file's row, not the object, decides whether and how it is encrypted
+	_, err = ObjectEnvelope(stored, nil)
+	assert.Error(t, err)
+	_, err = ObjectEnvelope(Envelope{}, copied.Metadata())
+	assert.Error(t, err)
+	other := Envelope{Algorithm: AlgorithmAESGCM, KeyID: "arn:aws:kms:us-east-1:999999999999:key/theirs", WrappedKey: []byte("x")}
+	_, err = ObjectEnvelope(stored, other.Metadata())
+	assert.Error(t, err)
+}
+
+// testKMS returns a KMS key service that sends its requests to url
+func testKMS(url string) *KMS {
+	return NewKMS(aws.Config{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		BaseEndpoint: aws.String(url),
+	})
+}
+
+func TestKMS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-east-1/kms/aws4_request")
+		var in struct {
+			KeyId             string
+			CiphertextBlob    []byte
+			EncryptionContext map[string]string
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		assert.Equal(t, "file-1", in.EncryptionContext["file_id"])
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GenerateDataKey":
+			json.NewEncoder(w).Encode(map[string][]byte{"Plaintext": []byte("key"), "CiphertextBlob": []byte("wrapped:" + in.KeyId)})
+		case "TrentService.Decrypt":
+			if string(in.CiphertextBlob) != "wrapped:"+in.KeyId {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"InvalidCiphertextException","message":"bad blob"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string][]byte{"Plaintext": []byte("key")})
+		}
+	}))
+	defer srv.Close()
+
+	k := testKMS(srv.URL)
+	ctx := context.Background()
+	ec := encryptionContext("file-1")
+
+	plaintext, wrapped, err := k.GenerateDataKey(ctx, "alias/uploads", ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "key", string(plaintext))
+	assert.Equal(t, "wrapped:alias/uploads", string(wrapped))
+
+	plaintext, err = k.Decrypt(ctx, "alias/uploads", wrapped, ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "key", string(plaintext))
+
+	_, err = k.Decrypt(ctx, "alias/other", wrapped, ec)
+	assert.ErrorContains(t, err, "InvalidCiphertextException")
+}
+
+func TestKMSSign(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			KeyId            string
+			Message          []byte
+			MessageType      string
+			SigningAlgorithm string
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		assert.Equal(t, "alias/tokens", in.KeyId)
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Sign":
+			assert.Equal(t, "DIGEST", in.MessageType)
+			assert.Equal(t, "RSASSA_PKCS1_V1_5_SHA_256", in.SigningAlgorithm)
+			json.NewEncoder(w).Encode(map[string][]byte{"Signature": append([]byte("sig:"), in.Message...)})
+		case "TrentService.GetPublicKey":
+			json.NewEncoder(w).Encode(map[string][]byte{"PublicKey": []byte("der")})
+		}
+	}))
+	defer srv.Close()
+
+	k := testKMS(srv.URL)
+	ctx := context.Background()
+
+	sig, err := k.Sign(ctx, "alias/tokens", []byte("digest"))
+	assert.NoError(t, err)
+	assert.Equal(t, "sig:digest", string(sig))
+
+	der, err := k.GetPublicKey(ctx, "alias/tokens")
+	assert.NoError(t, err)
+	assert.Equal(t, "der", string(der))
+}
@@ -0,0 +1,74 @@
+package envelope
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// KMS is a KeyService backed by AWS KMS. It also signs with asymmetric keys,
+// for tokens the API issues.
+type KMS struct {
+	client *kms.Client
+}
+
+// NewKMS returns a KMS key service using cfg's region, credentials and
+// endpoints. No request is made until a key is needed.
+func NewKMS(cfg aws.Config) *KMS {
+	return &KMS{client: kms.NewFromConfig(cfg)}
+}
+
+// GenerateDataKey returns a new AES-256 key in plaintext and wrapped under keyID
+func (k *KMS) GenerateDataKey(ctx context.Context, keyID string, encryptionContext map[string]string) ([]byte, []byte, error) {
+	out, err := k.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(keyID),
+		KeySpec:           types.DataKeySpecAes256,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+// Decrypt unwraps a data key returned by GenerateDataKey. KMS refuses a key
+// that wasn't wrapped under keyID, so a wrapped key can't name a KMS key of
+// its own.
+func (k *KMS) Decrypt(ctx context.Context, keyID string, wrapped []byte, encryptionContext map[string]string) ([]byte, error) {
+	out, err := k.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(keyID),
+		CiphertextBlob:    wrapped,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// Sign returns the RSASSA-PKCS1-v1_5 signature of digest, a SHA-256 hash,
+// made with the asymmetric RSA key keyID
+func (k *KMS) Sign(ctx context.Context, keyID string, digest []byte) ([]byte, error) {
+	out, err := k.client.Sign(ctx, &kms.SignInput{
+		KeyId:            aws.String(keyID),
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: types.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Signature, nil
+}
+
+// GetPublicKey returns the public half of the asymmetric key keyID, DER
+// encoded as a SubjectPublicKeyInfo
+func (k *KMS) GetPublicKey(ctx context.Context, keyID string) ([]byte, error) {
+	out, err := k.client.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return nil, err
+	}
+	return out.PublicKey, nil
+}
@@ -0,0 +1,39 @@
+package envelope
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// SealValue encrypts a short value, such as a database column, with
+// AES-256-GCM under a data key the caller keeps unwrapped. The value is bound
+// to aad, so it only opens for the same use; the random nonce is prepended.
+func SealValue(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// OpenValue decrypts a value sealed by SealValue with the same key and aad
+func OpenValue(key, sealed, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted value is truncated")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting value: %v", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,157 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Supported export formats
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+	FormatPDF  = "pdf"
+	FormatZip  = "zip"
+	// FormatAccount is a zip archive of everything stored about a user,
+	// written with WriteArchive
+	FormatAccount = "account"
+)
+
+// Record is a file's metadata and processing result flattened for export
+type Record struct {
+	FileID      string    `json:"file_id"`
+	FileName    string    `json:"file_name"`
+	S3Key       string    `json:"s3_key,omitempty"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	Status      string    `json:"status"`
+	Result      string    `json:"result"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// ContentType returns the MIME type for an export format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatJSON:
+		return "application/json"
+	case FormatPDF:
+		return "application/pdf"
+	case FormatZip, FormatAccount:
+		return "application/zip"
+	}
+	return ""
+}
+
+// IsValidFormat reports whether format is a supported single-result export format
+func IsValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatJSON || format == FormatPDF
+}
+
+// Write renders records to w in the given format
+func Write(w io.Writer, format string, records []Record) error {
+	switch format {
+	case FormatCSV:
+		return WriteCSV(w, records)
+	case FormatJSON:
+		return WriteJSON(w, records)
+	case FormatPDF:
+		return WritePDF(w, "Processing Report", records)
+	case FormatZip:
+		return WriteZip(w, records)
+	}
+	return fmt.Errorf("unsupported export format: %s", format)
+}
+
+// WriteCSV writes records as CSV with a header row
+func WriteCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"file_id", "file_name", "s3_key", "uploaded_at", "status", "result", "processed_at"}); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		row := []string{rec.FileID, csvCell(rec.FileName), csvCell(rec.S3Key), formatTime(rec.UploadedAt), rec.Status, csvCell(rec.Result), formatTime(rec.ProcessedAt)}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvCell returns a user-supplied value for a CSV cell. Values spreadsheets
+// would run as a formula are prefixed with a quote so they are shown as text.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// WriteJSON writes a single record as a JSON object, or several as an array
+func WriteJSON(w io.Writer, records []Record) error {
+	enc := json.NewEncoder(w)
+	if len(records) == 1 {
+		return enc.Encode(records[0])
+	}
+	return enc.Encode(records)
+}
+
+// WriteZip writes a zip archive containing the records as files.csv and results.json
+func WriteZip(w io.Writer, records []Record) error {
+	zw := zip.NewWriter(w)
+
+	csvFile, err := zw.Create("files.csv")
+	if err != nil {
+		return err
+	}
+	if err := WriteCSV(csvFile, records); err != nil {
+		return err
+	}
+
+	jsonFile, err := zw.Create("results.json")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(jsonFile).Encode(records); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// Document is one JSON file in an archive
+type Document struct {
+	Name string
+	Data interface{}
+}
+
+// WriteArchive writes a zip archive with each document encoded as indented
+// JSON under its name
+func WriteArchive(w io.Writer, docs []Document) error {
+	zw := zip.NewWriter(w)
+	for _, doc := range docs {
+		f, err := zw.Create(doc.Name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(doc.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// formatTime formats t as RFC 3339, leaving unset times empty
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
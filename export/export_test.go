@@ -0,0 +1,120 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRecords = []Record{
+	{
+		FileID:      "f1",
+		FileName:    "report.txt",
+		S3Key:       "files/f1/report.txt",
+		UploadedAt:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Status:      "completed",
+		Result:      "Word count: 3",
+		ProcessedAt: time.Date(2024, 1, 2, 3, 5, 0, 0, time.UTC),
+	},
+	{FileID: "f2", FileName: "=HYPERLINK(\"http://evil\")", Status: "pending", Result: "-2+3"},
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatCSV, testRecords))
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, []string{"file_id", "file_name", "s3_key", "uploaded_at", "status", "result", "processed_at"}, rows[0])
+	assert.Equal(t, []string{"f1", "report.txt", "files/f1/report.txt", "2024-01-02T03:04:05Z", "completed", "Word count: 3", "2024-01-02T03:05:00Z"}, rows[1])
+
+	// Values a spreadsheet would evaluate are written as text, unset times
+	// are left empty
+	assert.Equal(t, []string{"f2", "'=HYPERLINK(\"http://evil\")", "", "", "pending", "'-2+3", ""}, rows[2])
+}
+
+func TestCSVCell(t *testing.T) {
+	for _, v := range []string{"=1+1", "+1", "-1", "@SUM(A1)", "\tx", "\rx"} {
+		assert.Equal(t, "'"+v, csvCell(v))
+	}
+	for _, v := range []string{"", "a=b", "report.txt", "1-2"} {
+		assert.Equal(t, v, csvCell(v))
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var one bytes.Buffer
+	require.NoError(t, Write(&one, FormatJSON, testRecords[:1]))
+	var rec Record
+	require.NoError(t, json.Unmarshal(one.Bytes(), &rec))
+	assert.Equal(t, testRecords[0], rec)
+
+	var many bytes.Buffer
+	require.NoError(t, Write(&many, FormatJSON, testRecords))
+	var recs []Record
+	require.NoError(t, json.Unmarshal(many.Bytes(), &recs))
+	assert.Len(t, recs, 2)
+	assert.Equal(t, "=HYPERLINK(\"http://evil\")", recs[1].FileName, "JSON keeps values as they are")
+}
+
+func TestWriteZip(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatZip, testRecords))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 2)
+	assert.Equal(t, "files.csv", zr.File[0].Name)
+	assert.Equal(t, "results.json", zr.File[1].Name)
+
+	f, err := zr.File[1].Open()
+	require.NoError(t, err)
+	defer f.Close()
+	var recs []Record
+	require.NoError(t, json.NewDecoder(f).Decode(&recs))
+	assert.Len(t, recs, 2)
+}
+
+func TestWritePDF(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatPDF, testRecords))
+	pdf := buf.String()
+
+	assert.True(t, strings.HasPrefix(pdf, "%PDF-1.4\n"))
+	assert.True(t, strings.HasSuffix(pdf, "%%EOF\n"))
+	assert.Contains(t, pdf, "(File: report.txt) Tj")
+	assert.Contains(t, pdf, "(File: =HYPERLINK\\(\"http://evil\"\\)) Tj", "parentheses are escaped")
+
+	// Every cross-reference entry points at the object it names
+	xref := pdf[strings.LastIndex(pdf, "xref\n"):]
+	lines := strings.Split(xref, "\n")
+	for i, line := range lines[3:] {
+		if !strings.HasSuffix(line, " n ") {
+			break
+		}
+		off, err := strconv.Atoi(line[:10])
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(pdf[off:], strconv.Itoa(i+1)+" 0 obj"), "object %d", i+1)
+	}
+}
+
+func TestWriteUnsupportedFormat(t *testing.T) {
+	assert.Error(t, Write(io.Discard, "xml", testRecords))
+	assert.False(t, IsValidFormat(FormatZip))
+	assert.Equal(t, "text/csv", ContentType(FormatCSV))
+}
+
+func TestWrapText(t *testing.T) {
+	assert.Equal(t, []string{"one two", "three"}, wrapText("one two three", 8))
+	assert.Equal(t, []string{"abcd", "ef"}, wrapText("abcdef", 4))
+}
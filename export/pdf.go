@@ -0,0 +1,148 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	pdfLinesPerPage = 50
+	pdfLineWidth    = 90
+)
+
+// countingWriter tracks the byte offset needed for the PDF cross-reference table
+type countingWriter struct {
+	w   io.Writer
+	n   int
+	err error
+}
+
+func (cw *countingWriter) printf(format string, args ...interface{}) {
+	if cw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(cw.w, format, args...)
+	cw.n += n
+	cw.err = err
+}
+
+// WritePDF renders records as a simple text report. The document is written
+// directly to w without buffering; pages are split every pdfLinesPerPage lines.
+func WritePDF(w io.Writer, title string, records []Record) error {
+	lines := []string{title, "Generated " + time.Now().UTC().Format(time.RFC3339), ""}
+	for _, rec := range records {
+		lines = append(lines,
+			"File: "+rec.FileName,
+			"File ID: "+rec.FileID,
+			"Status: "+rec.Status,
+			"Processed at: "+formatTime(rec.ProcessedAt),
+			"Result:",
+		)
+		lines = append(lines, wrapText(rec.Result, pdfLineWidth)...)
+		lines = append(lines, "")
+	}
+
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	// Object layout: 1 catalog, 2 page tree, 3 font, then a page and a
+	// content stream object for every page
+	cw := &countingWriter{w: w}
+	offsets := []int{}
+	startObj := func() {
+		offsets = append(offsets, cw.n)
+		cw.printf("%d 0 obj\n", len(offsets))
+	}
+
+	cw.printf("%%PDF-1.4\n")
+
+	startObj()
+	cw.printf("<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+
+	startObj()
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+i*2)
+	}
+	cw.printf("<< /Type /Pages /Kids [%s] /Count %d >>\nendobj\n", strings.Join(kids, " "), len(pages))
+
+	startObj()
+	cw.printf("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>\nendobj\n")
+
+	for i, page := range pages {
+		startObj()
+		cw.printf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>\nendobj\n", 5+i*2)
+
+		var stream strings.Builder
+		stream.WriteString("BT\n/F1 10 Tf\n14 TL\n50 750 Td\n")
+		for _, line := range page {
+			fmt.Fprintf(&stream, "(%s) Tj T*\n", escapePDFText(line))
+		}
+		stream.WriteString("ET\n")
+
+		startObj()
+		cw.printf("<< /Length %d >>\nstream\n%sendstream\nendobj\n", stream.Len(), stream.String())
+	}
+
+	xref := cw.n
+	cw.printf("xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		cw.printf("%010d 00000 n \n", off)
+	}
+	cw.printf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return cw.err
+}
+
+// escapePDFText escapes a line for a PDF string literal, replacing characters
+// the standard Helvetica encoding can't show
+func escapePDFText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteRune('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// wrapText splits text into lines of at most width characters, breaking on
+// whitespace where possible
+func wrapText(text string, width int) []string {
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			for len(word) > width {
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				lines = append(lines, word[:width])
+				word = word[width:]
+			}
+			if line == "" {
+				line = word
+			} else if len(line)+1+len(word) <= width {
+				line += " " + word
+			} else {
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
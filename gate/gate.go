@@ -0,0 +1,98 @@
+// Package gate checks processing results before they are published. A gate
+// can veto a result, such as one containing personal data or not matching
+// the expected schema; the worker then quarantines the result for an
+// administrator to review instead of publishing it.
+package gate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Input is what a gate checks: a file's processing result and the content
+// it was produced from
+type Input struct {
+	FileID      string
+	Name        string
+	ContentType string
+	Content     []byte
+	Result      string
+}
+
+// Veto is a gate's reason to withhold a result
+type Veto struct {
+	Gate   string
+	Reason string
+}
+
+// Gate checks results before they are published
+type Gate interface {
+	// Name identifies the gate in RESULT_GATES and in vetoes, e.g. "pii"
+	Name() string
+	// Check returns a reason to withhold the result, or "" to let it
+	// through. An error means the result couldn't be checked.
+	Check(ctx context.Context, in Input) (string, error)
+}
+
+// Builder creates a gate from the AWS configuration and the environment
+type Builder func(cfg aws.Config) (Gate, error)
+
+var (
+	buildersMu sync.RWMutex
+	builders   = map[string]Builder{
+		"pii":    newPIIGate,
+		"schema": newSchemaGate,
+	}
+)
+
+// Register makes a gate available to RESULT_GATES by name
+func Register(name string, build Builder) {
+	buildersMu.Lock()
+	defer buildersMu.Unlock()
+	builders[name] = build
+}
+
+// New returns the gates named in RESULT_GATES, comma-separated, in that
+// order. None are configured by default.
+func New(cfg aws.Config) ([]Gate, error) {
+	buildersMu.RLock()
+	defer buildersMu.RUnlock()
+	var gates []Gate
+	for _, name := range strings.Split(os.Getenv("RESULT_GATES"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		build, ok := builders[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown result gate %q", name)
+		}
+		g, err := build(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("result gate %s: %v", name, err)
+		}
+		gates = append(gates, g)
+	}
+	return gates, nil
+}
+
+// Run checks a result with each gate in turn and returns the first veto, or
+// nil if every gate let the result through. A gate failing stops the check,
+// so a result is never published unchecked.
+func Run(ctx context.Context, gates []Gate, in Input) (*Veto, error) {
+	for _, g := range gates {
+		reason, err := g.Check(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("gate %s: %v", g.Name(), err)
+		}
+		if reason != "" {
+			return &Veto{Gate: g.Name(), Reason: reason}, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,155 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/golang-aws-api/comprehend"
+)
+
+type fixedGate struct {
+	name   string
+	reason string
+	err    error
+}
+
+func (g fixedGate) Name() string { return g.name }
+
+func (g fixedGate) Check(context.Context, Input) (string, error) { return g.reason, g.err }
+
+func TestRunStopsAtFirstVeto(t *testing.T) {
+	ctx := context.Background()
+	veto, err := Run(ctx, []Gate{fixedGate{name: "a"}, fixedGate{name: "b", reason: "bad"}, fixedGate{name: "c", reason: "worse"}}, Input{})
+	require.NoError(t, err)
+	assert.Equal(t, &Veto{Gate: "b", Reason: "bad"}, veto)
+
+	veto, err = Run(ctx, []Gate{fixedGate{name: "a"}}, Input{})
+	assert.NoError(t, err)
+	assert.Nil(t, veto)
+
+	_, err = Run(ctx, []Gate{fixedGate{name: "a", err: errors.New("down")}, fixedGate{name: "b", reason: "bad"}}, Input{})
+	assert.ErrorContains(t, err, "gate a: down")
+}
+
+func TestNew(t *testing.T) {
+	t.Setenv("RESULT_GATES", "schema, custom")
+	t.Setenv("RESULT_SCHEMA", `{"type":"object"}`)
+	Register("custom", func(aws.Config) (Gate, error) { return fixedGate{name: "custom"}, nil })
+
+	gates, err := New(aws.Config{})
+	require.NoError(t, err)
+	require.Len(t, gates, 2)
+	assert.Equal(t, "schema", gates[0].Name())
+	assert.Equal(t, "custom", gates[1].Name())
+
+	t.Setenv("RESULT_GATES", "antivirus")
+	_, err = New(aws.Config{})
+	assert.Error(t, err)
+
+	t.Setenv("RESULT_GATES", "")
+	gates, err = New(aws.Config{})
+	assert.NoError(t, err)
+	assert.Empty(t, gates)
+}
+
+func TestSchemaGate(t *testing.T) {
+	t.Setenv("RESULT_SCHEMA", `{
+		"type": "object",
+		"required": ["lines"],
+		"additionalProperties": false,
+		"properties": {
+			"lines": {"type": "integer", "minimum": 0},
+			"language": {"type": ["string", "null"], "enum": ["en", "de", null]},
+			"tags": {"type": "array", "items": {"type": "string", "maxLength": 3}}
+		}
+	}`)
+	g, err := newSchemaGate(aws.Config{})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	for result, want := range map[string]string{
+		`{"lines": 3, "language": "en", "tags": ["a"]}`: "",
+		`{"lines": 3, "language": null}`:                "",
+		`lines: 3`:                                      "Result is not JSON",
+		`{"language": "en"}`:                            `$ is missing required property "lines"`,
+		`{"lines": 1.5}`:                                "$.lines should be of type integer",
+		`{"lines": -1}`:                                 "$.lines should be at least 0",
+		`{"lines": 1, "language": "fr"}`:                "$.language should be one of the enumerated values",
+		`{"lines": 1, "tags": ["abcd"]}`:                "$.tags[0] should be at most 3 characters",
+		`{"lines": 1, "words": 2}`:                      `$ has unexpected property "words"`,
+	} {
+		reason, err := g.Check(ctx, Input{Result: result})
+		assert.NoError(t, err)
+		assert.Contains(t, reason, want, result)
+		if want == "" {
+			assert.Empty(t, reason, result)
+		}
+	}
+
+	t.Setenv("RESULT_SCHEMA", "")
+	_, err = newSchemaGate(aws.Config{})
+	assert.Error(t, err)
+}
+
+func TestPIIGate(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "Comprehend_20171127.DetectPiiEntities", r.Header.Get("X-Amz-Target"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-east-1/comprehend/aws4_request")
+		var in struct {
+			Text         string
+			LanguageCode string
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		assert.Equal(t, "en", in.LanguageCode)
+		var entities []map[string]interface{}
+		if strings.Contains(in.Text, "123-45-6789") {
+			entities = append(entities, map[string]interface{}{"Type": "SSN", "Score": 0.99})
+		}
+		if strings.Contains(in.Text, "Jane") {
+			entities = append(entities, map[string]interface{}{"Type": "NAME", "Score": 0.5})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Entities": entities})
+	}))
+	defer srv.Close()
+
+	g, err := newPIIGate(aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test", "test", ""),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, _ ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: srv.URL}, nil
+		}),
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	reason, err := g.Check(ctx, Input{Result: "Jane's SSN is 123-45-6789"})
+	require.NoError(t, err)
+	assert.Equal(t, "Personal data detected: SSN", reason)
+
+	// Below the minimum score
+	reason, err = g.Check(ctx, Input{Result: "Jane"})
+	require.NoError(t, err)
+	assert.Empty(t, reason)
+
+	requests = 0
+	reason, err = g.Check(ctx, Input{})
+	require.NoError(t, err)
+	assert.Empty(t, reason)
+	assert.Zero(t, requests)
+
+	reason, err = g.Check(ctx, Input{Result: strings.Repeat("a", piiMaxChunks*comprehend.MaxTextBytes+1)})
+	require.NoError(t, err)
+	assert.Contains(t, reason, "too long")
+	assert.Zero(t, requests)
+}
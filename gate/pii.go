@@ -0,0 +1,89 @@
+package gate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/yourusername/golang-aws-api/comprehend"
+)
+
+// piiMaxChunks caps the Comprehend requests per result; a longer result is
+// vetoed rather than published partly checked
+const piiMaxChunks = 10
+
+// piiGate vetoes results in which Amazon Comprehend detects personal data.
+// It is configured with PII_GATE_MIN_SCORE, the confidence from which an
+// entity counts (default 0.8), PII_GATE_ENTITY_TYPES, the comma-separated
+// Comprehend entity types that count (default all), and PII_GATE_LANGUAGE
+// (default en).
+type piiGate struct {
+	client   *comprehend.Client
+	minScore float64
+	types    map[string]bool
+	language string
+}
+
+func newPIIGate(cfg aws.Config) (Gate, error) {
+	g := &piiGate{
+		client:   comprehend.New(cfg),
+		minScore: 0.8,
+		language: "en",
+	}
+	if s := os.Getenv("PII_GATE_MIN_SCORE"); s != "" {
+		score, err := strconv.ParseFloat(s, 64)
+		if err != nil || score < 0 || score > 1 {
+			return nil, fmt.Errorf("PII_GATE_MIN_SCORE must be between 0 and 1")
+		}
+		g.minScore = score
+	}
+	if s := os.Getenv("PII_GATE_ENTITY_TYPES"); s != "" {
+		g.types = make(map[string]bool)
+		for _, t := range strings.Split(s, ",") {
+			g.types[strings.ToUpper(strings.TrimSpace(t))] = true
+		}
+	}
+	if s := os.Getenv("PII_GATE_LANGUAGE"); s != "" {
+		g.language = s
+	}
+	return g, nil
+}
+
+func (g *piiGate) Name() string {
+	return "pii"
+}
+
+// Check vetoes a result naming the types of personal data found, never the
+// data itself
+func (g *piiGate) Check(ctx context.Context, in Input) (string, error) {
+	chunks := comprehend.SplitText(strings.ToValidUTF8(in.Result, ""), comprehend.MaxTextBytes)
+	if len(chunks) > piiMaxChunks {
+		return fmt.Sprintf("Result too long to check for personal data (over %d bytes)", piiMaxChunks*comprehend.MaxTextBytes), nil
+	}
+
+	found := make(map[string]bool)
+	for _, chunk := range chunks {
+		entities, err := g.client.DetectPIIEntities(ctx, chunk, g.language)
+		if err != nil {
+			return "", err
+		}
+		for _, e := range entities {
+			if e.Score >= g.minScore && (g.types == nil || g.types[e.Type]) {
+				found[e.Type] = true
+			}
+		}
+	}
+	if len(found) == 0 {
+		return "", nil
+	}
+	types := make([]string, 0, len(found))
+	for t := range found {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return "Personal data detected: " + strings.Join(types, ", "), nil
+}
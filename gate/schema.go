@@ -0,0 +1,156 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// schemaGate vetoes results that aren't JSON matching the JSON Schema in
+// RESULT_SCHEMA. The keywords supported are type, enum, required,
+// properties, additionalProperties (as a boolean), items, minimum, maximum,
+// minLength and maxLength; others are ignored.
+type schemaGate struct {
+	schema map[string]interface{}
+}
+
+func newSchemaGate(aws.Config) (Gate, error) {
+	raw := os.Getenv("RESULT_SCHEMA")
+	if raw == "" {
+		return nil, errors.New("RESULT_SCHEMA is required for the schema gate")
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &schema); err != nil {
+		return nil, fmt.Errorf("RESULT_SCHEMA isn't a JSON object: %v", err)
+	}
+	return &schemaGate{schema: schema}, nil
+}
+
+func (g *schemaGate) Name() string {
+	return "schema"
+}
+
+func (g *schemaGate) Check(_ context.Context, in Input) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(in.Result), &v); err != nil {
+		return "Result is not JSON", nil
+	}
+	if err := validate(g.schema, v, "$"); err != nil {
+		return "Result does not match the schema: " + err.Error(), nil
+	}
+	return "", nil
+}
+
+// validate checks v, found at path, against schema
+func validate(schema map[string]interface{}, v interface{}, path string) error {
+	if t, ok := schema["type"]; ok && !matchesType(t, v) {
+		return fmt.Errorf("%s should be of type %v", path, t)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if equalJSON(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s should be one of the enumerated values", path)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				name, _ := r.(string)
+				if _, ok := v[name]; !ok {
+					return fmt.Errorf("%s is missing required property %q", path, name)
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sub, ok := props[name].(map[string]interface{})
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("%s has unexpected property %q", path, name)
+				}
+				continue
+			}
+			if err := validate(sub, v[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			return fmt.Errorf("%s should be at least %v", path, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && v > max {
+			return fmt.Errorf("%s should be at most %v", path, max)
+		}
+	case string:
+		n := len([]rune(v))
+		if min, ok := schema["minLength"].(float64); ok && float64(n) < min {
+			return fmt.Errorf("%s should be at least %v characters", path, min)
+		}
+		if max, ok := schema["maxLength"].(float64); ok && float64(n) > max {
+			return fmt.Errorf("%s should be at most %v characters", path, max)
+		}
+	}
+	return nil
+}
+
+// matchesType reports whether v has the JSON Schema type t, a name or a list
+// of names
+func matchesType(t interface{}, v interface{}) bool {
+	if list, ok := t.([]interface{}); ok {
+		for _, name := range list {
+			if matchesType(name, v) {
+				return true
+			}
+		}
+		return false
+	}
+	name, _ := t.(string)
+	switch v := v.(type) {
+	case nil:
+		return name == "null"
+	case bool:
+		return name == "boolean"
+	case float64:
+		return name == "number" || (name == "integer" && v == float64(int64(v)))
+	case string:
+		return name == "string"
+	case []interface{}:
+		return name == "array"
+	case map[string]interface{}:
+		return name == "object"
+	}
+	return false
+}
+
+// equalJSON compares two decoded JSON values
+func equalJSON(a, b interface{}) bool {
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(x) == string(y)
+}
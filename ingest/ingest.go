@@ -0,0 +1,160 @@
+// Package ingest stores files that arrive outside the upload endpoint, such as
+// SFTP drops, email attachments, uploads made with upload tokens and the
+// members of expanded archives, the same way an upload is stored: a file row,
+// the object under files/ and a processing event.
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/yourusername/golang-aws-api/contenttype"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/envelope"
+	"github.com/yourusername/golang-aws-api/queue"
+	"github.com/yourusername/golang-aws-api/quota"
+	"github.com/yourusername/golang-aws-api/settings"
+	"github.com/yourusername/golang-aws-api/storage"
+	"github.com/yourusername/golang-aws-api/tenants"
+)
+
+// ErrMaintenance is returned while maintenance mode is on
+var ErrMaintenance = errors.New("the service is down for maintenance, retry later")
+
+// Store saves ingested files to a bucket. Keys encrypts the content of users
+// whose uploads are encrypted before they reach S3.
+type Store struct {
+	S3     *storage.Store
+	Bucket string
+	Keys   envelope.KeyService
+}
+
+// MembersDir is the key segment that members of an expanded archive are
+// stored under, below their archive: files/{archiveID}/members/{id}/{path}
+const MembersDir = "members"
+
+// Save registers a file owned by userID, writes body to S3 and queues the file
+// for processing. Name clashes follow the default name policy. Quota errors
+// are *apierrors.Error values whose detail can be shown to the sender.
+func (s *Store) Save(ctx context.Context, userID, name string, body io.ReadSeeker, size int64) (*database.File, error) {
+	m, err := database.GetMaintenance()
+	if err != nil {
+		log.Printf("Error reading maintenance state: %v", err)
+	} else if m.Enabled {
+		return nil, ErrMaintenance
+	}
+
+	fileID := database.NewID()
+	return s.save(ctx, database.NewFile{
+		ID:     fileID,
+		Name:   name,
+		UserID: userID,
+		S3Key:  func(name string) string { return fmt.Sprintf("files/%s/%s", fileID, name) },
+	}, database.NamePolicy(), body, size)
+}
+
+// SaveMember stores a member of an expanded archive as a file of its own,
+// linked to the archive and owned by and in the collection of the archive's
+// owner. It is named after the member's base name, renamed on a clash, and
+// queued for processing like any other file.
+func (s *Store) SaveMember(ctx context.Context, archive *database.File, memberPath string, body io.ReadSeeker, size int64) (*database.File, error) {
+	fileID := database.NewID()
+	key := fmt.Sprintf("files/%s/%s/%s/%s", archive.ID, MembersDir, fileID, memberPath)
+	return s.save(ctx, database.NewFile{
+		ID:           fileID,
+		Name:         path.Base(memberPath),
+		UserID:       archive.UserID,
+		CollectionID: archive.CollectionID,
+		ParentID:     archive.ID,
+		S3Key:        func(string) string { return key },
+	}, database.NamePolicyRename, body, size)
+}
+
+// save stores a file described by nf, filling in its size, storage class,
+// retention, encryption and pending redaction from the owner's settings and
+// its content type from the content
+func (s *Store) save(ctx context.Context, nf database.NewFile, policy string, body io.ReadSeeker, size int64) (*database.File, error) {
+	class, err := settings.Current().StorageClass(nf.UserID, "")
+	if err != nil {
+		return nil, err
+	}
+	lock := settings.Current().Lock(nf.UserID, time.Now())
+
+	contentType, err := contenttype.DetectReader(nf.Name, body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	body, length, encryption, err := envelope.SealBody(ctx, s.Keys, settings.Current().EncryptionKey(nf.UserID), nf.ID, body, size)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting file: %v", err)
+	}
+	nf.SizeBytes, nf.StorageClass, nf.Encryption, nf.ContentType = size, class, encryption, contentType
+	nf.RetentionMode, nf.RetainUntil = lock.Mode, lock.RetainUntil
+	nf.RedactionPending, err = tenants.RedactsPII(nf.UserID, nf.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error loading pipeline: %v", err)
+	}
+	var file *database.File
+	err = database.WithTx(func(tx *sql.Tx) error {
+		if err := quota.CheckTx(tx, nf.UserID, nf.Name, size); err != nil {
+			return err
+		}
+		file, err = database.CreateFileTx(tx, nf, policy)
+		if err != nil {
+			return fmt.Errorf("error saving file metadata: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(file.S3Key),
+		Body:          body,
+		ContentLength: length,
+		ContentType:   aws.String(contentType),
+		StorageClass:  types.StorageClass(class),
+		Metadata:      encryption.Metadata(),
+	}
+	lock.Apply(input)
+	chunks, etag, err := s.S3.UploadWithChecksums(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("error uploading to S3: %v", err)
+	}
+	SaveChunks(file.ID, chunks)
+
+	// S3 event notifications can't target FIFO queues, so publish the event ourselves
+	if queue.IsFIFO() {
+		if err := queue.PublishFileEvent(ctx, file.ID, s.Bucket, file.S3Key, etag); err != nil {
+			return nil, fmt.Errorf("error publishing processing event: %v", err)
+		}
+	}
+	return file, nil
+}
+
+// SaveChunks stores the checksums of the chunks a file's object was uploaded
+// in, if it went up in parts. The upload has succeeded either way, so a
+// manifest that can't be saved is only logged.
+func SaveChunks(fileID string, chunks []storage.Chunk) {
+	if len(chunks) == 0 {
+		return
+	}
+	manifest := make([]database.Chunk, 0, len(chunks))
+	for _, c := range chunks {
+		manifest = append(manifest, database.Chunk(c))
+	}
+	if err := database.SaveFileChunks(fileID, manifest); err != nil {
+		log.Printf("Error saving chunk checksums of file %s: %v", fileID, err)
+	}
+}
@@ -0,0 +1,130 @@
+// Package ipfilter matches client addresses against sets of CIDR ranges. A
+// set is a binary trie over address bits, so a lookup costs at most one step
+// per bit of the address however many ranges the set holds.
+package ipfilter
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// node is one bit position in the trie. A node that ends a range matches
+// every address below it.
+type node struct {
+	children [2]*node
+	end      bool
+}
+
+// Set is an immutable set of IPv4 and IPv6 ranges. The zero Set is empty.
+type Set struct {
+	v4, v6 *node
+	n      int
+}
+
+// ParsePrefix parses a CIDR range, or a single address as a range of one,
+// and returns it masked to its network
+func ParsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid address or CIDR range %q", s)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR range %q", s)
+	}
+	return p.Masked(), nil
+}
+
+// Parse builds a set from CIDR ranges or single addresses
+func Parse(ranges []string) (*Set, error) {
+	prefixes := make([]netip.Prefix, 0, len(ranges))
+	for _, r := range ranges {
+		p, err := ParsePrefix(r)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	return New(prefixes), nil
+}
+
+// New builds a set from ranges
+func New(prefixes []netip.Prefix) *Set {
+	s := &Set{}
+	for _, p := range prefixes {
+		addr, bits := p.Addr(), p.Bits()
+		if addr.Is4In6() && bits >= 96 {
+			// Addresses are unmapped before lookups
+			addr, bits = addr.Unmap(), bits-96
+		}
+		root := &s.v6
+		if addr.Is4() {
+			root = &s.v4
+		}
+		if *root == nil {
+			*root = &node{}
+		}
+		n := *root
+		bytes := addr.AsSlice()
+		for i := 0; i < bits && !n.end; i++ {
+			bit := bytes[i/8] >> (7 - i%8) & 1
+			if n.children[bit] == nil {
+				n.children[bit] = &node{}
+			}
+			n = n.children[bit]
+		}
+		// A wider range replaces any narrower ones below it
+		n.end = true
+		n.children = [2]*node{}
+		s.n++
+	}
+	return s
+}
+
+// Len returns how many ranges the set was built from
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return s.n
+}
+
+// Contains reports whether addr is in any range of the set. IPv4 addresses
+// mapped into IPv6 match IPv4 ranges.
+func (s *Set) Contains(addr netip.Addr) bool {
+	if s == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	n := s.v6
+	if addr.Is4() {
+		n = s.v4
+	}
+	bytes := addr.AsSlice()
+	for i := 0; n != nil; i++ {
+		if n.end {
+			return true
+		}
+		if i == len(bytes)*8 {
+			return false
+		}
+		n = n.children[bytes[i/8]>>(7-i%8)&1]
+	}
+	return false
+}
+
+// ContainsString is Contains for an address in text form; invalid addresses
+// are in no set
+func (s *Set) ContainsString(addr string) bool {
+	a, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	return s.Contains(a)
+}
@@ -0,0 +1,58 @@
+package ipfilter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetContains(t *testing.T) {
+	s, err := Parse([]string{"10.0.0.0/8", "192.168.1.7", "2001:db8::/32"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, s.Len())
+
+	assert.True(t, s.ContainsString("10.1.2.3"))
+	assert.True(t, s.ContainsString("192.168.1.7"))
+	assert.False(t, s.ContainsString("192.168.1.8"))
+	assert.False(t, s.ContainsString("11.0.0.1"))
+	assert.True(t, s.ContainsString("2001:db8:1::1"))
+	assert.False(t, s.ContainsString("2001:db9::1"))
+	// IPv4-mapped addresses match IPv4 ranges
+	assert.True(t, s.ContainsString("::ffff:10.0.0.1"))
+	assert.False(t, s.ContainsString("not an address"))
+}
+
+func TestSetWiderRangeWins(t *testing.T) {
+	s, err := Parse([]string{"10.1.2.0/24", "10.0.0.0/8", "10.2.0.0/16"})
+	assert.NoError(t, err)
+	assert.True(t, s.ContainsString("10.200.0.1"))
+
+	s, err = Parse([]string{"0.0.0.0/0"})
+	assert.NoError(t, err)
+	assert.True(t, s.ContainsString("203.0.113.9"))
+	assert.False(t, s.ContainsString("2001:db8::1"))
+}
+
+func TestParseRejectsInvalidRanges(t *testing.T) {
+	_, err := Parse([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = Parse([]string{"example.com"})
+	assert.Error(t, err)
+
+	p, err := ParsePrefix(" 10.1.2.3/8 ")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/8", p.String())
+}
+
+func TestEmptySet(t *testing.T) {
+	var s *Set
+	assert.False(t, s.ContainsString("10.0.0.1"))
+	assert.False(t, New(nil).ContainsString("10.0.0.1"))
+}
+
+func TestSetMappedRange(t *testing.T) {
+	s, err := Parse([]string{"::ffff:10.0.0.0/104"})
+	assert.NoError(t, err)
+	assert.True(t, s.ContainsString("10.9.9.9"))
+	assert.False(t, s.ContainsString("11.0.0.1"))
+}
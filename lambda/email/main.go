@@ -0,0 +1,167 @@
+// Command email is a Lambda that ingests documents sent by email. An SES
+// receipt rule stores each inbound message in S3 and then invokes this
+// function; attachments from verified users are saved as their files and
+// processed like any upload.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/envelope"
+	"github.com/yourusername/golang-aws-api/ingest"
+	"github.com/yourusername/golang-aws-api/queue"
+	"github.com/yourusername/golang-aws-api/settings"
+	"github.com/yourusername/golang-aws-api/storage"
+)
+
+var (
+	s3Client    *storage.Store
+	store       *ingest.Store
+	emailBucket string
+	emailPrefix string
+)
+
+// setup connects to AWS and the database
+func setup() {
+	cfg, err := awsconfig.Load(context.TODO())
+	if err != nil {
+		log.Fatalf("Failed to load AWS configuration: %v", err)
+	}
+	queue.InitQueue(cfg)
+
+	bucketName := getEnv("S3_BUCKET_NAME", "my-test-bucket")
+	s3Client = storage.New(cfg, bucketName)
+	keys := envelope.NewKMS(cfg)
+	store = &ingest.Store{S3: s3Client, Bucket: bucketName, Keys: keys}
+	database.SetColumnEncryption(keys, os.Getenv("COLUMN_ENCRYPTION_KEY_ID"))
+
+	// Where the receipt rule's S3 action writes raw messages
+	emailBucket = getEnv("EMAIL_BUCKET", bucketName)
+	emailPrefix = getEnv("EMAIL_PREFIX", "inbound-email/")
+
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	settings.Init(context.Background())
+}
+
+// HandleSESEvent ingests the attachments of every received message. Messages
+// that are rejected are logged and dropped; an error is only returned for
+// failures worth retrying.
+func HandleSESEvent(ctx context.Context, event events.SimpleEmailEvent) error {
+	for _, record := range event.Records {
+		if err := handleMessage(ctx, record.SES); err != nil {
+			return fmt.Errorf("message %s: %w", record.SES.Mail.MessageID, err)
+		}
+	}
+	return nil
+}
+
+func handleMessage(ctx context.Context, ses events.SimpleEmailService) error {
+	msg, receipt := ses.Mail, ses.Receipt
+	sender := senderAddress(msg.CommonHeaders.From, msg.Source)
+
+	if reason := rejectReason(ses, sender); reason != "" {
+		log.Printf("Dropping message %s from %s: %s", msg.MessageID, sender, reason)
+		return nil
+	}
+
+	user, err := database.GetUserByEmail(sender)
+	if err != nil {
+		return fmt.Errorf("looking up sender: %v", err)
+	}
+	if user == nil || !user.Confirmed {
+		log.Printf("Dropping message %s: %s is not a verified user", msg.MessageID, sender)
+		return nil
+	}
+
+	bucket, key := emailBucket, emailPrefix+msg.MessageID
+	if receipt.Action.Type == "S3" {
+		bucket, key = receipt.Action.BucketName, receipt.Action.ObjectKey
+	}
+	obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("reading s3://%s/%s: %v", bucket, key, err)
+	}
+	defer obj.Body.Close()
+
+	attachments, err := parseAttachments(obj.Body)
+	if err != nil {
+		log.Printf("Dropping message %s from %s: %v", msg.MessageID, sender, err)
+		return nil
+	}
+	if len(attachments) == 0 {
+		log.Printf("Message %s from %s has no attachments", msg.MessageID, sender)
+		return nil
+	}
+
+	for _, a := range attachments {
+		f, err := store.Save(ctx, user.ID, a.Name, bytes.NewReader(a.Data), int64(len(a.Data)))
+		var apiErr *apierrors.Error
+		switch {
+		case err == nil:
+			log.Printf("Stored attachment from %s: id=%s, name=%s, size=%d", sender, f.ID, f.Name, len(a.Data))
+		case errors.Is(err, ingest.ErrMaintenance):
+			// Fail the invocation so Lambda retries it later
+			return err
+		case errors.As(err, &apiErr), errors.Is(err, database.ErrDuplicateFileName):
+			log.Printf("Skipping attachment %s from %s: %v", a.Name, sender, err)
+		default:
+			return fmt.Errorf("storing attachment %s: %v", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// rejectReason returns why SES's verdicts rule a message out, or "" if it
+// can be ingested. The From address alone decides who owns the files, so it
+// must be authenticated: by DMARC, or by DKIM or SPF for a domain aligned
+// with the From domain.
+func rejectReason(ses events.SimpleEmailService, sender string) string {
+	msg, receipt := ses.Mail, ses.Receipt
+	from := addressDomain(sender)
+	switch {
+	case receipt.VirusVerdict.Status == "FAIL":
+		return "virus scan failed"
+	case receipt.SpamVerdict.Status == "FAIL":
+		return "marked as spam"
+	case receipt.DMARCVerdict.Status == "FAIL":
+		return "DMARC check failed"
+	case receipt.DMARCVerdict.Status == "PASS":
+		return ""
+	case receipt.SPFVerdict.Status == "PASS" && alignedDomain(addressDomain(msg.Source), from):
+		return ""
+	case receipt.DKIMVerdict.Status == "PASS" && !msg.HeadersTruncated && dkimAligned(msg.Headers, from):
+		return ""
+	}
+	return "sender not authenticated by DMARC, or by DKIM or SPF aligned with the From domain"
+}
+
+func main() {
+	setup()
+	lambda.Start(HandleSESEvent)
+}
+
+// Helper function to get environment variables
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// maxMIMEDepth bounds how deeply nested multiparts are followed
+const maxMIMEDepth = 10
+
+// attachment is a file found in an email
+type attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+var wordDecoder = &mime.WordDecoder{}
+
+// parseAttachments reads a raw RFC 5322 message and returns its attachments.
+// A part counts as an attachment when it has a file name, from either
+// Content-Disposition or the Content-Type name parameter; message bodies and
+// inline text without a name are skipped.
+func parseAttachments(raw io.Reader) ([]attachment, error) {
+	msg, err := mail.ReadMessage(raw)
+	if err != nil {
+		return nil, fmt.Errorf("reading message: %v", err)
+	}
+	var found []attachment
+	err = walkPart(msg.Header, msg.Body, 0, &found)
+	return found, err
+}
+
+// mimeHeader is the subset of part headers walkPart needs, shared by the
+// message and multipart headers
+type mimeHeader interface {
+	Get(key string) string
+}
+
+func walkPart(h mimeHeader, body io.Reader, depth int, found *[]attachment) error {
+	if depth > maxMIMEDepth {
+		return fmt.Errorf("MIME parts nested more than %d deep", maxMIMEDepth)
+	}
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if params["boundary"] == "" {
+			return fmt.Errorf("%s part without a boundary", mediaType)
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("reading %s part: %v", mediaType, err)
+			}
+			if err := walkPart(p.Header, p, depth+1, found); err != nil {
+				return err
+			}
+		}
+	}
+
+	name := partFileName(h, params)
+	if name == "" {
+		return nil
+	}
+	data, err := io.ReadAll(decodeTransfer(h.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("decoding %s: %v", name, err)
+	}
+	*found = append(*found, attachment{Name: name, ContentType: mediaType, Data: data})
+	return nil
+}
+
+// partFileName returns the decoded base name of a part's file, or "" if it
+// has none
+func partFileName(h mimeHeader, typeParams map[string]string) string {
+	var name string
+	if _, params, err := mime.ParseMediaType(h.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = typeParams["name"]
+	}
+	if decoded, err := wordDecoder.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	// Some clients send Windows paths
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// senderAddress returns the bare address of the first From header, falling
+// back to the envelope sender
+func senderAddress(from []string, source string) string {
+	for _, f := range from {
+		if addr, err := mail.ParseAddress(f); err == nil {
+			return addr.Address
+		}
+	}
+	if addr, err := mail.ParseAddress(source); err == nil {
+		return addr.Address
+	}
+	return strings.TrimSpace(source)
+}
+
+// addressDomain returns the lowercased domain of an email address, or ""
+func addressDomain(address string) string {
+	if addr, err := mail.ParseAddress(address); err == nil {
+		address = addr.Address
+	}
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(address[i+1:], "."))
+}
+
+// alignedDomain reports whether an authenticated domain is aligned with the
+// From domain: the same domain or one of its subdomains
+func alignedDomain(domain, from string) bool {
+	if domain == "" || from == "" {
+		return false
+	}
+	return domain == from || strings.HasSuffix(domain, "."+from)
+}
+
+// dkimAligned reports whether the message is DKIM-signed only by domains
+// aligned with the From domain. SES's DKIM verdict doesn't say which
+// signature passed, so any unaligned signature rules the verdict out.
+func dkimAligned(headers []events.SimpleEmailHeader, from string) bool {
+	signed := false
+	for _, h := range headers {
+		if !strings.EqualFold(h.Name, "DKIM-Signature") {
+			continue
+		}
+		if !alignedDomain(dkimDomain(h.Value), from) {
+			return false
+		}
+		signed = true
+	}
+	return signed
+}
+
+// dkimDomain returns the signing domain, the d= tag, of a DKIM-Signature
+// header
+func dkimDomain(signature string) string {
+	for _, tag := range strings.Split(signature, ";") {
+		name, value, ok := strings.Cut(tag, "=")
+		if ok && strings.TrimSpace(name) == "d" {
+			return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(value), "."))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+const testEmail = "From: Alice <alice@example.com>\r\n" +
+	"To: upload@files.example.com\r\n" +
+	"Subject: Reports\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"See attached.\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>See attached.</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/csv\r\n" +
+	"Content-Disposition: attachment; filename=\"q1.csv\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"YSxiCjEs\r\n" +
+	"Mgo=\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain; name=\"=?UTF-8?Q?r=C3=A9sum=C3=A9.txt?=\"\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"caf=C3=A9\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=\"C:\\\\Users\\\\alice\\\\report.pdf\"\r\n" +
+	"\r\n" +
+	"%PDF\r\n" +
+	"--outer--\r\n"
+
+func TestParseAttachments(t *testing.T) {
+	attachments, err := parseAttachments(strings.NewReader(testEmail))
+	assert.NoError(t, err)
+	if !assert.Len(t, attachments, 3) {
+		return
+	}
+
+	assert.Equal(t, "q1.csv", attachments[0].Name)
+	assert.Equal(t, "text/csv", attachments[0].ContentType)
+	assert.Equal(t, "a,b\n1,2\n", string(attachments[0].Data))
+
+	assert.Equal(t, "résumé.txt", attachments[1].Name)
+	assert.Equal(t, "café", string(attachments[1].Data))
+
+	assert.Equal(t, "report.pdf", attachments[2].Name)
+	assert.Equal(t, "%PDF", string(attachments[2].Data))
+}
+
+func TestParseAttachmentsPlainMessage(t *testing.T) {
+	attachments, err := parseAttachments(strings.NewReader("From: alice@example.com\r\nSubject: hi\r\n\r\nNo files here.\r\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, attachments)
+}
+
+func TestSenderAddress(t *testing.T) {
+	assert.Equal(t, "alice@example.com", senderAddress([]string{"Alice <alice@example.com>"}, "bounce@example.net"))
+	assert.Equal(t, "bounce@example.net", senderAddress(nil, "bounce@example.net"))
+}
+
+func TestRejectReason(t *testing.T) {
+	pass := events.SimpleEmailVerdict{Status: "PASS"}
+	fail := events.SimpleEmailVerdict{Status: "FAIL"}
+	message := func(source string, signers ...string) events.SimpleEmailMessage {
+		m := events.SimpleEmailMessage{Source: source}
+		for _, d := range signers {
+			m.Headers = append(m.Headers, events.SimpleEmailHeader{Name: "DKIM-Signature", Value: "v=1; a=rsa-sha256; d=" + d + "; s=mail; b=abc"})
+		}
+		return m
+	}
+	reason := func(mail events.SimpleEmailMessage, receipt events.SimpleEmailReceipt) string {
+		return rejectReason(events.SimpleEmailService{Mail: mail, Receipt: receipt}, "alice@example.com")
+	}
+
+	assert.Empty(t, reason(message("x@attacker.test"), events.SimpleEmailReceipt{DMARCVerdict: pass}))
+	assert.Empty(t, reason(message("bounce@mail.example.com"), events.SimpleEmailReceipt{SPFVerdict: pass}))
+	assert.Empty(t, reason(message("x@attacker.test", "Example.com"), events.SimpleEmailReceipt{DKIMVerdict: pass, SPFVerdict: fail}))
+
+	// Passing checks for the attacker's own domain don't authenticate the From address
+	assert.NotEmpty(t, reason(message("x@attacker.test"), events.SimpleEmailReceipt{SPFVerdict: pass}))
+	assert.NotEmpty(t, reason(message("x@attacker.test", "attacker.test"), events.SimpleEmailReceipt{DKIMVerdict: pass}))
+	assert.NotEmpty(t, reason(message("x@attacker.test", "example.com", "attacker.test"), events.SimpleEmailReceipt{DKIMVerdict: pass}))
+	assert.NotEmpty(t, reason(message("x@notexample.com"), events.SimpleEmailReceipt{SPFVerdict: pass}))
+
+	assert.NotEmpty(t, reason(message("bounce@example.com"), events.SimpleEmailReceipt{DKIMVerdict: fail, SPFVerdict: fail}))
+	assert.NotEmpty(t, reason(message("bounce@example.com"), events.SimpleEmailReceipt{SPFVerdict: pass, DMARCVerdict: fail}))
+	assert.NotEmpty(t, reason(message("bounce@example.com"), events.SimpleEmailReceipt{DMARCVerdict: pass, VirusVerdict: fail}))
+	assert.NotEmpty(t, reason(message("bounce@example.com"), events.SimpleEmailReceipt{DMARCVerdict: pass, SpamVerdict: fail}))
+}
@@ -2,193 +2,917 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/google/uuid"
-	_ "github.com/lib/pq"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/custom"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/envelope"
+	"github.com/yourusername/golang-aws-api/gate"
+	"github.com/yourusername/golang-aws-api/ingest"
+	"github.com/yourusername/golang-aws-api/processor"
+	"github.com/yourusername/golang-aws-api/queue"
+	"github.com/yourusername/golang-aws-api/search"
+	"github.com/yourusername/golang-aws-api/settings"
+	"github.com/yourusername/golang-aws-api/storage"
+	"github.com/yourusername/golang-aws-api/tenants"
+	"github.com/yourusername/golang-aws-api/wasm"
 )
 
+// defaultExpandConcurrency is how many members of an archive are stored at
+// once unless ARCHIVE_EXPAND_CONCURRENCY says otherwise
+const defaultExpandConcurrency = 8
+
 var (
-	s3Client   *s3.Client
-	bucketName string
-	db         *sql.DB
+	s3Client      *storage.Store
+	bucketName    string
+	retryPolicy   queue.RetryPolicy
+	searchBackend search.Backend
+	keyService    envelope.KeyService
+	customInvoker *custom.Invoker
+	// ingestStore stores the members of expanded archives
+	ingestStore *ingest.Store
+	// visibilityExtension is how long each heartbeat hides messages being
+	// processed for
+	visibilityExtension time.Duration
+	// resultGates check results before they are published
+	resultGates []gate.Gate
 )
 
-// S3Event represents the S3 event that triggers this Lambda
-type S3Event struct {
-	Records []struct {
-		S3 struct {
-			Bucket struct {
-				Name string `json:"name"`
-			} `json:"bucket"`
-			Object struct {
-				Key string `json:"key"`
-			} `json:"object"`
-		} `json:"s3"`
-	} `json:"Records"`
-}
-
-// ProcessingResult represents the result of file processing
-type ProcessingResult struct {
-	ID        string    `json:"id"`
-	FileID    string    `json:"file_id"`
-	Status    string    `json:"status"`
-	Result    string    `json:"result"`
-	CreatedAt time.Time `json:"created_at"`
-}
-
 func init() {
 	// Set up AWS configuration
-	customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-		if os.Getenv("ENV") == "local" {
-			return aws.Endpoint{
-				URL:           "http://localhost:4566",
-				SigningRegion: "us-east-1",
-			}, nil
-		}
-		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
-	})
-
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion("us-east-1"),
-		config.WithEndpointResolverWithOptions(customResolver),
-	)
-
-	if os.Getenv("ENV") == "local" {
-		cfg.Credentials = credentials.NewStaticCredentialsProvider("test", "test", "")
-	}
-
+	cfg, err := awsconfig.Load(context.TODO())
 	if err != nil {
 		log.Fatalf("Failed to load AWS configuration: %v", err)
 	}
 
-	s3Client = s3.NewFromConfig(cfg)
+	queue.InitQueue(cfg)
+	retryPolicy = queue.LoadRetryPolicy()
+	visibilityExtension = queue.HeartbeatExtension()
+	searchBackend = search.New()
 
 	// Set bucket name
 	bucketName = os.Getenv("S3_BUCKET_NAME")
 	if bucketName == "" {
 		bucketName = "my-test-bucket"
 	}
+	s3Client = storage.New(cfg, bucketName)
+	keyService = envelope.NewKMS(cfg)
+	customInvoker = custom.NewInvoker(cfg)
+	ingestStore = &ingest.Store{S3: s3Client, Bucket: bucketName, Keys: keyService}
+	if resultGates, err = gate.New(cfg); err != nil {
+		log.Fatalf("Failed to set up result gates: %v", err)
+	}
+	if os.Getenv("PII_PROCESSOR_COMPREHEND") == "true" {
+		processor.SetPIIDetector(newComprehendPIIDetector(cfg))
+	}
 
 	// Set up PostgreSQL connection
-	dbUser := os.Getenv("DB_USER")
-	if dbUser == "" {
-		dbUser = "postgres"
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	dbPassword := os.Getenv("DB_PASSWORD")
-	if dbPassword == "" {
-		dbPassword = "postgres"
+
+	// Load the plugin processors first, as settings may name them in pipelines
+	wasm.Watch(context.Background(), wasm.RefreshInterval())
+
+	// Load runtime settings, reloaded on SIGHUP
+	settings.Init(context.Background())
+}
+
+// HandleSQSEvent processes a batch from the event source mapping. Maintenance
+// mode isn't checked here: the API disables the mapping instead, as failing
+// batches would count towards the messages' maxReceiveCount. Batches already
+// received when it was disabled are processed as usual.
+func HandleSQSEvent(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	var response events.SQSEventResponse
+
+	// Keep the whole batch hidden while it is worked through, so messages
+	// behind a long job aren't redelivered either
+	handles := make([]string, 0, len(sqsEvent.Records))
+	for _, message := range sqsEvent.Records {
+		handles = append(handles, message.ReceiptHandle)
 	}
-	dbName := os.Getenv("DB_NAME")
-	if dbName == "" {
-		dbName = "postgres"
+	heartbeat := queue.StartHeartbeat(ctx, handles, visibilityExtension)
+	defer heartbeat.Stop()
+
+	failedGroups := make(map[string]bool)
+
+	for _, message := range sqsEvent.Records {
+		// On FIFO queues a failed message holds back the rest of its group so
+		// events for the same file are never processed out of order
+		groupID := message.Attributes["MessageGroupId"]
+		if queue.IsFIFO() && failedGroups[groupID] {
+			log.Printf("Skipping message %s: earlier message in group %s failed", message.MessageId, groupID)
+			heartbeat.Release(message.ReceiptHandle)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: message.MessageId})
+			continue
+		}
+
+		retryAfter, err := processMessage(ctx, message)
+		heartbeat.Release(message.ReceiptHandle)
+		if err != nil {
+			log.Printf("Error processing message %s, retrying in %s: %v", message.MessageId, retryAfter, err)
+
+			// Hide the message until the backoff has passed, then let SQS redeliver it
+			if err := queue.ChangeVisibility(ctx, message.ReceiptHandle, retryAfter); err != nil {
+				log.Printf("Error changing visibility of message %s: %v", message.MessageId, err)
+			}
+			failedGroups[groupID] = true
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: message.MessageId})
+		}
+	}
+
+	return response, nil
+}
+
+// maintenancePause returns how long to hold off consuming while maintenance
+// mode is on, or 0 when the worker may run
+func maintenancePause() time.Duration {
+	m, err := database.GetMaintenance()
+	if err != nil {
+		log.Printf("Error reading maintenance state: %v", err)
+		return 0
 	}
-	dbHost := os.Getenv("DB_HOST")
-	if dbHost == "" {
-		dbHost = "localhost"
+	if !m.Enabled {
+		return 0
 	}
-	dbPort := os.Getenv("DB_PORT")
-	if dbPort == "" {
-		dbPort = "5432"
+	if m.RetryAfter > 0 {
+		return m.RetryAfter
 	}
+	return time.Minute
+}
 
-	dbInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
+// processMessage processes every S3 record in a single SQS message. When a
+// record fails and has attempts left, it returns the error along with the
+// backoff to wait before the message is redelivered.
+func processMessage(ctx context.Context, message events.SQSMessage) (time.Duration, error) {
+	// Forged or altered messages are dropped rather than retried
+	if err := queue.VerifyMessage(message.Body, stringAttribute(message, queue.SignatureAttribute), stringAttribute(message, queue.SignatureTimestampAttribute)); err != nil {
+		log.Printf("Dropping message %s: %v", message.MessageId, err)
+		return 0, nil
+	}
 
-	var dbErr error
-	db, dbErr = sql.Open("postgres", dbInfo)
-	if dbErr != nil {
-		log.Fatalf("Failed to connect to database: %v", dbErr)
+	// Parse the S3 event from the SQS message
+	var s3Event queue.S3Event
+	if err := json.Unmarshal([]byte(message.Body), &s3Event); err != nil {
+		log.Printf("Error parsing S3 event: %v", err)
+		return 0, nil
 	}
-}
 
-func HandleSQSEvent(ctx context.Context, sqsEvent events.SQSEvent) error {
-	for _, message := range sqsEvent.Records {
-		// Parse the S3 event from the SQS message
-		var s3Event S3Event
-		if err := json.Unmarshal([]byte(message.Body), &s3Event); err != nil {
-			log.Printf("Error parsing S3 event: %v", err)
+	// Process each S3 record
+	key := messageKey(message)
+	if s3Event.RequeueID != "" {
+		key = "requeue:" + s3Event.RequeueID
+	}
+	var retryAfter time.Duration
+	var lastErr error
+	for _, record := range s3Event.Records {
+		objectKey := record.S3.Object.Key
+
+		fileID, err := resolveFileID(objectKey)
+		if err != nil {
+			log.Printf("Error resolving file for %s: %v", objectKey, err)
+			return retryPolicy.Delay(1), err
+		}
+		if fileID == "" {
+			log.Printf("Ignoring object that is not a registered file: %s", objectKey)
 			continue
 		}
 
-		// Process each S3 record
-		for _, record := range s3Event.Records {
-			bucketName := record.S3.Bucket.Name
-			objectKey := record.S3.Object.Key
+		err = processRecord(ctx, key, fileID, record.S3.Bucket.Name, objectKey, record.S3.Object.Size, &s3Event)
+		if err == nil {
+			continue
+		}
+		log.Printf("Error processing %s: %v", objectKey, err)
 
-			// Get file ID from the object key (format: "files/{fileID}/{filename}")
-			parts := strings.Split(objectKey, "/")
-			if len(parts) < 2 {
-				log.Printf("Invalid object key format: %s", objectKey)
-				continue
+		delay, retry := handleFailure(ctx, key, fileID, err)
+		if retry {
+			lastErr = err
+			if delay > retryAfter {
+				retryAfter = delay
 			}
-			fileID := parts[1]
+		}
+	}
+	return retryAfter, lastErr
+}
 
-			// Get file from S3
-			result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
-				Bucket: aws.String(bucketName),
-				Key:    aws.String(objectKey),
-			})
-			if err != nil {
-				log.Printf("Error getting object from S3: %v", err)
-				continue
-			}
-			defer result.Body.Close()
+// stringAttribute returns the value of a message attribute, or "" if the
+// message doesn't have it
+func stringAttribute(message events.SQSMessage, name string) string {
+	if v := message.MessageAttributes[name].StringValue; v != nil {
+		return *v
+	}
+	return ""
+}
 
-			// Process the file content (simple example)
-			content, err := io.ReadAll(result.Body)
-			if err != nil {
-				log.Printf("Error reading object content: %v", err)
-				continue
-			}
+// messageKey identifies a message across redeliveries. It is the message ID
+// SQS assigned when the message was sent, never the FIFO deduplication ID:
+// that is derived from the body, so the same event published again to
+// process a file once more would look like a message already processed.
+func messageKey(message events.SQSMessage) string {
+	return message.MessageId
+}
 
-			fileContent := string(content)
+// resolveFileID maps an object key to its file ID. Uploaded objects carry the
+// ID in their key ("files/{fileID}/{filename}"); imported objects keep their
+// original key and are looked up, as are archive members, whose key starts
+// with their archive's ID. An empty ID means the object isn't a file.
+func resolveFileID(objectKey string) (string, error) {
+	parts := strings.Split(objectKey, "/")
+	if len(parts) >= 3 && parts[0] == "files" && !(len(parts) >= 5 && parts[2] == ingest.MembersDir) {
+		return parts[1], nil
+	}
+
+	file, err := database.GetFileByS3Key(objectKey)
+	if err != nil || file == nil {
+		return "", err
+	}
+	return file.ID, nil
+}
 
-			// Simple processing - count words and characters
-			words := len(strings.Fields(fileContent))
-			chars := len(fileContent)
+// handleFailure records a failed attempt for a file from the message with the
+// given key. It returns the backoff before the next attempt, or false once the
+// file has been marked as permanently failed.
+func handleFailure(ctx context.Context, messageKey, fileID string, procErr error) (time.Duration, bool) {
+	attempts, err := database.RecordFailedAttempt(fileID, procErr.Error())
+	if err != nil {
+		// Without an attempt count, fall back to retrying with the base delay
+		log.Printf("Error recording failed attempt for file %s: %v", fileID, err)
+		return retryPolicy.Delay(1), true
+	}
+
+	if !retryPolicy.Exhausted(attempts) {
+		return retryPolicy.Delay(attempts), true
+	}
+
+	log.Printf("File %s failed after %d attempts, giving up", fileID, attempts)
+	reason := fmt.Sprintf("Processing failed after %d attempts: %v", attempts, procErr)
+	if err := saveResult(ctx, messageKey, fileID, "failed", reason, database.AttemptFailed, ""); err != nil {
+		log.Printf("Error saving failed result for file %s: %v", fileID, err)
+	}
+	return 0, false
+}
 
-			processedResult := fmt.Sprintf("Processed file with %d words and %d characters", words, chars)
+// processRecord processes a single S3 object and stores the result. size is
+// the object's size from the event, or 0 when the event didn't carry one.
+// event carries the job ID of a scheduled job, and the run of a recurring
+// schedule with the processor it asked for. messageKey identifies the
+// message, so a redelivery whose result was already saved is dropped.
+func processRecord(ctx context.Context, messageKey, fileID, bucketName, objectKey string, size int64, event *queue.S3Event) error {
+	jobID := event.JobID
+	// A schedule re-runs processing to check the file again, so it neither
+	// reuses an earlier result nor expands an archive a second time
+	rerun := event.ScheduleRunID != ""
+	processed, err := database.IsMessageProcessed(messageKey, fileID)
+	if err != nil {
+		return fmt.Errorf("error checking processed messages: %v", err)
+	}
+	if processed {
+		log.Printf("Skipping file %s: message %s was already processed", fileID, messageKey)
+		return nil
+	}
+
+	// A stuck file sent again may have been processed by its original
+	// message since, which would make this one process it twice
+	if event.RequeueID != "" {
+		prev, err := database.GetProcessingResultByFileID(fileID)
+		if err != nil {
+			return fmt.Errorf("error checking processing results: %v", err)
+		}
+		if prev != nil {
+			log.Printf("Skipping requeued file %s: it was processed in the meantime", fileID)
+			return nil
+		}
+	}
+
+	// Honour deferred and cancelled processing
+	skip, err := skipForSchedule(fileID, jobID)
+	if err != nil {
+		return err
+	}
+	if skip {
+		return nil
+	}
+
+	// Resolve the processors to run; one switched off in the settings, or
+	// not enabled for the file's owner, skips the file without retrying
+	file, err := database.GetFileByID(fileID)
+	if err != nil {
+		return fmt.Errorf("error loading file: %v", err)
+	}
+	var procs []processor.Processor
+	if event.Processor != "" {
+		procs, err = processor.Pipeline([]string{event.Processor}, objectKey)
+	} else {
+		procs, err = pipelineFor(file, objectKey)
+	}
+	if err != nil {
+		return err
+	}
+	var ownerID string
+	if file != nil {
+		ownerID = file.UserID
+	}
+	owner, err := tenants.For(ownerID)
+	if err != nil {
+		return fmt.Errorf("error loading tenant settings: %v", err)
+	}
+	for _, proc := range procs {
+		if !owner.ProcessorEnabled(proc.Name()) {
+			log.Printf("Processor %s is disabled, skipping file %s", proc.Name(), fileID)
+			return saveResult(ctx, messageKey, fileID, "skipped", fmt.Sprintf("Processor %s is disabled", proc.Name()), database.AttemptCompleted, jobID)
+		}
+	}
+
+	// Get file from S3; large objects are fetched in parallel parts. Events
+	// without a size have the object's size looked up.
+	if size == 0 {
+		size = -1
+	}
+	result, err := s3Client.Download(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	}, size)
+	if err != nil {
+		return fmt.Errorf("error getting object from S3: %v", err)
+	}
+	defer result.Body.Close()
+
+	// Read the file content, decrypting it if it was encrypted before upload
+	var stored envelope.Envelope
+	if file != nil {
+		stored = file.Encryption
+	}
+	content, err := envelope.ReadObject(ctx, keyService, fileID, stored, result)
+	if err != nil {
+		return fmt.Errorf("error reading object content: %v", err)
+	}
+
+	// A new revision with the same content as the last one gets its result
+	// instead of running the pipeline again, unless the pipeline redacts
+	// personal data: the revision needs a redacted copy of its own
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	if err := database.SetContentSHA256(fileID, checksum); err != nil {
+		log.Printf("Error recording checksum of file %s: %v", fileID, err)
+	}
+	if !rerun && !redactsPII(procs) {
+		reused, err := reuseResult(ctx, messageKey, file, checksum, content, jobID)
+		if err != nil || reused {
+			return err
+		}
+	}
+
+	// Run the pipeline, each processor under its configured timeout. The
+	// first stage to fail stops it, and every stage lands in the timeline.
+	progress := newProgressReporter(fileID, file)
+	stages, err := processor.RunPipeline(processor.WithProgress(ctx, progress.report), procs, objectKey, content)
+	progress.stop()
+	recordStages(fileID, stages)
+	var stageErr *processor.StageError
+	errors.As(err, &stageErr)
+	if errors.Is(err, processor.ErrTimeout) {
+		// A hung processor is likely to hang again, so record the timeout instead of retrying
+		log.Printf("Processor %s timed out on file %s", stageErr.Processor, fileID)
+		if err := saveResult(ctx, messageKey, fileID, "timeout", fmt.Sprintf("Processor %s: %v", stageErr.Processor, stageErr.Err), database.AttemptFailed, ""); err != nil {
+			return fmt.Errorf("error saving timeout result: %v", err)
+		}
+		return nil
+	}
+	if errors.Is(err, processor.ErrUnsupported) || errors.Is(err, processor.ErrArchiveLimit) {
+		// The file won't change, so a retry can't succeed either
+		status := "unsupported"
+		if errors.Is(err, processor.ErrArchiveLimit) {
+			status = "rejected"
+		}
+		log.Printf("Processor %s can't read file %s: %v", stageErr.Processor, fileID, stageErr.Err)
+		if err := saveResult(ctx, messageKey, fileID, status, fmt.Sprintf("Processor %s: %v", stageErr.Processor, stageErr.Err), database.AttemptFailed, ""); err != nil {
+			return fmt.Errorf("error saving %s result: %v", status, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("processor %s failed: %v", stageErr.Processor, stageErr.Err)
+	}
+
+	// Keep the file searchable, without the personal data the pipeline may
+	// have found; a failure here shouldn't fail processing
+	if err := indexFile(ctx, fileID, searchableContent(stages, content)); err != nil {
+		log.Printf("Error indexing file %s for search: %v", fileID, err)
+	}
+	if err := saveTextStats(fileID, stages); err != nil {
+		log.Printf("Error saving text statistics of file %s: %v", fileID, err)
+	}
+	// Without its redacted copy the file would be served as it is, so a
+	// failure here has the file retried
+	if err := saveRedactedCopy(ctx, file, stages, content); err != nil {
+		return fmt.Errorf("error saving redacted copy: %v", err)
+	}
+
+	// Store result in database. An archive to expand stays expanding until
+	// its members have been processed.
+	summary := processor.Summary(stages)
+	status := "completed"
+	if file != nil && file.ExpandArchive && !rerun {
+		status = database.StatusExpanding
+	}
+
+	// A result a gate vetoes is quarantined for review instead of published,
+	// and goes no further. A gate that can't check it has the file retried.
+	in := gate.Input{FileID: fileID, Name: objectKey, Content: content, Result: summary}
+	if file != nil {
+		in.Name, in.ContentType = file.Name, file.ContentType
+	}
+	veto, err := gate.Run(ctx, resultGates, in)
+	if err != nil {
+		return fmt.Errorf("error checking result: %v", err)
+	}
+	if veto != nil {
+		log.Printf("Gate %s withheld the result of file %s: %s", veto.Gate, fileID, veto.Reason)
+		err := storeResult(ctx, messageKey, fileID, database.StatusQuarantined, database.AttemptCompleted, jobID, func(tx *sql.Tx) error {
+			return database.QuarantineResultTx(tx, fileID, summary, veto.Gate, veto.Reason)
+		})
+		if err != nil {
+			return fmt.Errorf("error quarantining processing result: %v", err)
+		}
+		return nil
+	}
+
+	if err := saveResult(ctx, messageKey, fileID, status, summary, database.AttemptCompleted, jobID); err != nil {
+		return fmt.Errorf("error saving processing result: %v", err)
+	}
+
+	// Store the members of an archive uploaded with expand_archive as files
+	// of their own, each processed in parallel through the queue. Members
+	// that couldn't be stored are left out of the archive's final result.
+	if status == database.StatusExpanding {
+		members, err := expandArchive(ctx, file, content)
+		if err != nil {
+			log.Printf("Error expanding archive %s: %v", fileID, err)
+		}
+		if err := finishExpansion(ctx, fileID, members); err != nil {
+			return fmt.Errorf("error recording expansion of archive %s: %v", fileID, err)
+		}
+	}
 
-			// Store result in database
-			processingResult := ProcessingResult{
-				ID:        uuid.New().String(),
-				FileID:    fileID,
-				Status:    "completed",
-				Result:    processedResult,
-				CreatedAt: time.Now(),
+	// Hand the file to the owner's own function, if they registered one
+	if err := runCustomProcessor(ctx, messageKey, fileID, bucketName, objectKey, summary); err != nil {
+		log.Printf("Error running custom processor for file %s: %v", fileID, err)
+	}
+
+	log.Printf("Successfully processed file %s", objectKey)
+	return nil
+}
+
+// saveResult stores a processing result for a file together with the file's
+// attempt status and, for scheduled runs, the completed job in one transaction.
+// The message is marked processed in the same transaction, so when another
+// delivery of it got there first nothing is written. Once saved, a completion
+// event is sent to the results queue. A result of at least the offload
+// threshold is stored in S3 first, and deleted again if it isn't saved. An
+// expanding archive's result is always kept in its row, as the archive's
+// final result includes it.
+func saveResult(ctx context.Context, messageKey, fileID, status, result, attemptStatus, jobID string) error {
+	stored := storedResult{result: result}
+	if status != database.StatusExpanding {
+		var err error
+		stored, err = offloadResult(ctx, fileID, result)
+		if err != nil {
+			return fmt.Errorf("error offloading result: %v", err)
+		}
+	}
+	saved := false
+	err := storeResult(ctx, messageKey, fileID, status, attemptStatus, jobID, func(tx *sql.Tx) error {
+		saved = true
+		return stored.saveTx(tx, fileID, status)
+	})
+	if err != nil || !saved {
+		stored.discard(ctx)
+	}
+	return err
+}
+
+// storeResult is saveResult with the result written by save
+func storeResult(ctx context.Context, messageKey, fileID, status, attemptStatus, jobID string, save func(tx *sql.Tx) error) error {
+	var archive *database.ArchiveProgress
+	err := database.WithTx(func(tx *sql.Tx) error {
+		if err := database.MarkMessageProcessedTx(tx, messageKey, fileID); err != nil {
+			return err
+		}
+		if err := save(tx); err != nil {
+			return err
+		}
+		if err := database.DeleteProcessingProgressTx(tx, fileID); err != nil {
+			return err
+		}
+		if err := database.SetAttemptStatusTx(tx, fileID, attemptStatus); err != nil {
+			return err
+		}
+		if err := database.RecordEventTx(tx, database.EventFileProcessed, fileID, "", map[string]string{"status": status}); err != nil {
+			return err
+		}
+		if jobID != "" {
+			if err := database.UpdateScheduledJobStatusTx(tx, jobID, database.ScheduledJobCompleted); err != nil {
+				return err
 			}
+		}
+		// The last member of an archive to finish finishes the archive
+		var err error
+		archive, err = database.FinishParentArchiveTx(tx, fileID)
+		if err != nil || archive == nil {
+			return err
+		}
+		return saveArchiveResultTx(ctx, tx, archive)
+	})
+	if errors.Is(err, database.ErrAlreadyProcessed) {
+		log.Printf("Result for file %s from message %s was already saved", fileID, messageKey)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The result is saved either way; clients that miss the event still
+	// find it with GET /api/files/{id}/result. An expanding archive isn't
+	// announced until it is done.
+	if status != database.StatusExpanding {
+		publishResult(ctx, queue.ResultEvent{FileID: fileID, Status: status, JobID: jobID, ProcessedAt: time.Now().UTC()})
+	}
+	if archive != nil {
+		publishResult(ctx, queue.ResultEvent{FileID: archive.ArchiveID, Status: "completed", ProcessedAt: time.Now().UTC()})
+	}
+	return nil
+}
+
+func publishResult(ctx context.Context, event queue.ResultEvent) {
+	if err := queue.PublishResult(ctx, event); err != nil {
+		log.Printf("Error publishing result event for file %s: %v", event.FileID, err)
+	}
+}
+
+// archiveSummary is the final result of an expanded archive
+type archiveSummary struct {
+	// Result is the archive's own result from its pipeline
+	Result string `json:"result"`
+	// Members counts the members stored, and Statuses counts them by the
+	// status of their result
+	Members  int            `json:"members"`
+	Statuses map[string]int `json:"statuses"`
+}
+
+// saveArchiveResultTx saves the final result of an archive whose members
+// have all been processed, which ends its expanding status. A result large
+// enough to be offloaded is stored in S3 right away; should tx roll back,
+// the object is left behind with no row pointing to it.
+func saveArchiveResultTx(ctx context.Context, tx *sql.Tx, p *database.ArchiveProgress) error {
+	summary, err := json.Marshal(archiveSummary{Result: p.Result, Members: p.Members, Statuses: p.Statuses})
+	if err != nil {
+		return err
+	}
+	stored, err := offloadResult(ctx, p.ArchiveID, string(summary))
+	if err != nil {
+		return fmt.Errorf("error offloading result: %v", err)
+	}
+	if err := stored.saveTx(tx, p.ArchiveID, "completed"); err != nil {
+		return err
+	}
+	return database.RecordEventTx(tx, database.EventFileProcessed, p.ArchiveID, "", map[string]interface{}{"status": "completed", "members": p.Members})
+}
+
+// finishExpansion records how many members an archive stored, and finishes
+// the archive if they were all processed before this ran
+func finishExpansion(ctx context.Context, archiveID string, members int) error {
+	var archive *database.ArchiveProgress
+	err := database.WithTx(func(tx *sql.Tx) error {
+		if err := database.SetMemberCountTx(tx, archiveID, members); err != nil {
+			return err
+		}
+		var err error
+		archive, err = database.FinishArchiveTx(tx, archiveID)
+		if err != nil || archive == nil {
+			return err
+		}
+		return saveArchiveResultTx(ctx, tx, archive)
+	})
+	if err != nil {
+		return err
+	}
+	if archive != nil {
+		publishResult(ctx, queue.ResultEvent{FileID: archiveID, Status: "completed", ProcessedAt: time.Now().UTC()})
+	}
+	return nil
+}
+
+// reuseResult saves the result of file's previous revision as its own when
+// the content, whose SHA-256 is checksum, hasn't changed, and reports whether
+// it did. The file is still indexed, as its search document is its own.
+// Archives to expand are always processed, as their members belong to the
+// file.
+func reuseResult(ctx context.Context, messageKey string, file *database.File, checksum string, content []byte, jobID string) (bool, error) {
+	if file == nil || file.ExpandArchive || settings.Current().ReprocessUnchanged {
+		return false, nil
+	}
+	prev, err := database.ReusableResult(file, checksum)
+	if err != nil {
+		// Processing again costs more but is still correct
+		log.Printf("Error looking up a reusable result for file %s: %v", file.ID, err)
+		return false, nil
+	}
+	if prev == nil {
+		return false, nil
+	}
+
+	log.Printf("File %s is unchanged since file %s, reusing its result", file.ID, prev.FileID)
+	if err := indexFile(ctx, file.ID, content); err != nil {
+		log.Printf("Error indexing file %s for search: %v", file.ID, err)
+	}
+	if err := database.CopyTextStats(prev.FileID, file.ID); err != nil {
+		log.Printf("Error copying text statistics to file %s: %v", file.ID, err)
+	}
+	err = storeResult(ctx, messageKey, file.ID, prev.Status, database.AttemptCompleted, jobID, func(tx *sql.Tx) error {
+		return database.SaveReusedResultTx(tx, file.ID, prev)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error saving reused result: %v", err)
+	}
+	return true, nil
+}
 
-			_, err = db.Exec(
-				"INSERT INTO processing_results (id, file_id, status, result, created_at) VALUES ($1, $2, $3, $4, $5)",
-				processingResult.ID, processingResult.FileID, processingResult.Status, processingResult.Result, processingResult.CreatedAt,
-			)
+// runCustomProcessor invokes the file owner's custom processor, if they have
+// one, and stores its output as an additional result. The built-in result is
+// already saved, so a failing function is recorded rather than retried.
+// Encrypted files are skipped: the function could only read ciphertext.
+func runCustomProcessor(ctx context.Context, messageKey, fileID, bucket, objectKey, result string) error {
+	file, err := database.GetFileByID(fileID)
+	if err != nil || file == nil || file.UserID == "" {
+		return err
+	}
+	cp, err := database.GetCustomProcessor(file.UserID)
+	if err != nil || cp == nil {
+		return err
+	}
+	if file.Encryption.Encrypted() {
+		settings.Debugf("Skipping custom processor for encrypted file %s", fileID)
+		return nil
+	}
+
+	timeout := time.Duration(cp.TimeoutSeconds) * time.Second
+	presigned, err := s3.NewPresignClient(s3Client.Client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(objectKey),
+	}, s3.WithPresignExpires(timeout+time.Minute))
+	if err != nil {
+		return fmt.Errorf("error presigning file: %v", err)
+	}
+
+	status := "completed"
+	output, err := customInvoker.Invoke(ctx, cp.UserID, cp.RoleARN, cp.FunctionARN, custom.Request{
+		FileID:    fileID,
+		Name:      file.Name,
+		Bucket:    bucket,
+		Key:       objectKey,
+		SizeBytes: file.SizeBytes,
+		URL:       presigned.URL,
+		Result:    result,
+	}, timeout)
+	if err != nil {
+		log.Printf("Custom processor %s failed on file %s: %v", cp.FunctionARN, fileID, err)
+		status, output = "failed", err.Error()
+	}
+
+	err = database.WithTx(func(tx *sql.Tx) error {
+		if err := database.MarkMessageProcessedTx(tx, messageKey+"/custom", fileID); err != nil {
+			return err
+		}
+		if err := database.SaveCustomResultTx(tx, fileID, status, output); err != nil {
+			return err
+		}
+		return database.RecordEventTx(tx, database.EventFileProcessed, fileID, "", map[string]string{"status": status, "source": database.ResultCustom})
+	})
+	if errors.Is(err, database.ErrAlreadyProcessed) {
+		return nil
+	}
+	return err
+}
+
+// expandArchive stores each member of an archive marked for expansion as a
+// child file. The whole archive is checked against the limits before anything
+// is stored, so one over them leaves no partial expansion behind. An archive
+// that already has members was expanded by an earlier run and is left alone.
+// Members are never marked for expansion, so archives inside are kept whole.
+// It returns how many members were stored.
+func expandArchive(ctx context.Context, file *database.File, content []byte) (int, error) {
+	children, err := database.ListChildFiles(file.ID)
+	if err != nil || len(children) > 0 {
+		return len(children), err
+	}
+
+	limits := processor.LoadArchiveLimits()
+	noop := func(processor.ArchiveMember, []byte) error { return nil }
+	if err := processor.WalkArchive(file.Name, content, limits, noop); err != nil {
+		return 0, err
+	}
+
+	// Members are read one at a time but stored concurrently, up to
+	// expandConcurrency at once; the first failure stops the walk
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		members  int
+		storeErr error
+	)
+	slots := make(chan struct{}, expandConcurrency())
+	walkErr := processor.WalkArchive(file.Name, content, limits, func(m processor.ArchiveMember, data []byte) error {
+		mu.Lock()
+		err := storeErr
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			_, err := ingestStore.SaveMember(ctx, file, m.Path, bytes.NewReader(data), m.SizeBytes)
+			mu.Lock()
+			defer mu.Unlock()
 			if err != nil {
-				log.Printf("Error saving processing result: %v", err)
-				continue
+				if storeErr == nil {
+					storeErr = fmt.Errorf("error storing member %s: %w", m.Path, err)
+				}
+				return
 			}
+			members++
+		}()
+		return nil
+	})
+	wg.Wait()
+	settings.Debugf("Expanded %d members of archive %s", members, file.ID)
+	if storeErr != nil {
+		return members, storeErr
+	}
+	return members, walkErr
+}
+
+// expandConcurrency is how many members of an archive are stored at once,
+// from ARCHIVE_EXPAND_CONCURRENCY
+func expandConcurrency() int {
+	n := defaultExpandConcurrency
+	if v := os.Getenv("ARCHIVE_EXPAND_CONCURRENCY"); v != "" {
+		if parsed, err := strconv.Atoi(v); err != nil || parsed < 1 {
+			log.Printf("Invalid ARCHIVE_EXPAND_CONCURRENCY %q, using %d", v, n)
+		} else {
+			n = parsed
+		}
+	}
+	return n
+}
+
+// pipelineFor returns the processors to run on a file. The owner's pipeline
+// for the file's type is used first, then their "*" pipeline, then the
+// configured ones, and without any the type's default processor runs alone.
+func pipelineFor(file *database.File, objectKey string) ([]processor.Processor, error) {
+	var userID string
+	if file != nil {
+		userID = file.UserID
+	}
+	stages, err := tenants.Pipeline(userID, processor.FileType(objectKey))
+	if err != nil {
+		return nil, fmt.Errorf("error loading pipeline: %v", err)
+	}
+	return processor.Pipeline(stages, objectKey)
+}
 
-			log.Printf("Successfully processed file %s", objectKey)
+// recordStages adds the outcome of each pipeline stage to the file's event
+// timeline, and meters the time the stages took. Results themselves are left
+// out; the saved result holds them. A pipeline that runs again after a
+// redelivery is metered again, as it did run twice.
+func recordStages(fileID string, stages []processor.StageResult) {
+	var elapsed time.Duration
+	for i, stage := range stages {
+		elapsed += stage.Duration
+		payload := map[string]interface{}{
+			"stage":       i + 1,
+			"processor":   stage.Processor,
+			"status":      stage.Status,
+			"duration_ms": stage.Duration.Milliseconds(),
 		}
+		if stage.Error != "" {
+			payload["error"] = stage.Error
+		}
+		if err := database.RecordEvent(database.EventFileStage, fileID, "", payload); err != nil {
+			log.Printf("Error recording stage %s of file %s: %v", stage.Processor, fileID, err)
+		}
+	}
+	if len(stages) == 0 {
+		return
+	}
+	if err := database.RecordUsage("", database.MeterProcessingMillis, elapsed.Milliseconds(), fileID); err != nil {
+		log.Printf("Error metering processing of file %s: %v", fileID, err)
 	}
+}
 
+// saveTextStats stores the statistics from the pipeline's text stage, if it
+// had one, for the stats endpoints
+func saveTextStats(fileID string, stages []processor.StageResult) error {
+	for _, stage := range stages {
+		if stage.Processor != "text" || stage.Status != processor.StageCompleted {
+			continue
+		}
+		stats, ok := processor.ParseTextStats(stage.Result)
+		if !ok {
+			return nil
+		}
+		topTerms, err := json.Marshal(stats.TopTerms)
+		if err != nil {
+			return err
+		}
+		s := database.TextStats{
+			FileID:     fileID,
+			Language:   stats.Language,
+			Words:      stats.Words,
+			Sentences:  stats.Sentences,
+			Characters: int64(stats.Characters),
+			TopTerms:   topTerms,
+		}
+		if stats.Readability != nil {
+			s.FleschReadingEase = &stats.Readability.FleschReadingEase
+			s.FleschKincaidGrade = &stats.Readability.FleschKincaidGrade
+		}
+		return database.SaveTextStats(s)
+	}
 	return nil
 }
 
+// indexFile sends a file's extracted text to the search backend
+func indexFile(ctx context.Context, fileID string, content []byte) error {
+	file, err := database.GetFileByID(fileID)
+	if err != nil || file == nil {
+		return err
+	}
+	return searchBackend.Index(ctx, search.Document{
+		FileID:    file.ID,
+		UserID:    file.UserID,
+		Name:      file.Name,
+		Content:   processor.ExtractText(content),
+		CreatedAt: file.CreatedAt,
+	})
+}
+
+// skipForSchedule reports whether a message should be dropped because the
+// file's processing was deferred to a later time or cancelled
+func skipForSchedule(fileID, jobID string) (bool, error) {
+	if jobID != "" {
+		job, err := database.GetScheduledJobByID(jobID)
+		if err != nil {
+			return false, fmt.Errorf("error loading scheduled job: %v", err)
+		}
+		if job != nil && job.Status == database.ScheduledJobCancelled {
+			log.Printf("Skipping file %s: scheduled job %s was cancelled", fileID, jobID)
+			return true, nil
+		}
+		return false, nil
+	}
+
+	// Unscheduled messages (e.g. the S3 notification) are ignored while a job is active
+	job, err := database.GetActiveScheduledJobByFileID(fileID)
+	if err != nil {
+		return false, fmt.Errorf("error loading scheduled job: %v", err)
+	}
+	if job != nil {
+		log.Printf("Skipping file %s: processing scheduled for %s", fileID, job.RunAt)
+		return true, nil
+	}
+	return false, nil
+}
+
 func main() {
+	// WORKER_MODE=poll runs the worker as a plain process, for local development
+	if os.Getenv("WORKER_MODE") == "poll" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		pollQueue(ctx)
+		return
+	}
 	lambda.Start(HandleSQSEvent)
 }
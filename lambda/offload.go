@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/storage"
+)
+
+const (
+	// defaultOffloadBytes is used when RESULT_OFFLOAD_BYTES isn't set
+	defaultOffloadBytes = 256 << 10
+	// offloadSummaryBytes is how much of an offloaded result its row keeps
+	offloadSummaryBytes = 1024
+)
+
+// offloadThreshold is the size from which results are stored in S3 rather
+// than in their row, from RESULT_OFFLOAD_BYTES; 0 keeps every result in the
+// database
+func offloadThreshold() int {
+	v := os.Getenv("RESULT_OFFLOAD_BYTES")
+	if v == "" {
+		return defaultOffloadBytes
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("Invalid RESULT_OFFLOAD_BYTES %q, using %d", v, defaultOffloadBytes)
+		return defaultOffloadBytes
+	}
+	return n
+}
+
+// storedResult is a result ready to be saved: the whole result, or the
+// summary of one offloaded to S3 under key
+type storedResult struct {
+	result string
+	key    string
+	size   int64
+}
+
+// offloadResult stores a result of fileID in S3 when it is at least the
+// offload threshold, and returns what its row keeps
+func offloadResult(ctx context.Context, fileID, result string) (storedResult, error) {
+	threshold := offloadThreshold()
+	if threshold == 0 || len(result) < threshold {
+		return storedResult{result: result}, nil
+	}
+	key := storage.ResultKey(fileID, database.NewID())
+	if err := s3Client.PutResult(ctx, key, result); err != nil {
+		return storedResult{}, err
+	}
+	return storedResult{result: summarizeResult(result), key: key, size: int64(len(result))}, nil
+}
+
+// summarizeResult returns the beginning of a result, cut at a character
+// boundary
+func summarizeResult(result string) string {
+	if len(result) <= offloadSummaryBytes {
+		return result
+	}
+	end := offloadSummaryBytes
+	for end > 0 && !utf8.RuneStart(result[end]) {
+		end--
+	}
+	return result[:end]
+}
+
+// saveTx saves the result as fileID's built-in result
+func (r storedResult) saveTx(tx *sql.Tx, fileID, status string) error {
+	if r.key == "" {
+		return database.SaveProcessingResultTx(tx, fileID, status, r.result)
+	}
+	return database.SaveOffloadedResultTx(tx, fileID, status, r.result, r.key, r.size)
+}
+
+// discard deletes the object of an offloaded result that wasn't saved after
+// all, such as when another delivery of the message saved its own
+func (r storedResult) discard(ctx context.Context) {
+	if r.key == "" {
+		return
+	}
+	_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(r.key),
+	})
+	if err != nil {
+		log.Printf("Error deleting unsaved result %s: %v", r.key, err)
+	}
+}
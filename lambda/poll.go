@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/yourusername/golang-aws-api/queue"
+)
+
+// pollQueue runs the worker outside Lambda: it long-polls the queue, hands
+// each batch to HandleSQSEvent and deletes the messages it handled, as the
+// Lambda event source mapping would. It returns when ctx is done.
+func pollQueue(ctx context.Context) {
+	log.Printf("Polling %s for messages", queue.QueueURL())
+	for ctx.Err() == nil {
+		if pause := maintenancePause(); pause > 0 {
+			log.Printf("Maintenance mode is on, pausing for %s", pause)
+			select {
+			case <-ctx.Done():
+			case <-time.After(pause):
+			}
+			continue
+		}
+
+		messages, err := queue.Receive(ctx, 10, 20*time.Second)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error receiving messages: %v", err)
+				time.Sleep(5 * time.Second)
+			}
+			continue
+		}
+		if len(messages) == 0 {
+			continue
+		}
+
+		event := events.SQSEvent{Records: make([]events.SQSMessage, 0, len(messages))}
+		for _, m := range messages {
+			attributes := make(map[string]events.SQSMessageAttribute, len(m.MessageAttributes))
+			for name, v := range m.MessageAttributes {
+				attributes[name] = events.SQSMessageAttribute{DataType: aws.ToString(v.DataType), StringValue: v.StringValue}
+			}
+			event.Records = append(event.Records, events.SQSMessage{
+				MessageId:         aws.ToString(m.MessageId),
+				ReceiptHandle:     aws.ToString(m.ReceiptHandle),
+				Body:              aws.ToString(m.Body),
+				Attributes:        m.Attributes,
+				MessageAttributes: attributes,
+			})
+		}
+
+		resp, _ := HandleSQSEvent(ctx, event)
+		failed := make(map[string]bool, len(resp.BatchItemFailures))
+		for _, f := range resp.BatchItemFailures {
+			failed[f.ItemIdentifier] = true
+		}
+		var handled []events.SQSMessage
+		var handles []string
+		for _, m := range event.Records {
+			if !failed[m.MessageId] {
+				handled = append(handled, m)
+				handles = append(handles, m.ReceiptHandle)
+			}
+		}
+		for i, err := range queue.DeleteBatch(ctx, handles) {
+			if err != nil {
+				log.Printf("Error deleting message %s: %v", handled[i].MessageId, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/processor"
+)
+
+// defaultProgressInterval is used when PROGRESS_INTERVAL isn't set
+const defaultProgressInterval = 2 * time.Second
+
+// progressInterval is the least time between progress updates saved for a
+// file, from PROGRESS_INTERVAL; 0 turns them off
+func progressInterval() time.Duration {
+	v := os.Getenv("PROGRESS_INTERVAL")
+	if v == "" {
+		return defaultProgressInterval
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid PROGRESS_INTERVAL %q, using %s: %v", v, defaultProgressInterval, err)
+		return defaultProgressInterval
+	}
+	return d
+}
+
+// progressReporter saves the progress of a file's pipeline and announces it
+// to the API instances, at most once per interval. Pipelines done within the
+// first interval save none, so only long-running ones pay for it.
+type progressReporter struct {
+	fileID   string
+	userID   string
+	interval time.Duration
+
+	mu      sync.Mutex
+	last    time.Time
+	stopped bool
+}
+
+func newProgressReporter(fileID string, file *database.File) *progressReporter {
+	r := &progressReporter{fileID: fileID, interval: progressInterval(), last: time.Now()}
+	if file != nil {
+		r.userID = file.UserID
+	}
+	return r
+}
+
+// report is the pipeline's progress function
+func (r *progressReporter) report(p processor.Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped || r.interval <= 0 || time.Since(r.last) < r.interval {
+		return
+	}
+	r.last = time.Now()
+
+	err := database.SaveProcessingProgress(database.ProcessingProgress{
+		FileID:    r.fileID,
+		Stage:     p.Stage,
+		Stages:    p.Stages,
+		Processor: p.Processor,
+		Done:      p.Done,
+		Total:     p.Total,
+		Percent:   p.Percent,
+	})
+	if err != nil {
+		log.Printf("Error saving progress of file %s: %v", r.fileID, err)
+		return
+	}
+	payload, err := json.Marshal(map[string]interface{}{"file_id": r.fileID, "user_id": r.userID, "progress": p})
+	if err == nil {
+		err = database.NotifyFileProgress(payload)
+	}
+	if err != nil {
+		log.Printf("Error announcing progress of file %s: %v", r.fileID, err)
+	}
+}
+
+// stop drops the reports of processors still running after their stage
+// timed out, once the pipeline is over
+func (r *progressReporter) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+}
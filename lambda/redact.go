@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yourusername/golang-aws-api/comprehend"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/envelope"
+	"github.com/yourusername/golang-aws-api/processor"
+	"github.com/yourusername/golang-aws-api/storage"
+)
+
+// defaultPIIMinScore is used when PII_PROCESSOR_MIN_SCORE isn't set
+const defaultPIIMinScore = 0.8
+
+// comprehendPIIDetector has the pii processor ask Amazon Comprehend for the
+// personal data its patterns can't find, such as names and addresses
+type comprehendPIIDetector struct {
+	client   *comprehend.Client
+	language string
+	minScore float64
+}
+
+// newComprehendPIIDetector configures the detector from
+// PII_PROCESSOR_LANGUAGE, default en, and PII_PROCESSOR_MIN_SCORE, the
+// confidence from which an entity counts
+func newComprehendPIIDetector(cfg aws.Config) *comprehendPIIDetector {
+	d := &comprehendPIIDetector{client: comprehend.New(cfg), language: "en", minScore: defaultPIIMinScore}
+	if v := os.Getenv("PII_PROCESSOR_LANGUAGE"); v != "" {
+		d.language = v
+	}
+	if v := os.Getenv("PII_PROCESSOR_MIN_SCORE"); v != "" {
+		score, err := strconv.ParseFloat(v, 64)
+		if err != nil || score < 0 || score > 1 {
+			log.Printf("Invalid PII_PROCESSOR_MIN_SCORE %q, using %v", v, defaultPIIMinScore)
+		} else {
+			d.minScore = score
+		}
+	}
+	return d
+}
+
+func (d *comprehendPIIDetector) DetectPII(ctx context.Context, text string) ([]processor.PIIMatch, error) {
+	spans, err := d.client.Spans(ctx, text, d.language, d.minScore)
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]processor.PIIMatch, 0, len(spans))
+	for _, s := range spans {
+		matches = append(matches, processor.PIIMatch{Type: s.Type, Start: s.Start, End: s.End})
+	}
+	return matches, nil
+}
+
+// redactsPII reports whether a pipeline has the pii stage
+func redactsPII(procs []processor.Processor) bool {
+	for _, p := range procs {
+		if p.Name() == "pii" {
+			return true
+		}
+	}
+	return false
+}
+
+// piiReport returns what the pipeline's pii stage found, or nil if it had
+// none
+func piiReport(stages []processor.StageResult) *processor.PIIReport {
+	var report *processor.PIIReport
+	for _, stage := range stages {
+		if stage.Processor == "pii" && stage.Status == processor.StageCompleted {
+			report, _ = processor.ParsePIIReport(stage.Result)
+		}
+	}
+	return report
+}
+
+// searchableContent returns the content to index a file with: the content
+// with the personal data the pipeline's pii stage found redacted, so that
+// neither matches nor highlights reveal it
+func searchableContent(stages []processor.StageResult, content []byte) []byte {
+	report := piiReport(stages)
+	if report == nil || len(report.Matches) == 0 {
+		return content
+	}
+	return processor.RedactPII(content, report.Matches)
+}
+
+// saveRedactedCopy stores a copy of the file with the personal data found by
+// the pipeline's pii stage redacted, if it had one, encrypted like the file
+// is. A file the stage found nothing in loses any copy it had. Files
+// processed without the pii stage keep what they have.
+func saveRedactedCopy(ctx context.Context, file *database.File, stages []processor.StageResult, content []byte) error {
+	if file == nil {
+		return nil
+	}
+	report := piiReport(stages)
+	if report == nil {
+		return nil
+	}
+
+	key, size := "", int64(0)
+	if len(report.Matches) > 0 {
+		redacted := processor.RedactPII(content, report.Matches)
+		size = int64(len(redacted))
+		body, env := redacted, envelope.Envelope{}
+		if file.Encryption.Encrypted() {
+			var err error
+			body, env, err = envelope.Seal(ctx, keyService, file.Encryption.KeyID, file.ID, redacted)
+			if err != nil {
+				return fmt.Errorf("error encrypting redacted copy: %v", err)
+			}
+		}
+		key = storage.RedactedKey(file.ID, database.NewID())
+		input := &s3.PutObjectInput{
+			Bucket:        aws.String(bucketName),
+			Key:           aws.String(key),
+			Body:          bytes.NewReader(body),
+			ContentLength: int64(len(body)),
+			Metadata:      env.Metadata(),
+		}
+		if file.ContentType != "" {
+			input.ContentType = aws.String(file.ContentType)
+		}
+		if _, err := s3Client.PutObject(ctx, input); err != nil {
+			return fmt.Errorf("error storing redacted copy: %v", err)
+		}
+	}
+
+	old, err := database.SetRedactedCopy(file.ID, key, size)
+	if err != nil {
+		deleteRedactedCopy(ctx, key)
+		return err
+	}
+	deleteRedactedCopy(ctx, old)
+	return nil
+}
+
+// deleteRedactedCopy deletes a redacted copy that is no longer recorded for
+// its file. Failing to only leaves the object behind.
+func deleteRedactedCopy(ctx context.Context, key string) {
+	if key == "" {
+		return
+	}
+	_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		log.Printf("Error deleting redacted copy %s: %v", key, err)
+	}
+}
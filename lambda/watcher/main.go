@@ -0,0 +1,258 @@
+// Command watcher registers objects that producers write straight to the
+// bucket. Each run lists WATCH_PREFIX after the last key it handled, records
+// every object that has no file row yet as a file of WATCH_USER_ID and queues
+// it for processing.
+//
+// It runs as a Lambda on an EventBridge schedule, or with WORKER_MODE=poll as
+// a process that scans every WATCH_INTERVAL. Keys are listed in lexical order,
+// so producers should write keys that sort by time (e.g. incoming/2024/05/01/…);
+// an object written below the checkpoint is only picked up by a reset.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/contenttype"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/queue"
+	"github.com/yourusername/golang-aws-api/storage"
+	"github.com/yourusername/golang-aws-api/tenants"
+)
+
+// watcher scans one prefix of the bucket
+type watcher struct {
+	s3Client   *s3.Client
+	bucketName string
+	prefix     string
+	// ownerID owns registered files
+	ownerID string
+	// maxObjects bounds the objects registered per run so a backlog is
+	// worked off over several runs instead of timing out one
+	maxObjects int
+}
+
+func newWatcher(ctx context.Context) (*watcher, error) {
+	cfg, err := awsconfig.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %v", err)
+	}
+	queue.InitQueue(cfg)
+	if err := database.InitDB(); err != nil {
+		return nil, fmt.Errorf("initializing database: %v", err)
+	}
+
+	maxObjects, err := strconv.Atoi(getEnv("WATCH_MAX_OBJECTS", "1000"))
+	if err != nil || maxObjects < 1 {
+		return nil, fmt.Errorf("invalid WATCH_MAX_OBJECTS %q", os.Getenv("WATCH_MAX_OBJECTS"))
+	}
+	w := &watcher{
+		s3Client:   s3.NewFromConfig(cfg),
+		bucketName: getEnv("S3_BUCKET_NAME", "my-test-bucket"),
+		prefix:     getEnv("WATCH_PREFIX", "incoming/"),
+		ownerID:    os.Getenv("WATCH_USER_ID"),
+		maxObjects: maxObjects,
+	}
+	// The API's own prefixes hold objects written for a particular user or
+	// file; registering them would hand them to the watch user
+	if storage.OverlapsReserved(w.prefix) {
+		return nil, fmt.Errorf("WATCH_PREFIX %q overlaps a prefix the API writes to", w.prefix)
+	}
+	// Ownerless files are only visible to administrators
+	if w.ownerID == "" {
+		return nil, fmt.Errorf("WATCH_USER_ID must name the user that owns registered files")
+	}
+	owner, err := database.GetUserByID(w.ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("looking up WATCH_USER_ID: %v", err)
+	}
+	if owner == nil {
+		return nil, fmt.Errorf("WATCH_USER_ID %q is not a user", w.ownerID)
+	}
+	return w, nil
+}
+
+// scan registers the unseen objects after the checkpoint, saving the
+// checkpoint after every page. It returns how many objects were registered.
+func (w *watcher) scan(ctx context.Context) (int, error) {
+	m, err := database.GetMaintenance()
+	if err != nil {
+		log.Printf("Error reading maintenance state: %v", err)
+	} else if m.Enabled {
+		log.Printf("Maintenance mode is on, skipping scan")
+		return 0, nil
+	}
+
+	checkpoint, err := database.GetWatchCheckpoint(w.prefix)
+	if err != nil {
+		return 0, fmt.Errorf("loading checkpoint: %v", err)
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(w.bucketName),
+		Prefix: aws.String(w.prefix),
+	}
+	if checkpoint != "" {
+		input.StartAfter = aws.String(checkpoint)
+	}
+
+	registered := 0
+	paginator := s3.NewListObjectsV2Paginator(w.s3Client, input)
+	for paginator.HasMorePages() && registered < w.maxObjects {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return registered, fmt.Errorf("listing %s: %v", w.prefix, err)
+		}
+
+		last := checkpoint
+		for _, obj := range page.Contents {
+			if registered >= w.maxObjects {
+				break
+			}
+			key := aws.ToString(obj.Key)
+			if !strings.HasSuffix(key, "/") {
+				ok, err := w.register(ctx, key, obj.Size, string(obj.StorageClass), aws.ToString(obj.ETag))
+				if err != nil {
+					// Stop before the failed key so the next run retries it
+					w.saveCheckpoint(last)
+					return registered, err
+				}
+				if ok {
+					registered++
+				}
+			}
+			last = key
+		}
+
+		w.saveCheckpoint(last)
+		checkpoint = last
+	}
+	return registered, nil
+}
+
+// register records key as a file and queues it, unless a file row already
+// points at it
+func (w *watcher) register(ctx context.Context, key string, size int64, class, etag string) (bool, error) {
+	existing, err := database.GetFileByS3Key(key)
+	if err != nil {
+		return false, fmt.Errorf("looking up %s: %v", key, err)
+	}
+	if existing != nil {
+		return false, nil
+	}
+
+	name := path.Base(key)
+	contentType, err := contenttype.DetectObject(ctx, w.s3Client, w.bucketName, key, name, size)
+	if err != nil {
+		log.Printf("Error sniffing content type of %s, going by its name: %v", key, err)
+		contentType = contenttype.Detect(name, nil)
+	}
+
+	// The object stays where the producer wrote it, whatever name it's given
+	pending, err := tenants.RedactsPII(w.ownerID, name)
+	if err != nil {
+		return false, fmt.Errorf("loading pipeline for %s: %v", key, err)
+	}
+	f, err := database.CreateFile(database.NewFile{
+		ID:               database.NewID(),
+		Name:             name,
+		UserID:           w.ownerID,
+		SizeBytes:        size,
+		StorageClass:     class,
+		ContentType:      contentType,
+		RedactionPending: pending,
+		S3Key:            func(string) string { return key },
+	}, database.NamePolicyRename)
+	if err != nil {
+		return false, fmt.Errorf("registering %s: %v", key, err)
+	}
+	if err := queue.PublishFileEvent(ctx, f.ID, w.bucketName, key, etag); err != nil {
+		return false, fmt.Errorf("queuing %s: %v", key, err)
+	}
+	log.Printf("Registered %s as file %s", key, f.ID)
+	return true, nil
+}
+
+func (w *watcher) saveCheckpoint(key string) {
+	if key == "" {
+		return
+	}
+	if err := database.SaveWatchCheckpoint(w.prefix, key); err != nil {
+		// The next run lists these keys again and skips the registered ones
+		log.Printf("Error saving checkpoint %s: %v", key, err)
+	}
+}
+
+// handleSchedule runs one scan per EventBridge invocation
+func (w *watcher) handleSchedule(ctx context.Context, _ events.CloudWatchEvent) error {
+	n, err := w.scan(ctx)
+	log.Printf("Registered %d objects under %s", n, w.prefix)
+	return err
+}
+
+// poll scans every interval until ctx is done
+func (w *watcher) poll(ctx context.Context, interval time.Duration) {
+	log.Printf("Watching s3://%s/%s every %s", w.bucketName, w.prefix, interval)
+	for {
+		n, err := w.scan(ctx)
+		if err != nil {
+			log.Printf("Error scanning %s: %v", w.prefix, err)
+		} else if n > 0 {
+			log.Printf("Registered %d objects under %s", n, w.prefix)
+		}
+
+		// A full run means more objects are probably waiting
+		wait := interval
+		if n >= w.maxObjects {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w, err := newWatcher(ctx)
+	if err != nil {
+		log.Fatalf("Failed to start watcher: %v", err)
+	}
+
+	// WORKER_MODE=poll runs the watcher as a plain process, for local development
+	if os.Getenv("WORKER_MODE") == "poll" {
+		interval, err := time.ParseDuration(getEnv("WATCH_INTERVAL", "1m"))
+		if err != nil || interval <= 0 {
+			log.Fatalf("Invalid WATCH_INTERVAL %q", os.Getenv("WATCH_INTERVAL"))
+		}
+		w.poll(ctx, interval)
+		return
+	}
+	lambda.Start(w.handleSchedule)
+}
+
+// Helper function to get environment variables
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,75 @@
+package metering
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// kinesisBatchSize is the most records PutRecords accepts at once
+const kinesisBatchSize = 500
+
+// Kinesis writes each usage record as a JSON record to a Kinesis data
+// stream, partitioned by user so a user's records stay in order
+type Kinesis struct {
+	api    jsonAPI
+	stream string
+}
+
+// NewKinesis returns a sink writing to the named stream
+func NewKinesis(cfg aws.Config, stream string) *Kinesis {
+	return &Kinesis{
+		api:    newJSONAPI(cfg, "Kinesis", "kinesis", "kinesis", "Kinesis_20131202"),
+		stream: stream,
+	}
+}
+
+type kinesisRecord struct {
+	// Data is base64 encoded by encoding/json, as Kinesis expects
+	Data         []byte
+	PartitionKey string
+}
+
+// Send puts the records in batches. PutRecords can fail for some records of
+// a batch only, in which case the whole batch is reported as failed.
+func (k *Kinesis) Send(ctx context.Context, records []database.UsageRecord) error {
+	for start := 0; start < len(records); start += kinesisBatchSize {
+		end := start + kinesisBatchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		batch, err := kinesisRecords(records[start:end])
+		if err != nil {
+			return err
+		}
+		var out struct {
+			FailedRecordCount int
+		}
+		err = k.api.call(ctx, "PutRecords", map[string]interface{}{
+			"StreamName": k.stream,
+			"Records":    batch,
+		}, &out)
+		if err != nil {
+			return err
+		}
+		if out.FailedRecordCount > 0 {
+			return fmt.Errorf("kinesis rejected %d of %d usage records", out.FailedRecordCount, len(batch))
+		}
+	}
+	return nil
+}
+
+func kinesisRecords(records []database.UsageRecord) ([]kinesisRecord, error) {
+	batch := make([]kinesisRecord, len(records))
+	for i, r := range records {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		batch[i] = kinesisRecord{Data: data, PartitionKey: r.UserID}
+	}
+	return batch, nil
+}
@@ -0,0 +1,86 @@
+package metering
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// Marketplace reports usage to AWS Marketplace Metering for a product sold
+// as an AMI or container. Each metric is reported as the usage dimension of
+// the same name, with the quantity allocated to tenants by a "tenant" tag.
+type Marketplace struct {
+	api         jsonAPI
+	productCode string
+}
+
+// NewMarketplace returns a sink metering the given product
+func NewMarketplace(cfg aws.Config, productCode string) *Marketplace {
+	return &Marketplace{
+		api:         newJSONAPI(cfg, "Marketplace Metering", "aws-marketplace", "metering.marketplace", "AWSMPMeteringService"),
+		productCode: productCode,
+	}
+}
+
+type usageTag struct {
+	Key   string
+	Value string
+}
+
+type usageAllocation struct {
+	AllocatedUsageQuantity int64
+	Tags                   []usageTag
+}
+
+// meterUsage is one MeterUsage request
+type meterUsage struct {
+	ProductCode      string
+	Timestamp        int64
+	UsageDimension   string
+	UsageQuantity    int64
+	UsageAllocations []usageAllocation
+}
+
+// Send totals the records per metric and tenant and sends one MeterUsage
+// request per metric
+func (m *Marketplace) Send(ctx context.Context, records []database.UsageRecord) error {
+	for _, req := range meterUsages(m.productCode, records, time.Now()) {
+		if err := m.api.call(ctx, "MeterUsage", req, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// meterUsages totals records into MeterUsage requests, ordered by metric
+// and with the allocations ordered by tenant
+func meterUsages(productCode string, records []database.UsageRecord, now time.Time) []meterUsage {
+	totals := make(map[string]map[string]int64)
+	for _, r := range records {
+		if totals[r.Metric] == nil {
+			totals[r.Metric] = make(map[string]int64)
+		}
+		totals[r.Metric][r.UserID] += r.Quantity
+	}
+
+	reqs := make([]meterUsage, 0, len(totals))
+	for metric, tenants := range totals {
+		req := meterUsage{ProductCode: productCode, Timestamp: now.Unix(), UsageDimension: metric}
+		for tenant, quantity := range tenants {
+			req.UsageQuantity += quantity
+			req.UsageAllocations = append(req.UsageAllocations, usageAllocation{
+				AllocatedUsageQuantity: quantity,
+				Tags:                   []usageTag{{Key: "tenant", Value: tenant}},
+			})
+		}
+		sort.Slice(req.UsageAllocations, func(i, j int) bool {
+			return req.UsageAllocations[i].Tags[0].Value < req.UsageAllocations[j].Tags[0].Value
+		})
+		reqs = append(reqs, req)
+	}
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].UsageDimension < reqs[j].UsageDimension })
+	return reqs
+}
@@ -0,0 +1,152 @@
+// Package metering sends usage records to an external billing system. The
+// records themselves are always kept in the usage_records table; a sink is
+// optional and only sees each record once it was committed there.
+package metering
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// Supported sinks
+const (
+	SinkKinesis     = "kinesis"
+	SinkMarketplace = "marketplace"
+)
+
+// Sink receives usage records. Send may be called again with records it
+// already received when an earlier call failed part way, so receivers should
+// deduplicate by record ID.
+type Sink interface {
+	Send(ctx context.Context, records []database.UsageRecord) error
+}
+
+// New returns the sink selected by METERING_SINK, or nil if none is
+// configured
+func New(cfg aws.Config) (Sink, error) {
+	switch sink := os.Getenv("METERING_SINK"); sink {
+	case "":
+		return nil, nil
+	case SinkKinesis:
+		stream := os.Getenv("METERING_KINESIS_STREAM")
+		if stream == "" {
+			return nil, errors.New("METERING_KINESIS_STREAM is required for the kinesis metering sink")
+		}
+		return NewKinesis(cfg, stream), nil
+	case SinkMarketplace:
+		productCode := os.Getenv("METERING_PRODUCT_CODE")
+		if productCode == "" {
+			return nil, errors.New("METERING_PRODUCT_CODE is required for the marketplace metering sink")
+		}
+		return NewMarketplace(cfg, productCode), nil
+	default:
+		log.Printf("Unknown METERING_SINK %q, usage is only recorded in the database", sink)
+		return nil, nil
+	}
+}
+
+// jsonAPI calls an AWS JSON protocol API directly, signed with the SDK's
+// credentials
+type jsonAPI struct {
+	cfg    aws.Config
+	signer *v4.Signer
+	client *http.Client
+	// serviceID resolves LocalStack endpoints, signingName signs requests
+	// and host is the regional endpoint's host prefix
+	serviceID   string
+	signingName string
+	host        string
+	target      string
+}
+
+func newJSONAPI(cfg aws.Config, serviceID, signingName, host, target string) jsonAPI {
+	return jsonAPI{
+		cfg:         cfg,
+		signer:      v4.NewSigner(),
+		client:      awsconfig.NewHTTPClient(30 * time.Second),
+		serviceID:   serviceID,
+		signingName: signingName,
+		host:        host,
+		target:      target,
+	}
+}
+
+// endpoint resolves the endpoint and signing region, honouring the LocalStack
+// resolver used in local development
+func (a jsonAPI) endpoint() (string, string) {
+	region := a.cfg.Region
+	if a.cfg.EndpointResolverWithOptions != nil {
+		e, err := a.cfg.EndpointResolverWithOptions.ResolveEndpoint(a.serviceID, region)
+		if err == nil {
+			if e.SigningRegion != "" {
+				region = e.SigningRegion
+			}
+			return e.URL, region
+		}
+	}
+	return fmt.Sprintf("https://%s.%s.amazonaws.com", a.host, region), region
+}
+
+// call sends one JSON request and decodes the response into out
+func (a jsonAPI) call(ctx context.Context, operation string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	url, region := a.endpoint()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", a.target+"."+operation)
+
+	if a.cfg.Credentials == nil {
+		return errors.New("no AWS credentials configured")
+	}
+	creds, err := a.cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieving credentials: %v", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := a.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), a.signingName, region, time.Now()); err != nil {
+		return fmt.Errorf("signing request: %v", err)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %v", a.serviceID, operation, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%s %s: %v", a.serviceID, operation, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(respBody, &apiErr)
+		return fmt.Errorf("%s %s: %s %s: %s", a.serviceID, operation, resp.Status, apiErr.Type, apiErr.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
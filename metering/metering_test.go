@@ -0,0 +1,46 @@
+package metering
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func TestMeterUsagesTotalsPerTenant(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	records := []database.UsageRecord{
+		{UserID: "u2", Metric: database.MeterDownloads, Quantity: 1},
+		{UserID: "u1", Metric: database.MeterBytesUploaded, Quantity: 100},
+		{UserID: "u1", Metric: database.MeterDownloads, Quantity: 1},
+		{UserID: "u2", Metric: database.MeterDownloads, Quantity: 1},
+	}
+
+	reqs := meterUsages("prod-1", records, now)
+	assert.Equal(t, []meterUsage{
+		{
+			ProductCode: "prod-1", Timestamp: now.Unix(), UsageDimension: database.MeterBytesUploaded, UsageQuantity: 100,
+			UsageAllocations: []usageAllocation{{AllocatedUsageQuantity: 100, Tags: []usageTag{{"tenant", "u1"}}}},
+		},
+		{
+			ProductCode: "prod-1", Timestamp: now.Unix(), UsageDimension: database.MeterDownloads, UsageQuantity: 3,
+			UsageAllocations: []usageAllocation{
+				{AllocatedUsageQuantity: 1, Tags: []usageTag{{"tenant", "u1"}}},
+				{AllocatedUsageQuantity: 2, Tags: []usageTag{{"tenant", "u2"}}},
+			},
+		},
+	}, reqs)
+}
+
+func TestKinesisRecordsArePartitionedByUser(t *testing.T) {
+	batch, err := kinesisRecords([]database.UsageRecord{{ID: "r1", UserID: "u1", Metric: database.MeterDownloads, Quantity: 1}})
+	assert.NoError(t, err)
+	assert.Len(t, batch, 1)
+	assert.Equal(t, "u1", batch[0].PartitionKey)
+
+	var r database.UsageRecord
+	assert.NoError(t, json.Unmarshal(batch[0].Data, &r))
+	assert.Equal(t, "r1", r.ID)
+}
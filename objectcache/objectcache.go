@@ -0,0 +1,239 @@
+// Package objectcache keeps small, frequently read S3 objects on local disk,
+// so repeated downloads of a hot file skip S3. The cache is bounded in total
+// bytes and per object, evicts the least recently used objects first and
+// drops objects older than its TTL. Objects are cached as S3 holds them, so
+// encrypted files stay encrypted on disk.
+//
+// Each process has its own cache. An object is stored with a version, such as
+// its S3 key, and only served for that version; callers invalidate it when the
+// object is overwritten or deleted, and the TTL bounds how long another
+// process's copy can go stale.
+package objectcache
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Options bound a cache
+type Options struct {
+	// Dir holds the cached objects; anything already in it is removed
+	Dir string
+	// MaxBytes bounds the size of all cached objects together
+	MaxBytes int64
+	// MaxObjectBytes is the size of the largest object that is cached
+	MaxObjectBytes int64
+	// TTL is how long an object is served from the cache
+	TTL time.Duration
+}
+
+// entry is one cached object
+type entry struct {
+	key         string
+	version     string
+	path        string
+	size        int64
+	contentType *string
+	metadata    map[string]string
+	stored      time.Time
+}
+
+// Cache is an LRU cache of objects on disk. A nil *Cache caches nothing, so
+// callers don't need to check whether caching is on.
+type Cache struct {
+	opts Options
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+	seq     uint64
+	now     func() time.Time
+}
+
+// New returns an empty cache in opts.Dir, creating the directory if needed
+func New(opts Options) (*Cache, error) {
+	if opts.MaxBytes <= 0 || opts.MaxObjectBytes <= 0 || opts.TTL <= 0 {
+		return nil, fmt.Errorf("cache bounds and TTL must be positive")
+	}
+	// Objects left by an earlier run aren't indexed, so they are dropped
+	if err := os.RemoveAll(opts.Dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Cache{
+		opts:    opts,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}, nil
+}
+
+// Load returns the cache configured by DOWNLOAD_CACHE_DIR, or nil when it is
+// unset. DOWNLOAD_CACHE_MAX_MB (default 1024) bounds the cache,
+// DOWNLOAD_CACHE_MAX_OBJECT_MB (default 8) the objects in it, and
+// DOWNLOAD_CACHE_TTL (default 10m) how long they are kept.
+func Load() (*Cache, error) {
+	dir := os.Getenv("DOWNLOAD_CACHE_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	opts := Options{Dir: dir, MaxBytes: 1024 << 20, MaxObjectBytes: 8 << 20, TTL: 10 * time.Minute}
+	if n, ok := envInt("DOWNLOAD_CACHE_MAX_MB"); ok {
+		opts.MaxBytes = int64(n) << 20
+	}
+	if n, ok := envInt("DOWNLOAD_CACHE_MAX_OBJECT_MB"); ok {
+		opts.MaxObjectBytes = int64(n) << 20
+	}
+	if v := os.Getenv("DOWNLOAD_CACHE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid DOWNLOAD_CACHE_TTL %q", v)
+		}
+		opts.TTL = ttl
+	}
+	c, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Download cache: dir=%s, max=%d bytes, max object=%d bytes, ttl=%s", dir, opts.MaxBytes, opts.MaxObjectBytes, opts.TTL)
+	return c, nil
+}
+
+func envInt(key string) (int, bool) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		log.Printf("Invalid %s %q, using the default", key, v)
+		return 0, false
+	}
+	return n, true
+}
+
+// Fits reports whether an object of size bytes would be cached
+func (c *Cache) Fits(size int64) bool {
+	return c != nil && size >= 0 && size <= c.opts.MaxObjectBytes && size <= c.opts.MaxBytes
+}
+
+// Get returns the object cached under key for version. The returned Body
+// reads the cached copy and must be closed.
+func (c *Cache) Get(key, version string) (*s3.GetObjectOutput, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if e.version != version || c.now().Sub(e.stored) >= c.opts.TTL {
+		c.remove(el)
+		return nil, false
+	}
+	// A file evicted after this is opened stays readable until closed
+	f, err := os.Open(e.path)
+	if err != nil {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.output(f), true
+}
+
+// Put copies obj's body into the cache under key and version and returns an
+// output that reads the copy. obj's body is read to the end and closed. On
+// error the body may have been partly read, so obj can't be used any more.
+func (c *Cache) Put(key, version string, obj *s3.GetObjectOutput) (*s3.GetObjectOutput, error) {
+	defer obj.Body.Close()
+
+	c.mu.Lock()
+	c.seq++
+	path := filepath.Join(c.opts.Dir, strconv.FormatUint(c.seq, 10))
+	c.mu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	// Read one byte past the limit to catch objects larger than announced
+	size, err := io.Copy(f, io.LimitReader(obj.Body, c.opts.MaxObjectBytes+1))
+	if err == nil && size > c.opts.MaxObjectBytes {
+		err = fmt.Errorf("object %s is larger than %d bytes", key, c.opts.MaxObjectBytes)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	e := &entry{
+		key:         key,
+		version:     version,
+		path:        path,
+		size:        size,
+		contentType: obj.ContentType,
+		metadata:    obj.Metadata,
+		stored:      c.now(),
+	}
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	c.size += size
+	for c.size > c.opts.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+	c.mu.Unlock()
+	return e.output(f), nil
+}
+
+// Invalidate drops the object cached under key, if any
+func (c *Cache) Invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+// remove drops an entry and its file; c.mu must be held
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing cached object %s: %v", e.path, err)
+	}
+}
+
+func (e *entry) output(f *os.File) *s3.GetObjectOutput {
+	return &s3.GetObjectOutput{
+		Body:          f,
+		ContentLength: e.size,
+		ContentType:   e.contentType,
+		Metadata:      e.metadata,
+	}
+}
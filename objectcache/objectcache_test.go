@@ -0,0 +1,126 @@
+package objectcache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCache(t *testing.T, maxBytes int64) *Cache {
+	c, err := New(Options{Dir: filepath.Join(t.TempDir(), "cache"), MaxBytes: maxBytes, MaxObjectBytes: 10, TTL: time.Minute})
+	require.NoError(t, err)
+	return c
+}
+
+func object(content string) *s3.GetObjectOutput {
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(strings.NewReader(content)),
+		ContentLength: int64(len(content)),
+		ContentType:   aws.String("text/plain"),
+		Metadata:      map[string]string{"k": "v"},
+	}
+}
+
+func read(t *testing.T, obj *s3.GetObjectOutput) string {
+	defer obj.Body.Close()
+	b, err := io.ReadAll(obj.Body)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestCacheReadsThrough(t *testing.T) {
+	c := newTestCache(t, 100)
+	_, ok := c.Get("f1", "files/f1/a.txt")
+	assert.False(t, ok)
+
+	out, err := c.Put("f1", "files/f1/a.txt", object("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", read(t, out))
+
+	out, ok = c.Get("f1", "files/f1/a.txt")
+	require.True(t, ok)
+	assert.Equal(t, "hello", read(t, out))
+	assert.Equal(t, int64(5), out.ContentLength)
+	assert.Equal(t, "text/plain", aws.ToString(out.ContentType))
+	assert.Equal(t, map[string]string{"k": "v"}, out.Metadata)
+
+	// Another version of the object is a miss and drops the stale copy
+	_, ok = c.Get("f1", "files/f1/b.txt")
+	assert.False(t, ok)
+	_, ok = c.Get("f1", "files/f1/a.txt")
+	assert.False(t, ok)
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newTestCache(t, 10)
+	for _, key := range []string{"a", "b"} {
+		out, err := c.Put(key, "v", object("12345"))
+		require.NoError(t, err)
+		out.Body.Close()
+	}
+	// Reading a makes b the least recently used
+	out, ok := c.Get("a", "v")
+	require.True(t, ok)
+	out.Body.Close()
+
+	out, err := c.Put("c", "v", object("123"))
+	require.NoError(t, err)
+	out.Body.Close()
+
+	_, ok = c.Get("b", "v")
+	assert.False(t, ok)
+	_, ok = c.Get("a", "v")
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), c.size)
+}
+
+func TestCacheExpiresAndInvalidates(t *testing.T) {
+	c := newTestCache(t, 100)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for _, key := range []string{"a", "b"} {
+		out, err := c.Put(key, "v", object("x"))
+		require.NoError(t, err)
+		out.Body.Close()
+	}
+	c.Invalidate("a")
+	_, ok := c.Get("a", "v")
+	assert.False(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = c.Get("b", "v")
+	assert.False(t, ok)
+
+	entries, err := os.ReadDir(c.opts.Dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestCacheRejectsOversizedObjects(t *testing.T) {
+	c := newTestCache(t, 100)
+	assert.True(t, c.Fits(10))
+	assert.False(t, c.Fits(11))
+
+	// An object larger than it claimed isn't kept
+	_, err := c.Put("big", "v", object("more than ten bytes"))
+	assert.Error(t, err)
+	_, ok := c.Get("big", "v")
+	assert.False(t, ok)
+}
+
+func TestNilCacheCachesNothing(t *testing.T) {
+	var c *Cache
+	assert.False(t, c.Fits(1))
+	_, ok := c.Get("a", "v")
+	assert.False(t, ok)
+	c.Invalidate("a")
+}
@@ -0,0 +1,90 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Page size limits for list endpoints
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// ErrInvalidCursor is returned when a cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the last row of a page. Lists are ordered by created_at and
+// then id, so the pair identifies a position even when timestamps collide.
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+	// Rank is set for lists ordered by relevance first, such as search results
+	Rank float64 `json:"r,omitempty"`
+}
+
+// Params are the pagination options of a list request
+type Params struct {
+	Limit int
+	// After is the cursor of the last row of the previous page, nil for the first page
+	After *Cursor
+}
+
+// Encode returns the opaque string form of a cursor handed to clients
+func Encode(c Cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Decode parses a cursor produced by Encode. An empty string decodes to nil.
+func Decode(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" || c.CreatedAt.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// FromRequest reads the limit and cursor query parameters
+func FromRequest(r *http.Request) (Params, error) {
+	p := Params{Limit: DefaultLimit}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, errors.New("limit must be a positive integer")
+		}
+		if n > MaxLimit {
+			n = MaxLimit
+		}
+		p.Limit = n
+	}
+
+	after, err := Decode(r.URL.Query().Get("cursor"))
+	if err != nil {
+		return p, err
+	}
+	p.After = after
+	return p, nil
+}
+
+// Trim takes rows fetched with a LIMIT of limit+1 and returns at most limit of
+// them, along with the cursor of the next page or "" if this is the last one
+func Trim[T any](rows []T, limit int, cursor func(T) Cursor) ([]T, string) {
+	if len(rows) <= limit {
+		return rows, ""
+	}
+	rows = rows[:limit]
+	return rows, Encode(cursor(rows[len(rows)-1]))
+}
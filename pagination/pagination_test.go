@@ -0,0 +1,60 @@
+package pagination
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := Cursor{CreatedAt: time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC), ID: "abc"}
+
+	decoded, err := Decode(Encode(c))
+	assert.NoError(t, err)
+	assert.True(t, c.CreatedAt.Equal(decoded.CreatedAt))
+	assert.Equal(t, c.ID, decoded.ID)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, s := range []string{"not base64!", Encode(Cursor{}), "e30"} {
+		_, err := Decode(s)
+		assert.ErrorIs(t, err, ErrInvalidCursor, s)
+	}
+
+	c, err := Decode("")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+func TestFromRequest(t *testing.T) {
+	p, err := FromRequest(httptest.NewRequest("GET", "/api/files", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultLimit, p.Limit)
+	assert.Nil(t, p.After)
+
+	p, err = FromRequest(httptest.NewRequest("GET", "/api/files?limit=1000", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, MaxLimit, p.Limit)
+
+	_, err = FromRequest(httptest.NewRequest("GET", "/api/files?limit=0", nil))
+	assert.Error(t, err)
+
+	_, err = FromRequest(httptest.NewRequest("GET", "/api/files?cursor=bogus", nil))
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestTrim(t *testing.T) {
+	cursor := func(n int) Cursor { return Cursor{CreatedAt: time.Unix(int64(n), 0), ID: "x"} }
+
+	rows, next := Trim([]int{1, 2, 3}, 3, cursor)
+	assert.Equal(t, []int{1, 2, 3}, rows)
+	assert.Empty(t, next)
+
+	rows, next = Trim([]int{1, 2, 3, 4}, 3, cursor)
+	assert.Equal(t, []int{1, 2, 3}, rows)
+	c, err := Decode(next)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), c.CreatedAt.Unix())
+}
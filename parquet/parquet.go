@@ -0,0 +1,473 @@
+// Package parquet writes Parquet files with a flat schema, the columnar
+// format that Athena, Glue and Spark query directly from S3. Every column
+// chunk is a single gzip-compressed data page of plainly encoded values,
+// which keeps the writer small while staying readable by any Parquet reader.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Kind is the type of a column's values
+type Kind int
+
+const (
+	// String columns hold UTF-8 text, written as strings
+	String Kind = iota
+	// Int32 columns hold int32 or int values
+	Int32
+	// Int64 columns hold int64 or int values
+	Int64
+	// Bool columns hold bool values
+	Bool
+	// Timestamp columns hold time.Time values, stored as UTC milliseconds
+	Timestamp
+)
+
+// Column describes one column of a file. Optional columns accept nil values.
+type Column struct {
+	Name     string
+	Kind     Kind
+	Optional bool
+}
+
+// Parquet physical types, encodings and codecs used by the writer
+const (
+	typeBoolean   = 0
+	typeInt32     = 1
+	typeInt64     = 2
+	typeByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecGzip = 2
+
+	pageData = 0
+)
+
+// magic starts and ends every Parquet file
+const magic = "PAR1"
+
+const (
+	// defaultRowGroupRows is how many rows a row group holds at most
+	defaultRowGroupRows = 100000
+	// maxRowGroupBytes flushes a row group early once its values take this
+	// much memory, so rows with large values don't pile up
+	maxRowGroupBytes = 64 << 20
+)
+
+// ErrClosed is returned when writing to a closed Writer
+var ErrClosed = errors.New("parquet: writer is closed")
+
+// Writer writes rows to a Parquet file. Rows are buffered in memory a row
+// group at a time; Close writes the last row group and the footer.
+type Writer struct {
+	w       io.Writer
+	offset  int64
+	columns []Column
+	// RowGroupRows is how many rows each row group holds at most
+	RowGroupRows int
+
+	buffers []columnBuffer
+	rows    int
+	groups  []rowGroup
+	total   int64
+	started bool
+	closed  bool
+	err     error
+}
+
+// columnBuffer holds a row group's values of one column
+type columnBuffer struct {
+	values bytes.Buffer
+	// levels is the definition level of each row: 1 for a value, 0 for nil
+	levels []byte
+	// bits packs Bool values, which PLAIN encoding stores one per bit
+	bits  []byte
+	nbits int
+}
+
+type columnChunk struct {
+	offset            int64
+	values            int64
+	uncompressedBytes int64
+	compressedBytes   int64
+}
+
+type rowGroup struct {
+	columns []columnChunk
+	rows    int64
+}
+
+// NewWriter returns a Writer of files with the given columns to w
+func NewWriter(w io.Writer, columns []Column) *Writer {
+	return &Writer{
+		w:            w,
+		columns:      columns,
+		RowGroupRows: defaultRowGroupRows,
+		buffers:      make([]columnBuffer, len(columns)),
+	}
+}
+
+// Write adds a row with a value for each column, in the columns' order
+func (w *Writer) Write(values ...interface{}) error {
+	if w.closed {
+		return ErrClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if len(values) != len(w.columns) {
+		return fmt.Errorf("parquet: row has %d values for %d columns", len(values), len(w.columns))
+	}
+	// Check the whole row first so a bad value doesn't leave the columns
+	// with different numbers of rows
+	for i, v := range values {
+		if err := w.columns[i].check(v); err != nil {
+			return err
+		}
+	}
+	for i, v := range values {
+		w.buffers[i].add(w.columns[i], v)
+	}
+	w.rows++
+
+	if w.rows >= w.RowGroupRows || w.buffered() >= maxRowGroupBytes {
+		w.err = w.flush()
+	}
+	return w.err
+}
+
+// Close writes the remaining rows and the file's footer. It doesn't close
+// the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	if w.rows > 0 {
+		if w.err = w.flush(); w.err != nil {
+			return w.err
+		}
+	}
+	if w.err = w.start(); w.err != nil {
+		return w.err
+	}
+	footer := w.footer()
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	for _, b := range [][]byte{footer, size[:], []byte(magic)} {
+		if w.err = w.write(b); w.err != nil {
+			return w.err
+		}
+	}
+	return nil
+}
+
+// Rows returns how many rows have been written
+func (w *Writer) Rows() int64 {
+	return w.total + int64(w.rows)
+}
+
+func (c Column) check(v interface{}) error {
+	if v == nil {
+		if !c.Optional {
+			return fmt.Errorf("parquet: column %s is required", c.Name)
+		}
+		return nil
+	}
+	ok := false
+	switch c.Kind {
+	case String:
+		_, ok = v.(string)
+	case Int32:
+		switch n := v.(type) {
+		case int32:
+			ok = true
+		case int:
+			ok = n >= math.MinInt32 && n <= math.MaxInt32
+		}
+	case Int64:
+		switch v.(type) {
+		case int64, int:
+			ok = true
+		}
+	case Bool:
+		_, ok = v.(bool)
+	case Timestamp:
+		_, ok = v.(time.Time)
+	}
+	if !ok {
+		return fmt.Errorf("parquet: invalid value %v (%T) for column %s", v, v, c.Name)
+	}
+	return nil
+}
+
+func (b *columnBuffer) add(c Column, v interface{}) {
+	if v == nil {
+		b.levels = append(b.levels, 0)
+		return
+	}
+	b.levels = append(b.levels, 1)
+
+	var scratch [8]byte
+	switch c.Kind {
+	case String:
+		s := v.(string)
+		binary.LittleEndian.PutUint32(scratch[:4], uint32(len(s)))
+		b.values.Write(scratch[:4])
+		b.values.WriteString(s)
+	case Int32:
+		var n int32
+		switch x := v.(type) {
+		case int32:
+			n = x
+		case int:
+			n = int32(x)
+		}
+		binary.LittleEndian.PutUint32(scratch[:4], uint32(n))
+		b.values.Write(scratch[:4])
+	case Int64:
+		var n int64
+		switch x := v.(type) {
+		case int64:
+			n = x
+		case int:
+			n = int64(x)
+		}
+		binary.LittleEndian.PutUint64(scratch[:], uint64(n))
+		b.values.Write(scratch[:])
+	case Timestamp:
+		binary.LittleEndian.PutUint64(scratch[:], uint64(v.(time.Time).UnixMilli()))
+		b.values.Write(scratch[:])
+	case Bool:
+		if b.nbits%8 == 0 {
+			b.bits = append(b.bits, 0)
+		}
+		if v.(bool) {
+			b.bits[len(b.bits)-1] |= 1 << (b.nbits % 8)
+		}
+		b.nbits++
+	}
+}
+
+func (w *Writer) buffered() int {
+	n := 0
+	for i := range w.buffers {
+		n += w.buffers[i].values.Len() + len(w.buffers[i].bits)
+	}
+	return n
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	return err
+}
+
+// start writes the leading magic bytes once
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	return w.write([]byte(magic))
+}
+
+// flush writes the buffered rows as a row group
+func (w *Writer) flush() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	group := rowGroup{rows: int64(w.rows)}
+	for i, c := range w.columns {
+		chunk, err := w.writeChunk(c, &w.buffers[i])
+		if err != nil {
+			return err
+		}
+		group.columns = append(group.columns, chunk)
+		w.buffers[i] = columnBuffer{}
+	}
+	w.groups = append(w.groups, group)
+	w.total += int64(w.rows)
+	w.rows = 0
+	return nil
+}
+
+// writeChunk writes a column's buffered values as one data page
+func (w *Writer) writeChunk(c Column, b *columnBuffer) (columnChunk, error) {
+	var page bytes.Buffer
+	if c.Optional {
+		levels := encodeLevels(b.levels)
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(levels)))
+		page.Write(size[:])
+		page.Write(levels)
+	}
+	if c.Kind == Bool {
+		page.Write(b.bits)
+	} else {
+		page.Write(b.values.Bytes())
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(page.Bytes()); err != nil {
+		return columnChunk{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return columnChunk{}, err
+	}
+
+	t := newThriftWriter()
+	t.i32(1, pageData)
+	t.i32(2, int32(page.Len()))
+	t.i32(3, int32(compressed.Len()))
+	t.beginStruct(5)
+	t.i32(1, int32(len(b.levels)))
+	t.i32(2, encodingPlain)
+	t.i32(3, encodingRLE)
+	t.i32(4, encodingRLE)
+	t.endStruct()
+	t.end()
+	header := t.Bytes()
+
+	chunk := columnChunk{
+		offset:            w.offset,
+		values:            int64(len(b.levels)),
+		uncompressedBytes: int64(len(header) + page.Len()),
+		compressedBytes:   int64(len(header) + compressed.Len()),
+	}
+	if err := w.write(header); err != nil {
+		return columnChunk{}, err
+	}
+	return chunk, w.write(compressed.Bytes())
+}
+
+// encodeLevels encodes definition levels of bit width 1 with the RLE/bit-
+// packing hybrid, as runs of equal levels
+func encodeLevels(levels []byte) []byte {
+	var out []byte
+	var scratch [binary.MaxVarintLen64]byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		out = append(out, scratch[:binary.PutUvarint(scratch[:], uint64(j-i)<<1)]...)
+		out = append(out, levels[i])
+		i = j
+	}
+	return out
+}
+
+// footer encodes the file's metadata: its schema and where each row group's
+// column chunks are
+func (w *Writer) footer() []byte {
+	t := newThriftWriter()
+	t.i32(1, 1)
+
+	t.list(2, thriftStruct, len(w.columns)+1)
+	t.beginElement()
+	t.string(4, "schema")
+	t.i32(5, int32(len(w.columns)))
+	t.endStruct()
+	for _, c := range w.columns {
+		t.beginElement()
+		t.i32(1, c.physicalType())
+		if c.Optional {
+			t.i32(3, repetitionOptional)
+		} else {
+			t.i32(3, repetitionRequired)
+		}
+		t.string(4, c.Name)
+		switch c.Kind {
+		case String:
+			t.i32(6, convertedUTF8)
+			t.beginStruct(10)
+			t.beginStruct(1) // STRING
+			t.endStruct()
+			t.endStruct()
+		case Timestamp:
+			t.i32(6, convertedTimestampMillis)
+			t.beginStruct(10)
+			t.beginStruct(8) // TIMESTAMP
+			t.bool(1, true)
+			t.beginStruct(2)
+			t.beginStruct(1) // MILLIS
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+		}
+		t.endStruct()
+	}
+
+	t.i64(3, w.total)
+
+	t.list(4, thriftStruct, len(w.groups))
+	for _, g := range w.groups {
+		t.beginElement()
+		t.list(1, thriftStruct, len(g.columns))
+		var uncompressed, compressed int64
+		for i, chunk := range g.columns {
+			c := w.columns[i]
+			t.beginElement()
+			t.i64(2, chunk.offset)
+			t.beginStruct(3)
+			t.i32(1, c.physicalType())
+			t.i32List(2, encodingPlain, encodingRLE)
+			t.stringList(3, c.Name)
+			t.i32(4, codecGzip)
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.uncompressedBytes)
+			t.i64(7, chunk.compressedBytes)
+			t.i64(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+			uncompressed += chunk.uncompressedBytes
+			compressed += chunk.compressedBytes
+		}
+		t.i64(2, uncompressed)
+		t.i64(3, g.rows)
+		if len(g.columns) > 0 {
+			t.i64(5, g.columns[0].offset)
+		}
+		t.i64(6, compressed)
+		t.endStruct()
+	}
+
+	t.string(6, "golang-aws-api")
+	t.end()
+	return t.Bytes()
+}
+
+func (c Column) physicalType() int32 {
+	switch c.Kind {
+	case Int32:
+		return typeInt32
+	case Int64, Timestamp:
+		return typeInt64
+	case Bool:
+		return typeBoolean
+	default:
+		return typeByteArray
+	}
+}
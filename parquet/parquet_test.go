@@ -0,0 +1,84 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testColumns = []Column{
+	{Name: "id", Kind: String},
+	{Name: "user_id", Kind: String, Optional: true},
+	{Name: "size", Kind: Int64},
+	{Name: "created_at", Kind: Timestamp},
+}
+
+func TestWriteFile(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, testColumns)
+	w.RowGroupRows = 2
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, w.Write("a", "u1", int64(10), now))
+	require.NoError(t, w.Write("b", nil, 20, now))
+	require.NoError(t, w.Write("c", "u2", int64(30), now))
+	require.NoError(t, w.Close())
+	assert.Equal(t, int64(3), w.Rows())
+	assert.Len(t, w.groups, 2)
+
+	data := buf.Bytes()
+	require.Greater(t, len(data), 12)
+	assert.Equal(t, magic, string(data[:4]))
+	assert.Equal(t, magic, string(data[len(data)-4:]))
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	assert.Equal(t, w.footer(), data[len(data)-8-footerLen:len(data)-8])
+	assert.Contains(t, string(data[len(data)-8-footerLen:]), "user_id")
+}
+
+func TestWriteEmptyFile(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, testColumns)
+	require.NoError(t, w.Close())
+
+	data := buf.Bytes()
+	assert.Equal(t, magic, string(data[:4]))
+	assert.Equal(t, magic, string(data[len(data)-4:]))
+	assert.Zero(t, w.Rows())
+}
+
+func TestWriteRejectsInvalidRows(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{}, testColumns)
+	now := time.Now()
+
+	assert.Error(t, w.Write("a", "u1", int64(10)))
+	assert.Error(t, w.Write(nil, "u1", int64(10), now))
+	assert.Error(t, w.Write("a", "u1", "10", now))
+	assert.Zero(t, w.Rows())
+	for _, b := range w.buffers {
+		assert.Empty(t, b.levels)
+	}
+
+	require.NoError(t, w.Close())
+	assert.ErrorIs(t, w.Write("a", "u1", int64(10), now), ErrClosed)
+}
+
+func TestEncodeLevels(t *testing.T) {
+	// Runs of two 1s, one 0 and three 1s, each a varint of length<<1 and
+	// the level
+	assert.Equal(t, []byte{4, 1, 2, 0, 6, 1}, encodeLevels([]byte{1, 1, 0, 1, 1, 1}))
+	assert.Empty(t, encodeLevels(nil))
+}
+
+func TestThriftFieldHeaders(t *testing.T) {
+	tw := newThriftWriter()
+	tw.i32(1, 3)
+	tw.i32(20, -1)
+	tw.bool(21, true)
+	tw.end()
+	// Field 1 is a short delta header; field 20 is 19 ids on, too far for
+	// one, so its id follows as a zigzag varint
+	assert.Equal(t, []byte{0x15, 6, 0x05, 40, 1, 0x11, 0}, tw.Bytes())
+}
@@ -0,0 +1,126 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol type codes
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the structs of the Parquet footer and page headers
+// with the Thrift compact protocol. Fields must be written in increasing id
+// order within each struct.
+type thriftWriter struct {
+	buf bytes.Buffer
+	// last holds the id of the last field written in each open struct
+	last []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func (t *thriftWriter) Bytes() []byte {
+	return t.buf.Bytes()
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) bool(id int16, v bool) {
+	if v {
+		t.fieldHeader(id, thriftTrue)
+	} else {
+		t.fieldHeader(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) string(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+// beginStruct starts a struct field; endStruct closes it
+func (t *thriftWriter) beginStruct(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.last = append(t.last, 0)
+}
+
+// beginElement starts a struct that is an element of a list
+func (t *thriftWriter) beginElement() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// end closes the top-level struct
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(0)
+}
+
+// list starts a list field of n elements of type elem, which the caller
+// writes next
+func (t *thriftWriter) list(id int16, elem byte, n int) {
+	t.fieldHeader(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elem)
+	t.varint(uint64(n))
+}
+
+func (t *thriftWriter) i32List(id int16, values ...int32) {
+	t.list(id, thriftI32, len(values))
+	for _, v := range values {
+		t.zigzag(int64(v))
+	}
+}
+
+func (t *thriftWriter) stringList(id int16, values ...string) {
+	t.list(id, thriftBinary, len(values))
+	for _, s := range values {
+		t.varint(uint64(len(s)))
+		t.buf.WriteString(s)
+	}
+}
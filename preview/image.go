@@ -0,0 +1,92 @@
+package preview
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// maxImagePixels caps the size of images that are decoded for a thumbnail,
+// so a small file claiming huge dimensions can't exhaust memory
+const maxImagePixels = 40_000_000
+
+// thumbnail scales an image down to fit in a size by size square. Images
+// that may be transparent stay PNG; photos become JPEG.
+func thumbnail(content []byte, size int) (*Preview, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("error reading image: %v", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large to preview", cfg.Width, cfg.Height)
+	}
+	var src image.Image
+	switch format {
+	case "png":
+		src, err = png.Decode(bytes.NewReader(content))
+	case "jpeg":
+		src, err = jpeg.Decode(bytes.NewReader(content))
+	case "gif":
+		src, err = gif.Decode(bytes.NewReader(content))
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %v", err)
+	}
+
+	thumb := scaleDown(src, size)
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+		return &Preview{Kind: KindImage, ContentType: "image/jpeg", Body: buf.Bytes()}, err
+	}
+	err = png.Encode(&buf, thumb)
+	return &Preview{Kind: KindImage, ContentType: "image/png", Body: buf.Bytes()}, err
+}
+
+// scaleDown shrinks src to fit in a size by size square, keeping its aspect
+// ratio, by averaging the source pixels under each thumbnail pixel. Images
+// that already fit are returned as they are.
+func scaleDown(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, src, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, (y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, (x+1)*w/tw
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := rgba.RGBAAt(b.Min.X+sx, b.Min.Y+sy)
+					r, g, bl, a = r+uint32(c.R), g+uint32(c.G), bl+uint32(c.B), a+uint32(c.A)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: uint8(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,208 @@
+package preview
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strings"
+)
+
+// maxPDFStreamBytes caps how much a single compressed PDF stream may inflate
+// to
+const maxPDFStreamBytes = 8 << 20
+
+// errNoPDFText is returned for PDFs without any text that can be read
+var errNoPDFText = errors.New("no text found in PDF")
+
+// firstPageText returns the text shown by the first content stream of a PDF
+// that shows any, which is the first page's in the PDFs writers usually
+// produce. Only streams that are uncompressed or Flate compressed are read,
+// and only text in literal strings, so text drawn with embedded CID fonts
+// doesn't come out.
+func firstPageText(pdf []byte) ([]byte, error) {
+	rest := pdf
+	for {
+		i := bytes.Index(rest, []byte("stream"))
+		if i < 0 {
+			return nil, errNoPDFText
+		}
+		dict := rest[:i]
+		if j := bytes.LastIndex(dict, []byte("obj")); j >= 0 {
+			dict = dict[j:]
+		}
+		data := rest[i+len("stream"):]
+		data = bytes.TrimPrefix(data, []byte("\r"))
+		data = bytes.TrimPrefix(data, []byte("\n"))
+		end := bytes.Index(data, []byte("endstream"))
+		if end < 0 {
+			return nil, errNoPDFText
+		}
+		rest = data[end+len("endstream"):]
+		if bytes.HasSuffix(dict, []byte("end")) {
+			// The "stream" of a previous "endstream"
+			continue
+		}
+
+		content, ok := streamContent(dict, data[:end])
+		if !ok {
+			continue
+		}
+		if text := strings.TrimSpace(showText(content)); text != "" {
+			return []byte(text), nil
+		}
+	}
+}
+
+// streamContent decodes a stream given its dictionary, reporting false for
+// streams that aren't page content or use a filter that isn't supported
+func streamContent(dict, data []byte) ([]byte, bool) {
+	for _, skip := range []string{"/Image", "/XRef", "/ObjStm", "/XObject", "/FontFile", "/Metadata"} {
+		if bytes.Contains(dict, []byte(skip)) {
+			return nil, false
+		}
+	}
+	if !bytes.Contains(dict, []byte("/Filter")) {
+		return data, true
+	}
+	if !bytes.Contains(dict, []byte("/FlateDecode")) || bytes.Contains(dict, []byte("/DecodeParms")) {
+		return nil, false
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	defer zr.Close()
+	content, err := io.ReadAll(io.LimitReader(zr, maxPDFStreamBytes))
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, false
+	}
+	return content, true
+}
+
+// showText runs the text operators of a content stream, collecting the
+// strings they show. Moves to a new line become line breaks.
+func showText(content []byte) string {
+	var out strings.Builder
+	var operands []string
+	newline := func() {
+		if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+			out.WriteByte('\n')
+		}
+	}
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := literalString(content[i:])
+			operands = append(operands, s)
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			// An inline dictionary, as used by marked content
+			end := bytes.Index(content[i:], []byte(">>"))
+			if end < 0 {
+				return out.String()
+			}
+			i += end + 2
+		case c == '<':
+			// Hex strings are usually glyph IDs, which can't be read
+			// without the font
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return out.String()
+			}
+			i += end + 1
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isPDFSpace(c) || c == '[' || c == ']':
+			i++
+		default:
+			start := i
+			for i < len(content) && !isPDFSpace(content[i]) && !strings.ContainsRune("()<>[]/%", rune(content[i])) {
+				i++
+			}
+			if i == start {
+				// A name's slash or a stray delimiter
+				i++
+				continue
+			}
+			switch string(content[start:i]) {
+			case "Tj", "TJ":
+				out.WriteString(strings.Join(operands, ""))
+			case "'", "\"":
+				newline()
+				out.WriteString(strings.Join(operands, ""))
+			case "T*", "Td", "TD", "ET":
+				newline()
+			default:
+				continue
+			}
+			operands = operands[:0]
+		}
+	}
+	return out.String()
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+// literalString decodes the PDF literal string at the start of b, returning
+// it and how many bytes it took. Bytes are read as Latin-1, which matches
+// the standard encodings for ASCII and most accented letters.
+func literalString(b []byte) (string, int) {
+	var s []rune
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch c {
+		case '(':
+			if depth > 0 {
+				s = append(s, '(')
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return string(s), i + 1
+			}
+			s = append(s, ')')
+		case '\\':
+			i++
+			if i == len(b) {
+				return string(s), i
+			}
+			switch e := b[i]; e {
+			case 'n':
+				s = append(s, '\n')
+			case 'r':
+				s = append(s, '\r')
+			case 't':
+				s = append(s, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// A line continuation
+				if e == '\r' && i+1 < len(b) && b[i+1] == '\n' {
+					i++
+				}
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					for n := 0; n < 3 && i < len(b) && b[i] >= '0' && b[i] <= '7'; n++ {
+						v = v*8 + int(b[i]-'0')
+						i++
+					}
+					i--
+					s = append(s, rune(v&0xff))
+					continue
+				}
+				s = append(s, rune(e))
+			}
+		default:
+			s = append(s, rune(c))
+		}
+	}
+	return string(s), len(b)
+}
@@ -0,0 +1,96 @@
+// Package preview renders small previews of file content for UIs: the head
+// of a text file, a thumbnail of an image, or the text of a PDF's first
+// page. The kind of preview is chosen by sniffing the content, not by the
+// file name.
+package preview
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Preview kinds
+const (
+	KindText  = "text"
+	KindImage = "image"
+	KindPDF   = "pdf"
+)
+
+// SniffLen is how many leading bytes Sniff looks at
+const SniffLen = 512
+
+// ErrUnsupported is returned for content there is no preview of
+var ErrUnsupported = errors.New("no preview for this type of content")
+
+// Preview is a rendered preview
+type Preview struct {
+	Kind        string
+	ContentType string
+	Body        []byte
+	// Truncated is set when a text preview stops before the end of the text
+	Truncated bool
+}
+
+// Options bound the size of a preview
+type Options struct {
+	// MaxTextBytes caps text previews, including text taken from a PDF
+	MaxTextBytes int
+	// ThumbnailSize is the longest side of an image thumbnail, in pixels
+	ThumbnailSize int
+}
+
+// Sniff returns the kind of preview for content starting with head, or ""
+// if there is none
+func Sniff(head []byte) string {
+	contentType := http.DetectContentType(head)
+	switch {
+	case contentType == "application/pdf":
+		return KindPDF
+	case contentType == "image/png", contentType == "image/jpeg", contentType == "image/gif":
+		return KindImage
+	case strings.HasPrefix(contentType, "text/"):
+		return KindText
+	}
+	return ""
+}
+
+// Render renders a preview of content. For text, content only needs to hold
+// the first MaxTextBytes bytes plus one, so callers can avoid reading more.
+func Render(content []byte, opts Options) (*Preview, error) {
+	switch Sniff(content) {
+	case KindText:
+		body, truncated := headText(content, opts.MaxTextBytes)
+		return &Preview{Kind: KindText, ContentType: "text/plain; charset=utf-8", Body: body, Truncated: truncated}, nil
+	case KindImage:
+		return thumbnail(content, opts.ThumbnailSize)
+	case KindPDF:
+		text, err := firstPageText(content)
+		if err != nil {
+			return nil, err
+		}
+		body, truncated := headText(text, opts.MaxTextBytes)
+		return &Preview{Kind: KindPDF, ContentType: "text/plain; charset=utf-8", Body: body, Truncated: truncated}, nil
+	}
+	return nil, ErrUnsupported
+}
+
+// headText returns at most max bytes of text, cut back to a whole UTF-8
+// character, and whether anything was cut
+func headText(text []byte, max int) ([]byte, bool) {
+	if len(text) <= max {
+		return text, false
+	}
+	text = text[:max]
+	// Drop a character split by the cut; at most utf8.UTFMax-1 bytes of it
+	// are left
+	for i := 0; i < utf8.UTFMax && len(text) > 0; i++ {
+		r, size := utf8.DecodeLastRune(text)
+		if r != utf8.RuneError || size > 1 {
+			break
+		}
+		text = text[:len(text)-1]
+	}
+	return text, true
+}
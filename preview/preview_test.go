@@ -0,0 +1,88 @@
+package preview
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/export"
+)
+
+var opts = Options{MaxTextBytes: 10, ThumbnailSize: 16}
+
+func TestRenderText(t *testing.T) {
+	p, err := Render([]byte("hello"), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, KindText, p.Kind)
+	assert.Equal(t, "hello", string(p.Body))
+	assert.False(t, p.Truncated)
+
+	// The cut never splits a character
+	p, err = Render([]byte("abcdefghé and more"), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdefghé", string(p.Body))
+	p, err = Render([]byte("abcdefghié and more"), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdefghi", string(p.Body))
+	assert.True(t, p.Truncated)
+}
+
+func TestRenderThumbnail(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			src.Set(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, src))
+
+	p, err := Render(buf.Bytes(), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, KindImage, p.Kind)
+	assert.Equal(t, "image/png", p.ContentType)
+	thumb, err := png.Decode(bytes.NewReader(p.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 16, 8), thumb.Bounds())
+	assert.Equal(t, color.RGBA{R: 200, A: 255}, color.RGBAModel.Convert(thumb.At(3, 3)))
+}
+
+func TestRenderPDFFirstPage(t *testing.T) {
+	records := []export.Record{{FileName: "a.txt", FileID: "f1", Status: "completed", Result: strings.Repeat("line\n", 80), ProcessedAt: time.Now()}}
+	var buf bytes.Buffer
+	assert.NoError(t, export.WritePDF(&buf, "Report (draft)", records))
+
+	p, err := Render(buf.Bytes(), Options{MaxTextBytes: 4096})
+	assert.NoError(t, err)
+	assert.Equal(t, KindPDF, p.Kind)
+	text := string(p.Body)
+	assert.True(t, strings.HasPrefix(text, "Report (draft)\n"), text)
+	assert.Contains(t, text, "File: a.txt")
+	// Only the first of the two pages
+	assert.Less(t, strings.Count(text, "line"), 50)
+}
+
+func TestFirstPageTextFlate(t *testing.T) {
+	var content bytes.Buffer
+	zw := zlib.NewWriter(&content)
+	zw.Write([]byte(`BT /F1 12 Tf 72 720 Td [(Hel) -20 (lo)] TJ T* (caf\351 \(1\)) Tj ET`))
+	zw.Close()
+	pdf := []byte("%PDF-1.4\n4 0 obj\n<< /Length 10 /Filter /FlateDecode >>\nstream\n")
+	pdf = append(pdf, content.Bytes()...)
+	pdf = append(pdf, []byte("\nendstream\nendobj\n%%EOF\n")...)
+
+	text, err := firstPageText(pdf)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello\ncafé (1)", string(text))
+}
+
+func TestRenderUnsupported(t *testing.T) {
+	_, err := Render([]byte{0x00, 0x01, 0x02, 0xff, 0xfe}, opts)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
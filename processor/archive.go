@@ -0,0 +1,217 @@
+package processor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register(archiveProcessor{})
+}
+
+// Defaults for the archive limits
+const (
+	DefaultArchiveMaxMembers = 1000
+	DefaultArchiveMaxBytes   = 256 << 20
+)
+
+// ErrArchiveLimit is returned, wrapped, for archives with more members or more
+// uncompressed content than ArchiveLimits allow
+var ErrArchiveLimit = errors.New("archive exceeds the expansion limits")
+
+// ArchiveLimits bound what reading an archive may unpack, so a small archive
+// can't expand into an unbounded amount of data
+type ArchiveLimits struct {
+	MaxMembers int
+	// MaxBytes caps the uncompressed size of all members together
+	MaxBytes int64
+}
+
+// LoadArchiveLimits reads the limits from ARCHIVE_MAX_MEMBERS and
+// ARCHIVE_MAX_EXPANDED_MB
+func LoadArchiveLimits() ArchiveLimits {
+	limits := ArchiveLimits{MaxMembers: DefaultArchiveMaxMembers, MaxBytes: DefaultArchiveMaxBytes}
+	if v := os.Getenv("ARCHIVE_MAX_MEMBERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Printf("Invalid ARCHIVE_MAX_MEMBERS %q, using %d", v, limits.MaxMembers)
+		} else {
+			limits.MaxMembers = n
+		}
+	}
+	if v := os.Getenv("ARCHIVE_MAX_EXPANDED_MB"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			log.Printf("Invalid ARCHIVE_MAX_EXPANDED_MB %q, using %d", v, limits.MaxBytes>>20)
+		} else {
+			limits.MaxBytes = n << 20
+		}
+	}
+	return limits
+}
+
+// ArchiveInfo is the result of the archive processor, as JSON
+type ArchiveInfo struct {
+	Format     string          `json:"format"`
+	Members    []ArchiveMember `json:"members"`
+	TotalBytes int64           `json:"total_bytes"`
+}
+
+// ArchiveMember is a regular file inside an archive. Path is cleaned of
+// leading slashes and ".." elements.
+type ArchiveMember struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// archiveProcessor lists the members of zip, tar and gzipped tar archives,
+// failing with ErrArchiveLimit for archives over the limits
+type archiveProcessor struct{}
+
+func (archiveProcessor) Name() string {
+	return "archive"
+}
+
+func (archiveProcessor) Process(ctx context.Context, name string, content []byte) (string, error) {
+	info := ArchiveInfo{Format: ArchiveFormat(name), Members: []ArchiveMember{}}
+	err := WalkArchive(name, content, LoadArchiveLimits(), func(m ArchiveMember, data []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info.Members = append(info.Members, m)
+		info.TotalBytes += m.SizeBytes
+		ReportProgress(ctx, int64(len(info.Members)), 0)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	result, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// ArchiveFormat returns "zip", "tar" or "tar.gz" for the archive types read
+// by WalkArchive, going by the file name, or "" for other files
+func ArchiveFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// WalkArchive calls fn with each regular file in an archive and its
+// uncompressed content, in archive order. Directories, links and other
+// special entries are skipped. Members are counted and sized as they are
+// read rather than trusting the archive's own headers, and going past limits
+// stops the walk with an error wrapping ErrArchiveLimit. An error from fn
+// stops it too and is returned as it is.
+func WalkArchive(name string, content []byte, limits ArchiveLimits, fn func(ArchiveMember, []byte) error) error {
+	w := archiveWalker{limits: limits, fn: fn}
+	switch ArchiveFormat(name) {
+	case "zip":
+		return w.zip(content)
+	case "tar.gz":
+		gz, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return fmt.Errorf("%w: not a gzip file: %v", ErrUnsupported, err)
+		}
+		defer gz.Close()
+		return w.tar(gz)
+	case "tar":
+		return w.tar(bytes.NewReader(content))
+	}
+	return fmt.Errorf("%w: not a zip or tar archive", ErrUnsupported)
+}
+
+// archiveWalker enforces the limits across the members of one archive
+type archiveWalker struct {
+	limits  ArchiveLimits
+	fn      func(ArchiveMember, []byte) error
+	members int
+	total   int64
+}
+
+func (w *archiveWalker) zip(content []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return fmt.Errorf("%w: not a zip file: %v", ErrUnsupported, err)
+	}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%w: reading %s: %v", ErrUnsupported, f.Name, err)
+		}
+		err = w.member(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *archiveWalker) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: reading tar archive: %v", ErrUnsupported, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := w.member(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// member reads one member within what is left of the limits and hands it to fn
+func (w *archiveWalker) member(name string, r io.Reader) error {
+	memberPath := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if memberPath == "" {
+		return nil
+	}
+	w.members++
+	if w.members > w.limits.MaxMembers {
+		return fmt.Errorf("%w: more than %d members", ErrArchiveLimit, w.limits.MaxMembers)
+	}
+
+	remaining := w.limits.MaxBytes - w.total
+	data, err := io.ReadAll(io.LimitReader(r, remaining+1))
+	if err != nil {
+		return fmt.Errorf("%w: reading %s: %v", ErrUnsupported, memberPath, err)
+	}
+	if int64(len(data)) > remaining {
+		return fmt.Errorf("%w: more than %d bytes uncompressed", ErrArchiveLimit, w.limits.MaxBytes)
+	}
+	w.total += int64(len(data))
+	return w.fn(ArchiveMember{Path: memberPath, SizeBytes: int64(len(data))}, data)
+}
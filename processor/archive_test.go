@@ -0,0 +1,100 @@
+package processor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		w.Write([]byte(content))
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}))
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		tw.Write([]byte(content))
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func walk(t *testing.T, name string, content []byte, limits ArchiveLimits) (map[string]string, error) {
+	members := make(map[string]string)
+	err := WalkArchive(name, content, limits, func(m ArchiveMember, data []byte) error {
+		assert.Equal(t, int64(len(data)), m.SizeBytes)
+		members[m.Path] = string(data)
+		return nil
+	})
+	return members, err
+}
+
+func TestWalkArchive(t *testing.T) {
+	files := map[string]string{"a.txt": "hello", "docs/b.txt": "world", "../../escape.txt": "x"}
+	want := map[string]string{"a.txt": "hello", "docs/b.txt": "world", "escape.txt": "x"}
+	limits := ArchiveLimits{MaxMembers: 10, MaxBytes: 1 << 20}
+
+	members, err := walk(t, "bundle.zip", zipArchive(t, files), limits)
+	require.NoError(t, err)
+	assert.Equal(t, want, members)
+
+	// Directories and links are skipped
+	members, err = walk(t, "bundle.TGZ", tarGzArchive(t, files), limits)
+	require.NoError(t, err)
+	assert.Equal(t, want, members)
+}
+
+func TestWalkArchiveLimits(t *testing.T) {
+	files := map[string]string{"a.txt": "aaaa", "b.txt": "bbbb", "c.txt": "cccc"}
+
+	_, err := walk(t, "x.zip", zipArchive(t, files), ArchiveLimits{MaxMembers: 2, MaxBytes: 1 << 20})
+	assert.ErrorIs(t, err, ErrArchiveLimit)
+
+	_, err = walk(t, "x.tar.gz", tarGzArchive(t, files), ArchiveLimits{MaxMembers: 10, MaxBytes: 10})
+	assert.ErrorIs(t, err, ErrArchiveLimit)
+
+	// A highly compressible member is measured by what it expands to
+	bomb := zipArchive(t, map[string]string{"zeros": strings.Repeat("\x00", 1<<20)})
+	assert.Less(t, len(bomb), 4096)
+	_, err = walk(t, "bomb.zip", bomb, ArchiveLimits{MaxMembers: 10, MaxBytes: 1 << 16})
+	assert.ErrorIs(t, err, ErrArchiveLimit)
+}
+
+func TestWalkArchiveUnsupported(t *testing.T) {
+	_, err := walk(t, "x.zip", []byte("not a zip"), ArchiveLimits{MaxMembers: 10, MaxBytes: 1 << 20})
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestArchiveProcessor(t *testing.T) {
+	assert.Equal(t, "archive", ForFile("bundle.tar.gz").Name())
+
+	content := zipArchive(t, map[string]string{"a.txt": "hello"})
+	result, err := ForFile("bundle.zip").Process(context.Background(), "bundle.zip", content)
+	require.NoError(t, err)
+
+	var info ArchiveInfo
+	require.NoError(t, json.Unmarshal([]byte(result), &info))
+	assert.Equal(t, ArchiveInfo{Format: "zip", Members: []ArchiveMember{{"a.txt", 5}}, TotalBytes: 5}, info)
+}
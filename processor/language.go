@@ -0,0 +1,126 @@
+package processor
+
+import (
+	"unicode"
+)
+
+// LanguageUndetermined is reported when a text's language can't be told, the
+// ISO 639-2 code for it
+const LanguageUndetermined = "und"
+
+// minStopwordHits is how many common words a Latin-script text needs before
+// its language is guessed from them
+const minStopwordHits = 3
+
+// stopwords are the most common words of the Latin-script languages that are
+// detected, by ISO 639-1 code
+var stopwords = map[string]map[string]bool{
+	"en": wordSet("the", "and", "of", "to", "in", "is", "that", "it", "was", "for", "on", "are", "with", "as", "this", "be", "at", "by", "not", "or", "from", "have", "but", "they", "which", "you", "we", "were", "has", "been"),
+	"es": wordSet("el", "la", "de", "que", "y", "en", "los", "se", "del", "las", "un", "por", "con", "no", "una", "su", "para", "es", "al", "lo", "como", "más", "pero", "sus", "le", "ya", "fue", "este", "ha", "porque"),
+	"fr": wordSet("le", "la", "les", "de", "des", "et", "un", "une", "du", "est", "que", "qui", "dans", "pour", "pas", "sur", "au", "avec", "il", "elle", "ce", "sont", "ne", "se", "plus", "par", "nous", "vous", "mais", "ou"),
+	"de": wordSet("der", "die", "und", "in", "den", "von", "zu", "das", "mit", "sich", "des", "auf", "für", "ist", "im", "dem", "nicht", "ein", "eine", "als", "auch", "es", "an", "werden", "aus", "er", "hat", "dass", "sie", "nach"),
+	"it": wordSet("il", "di", "che", "e", "la", "per", "un", "in", "non", "una", "sono", "del", "della", "le", "si", "da", "con", "gli", "al", "lo", "ma", "come", "anche", "più", "nel", "questo", "dei", "delle", "ha", "io"),
+	"pt": wordSet("o", "de", "que", "e", "do", "da", "em", "um", "para", "com", "não", "uma", "os", "no", "se", "na", "por", "mais", "as", "dos", "como", "mas", "ao", "ele", "das", "à", "seu", "sua", "ou", "quando"),
+	"nl": wordSet("de", "het", "een", "en", "van", "ik", "te", "dat", "die", "in", "is", "niet", "op", "zijn", "met", "voor", "hij", "maar", "ook", "als", "bij", "er", "aan", "om", "dan", "nog", "wel", "naar", "geen", "worden"),
+}
+
+// scriptLanguages maps the scripts other than Latin to the language they are
+// taken to mean. Japanese text mixes Han with kana, so kana is checked first.
+var scriptLanguages = []struct {
+	table    *unicode.RangeTable
+	language string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Greek, "el"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+}
+
+func wordSet(words ...string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
+
+// isStopword reports whether a lower-case word is common in any of the
+// detected languages
+func isStopword(word string) bool {
+	for _, set := range stopwords {
+		if set[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectLanguage guesses the language of a text from its script and, for
+// Latin script, from how many of each language's common words it uses. words
+// are the text's lower-case words. It returns an ISO 639-1 code with a
+// confidence between 0 and 1, or LanguageUndetermined with 0.
+func DetectLanguage(text string, words []string) (string, float64) {
+	scripts := make(map[string]int)
+	latin, letters := 0, 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.table, r) {
+				scripts[s.language]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return LanguageUndetermined, 0
+	}
+
+	// Kana marks Japanese even when Han characters outnumber it
+	if scripts["ja"] > 0 {
+		scripts["ja"] += scripts["zh"]
+		delete(scripts, "zh")
+	}
+	best, bestCount := "", 0
+	for language, n := range scripts {
+		if n > bestCount || (n == bestCount && language < best) {
+			best, bestCount = language, n
+		}
+	}
+	if bestCount > latin {
+		return best, round(float64(bestCount)/float64(letters), 2)
+	}
+
+	hits := make(map[string]int, len(stopwords))
+	total := 0
+	for _, w := range words {
+		for language, set := range stopwords {
+			if set[w] {
+				hits[language]++
+				total++
+			}
+		}
+	}
+	best, bestCount = "", 0
+	for language, n := range hits {
+		if n > bestCount || (n == bestCount && language < best) {
+			best, bestCount = language, n
+		}
+	}
+	if bestCount < minStopwordHits {
+		return LanguageUndetermined, 0
+	}
+	return best, round(float64(bestCount)/float64(total), 2)
+}
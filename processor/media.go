@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register(mediaProcessor{})
+}
+
+// mediaExtensions are the file types ForFile hands to the media processor
+var mediaExtensions = map[string]bool{
+	".mp4": true, ".m4v": true, ".m4a": true, ".mov": true,
+	".mp3": true, ".wav": true, ".flac": true,
+	".mkv": true, ".webm": true, ".avi": true, ".ogg": true,
+}
+
+// MediaInfo is the result of the media processor, as JSON. BitRate is in
+// bits per second.
+type MediaInfo struct {
+	Format          string  `json:"format"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	BitRate         int64   `json:"bit_rate"`
+	VideoCodec      string  `json:"video_codec,omitempty"`
+	Width           int     `json:"width,omitempty"`
+	Height          int     `json:"height,omitempty"`
+	AudioCodec      string  `json:"audio_codec,omitempty"`
+	SampleRate      int     `json:"sample_rate,omitempty"`
+	Channels        int     `json:"channels,omitempty"`
+}
+
+// mediaProcessor extracts the duration, codecs, resolution and bit rate of
+// audio and video files. It probes MP4/QuickTime, MP3, WAV and FLAC itself;
+// with MEDIA_FFPROBE_PATH set every format ffprobe knows is handed to it
+// instead. Formats neither can read fail with ErrUnsupported.
+type mediaProcessor struct{}
+
+func (mediaProcessor) Name() string {
+	return "media"
+}
+
+func (mediaProcessor) Process(ctx context.Context, name string, content []byte) (string, error) {
+	var info *MediaInfo
+	var err error
+	if ffprobe := os.Getenv("MEDIA_FFPROBE_PATH"); ffprobe != "" {
+		info, err = ffprobeMedia(ctx, ffprobe, content)
+	} else {
+		info, err = ProbeMedia(content)
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.BitRate == 0 && info.DurationSeconds > 0 {
+		info.BitRate = int64(float64(len(content)) * 8 / info.DurationSeconds)
+	}
+	info.DurationSeconds = round(info.DurationSeconds, 3)
+
+	result, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// isMediaFile reports whether a file name has one of mediaExtensions
+func isMediaFile(name string) bool {
+	return mediaExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// ffprobeOutput is the part of ffprobe's JSON output that is used
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType  string `json:"codec_type"`
+		CodecName  string `json:"codec_name"`
+		Width      int    `json:"width"`
+		Height     int    `json:"height"`
+		SampleRate string `json:"sample_rate"`
+		Channels   int    `json:"channels"`
+	} `json:"streams"`
+}
+
+// ffprobeMedia runs ffprobe on content. It is written to a temporary file
+// rather than piped, as MP4 files with their index at the end can't be probed
+// from a pipe.
+func ffprobeMedia(ctx context.Context, ffprobe string, content []byte) (*MediaInfo, error) {
+	f, err := os.CreateTemp("", "probe-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffprobe, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", f.Name())
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if strings.Contains(msg, "Invalid data found") {
+			return nil, fmt.Errorf("%w: not a media file ffprobe can read", ErrUnsupported)
+		}
+		return nil, fmt.Errorf("ffprobe: %v: %s", err, msg)
+	}
+
+	var out ffprobeOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("decoding ffprobe output: %v", err)
+	}
+	info := &MediaInfo{Format: strings.Split(out.Format.FormatName, ",")[0]}
+	info.DurationSeconds, _ = strconv.ParseFloat(out.Format.Duration, 64)
+	info.BitRate, _ = strconv.ParseInt(out.Format.BitRate, 10, 64)
+	for _, s := range out.Streams {
+		switch {
+		case s.CodecType == "video" && info.VideoCodec == "":
+			info.VideoCodec, info.Width, info.Height = s.CodecName, s.Width, s.Height
+		case s.CodecType == "audio" && info.AudioCodec == "":
+			info.AudioCodec, info.Channels = s.CodecName, s.Channels
+			info.SampleRate, _ = strconv.Atoi(s.SampleRate)
+		}
+	}
+	if info.VideoCodec == "" && info.AudioCodec == "" {
+		return nil, fmt.Errorf("%w: no audio or video streams", ErrUnsupported)
+	}
+	return info, nil
+}
@@ -0,0 +1,149 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// le and be append integers to b in little- and big-endian order
+func le(b []byte, vs ...interface{}) []byte {
+	var buf bytes.Buffer
+	buf.Write(b)
+	for _, v := range vs {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
+
+func be(b []byte, vs ...interface{}) []byte {
+	var buf bytes.Buffer
+	buf.Write(b)
+	for _, v := range vs {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	return buf.Bytes()
+}
+
+// box builds an MP4 box from its type and body parts
+func box(typ string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	return append(be(nil, uint32(8+len(body))), append([]byte(typ), body...)...)
+}
+
+func TestProbeWAV(t *testing.T) {
+	// Two seconds of 16-bit stereo PCM at 8 kHz
+	data := make([]byte, 2*8000*4)
+	wav := []byte("RIFF\x00\x00\x00\x00WAVE")
+	wav = append(wav, "fmt "...)
+	wav = le(wav, uint32(16), uint16(1), uint16(2), uint32(8000), uint32(32000), uint16(4), uint16(16))
+	wav = append(wav, "data"...)
+	wav = le(wav, uint32(len(data)))
+	wav = append(wav, data...)
+
+	info, err := ProbeMedia(wav)
+	require.NoError(t, err)
+	assert.Equal(t, &MediaInfo{Format: "wav", DurationSeconds: 2, BitRate: 256000, AudioCodec: "pcm", SampleRate: 8000, Channels: 2}, info)
+}
+
+func TestProbeFLAC(t *testing.T) {
+	// STREAMINFO for 44.1 kHz mono with 441000 samples
+	si := make([]byte, 34)
+	si[10], si[11], si[12] = 0x0A, 0xC4, 0x40
+	binary.BigEndian.PutUint32(si[14:18], 441000)
+	flac := append([]byte("fLaC\x80\x00\x00\x22"), si...)
+
+	info, err := ProbeMedia(flac)
+	require.NoError(t, err)
+	assert.Equal(t, &MediaInfo{Format: "flac", DurationSeconds: 10, AudioCodec: "flac", SampleRate: 44100, Channels: 1}, info)
+}
+
+func TestProbeMP3(t *testing.T) {
+	// An ID3v2 tag, then MPEG-1 layer III frames at 128 kbit/s, 44.1 kHz,
+	// joint stereo: 417 bytes each
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x40})
+	mp3 := append([]byte("ID3\x03\x00\x00\x00\x00\x00\x0A"), make([]byte, 10)...)
+	for i := 0; i < 100; i++ {
+		mp3 = append(mp3, frame...)
+	}
+
+	info, err := ProbeMedia(mp3)
+	require.NoError(t, err)
+	assert.Equal(t, "mp3", info.AudioCodec)
+	assert.Equal(t, 44100, info.SampleRate)
+	assert.Equal(t, 2, info.Channels)
+	assert.Equal(t, int64(128000), info.BitRate)
+	assert.InDelta(t, 41700*8/128000.0, info.DurationSeconds, 0.001)
+
+	// A Xing header's frame count takes precedence
+	xing := append([]byte(nil), frame...)
+	copy(xing[4+32:], "Xing")
+	binary.BigEndian.PutUint32(xing[4+32+4:], 1)
+	binary.BigEndian.PutUint32(xing[4+32+8:], 1000)
+	info, err = ProbeMedia(xing)
+	require.NoError(t, err)
+	assert.InDelta(t, 1000*1152/44100.0, info.DurationSeconds, 0.001)
+}
+
+func TestProbeMP4(t *testing.T) {
+	mvhd := be(make([]byte, 12), uint32(1000), uint32(90500))
+	videoEntry := be(append(make([]byte, 4), "avc1"...), make([]byte, 24), uint16(1920), uint16(1080))
+	audioEntry := be(append(make([]byte, 4), "mp4a"...), make([]byte, 16), uint16(2), uint16(16), uint32(0), uint32(48000<<16))
+	trak := func(handler string, entry []byte) []byte {
+		return box("trak", box("mdia",
+			box("hdlr", make([]byte, 8), []byte(handler), make([]byte, 12)),
+			box("minf", box("stbl", box("stsd", be(make([]byte, 4), uint32(1)), entry))),
+		))
+	}
+	mp4 := bytes.Join([][]byte{
+		box("ftyp", []byte("isom"), make([]byte, 4)),
+		box("mdat", make([]byte, 64)),
+		box("moov", box("mvhd", mvhd), trak("vide", videoEntry), trak("soun", audioEntry)),
+	}, nil)
+
+	info, err := ProbeMedia(mp4)
+	require.NoError(t, err)
+	assert.Equal(t, &MediaInfo{
+		Format: "mp4", DurationSeconds: 90.5,
+		VideoCodec: "h264", Width: 1920, Height: 1080,
+		AudioCodec: "aac", SampleRate: 48000, Channels: 2,
+	}, info)
+}
+
+func TestProbeMediaUnsupported(t *testing.T) {
+	for name, content := range map[string][]byte{
+		"text":     []byte("just some text"),
+		"matroska": {0x1A, 0x45, 0xDF, 0xA3, 0x00},
+		"ogg":      []byte("OggS\x00\x02"),
+	} {
+		_, err := ProbeMedia(content)
+		assert.ErrorIs(t, err, ErrUnsupported, name)
+	}
+}
+
+func TestMediaProcessor(t *testing.T) {
+	t.Setenv("MEDIA_FFPROBE_PATH", "")
+	assert.Equal(t, "media", ForFile("clip.MP4").Name())
+	assert.Equal(t, "text", ForFile("notes.txt").Name())
+
+	flac := append([]byte("fLaC\x80\x00\x00\x22"), make([]byte, 34)...)
+	flac[8+10], flac[8+11], flac[8+12] = 0x0A, 0xC4, 0x40
+	binary.BigEndian.PutUint32(flac[8+14:], 88200)
+	result, err := ForFile("a.flac").Process(context.Background(), "a.flac", flac)
+	require.NoError(t, err)
+
+	var info MediaInfo
+	require.NoError(t, json.Unmarshal([]byte(result), &info))
+	assert.Equal(t, 2.0, info.DurationSeconds)
+	// Without a bit rate in the headers it is the file's average
+	assert.Equal(t, int64(len(flac)*8/2), info.BitRate)
+
+	_, err = ForFile("a.mkv").Process(context.Background(), "a.mkv", []byte{0x1A, 0x45, 0xDF, 0xA3})
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
@@ -0,0 +1,384 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// ProbeMedia reads the metadata of an MP4/QuickTime, MP3, WAV or FLAC file
+// from its headers. Other formats fail with ErrUnsupported.
+func ProbeMedia(content []byte) (*MediaInfo, error) {
+	switch {
+	case len(content) >= 12 && string(content[0:4]) == "RIFF" && string(content[8:12]) == "WAVE":
+		return probeWAV(content)
+	case len(content) >= 4 && string(content[0:4]) == "fLaC":
+		return probeFLAC(content)
+	case len(content) >= 12 && string(content[4:8]) == "ftyp":
+		return probeMP4(content)
+	case len(content) >= 3 && string(content[0:3]) == "ID3", isMP3Frame(content):
+		return probeMP3(content)
+	case len(content) >= 4 && bytes.Equal(content[0:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return nil, unsupportedMedia("Matroska/WebM")
+	case len(content) >= 12 && string(content[0:4]) == "RIFF" && string(content[8:12]) == "AVI ":
+		return nil, unsupportedMedia("AVI")
+	case len(content) >= 4 && string(content[0:4]) == "OggS":
+		return nil, unsupportedMedia("Ogg")
+	}
+	return nil, fmt.Errorf("%w: not an audio or video file", ErrUnsupported)
+}
+
+// unsupportedMedia is the error for a recognised format that only ffprobe reads
+func unsupportedMedia(format string) error {
+	return fmt.Errorf("%w: %s files need MEDIA_FFPROBE_PATH set", ErrUnsupported, format)
+}
+
+// probeWAV reads the fmt chunk of a RIFF WAVE file and sizes its data chunk
+func probeWAV(content []byte) (*MediaInfo, error) {
+	info := &MediaInfo{Format: "wav"}
+	var byteRate uint32
+	var dataSize int64 = -1
+	for off := 12; off+8 <= len(content); {
+		id := string(content[off : off+4])
+		size := int64(binary.LittleEndian.Uint32(content[off+4 : off+8]))
+		body := content[off+8:]
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, fmt.Errorf("%w: truncated WAV fmt chunk", ErrUnsupported)
+			}
+			info.AudioCodec = wavCodec(binary.LittleEndian.Uint16(body[0:2]))
+			info.Channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			byteRate = binary.LittleEndian.Uint32(body[8:12])
+		case "data":
+			// Streamed files leave the size unset or too large
+			dataSize = size
+			if available := int64(len(body)); dataSize > available || dataSize == 0 {
+				dataSize = available
+			}
+		}
+		if dataSize >= 0 && byteRate > 0 {
+			break
+		}
+		// Chunks are padded to an even size
+		off += 8 + int(size+size&1)
+	}
+	if info.AudioCodec == "" || dataSize < 0 {
+		return nil, fmt.Errorf("%w: WAV file without fmt or data chunk", ErrUnsupported)
+	}
+	info.BitRate = int64(byteRate) * 8
+	if byteRate > 0 {
+		info.DurationSeconds = float64(dataSize) / float64(byteRate)
+	}
+	return info, nil
+}
+
+// wavCodec names a WAVE format tag
+func wavCodec(tag uint16) string {
+	switch tag {
+	case 1, 0xFFFE:
+		return "pcm"
+	case 3:
+		return "pcm_float"
+	case 6:
+		return "alaw"
+	case 7:
+		return "mulaw"
+	case 0x55:
+		return "mp3"
+	}
+	return fmt.Sprintf("0x%04x", tag)
+}
+
+// probeFLAC reads the STREAMINFO block that starts every FLAC file
+func probeFLAC(content []byte) (*MediaInfo, error) {
+	// "fLaC", a 4-byte block header and 18 bytes up to the sample count
+	if len(content) < 8+18 || content[4]&0x7F != 0 {
+		return nil, fmt.Errorf("%w: FLAC file without STREAMINFO", ErrUnsupported)
+	}
+	si := content[8:]
+	sampleRate := int(si[10])<<12 | int(si[11])<<4 | int(si[12])>>4
+	channels := int(si[12]>>1&0x07) + 1
+	samples := int64(si[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(si[14:18]))
+
+	info := &MediaInfo{Format: "flac", AudioCodec: "flac", SampleRate: sampleRate, Channels: channels}
+	if sampleRate > 0 {
+		info.DurationSeconds = float64(samples) / float64(sampleRate)
+	}
+	return info, nil
+}
+
+// MPEG audio bit rates in kbit/s by version group and layer, and sample rates
+// in Hz by version
+var (
+	mp3BitRates = map[[2]int][16]int{
+		{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	mp3SampleRates = map[int][3]int{
+		1:  {44100, 48000, 32000},
+		2:  {22050, 24000, 16000},
+		25: {11025, 12000, 8000},
+	}
+)
+
+// mp3Frame is a decoded MPEG audio frame header
+type mp3Frame struct {
+	version    int // 1, 2 or 25 for MPEG 2.5
+	layer      int
+	bitRate    int // bits per second
+	sampleRate int
+	channels   int
+}
+
+// isMP3Frame reports whether content starts with an MPEG audio frame header
+func isMP3Frame(content []byte) bool {
+	_, ok := parseMP3Frame(content)
+	return ok
+}
+
+func parseMP3Frame(b []byte) (mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	var f mp3Frame
+	switch b[1] >> 3 & 0x03 {
+	case 0:
+		f.version = 25
+	case 2:
+		f.version = 2
+	case 3:
+		f.version = 1
+	default:
+		return mp3Frame{}, false
+	}
+	f.layer = 4 - int(b[1]>>1&0x03)
+	bitRateIndex, sampleRateIndex := int(b[2]>>4), int(b[2]>>2&0x03)
+	if f.layer == 4 || bitRateIndex == 0 || bitRateIndex == 15 || sampleRateIndex == 3 {
+		return mp3Frame{}, false
+	}
+	group := 1
+	if f.version != 1 {
+		group = 2
+	}
+	f.bitRate = mp3BitRates[[2]int{group, f.layer}][bitRateIndex] * 1000
+	f.sampleRate = mp3SampleRates[f.version][sampleRateIndex]
+	f.channels = 2
+	if b[3]>>6 == 3 {
+		f.channels = 1
+	}
+	return f, true
+}
+
+// samplesPerFrame is how many samples each channel gets in one frame
+func (f mp3Frame) samplesPerFrame() int {
+	switch {
+	case f.layer == 1:
+		return 384
+	case f.layer == 3 && f.version != 1:
+		return 576
+	}
+	return 1152
+}
+
+// probeMP3 reads the first MPEG audio frame after any ID3v2 tag. The duration
+// comes from the frame count of a Xing or Info header when the encoder wrote
+// one, and otherwise from the first frame's bit rate, which is exact only for
+// constant bit rate files.
+func probeMP3(content []byte) (*MediaInfo, error) {
+	off := 0
+	if len(content) >= 10 && string(content[0:3]) == "ID3" {
+		// The tag size is a 28-bit "syncsafe" integer, 7 bits per byte
+		size := int(content[6]&0x7F)<<21 | int(content[7]&0x7F)<<14 | int(content[8]&0x7F)<<7 | int(content[9]&0x7F)
+		off = 10 + size
+		if content[5]&0x10 != 0 {
+			off += 10
+		}
+	}
+	// Skip any padding between the tag and the first frame
+	var frame mp3Frame
+	for {
+		if off+4 > len(content) {
+			return nil, fmt.Errorf("%w: no MPEG audio frame found", ErrUnsupported)
+		}
+		var ok bool
+		if frame, ok = parseMP3Frame(content[off:]); ok {
+			break
+		}
+		off++
+	}
+	audioBytes := int64(len(content) - off)
+
+	names := map[int]string{1: "mp1", 2: "mp2", 3: "mp3"}
+	info := &MediaInfo{
+		Format:     "mp3",
+		AudioCodec: names[frame.layer],
+		SampleRate: frame.sampleRate,
+		Channels:   frame.channels,
+		BitRate:    int64(frame.bitRate),
+	}
+	if frames := xingFrames(content[off:], frame); frames > 0 && frame.sampleRate > 0 {
+		info.DurationSeconds = float64(frames) * float64(frame.samplesPerFrame()) / float64(frame.sampleRate)
+		info.BitRate = int64(float64(audioBytes) * 8 / info.DurationSeconds)
+	} else if frame.bitRate > 0 {
+		info.DurationSeconds = float64(audioBytes) * 8 / float64(frame.bitRate)
+	}
+	return info, nil
+}
+
+// xingFrames returns the frame count from the Xing or Info header in the
+// first frame of a variable bit rate MP3, or 0 if there is none
+func xingFrames(b []byte, f mp3Frame) int64 {
+	// The header follows the side information, whose size depends on the
+	// version and channel count
+	side := 17
+	switch {
+	case f.version == 1 && f.channels == 2:
+		side = 32
+	case f.version != 1 && f.channels == 1:
+		side = 9
+	}
+	off := 4 + side
+	if len(b) < off+12 {
+		return 0
+	}
+	if id := string(b[off : off+4]); id != "Xing" && id != "Info" {
+		return 0
+	}
+	// Bit 0 of the flags says the frame count is present
+	if binary.BigEndian.Uint32(b[off+4:off+8])&1 == 0 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint32(b[off+8 : off+12]))
+}
+
+// mp4Codecs maps sample entry types to codec names as ffprobe reports them
+var mp4Codecs = map[string]string{
+	"avc1": "h264", "avc3": "h264",
+	"hvc1": "hevc", "hev1": "hevc",
+	"av01": "av1", "vp09": "vp9", "mp4v": "mpeg4",
+	"jpeg": "mjpeg", "apcn": "prores", "apch": "prores",
+	"mp4a": "aac", "ac-3": "ac3", "ec-3": "eac3",
+	"alac": "alac", "Opus": "opus", "fLaC": "flac",
+	"lpcm": "pcm", "sowt": "pcm", "twos": "pcm",
+}
+
+// mp4Box is one box of an ISO base media file: its type and its body
+type mp4Box struct {
+	typ  string
+	body []byte
+}
+
+// mp4Boxes splits b into boxes, stopping at a box that doesn't fit
+func mp4Boxes(b []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b[0:4]))
+		typ := string(b[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return boxes
+			}
+			size, header = binary.BigEndian.Uint64(b[8:16]), 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return boxes
+		}
+		boxes = append(boxes, mp4Box{typ, b[header:size]})
+		b = b[size:]
+	}
+	return boxes
+}
+
+// findBox returns the body of the first box of type typ in b, or nil
+func findBox(b []byte, typ string) []byte {
+	for _, box := range mp4Boxes(b) {
+		if box.typ == typ {
+			return box.body
+		}
+	}
+	return nil
+}
+
+// probeMP4 reads the movie header and the track descriptions of an
+// MP4/QuickTime file. The moov box may sit before or after the media data.
+func probeMP4(content []byte) (*MediaInfo, error) {
+	info := &MediaInfo{Format: "mp4"}
+	if ftyp := findBox(content, "ftyp"); len(ftyp) >= 4 && string(ftyp[0:4]) == "qt  " {
+		info.Format = "mov"
+	}
+	moov := findBox(content, "moov")
+	if moov == nil {
+		return nil, fmt.Errorf("%w: MP4 file without a moov box", ErrUnsupported)
+	}
+
+	if mvhd := findBox(moov, "mvhd"); len(mvhd) >= 20 {
+		var timescale uint32
+		var duration uint64
+		if mvhd[0] == 1 && len(mvhd) >= 32 {
+			timescale = binary.BigEndian.Uint32(mvhd[20:24])
+			duration = binary.BigEndian.Uint64(mvhd[24:32])
+		} else {
+			timescale = binary.BigEndian.Uint32(mvhd[12:16])
+			duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+		}
+		if timescale > 0 {
+			info.DurationSeconds = float64(duration) / float64(timescale)
+		}
+	}
+
+	for _, box := range mp4Boxes(moov) {
+		if box.typ != "trak" {
+			continue
+		}
+		mdia := findBox(box.body, "mdia")
+		hdlr := findBox(mdia, "hdlr")
+		stsd := findBox(findBox(findBox(mdia, "minf"), "stbl"), "stsd")
+		// hdlr: version and flags, pre-defined, then the handler type.
+		// stsd: version and flags, entry count, then the first entry's size
+		// and type, six reserved bytes and a data reference index.
+		if len(hdlr) < 12 || len(stsd) < 16 {
+			continue
+		}
+		entry := stsd[8:]
+		fourcc := string(entry[4:8])
+		codec, ok := mp4Codecs[fourcc]
+		if !ok {
+			codec = fourcc
+		}
+		switch string(hdlr[8:12]) {
+		case "vide":
+			// Sixteen bytes of pre-defined and reserved fields precede the
+			// width and height
+			if info.VideoCodec == "" && len(entry) >= 36 {
+				info.VideoCodec = codec
+				info.Width = int(binary.BigEndian.Uint16(entry[32:34]))
+				info.Height = int(binary.BigEndian.Uint16(entry[34:36]))
+			}
+		case "soun":
+			// Eight reserved bytes precede the channel count, sample size,
+			// two more reserved fields and the 16.16 fixed-point sample rate
+			if info.AudioCodec == "" && len(entry) >= 36 {
+				info.AudioCodec = codec
+				info.Channels = int(binary.BigEndian.Uint16(entry[24:26]))
+				info.SampleRate = int(binary.BigEndian.Uint16(entry[32:34]))
+			}
+		}
+	}
+	if info.VideoCodec == "" && info.AudioCodec == "" {
+		return nil, fmt.Errorf("%w: MP4 file without audio or video tracks", ErrUnsupported)
+	}
+	if info.VideoCodec == "" && info.Format == "mp4" {
+		info.Format = "m4a"
+	}
+	return info, nil
+}
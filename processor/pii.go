@@ -0,0 +1,205 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+func init() {
+	Register(piiProcessor{})
+}
+
+// Types of personal data the pii processor finds itself, named as Amazon
+// Comprehend names them
+const (
+	PIIEmail = "EMAIL"
+	PIIPhone = "PHONE"
+	PIISSN   = "SSN"
+)
+
+// piiPatterns find personal data by its form, with valid ruling out
+// matches that can't be it. Phone numbers need separators between their
+// groups so other runs of ten digits aren't taken for one.
+var piiPatterns = []struct {
+	kind    string
+	pattern *regexp.Regexp
+	valid   func(string) bool
+}{
+	{PIIEmail, regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`), nil},
+	{PIISSN, regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), validSSN},
+	{PIIPhone, regexp.MustCompile(`(?:\+\d{1,3}[-.\s]?)?(?:\(\d{3}\)\s?|\b\d{3}[-.\s])\d{3}[-.\s]\d{4}\b`), nil},
+}
+
+// validSSN reports whether s, formatted as 123-45-6789, could be a social
+// security number: none is issued with an area of 000, 666 or 900 and up, a
+// group of 00 or a serial of 0000
+func validSSN(s string) bool {
+	area, group, serial := s[0:3], s[4:6], s[7:11]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// PIIMatch is a piece of personal data found in a file, by its type and
+// byte offsets. The data itself is never part of a result.
+type PIIMatch struct {
+	Type  string `json:"type"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// PIIReport is the result of the pii processor, as JSON
+type PIIReport struct {
+	Summary string `json:"summary"`
+	// Counts are the matches by type
+	Counts  map[string]int `json:"counts"`
+	Matches []PIIMatch     `json:"matches"`
+}
+
+// PIIDetector finds personal data the patterns can't, such as names and
+// addresses
+type PIIDetector interface {
+	DetectPII(ctx context.Context, text string) ([]PIIMatch, error)
+}
+
+var (
+	piiDetectorMu sync.RWMutex
+	piiDetector   PIIDetector
+)
+
+// SetPIIDetector has the pii processor also use d, such as Amazon
+// Comprehend, or only its patterns when d is nil
+func SetPIIDetector(d PIIDetector) {
+	piiDetectorMu.Lock()
+	defer piiDetectorMu.Unlock()
+	piiDetector = d
+}
+
+// piiProcessor finds emails, phone numbers and social security numbers in
+// text files, and whatever the PIIDetector set finds. Where they are is
+// reported so a redacted copy of the file can be made.
+type piiProcessor struct{}
+
+func (piiProcessor) Name() string {
+	return "pii"
+}
+
+func (piiProcessor) Process(ctx context.Context, name string, content []byte) (string, error) {
+	if !utf8.Valid(content) {
+		return "", fmt.Errorf("%w: %s is not UTF-8 text", ErrUnsupported, name)
+	}
+	text := string(content)
+	matches := FindPII(text)
+
+	piiDetectorMu.RLock()
+	d := piiDetector
+	piiDetectorMu.RUnlock()
+	if d != nil {
+		found, err := d.DetectPII(ctx, text)
+		if err != nil {
+			return "", fmt.Errorf("detecting personal data: %w", err)
+		}
+		matches = mergePIIMatches(append(matches, found...))
+	}
+
+	report := PIIReport{Counts: make(map[string]int), Matches: matches}
+	for _, m := range matches {
+		report.Counts[m.Type]++
+	}
+	report.Summary = piiSummary(report.Counts)
+	result, err := json.Marshal(report)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// piiSummary describes what was found, e.g. "Personal data found: 2 EMAIL,
+// 1 PHONE"
+func piiSummary(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "No personal data found"
+	}
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	parts := make([]string, 0, len(types))
+	for _, t := range types {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[t], t))
+	}
+	return "Personal data found: " + strings.Join(parts, ", ")
+}
+
+// FindPII returns the emails, phone numbers and social security numbers in
+// text, in order
+func FindPII(text string) []PIIMatch {
+	var matches []PIIMatch
+	for _, p := range piiPatterns {
+		for _, loc := range p.pattern.FindAllStringIndex(text, -1) {
+			if p.valid != nil && !p.valid(text[loc[0]:loc[1]]) {
+				continue
+			}
+			matches = append(matches, PIIMatch{Type: p.kind, Start: loc[0], End: loc[1]})
+		}
+	}
+	return mergePIIMatches(matches)
+}
+
+// mergePIIMatches sorts matches and joins those that overlap, keeping the
+// type of the one starting first
+func mergePIIMatches(matches []PIIMatch) []PIIMatch {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Start != matches[j].Start {
+			return matches[i].Start < matches[j].Start
+		}
+		return matches[i].End > matches[j].End
+	})
+	merged := make([]PIIMatch, 0, len(matches))
+	for _, m := range matches {
+		if m.End <= m.Start {
+			continue
+		}
+		if n := len(merged); n > 0 && m.Start < merged[n-1].End {
+			if m.End > merged[n-1].End {
+				merged[n-1].End = m.End
+			}
+			continue
+		}
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+// ParsePIIReport decodes a result of the pii processor
+func ParsePIIReport(result string) (*PIIReport, bool) {
+	var report PIIReport
+	if err := json.Unmarshal([]byte(result), &report); err != nil || report.Counts == nil {
+		return nil, false
+	}
+	return &report, true
+}
+
+// RedactPII replaces each match in content with its type in brackets, e.g.
+// "[EMAIL]". Matches must be sorted and not overlap, as in a PIIReport;
+// those that don't fit content are skipped.
+func RedactPII(content []byte, matches []PIIMatch) []byte {
+	var b strings.Builder
+	b.Grow(len(content))
+	last := 0
+	for _, m := range matches {
+		if m.Start < last || m.End > len(content) || m.End <= m.Start {
+			continue
+		}
+		b.Write(content[last:m.Start])
+		b.WriteString("[" + m.Type + "]")
+		last = m.End
+	}
+	b.Write(content[last:])
+	return []byte(b.String())
+}
@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedPIIDetector struct {
+	matches []PIIMatch
+	err     error
+}
+
+func (d fixedPIIDetector) DetectPII(context.Context, string) ([]PIIMatch, error) {
+	return d.matches, d.err
+}
+
+func TestFindPII(t *testing.T) {
+	text := "Mail jane.doe@example.co.uk or call (555) 123-4567, +1 555.987.6543. SSN 123-45-6789, not 666-12-3456 or order 1234-555-1234."
+	var found []string
+	for _, m := range FindPII(text) {
+		found = append(found, m.Type+" "+text[m.Start:m.End])
+	}
+	assert.Equal(t, []string{
+		"EMAIL jane.doe@example.co.uk",
+		"PHONE (555) 123-4567",
+		"PHONE +1 555.987.6543",
+		"SSN 123-45-6789",
+	}, found)
+}
+
+func TestPIIProcessor(t *testing.T) {
+	defer SetPIIDetector(nil)
+	ctx := context.Background()
+	content := []byte("Jane Doe, jane@example.com")
+
+	result, err := piiProcessor{}.Process(ctx, "a.txt", content)
+	require.NoError(t, err)
+	report, ok := ParsePIIReport(result)
+	require.True(t, ok)
+	assert.Equal(t, map[string]int{PIIEmail: 1}, report.Counts)
+	assert.Equal(t, "Personal data found: 1 EMAIL", report.Summary)
+
+	// The detector's matches are merged with the patterns'
+	SetPIIDetector(fixedPIIDetector{matches: []PIIMatch{{Type: "NAME", Start: 0, End: 8}, {Type: "EMAIL", Start: 10, End: 26}}})
+	result, err = piiProcessor{}.Process(ctx, "a.txt", content)
+	require.NoError(t, err)
+	report, ok = ParsePIIReport(result)
+	require.True(t, ok)
+	assert.Equal(t, map[string]int{"NAME": 1, PIIEmail: 1}, report.Counts)
+	assert.Equal(t, "[NAME], [EMAIL]", string(RedactPII(content, report.Matches)))
+
+	SetPIIDetector(fixedPIIDetector{err: errors.New("throttled")})
+	_, err = piiProcessor{}.Process(ctx, "a.txt", content)
+	assert.Error(t, err)
+
+	_, err = piiProcessor{}.Process(ctx, "a.bin", []byte{0xff, 0xfe})
+	assert.True(t, errors.Is(err, ErrUnsupported))
+}
+
+func TestPIIProcessorNothingFound(t *testing.T) {
+	result, err := piiProcessor{}.Process(context.Background(), "a.txt", []byte("nothing to see"))
+	require.NoError(t, err)
+	report, ok := ParsePIIReport(result)
+	require.True(t, ok)
+	assert.Empty(t, report.Matches)
+	assert.Equal(t, "No personal data found", report.Summary)
+
+	_, ok = ParsePIIReport("Processed file with 3 words")
+	assert.False(t, ok)
+}
+
+func TestRedactPII(t *testing.T) {
+	content := []byte("a 123-45-6789 b")
+	assert.Equal(t, "a [SSN] b", string(RedactPII(content, []PIIMatch{{Type: PIISSN, Start: 2, End: 13}})))
+	// Matches that don't fit are skipped
+	assert.Equal(t, string(content), string(RedactPII(content, []PIIMatch{{Type: PIISSN, Start: 2, End: 99}})))
+}
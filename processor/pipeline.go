@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultFileType is the pipeline key matching files of every other type
+const DefaultFileType = "*"
+
+// Stage statuses
+const (
+	StageCompleted = "completed"
+	StageFailed    = "failed"
+	StageTimeout   = "timeout"
+	// StageSkipped marks the stages after one that failed
+	StageSkipped = "skipped"
+)
+
+// StageResult is the outcome of one processor in a pipeline
+type StageResult struct {
+	Processor string        `json:"processor"`
+	Status    string        `json:"status"`
+	Result    string        `json:"result,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"-"`
+}
+
+// StageError is returned by RunPipeline for the stage that stopped it
+type StageError struct {
+	Processor string
+	Err       error
+}
+
+func (e *StageError) Error() string {
+	return "processor " + e.Processor + ": " + e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// FileType returns the key a file's pipeline is looked up by: the file
+// name's extension in lower case, e.g. ".csv"
+func FileType(name string) string {
+	return strings.ToLower(path.Ext(name))
+}
+
+// Pipeline returns the processors named by stages, in order. Without stages
+// the file's own processor from ForFile runs alone.
+func Pipeline(stages []string, fileName string) ([]Processor, error) {
+	if len(stages) == 0 {
+		return []Processor{ForFile(fileName)}, nil
+	}
+	procs := make([]Processor, 0, len(stages))
+	for _, name := range stages {
+		p := Get(name)
+		if p == nil {
+			return nil, fmt.Errorf("unknown processor %q", name)
+		}
+		procs = append(procs, p)
+	}
+	return procs, nil
+}
+
+// RunPipeline runs procs one after another on a file, each under its own
+// timeout as with Run. The first stage that fails or times out stops the
+// pipeline: the stages after it are reported as skipped and a *StageError
+// wrapping its error is returned along with every stage's result. Progress
+// is passed to the function given with WithProgress, if any.
+func RunPipeline(ctx context.Context, procs []Processor, name string, content []byte) ([]StageResult, error) {
+	stages := make([]StageResult, 0, len(procs))
+	var stopped error
+	for i, p := range procs {
+		if stopped != nil {
+			stages = append(stages, StageResult{Processor: p.Name(), Status: StageSkipped})
+			continue
+		}
+
+		start := time.Now()
+		result, err := Run(stageProgress(ctx, i, len(procs), p.Name()), p, name, content)
+		stage := StageResult{Processor: p.Name(), Status: StageCompleted, Result: result, Duration: time.Since(start)}
+		if err != nil {
+			stage.Status, stage.Result, stage.Error = StageFailed, "", err.Error()
+			if errors.Is(err, ErrTimeout) {
+				stage.Status = StageTimeout
+			}
+			stopped = &StageError{Processor: p.Name(), Err: err}
+		}
+		stages = append(stages, stage)
+	}
+	return stages, stopped
+}
+
+// Summary combines the results of a completed pipeline into the file's
+// processing result. A single stage's result is kept as it is; several are
+// listed one per line after their processor's name.
+func Summary(stages []StageResult) string {
+	if len(stages) == 1 {
+		return stages[0].Result
+	}
+	lines := make([]string, 0, len(stages))
+	for _, s := range stages {
+		lines = append(lines, s.Processor+": "+s.Result)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubProcessor returns a fixed result or error
+type stubProcessor struct {
+	name   string
+	result string
+	err    error
+}
+
+func (p stubProcessor) Name() string { return p.name }
+
+func (p stubProcessor) Process(ctx context.Context, name string, content []byte) (string, error) {
+	return p.result, p.err
+}
+
+func TestRunPipelineStopsAtFailure(t *testing.T) {
+	boom := errors.New("boom")
+	procs := []Processor{
+		stubProcessor{name: "scan", result: "clean"},
+		stubProcessor{name: "extract", err: boom},
+		stubProcessor{name: "index", result: "indexed"},
+	}
+
+	stages, err := RunPipeline(context.Background(), procs, "a.txt", nil)
+	var stageErr *StageError
+	assert.ErrorAs(t, err, &stageErr)
+	assert.Equal(t, "extract", stageErr.Processor)
+	assert.ErrorIs(t, err, boom)
+
+	assert.Len(t, stages, 3)
+	assert.Equal(t, StageCompleted, stages[0].Status)
+	assert.Equal(t, StageFailed, stages[1].Status)
+	assert.Equal(t, "boom", stages[1].Error)
+	assert.Equal(t, StageSkipped, stages[2].Status)
+}
+
+func TestRunPipelineSummary(t *testing.T) {
+	procs := []Processor{stubProcessor{name: "scan", result: "clean"}, stubProcessor{name: "stats", result: "3 words"}}
+	stages, err := RunPipeline(context.Background(), procs, "a.txt", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "scan: clean\nstats: 3 words", Summary(stages))
+	assert.Equal(t, "clean", Summary(stages[:1]))
+}
+
+func TestPipelineResolvesProcessors(t *testing.T) {
+	procs, err := Pipeline(nil, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []Processor{ForFile("a.txt")}, procs)
+
+	_, err = Pipeline([]string{"text", "missing"}, "a.txt")
+	assert.Error(t, err)
+	assert.Equal(t, ".csv", FileType("Report.CSV"))
+}
+
+// blockingProcessor waits for its context to end
+type blockingProcessor struct{}
+
+func (blockingProcessor) Name() string { return "block" }
+
+func (blockingProcessor) Process(ctx context.Context, name string, content []byte) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestRunTimeoutAndCancellation(t *testing.T) {
+	t.Setenv("PROCESSOR_TIMEOUT_BLOCK", "20ms")
+
+	_, err := Run(context.Background(), blockingProcessor{}, "a.txt", nil)
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	// Cancelling the caller isn't the processor timing out
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Run(ctx, blockingProcessor{}, "a.txt", nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrTimeout)
+}
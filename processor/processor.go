@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is used when no timeout is configured for a processor
+const DefaultTimeout = 60 * time.Second
+
+// ErrTimeout is returned when a processor does not finish before its deadline
+var ErrTimeout = errors.New("processor timed out")
+
+// ErrUnsupported is returned, wrapped, by processors given a file format they
+// can't read. Retrying such a file can't succeed.
+var ErrUnsupported = errors.New("unsupported file format")
+
+// Processor turns the content of a file into a result
+type Processor interface {
+	// Name identifies the processor type, e.g. "text"
+	Name() string
+	Process(ctx context.Context, name string, content []byte) (string, error)
+}
+
+var (
+	// registryMu guards processors, which plugins change while files are
+	// being processed
+	registryMu sync.RWMutex
+	processors = map[string]Processor{}
+)
+
+// Register makes a processor available by name
+func Register(p Processor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	processors[p.Name()] = p
+}
+
+// Unregister removes the processor registered under name
+func Unregister(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(processors, name)
+}
+
+// Get returns the processor registered under name, or nil
+func Get(name string) Processor {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return processors[name]
+}
+
+// ForFile returns the processor to use for a file name: the media processor for
+// audio and video files, the archive processor for archives and the text
+// processor for everything else
+func ForFile(name string) Processor {
+	if isMediaFile(name) {
+		return Get("media")
+	}
+	if ArchiveFormat(name) != "" {
+		return Get("archive")
+	}
+	return Get("text")
+}
+
+// Timeout returns the configured timeout for a processor type, read from
+// PROCESSOR_TIMEOUT_<NAME> and falling back to PROCESSOR_TIMEOUT
+func Timeout(name string) time.Duration {
+	keys := []string{"PROCESSOR_TIMEOUT_" + strings.ToUpper(name), "PROCESSOR_TIMEOUT"}
+	for _, key := range keys {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("Invalid %s %q, ignoring", key, v)
+			continue
+		}
+		return d
+	}
+	return DefaultTimeout
+}
+
+// Run executes a processor with its configured timeout. The processor runs in
+// its own goroutine so that one ignoring its context can't hold the caller past
+// the deadline; ErrTimeout is returned in that case. When ctx itself is
+// cancelled or expires first, its error is returned instead.
+func Run(ctx context.Context, p Processor, name string, content []byte) (string, error) {
+	timeout := Timeout(p.Name())
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type outcome struct {
+		result string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := p.Process(runCtx, name, content)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case out := <-done:
+		if out.err != nil && runCtx.Err() != nil {
+			return "", stopped(ctx, timeout)
+		}
+		return out.result, out.err
+	case <-runCtx.Done():
+		return "", stopped(ctx, timeout)
+	}
+}
+
+// stopped is the error of a run whose context ended: the parent's error if it
+// was the parent that ended, ErrTimeout if it was the processor's own deadline
+func stopped(parent context.Context, timeout time.Duration) error {
+	if err := parent.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w after %s", ErrTimeout, timeout)
+}
@@ -0,0 +1,63 @@
+package processor
+
+import "context"
+
+// Progress is how far a pipeline has got with a file. Done and Total are the
+// running stage's own units, such as rows or members, as it last reported
+// them; Total is 0 when the stage doesn't know how many there are. Percent
+// covers the whole pipeline, counting finished stages in full.
+type Progress struct {
+	Stage     int     `json:"stage"`
+	Stages    int     `json:"stages"`
+	Processor string  `json:"processor"`
+	Done      int64   `json:"done"`
+	Total     int64   `json:"total,omitempty"`
+	Percent   float64 `json:"percent"`
+}
+
+type (
+	pipelineProgressKey struct{}
+	stageProgressKey    struct{}
+)
+
+// WithProgress returns a context that makes RunPipeline pass fn its progress
+// as each stage starts and whenever a processor calls ReportProgress. fn may
+// be called from a processor's goroutine after its stage timed out.
+func WithProgress(ctx context.Context, fn func(Progress)) context.Context {
+	return context.WithValue(ctx, pipelineProgressKey{}, fn)
+}
+
+// ReportProgress lets a long-running processor report that it has done done
+// of total units of its work, or of an unknown number with total 0. It does
+// nothing unless the pipeline's progress is being followed.
+func ReportProgress(ctx context.Context, done, total int64) {
+	if fn, ok := ctx.Value(stageProgressKey{}).(func(done, total int64)); ok {
+		fn(done, total)
+	}
+}
+
+// stageProgress returns the context a pipeline's stage runs under, with
+// ReportProgress passed on to the pipeline's progress function as the
+// stage's share of the whole
+func stageProgress(ctx context.Context, stage, stages int, name string) context.Context {
+	fn, ok := ctx.Value(pipelineProgressKey{}).(func(Progress))
+	if !ok {
+		return ctx
+	}
+	report := func(done, total int64) {
+		fraction := 0.0
+		if total > 0 && done > 0 {
+			fraction = float64(min(done, total)) / float64(total)
+		}
+		fn(Progress{
+			Stage:     stage + 1,
+			Stages:    stages,
+			Processor: name,
+			Done:      done,
+			Total:     total,
+			Percent:   round((float64(stage)+fraction)/float64(stages)*100, 1),
+		})
+	}
+	report(0, 0)
+	return context.WithValue(ctx, stageProgressKey{}, report)
+}
@@ -0,0 +1,47 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingProcessor reports progress through rows it pretends to process
+type countingProcessor struct {
+	name string
+	rows int64
+}
+
+func (p countingProcessor) Name() string { return p.name }
+
+func (p countingProcessor) Process(ctx context.Context, name string, content []byte) (string, error) {
+	for done := int64(1); done <= p.rows; done++ {
+		ReportProgress(ctx, done, p.rows)
+	}
+	return "ok", nil
+}
+
+func TestRunPipelineReportsProgress(t *testing.T) {
+	var reports []Progress
+	ctx := WithProgress(context.Background(), func(p Progress) {
+		reports = append(reports, p)
+	})
+	procs := []Processor{countingProcessor{name: "rows", rows: 2}, stubProcessor{name: "stats", result: "done"}}
+
+	_, err := RunPipeline(ctx, procs, "a.csv", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []Progress{
+		{Stage: 1, Stages: 2, Processor: "rows", Percent: 0},
+		{Stage: 1, Stages: 2, Processor: "rows", Done: 1, Total: 2, Percent: 25},
+		{Stage: 1, Stages: 2, Processor: "rows", Done: 2, Total: 2, Percent: 50},
+		{Stage: 2, Stages: 2, Processor: "stats", Percent: 50},
+	}, reports)
+}
+
+func TestReportProgressWithoutListener(t *testing.T) {
+	// Processors run outside a followed pipeline just don't report
+	ReportProgress(context.Background(), 1, 2)
+	_, err := RunPipeline(context.Background(), []Processor{countingProcessor{name: "rows", rows: 3}}, "a.csv", nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,223 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+func init() {
+	Register(textProcessor{})
+}
+
+// TopTerms is how many of a text's most frequent terms are reported
+const TopTerms = 10
+
+// TextStats is the result of the text processor, as JSON
+type TextStats struct {
+	// Summary is the result in the plain form the processor used to return
+	Summary            string  `json:"summary"`
+	Words              int     `json:"words"`
+	Characters         int     `json:"characters"`
+	Sentences          int     `json:"sentences"`
+	Language           string  `json:"language"`
+	LanguageConfidence float64 `json:"language_confidence"`
+	// Readability is left out for texts without words. The formulas are
+	// calibrated for English, so other languages score only roughly.
+	Readability *Readability `json:"readability,omitempty"`
+	TopTerms    []TermCount  `json:"top_terms"`
+}
+
+// Readability holds the Flesch reading ease (higher is easier, 60-70 is
+// plain English) and the Flesch-Kincaid US school grade of a text
+type Readability struct {
+	FleschReadingEase  float64 `json:"flesch_reading_ease"`
+	FleschKincaidGrade float64 `json:"flesch_kincaid_grade"`
+}
+
+// TermCount is how often a term occurs in a text
+type TermCount struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// textProcessor reports the words, sentences, language, readability and most
+// frequent terms of a file
+type textProcessor struct{}
+
+func (textProcessor) Name() string {
+	return "text"
+}
+
+func (textProcessor) Process(ctx context.Context, name string, content []byte) (string, error) {
+	stats := AnalyzeText(string(content))
+	result, err := json.Marshal(stats)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// ParseTextStats decodes a result of the text processor. It reports false for
+// results in another form, such as those saved before results were JSON.
+func ParseTextStats(result string) (*TextStats, bool) {
+	var stats TextStats
+	if err := json.Unmarshal([]byte(result), &stats); err != nil || stats.Language == "" {
+		return nil, false
+	}
+	return &stats, true
+}
+
+// AnalyzeText computes the statistics of a text
+func AnalyzeText(text string) TextStats {
+	words := len(strings.Fields(text))
+	stats := TextStats{
+		Summary:    fmt.Sprintf("Processed file with %d words and %d characters", words, len(text)),
+		Words:      words,
+		Characters: len(text),
+		Sentences:  countSentences(text),
+	}
+
+	tokens := tokenize(text)
+	stats.Language, stats.LanguageConfidence = DetectLanguage(text, tokens)
+	stats.Readability = readability(tokens, stats.Sentences)
+	stats.TopTerms = topTerms(tokens, TopTerms)
+	return stats
+}
+
+// tokenize splits a text into lower-case words of letters, digits and
+// apostrophes
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+// countSentences counts the runs of text ended by ".", "!" or "?" followed by
+// white space or the end of the text, plus a final unterminated one
+func countSentences(text string) int {
+	sentences := 0
+	inSentence, ending := false, false
+	for _, r := range text {
+		switch {
+		case r == '.' || r == '!' || r == '?':
+			if inSentence {
+				ending = true
+			}
+		case unicode.IsSpace(r):
+			if ending {
+				sentences++
+				inSentence, ending = false, false
+			}
+		default:
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				inSentence = true
+			}
+			ending = false
+		}
+	}
+	if inSentence {
+		sentences++
+	}
+	return sentences
+}
+
+// readability scores a text from its words and sentence count, or returns nil
+// when it has no words
+func readability(words []string, sentences int) *Readability {
+	if len(words) == 0 || sentences == 0 {
+		return nil
+	}
+	syllables := 0
+	for _, w := range words {
+		syllables += countSyllables(w)
+	}
+	wordsPerSentence := float64(len(words)) / float64(sentences)
+	syllablesPerWord := float64(syllables) / float64(len(words))
+	return &Readability{
+		FleschReadingEase:  round(206.835-1.015*wordsPerSentence-84.6*syllablesPerWord, 1),
+		FleschKincaidGrade: round(0.39*wordsPerSentence+11.8*syllablesPerWord-15.59, 1),
+	}
+}
+
+// countSyllables estimates a word's syllables as its groups of vowels, not
+// counting a silent final "e"
+func countSyllables(word string) int {
+	count, prevVowel := 0, false
+	for _, r := range word {
+		vowel := strings.ContainsRune("aeiouyàáâäèéêëìíîïòóôöùúûü", r)
+		if vowel && !prevVowel {
+			count++
+		}
+		prevVowel = vowel
+	}
+	if count > 1 && strings.HasSuffix(word, "e") && !strings.HasSuffix(word, "le") {
+		count--
+	}
+	if count == 0 {
+		return 1
+	}
+	return count
+}
+
+// topTerms returns the n most frequent words of three or more letters that
+// aren't common words, most frequent first and then alphabetically
+func topTerms(words []string, n int) []TermCount {
+	counts := make(map[string]int)
+	for _, w := range words {
+		w = strings.Trim(w, "'")
+		if utf8.RuneCountInString(w) < 3 || isStopword(w) || strings.IndexFunc(w, unicode.IsLetter) < 0 {
+			continue
+		}
+		counts[w]++
+	}
+
+	terms := make([]TermCount, 0, len(counts))
+	for term, count := range counts {
+		terms = append(terms, TermCount{Term: term, Count: count})
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Count != terms[j].Count {
+			return terms[i].Count > terms[j].Count
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	if len(terms) > n {
+		terms = terms[:n]
+	}
+	return terms
+}
+
+// round rounds x to the given number of decimal places
+func round(x float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(x*p) / p
+}
+
+// MaxExtractedText caps how much of a file's text is kept for search
+const MaxExtractedText = 1 << 20
+
+// ExtractText returns the searchable text of a file: its content if it is
+// valid UTF-8, truncated to MaxExtractedText bytes, or "" for binary files
+func ExtractText(content []byte) string {
+	if len(content) > MaxExtractedText {
+		content = content[:MaxExtractedText]
+		// Drop a multi-byte character cut in half by the limit
+		for i := 0; i < utf8.UTFMax-1 && len(content) > 0; i++ {
+			if r, _ := utf8.DecodeLastRune(content); r != utf8.RuneError {
+				break
+			}
+			content = content[:len(content)-1]
+		}
+	}
+	if !utf8.Valid(content) {
+		return ""
+	}
+	// Postgres text can't hold NUL bytes
+	return strings.ReplaceAll(string(content), "\x00", "")
+}
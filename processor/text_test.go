@@ -0,0 +1,51 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeText(t *testing.T) {
+	stats := AnalyzeText("The cat sat on the mat. The cat was happy! Was the dog happy too?")
+	assert.Equal(t, 15, stats.Words)
+	assert.Equal(t, 3, stats.Sentences)
+	assert.Equal(t, "en", stats.Language)
+	assert.Equal(t, []TermCount{{"cat", 2}, {"happy", 2}, {"dog", 1}, {"mat", 1}, {"sat", 1}, {"too", 1}}, stats.TopTerms)
+	assert.NotNil(t, stats.Readability)
+	assert.Greater(t, stats.Readability.FleschReadingEase, 90.0)
+}
+
+func TestAnalyzeTextEmpty(t *testing.T) {
+	stats := AnalyzeText("")
+	assert.Equal(t, 0, stats.Sentences)
+	assert.Equal(t, LanguageUndetermined, stats.Language)
+	assert.Nil(t, stats.Readability)
+	assert.Empty(t, stats.TopTerms)
+}
+
+func TestDetectLanguage(t *testing.T) {
+	for text, want := range map[string]string{
+		"Der Hund und die Katze sind nicht im Haus, das ist für mich neu.": "de",
+		"El perro y la casa de los niños que no es para mí.":               "es",
+		"Le chat et le chien sont dans la maison avec les enfants.":        "fr",
+		"Это простой текст на русском языке.":                              "ru",
+		"これは日本語の文章です。":                                                     "ja",
+		"Lorem ipsum":                                                      LanguageUndetermined,
+	} {
+		got, _ := DetectLanguage(text, tokenize(text))
+		assert.Equal(t, want, got, text)
+	}
+}
+
+func TestTextProcessorResultIsJSON(t *testing.T) {
+	result, err := textProcessor{}.Process(context.Background(), "a.txt", []byte("Hello there, world."))
+	assert.NoError(t, err)
+	stats, ok := ParseTextStats(result)
+	assert.True(t, ok)
+	assert.Equal(t, "Processed file with 3 words and 19 characters", stats.Summary)
+
+	_, ok = ParseTextStats("Processed file with 3 words and 19 characters")
+	assert.False(t, ok)
+}
@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxBatchEntries is the most messages SQS accepts in one SendMessageBatch
+const maxBatchEntries = 10
+
+// FileEvent is a processing message for one file. ETag is that of the object
+// when the publisher knows it. JobID is set for scheduled jobs, ScheduleRunID
+// and Processor for runs of a recurring schedule, and RequeueID for stuck
+// files sent again.
+type FileEvent struct {
+	FileID        string
+	Bucket        string
+	Key           string
+	ETag          string
+	JobID         string
+	ScheduleRunID string
+	Processor     string
+	RequeueID     string
+}
+
+// PublishFileEvents sends processing messages for many files, ten to a
+// SendMessageBatch call. The returned slice has an entry per event, nil for
+// the ones that were sent, so callers can retry or report failures one by
+// one; a batch call that fails outright fails each of its events.
+func PublishFileEvents(ctx context.Context, events []FileEvent) []error {
+	errs := make([]error, len(events))
+	for start := 0; start < len(events); start += maxBatchEntries {
+		end := start + maxBatchEntries
+		if end > len(events) {
+			end = len(events)
+		}
+		publishBatch(ctx, events[start:end], errs[start:end])
+	}
+	return errs
+}
+
+// publishBatch sends up to maxBatchEntries events, recording each one's error
+// in errs. Entry IDs are the events' indexes in the batch.
+func publishBatch(ctx context.Context, events []FileEvent, errs []error) {
+	entries := make([]types.SendMessageBatchRequestEntry, 0, len(events))
+	index := make(map[string]int, len(events))
+	for i, e := range events {
+		event := NewS3Event(e.Bucket, e.Key)
+		event.Records[0].S3.Object.ETag = e.ETag
+		event.JobID = e.JobID
+		event.ScheduleRunID = e.ScheduleRunID
+		event.Processor = e.Processor
+		event.RequeueID = e.RequeueID
+		m, err := newMessage(e.FileID, event, 0)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		id := strconv.Itoa(i)
+		index[id] = i
+		entries = append(entries, types.SendMessageBatchRequestEntry{
+			Id:                     aws.String(id),
+			MessageBody:            m.body,
+			DelaySeconds:           m.delaySeconds,
+			MessageGroupId:         m.groupID,
+			MessageDeduplicationId: m.dedupID,
+			MessageAttributes:      m.attributes,
+		})
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	out, err := sqsClient.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(queueURL),
+		Entries:  entries,
+	})
+	if err != nil {
+		for _, i := range index {
+			errs[i] = err
+		}
+		return
+	}
+	for _, f := range out.Failed {
+		if i, ok := index[aws.ToString(f.Id)]; ok {
+			errs[i] = errors.New(aws.ToString(f.Code) + ": " + aws.ToString(f.Message))
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package queue
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// PublishEvent sends an event body to the queue at url, which need not be the
+// processing queue. On FIFO queues groupID keeps events for one entity in
+// order and dedupID stops a retried send from being delivered twice.
+func PublishEvent(ctx context.Context, url, groupID, dedupID string, body []byte) error {
+	input := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(url),
+		MessageBody:       aws.String(string(body)),
+		MessageAttributes: signatureAttributes(string(body), time.Now()),
+	}
+	if strings.HasSuffix(url, ".fifo") {
+		input.MessageGroupId = aws.String(groupID)
+		input.MessageDeduplicationId = aws.String(dedupID)
+	}
+	_, err := sqsClient.SendMessage(ctx, input)
+	return err
+}
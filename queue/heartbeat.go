@@ -0,0 +1,163 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// HeartbeatExtension returns how long each heartbeat hides messages for, from
+// SQS_VISIBILITY_EXTENSION (default 2m). 0 turns heartbeats off.
+func HeartbeatExtension() time.Duration {
+	extension := 2 * time.Minute
+	if v := os.Getenv("SQS_VISIBILITY_EXTENSION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("Invalid SQS_VISIBILITY_EXTENSION %q, using %s", v, extension)
+		} else {
+			extension = d
+		}
+	}
+	return extension
+}
+
+// Heartbeat keeps received messages hidden from other consumers while they
+// wait for or go through processing, so a long job isn't redelivered before
+// it finishes. Every third of the extension the messages still held are
+// hidden for the full extension again.
+type Heartbeat struct {
+	extension time.Duration
+	extend    func(ctx context.Context, handles []string, d time.Duration) error
+
+	mu      sync.Mutex
+	handles map[string]bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// StartHeartbeat starts extending the visibility of the messages with the
+// given receipt handles. A zero extension returns a heartbeat that does
+// nothing.
+func StartHeartbeat(ctx context.Context, receiptHandles []string, extension time.Duration) *Heartbeat {
+	return startHeartbeat(ctx, receiptHandles, extension, changeVisibilityBatch)
+}
+
+func startHeartbeat(ctx context.Context, receiptHandles []string, extension time.Duration, extend func(context.Context, []string, time.Duration) error) *Heartbeat {
+	h := &Heartbeat{
+		extension: extension,
+		extend:    extend,
+		handles:   make(map[string]bool, len(receiptHandles)),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, handle := range receiptHandles {
+		h.handles[handle] = true
+	}
+	if extension <= 0 {
+		close(h.done)
+		return h
+	}
+
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(extension / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				h.beat(ctx)
+			}
+		}
+	}()
+	return h
+}
+
+// beat extends the messages still held. The lock is kept during the call so
+// that Release doesn't return while an extension is in flight, which could
+// otherwise override a visibility change the caller makes next.
+func (h *Heartbeat) beat(ctx context.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.handles) == 0 {
+		return
+	}
+	handles := make([]string, 0, len(h.handles))
+	for handle := range h.handles {
+		handles = append(handles, handle)
+	}
+	if err := h.extend(ctx, handles, h.extension); err != nil {
+		log.Printf("Error extending visibility of %d messages: %v", len(handles), err)
+	}
+}
+
+// Release stops extending one message, once it is handled or about to have
+// its visibility changed for a retry
+func (h *Heartbeat) Release(receiptHandle string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.handles, receiptHandle)
+}
+
+// Stop stops the heartbeat and waits for it to finish
+func (h *Heartbeat) Stop() {
+	select {
+	case <-h.done:
+	default:
+		close(h.stop)
+		<-h.done
+	}
+}
+
+// changeVisibilityBatch hides messages for d, ten to a call
+func changeVisibilityBatch(ctx context.Context, receiptHandles []string, d time.Duration) error {
+	if d > maxVisibilityTimeout {
+		d = maxVisibilityTimeout
+	}
+	var firstErr error
+	for start := 0; start < len(receiptHandles); start += maxBatchEntries {
+		end := start + maxBatchEntries
+		if end > len(receiptHandles) {
+			end = len(receiptHandles)
+		}
+		entries := make([]types.ChangeMessageVisibilityBatchRequestEntry, 0, end-start)
+		for i := start; i < end; i++ {
+			entries = append(entries, types.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				ReceiptHandle:     aws.String(receiptHandles[i]),
+				VisibilityTimeout: int32(d.Seconds()),
+			})
+		}
+		out, err := sqsClient.ChangeMessageVisibilityBatch(ctx, &sqs.ChangeMessageVisibilityBatchInput{
+			QueueUrl: aws.String(queueURL),
+			Entries:  entries,
+		})
+		if err == nil && len(out.Failed) > 0 {
+			err = &batchError{code: aws.ToString(out.Failed[0].Code), message: aws.ToString(out.Failed[0].Message), failed: len(out.Failed)}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// batchError reports the entries a batch call failed on
+type batchError struct {
+	code, message string
+	failed        int
+}
+
+func (e *batchError) Error() string {
+	return strconv.Itoa(e.failed) + " entries failed, first with " + e.code + ": " + e.message
+}
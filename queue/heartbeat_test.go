@@ -0,0 +1,45 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeatExtendsHeldMessages(t *testing.T) {
+	var mu sync.Mutex
+	var calls [][]string
+	extend := func(ctx context.Context, handles []string, d time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sort.Strings(handles)
+		calls = append(calls, handles)
+		assert.Equal(t, 30*time.Millisecond, d)
+		return nil
+	}
+
+	h := startHeartbeat(context.Background(), []string{"a", "b"}, 30*time.Millisecond, extend)
+	time.Sleep(25 * time.Millisecond)
+	h.Release("a")
+	time.Sleep(50 * time.Millisecond)
+	h.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.GreaterOrEqual(t, len(calls), 2)
+	assert.Equal(t, []string{"a", "b"}, calls[0])
+	assert.Equal(t, []string{"b"}, calls[len(calls)-1])
+}
+
+func TestHeartbeatDisabled(t *testing.T) {
+	h := startHeartbeat(context.Background(), []string{"a"}, 0, func(context.Context, []string, time.Duration) error {
+		t.Fatal("disabled heartbeat extended a message")
+		return nil
+	})
+	h.Release("a")
+	h.Stop()
+}
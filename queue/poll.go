@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Receive long-polls the queue for up to max messages, waiting at most wait
+// for one to arrive. It is used to run the worker outside Lambda.
+func Receive(ctx context.Context, max int32, wait time.Duration) ([]types.Message, error) {
+	return receive(ctx, queueURL, max, wait)
+}
+
+func receive(ctx context.Context, url string, max int32, wait time.Duration) ([]types.Message, error) {
+	out, err := sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(url),
+		MaxNumberOfMessages: max,
+		WaitTimeSeconds:     int32(wait.Seconds()),
+		// The worker needs MessageGroupId on FIFO queues
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameAll},
+		// Signed messages carry their signature in message attributes
+		MessageAttributeNames: []string{SignatureAttribute, SignatureTimestampAttribute},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Messages, nil
+}
+
+// DeleteBatch removes handled messages ten to a DeleteMessageBatch call. It
+// returns an error per receipt handle, nil for the ones that were deleted.
+func DeleteBatch(ctx context.Context, receiptHandles []string) []error {
+	return deleteBatch(ctx, queueURL, receiptHandles)
+}
+
+func deleteBatch(ctx context.Context, url string, receiptHandles []string) []error {
+	errs := make([]error, len(receiptHandles))
+	for start := 0; start < len(receiptHandles); start += maxBatchEntries {
+		end := start + maxBatchEntries
+		if end > len(receiptHandles) {
+			end = len(receiptHandles)
+		}
+		entries := make([]types.DeleteMessageBatchRequestEntry, 0, end-start)
+		for i := start; i < end; i++ {
+			entries = append(entries, types.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(strconv.Itoa(i)),
+				ReceiptHandle: aws.String(receiptHandles[i]),
+			})
+		}
+		out, err := sqsClient.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+			QueueUrl: aws.String(url),
+			Entries:  entries,
+		})
+		if err != nil {
+			for i := start; i < end; i++ {
+				errs[i] = err
+			}
+			continue
+		}
+		for _, f := range out.Failed {
+			if i, err := strconv.Atoi(aws.ToString(f.Id)); err == nil && i >= start && i < end {
+				errs[i] = errors.New(aws.ToString(f.Code) + ": " + aws.ToString(f.Message))
+			}
+		}
+	}
+	return errs
+}
+
+// Depth is the approximate number of messages in the processing queue by
+// state, as reported by SQS
+type Depth struct {
+	Visible  int64
+	InFlight int64
+	Delayed  int64
+}
+
+// GetDepth reads the approximate message counts of the processing queue
+func GetDepth(ctx context.Context) (Depth, error) {
+	out, err := sqsClient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(queueURL),
+		AttributeNames: []types.QueueAttributeName{
+			types.QueueAttributeNameApproximateNumberOfMessages,
+			types.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+			types.QueueAttributeNameApproximateNumberOfMessagesDelayed,
+		},
+	})
+	if err != nil {
+		return Depth{}, err
+	}
+	count := func(name types.QueueAttributeName) int64 {
+		n, _ := strconv.ParseInt(out.Attributes[string(name)], 10, 64)
+		return n
+	}
+	return Depth{
+		Visible:  count(types.QueueAttributeNameApproximateNumberOfMessages),
+		InFlight: count(types.QueueAttributeNameApproximateNumberOfMessagesNotVisible),
+		Delayed:  count(types.QueueAttributeNameApproximateNumberOfMessagesDelayed),
+	}, nil
+}
@@ -0,0 +1,187 @@
+package queue
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+var (
+	sqsClient         *sqs.Client
+	queueURL          string
+	fifo              bool
+	contentBasedDedup bool
+	// resultsQueueURL is the queue completion events are sent to, or empty
+	// when they aren't published
+	resultsQueueURL string
+)
+
+// S3Event is the message body understood by the processing Lambda. It mirrors
+// the shape of an S3 event notification so both sources can share one queue.
+type S3Event struct {
+	Records []S3EventRecord `json:"Records"`
+	// JobID is set when the message was published for a scheduled job
+	JobID string `json:"jobId,omitempty"`
+	// ScheduleRunID is set when the message re-runs processing for a
+	// recurring schedule, and Processor names the processor it runs instead
+	// of the file's pipeline, if any
+	ScheduleRunID string `json:"scheduleRunId,omitempty"`
+	Processor     string `json:"processor,omitempty"`
+	// RequeueID is set when an administrator sent the message again for a
+	// file that was stuck. It identifies the message in place of its SQS
+	// message ID.
+	RequeueID string `json:"requeueId,omitempty"`
+}
+
+// S3EventRecord is a single object reference inside an S3Event
+type S3EventRecord struct {
+	// EventSource is "aws:s3" on S3 notifications; events published by the
+	// API leave it out
+	EventSource string `json:"eventSource,omitempty"`
+	S3          struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key string `json:"key"`
+			// Size is sent by S3 notifications; events published by the
+			// API leave it out
+			Size int64 `json:"size,omitempty"`
+			// ETag is that of the object the event is for. It is part of
+			// the body deduplication IDs are derived from, so a rewrite of
+			// the same key isn't taken for a duplicate of the last event.
+			ETag string `json:"eTag,omitempty"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+// InitQueue initializes the SQS client and queue settings
+func InitQueue(cfg aws.Config) {
+	sqsClient = sqs.NewFromConfig(cfg)
+
+	queueURL = getEnv("SQS_QUEUE_URL", "http://localhost:4566/000000000000/my-queue")
+
+	// FIFO queues are detected from the ".fifo" suffix unless explicitly configured
+	fifo = strings.HasSuffix(queueURL, ".fifo")
+	if v := os.Getenv("SQS_FIFO"); v != "" {
+		fifo = v == "true"
+	}
+	contentBasedDedup = os.Getenv("SQS_CONTENT_BASED_DEDUP") == "true"
+	resultsQueueURL = os.Getenv("RESULTS_QUEUE_URL")
+	keys = loadSigningKeys()
+}
+
+// IsFIFO reports whether the configured queue is a FIFO queue
+func IsFIFO() bool {
+	return fifo
+}
+
+// QueueURL returns the configured queue URL
+func QueueURL() string {
+	return queueURL
+}
+
+// NewS3Event builds a single-record S3Event for the given object
+func NewS3Event(bucket, key string) S3Event {
+	var record S3EventRecord
+	record.S3.Bucket.Name = bucket
+	record.S3.Object.Key = key
+	return S3Event{Records: []S3EventRecord{record}}
+}
+
+// MaxDelay is the longest delivery delay SQS supports for a single message
+const MaxDelay = 15 * time.Minute
+
+// PublishFileEvent sends a processing message for the given file to the queue.
+// On FIFO queues the file ID is used as the message group so that events for the
+// same file are processed in order. etag is the ETag of the object as written.
+func PublishFileEvent(ctx context.Context, fileID, bucket, key, etag string) error {
+	event := NewS3Event(bucket, key)
+	event.Records[0].S3.Object.ETag = etag
+	return publish(ctx, fileID, event, 0)
+}
+
+// PublishScheduledFileEvent sends a processing message for a scheduled job,
+// delaying delivery by up to MaxDelay. FIFO queues don't support per-message
+// delays, so the delay is ignored there.
+func PublishScheduledFileEvent(ctx context.Context, fileID, bucket, key, jobID string, delay time.Duration) error {
+	event := NewS3Event(bucket, key)
+	event.JobID = jobID
+	return publish(ctx, fileID, event, delay)
+}
+
+func publish(ctx context.Context, fileID string, event S3Event, delay time.Duration) error {
+	m, err := newMessage(fileID, event, delay)
+	if err != nil {
+		return err
+	}
+	_, err = sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               aws.String(queueURL),
+		MessageBody:            m.body,
+		DelaySeconds:           m.delaySeconds,
+		MessageGroupId:         m.groupID,
+		MessageDeduplicationId: m.dedupID,
+		MessageAttributes:      m.attributes,
+	})
+	return err
+}
+
+// message is a processing message ready to send on its own or in a batch
+type message struct {
+	body         *string
+	delaySeconds int32
+	groupID      *string
+	dedupID      *string
+	attributes   map[string]types.MessageAttributeValue
+}
+
+func newMessage(fileID string, event S3Event, delay time.Duration) (message, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return message{}, err
+	}
+	m := message{
+		body:       aws.String(string(body)),
+		attributes: signatureAttributes(string(body), time.Now()),
+	}
+
+	if delay > MaxDelay {
+		delay = MaxDelay
+	}
+	if delay > 0 && !fifo {
+		m.delaySeconds = int32(delay.Seconds())
+	}
+
+	if fifo {
+		m.groupID = aws.String(fileID)
+		// Without content-based deduplication on the queue an explicit ID is required
+		if !contentBasedDedup {
+			m.dedupID = aws.String(DeduplicationID(string(body)))
+		}
+	}
+	return m, nil
+}
+
+// DeduplicationID returns a deduplication ID derived from the message body,
+// which names the object's ETag when the publisher knows it
+func DeduplicationID(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// Helper function to get environment variables
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,27 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMessageDeduplicatesByObjectVersion(t *testing.T) {
+	oldFIFO, oldDedup := fifo, contentBasedDedup
+	fifo, contentBasedDedup = true, false
+	t.Cleanup(func() { fifo, contentBasedDedup = oldFIFO, oldDedup })
+
+	dedupID := func(etag string) string {
+		event := NewS3Event("b", "files/1/a.txt")
+		event.Records[0].S3.Object.ETag = etag
+		m, err := newMessage("1", event, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, "1", aws.ToString(m.groupID))
+		return aws.ToString(m.dedupID)
+	}
+
+	// Retries of the same send are deduplicated, a rewrite of the key isn't
+	assert.Equal(t, dedupID(`"e1"`), dedupID(`"e1"`))
+	assert.NotEqual(t, dedupID(`"e1"`), dedupID(`"e2"`))
+}
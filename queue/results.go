@@ -0,0 +1,55 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// ResultEvent announces that processing of a file finished. The worker sends
+// one to the results queue after saving the result, and the API pushes it on
+// to clients instead of having them poll for the result.
+type ResultEvent struct {
+	FileID string `json:"file_id"`
+	Status string `json:"status"`
+	// JobID is set when the file was processed for a scheduled job
+	JobID       string    `json:"job_id,omitempty"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// ResultsEnabled reports whether RESULTS_QUEUE_URL is set
+func ResultsEnabled() bool {
+	return resultsQueueURL != ""
+}
+
+// PublishResult sends a completion event to the results queue. It does
+// nothing when the queue isn't configured.
+func PublishResult(ctx context.Context, event ResultEvent) error {
+	if !ResultsEnabled() {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(resultsQueueURL),
+		MessageBody:       aws.String(string(body)),
+		MessageAttributes: signatureAttributes(string(body), time.Now()),
+	})
+	return err
+}
+
+// ReceiveResults long-polls the results queue like Receive
+func ReceiveResults(ctx context.Context, max int32, wait time.Duration) ([]types.Message, error) {
+	return receive(ctx, resultsQueueURL, max, wait)
+}
+
+// DeleteResults removes handled completion events like DeleteBatch
+func DeleteResults(ctx context.Context, receiptHandles []string) []error {
+	return deleteBatch(ctx, resultsQueueURL, receiptHandles)
+}
@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// maxVisibilityTimeout is the longest visibility timeout SQS accepts
+const maxVisibilityTimeout = 12 * time.Hour
+
+// RetryPolicy controls how failed processing is retried
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// LoadRetryPolicy reads the retry policy from environment variables
+func LoadRetryPolicy() RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   10 * time.Second,
+		MaxDelay:    15 * time.Minute,
+	}
+
+	if v := os.Getenv("PROCESSING_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Printf("Invalid PROCESSING_MAX_ATTEMPTS %q, using %d", v, policy.MaxAttempts)
+		} else {
+			policy.MaxAttempts = n
+		}
+	}
+	if v := os.Getenv("PROCESSING_RETRY_BASE_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Printf("Invalid PROCESSING_RETRY_BASE_DELAY %q, using %s", v, policy.BaseDelay)
+		} else {
+			policy.BaseDelay = d
+		}
+	}
+	if v := os.Getenv("PROCESSING_RETRY_MAX_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Printf("Invalid PROCESSING_RETRY_MAX_DELAY %q, using %s", v, policy.MaxDelay)
+		} else {
+			policy.MaxDelay = d
+		}
+	}
+
+	return policy
+}
+
+// Delay returns the backoff before the next attempt after the given number of
+// failed attempts: BaseDelay doubled for every attempt, capped at MaxDelay
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Exhausted reports whether no attempts remain after the given number of failures
+func (p RetryPolicy) Exhausted(attempts int) bool {
+	return attempts >= p.MaxAttempts
+}
+
+// ChangeVisibility hides a received message for the given duration so that SQS
+// redelivers it only once the delay has passed
+func ChangeVisibility(ctx context.Context, receiptHandle string, delay time.Duration) error {
+	if delay > maxVisibilityTimeout {
+		delay = maxVisibilityTimeout
+	}
+
+	_, err := sqsClient.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(queueURL),
+		ReceiptHandle:     aws.String(receiptHandle),
+		VisibilityTimeout: int32(delay.Seconds()),
+	})
+	return err
+}
@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/yourusername/golang-aws-api/signing"
+)
+
+// Message attributes carrying the signature of a message body and the time
+// it was signed
+const (
+	SignatureAttribute          = "Signature"
+	SignatureTimestampAttribute = "SignatureTimestamp"
+)
+
+// signatureMaxAge is how far a signature's timestamp may be from the
+// receiver's clock. SQS keeps a message for at most 14 days, and a delayed
+// or retried one can legitimately wait that long; replays within that time
+// are caught by the processed message records.
+const signatureMaxAge = 14 * 24 * time.Hour
+
+// Verification errors
+var (
+	ErrUnsigned     = errors.New("message is not signed")
+	ErrBadSignature = errors.New("message signature is invalid")
+)
+
+// signingKeys are the secrets messages are signed and verified with. Only
+// current signs; previous is still accepted until previousUntil, so senders
+// and receivers can be rolled over to a new key one at a time.
+type signingKeys struct {
+	current       string
+	previous      string
+	previousUntil time.Time
+	// acceptS3 lets unsigned S3 event notifications through, as S3 can't
+	// sign them
+	acceptS3 bool
+}
+
+var keys signingKeys
+
+// loadSigningKeys reads SQS_SIGNING_KEY, SQS_SIGNING_PREVIOUS_KEY with the
+// RFC 3339 time SQS_SIGNING_PREVIOUS_KEY_UNTIL it stops being accepted, and
+// SQS_ACCEPT_S3_NOTIFICATIONS. A previous key without a valid end time is
+// ignored.
+func loadSigningKeys() signingKeys {
+	k := signingKeys{
+		current:  os.Getenv("SQS_SIGNING_KEY"),
+		acceptS3: os.Getenv("SQS_ACCEPT_S3_NOTIFICATIONS") == "true",
+	}
+	if previous := os.Getenv("SQS_SIGNING_PREVIOUS_KEY"); previous != "" {
+		until, err := time.Parse(time.RFC3339, os.Getenv("SQS_SIGNING_PREVIOUS_KEY_UNTIL"))
+		if err != nil {
+			log.Printf("Ignoring SQS_SIGNING_PREVIOUS_KEY: SQS_SIGNING_PREVIOUS_KEY_UNTIL must be an RFC 3339 time: %v", err)
+		} else {
+			k.previous, k.previousUntil = previous, until
+		}
+	}
+	return k
+}
+
+// SigningEnabled reports whether messages are signed and verified
+func SigningEnabled() bool {
+	return keys.current != ""
+}
+
+func (k signingKeys) secrets(now time.Time) []string {
+	if k.previous != "" && now.Before(k.previousUntil) {
+		return []string{k.current, k.previous}
+	}
+	return []string{k.current}
+}
+
+// signatureAttributes returns the attributes that sign body, or nil when
+// signing is off
+func signatureAttributes(body string, now time.Time) map[string]types.MessageAttributeValue {
+	if keys.current == "" {
+		return nil
+	}
+	ts := signing.Timestamp(now)
+	return map[string]types.MessageAttributeValue{
+		SignatureAttribute:          {DataType: aws.String("String"), StringValue: aws.String(signing.Sign(keys.current, ts, []byte(body)))},
+		SignatureTimestampAttribute: {DataType: aws.String("String"), StringValue: aws.String(ts)},
+	}
+}
+
+// VerifyMessage checks a received message body against the values of its
+// signature attributes. With signing off every message passes. Otherwise an
+// unsigned message is refused with ErrUnsigned, unless it is an S3 event
+// notification and those are accepted, and a forged or altered one with
+// ErrBadSignature.
+func VerifyMessage(body, signature, timestamp string) error {
+	if keys.current == "" {
+		return nil
+	}
+	if signature == "" && timestamp == "" {
+		if keys.acceptS3 && isS3Notification(body) {
+			return nil
+		}
+		return ErrUnsigned
+	}
+	now := time.Now()
+	if _, err := signing.Verify(signature, timestamp, []byte(body), keys.secrets(now), now, signatureMaxAge); err != nil {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// isS3Notification reports whether body is an S3 event notification, all of
+// whose records come from S3
+func isS3Notification(body string) bool {
+	var event S3Event
+	if err := json.Unmarshal([]byte(body), &event); err != nil || len(event.Records) == 0 || event.JobID != "" || event.ScheduleRunID != "" || event.RequeueID != "" {
+		return false
+	}
+	for _, r := range event.Records {
+		if r.EventSource != "aws:s3" {
+			return false
+		}
+	}
+	return true
+}
+
+// MessageSignature returns the signature attribute values of a message
+// received with ReceiveMessage
+func MessageSignature(m types.Message) (signature, timestamp string) {
+	return aws.ToString(m.MessageAttributes[SignatureAttribute].StringValue),
+		aws.ToString(m.MessageAttributes[SignatureTimestampAttribute].StringValue)
+}
@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func withSigningKeys(t *testing.T, k signingKeys) {
+	old := keys
+	keys = k
+	t.Cleanup(func() { keys = old })
+}
+
+func signed(body string) (string, string) {
+	attrs := signatureAttributes(body, time.Now())
+	return aws.ToString(attrs[SignatureAttribute].StringValue), aws.ToString(attrs[SignatureTimestampAttribute].StringValue)
+}
+
+func TestVerifyMessage(t *testing.T) {
+	withSigningKeys(t, signingKeys{current: "k1"})
+	body := `{"Records":[{"s3":{"bucket":{"name":"b"},"object":{"key":"files/1/a.txt"}}}]}`
+	sig, ts := signed(body)
+
+	assert.NoError(t, VerifyMessage(body, sig, ts))
+	assert.ErrorIs(t, VerifyMessage(`{"Records":[]}`, sig, ts), ErrBadSignature)
+	assert.ErrorIs(t, VerifyMessage(body, "", ""), ErrUnsigned)
+
+	// Without a key nothing is checked
+	withSigningKeys(t, signingKeys{})
+	assert.Nil(t, signatureAttributes(body, time.Now()))
+	assert.NoError(t, VerifyMessage(body, "", ""))
+}
+
+func TestVerifyMessageDuringKeyRotation(t *testing.T) {
+	body := `{"file_id":"1","status":"completed"}`
+	withSigningKeys(t, signingKeys{current: "old"})
+	sig, ts := signed(body)
+
+	// Receivers switched to the new key accept the old one until its end
+	withSigningKeys(t, signingKeys{current: "new", previous: "old", previousUntil: time.Now().Add(time.Hour)})
+	assert.NoError(t, VerifyMessage(body, sig, ts))
+
+	withSigningKeys(t, signingKeys{current: "new", previous: "old", previousUntil: time.Now().Add(-time.Hour)})
+	assert.ErrorIs(t, VerifyMessage(body, sig, ts), ErrBadSignature)
+}
+
+func TestVerifyMessageAcceptsS3Notifications(t *testing.T) {
+	notification := `{"Records":[{"eventSource":"aws:s3","s3":{"bucket":{"name":"b"},"object":{"key":"files/1/a.txt","size":5}}}]}`
+	published := `{"Records":[{"s3":{"bucket":{"name":"b"},"object":{"key":"files/1/a.txt"}}}]}`
+	scheduled := `{"Records":[{"eventSource":"aws:s3","s3":{"bucket":{"name":"b"},"object":{"key":"files/1/a.txt"}}}],"jobId":"j1"}`
+	requeued := `{"Records":[{"eventSource":"aws:s3","s3":{"bucket":{"name":"b"},"object":{"key":"files/1/a.txt"}}}],"requeueId":"r1"}`
+
+	withSigningKeys(t, signingKeys{current: "k1"})
+	assert.ErrorIs(t, VerifyMessage(notification, "", ""), ErrUnsigned)
+
+	withSigningKeys(t, signingKeys{current: "k1", acceptS3: true})
+	assert.NoError(t, VerifyMessage(notification, "", ""))
+	assert.ErrorIs(t, VerifyMessage(published, "", ""), ErrUnsigned)
+	assert.ErrorIs(t, VerifyMessage(scheduled, "", ""), ErrUnsigned)
+	assert.ErrorIs(t, VerifyMessage(requeued, "", ""), ErrUnsigned)
+}
@@ -0,0 +1,73 @@
+// Package quota enforces the upload quotas from the runtime settings, and
+// each tenant's overrides of them, for every ingestion path
+package quota
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/yourusername/golang-aws-api/apierrors"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/settings"
+	"github.com/yourusername/golang-aws-api/tenants"
+)
+
+// Check returns a QUOTA_EXCEEDED error if storing size more bytes would take
+// the user past their quotas, and an UNSUPPORTED_FORMAT error if the user
+// may not upload files called name. Uploads without a user are only held to
+// the global per-file limit; anonymous uploads count against the anonymous
+// system user's quotas.
+//
+// Check rejects uploads early, before their content is handled. Only
+// CheckTx, in the transaction that saves the file, enforces the quotas.
+func Check(userID, name string, size int64) error {
+	return check(userID, name, size, func() (int, int64, error) {
+		return database.UserUsage(userID)
+	})
+}
+
+// CheckTx is Check run inside the transaction that saves the file. The
+// user's usage stays locked until the transaction ends, so concurrent uploads
+// can't each fit under a quota that together they exceed.
+func CheckTx(tx *sql.Tx, userID, name string, size int64) error {
+	return check(userID, name, size, func() (int, int64, error) {
+		return database.UserUsageTx(tx, userID)
+	})
+}
+
+func check(userID, name string, size int64, usage func() (int, int64, error)) error {
+	if userID == "" {
+		s := settings.Current()
+		if s.MaxFileBytes > 0 && size > s.MaxFileBytes {
+			return apierrors.New(apierrors.CodeQuotaExceeded, fmt.Sprintf("File exceeds the %d byte limit", s.MaxFileBytes))
+		}
+		return nil
+	}
+
+	s, err := tenants.For(userID)
+	if err != nil {
+		return err
+	}
+	if !s.TypeAllowed(name) {
+		return apierrors.New(apierrors.CodeUnsupportedFormat, "Files must be one of "+strings.Join(s.FileTypes, ", "))
+	}
+	if s.MaxFileBytes > 0 && size > s.MaxFileBytes {
+		return apierrors.New(apierrors.CodeQuotaExceeded, fmt.Sprintf("File exceeds the %d byte limit", s.MaxFileBytes))
+	}
+	if s.MaxFiles == 0 && s.MaxBytes == 0 {
+		return nil
+	}
+
+	files, bytes, err := usage()
+	if err != nil {
+		return err
+	}
+	if s.MaxFiles > 0 && files >= s.MaxFiles {
+		return apierrors.New(apierrors.CodeQuotaExceeded, fmt.Sprintf("File limit of %d reached", s.MaxFiles))
+	}
+	if s.MaxBytes > 0 && bytes+size > s.MaxBytes {
+		return apierrors.New(apierrors.CodeQuotaExceeded, fmt.Sprintf("Storage limit of %d bytes reached", s.MaxBytes))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Package removal deletes files together with everything stored for them, for
+// the API's deletions and expiry and for the report tool's fixes alike, so
+// that none of them leaves a redacted copy, offloaded result or search
+// document behind.
+package removal
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/search"
+	"github.com/yourusername/golang-aws-api/storage"
+)
+
+// ObjectDeleter deletes S3 objects; storage.Store implements it, deleting
+// from the replica bucket as well
+type ObjectDeleter interface {
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// Remover deletes files from the database, Bucket and the search index
+type Remover struct {
+	S3     ObjectDeleter
+	Bucket string
+	Index  search.Backend
+}
+
+// Remove deletes a file's row, then everything stored for it with
+// DeleteStored, including the offloaded results no reused result still
+// points to. Retention of bypassMode is lifted, if set. Only the row's
+// deletion can fail. It returns the number of objects deleted.
+func (r *Remover) Remove(ctx context.Context, f *database.File, bypassMode string) (int, error) {
+	var err error
+	if bypassMode != "" {
+		err = database.DeleteFileBypassingRetention(f.ID, bypassMode)
+	} else {
+		err = database.DeleteFile(f.ID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Deleting the row queued the file's offloaded results; they are deleted
+	// now rather than by the scheduler
+	keys, err := database.ClaimResultObjectDeletionsUnder(storage.FileResultPrefix(f.ID))
+	if err != nil {
+		log.Printf("Error claiming offloaded results of file %s: %v", f.ID, err)
+	}
+	return r.DeleteStored(ctx, f, bypassMode, keys), nil
+}
+
+// DeleteStored deletes what is stored for a file whose row is gone: its
+// search document, its object, its redacted copy and the offloaded results
+// at resultKeys. Failures are only logged, for the reconcile job to clean up.
+// It returns the number of objects deleted.
+func (r *Remover) DeleteStored(ctx context.Context, f *database.File, bypassMode string, resultKeys []string) int {
+	// Search results are filtered against the files table, so a document
+	// left behind is never shown
+	if err := r.Index.Delete(ctx, f.ID); err != nil {
+		log.Printf("Error removing file %s from the search index: %v", f.ID, err)
+	}
+
+	deleted := 0
+	// In a versioned bucket this only adds a delete marker; locked versions
+	// stay until their retention ends
+	_, err := r.S3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:                    aws.String(r.Bucket),
+		Key:                       aws.String(f.S3Key),
+		BypassGovernanceRetention: bypassMode == storage.RetentionGovernance && f.RetentionMode == storage.RetentionGovernance,
+	})
+	if err != nil {
+		log.Printf("Error deleting object %s of file %s: %v", f.S3Key, f.ID, err)
+	} else {
+		deleted++
+	}
+	if f.Redacted() && r.DeleteObject(ctx, f.RedactedKey) {
+		deleted++
+	}
+	for _, key := range resultKeys {
+		if r.DeleteObject(ctx, key) {
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// DeleteObject deletes an object, reporting whether it succeeded. Failures
+// are only logged, since the rows pointing to the object are already gone.
+func (r *Remover) DeleteObject(ctx context.Context, key string) bool {
+	_, err := r.S3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(r.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		log.Printf("Error deleting object %s: %v", key, err)
+		return false
+	}
+	return true
+}
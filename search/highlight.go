@@ -0,0 +1,18 @@
+package search
+
+import (
+	"html"
+	"strings"
+
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+// highlightTags turns the match markers into the <b></b> tags Hit promises
+var highlightTags = strings.NewReplacer(database.HighlightStart, "<b>", database.HighlightStop, "</b>")
+
+// highlightHTML returns a fragment whose matches are between the highlight
+// markers as HTML. The text is escaped first, so file names and content can't
+// add markup of their own.
+func highlightHTML(fragment string) string {
+	return highlightTags.Replace(html.EscapeString(fragment))
+}
@@ -0,0 +1,14 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+func TestHighlightHTML(t *testing.T) {
+	fragment := `<img src=x onerror=alert(1)> pay the ` + database.HighlightStart + "invoice" + database.HighlightStop + ` & "more"`
+	assert.Equal(t, `&lt;img src=x onerror=alert(1)&gt; pay the <b>invoice</b> &amp; &#34;more&#34;`, highlightHTML(fragment))
+	assert.Equal(t, "", highlightHTML(""))
+}
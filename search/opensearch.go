@@ -0,0 +1,251 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/awsconfig"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// OpenSearch indexes files in an OpenSearch or Elasticsearch cluster through
+// its REST API
+type OpenSearch struct {
+	url    string
+	index  string
+	client *http.Client
+
+	// ensured is set once the index exists, so creation is retried after
+	// a failure instead of failing every request until a restart
+	ensureMu sync.Mutex
+	ensured  bool
+}
+
+// NewOpenSearch returns a backend for the given cluster URL and index name
+func NewOpenSearch(url, index string) *OpenSearch {
+	return &OpenSearch{
+		url:    strings.TrimRight(url, "/"),
+		index:  index,
+		client: awsconfig.NewHTTPClient(10 * time.Second),
+	}
+}
+
+// indexMapping keeps IDs exact so they can be filtered and sorted on
+const indexMapping = `{
+	"mappings": {
+		"properties": {
+			"file_id":    {"type": "keyword"},
+			"user_id":    {"type": "keyword"},
+			"name":       {"type": "text"},
+			"content":    {"type": "text"},
+			"created_at": {"type": "date"}
+		}
+	}
+}`
+
+// osDocument is a file as stored in the index
+type osDocument struct {
+	FileID    string    `json:"file_id"`
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ensureIndex creates the index with its mapping the first time it is needed
+func (o *OpenSearch) ensureIndex(ctx context.Context) error {
+	o.ensureMu.Lock()
+	defer o.ensureMu.Unlock()
+	if o.ensured {
+		return nil
+	}
+
+	status, body, err := o.do(ctx, http.MethodPut, "/"+o.index, []byte(indexMapping))
+	if err != nil {
+		return err
+	}
+	if status >= 300 && !strings.Contains(string(body), "resource_already_exists_exception") {
+		return fmt.Errorf("creating index %s: status %d: %s", o.index, status, body)
+	}
+	o.ensured = true
+	return nil
+}
+
+func (o *OpenSearch) Index(ctx context.Context, doc Document) error {
+	if err := o.ensureIndex(ctx); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(osDocument{
+		FileID:    doc.FileID,
+		UserID:    doc.UserID,
+		Name:      doc.Name,
+		Content:   doc.Content,
+		CreatedAt: doc.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	status, resp, err := o.do(ctx, http.MethodPut, "/"+o.index+"/_doc/"+doc.FileID, body)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("indexing file %s: status %d: %s", doc.FileID, status, resp)
+	}
+	return nil
+}
+
+func (o *OpenSearch) Rename(ctx context.Context, fileID, name string) error {
+	body, err := json.Marshal(map[string]interface{}{"doc": map[string]string{"name": name}})
+	if err != nil {
+		return err
+	}
+	status, resp, err := o.do(ctx, http.MethodPost, "/"+o.index+"/_update/"+fileID, body)
+	if err != nil {
+		return err
+	}
+	// Files that haven't been processed yet aren't indexed
+	if status >= 300 && status != http.StatusNotFound {
+		return fmt.Errorf("renaming file %s: status %d: %s", fileID, status, resp)
+	}
+	return nil
+}
+
+func (o *OpenSearch) Delete(ctx context.Context, fileID string) error {
+	status, resp, err := o.do(ctx, http.MethodDelete, "/"+o.index+"/_doc/"+fileID, nil)
+	if err != nil {
+		return err
+	}
+	// Files that were never processed were never indexed
+	if status >= 300 && status != http.StatusNotFound {
+		return fmt.Errorf("deleting file %s: status %d: %s", fileID, status, resp)
+	}
+	return nil
+}
+
+// osSearchResponse is the part of a search response that is read
+type osSearchResponse struct {
+	Hits struct {
+		Hits []struct {
+			ID        string              `json:"_id"`
+			Source    osDocument          `json:"_source"`
+			Highlight map[string][]string `json:"highlight"`
+			Sort      []interface{}       `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+func (o *OpenSearch) Search(ctx context.Context, userID, query string, after *pagination.Cursor, limit int) ([]Hit, error) {
+	req := map[string]interface{}{
+		"size": limit + 1,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]string{"user_id": userID}},
+				},
+				"must": []interface{}{
+					map[string]interface{}{"simple_query_string": map[string]interface{}{
+						"query":            query,
+						"fields":           []string{"name^2", "content"},
+						"default_operator": "and",
+					}},
+				},
+			},
+		},
+		// Same order as the Postgres backend: relevance, then newest first
+		"sort": []interface{}{
+			map[string]string{"_score": "desc"},
+			map[string]string{"created_at": "desc"},
+			map[string]string{"file_id": "desc"},
+		},
+		"track_scores": true,
+		"highlight": map[string]interface{}{
+			// Markers, not tags, so the fragments can be escaped before
+			// the matches are marked up
+			"pre_tags":  []string{database.HighlightStart},
+			"post_tags": []string{database.HighlightStop},
+			"fields": map[string]interface{}{
+				"name":    map[string]int{"number_of_fragments": 0},
+				"content": map[string]int{"number_of_fragments": 2},
+			},
+		},
+	}
+	if after != nil {
+		req["search_after"] = []interface{}{after.Rank, after.CreatedAt.UnixMilli(), after.ID}
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	status, resp, err := o.do(ctx, http.MethodPost, "/"+o.index+"/_search", body)
+	if err != nil {
+		return nil, err
+	}
+	// Nothing has been indexed yet
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if status >= 300 {
+		return nil, fmt.Errorf("searching: status %d: %s", status, resp)
+	}
+
+	var result osSearchResponse
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("decoding search response: %v", err)
+	}
+
+	hits := make([]Hit, 0, len(result.Hits.Hits))
+	for _, h := range result.Hits.Hits {
+		hit := Hit{
+			FileID:           h.ID,
+			CreatedAt:        h.Source.CreatedAt,
+			NameHighlight:    highlightHTML(strings.Join(h.Highlight["name"], " ")),
+			ContentHighlight: highlightHTML(strings.Join(h.Highlight["content"], " ... ")),
+		}
+		if hit.NameHighlight == "" {
+			hit.NameHighlight = highlightHTML(h.Source.Name)
+		}
+		// Cursors must carry the exact sort values to resume with search_after
+		if len(h.Sort) == 3 {
+			if score, ok := h.Sort[0].(float64); ok {
+				hit.Rank = score
+			}
+			if millis, ok := h.Sort[1].(float64); ok {
+				hit.CreatedAt = time.UnixMilli(int64(millis)).UTC()
+			}
+		}
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
+
+// do sends a JSON request to the cluster and returns the status and body
+func (o *OpenSearch) do(ctx context.Context, method, path string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, o.url+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
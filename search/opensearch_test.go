@@ -0,0 +1,123 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+func TestOpenSearchIndex(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/files/_doc/f1" {
+			var doc osDocument
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&doc))
+			assert.Equal(t, "u1", doc.UserID)
+			assert.Equal(t, "report.txt", doc.Name)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	backend := NewOpenSearch(server.URL, "files")
+	doc := Document{FileID: "f1", UserID: "u1", Name: "report.txt", Content: "quarterly numbers", CreatedAt: time.Now()}
+	assert.NoError(t, backend.Index(context.Background(), doc))
+	assert.NoError(t, backend.Index(context.Background(), doc))
+
+	// The index is only created once
+	assert.Equal(t, []string{"PUT /files", "PUT /files/_doc/f1", "PUT /files/_doc/f1"}, paths)
+}
+
+func TestOpenSearchIndexRetriesCreatingIndex(t *testing.T) {
+	var paths []string
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/files" && failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	backend := NewOpenSearch(server.URL, "files")
+	doc := Document{FileID: "f1", UserID: "u1", Name: "report.txt"}
+	assert.Error(t, backend.Index(context.Background(), doc))
+
+	// A cluster that was briefly unavailable doesn't fail indexing for good
+	failing = false
+	assert.NoError(t, backend.Index(context.Background(), doc))
+	assert.NoError(t, backend.Index(context.Background(), doc))
+	assert.Equal(t, []string{"PUT /files", "PUT /files", "PUT /files/_doc/f1", "PUT /files/_doc/f1"}, paths)
+}
+
+func TestOpenSearchDelete(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/files/_doc/never-indexed" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Path == "/files/_doc/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	backend := NewOpenSearch(server.URL, "files")
+	assert.NoError(t, backend.Delete(context.Background(), "f1"))
+	assert.NoError(t, backend.Delete(context.Background(), "never-indexed"))
+	assert.Error(t, backend.Delete(context.Background(), "broken"))
+	assert.Equal(t, "DELETE /files/_doc/f1", paths[0])
+}
+
+func TestOpenSearchSearch(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/files/_search", r.URL.Path)
+
+		body, _ := io.ReadAll(r.Body)
+		var req map[string]interface{}
+		assert.NoError(t, json.Unmarshal(body, &req))
+		assert.Equal(t, float64(3), req["size"])
+		assert.Equal(t, []interface{}{1.5, float64(created.UnixMilli()), "f0"}, req["search_after"])
+
+		w.Write([]byte(`{"hits": {"hits": [{
+			"_id": "f1",
+			"_source": {"file_id": "f1", "name": "<i>invoice</i>.txt", "created_at": "2024-05-01T12:00:00Z"},
+			"highlight": {"content": ["pay the \u0002invoice\u0003 <script>"]},
+			"sort": [1.25, ` + jsonNumber(created.UnixMilli()) + `, "f1"]
+		}]}}`))
+	}))
+	defer server.Close()
+
+	backend := NewOpenSearch(server.URL, "files")
+	after := &pagination.Cursor{CreatedAt: created, ID: "f0", Rank: 1.5}
+	hits, err := backend.Search(context.Background(), "u1", "invoice", after, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, hits, 1) {
+		assert.Equal(t, "f1", hits[0].FileID)
+		assert.Equal(t, 1.25, hits[0].Rank)
+		assert.True(t, created.Equal(hits[0].CreatedAt))
+		assert.Equal(t, "&lt;i&gt;invoice&lt;/i&gt;.txt", hits[0].NameHighlight)
+		assert.Equal(t, "pay the <b>invoice</b> &lt;script&gt;", hits[0].ContentHighlight)
+	}
+}
+
+func jsonNumber(n int64) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
@@ -0,0 +1,45 @@
+package search
+
+import (
+	"context"
+
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// Postgres searches the tsvector column on the files table
+type Postgres struct{}
+
+// Index stores the extracted text; the search vector is a generated column
+func (Postgres) Index(ctx context.Context, doc Document) error {
+	return database.SetFileSearchText(doc.FileID, doc.Content)
+}
+
+// Rename is a no-op because the search vector follows the name column
+func (Postgres) Rename(ctx context.Context, fileID, name string) error {
+	return nil
+}
+
+// Delete is a no-op because the search vector goes with the file's row
+func (Postgres) Delete(ctx context.Context, fileID string) error {
+	return nil
+}
+
+func (Postgres) Search(ctx context.Context, userID, query string, after *pagination.Cursor, limit int) ([]Hit, error) {
+	matches, err := database.SearchFiles(userID, query, after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]Hit, 0, len(matches))
+	for _, m := range matches {
+		hits = append(hits, Hit{
+			FileID:           m.ID,
+			CreatedAt:        m.CreatedAt,
+			Rank:             m.Rank,
+			NameHighlight:    highlightHTML(m.NameHighlight),
+			ContentHighlight: highlightHTML(m.ContentHighlight),
+		})
+	}
+	return hits, nil
+}
@@ -0,0 +1,78 @@
+package search
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/pagination"
+)
+
+// Supported search backends
+const (
+	BackendPostgres   = "postgres"
+	BackendOpenSearch = "opensearch"
+)
+
+// Document is the searchable representation of a file
+type Document struct {
+	FileID    string
+	UserID    string
+	Name      string
+	Content   string
+	CreatedAt time.Time
+}
+
+// Hit is a file matching a search query
+type Hit struct {
+	FileID    string
+	CreatedAt time.Time
+	Rank      float64
+	// NameHighlight and ContentHighlight are HTML fragments with matches
+	// wrapped in <b></b> and everything else escaped
+	NameHighlight    string
+	ContentHighlight string
+}
+
+// Cursor returns the pagination cursor positioned at this hit
+func (h Hit) Cursor() pagination.Cursor {
+	return pagination.Cursor{CreatedAt: h.CreatedAt, ID: h.FileID, Rank: h.Rank}
+}
+
+// Backend indexes and searches files
+type Backend interface {
+	// Index adds or replaces a file's document
+	Index(ctx context.Context, doc Document) error
+	// Rename updates the indexed name of a file
+	Rename(ctx context.Context, fileID, name string) error
+	// Delete removes a file's document; files that were never indexed are
+	// not an error
+	Delete(ctx context.Context, fileID string) error
+	// Search returns a user's files matching the query, most relevant first,
+	// starting after the given cursor. Up to limit+1 hits are returned so the
+	// caller can tell whether another page follows.
+	Search(ctx context.Context, userID, query string, after *pagination.Cursor, limit int) ([]Hit, error)
+}
+
+// New returns the backend selected by SEARCH_BACKEND. Postgres full-text search
+// is the default; OpenSearch (or Elasticsearch) is used for larger deployments.
+func New() Backend {
+	switch backend := os.Getenv("SEARCH_BACKEND"); backend {
+	case BackendOpenSearch:
+		url := os.Getenv("OPENSEARCH_URL")
+		if url == "" {
+			url = "http://localhost:9200"
+		}
+		index := os.Getenv("OPENSEARCH_INDEX")
+		if index == "" {
+			index = "files"
+		}
+		return NewOpenSearch(url, index)
+	case "", BackendPostgres:
+		return Postgres{}
+	default:
+		log.Printf("Unknown SEARCH_BACKEND %q, using %s", backend, BackendPostgres)
+		return Postgres{}
+	}
+}
@@ -0,0 +1,542 @@
+// Package settings holds the tunables that can change while a process runs:
+// log level, rate, concurrency and bandwidth limits, processor toggles and pipelines,
+// quotas, storage classes, Object Lock retention, file expiry, client-side
+// encryption, access log sampling, audit logging and anonymous uploads. They
+// are read from the environment and an optional JSON file at SETTINGS_FILE,
+// and reloaded from both on SIGHUP. Readers get an immutable snapshot that is
+// swapped atomically, so a reload never disturbs requests already in flight.
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/processor"
+	"github.com/yourusername/golang-aws-api/storage"
+)
+
+// Log levels
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+)
+
+// Settings is a snapshot of the runtime tunables. A zero limit means no limit.
+type Settings struct {
+	LogLevel string `json:"log_level"`
+
+	// Requests per minute allowed from one client, and how many of them may
+	// arrive at once
+	RateLimit int `json:"rate_limit"`
+	RateBurst int `json:"rate_burst"`
+
+	// Downloads streamed at once per endpoint, and per user (or per client
+	// address for anonymous share links) on each of those endpoints
+	StreamConcurrency        int `json:"stream_concurrency"`
+	StreamConcurrencyPerUser int `json:"stream_concurrency_per_user"`
+
+	// Bytes per second one user (or client address, when anonymous) may
+	// upload and download, shared by all of their requests at once
+	UploadBytesPerSecond   int64 `json:"upload_bytes_per_second"`
+	DownloadBytesPerSecond int64 `json:"download_bytes_per_second"`
+
+	// Processor types whose files are skipped instead of processed
+	DisabledProcessors []string `json:"disabled_processors"`
+
+	// Processors run one after another on files of a type, keyed by
+	// extension such as ".csv", with "*" for every other type. Types without
+	// a pipeline run their default processor. Pipelines a user saves for
+	// themselves take precedence.
+	Pipelines map[string][]string `json:"pipelines"`
+
+	// Quotas enforced on upload
+	MaxFileBytes    int64 `json:"max_file_bytes"`
+	MaxFilesPerUser int   `json:"max_files_per_user"`
+	MaxUserBytes    int64 `json:"max_user_bytes"`
+
+	// Log request and response bodies, redacted and capped at AuditMaxBytes
+	// each, for debugging
+	AuditBodies   bool `json:"audit_bodies"`
+	AuditMaxBytes int  `json:"audit_max_bytes"`
+
+	// Fraction of requests written to the access log per route template,
+	// e.g. {"/health": 0.01}; routes not listed are always logged, and so
+	// are server errors
+	AccessLogSampling map[string]float64 `json:"access_log_sampling"`
+
+	// Storage class of uploads that don't ask for one, the classes clients
+	// may ask for (empty allows every supported class), and per-user
+	// defaults keyed by user ID
+	DefaultStorageClass   string            `json:"default_storage_class"`
+	AllowedStorageClasses []string          `json:"allowed_storage_classes"`
+	UserStorageClasses    map[string]string `json:"user_storage_classes"`
+
+	// Object Lock retention applied to uploads, GOVERNANCE or COMPLIANCE for
+	// RetentionDays days; an empty mode writes objects without retention.
+	// UserRetention overrides it per user ID for regulated tenants.
+	RetentionMode string               `json:"retention_mode"`
+	RetentionDays int                  `json:"retention_days"`
+	UserRetention map[string]Retention `json:"user_retention"`
+
+	// Files older than FileExpiryDays days are deleted by the scheduler; 0
+	// keeps them forever. UserFileExpiryDays overrides it per user ID, where
+	// 0 turns expiry off. Files under retention are kept until it ends, and
+	// files on legal hold until it is released.
+	FileExpiryDays     int            `json:"file_expiry_days"`
+	UserFileExpiryDays map[string]int `json:"user_file_expiry_days"`
+
+	// KMS key that wraps the data keys of uploads encrypted by the API before
+	// they reach S3; empty leaves encryption to S3. UserEncryptionKeys
+	// overrides it per user ID, where an empty key turns encryption off.
+	EncryptionKeyID    string            `json:"encryption_key_id"`
+	UserEncryptionKeys map[string]string `json:"user_encryption_keys"`
+
+	// Uploads without credentials are only accepted when AnonymousUploads is
+	// set. They are owned by the anonymous system user, must be at most
+	// AnonymousMaxFileBytes and have one of the AnonymousUploadTypes
+	// extensions, are limited to AnonymousRateLimit per minute from one
+	// client, and are deleted after a day.
+	AnonymousUploads      bool     `json:"anonymous_uploads"`
+	AnonymousMaxFileBytes int64    `json:"anonymous_max_file_bytes"`
+	AnonymousUploadTypes  []string `json:"anonymous_upload_types"`
+	AnonymousRateLimit    int      `json:"anonymous_rate_limit"`
+
+	// A new revision of a file whose content matches the previous revision
+	// gets a copy of its result instead of being processed again, unless
+	// ReprocessUnchanged is set
+	ReprocessUnchanged bool `json:"reprocess_unchanged"`
+}
+
+// Retention is an Object Lock retention policy
+type Retention struct {
+	Mode string `json:"mode"`
+	Days int    `json:"days"`
+}
+
+func (r Retention) validate() error {
+	if r.Mode == "" {
+		return nil
+	}
+	if !storage.ValidRetentionMode(r.Mode) {
+		return fmt.Errorf("retention mode must be GOVERNANCE or COMPLIANCE, got %q", r.Mode)
+	}
+	if r.Days < 1 {
+		return fmt.Errorf("retention days must be positive")
+	}
+	return nil
+}
+
+// Defaults returns the settings used when nothing is configured
+func Defaults() Settings {
+	return Settings{
+		LogLevel:              LogLevelInfo,
+		AuditMaxBytes:         4096,
+		DefaultStorageClass:   storage.ClassStandard,
+		AnonymousMaxFileBytes: 1 << 20,
+		AnonymousUploadTypes:  []string{".txt", ".csv", ".json", ".pdf", ".png", ".jpg"},
+		AnonymousRateLimit:    10,
+	}
+}
+
+var current atomic.Pointer[Settings]
+
+func init() {
+	s := Defaults()
+	current.Store(&s)
+}
+
+// Current returns the active settings. The snapshot must not be modified.
+func Current() *Settings {
+	return current.Load()
+}
+
+// Set validates s and makes it the active snapshot
+func Set(s Settings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	s.DisabledProcessors = append([]string(nil), s.DisabledProcessors...)
+	pipelines := make(map[string][]string, len(s.Pipelines))
+	for fileType, stages := range s.Pipelines {
+		pipelines[fileType] = append([]string(nil), stages...)
+	}
+	s.Pipelines = pipelines
+	s.AllowedStorageClasses = append([]string(nil), s.AllowedStorageClasses...)
+	userClasses := make(map[string]string, len(s.UserStorageClasses))
+	for userID, class := range s.UserStorageClasses {
+		userClasses[userID] = class
+	}
+	s.UserStorageClasses = userClasses
+	userRetention := make(map[string]Retention, len(s.UserRetention))
+	for userID, retention := range s.UserRetention {
+		userRetention[userID] = retention
+	}
+	s.UserRetention = userRetention
+	sampling := make(map[string]float64, len(s.AccessLogSampling))
+	for route, rate := range s.AccessLogSampling {
+		sampling[route] = rate
+	}
+	s.AccessLogSampling = sampling
+	userExpiry := make(map[string]int, len(s.UserFileExpiryDays))
+	for userID, days := range s.UserFileExpiryDays {
+		userExpiry[userID] = days
+	}
+	s.UserFileExpiryDays = userExpiry
+	userKeys := make(map[string]string, len(s.UserEncryptionKeys))
+	for userID, keyID := range s.UserEncryptionKeys {
+		userKeys[userID] = keyID
+	}
+	s.UserEncryptionKeys = userKeys
+	s.AnonymousUploadTypes = append([]string(nil), s.AnonymousUploadTypes...)
+	current.Store(&s)
+	return nil
+}
+
+// Validate reports the first invalid value in s
+func (s Settings) Validate() error {
+	switch s.LogLevel {
+	case LogLevelDebug, LogLevelInfo:
+	default:
+		return fmt.Errorf("log_level must be debug or info, got %q", s.LogLevel)
+	}
+	if s.RateLimit < 0 || s.RateBurst < 0 {
+		return fmt.Errorf("rate_limit and rate_burst must not be negative")
+	}
+	if s.StreamConcurrency < 0 || s.StreamConcurrencyPerUser < 0 {
+		return fmt.Errorf("stream_concurrency and stream_concurrency_per_user must not be negative")
+	}
+	if s.UploadBytesPerSecond < 0 || s.DownloadBytesPerSecond < 0 {
+		return fmt.Errorf("upload_bytes_per_second and download_bytes_per_second must not be negative")
+	}
+	for fileType, stages := range s.Pipelines {
+		if err := ValidatePipeline(stages); err != nil {
+			return fmt.Errorf("pipelines for %s: %v", fileType, err)
+		}
+	}
+	if s.MaxFileBytes < 0 || s.MaxFilesPerUser < 0 || s.MaxUserBytes < 0 {
+		return fmt.Errorf("quotas must not be negative")
+	}
+	if s.AuditMaxBytes < 1 {
+		return fmt.Errorf("audit_max_bytes must be positive")
+	}
+	for route, rate := range s.AccessLogSampling {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("access_log_sampling for %s must be between 0 and 1, got %v", route, rate)
+		}
+	}
+	if !storage.ValidClass(s.DefaultStorageClass) {
+		return fmt.Errorf("default_storage_class must be one of %s, got %q", strings.Join(storage.Classes, ", "), s.DefaultStorageClass)
+	}
+	for _, class := range s.AllowedStorageClasses {
+		if !storage.ValidClass(class) {
+			return fmt.Errorf("allowed_storage_classes: unknown storage class %q", class)
+		}
+	}
+	for userID, class := range s.UserStorageClasses {
+		if !storage.ValidClass(class) {
+			return fmt.Errorf("user_storage_classes: unknown storage class %q for user %s", class, userID)
+		}
+	}
+	if err := (Retention{Mode: s.RetentionMode, Days: s.RetentionDays}).validate(); err != nil {
+		return err
+	}
+	for userID, retention := range s.UserRetention {
+		if err := retention.validate(); err != nil {
+			return fmt.Errorf("user_retention for user %s: %v", userID, err)
+		}
+	}
+	if s.FileExpiryDays < 0 {
+		return fmt.Errorf("file_expiry_days must not be negative")
+	}
+	for userID, days := range s.UserFileExpiryDays {
+		if days < 0 {
+			return fmt.Errorf("user_file_expiry_days for user %s must not be negative", userID)
+		}
+	}
+	if s.AnonymousUploads && (s.AnonymousMaxFileBytes < 1 || s.AnonymousRateLimit < 1) {
+		return fmt.Errorf("anonymous_max_file_bytes and anonymous_rate_limit must be positive when anonymous_uploads is set")
+	}
+	for _, ext := range s.AnonymousUploadTypes {
+		if !strings.HasPrefix(ext, ".") || ext != strings.ToLower(ext) {
+			return fmt.Errorf("anonymous_upload_types must be lowercase extensions like .txt, got %q", ext)
+		}
+	}
+	return nil
+}
+
+// AnonymousTypeAllowed reports whether a file called name may be uploaded
+// without credentials
+func (s *Settings) AnonymousTypeAllowed(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, allowed := range s.AnonymousUploadTypes {
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessorEnabled reports whether files may be run through the named processor
+func (s *Settings) ProcessorEnabled(name string) bool {
+	for _, disabled := range s.DisabledProcessors {
+		if disabled == name {
+			return false
+		}
+	}
+	return true
+}
+
+// Pipeline returns the processors configured for a file type, falling back to
+// the "*" pipeline, or nil to run the type's default processor
+func (s *Settings) Pipeline(fileType string) []string {
+	if stages, ok := s.Pipelines[fileType]; ok {
+		return stages
+	}
+	return s.Pipelines[processor.DefaultFileType]
+}
+
+// ValidatePipeline checks that a pipeline names at least one processor and
+// only registered ones
+func ValidatePipeline(stages []string) error {
+	if len(stages) == 0 {
+		return fmt.Errorf("a pipeline needs at least one processor")
+	}
+	for _, name := range stages {
+		if processor.Get(name) == nil {
+			return fmt.Errorf("unknown processor %q", name)
+		}
+	}
+	return nil
+}
+
+// StorageClass implements storage.ClassPolicy. A requested class must be in
+// AllowedStorageClasses; otherwise the user's own default applies, then
+// DefaultStorageClass. Defaults are set by the operator and aren't checked
+// against the allowed list.
+func (s *Settings) StorageClass(userID, requested string) (string, error) {
+	if requested == "" {
+		if class, ok := s.UserStorageClasses[userID]; ok && userID != "" {
+			return class, nil
+		}
+		return s.DefaultStorageClass, nil
+	}
+	if !storage.ValidClass(requested) {
+		return "", fmt.Errorf("%w: storage_class must be one of %s", storage.ErrClassNotAllowed, strings.Join(storage.Classes, ", "))
+	}
+	if len(s.AllowedStorageClasses) == 0 {
+		return requested, nil
+	}
+	for _, allowed := range s.AllowedStorageClasses {
+		if allowed == requested {
+			return requested, nil
+		}
+	}
+	return "", fmt.Errorf("%w: storage_class must be one of %s", storage.ErrClassNotAllowed, strings.Join(s.AllowedStorageClasses, ", "))
+}
+
+// Lock returns the Object Lock retention for a file userID uploads now
+func (s *Settings) Lock(userID string, now time.Time) storage.Lock {
+	retention := Retention{Mode: s.RetentionMode, Days: s.RetentionDays}
+	if r, ok := s.UserRetention[userID]; ok && userID != "" {
+		retention = r
+	}
+	if retention.Mode == "" {
+		return storage.Lock{}
+	}
+	return storage.Lock{Mode: retention.Mode, RetainUntil: now.AddDate(0, 0, retention.Days)}
+}
+
+// ExpiryDays returns how many days userID's files are kept before they
+// expire, or 0 if they never do
+func (s *Settings) ExpiryDays(userID string) int {
+	if days, ok := s.UserFileExpiryDays[userID]; ok && userID != "" {
+		return days
+	}
+	return s.FileExpiryDays
+}
+
+// AccessLogRate returns the fraction of requests to route that are logged
+func (s *Settings) AccessLogRate(route string) float64 {
+	if rate, ok := s.AccessLogSampling[route]; ok {
+		return rate
+	}
+	return 1
+}
+
+// EncryptionKey returns the KMS key that userID's uploads are encrypted
+// under, or "" when they are stored as sent
+func (s *Settings) EncryptionKey(userID string) string {
+	if keyID, ok := s.UserEncryptionKeys[userID]; ok && userID != "" {
+		return keyID
+	}
+	return s.EncryptionKeyID
+}
+
+// Load reads settings from the environment, then overlays SETTINGS_FILE if
+// set. Fields missing from the file keep their environment value.
+func Load() (Settings, error) {
+	s := Defaults()
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		s.LogLevel = strings.ToLower(v)
+	}
+	s.RateLimit = envInt("RATE_LIMIT_PER_MINUTE")
+	s.RateBurst = envInt("RATE_LIMIT_BURST")
+	s.StreamConcurrency = envInt("STREAM_CONCURRENCY")
+	s.StreamConcurrencyPerUser = envInt("STREAM_CONCURRENCY_PER_USER")
+	s.UploadBytesPerSecond = int64(envInt("UPLOAD_BYTES_PER_SECOND"))
+	s.DownloadBytesPerSecond = int64(envInt("DOWNLOAD_BYTES_PER_SECOND"))
+	if v := os.Getenv("DISABLED_PROCESSORS"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				s.DisabledProcessors = append(s.DisabledProcessors, name)
+			}
+		}
+	}
+	s.MaxFileBytes = int64(envInt("QUOTA_MAX_FILE_BYTES"))
+	s.MaxFilesPerUser = envInt("QUOTA_MAX_FILES_PER_USER")
+	s.MaxUserBytes = int64(envInt("QUOTA_MAX_USER_BYTES"))
+	s.AuditBodies = os.Getenv("AUDIT_BODIES") == "true"
+	if n := envInt("AUDIT_MAX_BYTES"); n > 0 {
+		s.AuditMaxBytes = n
+	}
+	if v := os.Getenv("DEFAULT_STORAGE_CLASS"); v != "" {
+		s.DefaultStorageClass = storage.NormalizeClass(v)
+	}
+	s.RetentionMode = strings.ToUpper(os.Getenv("RETENTION_MODE"))
+	s.RetentionDays = envInt("RETENTION_DAYS")
+	s.FileExpiryDays = envInt("FILE_EXPIRY_DAYS")
+	s.EncryptionKeyID = os.Getenv("ENCRYPTION_KMS_KEY_ID")
+	if v := os.Getenv("ACCESS_LOG_SAMPLING"); v != "" {
+		sampling, err := parseSampling(v)
+		if err != nil {
+			return s, err
+		}
+		s.AccessLogSampling = sampling
+	}
+	s.AnonymousUploads = os.Getenv("ANONYMOUS_UPLOADS") == "true"
+	if n := envInt("ANONYMOUS_MAX_FILE_BYTES"); n > 0 {
+		s.AnonymousMaxFileBytes = int64(n)
+	}
+	if n := envInt("ANONYMOUS_RATE_LIMIT_PER_MINUTE"); n > 0 {
+		s.AnonymousRateLimit = n
+	}
+	if v := os.Getenv("ANONYMOUS_UPLOAD_TYPES"); v != "" {
+		s.AnonymousUploadTypes = nil
+		for _, ext := range strings.Split(v, ",") {
+			if ext = strings.ToLower(strings.TrimSpace(ext)); ext != "" {
+				if !strings.HasPrefix(ext, ".") {
+					ext = "." + ext
+				}
+				s.AnonymousUploadTypes = append(s.AnonymousUploadTypes, ext)
+			}
+		}
+	}
+	s.ReprocessUnchanged = os.Getenv("REPROCESS_UNCHANGED") == "true"
+	if v := os.Getenv("ALLOWED_STORAGE_CLASSES"); v != "" {
+		for _, class := range strings.Split(v, ",") {
+			if class = storage.NormalizeClass(class); class != "" {
+				s.AllowedStorageClasses = append(s.AllowedStorageClasses, class)
+			}
+		}
+	}
+
+	if path := os.Getenv("SETTINGS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return s, fmt.Errorf("reading %s: %v", path, err)
+		}
+		if err := json.Unmarshal(data, &s); err != nil {
+			return s, fmt.Errorf("parsing %s: %v", path, err)
+		}
+	}
+
+	return s, s.Validate()
+}
+
+// parseSampling parses a comma-separated list of route=rate pairs, e.g.
+// "/health=0.01,/api/files=0.1"
+func parseSampling(v string) (map[string]float64, error) {
+	sampling := make(map[string]float64)
+	for _, pair := range strings.Split(v, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		route, rate, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid ACCESS_LOG_SAMPLING entry %q, want route=rate", pair)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCESS_LOG_SAMPLING rate for %s: %v", route, err)
+		}
+		sampling[strings.TrimSpace(route)] = f
+	}
+	return sampling, nil
+}
+
+// envInt reads a non-negative integer from key, ignoring invalid values
+func envInt(key string) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("Invalid %s %q, ignoring", key, v)
+		return 0
+	}
+	return n
+}
+
+// Reload loads the settings and swaps them in. Invalid settings are rejected
+// and the active snapshot is kept.
+func Reload() error {
+	s, err := Load()
+	if err != nil {
+		return err
+	}
+	return Set(s)
+}
+
+// Init loads the settings at startup and reloads them on SIGHUP until ctx is
+// done. Invalid settings at startup leave the defaults in place.
+func Init(ctx context.Context) {
+	if err := Reload(); err != nil {
+		log.Printf("Invalid settings, using defaults: %v", err)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if err := Reload(); err != nil {
+					log.Printf("Settings reload failed, keeping current settings: %v", err)
+					continue
+				}
+				log.Printf("Settings reloaded")
+			}
+		}
+	}()
+}
+
+// Debugf logs only when the log level is debug
+func Debugf(format string, args ...interface{}) {
+	if Current().LogLevel == LogLevelDebug {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,198 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/storage"
+)
+
+func withSettings(t *testing.T) {
+	t.Helper()
+	previous := *Current()
+	t.Cleanup(func() { current.Store(&previous) })
+}
+
+func TestLoadFileOverridesEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"rate_limit": 120, "disabled_processors": ["text"]}`), 0o644))
+	t.Setenv("SETTINGS_FILE", path)
+	t.Setenv("RATE_LIMIT_PER_MINUTE", "60")
+	t.Setenv("QUOTA_MAX_FILES_PER_USER", "10")
+
+	s, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 120, s.RateLimit)
+	assert.Equal(t, 10, s.MaxFilesPerUser)
+	assert.False(t, s.ProcessorEnabled("text"))
+	assert.Equal(t, LogLevelInfo, s.LogLevel)
+}
+
+func TestReloadKeepsSnapshotOnInvalidSettings(t *testing.T) {
+	withSettings(t)
+	path := filepath.Join(t.TempDir(), "settings.json")
+	t.Setenv("SETTINGS_FILE", path)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"log_level": "debug", "rate_limit": 30}`), 0o644))
+	assert.NoError(t, Reload())
+	before := Current()
+	assert.Equal(t, LogLevelDebug, before.LogLevel)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"log_level": "verbose"}`), 0o644))
+	assert.Error(t, Reload())
+	assert.Same(t, before, Current())
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"rate_limit": -1}`), 0o644))
+	assert.Error(t, Reload())
+	assert.Equal(t, 30, Current().RateLimit)
+}
+
+func TestStorageClass(t *testing.T) {
+	s := Defaults()
+	s.AllowedStorageClasses = []string{storage.ClassStandard, storage.ClassIntelligentTiering}
+	s.UserStorageClasses = map[string]string{"archiver": storage.ClassStandardIA}
+	assert.NoError(t, s.Validate())
+
+	class, err := s.StorageClass("alice", "")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.ClassStandard, class)
+
+	// A user's default applies even when clients may not request it
+	class, err = s.StorageClass("archiver", "")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.ClassStandardIA, class)
+
+	class, err = s.StorageClass("alice", storage.ClassIntelligentTiering)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.ClassIntelligentTiering, class)
+
+	_, err = s.StorageClass("alice", storage.ClassStandardIA)
+	assert.ErrorIs(t, err, storage.ErrClassNotAllowed)
+	_, err = s.StorageClass("alice", "GLACIER")
+	assert.ErrorIs(t, err, storage.ErrClassNotAllowed)
+
+	s.DefaultStorageClass = "GLACIER"
+	assert.Error(t, s.Validate())
+}
+
+func TestLoadStorageClassesFromEnvironment(t *testing.T) {
+	t.Setenv("DEFAULT_STORAGE_CLASS", "intelligent_tiering")
+	t.Setenv("ALLOWED_STORAGE_CLASSES", "standard, standard_ia")
+
+	s, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, storage.ClassIntelligentTiering, s.DefaultStorageClass)
+	assert.Equal(t, []string{storage.ClassStandard, storage.ClassStandardIA}, s.AllowedStorageClasses)
+}
+
+func TestLock(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := Defaults()
+	assert.False(t, s.Lock("alice", now).Enabled())
+
+	s.RetentionMode = storage.RetentionGovernance
+	s.RetentionDays = 30
+	s.UserRetention = map[string]Retention{"bank": {Mode: storage.RetentionCompliance, Days: 365}}
+	assert.NoError(t, s.Validate())
+
+	assert.Equal(t, storage.Lock{Mode: storage.RetentionGovernance, RetainUntil: now.AddDate(0, 0, 30)}, s.Lock("alice", now))
+	assert.Equal(t, storage.Lock{Mode: storage.RetentionCompliance, RetainUntil: now.AddDate(1, 0, 0)}, s.Lock("bank", now))
+
+	s.UserRetention["bank"] = Retention{Mode: "LEGAL_HOLD", Days: 1}
+	assert.Error(t, s.Validate())
+	s.UserRetention["bank"] = Retention{Mode: storage.RetentionCompliance}
+	assert.Error(t, s.Validate())
+}
+
+func TestExpiryDays(t *testing.T) {
+	s := Defaults()
+	assert.Equal(t, 0, s.ExpiryDays("alice"))
+
+	s.FileExpiryDays = 90
+	s.UserFileExpiryDays = map[string]int{"bank": 3650, "archive": 0}
+	assert.NoError(t, s.Validate())
+	assert.Equal(t, 90, s.ExpiryDays("alice"))
+	assert.Equal(t, 3650, s.ExpiryDays("bank"))
+	assert.Equal(t, 0, s.ExpiryDays("archive"))
+	assert.Equal(t, 90, s.ExpiryDays(""))
+
+	s.UserFileExpiryDays["bank"] = -1
+	assert.Error(t, s.Validate())
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	t.Setenv("ACCESS_LOG_SAMPLING", "/health=0.01, /api/files = 0.5")
+
+	s, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 0.01, s.AccessLogRate("/health"))
+	assert.Equal(t, 0.5, s.AccessLogRate("/api/files"))
+	assert.Equal(t, 1.0, s.AccessLogRate("/api/files/{id}"))
+
+	s.AccessLogSampling["/health"] = 2
+	assert.Error(t, s.Validate())
+
+	t.Setenv("ACCESS_LOG_SAMPLING", "/health")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+func TestEncryptionKey(t *testing.T) {
+	s := Defaults()
+	assert.Equal(t, "", s.EncryptionKey("alice"))
+
+	s.EncryptionKeyID = "alias/uploads"
+	s.UserEncryptionKeys = map[string]string{"bank": "alias/bank", "public": ""}
+	assert.Equal(t, "alias/uploads", s.EncryptionKey("alice"))
+	assert.Equal(t, "alias/bank", s.EncryptionKey("bank"))
+	assert.Equal(t, "", s.EncryptionKey("public"))
+	assert.Equal(t, "alias/uploads", s.EncryptionKey(""))
+}
+
+func TestPipeline(t *testing.T) {
+	s := Defaults()
+	assert.Nil(t, s.Pipeline(".txt"))
+
+	s.Pipelines = map[string][]string{".csv": {"text"}, "*": {"text", "text"}}
+	assert.NoError(t, s.Validate())
+	assert.Equal(t, []string{"text"}, s.Pipeline(".csv"))
+	assert.Equal(t, []string{"text", "text"}, s.Pipeline(".md"))
+
+	s.Pipelines[".pdf"] = []string{"ocr"}
+	assert.Error(t, s.Validate())
+	s.Pipelines[".pdf"] = nil
+	assert.Error(t, s.Validate())
+}
+
+func TestAnonymousUploadsFromEnvironment(t *testing.T) {
+	s, err := Load()
+	assert.NoError(t, err)
+	assert.False(t, s.AnonymousUploads)
+	assert.True(t, s.AnonymousTypeAllowed("notes.TXT"))
+	assert.False(t, s.AnonymousTypeAllowed("setup.exe"))
+
+	t.Setenv("ANONYMOUS_UPLOADS", "true")
+	t.Setenv("ANONYMOUS_UPLOAD_TYPES", "csv, .MD")
+	s, err = Load()
+	assert.NoError(t, err)
+	assert.True(t, s.AnonymousUploads)
+	assert.Equal(t, []string{".csv", ".md"}, s.AnonymousUploadTypes)
+	assert.False(t, s.AnonymousTypeAllowed("notes.txt"))
+
+	s.AnonymousRateLimit = 0
+	assert.Error(t, s.Validate())
+}
+
+func TestReprocessUnchangedFromEnvironment(t *testing.T) {
+	s, err := Load()
+	assert.NoError(t, err)
+	assert.False(t, s.ReprocessUnchanged)
+
+	t.Setenv("REPROCESS_UNCHANGED", "true")
+	s, err = Load()
+	assert.NoError(t, err)
+	assert.True(t, s.ReprocessUnchanged)
+}
@@ -21,6 +21,15 @@ aws --endpoint-url=http://localhost:4566 s3 mb s3://my-test-bucket
 echo "Creating SQS queue..."
 aws --endpoint-url=http://localhost:4566 sqs create-queue --queue-name my-queue
 
+# Create the results queue the worker reports finished processing on
+echo "Creating results SQS queue..."
+aws --endpoint-url=http://localhost:4566 sqs create-queue --queue-name my-results-queue
+
+# Create FIFO SQS queue (used when SQS_FIFO=true; the API publishes to it directly)
+echo "Creating FIFO SQS queue..."
+aws --endpoint-url=http://localhost:4566 sqs create-queue --queue-name my-queue.fifo \
+  --attributes FifoQueue=true,ContentBasedDeduplication=true
+
 # Create Lambda function (assuming the Lambda code is already built)
 echo "Creating Lambda function..."
 aws --endpoint-url=http://localhost:4566 lambda create-function \
@@ -38,7 +47,8 @@ aws --endpoint-url=http://localhost:4566 s3api put-bucket-notification-configura
     "QueueConfigurations": [
       {
         "QueueArn": "arn:aws:sqs:us-east-1:000000000000:my-queue",
-        "Events": ["s3:ObjectCreated:*"]
+        "Events": ["s3:ObjectCreated:*"],
+        "Filter": {"Key": {"FilterRules": [{"Name": "prefix", "Value": "files/"}]}}
       }
     ]
   }'
@@ -48,8 +58,15 @@ echo "Setting up SQS event source mapping for Lambda..."
 aws --endpoint-url=http://localhost:4566 lambda create-event-source-mapping \
   --function-name file-processor \
   --batch-size 1 \
+  --function-response-types ReportBatchItemFailures \
   --event-source-arn arn:aws:sqs:us-east-1:000000000000:my-queue
 
+aws --endpoint-url=http://localhost:4566 lambda create-event-source-mapping \
+  --function-name file-processor \
+  --batch-size 10 \
+  --function-response-types ReportBatchItemFailures \
+  --event-source-arn arn:aws:sqs:us-east-1:000000000000:my-queue.fifo
+
 # Create Cognito User Pool
 echo "Creating Cognito User Pool..."
 USER_POOL_ID=$(aws --endpoint-url=http://localhost:4566 cognito-idp create-user-pool \
@@ -0,0 +1,52 @@
+package signing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := Timestamp(now)
+	payload := []byte(`{"key":"inbox/a.csv"}`)
+	sig := Sign("new", ts, payload)
+
+	i, err := Verify(sig, ts, payload, []string{"new", "old"}, now, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, i)
+
+	// Senders still using the previous secret are accepted while it is kept
+	i, err = Verify(Sign("old", ts, payload), ts, payload, []string{"new", "old"}, now, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, i)
+
+	_, err = Verify(sig, ts, []byte(`{"key":"inbox/b.csv"}`), []string{"new"}, now, 5*time.Minute)
+	assert.ErrorIs(t, err, ErrMismatch)
+	_, err = Verify(sig, Timestamp(now.Add(time.Second)), payload, []string{"new"}, now, 5*time.Minute)
+	assert.ErrorIs(t, err, ErrMismatch, "the timestamp is signed too")
+	_, err = Verify(sig, ts, payload, []string{"", "other"}, now, 5*time.Minute)
+	assert.ErrorIs(t, err, ErrMismatch)
+}
+
+func TestVerifyRejectsStaleAndMalformed(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	payload := []byte("body")
+	old := Timestamp(now.Add(-6 * time.Minute))
+	_, err := Verify(Sign("s", old, payload), old, payload, []string{"s"}, now, 5*time.Minute)
+	assert.ErrorIs(t, err, ErrStale)
+	future := Timestamp(now.Add(6 * time.Minute))
+	_, err = Verify(Sign("s", future, payload), future, payload, []string{"s"}, now, 5*time.Minute)
+	assert.ErrorIs(t, err, ErrStale)
+
+	ts := Timestamp(now)
+	_, err = Verify("", ts, payload, []string{"s"}, now, time.Minute)
+	assert.ErrorIs(t, err, ErrMissing)
+	_, err = Verify("md5=abc", ts, payload, []string{"s"}, now, time.Minute)
+	assert.ErrorIs(t, err, ErrMalformed)
+	_, err = Verify("sha256=zz", ts, payload, []string{"s"}, now, time.Minute)
+	assert.ErrorIs(t, err, ErrMalformed)
+	_, err = Verify(Sign("s", ts, payload), "yesterday", payload, []string{"s"}, now, time.Minute)
+	assert.ErrorIs(t, err, ErrMalformed)
+}
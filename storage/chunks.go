@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Chunk is one part of an object uploaded in parts, with the SHA-256 of its
+// bytes as stored. Numbers start at 1, like S3 part numbers.
+type Chunk struct {
+	Number int
+	Offset int64
+	Size   int64
+	SHA256 string
+}
+
+// ChunkSize returns the size of the chunks an object of size bytes is split
+// into: the transfer part size, grown like the uploader grows it for objects
+// that would otherwise need more parts than S3 allows
+func (s *Store) ChunkSize(size int64) int64 {
+	partSize := s.transfer.PartSize
+	if size/partSize >= int64(manager.MaxUploadParts) {
+		partSize = size/int64(manager.MaxUploadParts) + 1
+	}
+	return partSize
+}
+
+// ChecksumChunks hashes body in chunks of chunkSize bytes and seeks it back to
+// the start
+func ChecksumChunks(body io.ReadSeeker, chunkSize int64) ([]Chunk, error) {
+	var chunks []Chunk
+	var offset int64
+	for {
+		h := sha256.New()
+		n, err := io.CopyN(h, body, chunkSize)
+		if n > 0 {
+			chunks = append(chunks, Chunk{Number: len(chunks) + 1, Offset: offset, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+			offset += n
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// UploadWithChecksums is Upload also returning the checksums of the object's
+// chunks when it is uploaded in parts. Smaller objects, and bodies that can't
+// be read twice, get no checksums.
+func (s *Store) UploadWithChecksums(ctx context.Context, params *s3.PutObjectInput) ([]Chunk, string, error) {
+	var chunks []Chunk
+	if body, ok := params.Body.(io.ReadSeeker); ok && s.parallel(params.ContentLength) {
+		var err error
+		chunks, err = ChecksumChunks(body, s.ChunkSize(params.ContentLength))
+		if err != nil {
+			return nil, "", fmt.Errorf("error computing chunk checksums: %v", err)
+		}
+	}
+	etag, err := s.Upload(ctx, params)
+	if err != nil {
+		return nil, "", err
+	}
+	return chunks, etag, nil
+}
+
+// VerifyChunks reads each chunk of an object in the primary bucket back with
+// a ranged request and returns the numbers of those whose content no longer
+// matches its checksum
+func (s *Store) VerifyChunks(ctx context.Context, key string, chunks []Chunk) ([]int, error) {
+	corrupt := []int{}
+	for _, c := range chunks {
+		out, err := s.primary.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+			Range:  aws.String(chunkRange(c)),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error reading chunk %d: %v", c.Number, err)
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, out.Body)
+		out.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading chunk %d: %v", c.Number, err)
+		}
+		if n != c.Size || hex.EncodeToString(h.Sum(nil)) != c.SHA256 {
+			corrupt = append(corrupt, c.Number)
+		}
+	}
+	return corrupt, nil
+}
+
+// ReplaceChunk rewrites the chunk numbered number of an object in the primary
+// bucket with data, which the caller has checked against the chunk's checksum.
+// The object is written anew as a multipart upload whose other parts are
+// copied from the current object within S3, so only the replaced chunk is
+// sent. Its metadata, storage class and retention are kept, and dual writes
+// copy the result to the replica.
+func (s *Store) ReplaceChunk(ctx context.Context, key string, chunks []Chunk, number int, data []byte) error {
+	head, err := s.primary.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		return err
+	}
+	create, err := s.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:                    aws.String(s.bucket),
+		Key:                       aws.String(key),
+		ContentType:               head.ContentType,
+		Metadata:                  head.Metadata,
+		StorageClass:              types.StorageClass(head.StorageClass),
+		ObjectLockMode:            head.ObjectLockMode,
+		ObjectLockRetainUntilDate: head.ObjectLockRetainUntilDate,
+	})
+	if err != nil {
+		return err
+	}
+
+	parts := make([]types.CompletedPart, 0, len(chunks))
+	for _, c := range chunks {
+		var etag *string
+		if c.Number == number {
+			out, err := s.Client.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:        aws.String(s.bucket),
+				Key:           aws.String(key),
+				UploadId:      create.UploadId,
+				PartNumber:    int32(c.Number),
+				Body:          bytes.NewReader(data),
+				ContentLength: int64(len(data)),
+			})
+			if err != nil {
+				s.abortUpload(key, create.UploadId)
+				return fmt.Errorf("error uploading chunk %d: %v", c.Number, err)
+			}
+			etag = out.ETag
+		} else {
+			out, err := s.Client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+				Bucket:          aws.String(s.bucket),
+				Key:             aws.String(key),
+				UploadId:        create.UploadId,
+				PartNumber:      int32(c.Number),
+				CopySource:      aws.String(copySource(s.bucket, key)),
+				CopySourceRange: aws.String(chunkRange(c)),
+			})
+			if err != nil {
+				s.abortUpload(key, create.UploadId)
+				return fmt.Errorf("error copying chunk %d: %v", c.Number, err)
+			}
+			etag = out.CopyPartResult.ETag
+		}
+		parts = append(parts, types.CompletedPart{ETag: etag, PartNumber: int32(c.Number)})
+	}
+
+	_, err = s.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        create.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		s.abortUpload(key, create.UploadId)
+		return err
+	}
+	s.copyToReplica(ctx, &s3.PutObjectInput{
+		Bucket:                    aws.String(s.bucket),
+		Key:                       aws.String(key),
+		StorageClass:              types.StorageClass(head.StorageClass),
+		ObjectLockMode:            head.ObjectLockMode,
+		ObjectLockRetainUntilDate: head.ObjectLockRetainUntilDate,
+	})
+	return nil
+}
+
+// abortUpload cancels a multipart upload so its parts aren't kept and billed.
+// It runs on its own context, as the request's may be what failed.
+func (s *Store) abortUpload(key string, uploadID *string) {
+	s.Client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+}
+
+// chunkRange is the HTTP Range header value covering a chunk
+func chunkRange(c Chunk) string {
+	return fmt.Sprintf("bytes=%d-%d", c.Offset, c.Offset+c.Size-1)
+}
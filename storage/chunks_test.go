@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestChecksumChunks(t *testing.T) {
+	body := strings.NewReader("abcdefghij")
+	chunks, err := ChecksumChunks(body, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []Chunk{
+		{Number: 1, Offset: 0, Size: 4, SHA256: sha256Hex("abcd")},
+		{Number: 2, Offset: 4, Size: 4, SHA256: sha256Hex("efgh")},
+		{Number: 3, Offset: 8, Size: 2, SHA256: sha256Hex("ij")},
+	}, chunks)
+
+	// The body is left ready to upload
+	rest, _ := io.ReadAll(body)
+	assert.Equal(t, "abcdefghij", string(rest))
+}
+
+func TestChunkSizeGrowsForHugeObjects(t *testing.T) {
+	store := &Store{transfer: awsconfig.Transfer{PartSize: 8 << 20}}
+	assert.Equal(t, int64(8<<20), store.ChunkSize(1<<30))
+
+	size := int64(100 << 30)
+	chunkSize := store.ChunkSize(size)
+	assert.Greater(t, chunkSize, int64(8<<20))
+	assert.LessOrEqual(t, (size+chunkSize-1)/chunkSize, int64(10000))
+}
+
+func TestVerifyChunks(t *testing.T) {
+	primary := &fakeS3{body: "abcdXfghij"}
+	store := newTestStore(primary, &fakeS3{}, false)
+	chunks, err := ChecksumChunks(strings.NewReader("abcdefghij"), 4)
+	assert.NoError(t, err)
+
+	corrupt, err := store.VerifyChunks(context.Background(), "a.bin", chunks)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2}, corrupt)
+	assert.Equal(t, []string{"get main/a.bin", "get main/a.bin", "get main/a.bin"}, primary.calls)
+
+	// Only the chunks asked for are read
+	primary.calls = nil
+	corrupt, err = store.VerifyChunks(context.Background(), "a.bin", chunks[2:])
+	assert.NoError(t, err)
+	assert.Empty(t, corrupt)
+	assert.Len(t, primary.calls, 1)
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+)
+
+// Storage classes that uploads may use
+const (
+	ClassStandard           = "STANDARD"
+	ClassStandardIA         = "STANDARD_IA"
+	ClassIntelligentTiering = "INTELLIGENT_TIERING"
+)
+
+// Classes lists the supported storage classes
+var Classes = []string{ClassStandard, ClassStandardIA, ClassIntelligentTiering}
+
+// ValidClass reports whether class is a supported storage class
+func ValidClass(class string) bool {
+	for _, c := range Classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassPolicy picks the storage class of a new object. requested is the
+// class the client asked for, or "" to use the owner's default; an error
+// means the request can't be honored and should be rejected.
+type ClassPolicy interface {
+	StorageClass(userID, requested string) (string, error)
+}
+
+// ClassPolicyFunc adapts a function to ClassPolicy
+type ClassPolicyFunc func(userID, requested string) (string, error)
+
+// StorageClass calls f
+func (f ClassPolicyFunc) StorageClass(userID, requested string) (string, error) {
+	return f(userID, requested)
+}
+
+// NormalizeClass upper-cases a client supplied class name
+func NormalizeClass(class string) string {
+	return strings.ToUpper(strings.TrimSpace(class))
+}
+
+// ErrClassNotAllowed is wrapped by policies that refuse a requested class
+var ErrClassNotAllowed = errors.New("storage class not allowed")
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockApply(t *testing.T) {
+	var in s3.PutObjectInput
+	Lock{}.Apply(&in)
+	assert.Empty(t, in.ObjectLockMode)
+	assert.Nil(t, in.ObjectLockRetainUntilDate)
+
+	until := time.Date(2030, 1, 1, 0, 0, 0, 0, time.FixedZone("CET", 3600))
+	Lock{Mode: RetentionCompliance, RetainUntil: until}.Apply(&in)
+	assert.Equal(t, types.ObjectLockModeCompliance, in.ObjectLockMode)
+	assert.True(t, until.Equal(*in.ObjectLockRetainUntilDate))
+	assert.Equal(t, time.UTC, in.ObjectLockRetainUntilDate.Location())
+	assert.Equal(t, types.ChecksumAlgorithmSha256, in.ChecksumAlgorithm)
+}
@@ -0,0 +1,35 @@
+package storage
+
+import "strings"
+
+// FilePrefix is where uploaded files are stored, under their file ID
+const FilePrefix = "files/"
+
+// ExportPrefix is where export archives are stored, under their user's ID
+const ExportPrefix = "exports/"
+
+// reservedPrefixes hold objects the API wrote for a particular user or file,
+// which must never be registered as a file of someone else
+var reservedPrefixes = []string{FilePrefix, ExportPrefix, ResultPrefix, RedactedPrefix}
+
+// ReservedKey reports whether key is under a reserved prefix
+func ReservedKey(key string) bool {
+	for _, p := range reservedPrefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// OverlapsReserved reports whether listing prefix could return objects under
+// a reserved prefix, either because it is inside one or because one is inside
+// it, as with the empty prefix
+func OverlapsReserved(prefix string) bool {
+	for _, p := range reservedPrefixes {
+		if strings.HasPrefix(prefix, p) || strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
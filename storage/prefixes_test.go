@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReservedPrefixes(t *testing.T) {
+	assert.True(t, ReservedKey("files/f1/a.txt"))
+	assert.True(t, ReservedKey("exports/u1/e1.zip"))
+	assert.True(t, ReservedKey(ResultKey("f1", "r1")))
+	assert.True(t, ReservedKey(RedactedKey("f1", "r1")))
+	assert.False(t, ReservedKey("incoming/a.txt"))
+	assert.False(t, ReservedKey("filesystem/a.txt"))
+
+	assert.True(t, OverlapsReserved(""))
+	assert.True(t, OverlapsReserved("file"))
+	assert.True(t, OverlapsReserved("exports/u1/"))
+	assert.False(t, OverlapsReserved("incoming/"))
+}
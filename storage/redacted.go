@@ -0,0 +1,10 @@
+package storage
+
+// RedactedPrefix is where the copies of files with their personal data
+// redacted are stored
+const RedactedPrefix = "redacted/"
+
+// RedactedKey returns the key of a redacted copy of a file, unique to id
+func RedactedKey(fileID, id string) string {
+	return RedactedPrefix + fileID + "/" + id
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ResultPrefix is where processing results too large to keep in the database
+// are stored
+const ResultPrefix = "results/"
+
+// ResultKey returns the key of an offloaded result of a file, unique to id
+func ResultKey(fileID, id string) string {
+	return FileResultPrefix(fileID) + id + ".json"
+}
+
+// FileResultPrefix returns the prefix of the offloaded results of a file
+func FileResultPrefix(fileID string) string {
+	return ResultPrefix + fileID + "/"
+}
+
+// PutResult stores an offloaded result under key
+func (s *Store) PutResult(ctx context.Context, key, result string) error {
+	_, err := s.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          strings.NewReader(result),
+		ContentLength: int64(len(result)),
+		ContentType:   aws.String("application/json"),
+	})
+	return err
+}
+
+// GetResult reads the offloaded result stored under key, from the replica
+// when the primary bucket can't be read
+func (s *Store) GetResult(ctx context.Context, key string) (string, error) {
+	out, err := s.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer out.Body.Close()
+	body, err := io.ReadAll(out.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading result %s: %v", key, err)
+	}
+	return string(body), nil
+}
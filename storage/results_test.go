@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultKey(t *testing.T) {
+	assert.Equal(t, "results/f1/r1.json", ResultKey("f1", "r1"))
+}
+
+func TestPutResult(t *testing.T) {
+	primary := &fakeS3{}
+	store := newTestStore(primary, nil, false)
+
+	require.NoError(t, store.PutResult(context.Background(), "results/f1/r1.json", `{"lines":3}`))
+	assert.Equal(t, []string{"put main/results/f1/r1.json"}, primary.calls)
+}
+
+func TestGetResultFallsBackToReplica(t *testing.T) {
+	primary := &fakeS3{err: errUnavailable}
+	replica := &fakeS3{body: `{"lines":3}`}
+	store := newTestStore(primary, replica, false)
+
+	result, err := store.GetResult(context.Background(), "results/f1/r1.json")
+	require.NoError(t, err)
+	assert.Equal(t, `{"lines":3}`, result)
+	assert.Equal(t, []string{"get main-dr/results/f1/r1.json"}, replica.calls)
+}
@@ -0,0 +1,206 @@
+// Package storage wraps the S3 client with an optional replica bucket in a
+// second region, for deployments with disaster recovery requirements. Reads
+// from the primary bucket fall back to the replica while the primary region
+// is down, writes can be copied to the replica as well when S3 replication
+// isn't set up, and deletes apply to both.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+)
+
+// healthTimeout bounds each region's health check
+const healthTimeout = 3 * time.Second
+
+// objectAPI is the part of the S3 API that Store adds failover to
+type objectAPI interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+}
+
+// Store is an S3 client for the primary bucket. Calls other than the ones
+// overridden here go to the primary region only.
+type Store struct {
+	*s3.Client
+	primary objectAPI
+	bucket  string
+	region  string
+
+	replica       objectAPI
+	replicaBucket string
+	replicaRegion string
+	dualWrite     bool
+
+	transfer awsconfig.Transfer
+}
+
+// New returns a Store for bucket. The replica is configured from
+// S3_REPLICA_BUCKET and S3_REPLICA_REGION (default the primary region), and
+// S3_DUAL_WRITE=true copies every write to it. Large object transfers are
+// tuned with awsconfig.LoadTransfer.
+func New(cfg aws.Config, bucket string) *Store {
+	client := s3.NewFromConfig(cfg)
+	s := &Store{
+		Client:   client,
+		primary:  client,
+		bucket:   bucket,
+		region:   cfg.Region,
+		transfer: awsconfig.LoadTransfer(),
+	}
+
+	replicaBucket := os.Getenv("S3_REPLICA_BUCKET")
+	if replicaBucket == "" {
+		return s
+	}
+	replicaRegion := os.Getenv("S3_REPLICA_REGION")
+	if replicaRegion == "" {
+		replicaRegion = cfg.Region
+	}
+	s.dualWrite, _ = strconv.ParseBool(os.Getenv("S3_DUAL_WRITE"))
+	s.replica = s3.NewFromConfig(cfg, func(o *s3.Options) { o.Region = replicaRegion })
+	s.replicaBucket = replicaBucket
+	s.replicaRegion = replicaRegion
+	log.Printf("S3 replica: bucket=%s, region=%s, dual write=%t", replicaBucket, replicaRegion, s.dualWrite)
+	return s
+}
+
+// Bucket returns the primary bucket name
+func (s *Store) Bucket() string {
+	return s.bucket
+}
+
+// fallback reports whether a failed call on the primary bucket should be
+// retried on the replica: only when the primary region looks down, with a
+// server error or no response at all. Errors about the object itself, such
+// as it not existing or access being denied, are the replica's answer too.
+func (s *Store) fallback(ctx context.Context, bucket *string, err error) bool {
+	if err == nil || s.replica == nil || aws.ToString(bucket) != s.bucket || ctx.Err() != nil {
+		return false
+	}
+	var resp interface{ HTTPStatusCode() int }
+	if errors.As(err, &resp) {
+		return resp.HTTPStatusCode() >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// GetObject reads an object, falling back to the replica when the primary
+// region is down
+func (s *Store) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	out, err := s.primary.GetObject(ctx, params, optFns...)
+	if !s.fallback(ctx, params.Bucket, err) {
+		return out, err
+	}
+	log.Printf("Reading %s from replica in %s after primary error: %v", aws.ToString(params.Key), s.replicaRegion, err)
+	input := *params
+	input.Bucket = aws.String(s.replicaBucket)
+	out, replicaErr := s.replica.GetObject(ctx, &input, optFns...)
+	if replicaErr != nil {
+		return nil, errors.Join(err, fmt.Errorf("replica: %w", replicaErr))
+	}
+	return out, nil
+}
+
+// HeadObject reads an object's metadata, falling back to the replica when
+// the primary region is down
+func (s *Store) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	out, err := s.primary.HeadObject(ctx, params, optFns...)
+	if !s.fallback(ctx, params.Bucket, err) {
+		return out, err
+	}
+	input := *params
+	input.Bucket = aws.String(s.replicaBucket)
+	out, replicaErr := s.replica.HeadObject(ctx, &input, optFns...)
+	if replicaErr != nil {
+		return nil, errors.Join(err, fmt.Errorf("replica: %w", replicaErr))
+	}
+	return out, nil
+}
+
+// PutObject writes an object to the primary bucket. With dual writes on it
+// is then copied to the replica.
+func (s *Store) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	out, err := s.primary.PutObject(ctx, params, optFns...)
+	if err != nil {
+		return out, err
+	}
+	s.copyToReplica(ctx, params)
+	return out, nil
+}
+
+// DeleteObject deletes an object from the primary bucket and, when a
+// replica is configured, from the replica too, so deleted content doesn't
+// live on in the other region. The replica's error is returned once the
+// primary deletion has succeeded. Deleting a specific version only applies
+// to the primary, since version IDs differ between buckets.
+func (s *Store) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	out, err := s.primary.DeleteObject(ctx, params, optFns...)
+	if err != nil || s.replica == nil || aws.ToString(params.Bucket) != s.bucket || params.VersionId != nil {
+		return out, err
+	}
+	input := *params
+	input.Bucket = aws.String(s.replicaBucket)
+	if _, err := s.replica.DeleteObject(ctx, &input, optFns...); err != nil {
+		return out, fmt.Errorf("replica: %w", err)
+	}
+	return out, nil
+}
+
+// copySource formats a CopyObject source, URL-encoding each key segment
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
+
+// RegionStatus is the health of one bucket
+type RegionStatus struct {
+	Role   string `json:"role"`
+	Region string `json:"region"`
+	Bucket string `json:"bucket"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Health checks that the primary bucket, and the replica if configured, can
+// be reached
+func (s *Store) Health(ctx context.Context) []RegionStatus {
+	statuses := []RegionStatus{checkBucket(ctx, s.primary, "primary", s.region, s.bucket)}
+	if s.replica != nil {
+		statuses = append(statuses, checkBucket(ctx, s.replica, "replica", s.replicaRegion, s.replicaBucket))
+	}
+	return statuses
+}
+
+func checkBucket(ctx context.Context, client objectAPI, role, region, bucket string) RegionStatus {
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	status := RegionStatus{Role: role, Region: region, Bucket: bucket, OK: true}
+	_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		status.OK = false
+		status.Error = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/awsconfig"
+)
+
+// fakeS3 records the calls made to one bucket and fails them all with err.
+// Its one object has content body and ETag etag.
+type fakeS3 struct {
+	mu    sync.Mutex
+	calls []string
+	err   error
+	body  string
+	etag  string
+}
+
+func (f *fakeS3) call(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.call("get " + aws.ToString(params.Bucket) + "/" + aws.ToString(params.Key))
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.mu.Lock()
+	body, etag := f.body, f.etag
+	f.mu.Unlock()
+	if params.IfMatch != nil && *params.IfMatch != etag {
+		return nil, &statusError{http.StatusPreconditionFailed, "precondition failed"}
+	}
+	out := &s3.GetObjectOutput{ETag: aws.String(etag)}
+	if r := aws.ToString(params.Range); r != "" {
+		var start, end int
+		fmt.Sscanf(r, "bytes=%d-%d", &start, &end)
+		if end >= len(body) {
+			end = len(body) - 1
+		}
+		out.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+		body = body[start : end+1]
+	}
+	out.Body = io.NopCloser(strings.NewReader(body))
+	out.ContentLength = int64(len(body))
+	return out, nil
+}
+
+func (f *fakeS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	f.call("head " + aws.ToString(params.Bucket) + "/" + aws.ToString(params.Key))
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &s3.HeadObjectOutput{ContentLength: int64(len(f.body)), ETag: aws.String(f.etag)}, nil
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.call("put " + aws.ToString(params.Bucket) + "/" + aws.ToString(params.Key))
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	f.call("copy " + aws.ToString(params.CopySource) + " to " + aws.ToString(params.Bucket) + "/" + aws.ToString(params.Key))
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.call("delete " + aws.ToString(params.Bucket) + "/" + aws.ToString(params.Key))
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	f.call("head bucket " + aws.ToString(params.Bucket))
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func newTestStore(primary, replica *fakeS3, dualWrite bool) *Store {
+	return &Store{
+		primary:       primary,
+		bucket:        "main",
+		region:        "us-east-1",
+		replica:       replica,
+		replicaBucket: "main-dr",
+		replicaRegion: "us-west-2",
+		dualWrite:     dualWrite,
+	}
+}
+
+// statusError is an error response from S3, like the SDK's ResponseError
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string       { return e.msg }
+func (e *statusError) HTTPStatusCode() int { return e.code }
+
+var errUnavailable error = &statusError{http.StatusServiceUnavailable, "service unavailable"}
+
+func TestGetObjectFallsBackToReplica(t *testing.T) {
+	primary := &fakeS3{err: errUnavailable}
+	replica := &fakeS3{body: "from replica"}
+	store := newTestStore(primary, replica, false)
+
+	out, err := store.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String("main"),
+		Key:    aws.String("files/1/a.txt"),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	body, _ := io.ReadAll(out.Body)
+	assert.Equal(t, "from replica", string(body))
+	assert.Equal(t, []string{"get main-dr/files/1/a.txt"}, replica.calls)
+}
+
+func TestGetObjectFallsBackOnNetworkError(t *testing.T) {
+	replica := &fakeS3{body: "from replica"}
+	store := newTestStore(&fakeS3{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, replica, false)
+
+	_, err := store.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String("main"),
+		Key:    aws.String("a.txt"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"get main-dr/a.txt"}, replica.calls)
+}
+
+func TestGetObjectClientErrorDoesNotFallBack(t *testing.T) {
+	for _, code := range []int{http.StatusNotFound, http.StatusForbidden, http.StatusPreconditionFailed} {
+		replica := &fakeS3{}
+		primaryErr := &statusError{code, http.StatusText(code)}
+		store := newTestStore(&fakeS3{err: primaryErr}, replica, false)
+
+		_, err := store.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String("main"),
+			Key:    aws.String("a.txt"),
+		})
+		assert.ErrorIs(t, err, primaryErr)
+		assert.Empty(t, replica.calls, "status %d", code)
+	}
+}
+
+func TestGetObjectBothRegionsFail(t *testing.T) {
+	store := newTestStore(&fakeS3{err: errUnavailable}, &fakeS3{err: errors.New("access denied")}, false)
+
+	_, err := store.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String("main"),
+		Key:    aws.String("a.txt"),
+	})
+	assert.ErrorIs(t, err, errUnavailable)
+	assert.ErrorContains(t, err, "replica: access denied")
+}
+
+func TestGetObjectOtherBucketDoesNotFallBack(t *testing.T) {
+	replica := &fakeS3{}
+	store := newTestStore(&fakeS3{err: errUnavailable}, replica, false)
+
+	_, err := store.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String("inbound-email"),
+		Key:    aws.String("msg"),
+	})
+	assert.ErrorIs(t, err, errUnavailable)
+	assert.Empty(t, replica.calls)
+}
+
+func TestHeadObjectFallsBackToReplica(t *testing.T) {
+	replica := &fakeS3{}
+	store := newTestStore(&fakeS3{err: errUnavailable}, replica, false)
+
+	_, err := store.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("main"),
+		Key:    aws.String("a.txt"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"head main-dr/a.txt"}, replica.calls)
+}
+
+func TestPutObjectDualWrite(t *testing.T) {
+	primary, replica := &fakeS3{}, &fakeS3{}
+	store := newTestStore(primary, replica, true)
+
+	_, err := store.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("main"),
+		Key:    aws.String("files/1/my report.txt"),
+		Body:   strings.NewReader("hello"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"put main/files/1/my report.txt"}, primary.calls)
+	assert.Equal(t, []string{"copy main/files/1/my%20report.txt to main-dr/files/1/my report.txt"}, replica.calls)
+}
+
+func TestPutObjectWithoutDualWrite(t *testing.T) {
+	replica := &fakeS3{}
+	store := newTestStore(&fakeS3{}, replica, false)
+
+	_, err := store.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("main"),
+		Key:    aws.String("a.txt"),
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, replica.calls)
+}
+
+func TestPutObjectReplicaFailureIsNotReturned(t *testing.T) {
+	store := newTestStore(&fakeS3{}, &fakeS3{err: errUnavailable}, true)
+
+	_, err := store.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("main"),
+		Key:    aws.String("a.txt"),
+	})
+	assert.NoError(t, err)
+}
+
+func TestDeleteObjectDeletesFromReplica(t *testing.T) {
+	primary, replica := &fakeS3{}, &fakeS3{}
+	store := newTestStore(primary, replica, false)
+
+	_, err := store.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String("main"),
+		Key:    aws.String("files/1/a.txt"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"delete main/files/1/a.txt"}, primary.calls)
+	assert.Equal(t, []string{"delete main-dr/files/1/a.txt"}, replica.calls)
+
+	replica.err = errUnavailable
+	_, err = store.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String("main"),
+		Key:    aws.String("files/1/a.txt"),
+	})
+	assert.ErrorContains(t, err, "replica: service unavailable")
+}
+
+func TestDownloadInParts(t *testing.T) {
+	primary := &fakeS3{body: "abcdefghijklmnopqrstuvwxyz", etag: "v1"}
+	store := newTestStore(primary, &fakeS3{}, false)
+	store.transfer = awsconfig.Transfer{PartSize: 4, Concurrency: 3, Threshold: 8}
+
+	// Small objects are streamed with a single request
+	out, err := store.Download(context.Background(), &s3.GetObjectInput{Bucket: aws.String("main"), Key: aws.String("a.txt")}, 5)
+	assert.NoError(t, err)
+	out.Body.Close()
+	assert.Equal(t, []string{"get main/a.txt"}, primary.calls)
+
+	// Without a size the object is looked up, then read in ranges
+	primary.calls = nil
+	out, err = store.Download(context.Background(), &s3.GetObjectInput{Bucket: aws.String("main"), Key: aws.String("a.txt")}, -1)
+	assert.NoError(t, err)
+	content, err := io.ReadAll(out.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, out.Body.Close())
+	assert.Equal(t, primary.body, string(content))
+	assert.Equal(t, int64(26), out.ContentLength)
+	assert.Equal(t, "head main/a.txt", primary.calls[0])
+	assert.Len(t, primary.calls, 8)
+}
+
+func TestDownloadPinsETag(t *testing.T) {
+	primary := &fakeS3{body: strings.Repeat("abcd", 10), etag: "v1"}
+	store := newTestStore(primary, &fakeS3{}, false)
+	store.transfer = awsconfig.Transfer{PartSize: 4, Concurrency: 2, Threshold: 8}
+
+	out, err := store.Download(context.Background(), &s3.GetObjectInput{Bucket: aws.String("main"), Key: aws.String("a.txt")}, 40)
+	assert.NoError(t, err)
+	defer out.Body.Close()
+
+	// Nothing has been read yet, so later parts are read after the object
+	// is replaced
+	primary.mu.Lock()
+	primary.body, primary.etag = strings.Repeat("wxyz", 10), "v2"
+	primary.mu.Unlock()
+	content, err := io.ReadAll(out.Body)
+	assert.ErrorContains(t, err, "precondition failed")
+	assert.NotContains(t, string(content), "w")
+}
+
+func TestDownloadFallsBackForWholeObject(t *testing.T) {
+	primary := &fakeS3{err: errUnavailable}
+	replica := &fakeS3{body: "abcdefghijklmnopqrstuvwxyz", etag: "v1"}
+	store := newTestStore(primary, replica, false)
+	store.transfer = awsconfig.Transfer{PartSize: 4, Concurrency: 3, Threshold: 8}
+
+	out, err := store.Download(context.Background(), &s3.GetObjectInput{Bucket: aws.String("main"), Key: aws.String("a.txt")}, 26)
+	assert.NoError(t, err)
+	content, err := io.ReadAll(out.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, out.Body.Close())
+	assert.Equal(t, replica.body, string(content))
+	assert.Equal(t, []string{"head main/a.txt"}, primary.calls, "parts aren't tried on the primary")
+	assert.Equal(t, "head main-dr/a.txt", replica.calls[0])
+	assert.Len(t, replica.calls, 8)
+}
+
+func TestHealth(t *testing.T) {
+	store := newTestStore(&fakeS3{}, &fakeS3{err: errUnavailable}, false)
+
+	assert.Equal(t, []RegionStatus{
+		{Role: "primary", Region: "us-east-1", Bucket: "main", OK: true},
+		{Role: "replica", Region: "us-west-2", Bucket: "main-dr", OK: false, Error: "service unavailable"},
+	}, store.Health(context.Background()))
+}
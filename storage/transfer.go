@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Upload writes an object like PutObject, uploading it in parallel parts when
+// it is at least the multipart threshold. Parts are read from the body with
+// ReadAt when it supports it, so files and in-memory readers aren't copied.
+// Dual writes copy the finished object to the replica as with PutObject. It
+// returns the ETag of the written object.
+func (s *Store) Upload(ctx context.Context, params *s3.PutObjectInput) (string, error) {
+	if !s.parallel(params.ContentLength) {
+		out, err := s.PutObject(ctx, params)
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(out.ETag), nil
+	}
+	uploader := manager.NewUploader(s.Client, func(u *manager.Uploader) {
+		u.PartSize = s.transfer.PartSize
+		u.Concurrency = s.transfer.Concurrency
+	})
+	out, err := uploader.Upload(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	s.copyToReplica(ctx, params)
+	return aws.ToString(out.ETag), nil
+}
+
+// Download reads an object like GetObject. Objects of at least the multipart
+// threshold are fetched with parallel ranged reads, streamed to the returned
+// Body in order with at most Concurrency parts held in memory. size is the
+// expected size, such as the one recorded for the file; when it is negative
+// the object's size is looked up first. Every part is read from the bucket
+// the object was looked up in, the replica when the primary region is down,
+// and pinned to the ETag found there, so a part of an object replaced during
+// the download fails the read instead of mixing two versions. The caller
+// must close Body to stop the reads.
+func (s *Store) Download(ctx context.Context, params *s3.GetObjectInput, size int64) (*s3.GetObjectOutput, error) {
+	if size >= 0 && !s.parallel(size) {
+		return s.GetObject(ctx, params)
+	}
+	api, input := s.primary, *params
+	head, err := api.HeadObject(ctx, &s3.HeadObjectInput{Bucket: input.Bucket, Key: input.Key, VersionId: input.VersionId})
+	if s.fallback(ctx, params.Bucket, err) {
+		log.Printf("Reading %s from replica in %s after primary error: %v", aws.ToString(params.Key), s.replicaRegion, err)
+		api, input.Bucket = s.replica, aws.String(s.replicaBucket)
+		var replicaErr error
+		head, replicaErr = api.HeadObject(ctx, &s3.HeadObjectInput{Bucket: input.Bucket, Key: input.Key, VersionId: input.VersionId})
+		if replicaErr != nil {
+			return nil, errors.Join(err, fmt.Errorf("replica: %w", replicaErr))
+		}
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	input.IfMatch = head.ETag
+	if !s.parallel(head.ContentLength) {
+		return api.GetObject(ctx, &input)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	go s.readParts(ctx, api, &input, head.ContentLength, pw)
+	return &s3.GetObjectOutput{
+		Body:          &partsBody{PipeReader: pr, cancel: cancel},
+		ContentLength: head.ContentLength,
+		ContentType:   head.ContentType,
+		ETag:          head.ETag,
+		LastModified:  head.LastModified,
+		Metadata:      head.Metadata,
+		StorageClass:  head.StorageClass,
+	}, nil
+}
+
+// part is one ranged read of a download
+type part struct {
+	data []byte
+	err  error
+}
+
+// readParts reads the size bytes of an object in PartSize ranges, up to
+// Concurrency at a time, and writes them to pw in order. The first error
+// ends the download and is returned by the reader.
+func (s *Store) readParts(ctx context.Context, api objectAPI, params *s3.GetObjectInput, size int64, pw *io.PipeWriter) {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	// Parts are queued in order. A part is only read once it is queued, so
+	// with the one being written at most Concurrency are held at a time.
+	queue := make(chan chan part, s.transfer.Concurrency-1)
+	go func() {
+		defer close(queue)
+		for start := int64(0); start < size; start += s.transfer.PartSize {
+			end := min(start+s.transfer.PartSize, size) - 1
+			result := make(chan part, 1)
+			select {
+			case queue <- result:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				data, err := readRange(ctx, api, params, start, end)
+				result <- part{data, err}
+			}()
+		}
+	}()
+
+	for result := range queue {
+		p := <-result
+		if p.err == nil {
+			_, p.err = pw.Write(p.data)
+		}
+		if p.err != nil {
+			pw.CloseWithError(p.err)
+			// Cancel the reads ahead and let the queue drain
+			stop()
+			for range queue {
+			}
+			return
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		pw.CloseWithError(err)
+		return
+	}
+	pw.Close()
+}
+
+// readRange reads bytes start to end, inclusive, of the object
+func readRange(ctx context.Context, api objectAPI, params *s3.GetObjectInput, start, end int64) ([]byte, error) {
+	input := *params
+	input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", start, end))
+	out, err := api.GetObject(ctx, &input)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != end-start+1 {
+		return nil, fmt.Errorf("read %d bytes of range %d-%d of %s", len(data), start, end, aws.ToString(params.Key))
+	}
+	return data, nil
+}
+
+// parallel reports whether an object of size bytes is transferred in parts
+func (s *Store) parallel(size int64) bool {
+	return s.transfer.Threshold > 0 && size >= s.transfer.Threshold
+}
+
+// copyToReplica copies a newly written object to the replica when dual
+// writes are on. Copying server side avoids reading the body a second time.
+// A failed copy is logged rather than returned, since the primary holds the
+// object and S3 replication or the reconcile job can catch the replica up.
+func (s *Store) copyToReplica(ctx context.Context, params *s3.PutObjectInput) {
+	if !s.dualWrite || aws.ToString(params.Bucket) != s.bucket {
+		return
+	}
+	_, err := s.replica.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:                    aws.String(s.replicaBucket),
+		Key:                       params.Key,
+		CopySource:                aws.String(copySource(s.bucket, aws.ToString(params.Key))),
+		StorageClass:              params.StorageClass,
+		ObjectLockMode:            params.ObjectLockMode,
+		ObjectLockRetainUntilDate: params.ObjectLockRetainUntilDate,
+	})
+	if err != nil {
+		log.Printf("Error copying %s to replica in %s: %v", aws.ToString(params.Key), s.replicaRegion, err)
+	}
+}
+
+// partsBody is the content of an object being downloaded in parts
+type partsBody struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+// Close stops the reads still in flight
+func (b *partsBody) Close() error {
+	b.cancel()
+	return b.PipeReader.Close()
+}
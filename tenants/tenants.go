@@ -0,0 +1,203 @@
+// Package tenants applies the per-tenant overrides stored in the
+// tenant_settings table on top of the runtime settings. Overrides are cached
+// for a short time, so the API and the worker pick up an administrator's
+// change within cacheTTL.
+package tenants
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/processor"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+// cacheTTL is how long a user's overrides are cached between database reads
+const cacheTTL = 30 * time.Second
+
+type cacheEntry struct {
+	overrides *database.TenantSettings
+	fetched   time.Time
+}
+
+var (
+	mu    sync.Mutex
+	cache = make(map[string]cacheEntry)
+)
+
+// Overrides returns a user's overrides, or nil if there are none
+func Overrides(userID string) (*database.TenantSettings, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	mu.Lock()
+	e, ok := cache[userID]
+	mu.Unlock()
+	if ok && time.Since(e.fetched) < cacheTTL {
+		return e.overrides, nil
+	}
+
+	t, err := database.GetTenantSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	cache[userID] = cacheEntry{overrides: t, fetched: time.Now()}
+	mu.Unlock()
+	return t, nil
+}
+
+// Invalidate drops a user's cached overrides after they were changed
+func Invalidate(userID string) {
+	mu.Lock()
+	delete(cache, userID)
+	mu.Unlock()
+}
+
+// Settings are the settings in effect for one user. Zero limits mean no
+// limit.
+type Settings struct {
+	MaxFileBytes int64
+	MaxFiles     int
+	MaxBytes     int64
+	// FileTypes are the extensions the user may upload; nil allows any
+	FileTypes []string
+	// FileExpiryDays is 0 when the user's files never expire
+	FileExpiryDays int
+	// Processors are the only processors enabled for the user, on top of
+	// the globally disabled ones; nil enables all of them
+	Processors []string
+	// UploadBytesPerSecond and DownloadBytesPerSecond cap the user's
+	// bandwidth across all of their requests
+	UploadBytesPerSecond   int64
+	DownloadBytesPerSecond int64
+
+	global *settings.Settings
+}
+
+// For returns the settings in effect for userID: the current runtime
+// settings with the user's overrides applied
+func For(userID string) (Settings, error) {
+	t, err := Overrides(userID)
+	if err != nil {
+		return Settings{}, err
+	}
+	return Apply(settings.Current(), userID, t), nil
+}
+
+// Apply applies a user's overrides, which may be nil, to the runtime
+// settings s
+func Apply(s *settings.Settings, userID string, t *database.TenantSettings) Settings {
+	e := Settings{
+		MaxFileBytes:           s.MaxFileBytes,
+		MaxFiles:               s.MaxFilesPerUser,
+		MaxBytes:               s.MaxUserBytes,
+		FileExpiryDays:         s.ExpiryDays(userID),
+		UploadBytesPerSecond:   s.UploadBytesPerSecond,
+		DownloadBytesPerSecond: s.DownloadBytesPerSecond,
+		global:                 s,
+	}
+	if t == nil {
+		return e
+	}
+	if t.MaxFileBytes != nil {
+		e.MaxFileBytes = *t.MaxFileBytes
+	}
+	if t.MaxFiles != nil {
+		e.MaxFiles = *t.MaxFiles
+	}
+	if t.MaxBytes != nil {
+		e.MaxBytes = *t.MaxBytes
+	}
+	if t.FileExpiryDays != nil {
+		e.FileExpiryDays = *t.FileExpiryDays
+	}
+	if t.UploadBytesPerSecond != nil {
+		e.UploadBytesPerSecond = *t.UploadBytesPerSecond
+	}
+	if t.DownloadBytesPerSecond != nil {
+		e.DownloadBytesPerSecond = *t.DownloadBytesPerSecond
+	}
+	e.FileTypes = t.AllowedFileTypes
+	e.Processors = t.EnabledProcessors
+	return e
+}
+
+// TypeAllowed reports whether the user may upload a file called name
+func (e Settings) TypeAllowed(name string) bool {
+	if e.FileTypes == nil {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, allowed := range e.FileTypes {
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessorEnabled reports whether the user's files may run through the
+// named processor
+func (e Settings) ProcessorEnabled(name string) bool {
+	if e.global != nil && !e.global.ProcessorEnabled(name) {
+		return false
+	}
+	if e.Processors == nil {
+		return true
+	}
+	for _, enabled := range e.Processors {
+		if enabled == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Pipeline returns the stages a user's files of fileType are processed
+// with: the user's pipeline for the type, then their "*" pipeline, then the
+// configured ones. Nil runs the type's default processor alone.
+func Pipeline(userID, fileType string) ([]string, error) {
+	if userID != "" {
+		stages, err := database.GetUserPipeline(userID, fileType)
+		if err != nil || stages != nil {
+			return stages, err
+		}
+	}
+	return settings.Current().Pipeline(fileType), nil
+}
+
+// RedactsPII reports whether a file called name uploaded by userID is run
+// through the pii stage, which has it wait for its redacted copy before its
+// content is served to callers who may not see personal data
+func RedactsPII(userID, name string) (bool, error) {
+	stages, err := Pipeline(userID, processor.FileType(name))
+	if err != nil {
+		return false, err
+	}
+	for _, stage := range stages {
+		if stage == "pii" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ExpiryDays returns the users whose files expire on their own schedule,
+// keyed by user ID: those with a policy in the runtime settings, replaced or
+// added to by the overrides
+func ExpiryDays(s *settings.Settings, overrides []database.TenantSettings) map[string]int {
+	days := make(map[string]int, len(s.UserFileExpiryDays)+len(overrides))
+	for userID, d := range s.UserFileExpiryDays {
+		days[userID] = d
+	}
+	for _, t := range overrides {
+		if t.FileExpiryDays != nil {
+			days[t.UserID] = *t.FileExpiryDays
+		}
+	}
+	return days
+}
@@ -0,0 +1,87 @@
+package tenants
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/settings"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	s := settings.Defaults()
+	s.MaxFileBytes = 100
+	s.MaxFilesPerUser = 10
+	s.FileExpiryDays = 30
+	s.DisabledProcessors = []string{"image"}
+
+	// Without overrides the global settings apply
+	e := Apply(&s, "u1", nil)
+	assert.Equal(t, int64(100), e.MaxFileBytes)
+	assert.Equal(t, 10, e.MaxFiles)
+	assert.Equal(t, 30, e.FileExpiryDays)
+	assert.True(t, e.TypeAllowed("a.exe"))
+	assert.True(t, e.ProcessorEnabled("text"))
+	assert.False(t, e.ProcessorEnabled("image"))
+
+	maxFileBytes, expiry := int64(0), 7
+	e = Apply(&s, "u1", &database.TenantSettings{
+		MaxFileBytes:      &maxFileBytes,
+		FileExpiryDays:    &expiry,
+		AllowedFileTypes:  []string{".csv"},
+		EnabledProcessors: []string{"csv", "image"},
+	})
+	assert.Equal(t, int64(0), e.MaxFileBytes)
+	assert.Equal(t, 10, e.MaxFiles)
+	assert.Equal(t, 7, e.FileExpiryDays)
+	assert.True(t, e.TypeAllowed("data.CSV"))
+	assert.False(t, e.TypeAllowed("notes.txt"))
+	assert.True(t, e.ProcessorEnabled("csv"))
+	assert.False(t, e.ProcessorEnabled("text"))
+	// Processors disabled globally stay disabled
+	assert.False(t, e.ProcessorEnabled("image"))
+}
+
+func TestExpiryDaysPrefersOverrides(t *testing.T) {
+	s := settings.Defaults()
+	s.UserFileExpiryDays = map[string]int{"u1": 30, "u2": 0}
+	never, week := 0, 7
+
+	days := ExpiryDays(&s, []database.TenantSettings{
+		{UserID: "u1", FileExpiryDays: &never},
+		{UserID: "u3", FileExpiryDays: &week},
+		{UserID: "u4"},
+	})
+	assert.Equal(t, map[string]int{"u1": 0, "u2": 0, "u3": 7}, days)
+}
+
+func TestApplyBandwidthOverrides(t *testing.T) {
+	s := settings.Defaults()
+	s.UploadBytesPerSecond = 1 << 20
+	s.DownloadBytesPerSecond = 2 << 20
+
+	e := Apply(&s, "u1", nil)
+	assert.Equal(t, int64(1<<20), e.UploadBytesPerSecond)
+	assert.Equal(t, int64(2<<20), e.DownloadBytesPerSecond)
+
+	// A zero override lifts the limit; a missing one keeps the global
+	unlimited := int64(0)
+	e = Apply(&s, "u1", &database.TenantSettings{UploadBytesPerSecond: &unlimited})
+	assert.Equal(t, int64(0), e.UploadBytesPerSecond)
+	assert.Equal(t, int64(2<<20), e.DownloadBytesPerSecond)
+}
+
+func TestRedactsPII(t *testing.T) {
+	s := settings.Defaults()
+	s.Pipelines = map[string][]string{".txt": {"text", "pii"}, "*": {"text"}}
+	assert.NoError(t, settings.Set(s))
+	t.Cleanup(func() { settings.Set(settings.Defaults()) })
+
+	// Files without an owner follow the configured pipelines
+	redacts, err := RedactsPII("", "notes.TXT")
+	assert.NoError(t, err)
+	assert.True(t, redacts)
+	redacts, err = RedactsPII("", "data.csv")
+	assert.NoError(t, err)
+	assert.False(t, redacts)
+}
@@ -0,0 +1,83 @@
+// tests/benchmark_test.go
+package tests
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/yourusername/golang-aws-api/database"
+)
+
+const (
+	benchFiles          = 5000
+	benchResultsPerFile = 4
+)
+
+// seedBenchmarkData inserts benchFiles files with benchResultsPerFile results
+// each and returns the file IDs
+func seedBenchmarkData(b *testing.B) []string {
+	b.Helper()
+	if err := database.InitDB(); err != nil {
+		b.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	ids := make([]string, 0, benchFiles)
+	for i := 0; i < benchFiles; i++ {
+		f, err := database.SaveFile(fmt.Sprintf("bench-%d.txt", i), fmt.Sprintf("files/bench-%d.txt", i))
+		if err != nil {
+			b.Fatalf("Failed to seed file: %v", err)
+		}
+		for j := 0; j < benchResultsPerFile; j++ {
+			if err := database.SaveProcessingResult(f.ID, "completed", "benchmark"); err != nil {
+				b.Fatalf("Failed to seed result: %v", err)
+			}
+		}
+		ids = append(ids, f.ID)
+	}
+	return ids
+}
+
+// BenchmarkLatestResultLookup compares the latest-result lookup with and
+// without the processing_results (file_id, created_at) index.
+//
+//	go test -run '^$' -bench LatestResultLookup ./tests
+func BenchmarkLatestResultLookup(b *testing.B) {
+	ids := seedBenchmarkData(b)
+
+	run := func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := database.GetProcessingResultByFileID(ids[i%len(ids)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("with_index", run)
+
+	b.Run("without_index", func(b *testing.B) {
+		_, err := database.GetDB().Exec(`DROP INDEX idx_processing_results_file_id_created_at`)
+		if err != nil {
+			b.Fatalf("Failed to drop index: %v", err)
+		}
+		defer database.GetDB().Exec(`
+			CREATE INDEX idx_processing_results_file_id_created_at
+				ON processing_results (file_id, created_at DESC)
+		`)
+		database.GetDB().Exec(`ANALYZE processing_results`)
+		b.ResetTimer()
+		run(b)
+	})
+}
+
+// BenchmarkListFiles measures the newest-first listing that
+// idx_files_created_at serves
+func BenchmarkListFiles(b *testing.B) {
+	seedBenchmarkData(b)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := database.GetAllFiles(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
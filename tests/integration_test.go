@@ -11,6 +11,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"strings"
 	"testing"
 	"time"
@@ -26,6 +27,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/yourusername/golang-aws-api/auth"
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/pagination"
+	"github.com/yourusername/golang-aws-api/storage"
 )
 
 // Global variables for tests
@@ -389,6 +394,200 @@ func TestFileUploadAndProcessing(t *testing.T) {
 	assert.Contains(t, processingResult.Result, "Processed file with")
 }
 
+func TestSaveFileAndProcessingResult(t *testing.T) {
+	// Use the application's schema and insert paths against the test database
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	f, err := database.SaveFile("saved-file.txt", "files/saved-file.txt")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, f.ID)
+
+	other, err := database.SaveFile("other-file.txt", "files/other-file.txt")
+	assert.NoError(t, err)
+	assert.NotEqual(t, f.ID, other.ID)
+
+	err = database.SaveProcessingResult(f.ID, "completed", "Processed file with 2 words and 10 characters")
+	assert.NoError(t, err)
+
+	result, err := database.GetProcessingResultByFileID(f.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, result) {
+		assert.NotEmpty(t, result.ID)
+		assert.Equal(t, "completed", result.Status)
+	}
+}
+
+func TestUpdateProcessingResultVersionConflict(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	f, err := database.SaveFile("locked-file.txt", "files/locked-file.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, database.SaveProcessingResult(f.ID, "completed", "first"))
+
+	current, err := database.GetProcessingResultByFileID(f.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, current.Version)
+
+	// The first writer wins and bumps the version
+	updated, err := database.UpdateProcessingResult(current.ID, current.Version, "completed", "second")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, updated.Version)
+
+	// A second writer holding the old version is rejected
+	_, err = database.UpdateProcessingResult(current.ID, current.Version, "failed", "third")
+	assert.ErrorIs(t, err, database.ErrConflict)
+
+	latest, err := database.GetProcessingResultByFileID(f.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", latest.Result)
+
+	// Files use the same compare-and-swap
+	_, err = database.UpdateFileName(f.ID, "renamed.txt", f.Version)
+	assert.NoError(t, err)
+	_, err = database.UpdateFileName(f.ID, "renamed-again.txt", f.Version)
+	assert.ErrorIs(t, err, database.ErrConflict)
+}
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	fileID := database.NewID()
+	err = database.WithTx(func(tx *sql.Tx) error {
+		if _, err := database.SaveFileWithIDTx(tx, fileID, "tx-file.txt", "files/tx-file.txt", "", 0); err != nil {
+			return err
+		}
+		return fmt.Errorf("abort")
+	})
+	assert.EqualError(t, err, "abort")
+
+	f, err := database.GetFileByID(fileID)
+	assert.NoError(t, err)
+	assert.Nil(t, f, "file insert should have been rolled back")
+
+	// DeleteFile removes the file and its results together
+	saved, err := database.SaveFile("delete-me.txt", "files/delete-me.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, database.SaveProcessingResult(saved.ID, "completed", "done"))
+	assert.NoError(t, database.DeleteFile(saved.ID))
+
+	result, err := database.GetProcessingResultByFileID(saved.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+func TestSearchFiles(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("search-"+suffix, "password", "search-"+suffix+"@example.com")
+	assert.NoError(t, err)
+
+	invoice, err := database.SaveFileWithID(database.NewID(), "invoice-march.txt", "files/invoice-march.txt", user.ID, 0)
+	assert.NoError(t, err)
+	notes, err := database.SaveFileWithID(database.NewID(), "notes.txt", "files/notes.txt", user.ID, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, database.SetFileSearchText(notes.ID, "Remember to pay the invoice for the office lease"))
+
+	results, err := database.SearchFiles(user.ID, "invoice", nil, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		// The name match outranks the content match
+		assert.Equal(t, invoice.ID, results[0].ID)
+		assert.Equal(t, notes.ID, results[1].ID)
+		assert.Contains(t, results[1].ContentHighlight, database.HighlightStart+"invoice"+database.HighlightStop)
+	}
+
+	// The second page continues after the first result
+	cursor := &pagination.Cursor{CreatedAt: results[0].CreatedAt, ID: results[0].ID, Rank: results[0].Rank}
+	page, err := database.SearchFiles(user.ID, "invoice", cursor, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 1) {
+		assert.Equal(t, notes.ID, page[0].ID)
+	}
+}
+
+func TestIdempotencyKeyReplay(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	key := database.NewID()
+	existing, err := database.ClaimIdempotencyKey("", key, "hash-a", time.Hour)
+	assert.NoError(t, err)
+	assert.Nil(t, existing)
+
+	// A retry before the first request finishes sees it in progress
+	existing, err = database.ClaimIdempotencyKey("", key, "hash-a", time.Hour)
+	assert.NoError(t, err)
+	if assert.NotNil(t, existing) {
+		assert.Equal(t, 0, existing.StatusCode)
+	}
+
+	assert.NoError(t, database.CompleteIdempotencyKey("", key, 201, "application/json", []byte(`{"id":"1"}`)))
+	existing, err = database.ClaimIdempotencyKey("", key, "hash-a", time.Hour)
+	assert.NoError(t, err)
+	if assert.NotNil(t, existing) {
+		assert.Equal(t, 201, existing.StatusCode)
+		assert.Equal(t, `{"id":"1"}`, string(existing.ResponseBody))
+	}
+
+	// Released keys can be claimed again
+	assert.NoError(t, database.ReleaseIdempotencyKey("", key))
+	existing, err = database.ClaimIdempotencyKey("", key, "hash-b", time.Hour)
+	assert.NoError(t, err)
+	assert.Nil(t, existing)
+}
+
+func TestCreateFileNamePolicies(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("names-"+suffix, "password", "names-"+suffix+"@example.com")
+	assert.NoError(t, err)
+
+	create := func(policy string) (*database.File, error) {
+		return database.CreateFile(database.NewFile{
+			ID:     database.NewID(),
+			Name:   "report.txt",
+			UserID: user.ID,
+			S3Key:  func(name string) string { return "files/" + name },
+		}, policy)
+	}
+
+	first, err := create(database.NamePolicyReject)
+	assert.NoError(t, err)
+	assert.Equal(t, "report.txt", first.Name)
+
+	_, err = create(database.NamePolicyReject)
+	assert.ErrorIs(t, err, database.ErrDuplicateFileName)
+
+	renamed, err := create(database.NamePolicyRename)
+	assert.NoError(t, err)
+	assert.Equal(t, "report-2.txt", renamed.Name)
+	assert.Equal(t, "files/report-2.txt", renamed.S3Key)
+
+	versioned, err := create(database.NamePolicyVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, "report.txt", versioned.Name)
+	assert.Equal(t, 2, versioned.NameRevision)
+}
+
 // Helper function to create an S3 bucket
 func createS3Bucket(ctx context.Context, client *s3.Client, bucketName string) error {
 	// First check if the bucket already exists
@@ -546,3 +745,777 @@ func getResultHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
 }
+
+func TestDomainEventsRecorded(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("events-"+suffix, "password", "events-"+suffix+"@example.com")
+	assert.NoError(t, err)
+	f, err := database.SaveFileWithID(database.NewID(), "events.txt", "files/events.txt", user.ID, 10)
+	assert.NoError(t, err)
+	_, err = database.SaveShare(database.NewID(), f.ID, user.ID, time.Now().Add(time.Hour), 0, "")
+	assert.NoError(t, err)
+	assert.NoError(t, database.DeleteFile(f.ID))
+
+	events, err := database.ListEvents(database.EventFilter{FileID: f.ID}, nil, 10)
+	assert.NoError(t, err)
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+		assert.Equal(t, user.ID, e.UserID)
+	}
+	assert.Equal(t, []string{database.EventFileUploaded, database.EventFileShared, database.EventFileDeleted}, types)
+
+	// Filtering by type
+	events, err = database.ListEvents(database.EventFilter{FileID: f.ID, Types: []string{database.EventFileDeleted}}, nil, 10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+func TestWatchCheckpoint(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	prefix := "incoming-" + database.NewID() + "/"
+	key, err := database.GetWatchCheckpoint(prefix)
+	assert.NoError(t, err)
+	assert.Empty(t, key)
+
+	assert.NoError(t, database.SaveWatchCheckpoint(prefix, prefix+"a.csv"))
+	assert.NoError(t, database.SaveWatchCheckpoint(prefix, prefix+"b.csv"))
+	key, err = database.GetWatchCheckpoint(prefix)
+	assert.NoError(t, err)
+	assert.Equal(t, prefix+"b.csv", key)
+}
+
+func TestRetentionBlocksDelete(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("retention-"+suffix, "password", "retention-"+suffix+"@example.com")
+	assert.NoError(t, err)
+
+	create := func(mode string, until time.Time) *database.File {
+		f, err := database.CreateFile(database.NewFile{
+			ID:            database.NewID(),
+			Name:          "ledger-" + database.NewID() + ".csv",
+			UserID:        user.ID,
+			RetentionMode: mode,
+			RetainUntil:   until,
+			S3Key:         func(name string) string { return "files/" + name },
+		}, database.NamePolicyReject)
+		assert.NoError(t, err)
+		return f
+	}
+
+	compliance := create(storage.RetentionCompliance, time.Now().Add(time.Hour))
+	assert.Equal(t, storage.RetentionCompliance, compliance.RetentionMode)
+	assert.NotNil(t, compliance.RetainUntil)
+	assert.ErrorIs(t, database.DeleteFile(compliance.ID), database.ErrRetentionActive)
+	assert.ErrorIs(t, database.DeleteFileBypassingRetention(compliance.ID, storage.RetentionGovernance), database.ErrRetentionActive)
+
+	governance := create(storage.RetentionGovernance, time.Now().Add(time.Hour))
+	assert.ErrorIs(t, database.DeleteFile(governance.ID), database.ErrRetentionActive)
+	assert.NoError(t, database.DeleteFileBypassingRetention(governance.ID, storage.RetentionGovernance))
+
+	expired := create(storage.RetentionCompliance, time.Now().Add(-time.Minute))
+	assert.NoError(t, database.DeleteFile(expired.ID))
+}
+
+func TestAuthDoesNotRevealAccounts(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	os.Setenv("AUTH_RESPONSE_FLOOR", "50ms")
+	defer os.Unsetenv("AUTH_RESPONSE_FLOOR")
+	ctx := context.Background()
+
+	suffix := database.NewID()
+	username, email := "enum-"+suffix, "enum-"+suffix+"@example.com"
+	user, err := auth.MockSignUp(ctx, username, "password", email)
+	assert.NoError(t, err)
+	assert.NotNil(t, user)
+	assert.NoError(t, auth.MockConfirmSignUp(ctx, username, "123456"))
+
+	// Taken usernames and emails sign up "successfully" without an account
+	user, err = auth.MockSignUp(ctx, username, "other", "enum-other-"+suffix+"@example.com")
+	assert.NoError(t, err)
+	assert.Nil(t, user)
+	user, err = auth.MockSignUp(ctx, "enum-other-"+suffix, "other", email)
+	assert.NoError(t, err)
+	assert.Nil(t, user)
+
+	// Confirming an unknown user looks like confirming a real one
+	assert.NoError(t, auth.MockConfirmSignUp(ctx, "enum-missing-"+suffix, "123456"))
+
+	// Unknown users and wrong passwords fail identically, and no faster than
+	// the response floor
+	var errs []error
+	for _, try := range []struct{ username, password string }{
+		{username, "wrong"},
+		{"enum-missing-" + suffix, "password"},
+	} {
+		start := time.Now()
+		_, err := auth.MockSignIn(ctx, try.username, try.password, nil)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+		errs = append(errs, err)
+	}
+	assert.Equal(t, errs[0], errs[1])
+	assert.ErrorIs(t, errs[0], auth.ErrInvalidLogin)
+
+	_, err = auth.MockSignIn(ctx, username, "password", nil)
+	assert.NoError(t, err)
+}
+
+func TestUsernamesAndEmailsIgnoreCase(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser(" Case-"+suffix, "password", " Case-"+suffix+"@Example.com")
+	assert.NoError(t, err)
+	if assert.NotNil(t, user) {
+		assert.Equal(t, "Case-"+suffix, user.Username)
+		assert.Equal(t, "case-"+suffix+"@example.com", user.Email)
+	}
+
+	dup, err := database.SaveUser("CASE-"+suffix, "password", "other-"+suffix+"@example.com")
+	assert.NoError(t, err)
+	assert.Nil(t, dup, "usernames differing only in case are taken")
+	dup, err = database.SaveUser("other-"+suffix, "password", "CASE-"+suffix+"@EXAMPLE.COM")
+	assert.NoError(t, err)
+	assert.Nil(t, dup, "emails differing only in case are taken")
+
+	found, err := database.GetUserByUsername("case-" + suffix)
+	assert.NoError(t, err)
+	assert.NotNil(t, found)
+	found, err = database.GetUserByEmail("Case-" + suffix + "@example.COM")
+	assert.NoError(t, err)
+	assert.NotNil(t, found)
+}
+
+func TestLinkIdentityClearsPassword(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("link-"+suffix, "password", "link-"+suffix+"@example.com")
+	assert.NoError(t, err)
+	if !assert.NotNil(t, user) {
+		return
+	}
+	assert.NoError(t, database.LinkIdentity(user.ID, "google", "sub-"+suffix, user.Email))
+
+	linked, err := database.GetUserByIdentity("google", "sub-"+suffix)
+	assert.NoError(t, err)
+	if assert.NotNil(t, linked) {
+		assert.Equal(t, user.ID, linked.ID)
+		assert.Empty(t, linked.Password, "a password set before linking can't sign in")
+	}
+}
+
+func TestAccessTokensStoredHashed(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("token-"+suffix, "password", "token-"+suffix+"@example.com")
+	assert.NoError(t, err)
+	if !assert.NotNil(t, user) {
+		return
+	}
+	token := "token-" + database.NewID()
+	assert.NoError(t, database.SaveAccessToken(token, user.ID, time.Now().Add(time.Hour), nil))
+
+	var stored int
+	err = database.GetDB().QueryRow(`SELECT COUNT(*) FROM access_tokens WHERE token_hash = $1`, token).Scan(&stored)
+	assert.NoError(t, err)
+	assert.Zero(t, stored, "the table doesn't hold the token itself")
+
+	found, _, err := database.GetUserByAccessToken(token)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, user.ID, found.ID)
+	}
+	assert.NoError(t, database.DeleteAccessToken(token))
+	found, _, err = database.GetUserByAccessToken(token)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestAccountDeletionLifecycle(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("leaving-"+suffix, "password", "leaving-"+suffix+"@example.com")
+	assert.NoError(t, err)
+
+	d, err := database.ScheduleAccountDeletion(user.ID, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	again, err := database.ScheduleAccountDeletion(user.ID, time.Now().Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, d.ID, again.ID, "a pending deletion is reused")
+
+	cancelled, err := database.CancelAccountDeletion(user.ID)
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+	open, err := database.GetOpenAccountDeletion(user.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, open)
+
+	d, err = database.ScheduleAccountDeletion(user.ID, time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	claimed, err := database.ClaimDueAccountDeletions(time.Now(), 100)
+	assert.NoError(t, err)
+	var found bool
+	for _, c := range claimed {
+		found = found || c.ID == d.ID
+	}
+	assert.True(t, found, "due deletions are claimed")
+
+	assert.NoError(t, database.AnonymizeUser(user.ID))
+	assert.NoError(t, database.CompleteAccountDeletion(d.ID, database.PurgeCounts{FilesDeleted: 2, ObjectsDeleted: 2}))
+
+	gone, err := database.GetUserByUsername("leaving-" + suffix)
+	assert.NoError(t, err)
+	assert.Nil(t, gone, "the username is released")
+	anonymized, err := database.GetUserByUsername("deleted-" + user.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, anonymized) {
+		assert.Equal(t, user.ID, anonymized.ID)
+		assert.Equal(t, user.ID+"@deleted.invalid", anonymized.Email)
+	}
+
+	report, err := database.ListAccountDeletions(database.AccountDeletionCompleted, nil, 100)
+	assert.NoError(t, err)
+	found = false
+	for _, r := range report {
+		if r.ID == d.ID {
+			found = true
+			assert.Equal(t, 2, r.FilesDeleted)
+		}
+	}
+	assert.True(t, found, "completed purges are reported")
+}
+
+func TestAccountHistoryQueries(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("dsar-"+suffix, "password", "dsar-"+suffix+"@example.com")
+	assert.NoError(t, err)
+	f, err := database.SaveFileWithID(database.NewID(), "notes.txt", "uploads/dsar-"+suffix, user.ID, 5)
+	assert.NoError(t, err)
+	assert.NoError(t, database.SaveProcessingResult(f.ID, "completed", "first"))
+	assert.NoError(t, database.SaveProcessingResult(f.ID, "completed", "second"))
+	assert.NoError(t, database.LogFileAccess(f.ID, user.ID, database.AccessDownload, database.VariantOriginal, "127.0.0.1", "test"))
+
+	found, err := database.GetUserByID(user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, user.Email, found.Email)
+
+	results, err := database.ListProcessingResultsByUser(user.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, "first", results[0].Result)
+	}
+
+	events, err := database.ListEvents(database.EventFilter{UserID: user.ID}, nil, 10)
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, events) {
+		assert.Equal(t, database.EventFileUploaded, events[0].Type)
+	}
+
+	accesses, err := database.ListFileAccessByUser(user.ID)
+	assert.NoError(t, err)
+	assert.Len(t, accesses, 1)
+}
+
+func TestExpiredFilesSkipRetention(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("expiry-"+suffix, "password", "expiry-"+suffix+"@example.com")
+	assert.NoError(t, err)
+	plain, err := database.SaveFileWithID(database.NewID(), "old.txt", "uploads/expiry-"+suffix, user.ID, 3)
+	assert.NoError(t, err)
+	locked, err := database.CreateFile(database.NewFile{
+		ID:            database.NewID(),
+		Name:          "locked.txt",
+		UserID:        user.ID,
+		RetentionMode: storage.RetentionGovernance,
+		RetainUntil:   time.Now().Add(time.Hour),
+		S3Key:         func(name string) string { return "files/" + name },
+	}, database.NamePolicyReject)
+	assert.NoError(t, err)
+
+	cutoff := time.Now().Add(time.Minute)
+	files, err := database.ListExpiredFilesByUser(user.ID, cutoff, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		assert.Equal(t, plain.ID, files[0].ID)
+	}
+
+	files, err = database.ListExpiredFiles([]string{user.ID}, cutoff, 1000)
+	assert.NoError(t, err)
+	for _, f := range files {
+		assert.NotEqual(t, plain.ID, f.ID, "excluded users' files are left to their own policy")
+		assert.NotEqual(t, locked.ID, f.ID)
+	}
+}
+
+func TestLegalHoldBlocksDelete(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("hold-"+suffix, "password", "hold-"+suffix+"@example.com")
+	assert.NoError(t, err)
+	f, err := database.SaveFileWithID(database.NewID(), "evidence.txt", "uploads/hold-"+suffix, user.ID, 8)
+	assert.NoError(t, err)
+
+	held, err := database.SetLegalHold(f.ID, true, "admin")
+	assert.NoError(t, err)
+	assert.True(t, held.LegalHold)
+	assert.ErrorIs(t, database.DeleteFile(f.ID), database.ErrLegalHold)
+	assert.ErrorIs(t, database.DeleteFileBypassingRetention(f.ID, storage.RetentionGovernance), database.ErrLegalHold)
+
+	expired, err := database.ListExpiredFilesByUser(user.ID, time.Now().Add(time.Minute), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, expired, "held files never expire")
+
+	released, err := database.SetLegalHold(f.ID, false, "admin")
+	assert.NoError(t, err)
+	assert.False(t, released.LegalHold)
+	assert.NoError(t, database.DeleteFile(f.ID))
+
+	missing, err := database.SetLegalHold(database.NewID(), true, "admin")
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestProcessedMessageSavesOneResult(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	f, err := database.SaveFile("delivered-twice.txt", "files/delivered-twice.txt")
+	assert.NoError(t, err)
+	messageKey := database.NewID()
+
+	save := func() error {
+		return database.WithTx(func(tx *sql.Tx) error {
+			if err := database.MarkMessageProcessedTx(tx, messageKey, f.ID); err != nil {
+				return err
+			}
+			return database.SaveProcessingResultTx(tx, f.ID, "completed", "done")
+		})
+	}
+	assert.NoError(t, save())
+	assert.ErrorIs(t, save(), database.ErrAlreadyProcessed)
+
+	processed, err := database.IsMessageProcessed(messageKey, f.ID)
+	assert.NoError(t, err)
+	assert.True(t, processed)
+
+	results, err := database.ListProcessingResultsByFileID(f.ID, nil, 10)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+}
+
+func TestUserPipelineFallsBackToDefault(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("pipeline-"+suffix, "password", "pipeline-"+suffix+"@example.com")
+	assert.NoError(t, err)
+
+	stages, err := database.GetUserPipeline(user.ID, ".csv")
+	assert.NoError(t, err)
+	assert.Nil(t, stages)
+
+	_, err = database.SaveUserPipeline(user.ID, "*", []string{"text"})
+	assert.NoError(t, err)
+	_, err = database.SaveUserPipeline(user.ID, ".csv", []string{"text", "text"})
+	assert.NoError(t, err)
+
+	stages, err = database.GetUserPipeline(user.ID, ".csv")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"text", "text"}, stages)
+	stages, err = database.GetUserPipeline(user.ID, ".md")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"text"}, stages)
+
+	deleted, err := database.DeleteUserPipeline(user.ID, ".csv")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	pipelines, err := database.ListUserPipelines(user.ID)
+	assert.NoError(t, err)
+	assert.Len(t, pipelines, 1)
+}
+
+func TestCustomResultIsKeptBesideLatestResult(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	f, err := database.SaveFile("custom.txt", "files/custom.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, database.SaveProcessingResult(f.ID, "completed", "built-in"))
+	err = database.WithTx(func(tx *sql.Tx) error {
+		return database.SaveCustomResultTx(tx, f.ID, "completed", "custom")
+	})
+	assert.NoError(t, err)
+
+	latest, err := database.GetProcessingResultByFileID(f.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "built-in", latest.Result)
+	assert.Equal(t, database.ResultBuiltin, latest.Source)
+
+	results, err := database.ListProcessingResultsByFileID(f.ID, nil, 10)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+}
+
+func TestTextStatsSummary(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("stats-"+suffix, "password", "stats-"+suffix+"@example.com")
+	assert.NoError(t, err)
+	ease := 80.0
+	for i, language := range []string{"en", "en", "de"} {
+		f, err := database.SaveFileWithID(database.NewID(), fmt.Sprintf("stats-%d.txt", i), fmt.Sprintf("files/stats-%s-%d.txt", suffix, i), user.ID, 50)
+		assert.NoError(t, err)
+		err = database.SaveTextStats(database.TextStats{
+			FileID:            f.ID,
+			Language:          language,
+			Words:             10,
+			Sentences:         2,
+			Characters:        50,
+			FleschReadingEase: &ease,
+			TopTerms:          json.RawMessage(`[{"term": "invoice", "count": 3}, {"term": "total", "count": 1}]`),
+		})
+		assert.NoError(t, err)
+	}
+
+	summary, err := database.GetTextStatsSummary(user.ID, "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, summary.Files)
+	assert.Equal(t, int64(30), summary.Words)
+	assert.Equal(t, map[string]int{"en": 2, "de": 1}, summary.Languages)
+	assert.Equal(t, database.TermCount{Term: "invoice", Count: 9}, summary.TopTerms[0])
+
+	summary, err = database.GetTextStatsSummary(user.ID, "de", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Files)
+}
+
+func TestArchiveMembersOutliveArchive(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	suffix := database.NewID()
+	user, err := database.SaveUser("archive-"+suffix, "password", "archive-"+suffix+"@example.com")
+	assert.NoError(t, err)
+	archiveID := database.NewID()
+	archive, err := database.CreateFile(database.NewFile{
+		ID:            archiveID,
+		Name:          "bundle.zip",
+		UserID:        user.ID,
+		S3Key:         func(name string) string { return "files/" + archiveID + "/" + name },
+		ExpandArchive: true,
+	}, database.NamePolicyReject)
+	assert.NoError(t, err)
+	assert.True(t, archive.ExpandArchive)
+
+	// Two members with the same base name don't clash
+	for _, member := range []string{"a/report.txt", "b/report.txt"} {
+		memberID := database.NewID()
+		_, err := database.CreateFile(database.NewFile{
+			ID:       memberID,
+			Name:     path.Base(member),
+			UserID:   user.ID,
+			ParentID: archive.ID,
+			S3Key:    func(string) string { return "files/" + archiveID + "/members/" + memberID + "/" + member },
+		}, database.NamePolicyRename)
+		assert.NoError(t, err)
+	}
+
+	children, err := database.ListChildFiles(archive.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, children, 2) {
+		assert.Equal(t, archive.ID, children[0].ParentID)
+		assert.NotEqual(t, children[0].Name, children[1].Name)
+	}
+
+	assert.NoError(t, database.DeleteFile(archive.ID))
+	child, err := database.GetFileByID(children[0].ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, child) {
+		assert.Empty(t, child.ParentID)
+	}
+}
+
+func TestChunkVerificationIsRecorded(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	f, err := database.SaveFileWithID(database.NewID(), "big.bin", "files/chunks-"+database.NewID()+"/big.bin", "", 12)
+	assert.NoError(t, err)
+	err = database.SaveFileChunks(f.ID, []database.Chunk{
+		{Number: 1, Offset: 0, Size: 5, SHA256: "aa"},
+		{Number: 2, Offset: 5, Size: 5, SHA256: "bb"},
+		{Number: 3, Offset: 10, Size: 2, SHA256: "cc"},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, database.RecordChunkVerification(f.ID, []int{1, 2}, []int{2}))
+	chunks, err := database.ListFileChunks(f.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, chunks, 3) {
+		assert.False(t, chunks[0].Corrupt)
+		assert.NotNil(t, chunks[0].VerifiedAt)
+		assert.True(t, chunks[1].Corrupt)
+		assert.Nil(t, chunks[2].VerifiedAt)
+	}
+
+	// A repaired chunk is clear again
+	assert.NoError(t, database.RecordChunkVerification(f.ID, []int{2}, nil))
+	chunks, err = database.ListFileChunks(f.ID)
+	assert.NoError(t, err)
+	assert.False(t, chunks[1].Corrupt)
+}
+
+func TestFileStatusesReadLatestResult(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	done, err := database.SaveFileWithID(database.NewID(), "done.txt", "files/status-"+database.NewID()+"/done.txt", "", 5)
+	assert.NoError(t, err)
+	pending, err := database.SaveFileWithID(database.NewID(), "pending.txt", "files/status-"+database.NewID()+"/pending.txt", "", 5)
+	assert.NoError(t, err)
+	assert.NoError(t, database.SaveProcessingResult(done.ID, "completed", strings.Repeat("x", database.ResultSummaryLength+10)))
+
+	statuses, err := database.GetFileStatuses([]string{done.ID, pending.ID, "missing"})
+	assert.NoError(t, err)
+	assert.Len(t, statuses, 2)
+	if assert.NotNil(t, statuses[done.ID].Result) {
+		assert.Equal(t, "completed", statuses[done.ID].Result.Status)
+		assert.Len(t, statuses[done.ID].Result.Result, database.ResultSummaryLength)
+		assert.True(t, statuses[done.ID].Truncated)
+	}
+	assert.Nil(t, statuses[pending.ID].Result)
+}
+
+func TestUploadTokenIsSingleUse(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	token := "upload-" + database.NewID()
+	created, err := database.SaveUploadToken(token, database.AnonymousUserID, "a.csv", 5, "text/csv", time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+
+	claimed, err := database.ClaimUploadToken(token)
+	assert.NoError(t, err)
+	if assert.NotNil(t, claimed) {
+		assert.Equal(t, created.ID, claimed.ID)
+	}
+	claimed, err = database.ClaimUploadToken(token)
+	assert.NoError(t, err)
+	assert.Nil(t, claimed)
+
+	// A failed upload gives the token back
+	assert.NoError(t, database.ReleaseUploadToken(created.ID))
+	claimed, err = database.ClaimUploadToken(token)
+	assert.NoError(t, err)
+	assert.NotNil(t, claimed)
+
+	expired := "upload-" + database.NewID()
+	_, err = database.SaveUploadToken(expired, database.AnonymousUserID, "a.csv", 5, "text/csv", time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	claimed, err = database.ClaimUploadToken(expired)
+	assert.NoError(t, err)
+	assert.Nil(t, claimed)
+}
+
+func TestUsageIsMeteredForFileOwner(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	name := "meter-" + database.NewID()
+	user, err := database.SaveUser(name, "password", name+"@example.com")
+	assert.NoError(t, err)
+	f, err := database.SaveFileWithID(database.NewID(), "a.txt", "files/"+name+"/a.txt", user.ID, 42)
+	assert.NoError(t, err)
+	assert.NoError(t, database.RecordUsage("", database.MeterDownloads, 1, f.ID))
+	assert.NoError(t, database.RecordUsage("", database.MeterDownloads, 1, f.ID))
+
+	// Usage of files without an owner isn't recorded
+	orphan, err := database.SaveFileWithID(database.NewID(), "b.txt", "files/"+name+"/b.txt", "", 5)
+	assert.NoError(t, err)
+	assert.NoError(t, database.RecordUsage("", database.MeterDownloads, 1, orphan.ID))
+
+	days, err := database.GetUsageByDay(user.ID, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	totals := make(map[string]int64)
+	for _, d := range days {
+		totals[d.Metric] += d.Quantity
+	}
+	assert.Equal(t, map[string]int64{database.MeterBytesUploaded: 42, database.MeterDownloads: 2}, totals)
+}
+
+func TestTenantSettingsKeepUnsetFields(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	name := "tenant-" + database.NewID()
+	user, err := database.SaveUser(name, "password", name+"@example.com")
+	assert.NoError(t, err)
+
+	maxFiles := 3
+	saved, err := database.SaveTenantSettings(database.TenantSettings{
+		UserID:           user.ID,
+		MaxFiles:         &maxFiles,
+		AllowedFileTypes: []string{".csv"},
+		UpdatedBy:        "admin",
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, saved.MaxFileBytes)
+	assert.Nil(t, saved.EnabledProcessors)
+
+	got, err := database.GetTenantSettings(user.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, got) {
+		assert.Equal(t, 3, *got.MaxFiles)
+		assert.Equal(t, []string{".csv"}, got.AllowedFileTypes)
+		assert.Nil(t, got.FileExpiryDays)
+	}
+
+	deleted, err := database.DeleteTenantSettings(user.ID)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	got, err = database.GetTenantSettings(user.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestIPDenyListAndKeyAllowedRanges(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	cidr := fmt.Sprintf("192.0.2.%d/32", time.Now().UnixNano()%256)
+	e, err := database.AddIPDenyEntry(cidr, "abuse", "admin")
+	assert.NoError(t, err)
+	again, err := database.AddIPDenyEntry(cidr, "still abuse", "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, e.ID, again.ID)
+	assert.Equal(t, "still abuse", again.Reason)
+
+	deleted, err := database.DeleteIPDenyEntry(e.ID)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	deleted, err = database.DeleteIPDenyEntry(e.ID)
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+
+	name := "ipkey-" + database.NewID()
+	user, err := database.SaveUser(name, "password", name+"@example.com")
+	assert.NoError(t, err)
+	k, err := database.SaveAPIKey(user.ID, "ci", "ak_"+name[:8], "hash-"+name, nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, k.AllowedCIDRs)
+
+	k, err = database.SetAPIKeyAllowedCIDRs(k.ID, []string{"203.0.113.0/24"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"203.0.113.0/24"}, k.AllowedCIDRs)
+	_, got, err := database.GetUserByAPIKey("hash-" + name)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"203.0.113.0/24"}, got.AllowedCIDRs)
+
+	missing, err := database.SetAPIKeyAllowedCIDRs(database.NewID(), nil)
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestIntegrationSecretRotationAndReplay(t *testing.T) {
+	err := database.InitDB()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	name := "integration-" + database.NewID()
+	user, err := database.SaveUser(name, "password", name+"@example.com")
+	assert.NoError(t, err)
+	in, err := database.SaveIntegration("partner", user.ID, "first", "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first"}, in.Secrets(time.Now()))
+
+	in, err = database.RotateIntegrationSecret(in.ID, "second", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"second", "first"}, in.Secrets(time.Now()))
+	assert.Equal(t, []string{"second"}, in.Secrets(time.Now().Add(2*time.Hour)))
+
+	fresh, err := database.RecordCallbackSignature(in.ID, "sha256=abc")
+	assert.NoError(t, err)
+	assert.True(t, fresh)
+	fresh, err = database.RecordCallbackSignature(in.ID, "sha256=abc")
+	assert.NoError(t, err)
+	assert.False(t, fresh)
+
+	deleted, err := database.DeleteIntegration(in.ID)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	got, err := database.GetIntegration(in.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
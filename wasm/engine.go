@@ -0,0 +1,98 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// module is a compiled plugin in its own runtime, which carries the memory
+// limit. Every call gets a fresh instance, so calls share no state.
+type module struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+// compile compiles a plugin and checks its imports and exports
+func compile(ctx context.Context, wasm []byte, memoryLimitPages uint32) (*module, error) {
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(memoryLimitPages).
+		WithCloseOnContextDone(true))
+	compiled, err := r.CompileModule(ctx, wasm)
+	if err == nil {
+		err = checkInterface(compiled)
+	}
+	if err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	return &module{runtime: r, compiled: compiled}, nil
+}
+
+// checkInterface checks that a module imports nothing and exports the plugin
+// interface described in the package documentation
+func checkInterface(c wazero.CompiledModule) error {
+	if imports := c.ImportedFunctions(); len(imports) > 0 {
+		moduleName, name, _ := imports[0].Import()
+		return fmt.Errorf("module imports %s.%s, but plugins may not import functions", moduleName, name)
+	}
+	if _, ok := c.ExportedMemories()["memory"]; !ok {
+		return errors.New(`module does not export "memory"`)
+	}
+	exports := c.ExportedFunctions()
+	for _, fn := range []struct {
+		name            string
+		params, results []api.ValueType
+	}{
+		{"alloc", []api.ValueType{api.ValueTypeI32}, []api.ValueType{api.ValueTypeI32}},
+		{"process", []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}, []api.ValueType{api.ValueTypeI64}},
+	} {
+		def, ok := exports[fn.name]
+		if !ok || !bytes.Equal(def.ParamTypes(), fn.params) || !bytes.Equal(def.ResultTypes(), fn.results) {
+			return fmt.Errorf("module does not export %s with the expected signature", fn.name)
+		}
+	}
+	return nil
+}
+
+// call runs process on input in a new instance
+func (m *module) call(ctx context.Context, input []byte) ([]byte, error) {
+	inst, err := m.runtime.InstantiateModule(ctx, m.compiled, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return nil, err
+	}
+	defer inst.Close(ctx)
+
+	res, err := inst.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("alloc: %v", err)
+	}
+	ptr := uint32(res[0])
+	if !inst.Memory().Write(ptr, input) {
+		return nil, errors.New("alloc returned a buffer outside memory")
+	}
+
+	res, err = inst.ExportedFunction("process").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("process: %v", err)
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	if outLen > maxOutputBytes {
+		return nil, fmt.Errorf("output is larger than %d bytes", maxOutputBytes)
+	}
+	out, ok := inst.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, errors.New("output is outside memory")
+	}
+	// out is a view of the instance's memory, which is freed on Close
+	return bytes.Clone(out), nil
+}
+
+// close releases the compiled module and aborts calls still running on it
+func (m *module) close(ctx context.Context) error {
+	return m.runtime.Close(ctx)
+}
@@ -0,0 +1,204 @@
+// Package wasm runs WebAssembly modules as processors, so new processing logic
+// can be shipped by uploading a plugin instead of redeploying the worker.
+//
+// A plugin module exports its linear memory as "memory" and two functions:
+//
+//	alloc(size i32) i32            returns a buffer of size bytes
+//	process(ptr i32, len i32) i64  processes the file content written there
+//
+// process returns where its output is as (ptr << 32) | len, and the output
+// must be JSON. Modules may not import any functions, so they can't reach the
+// file system, the network or the clock. Each call runs in a fresh instance
+// whose memory is capped by WASM_MEMORY_LIMIT_MB, and a call still running
+// when the processor timeout expires is aborted.
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yourusername/golang-aws-api/database"
+	"github.com/yourusername/golang-aws-api/processor"
+)
+
+const (
+	// Prefix starts the processor name of every plugin, e.g. "wasm:invoices"
+	Prefix = "wasm:"
+	// MaxModuleBytes caps the size of an uploaded module
+	MaxModuleBytes = 16 << 20
+	// maxOutputBytes caps the output of one call
+	maxOutputBytes = 1 << 20
+	// pageSize is the size of a WebAssembly memory page
+	pageSize = 64 << 10
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ValidName reports whether name may be used for a plugin: lower-case letters,
+// digits, "_" and "-", starting with a letter or digit
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// MemoryLimitPages returns the most memory a plugin instance may grow to, from
+// WASM_MEMORY_LIMIT_MB (default 64), in pages
+func MemoryLimitPages() uint32 {
+	limitMB := 64
+	if v := os.Getenv("WASM_MEMORY_LIMIT_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 4096 {
+			log.Printf("Invalid WASM_MEMORY_LIMIT_MB %q, using %d", v, limitMB)
+		} else {
+			limitMB = n
+		}
+	}
+	return uint32(limitMB << 20 / pageSize)
+}
+
+// RefreshInterval returns how often Watch looks for changed plugins, from
+// WASM_REFRESH_INTERVAL (default 1m)
+func RefreshInterval() time.Duration {
+	interval := time.Minute
+	if v := os.Getenv("WASM_REFRESH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("Invalid WASM_REFRESH_INTERVAL %q, using %s", v, interval)
+		} else {
+			interval = d
+		}
+	}
+	return interval
+}
+
+// runner calls a compiled module
+type runner interface {
+	call(ctx context.Context, input []byte) ([]byte, error)
+	close(ctx context.Context) error
+}
+
+// Processor runs a plugin on a file's content
+type Processor struct {
+	name string
+	sum  string
+	mod  runner
+}
+
+// Name returns the plugin's name with Prefix
+func (p *Processor) Name() string {
+	return Prefix + p.name
+}
+
+// Process passes the file content to the plugin and returns its JSON output
+func (p *Processor) Process(ctx context.Context, name string, content []byte) (string, error) {
+	out, err := p.mod.call(ctx, content)
+	if err != nil {
+		return "", fmt.Errorf("plugin %s: %v", p.name, err)
+	}
+	if !json.Valid(out) {
+		return "", fmt.Errorf("plugin %s: output is not JSON", p.name)
+	}
+	return string(out), nil
+}
+
+// Validate checks that module compiles and implements the plugin interface
+func Validate(ctx context.Context, module []byte) error {
+	if len(module) > MaxModuleBytes {
+		return errors.New("module is too large")
+	}
+	mod, err := compile(ctx, module, MemoryLimitPages())
+	if err != nil {
+		return err
+	}
+	return mod.close(ctx)
+}
+
+var (
+	// loadedMu serialises refreshes
+	loadedMu sync.Mutex
+	// loaded are the plugins registered as processors, by plugin name
+	loaded = map[string]*Processor{}
+)
+
+// Refresh registers every stored plugin as a processor, compiling those added
+// or changed since the last refresh, and unregisters deleted ones. A plugin
+// that doesn't compile is logged and left out. A call still running on a
+// replaced or deleted plugin fails, and is retried like any other failure.
+func Refresh(ctx context.Context) error {
+	plugins, err := database.ListWasmPlugins()
+	if err != nil {
+		return err
+	}
+
+	loadedMu.Lock()
+	defer loadedMu.Unlock()
+	stored := make(map[string]bool, len(plugins))
+	for _, p := range plugins {
+		stored[p.Name] = true
+		if cur := loaded[p.Name]; cur != nil && cur.sum == p.SHA256 {
+			continue
+		}
+		module, sum, err := database.GetWasmPluginModule(p.Name)
+		if err != nil {
+			return err
+		}
+		if module == nil {
+			// Deleted since it was listed
+			continue
+		}
+		mod, err := compile(ctx, module, MemoryLimitPages())
+		if err != nil {
+			log.Printf("Error loading plugin %s: %v", p.Name, err)
+			continue
+		}
+
+		proc := &Processor{name: p.Name, sum: sum, mod: mod}
+		processor.Register(proc)
+		if old := loaded[p.Name]; old != nil {
+			old.mod.close(ctx)
+		}
+		loaded[p.Name] = proc
+		log.Printf("Loaded plugin %s (sha256 %.12s)", p.Name, sum)
+	}
+
+	for name, proc := range loaded {
+		if stored[name] {
+			continue
+		}
+		processor.Unregister(proc.Name())
+		proc.mod.close(ctx)
+		delete(loaded, name)
+		log.Printf("Unloaded plugin %s", name)
+	}
+	return nil
+}
+
+// Watch loads the stored plugins and then refreshes them every interval until
+// ctx is done. The first load happens before Watch returns, so settings that
+// name plugins can be validated after it.
+func Watch(ctx context.Context, interval time.Duration) {
+	if err := Refresh(ctx); err != nil {
+		log.Printf("Error loading plugins: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := Refresh(ctx); err != nil {
+					log.Printf("Error refreshing plugins: %v", err)
+				}
+			}
+		}
+	}()
+}
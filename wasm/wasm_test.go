@@ -0,0 +1,52 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRunner returns fixed output for every call
+type fakeRunner struct {
+	out []byte
+	err error
+}
+
+func (f fakeRunner) call(ctx context.Context, input []byte) ([]byte, error) { return f.out, f.err }
+func (f fakeRunner) close(ctx context.Context) error                        { return nil }
+
+func TestProcessorRequiresJSON(t *testing.T) {
+	p := &Processor{name: "counter", mod: fakeRunner{out: []byte(`{"lines": 3}`)}}
+	assert.Equal(t, "wasm:counter", p.Name())
+	result, err := p.Process(context.Background(), "a.txt", []byte("a\nb\nc"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"lines": 3}`, result)
+
+	p.mod = fakeRunner{out: []byte("3 lines")}
+	_, err = p.Process(context.Background(), "a.txt", nil)
+	assert.EqualError(t, err, "plugin counter: output is not JSON")
+
+	p.mod = fakeRunner{err: errors.New("unreachable")}
+	_, err = p.Process(context.Background(), "a.txt", nil)
+	assert.EqualError(t, err, "plugin counter: unreachable")
+}
+
+func TestValidName(t *testing.T) {
+	assert.True(t, ValidName("invoices"))
+	assert.True(t, ValidName("csv_2-rows"))
+	assert.False(t, ValidName(""))
+	assert.False(t, ValidName("-invoices"))
+	assert.False(t, ValidName("Invoices"))
+	assert.False(t, ValidName("a/b"))
+}
+
+func TestMemoryLimitPages(t *testing.T) {
+	t.Setenv("WASM_MEMORY_LIMIT_MB", "")
+	assert.Equal(t, uint32(1024), MemoryLimitPages())
+	t.Setenv("WASM_MEMORY_LIMIT_MB", "16")
+	assert.Equal(t, uint32(256), MemoryLimitPages())
+	t.Setenv("WASM_MEMORY_LIMIT_MB", "lots")
+	assert.Equal(t, uint32(1024), MemoryLimitPages())
+}